@@ -0,0 +1,276 @@
+// devserver runs profile-server against fake Keycloak/Discord APIs and static Stripe prices.
+// It allows the UI to be developed locally without production credentials:
+//
+//	go run ./cmd/devserver
+//
+// Any of the usual env vars can still be set to override the defaults (e.g. EVENT_PSQL_ADDR to use a local Postgres).
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+
+	"github.com/TheLab-ms/profile/internal/access"
+	"github.com/TheLab-ms/profile/internal/app"
+	"github.com/TheLab-ms/profile/internal/conf"
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/email"
+	"github.com/TheLab-ms/profile/internal/events"
+	"github.com/TheLab-ms/profile/internal/flags"
+	"github.com/TheLab-ms/profile/internal/keycloak/keycloaktest"
+	"github.com/TheLab-ms/profile/internal/notify"
+	"github.com/TheLab-ms/profile/internal/payment"
+	"github.com/TheLab-ms/profile/internal/paypal"
+	"github.com/TheLab-ms/profile/internal/reporting"
+	"github.com/TheLab-ms/profile/internal/server"
+	"github.com/TheLab-ms/profile/internal/waitlist"
+)
+
+const (
+	fakeAPIAddr   = "localhost:8090"
+	membersGroup  = "fake-members-group"
+	devUserID     = "dev-user"
+	devUserEmail  = "dev@example.com"
+	fakeGuildID   = "fake-guild"
+	fakeBotToken  = "fake-bot-token"
+	devServerAddr = "127.0.0.1:8080" // loopback only, since every request is treated as leadership
+)
+
+func main() {
+	setDefaultEnv("KEYCLOAK_URL", "http://"+fakeAPIAddr)
+	setDefaultEnv("KEYCLOAK_MEMBERS_GROUP_ID", membersGroup)
+	setDefaultEnv("KEYCLOAK_CLIENT_ID", "fake-client")
+	setDefaultEnv("KEYCLOAK_CLIENT_SECRET", "fake-secret")
+	setDefaultEnv("SELF_URL", "http://"+devServerAddr)
+	setDefaultEnv("DISCORD_GUILD_ID", fakeGuildID)
+	setDefaultEnv("DISCORD_BOT_TOKEN", fakeBotToken)
+	setDefaultEnv("ACCESS_CONTROLLER_TOKEN", "dev-access-token")
+
+	a, err := app.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	env, kc := a.Env, a.Keycloak
+	seedReporting()
+
+	// Fake APIs
+	kcFake := keycloaktest.NewFake(membersGroup)
+	seedUsers(kcFake)
+	mux := http.NewServeMux()
+	mux.Handle("/api/v10/", newFakeDiscordEventsHandler())
+	mux.Handle("/", kcFake)
+	lis, err := net.Listen("tcp", fakeAPIAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		log.Fatal(http.Serve(lis, mux))
+	}()
+
+	ctx := context.Background()
+
+	featureFlags := flags.New(reporting.DefaultSink)
+	go featureFlags.Run(ctx)
+
+	eventsCache := events.NewCache(env)
+	eventsCache.BaseURL = "http://" + fakeAPIAddr
+	go eventsCache.Run(ctx)
+
+	accessCache := access.NewCache(kc, env.AccessCacheInterval)
+	accessCache.RequireWaiver = env.AccessWaiverPolicy == conf.WaiverPolicyDeny
+	go accessCache.Run(ctx)
+
+	sender := email.NewSender(env)
+	svr := &server.Server{
+		Env:         env,
+		Keycloak:    kc,
+		Paypal:      paypal.NewClient(env),
+		Stripe:      payment.NewStripeClient(),
+		PriceCache:  payment.NewStaticPriceCache(samplePrices(), []string{"educator", "military"}),
+		Balances:    payment.NewStaticBalanceCache(map[string]int64{"cus_fake": -1250}),
+		EventsCache: eventsCache,
+		Email:       sender,
+		Flags:       featureFlags,
+		Access:      accessCache,
+		Waitlist:    waitlist.NewStaticGate(reporting.DefaultSink, env.MemberCap, 3), // set MEMBER_CAP=3 to see the waitlist
+		Notify:      notify.New(nil, sender),
+	}
+
+	log.Printf("dev server listening on %s - logged in as %s (leadership)", env.SelfURL, devUserEmail)
+	log.Fatal(http.ListenAndServe(devServerAddr, withFakeProxyHeaders(svr.NewHandler())))
+}
+
+// withFakeProxyHeaders sets the headers oauth2proxy would normally set for a logged in leadership member.
+func withFakeProxyHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Forwarded-Preferred-Username") == "" {
+			r.Header.Set("X-Forwarded-Preferred-Username", devUserID)
+			r.Header.Set("X-Forwarded-Email", devUserEmail)
+			r.Header.Set("X-Forwarded-Groups", "leadership")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func setDefaultEnv(key, val string) {
+	if os.Getenv(key) == "" {
+		os.Setenv(key, val)
+	}
+}
+
+func seedUsers(kc *keycloaktest.Fake) {
+	now := time.Now()
+	unix := func(t time.Time) []string { return []string{strconv.FormatInt(t.Unix(), 10)} }
+
+	kc.AddUser(&gocloak.User{
+		ID:            gocloak.StringP(devUserID),
+		Username:      gocloak.StringP(devUserEmail),
+		Email:         gocloak.StringP(devUserEmail),
+		EmailVerified: gocloak.BoolP(true),
+		FirstName:     gocloak.StringP("Dev"),
+		LastName:      gocloak.StringP("User"),
+		Attributes: &map[string][]string{
+			"keyfobID":               {"1001"},
+			"waiverState":            {"Signed"},
+			"buildingAccessApprover": {"leadership"},
+			"stripeID":               {"cus_fake"},
+			"stripeSubscriptionID":   {"sub_fake"},
+			"signupEpochTimeUTC":     unix(now.Add(-time.Hour * 24 * 90)),
+			"lastSwipeTime":          unix(now.Add(-time.Hour * 24 * 2)),
+		},
+	}, true)
+
+	paypal, _ := json.Marshal(&datamodel.PaypalMetadata{Price: 40, TimeRFC3339: now.Add(-time.Hour * 24 * 10), TransactionID: "I-FAKE"})
+	kc.AddUser(&gocloak.User{
+		ID:            gocloak.StringP("paypal-user"),
+		Username:      gocloak.StringP("paypal@example.com"),
+		Email:         gocloak.StringP("paypal@example.com"),
+		EmailVerified: gocloak.BoolP(true),
+		FirstName:     gocloak.StringP("Paypal"),
+		LastName:      gocloak.StringP("Member"),
+		Attributes: &map[string][]string{
+			"keyfobID":                {"1002"},
+			"waiverState":             {"Signed"},
+			"discountType":            {"educator"},
+			"buildingAccessApprover":  {"leadership"},
+			"paypalMigrationMetadata": {string(paypal)},
+			"signupEpochTimeUTC":      unix(now.Add(-time.Hour * 24 * 700)),
+			"lastSwipeTime":           unix(now.Add(-time.Hour * 24 * 200)),
+		},
+	}, true)
+
+	kc.AddUser(&gocloak.User{
+		ID:            gocloak.StringP("lifetime-user"),
+		Username:      gocloak.StringP("lifetime@example.com"),
+		Email:         gocloak.StringP("lifetime@example.com"),
+		EmailVerified: gocloak.BoolP(true),
+		FirstName:     gocloak.StringP("Lifetime"),
+		LastName:      gocloak.StringP("Member"),
+		Attributes: &map[string][]string{
+			"keyfobID":               {"1003"},
+			"waiverState":            {"Signed"},
+			"nonBillable":            {"true"},
+			"buildingAccessApprover": {"leadership"},
+			"signupEpochTimeUTC":     unix(now.Add(-time.Hour * 24 * 2000)),
+		},
+	}, true)
+
+	kc.AddUser(&gocloak.User{
+		ID:            gocloak.StringP("new-user"),
+		Username:      gocloak.StringP("new@example.com"),
+		Email:         gocloak.StringP("new@example.com"),
+		EmailVerified: gocloak.BoolP(false),
+		Attributes: &map[string][]string{
+			"signupEpochTimeUTC": unix(now.Add(-time.Hour)),
+		},
+	}, false)
+}
+
+func samplePrices() []*datamodel.PriceDetails {
+	return []*datamodel.PriceDetails{
+		{
+			ID:               "price_fake_monthly",
+			ProductID:        "prod_fake",
+			Product:          datamodel.ProductMembership,
+			ProductName:      "Membership",
+			Price:            50,
+			CouponIDs:        map[string]string{"educator": "coupon_fake_educator_monthly", "military": "coupon_fake_military_monthly"},
+			CouponAmountsOff: map[string]int64{"educator": 1000, "military": 1500},
+		},
+		{
+			ID:               "price_fake_yearly",
+			ProductID:        "prod_fake",
+			Product:          datamodel.ProductMembership,
+			ProductName:      "Membership",
+			Annual:           true,
+			Price:            500,
+			CouponIDs:        map[string]string{"educator": "coupon_fake_educator_yearly"},
+			CouponAmountsOff: map[string]int64{"educator": 10000},
+		},
+		{
+			ID:          "price_fake_storage_monthly",
+			ProductID:   "prod_fake_storage",
+			Product:     "storage",
+			ProductName: "Storage Shelf",
+			Price:       10,
+		},
+	}
+}
+
+// newFakeDiscordEventsHandler serves a one-off and a recurring event in the shape of Discord's scheduled events API.
+func newFakeDiscordEventsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != fmt.Sprintf("/api/v10/guilds/%s/scheduled-events", fakeGuildID) {
+			http.NotFound(w, r)
+			return
+		}
+
+		tomorrow := time.Now().Truncate(time.Hour).Add(time.Hour * 24)
+		weekly := tomorrow.Add(time.Hour * 3)
+		writeJSON(w, []map[string]any{
+			{
+				"id":                   "1",
+				"name":                 "Intro to the Laser Cutter",
+				"description":          "Learn to use the laser cutter safely.",
+				"scheduled_start_time": tomorrow,
+				"scheduled_end_time":   tomorrow.Add(time.Hour * 2),
+			},
+			{
+				"id":                   "2",
+				"name":                 "Open Build Night (Member Event)",
+				"description":          "Bring a project!",
+				"scheduled_start_time": weekly,
+				"scheduled_end_time":   weekly.Add(time.Hour * 3),
+				"recurrence_rule": map[string]any{
+					"start":      weekly,
+					"frequency":  2, // weekly
+					"interval":   1,
+					"by_weekday": []int{(int(weekly.Weekday()) + 6) % 7}, // discord weeks start on monday
+				},
+			},
+		})
+	})
+}
+
+func seedReporting() {
+	if !reporting.DefaultSink.Enabled() {
+		return
+	}
+	reporting.DefaultSink.Eventf(devUserEmail, "Signup", "user created an account")
+	reporting.DefaultSink.Eventf(devUserEmail, "SignedWaiver", "user signed waiver")
+	reporting.DefaultSink.Eventf("new@example.com", "Signup", "user created an account")
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,5 @@
+package main
+
+import "github.com/TheLab-ms/profile/internal/jobs"
+
+func main() { jobs.Main(jobs.All["discount-check"]) }
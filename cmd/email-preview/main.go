@@ -0,0 +1,34 @@
+// email-preview renders a transactional email template with its sample data, for iterating on templates without a running server:
+//
+//	go run ./cmd/email-preview -name magicLink > /tmp/email.html
+//
+// Run without -name to list the available templates.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/TheLab-ms/profile/internal/emailtmpl"
+)
+
+func main() {
+	name := flag.String("name", "", "name of the template to render")
+	flag.Parse()
+
+	if *name == "" {
+		for _, name := range emailtmpl.Names() {
+			fmt.Println(name)
+		}
+		return
+	}
+
+	subject, body, err := emailtmpl.Render(*name, emailtmpl.Samples[*name])
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "Subject: %s\n", subject)
+	fmt.Println(body)
+}
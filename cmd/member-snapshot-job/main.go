@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/TheLab-ms/profile/internal/app"
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+func main() {
+	if err := run(); err != nil {
+		log.Printf("terminal error: %s", err)
+		os.Exit(1)
+	}
+}
+
+// run records every member's current status under today's date (in the space's timezone).
+// It's meant to be scheduled nightly.
+func run() error {
+	a, err := app.Load()
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	env, kc := a.Env, a.Keycloak
+
+	loc, err := time.LoadLocation(env.SpaceTimezone)
+	if err != nil {
+		return fmt.Errorf("loading space timezone: %w", err)
+	}
+
+	ctx := context.Background()
+
+	if !reporting.DefaultSink.Enabled() {
+		return errors.New("the reporting database is required")
+	}
+
+	users, err := kc.ListUsers(ctx)
+	if err != nil {
+		return fmt.Errorf("listing users: %w", err)
+	}
+	snapshots := make([]*reporting.MemberSnapshot, len(users))
+	for i, user := range users {
+		snapshots[i] = reporting.NewMemberSnapshot(user)
+	}
+
+	today := time.Now().In(loc)
+	if err := reporting.DefaultSink.RecordMemberSnapshots(ctx, today, snapshots); err != nil {
+		return fmt.Errorf("recording snapshots: %w", err)
+	}
+
+	log.Printf("recorded %d member snapshots for %s", len(snapshots), today.Format("2006-01-02"))
+	return nil
+}
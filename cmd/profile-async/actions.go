@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+// applyDeferredDeletions deletes accounts whose deletion by leadership wasn't undone in time.
+// See the admin actions in internal/server.
+func applyDeferredDeletions(ctx context.Context, kc *keycloak.Keycloak[*datamodel.User]) bool {
+	now := time.Now()
+	actions, err := reporting.DefaultSink.ListDueAdminActions(ctx, reporting.AdminActionDeleteUser, now)
+	if err != nil {
+		log.Printf("error while listing pending account deletions: %s", err)
+		return false
+	}
+
+	ok := true
+	for _, action := range actions {
+		err := kc.DeleteUser(ctx, action.UserID)
+		if err != nil && !errors.Is(err, keycloak.ErrNotFound) {
+			log.Printf("error while deleting user %s: %s", action.UserID, err)
+			ok = false
+			continue
+		}
+
+		if err := reporting.DefaultSink.MarkAdminActionApplied(ctx, action.ID, now); err != nil {
+			log.Printf("error while marking admin action %d as applied: %s", action.ID, err)
+			ok = false
+			continue
+		}
+		log.Printf("deleted user %s as requested by %s", action.UserID, action.Actor)
+		reporting.DefaultSink.Eventf(action.Email, "AccountDeleted", "account deleted by %s", action.Actor)
+	}
+	return ok
+}
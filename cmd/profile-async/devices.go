@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/TheLab-ms/profile/internal/chatbot"
+	"github.com/TheLab-ms/profile/internal/conf"
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+// alertSilentDevices posts to the device alert webhook when a device stops reporting heartbeats, and again once it
+// recovers. Alerts are recorded in the reporting db so each outage is only posted once.
+func alertSilentDevices(ctx context.Context, env *conf.Env, now time.Time) bool {
+	devices, err := reporting.DefaultSink.ListDevices(ctx)
+	if err != nil {
+		log.Printf("error while listing devices: %s", err)
+		return false
+	}
+
+	for _, d := range devices {
+		silent := d.Silent(now, env.DeviceSilenceThreshold)
+		alerted := !d.SilentSince.IsZero()
+		if silent == alerted {
+			continue
+		}
+
+		msg := fmt.Sprintf("Device %s (%s) is reporting again after being silent for %s.", d.ID, d.Kind, now.Sub(d.SilentSince).Round(time.Minute))
+		since := time.Time{}
+		if silent {
+			msg = fmt.Sprintf("Device %s (%s) hasn't reported a heartbeat in %s. It last reported %q on firmware %s.", d.ID, d.Kind, now.Sub(d.LastSeen).Round(time.Minute), d.Status, d.FirmwareVersion)
+			since = d.LastSeen
+		}
+		log.Print(msg)
+		if env.DeviceAlertWebhook != "" {
+			if err := chatbot.PostWebhook(ctx, env.DeviceAlertWebhook, msg); err != nil {
+				log.Printf("error while posting device alert: %s", err)
+				continue // try again next time
+			}
+		}
+
+		if err := reporting.DefaultSink.MarkDeviceSilent(ctx, d.ID, since); err != nil {
+			log.Printf("error while recording device alert for %s: %s", d.ID, err)
+		}
+	}
+	return true
+}
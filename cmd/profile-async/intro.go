@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/TheLab-ms/profile/internal/chatbot"
+	"github.com/TheLab-ms/profile/internal/conf"
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/reporting"
+	"github.com/TheLab-ms/profile/internal/urls"
+)
+
+// postDiscordIntro introduces a newly active member in the configured forum channel, mentioning them so they're pinged.
+// Each member is only introduced once, even if their membership lapses and starts again.
+func postDiscordIntro(ctx context.Context, env *conf.Env, kc *keycloak.Keycloak[*datamodel.User], bot *chatbot.Bot, user *datamodel.User) error {
+	if env.DiscordIntroChannelID == "" || user.DiscordIntroOptOut || user.DiscordIntroThreadID != "" || user.DiscordUserID == 0 {
+		return nil
+	}
+
+	title := strings.TrimSpace(fmt.Sprintf("Welcome %s!", user.First))
+	threadID, err := bot.CreateForumPost(ctx, env.DiscordIntroChannelID, title, introMessage(env, user), user.DiscordUserID)
+	if err != nil {
+		return err
+	}
+	if threadID == "" {
+		return nil // bot is disabled
+	}
+
+	user.DiscordIntroThreadID = threadID
+	if err := kc.WriteUser(ctx, user); err != nil {
+		return fmt.Errorf("writing user: %w", err)
+	}
+	reporting.DefaultSink.Eventf(user.Email, "DiscordIntroPosted", "posted introduction thread %s", threadID)
+	return nil
+}
+
+func introMessage(env *conf.Env, user *datamodel.User) string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "Everyone please welcome <@%d> to TheLab! Tell us a bit about yourself and what you're hoping to make.\n\n", user.DiscordUserID)
+	b.WriteString("Some links to help you get started:\n")
+	if env.WelcomeOrientationURL != "" {
+		fmt.Fprintf(b, "- Book an orientation: %s\n", env.WelcomeOrientationURL)
+	}
+	fmt.Fprintf(b, "- Upcoming events: %s\n", urls.Calendar(env))
+	fmt.Fprintf(b, "- Your membership profile: %s\n", urls.Profile(env))
+	return b.String()
+}
@@ -1,89 +1,111 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
-	"net/http"
+	"sort"
 	"strconv"
 	"time"
 
+	"github.com/TheLab-ms/profile/internal/app"
 	"github.com/TheLab-ms/profile/internal/chatbot"
 	"github.com/TheLab-ms/profile/internal/conf"
+	"github.com/TheLab-ms/profile/internal/conway"
 	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/email"
+	"github.com/TheLab-ms/profile/internal/events"
 	"github.com/TheLab-ms/profile/internal/flowcontrol"
 	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/notify"
 	"github.com/TheLab-ms/profile/internal/reporting"
-	"golang.org/x/time/rate"
+	"github.com/TheLab-ms/profile/internal/telemetry"
 )
 
-var signupEmailLimiter = rate.NewLimiter(rate.Every(time.Second*10), 1)
-
-func handleUserSignupEmail(ctx context.Context, kc *keycloak.Keycloak[*datamodel.User], userID string) error {
-	user, err := kc.GetUser(ctx, userID)
-	if errors.Is(keycloak.ErrNotFound, err) {
-		return nil // ignore any users that have been deleted since being enqueued
-	}
+func handleDiscordSync(ctx context.Context, env *conf.Env, kc *keycloak.Keycloak[*datamodel.User], bot *chatbot.Bot, run *chatbot.ResyncRun, userID int64) error {
+	holders, err := kc.ListUsersByAttribute(ctx, "discordUserID", strconv.FormatInt(userID, 10))
 	if err != nil {
 		return fmt.Errorf("getting user: %w", err)
 	}
-
-	// Send emails at least once while avoiding duplicates when possible
-	if user.SignupEmailSentTime.After(time.Unix(0, 0)) {
-		return nil
+	// no holders isn't an error since we may still need to clean up the role
+	user, active, err := resolveDiscordHolder(ctx, kc, userID, holders)
+	if err != nil {
+		return err
 	}
 
-	// Don't send email if we somehow didn't become aware of the user until >24hr after signup time.
-	if time.Since(user.SignupTime) > time.Hour*24 {
-		return nil
+	status := &chatbot.UserStatus{ID: userID}
+	if user != nil {
+		status.Email = user.Email
+		status.Banned = user.Banned
+		status.Nickname = env.DiscordNicknameFormat.For(user)
+		status.ActiveMember = active
 	}
 
-	signupEmailLimiter.Wait(ctx)
-	err = kc.SendSignupEmail(ctx, user.UUID)
+	result, err := bot.SyncUser(ctx, status)
 	if err != nil {
-		return err
+		return fmt.Errorf("syncing discord user: %w", err)
+	}
+	if stats, done := run.Record(userID, result); done {
+		log.Printf("discord resync finished: %+v", stats)
+		reporting.DefaultSink.Eventf("", "DiscordSyncSummary", "added=%d removed=%d noop=%d user_missing=%d",
+			stats[chatbot.SyncResultAdded], stats[chatbot.SyncResultRemoved], stats[chatbot.SyncResultNoop], stats[chatbot.SyncResultUserMissing])
 	}
-	reporting.DefaultSink.Eventf(user.Email, "SignupEmailSent", "sent initial password reset email to new user")
 
-	user.SignupEmailSentTime = time.Now()
-	return kc.WriteUser(ctx, user)
+	// Adding the role means the member just became active.
+	// Failures aren't retried since the next sync won't add the role again - the intro is nice to have anyway.
+	if result == chatbot.SyncResultAdded && user != nil {
+		if err := postDiscordIntro(ctx, env, kc, bot, user); err != nil {
+			log.Printf("error while posting discord intro for member %s: %s", user.Email, err)
+		}
+	}
+
+	return nil
 }
 
-func handleDiscordSync(ctx context.Context, kc *keycloak.Keycloak[*datamodel.User], bot *chatbot.Bot, userID int64) error {
-	user, err := kc.GetUserByAttribute(ctx, "discordUserID", strconv.FormatInt(userID, 10))
-	if errors.Is(keycloak.ErrNotFound, err) {
-		// ignore 404s since we may still need to clean up the role
-		err = nil
+// resolveDiscordHolder picks the profile a Discord account is synced with, and unlinks it from any others.
+// Profiles linked before links were unique can share an account, so the winner is chosen deterministically:
+// active members first, then the most recently created profile since it's more likely to be the one in use.
+func resolveDiscordHolder(ctx context.Context, kc *keycloak.Keycloak[*datamodel.User], discordUserID int64, holders []*datamodel.User) (*datamodel.User, bool, error) {
+	type candidate struct {
+		user   *datamodel.User
+		active bool
 	}
-	if err != nil {
-		return fmt.Errorf("getting user: %w", err)
-	}
-
-	status := &chatbot.UserStatus{ID: userID}
-	if user != nil {
-		status.Email = user.Email
-		extended, err := kc.ExtendUser(ctx, user, user.UUID)
-		if errors.Is(keycloak.ErrNotFound, err) {
-			// ignore 404s since we may still need to clean up the role
-			err = nil
+	candidates := []*candidate{}
+	for _, holder := range holders {
+		extended, err := kc.ExtendUser(ctx, holder, holder.UUID)
+		if errors.Is(err, keycloak.ErrNotFound) {
+			continue // deleted since it was listed
 		}
 		if err != nil {
-			return fmt.Errorf("extending user: %w", err)
-		}
-		if extended != nil {
-			status.ActiveMember = extended.ActiveMember
+			return nil, false, fmt.Errorf("extending user: %w", err)
 		}
+		candidates = append(candidates, &candidate{user: holder, active: extended.ActiveMember})
 	}
-
-	err = bot.SyncUser(ctx, status)
-	if err != nil {
-		return fmt.Errorf("syncing discord user: %w", err)
+	if len(candidates) == 0 {
+		return nil, false, nil
 	}
 
-	return nil
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.active != b.active {
+			return a.active
+		}
+		if a.user.CreationTime != b.user.CreationTime {
+			return a.user.CreationTime > b.user.CreationTime
+		}
+		return a.user.UUID < b.user.UUID
+	})
+
+	winner := candidates[0]
+	for _, loser := range candidates[1:] {
+		err := kc.PatchUserAttributes(ctx, loser.user.UUID, map[string]string{"discordUserID": ""})
+		if err != nil {
+			return nil, false, fmt.Errorf("unlinking duplicate profile %s: %w", loser.user.UUID, err)
+		}
+		reporting.DefaultSink.Eventf(loser.user.Email, "DiscordUnlinked", "discord account %d was also linked to %s, which was kept", discordUserID, winner.user.Email)
+	}
+	return winner.user, winner.active, nil
 }
 
 func handleConwaySync(ctx context.Context, env *conf.Env, kc *keycloak.Keycloak[*datamodel.User], userID string) error {
@@ -112,7 +134,7 @@ func handleConwaySync(ctx context.Context, env *conf.Env, kc *keycloak.Keycloak[
 		"fob_id":                    user.FobID,
 		"stripe_customer_id":        user.StripeCustomerID,
 		"stripe_subscription_id":    user.StripeSubscriptionID,
-		"stripe_subscription_state": "unknown",
+		"stripe_subscription_state": datamodel.SubscriptionStateUnknown,
 		"paypal_subscription_id":    user.PaypalMetadata.TransactionID,
 		"paypal_price":              user.PaypalMetadata.Price,
 		"paypal_last_payment":       nil,
@@ -139,8 +161,14 @@ func handleConwaySync(ctx context.Context, env *conf.Env, kc *keycloak.Keycloak[
 	if user.PaypalMetadata.TimeRFC3339.After(time.Unix(0, 0)) {
 		out["paypal_last_payment"] = user.PaypalMetadata.TimeRFC3339.Unix()
 	}
-	if ext.ActiveMember {
-		out["stripe_subscription_state"] = "active"
+	out["stripe_subscription_state"] = user.SubscriptionState(ext.ActiveMember, time.Now())
+	if user.StripeGracePeriodEnd.After(time.Unix(0, 0)) {
+		out["grace_period_end"] = user.StripeGracePeriodEnd.Unix()
+	}
+	out["banned"] = user.Banned
+	out["ban_reason"] = nil
+	if user.Banned {
+		out["ban_reason"] = user.BanReason
 	}
 
 	if env.ConwayURL == "" || env.ConwayToken == "" {
@@ -148,59 +176,81 @@ func handleConwaySync(ctx context.Context, env *conf.Env, kc *keycloak.Keycloak[
 		return nil
 	}
 
-	js, err := json.Marshal(&out)
-	if err != nil {
-		return err
-	}
-
-	req, err := http.NewRequestWithContext(ctx, "PATCH", fmt.Sprintf("%s/api/members/%s", env.ConwayURL, user.Email), bytes.NewBuffer(js))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", env.ConwayToken))
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 204 {
-		return fmt.Errorf("unexpected status: %d", resp.StatusCode)
-	}
-
-	return nil
+	return conway.NewClient(env.ConwayURL, env.ConwayToken).PatchMember(ctx, user.Email, out)
 }
 
 func main() {
 	ctx := context.TODO()
-	env := &conf.Env{}
-	env.MustLoad()
+	a, err := app.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	env, kc := a.Env, a.Keycloak
 
 	discordSyncUsers := flowcontrol.NewQueue[int64]()
 	go discordSyncUsers.Run(ctx)
+	discordResync := &chatbot.ResyncRun{}
 	conwaySyncUsers := flowcontrol.NewQueue[string]()
 	go conwaySyncUsers.Run(ctx)
-	signupEmailUsers := flowcontrol.NewQueue[string]()
-	go signupEmailUsers.Run(ctx)
+	welcomeUsers := flowcontrol.NewQueue[string]()
+	go welcomeUsers.Run(ctx)
+	newsletterSyncUsers := flowcontrol.NewQueue[string]()
+	go newsletterSyncUsers.Run(ctx)
+
+	// Hold the queues while Keycloak is down rather than burning through retries
+	discordSyncUsers.Paused = kc.Unavailable
+	conwaySyncUsers.Paused = kc.Unavailable
+	welcomeUsers.Paused = kc.Unavailable
+	newsletterSyncUsers.Paused = kc.Unavailable
 
-	kc := keycloak.New[*datamodel.User](env)
+	// Replicas all serve webhooks and run workers, but only the leader runs the loops below
+	leader := reporting.DefaultSink.NewLeadership("profile-async")
+	go leader.Run(ctx)
 
-	var err error
-	reporting.DefaultSink, err = reporting.NewSink(env, kc)
+	bot, err := chatbot.NewBot(env)
 	if err != nil {
 		log.Fatal(err)
 	}
-	kc.Sink = reporting.DefaultSink
 
-	bot, err := chatbot.NewBot(env)
-	if err != nil {
+	// New members are walked through the welcome sequence as they're resynced
+	sender := email.NewSender(env)
+	welcomeSeq := newWelcomeSequence(env, kc, bot, sender)
+	notifier := notify.New(bot, sender)
+
+	// Leadership can skip the wait for the next resync loop
+	bot.AddResyncCommand(func(ctx context.Context, email string) (string, error) {
+		user, err := kc.GetUserByEmail(ctx, email)
+		if errors.Is(err, keycloak.ErrNotFound) {
+			return fmt.Sprintf("No account exists for %s", email), nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("getting user: %w", err)
+		}
+
+		welcomeUsers.AddWithPriority(user.UUID, flowcontrol.PriorityHigh)
+		conwaySyncUsers.AddWithPriority(user.UUID, flowcontrol.PriorityHigh)
+		newsletterSyncUsers.AddWithPriority(user.UUID, flowcontrol.PriorityHigh)
+		if user.DiscordUserID == 0 {
+			return fmt.Sprintf("Enqueued %s for welcome sequence, Conway, and newsletter sync (no Discord account is linked)", user.Email), nil
+		}
+		discordSyncUsers.AddWithPriority(user.DiscordUserID, flowcontrol.PriorityHigh)
+		return fmt.Sprintf("Enqueued %s for welcome sequence, Conway, newsletter, and Discord sync", user.Email), nil
+	})
+	if err := bot.Start(ctx); err != nil {
 		log.Fatal(err)
 	}
 
+	// Resync loops record when they last completed so stalls can be noticed
+	marks := newWatermarks()
+	go (&flowcontrol.Loop{
+		Handler: flowcontrol.LeaderOnly(leader, leader.Interval, flowcontrol.RetryHandler(time.Minute*5, func(ctx context.Context) bool {
+			return marks.Alert(ctx, env.ResyncAlertWebhook, time.Now())
+		})),
+	}).Run(ctx)
+
 	// Webhook registration
 	if env.KeycloakRegisterWebhook {
-		err = kc.EnsureWebhook(ctx, fmt.Sprintf("%s/webhooks/keycloak", env.WebhookURL))
+		err = kc.EnsureWebhook(ctx, fmt.Sprintf("%s/webhooks/keycloak", env.WebhookURL), env.KeycloakWebhookSecret)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -208,67 +258,171 @@ func main() {
 
 	// Keycloak resync loop
 	go (&flowcontrol.Loop{
-		Handler: flowcontrol.RetryHandler(time.Hour*2, func(ctx context.Context) bool {
+		Handler: flowcontrol.LeaderOnly(leader, leader.Interval, flowcontrol.RetryHandler(resyncIntervals[resyncKeycloak], func(ctx context.Context) bool {
 			log.Printf("resyncing keycloak users...")
-			users, err := kc.ListUsers(ctx)
+			// Only the IDs are kept while paging - the workers get the rest of the user themselves
+			ids := []string{}
+			discordIDs := []int64{}
+			err := kc.ListUsersStream(ctx, func(extended *keycloak.ExtendedUser[*datamodel.User]) error {
+				ids = append(ids, extended.User.UUID)
+				if extended.User.DiscordUserID > 0 {
+					discordIDs = append(discordIDs, extended.User.DiscordUserID)
+				}
+				return nil
+			})
 			if err != nil {
 				log.Printf("error while listing members for resync: %s", err)
 				return false
 			}
-			for _, extended := range users {
-				user := extended.User
-				if user.DiscordUserID > 0 {
-					discordSyncUsers.Add(user.DiscordUserID)
-				}
-				signupEmailUsers.Add(user.UUID)
-				conwaySyncUsers.Add(user.UUID)
+			marks.StartConwayBatch(ids)
+
+			// Bulk resyncs yield to webhooks so real-time changes aren't stuck behind every other member
+			for _, id := range discordIDs {
+				discordSyncUsers.AddWithPriority(id, flowcontrol.PriorityLow)
+			}
+			for _, id := range ids {
+				welcomeUsers.AddWithPriority(id, flowcontrol.PriorityLow)
+				conwaySyncUsers.AddWithPriority(id, flowcontrol.PriorityLow)
+				newsletterSyncUsers.AddWithPriority(id, flowcontrol.PriorityLow)
 			}
+			marks.Record(ctx, resyncKeycloak, time.Now())
 			return true
-		}),
+		})),
+	}).Run(ctx)
+
+	// Account deletions requested by leadership are deferred so they can be undone
+	if reporting.DefaultSink.Enabled() {
+		go (&flowcontrol.Loop{
+			Handler: flowcontrol.LeaderOnly(leader, leader.Interval, flowcontrol.RetryHandler(time.Minute, func(ctx context.Context) bool {
+				return applyDeferredDeletions(ctx, kc)
+			})),
+		}).Run(ctx)
+	}
+
+	// RSVPs are reminded of upcoming Discord events - the reporting database prevents duplicate reminders
+	if reporting.DefaultSink.Enabled() && bot.Enabled() {
+		eventsCache := events.NewCache(env)
+		eventsCache.Coordinator = reporting.DefaultSink
+		go eventsCache.Run(ctx)
+
+		go (&flowcontrol.Loop{
+			Handler: flowcontrol.LeaderOnly(leader, leader.Interval, flowcontrol.RetryHandler(time.Minute*5, func(ctx context.Context) bool {
+				return sendEventReminders(ctx, env, kc, eventsCache, notifier, time.Now())
+			})),
+		}).Run(ctx)
+	}
+
+	// Members are reminded to change old door PINs
+	if env.DoorPINKey != "" {
+		go (&flowcontrol.Loop{
+			Handler: flowcontrol.LeaderOnly(leader, leader.Interval, flowcontrol.RetryHandler(time.Hour*24, func(ctx context.Context) bool {
+				return sendPINRotationReminders(ctx, env, kc, notifier, time.Now())
+			})),
+		}).Run(ctx)
+	}
+
+	// Leadership is alerted when door controllers and kiosks stop reporting heartbeats
+	if reporting.DefaultSink.Enabled() && env.DeviceHeartbeatToken != "" {
+		go (&flowcontrol.Loop{
+			Handler: flowcontrol.LeaderOnly(leader, leader.Interval, flowcontrol.RetryHandler(time.Minute, func(ctx context.Context) bool {
+				return alertSilentDevices(ctx, env, time.Now())
+			})),
+		}).Run(ctx)
+	}
+
+	// Old swipes are aggregated and deleted accounts are anonymized
+	go (&flowcontrol.Loop{
+		Handler: flowcontrol.LeaderOnly(leader, leader.Interval, flowcontrol.RetryHandler(time.Hour*24, func(ctx context.Context) bool {
+			return applyRetentionPolicy(ctx, env, kc, time.Now())
+		})),
 	}).Run(ctx)
 
 	// Discord resync loop
 	go (&flowcontrol.Loop{
-		Handler: flowcontrol.RetryHandler(time.Hour*24, func(ctx context.Context) bool {
+		Handler: flowcontrol.LeaderOnly(leader, leader.Interval, flowcontrol.RetryHandler(resyncIntervals[resyncDiscord], func(ctx context.Context) bool {
 			log.Printf("resyncing discord users...")
+			ids := []int64{}
 			err := bot.ListUsers(ctx, func(id int64) {
-				discordSyncUsers.Add(id)
+				ids = append(ids, id)
 			})
 			if err != nil {
 				log.Printf("error while listing discord users for resync: %s", err)
 				return false
 			}
+
+			// The run is summarized by the worker once all of these users have been synced
+			if !discordResync.Start(ids) {
+				log.Printf("the previous discord resync never finished - it won't be summarized")
+			}
+			for _, id := range ids {
+				discordSyncUsers.AddWithPriority(id, flowcontrol.PriorityLow)
+			}
+			marks.Record(ctx, resyncDiscord, time.Now())
 			return true
-		}),
+		})),
 	}).Run(ctx)
 
 	// Workers pull messages off of the queue and process them
 	go flowcontrol.RunWorker(ctx, discordSyncUsers, func(id int64) error {
-		return handleDiscordSync(ctx, kc, bot, id)
+		return handleDiscordSync(ctx, env, kc, bot, discordResync, id)
 	})
-	go flowcontrol.RunWorker(ctx, signupEmailUsers, func(id string) error {
-		return handleUserSignupEmail(ctx, kc, id)
+	go flowcontrol.RunWorker(ctx, welcomeUsers, func(id string) error {
+		priority, enqueued, _ := welcomeUsers.Item(id)
+		return handleWelcomeSequence(withSignupEmailLane(ctx, signupEmailLaneFor(priority), enqueued), kc, welcomeSeq, id)
 	})
 	go flowcontrol.RunWorker(ctx, conwaySyncUsers, func(id string) error {
 		defer time.Sleep(time.Millisecond * 50) // throttling lol
-		return handleConwaySync(ctx, env, kc, id)
+		if err := handleConwaySync(ctx, env, kc, id); err != nil {
+			return err
+		}
+		marks.ConwaySynced(ctx, id)
+		return nil
+	})
+
+	go flowcontrol.RunWorker(ctx, newsletterSyncUsers, func(id string) error {
+		return handleNewsletterSync(ctx, env, kc, id)
 	})
 
 	// Webhook server
-	mux := http.NewServeMux()
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(204) })
-	mux.Handle("/webhooks/keycloak", keycloak.NewWebhookHandler(func(userID string) bool {
+	mux := telemetry.NewMux("profile-async")
+	mux.HandleFunc("/ready", marks.newReadyHandler())
+	mux.Handle("/webhooks/keycloak", keycloak.NewWebhookHandler(env.KeycloakWebhookSecret, func(userID string) bool {
 		log.Printf("got keycloak webhook for user %s", userID)
-		signupEmailUsers.Add(userID)
+		kc.InvalidateMembership(userID)
+		welcomeUsers.AddWithPriority(userID, flowcontrol.PriorityHigh)
 
 		user, err := kc.GetUser(ctx, userID)
 		if err != nil {
 			log.Printf("error while getting keycloak user: %s", err)
 			return false
 		}
-		discordSyncUsers.Add(user.DiscordUserID)
+		discordSyncUsers.AddWithPriority(user.DiscordUserID, flowcontrol.PriorityHigh)
+		if user.PreviousDiscordUserID != 0 && user.PreviousDiscordUserID != user.DiscordUserID {
+			discordSyncUsers.AddWithPriority(user.PreviousDiscordUserID, flowcontrol.PriorityHigh) // relinked
+		}
+
+		// Changes like bans need to reach Conway before the next resync. PatchMember only sends fields that differ,
+		// so this is cheap for changes Conway doesn't care about.
+		conwaySyncUsers.AddWithPriority(userID, flowcontrol.PriorityHigh)
+		newsletterSyncUsers.AddWithPriority(userID, flowcontrol.PriorityHigh)
 		return true
 	}))
+	if env.ConwayWebhookSecret != "" {
+		// Conway pushes changes made on its side so we can reconcile right away instead of waiting for the next resync
+		mux.Handle("/webhooks/conway", conway.NewWebhookHandler(env.ConwayWebhookSecret, func(email string) bool {
+			user, err := kc.GetUserByEmail(ctx, email)
+			if errors.Is(err, keycloak.ErrNotFound) {
+				log.Printf("ignoring conway webhook for unknown member %s", email)
+				return true
+			}
+			if err != nil {
+				log.Printf("error while getting keycloak user: %s", err)
+				return false
+			}
+			conwaySyncUsers.AddWithPriority(user.UUID, flowcontrol.PriorityHigh)
+			return true
+		}))
+	}
 
-	log.Fatal(http.ListenAndServe(":8081", mux))
+	log.Fatal(telemetry.ListenAndServe(mux))
 }
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/TheLab-ms/profile/internal/conf"
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/newsletter"
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+// handleNewsletterSync subscribes or unsubscribes the member to match their newsletter opt-in. Subscribing sends the
+// double opt-in email, and the member stays pending until the newsletter webhook reports that they confirmed.
+func handleNewsletterSync(ctx context.Context, env *conf.Env, kc *keycloak.Keycloak[*datamodel.User], userID string) error {
+	if env.NewsletterAPIKey == "" {
+		return nil
+	}
+	user, err := kc.GetUser(ctx, userID)
+	if errors.Is(err, keycloak.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("getting user: %w", err)
+	}
+
+	subscribed := user.NewsletterStatus == newsletter.StatusPending || user.NewsletterStatus == newsletter.StatusSubscribed
+	if user.NewsletterOptIn == subscribed {
+		return nil
+	}
+
+	client := newsletter.NewClient(env.NewsletterAPIKey, env.NewsletterListID)
+	if user.NewsletterOptIn {
+		user.NewsletterStatus, err = client.Subscribe(ctx, user.Email)
+	} else {
+		user.NewsletterStatus, err = newsletter.StatusUnsubscribed, client.Unsubscribe(ctx, user.Email)
+	}
+	if err != nil {
+		return err
+	}
+	if err := kc.WriteUser(ctx, user); err != nil {
+		return fmt.Errorf("writing user: %w", err)
+	}
+
+	reporting.DefaultSink.Eventf(user.Email, "NewsletterStatusChanged", "newsletter subscription is now %s", user.NewsletterStatus)
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/TheLab-ms/profile/internal/access"
+	"github.com/TheLab-ms/profile/internal/conf"
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/emailtmpl"
+	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/notify"
+	"github.com/TheLab-ms/profile/internal/reporting"
+	"github.com/TheLab-ms/profile/internal/urls"
+)
+
+// sendPINRotationReminders asks members with old door PINs to choose new ones.
+// The reminder is recorded before it's sent, so a failure to write the user doesn't send it again every day.
+func sendPINRotationReminders(ctx context.Context, env *conf.Env, kc *keycloak.Keycloak[*datamodel.User], notifier *notify.Notifier, now time.Time) bool {
+	users, err := kc.ListUsers(ctx)
+	if err != nil {
+		log.Printf("error while listing users for door PIN reminders: %s", err)
+		return false
+	}
+
+	for _, extended := range users {
+		user := extended.User
+		if !extended.ActiveMember || !access.PINRotationDue(user, now, env.DoorPINRotation) {
+			continue
+		}
+
+		user.DoorPINReminderTime = now
+		if err := kc.WriteUser(ctx, user); err != nil {
+			log.Printf("error while recording door PIN reminder for user %s: %s", user.Email, err)
+			continue
+		}
+		_, err := notifier.Notify(ctx, user, notify.ReasonDoorPINRotation, &emailtmpl.DoorPINRotation{
+			SetOn: user.DoorPINSetTime.Format("January 2, 2006"),
+			URL:   urls.Profile(env),
+		})
+		if err != nil {
+			log.Printf("error while sending door PIN reminder to user %s: %s", user.Email, err)
+			continue
+		}
+		reporting.DefaultSink.Eventf(user.Email, "DoorPINReminderSent", "reminded user to change their door PIN")
+	}
+	return true
+}
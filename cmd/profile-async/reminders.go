@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/TheLab-ms/profile/internal/conf"
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/emailtmpl"
+	"github.com/TheLab-ms/profile/internal/events"
+	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/notify"
+	"github.com/TheLab-ms/profile/internal/reporting"
+	"github.com/TheLab-ms/profile/internal/urls"
+)
+
+// sendEventReminders DMs everyone who RSVPed to an event when one of its reminders is due.
+// Each reminder is claimed in the reporting database first, so restarts and leadership changes don't double-send.
+func sendEventReminders(ctx context.Context, env *conf.Env, kc *keycloak.Keycloak[*datamodel.User], cache *events.EventCache, notifier *notify.Notifier, now time.Time) bool {
+	upcoming, err := cache.GetEvents(now.Add(events.Reminders[0].Before))
+	if err != nil {
+		log.Printf("error while getting upcoming events: %s", err)
+		return false
+	}
+
+	ok := true
+	for _, event := range upcoming {
+		reminder := events.DueReminder(event, now)
+		if reminder == nil || event.ID == "" {
+			continue
+		}
+		rsvps, err := cache.ListRSVPs(ctx, event.ID)
+		if err != nil {
+			log.Printf("error while listing RSVPs for event %s: %s", event.ID, err)
+			ok = false
+			continue
+		}
+
+		start := time.Unix(event.Start, 0)
+		for _, discordUserID := range rsvps {
+			claimed, err := reporting.DefaultSink.ClaimEventReminder(ctx, event.ID, start, discordUserID, reminder.Kind)
+			if err != nil {
+				log.Printf("error while claiming event reminder: %s", err)
+				ok = false
+				continue
+			}
+			if !claimed {
+				continue // already sent
+			}
+			if err := sendEventReminder(ctx, env, kc, notifier, event, discordUserID, now); err != nil {
+				log.Printf("error while sending %s reminder for event %s to discord user %d: %s", reminder.Kind, event.ID, discordUserID, err)
+			}
+		}
+	}
+	return ok
+}
+
+func sendEventReminder(ctx context.Context, env *conf.Env, kc *keycloak.Keycloak[*datamodel.User], notifier *notify.Notifier, event *datamodel.Event, discordUserID int64, now time.Time) error {
+	// RSVPs linked to an account get their notification preferences respected.
+	// Everyone else can stop reminders by removing their RSVP.
+	user, err := kc.GetUserByAttribute(ctx, "discordUserID", strconv.FormatInt(discordUserID, 10))
+	if errors.Is(err, keycloak.ErrNotFound) {
+		user, err = &datamodel.User{DiscordUserID: discordUserID}, nil
+	}
+	if err != nil {
+		return fmt.Errorf("getting user: %w", err)
+	}
+
+	loc, _ := time.LoadLocation(env.SpaceTimezone) // validated at startup
+	start := time.Unix(event.Start, 0)
+	_, err = notifier.Notify(ctx, user, notify.ReasonEventReminder, &emailtmpl.EventReminder{
+		Name:  event.Name,
+		Start: start.In(loc).Format("Monday, January 2 at 3:04 PM"),
+		In:    humanizeUntil(start.Sub(now)),
+		URL:   urls.NotificationPreferences(env),
+	})
+	if errors.Is(err, notify.ErrUndeliverable) && !user.WantsNotification(datamodel.NotifyEvents, datamodel.ChannelDiscord) {
+		return nil // opted out
+	}
+	return err
+}
+
+// humanizeUntil completes "... starts " e.g. "in 3 hours".
+func humanizeUntil(d time.Duration) string {
+	switch {
+	case d >= time.Hour*2:
+		return fmt.Sprintf("in %d hours", int(d.Round(time.Hour).Hours()))
+	case d >= time.Minute*50:
+		return "in 1 hour"
+	default:
+		return fmt.Sprintf("in %d minutes", int(d.Round(time.Minute).Minutes()))
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/TheLab-ms/profile/internal/conf"
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+// anonymizationDelay gives buffered events about a deletion time to be flushed before the account's records are scrubbed.
+const anonymizationDelay = time.Hour * 24
+
+// applyRetentionPolicy aggregates old swipes and anonymizes the records of deleted accounts.
+func applyRetentionPolicy(ctx context.Context, env *conf.Env, kc *keycloak.Keycloak[*datamodel.User], now time.Time) bool {
+	ok := true
+	if env.SwipeRetention > 0 {
+		n, err := reporting.DefaultSink.AggregateSwipes(ctx, now.Add(-env.SwipeRetention))
+		if err != nil {
+			log.Printf("error while aggregating old swipes: %s", err)
+			ok = false
+		} else if n > 0 {
+			log.Printf("aggregated and deleted %d swipes older than %s", n, env.SwipeRetention)
+		}
+	}
+
+	emails, err := reporting.DefaultSink.ListDeletedAccounts(ctx, now.Add(-anonymizationDelay))
+	if err != nil {
+		log.Printf("error while listing deleted accounts: %s", err)
+		return false
+	}
+	for _, email := range emails {
+		// The address may have been used to sign up again since
+		_, err := kc.GetUserByEmail(ctx, email)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, keycloak.ErrNotFound) {
+			log.Printf("error while checking for account %s: %s", email, err)
+			ok = false
+			continue
+		}
+
+		if err := reporting.DefaultSink.AnonymizeMember(ctx, email); err != nil {
+			log.Printf("error while anonymizing records of deleted account %s: %s", email, err)
+			ok = false
+			continue
+		}
+		log.Printf("anonymized records of deleted account %s", email)
+	}
+	return ok
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/TheLab-ms/profile/internal/chatbot"
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+// Full resync loops, by the name their watermarks are stored under.
+const (
+	resyncKeycloak = "keycloak"
+	resyncDiscord  = "discord"
+	resyncConway   = "conway" // completes once every member enqueued by the Keycloak resync has been synced to Conway
+)
+
+var resyncIntervals = map[string]time.Duration{
+	resyncKeycloak: time.Hour * 2,
+	resyncDiscord:  time.Hour * 24,
+	resyncConway:   time.Hour * 2,
+}
+
+var (
+	resyncCompleted = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "profile_resync_last_completed_timestamp_seconds",
+		Help: "When each full resync loop last completed successfully",
+	}, []string{"loop"})
+	resyncStale = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "profile_resync_stale",
+		Help: "1 when a full resync loop hasn't completed within twice its interval",
+	}, []string{"loop"})
+)
+
+// watermarks tracks when each full resync loop last completed. They're stored in the reporting database so every
+// replica (and the next leader) reports the same thing, and kept in memory when reporting is disabled.
+type watermarks struct {
+	started time.Time
+
+	mut     sync.Mutex
+	local   map[string]time.Time
+	alerted map[string]bool
+	conway  map[string]struct{} // user IDs still waiting to be synced to Conway since the last Keycloak resync
+}
+
+func newWatermarks() *watermarks {
+	return &watermarks{started: time.Now(), local: map[string]time.Time{}, alerted: map[string]bool{}}
+}
+
+// Record marks the loop as having completed successfully.
+func (w *watermarks) Record(ctx context.Context, name string, completed time.Time) {
+	w.mut.Lock()
+	w.local[name] = completed
+	w.mut.Unlock()
+
+	if err := reporting.DefaultSink.RecordResyncWatermark(ctx, name, completed); err != nil {
+		log.Printf("error while recording %s resync watermark: %s", name, err)
+	}
+}
+
+// StartConwayBatch starts tracking a Conway resync of the given users, replacing any batch that hasn't finished.
+func (w *watermarks) StartConwayBatch(userIDs []string) {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	w.conway = make(map[string]struct{}, len(userIDs))
+	for _, id := range userIDs {
+		w.conway[id] = struct{}{}
+	}
+}
+
+// ConwaySynced removes the user from the current batch, recording the watermark once the batch is empty.
+func (w *watermarks) ConwaySynced(ctx context.Context, userID string) {
+	w.mut.Lock()
+	_, ok := w.conway[userID]
+	delete(w.conway, userID)
+	done := ok && len(w.conway) == 0
+	w.mut.Unlock()
+
+	if done {
+		w.Record(ctx, resyncConway, time.Now())
+	}
+}
+
+type resyncStatus struct {
+	Loop          string     `json:"loop"`
+	Interval      string     `json:"interval"`
+	LastCompleted *time.Time `json:"last_completed"` // nil if it hasn't completed since watermarks were introduced
+	Stale         bool       `json:"stale"`
+}
+
+// Status returns the state of every loop, sorted by name, and updates the metrics to match.
+// A loop is stale when it hasn't completed within twice its interval (counting from startup if it never has).
+func (w *watermarks) Status(ctx context.Context, now time.Time) ([]*resyncStatus, error) {
+	marks, err := reporting.DefaultSink.ListResyncWatermarks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	w.mut.Lock()
+	for name, completed := range w.local {
+		if completed.After(marks[name]) {
+			marks[name] = completed
+		}
+	}
+	w.mut.Unlock()
+
+	statuses := []*resyncStatus{}
+	for name, interval := range resyncIntervals {
+		status := &resyncStatus{Loop: name, Interval: interval.String()}
+		since := w.started
+		if completed, ok := marks[name]; ok {
+			status.LastCompleted = &completed
+			since = completed
+			resyncCompleted.WithLabelValues(name).Set(float64(completed.Unix()))
+		}
+		status.Stale = now.Sub(since) > interval*2
+		if status.Stale {
+			resyncStale.WithLabelValues(name).Set(1)
+		} else {
+			resyncStale.WithLabelValues(name).Set(0)
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Loop < statuses[j].Loop })
+	return statuses, nil
+}
+
+// Alert posts to the alert webhook when a loop becomes stale, and again once it recovers.
+func (w *watermarks) Alert(ctx context.Context, webhook string, now time.Time) bool {
+	statuses, err := w.Status(ctx, now)
+	if err != nil {
+		log.Printf("error while checking resync watermarks: %s", err)
+		return false
+	}
+
+	for _, status := range statuses {
+		w.mut.Lock()
+		changed := w.alerted[status.Loop] != status.Stale
+		w.mut.Unlock()
+		if !changed {
+			continue
+		}
+
+		msg := fmt.Sprintf("The %s resync has recovered.", status.Loop)
+		if status.Stale {
+			last := "hasn't completed since profile-async started"
+			if status.LastCompleted != nil {
+				last = fmt.Sprintf("last completed %s ago", now.Sub(*status.LastCompleted).Round(time.Minute))
+			}
+			msg = fmt.Sprintf("The %s resync is behind: it runs every %s but %s.", status.Loop, status.Interval, last)
+		}
+		log.Print(msg)
+		if webhook != "" {
+			if err := chatbot.PostWebhook(ctx, webhook, msg); err != nil {
+				log.Printf("error while posting resync alert: %s", err)
+				continue // try again next time
+			}
+		}
+
+		w.mut.Lock()
+		w.alerted[status.Loop] = status.Stale
+		w.mut.Unlock()
+	}
+	return true
+}
+
+// newReadyHandler reports the resync watermarks. It only fails when they can't be read.
+func (w *watermarks) newReadyHandler() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		statuses, err := w.Status(r.Context(), time.Now())
+		if err != nil {
+			log.Printf("error while reading resync watermarks: %s", err)
+			http.Error(rw, "unable to read resync watermarks", http.StatusServiceUnavailable)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(map[string]any{"resyncs": statuses})
+	}
+}
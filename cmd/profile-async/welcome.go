@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/time/rate"
+
+	"github.com/TheLab-ms/profile/internal/chatbot"
+	"github.com/TheLab-ms/profile/internal/conf"
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/email"
+	"github.com/TheLab-ms/profile/internal/emailtmpl"
+	"github.com/TheLab-ms/profile/internal/flowcontrol"
+	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/reporting"
+	"github.com/TheLab-ms/profile/internal/welcome"
+)
+
+// Signup emails are rate limited in separate lanes so members who just signed up (webhooks) aren't stuck behind
+// everyone picked up by a bulk resync. The resync lane doesn't block the worker: emails over its limit are deferred
+// and retried with the queue's exponential backoff.
+var (
+	webhookSignupEmails = &signupEmailLane{name: "webhook", limiter: rate.NewLimiter(rate.Every(time.Second*2), 5), wait: true}
+	resyncSignupEmails  = &signupEmailLane{name: "resync", limiter: rate.NewLimiter(rate.Every(time.Second*10), 1)}
+)
+
+var errSignupEmailDeferred = errors.New("signup email rate limit reached, will retry")
+
+var (
+	signupEmailQueueAge = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "profile_signup_email_queue_age_seconds",
+		Help:    "Time between a user being enqueued for the welcome sequence and their signup email being sent",
+		Buckets: []float64{1, 5, 15, 30, 60, 300, 900, 3600, 14400},
+	}, []string{"lane"})
+	signupEmailDeferrals = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "profile_signup_email_deferrals_total",
+		Help: "Signup emails put back in the queue because their lane's rate limit was reached",
+	}, []string{"lane"})
+)
+
+type signupEmailLane struct {
+	name    string
+	limiter *rate.Limiter
+	wait    bool // block until the limiter allows it instead of deferring
+}
+
+// signupEmailLaneFor picks the lane based on the welcome queue priority of the user's item.
+func signupEmailLaneFor(priority flowcontrol.Priority) *signupEmailLane {
+	if priority > flowcontrol.PriorityLow {
+		return webhookSignupEmails
+	}
+	return resyncSignupEmails
+}
+
+func (l *signupEmailLane) acquire(ctx context.Context) error {
+	if l.wait {
+		return l.limiter.Wait(ctx)
+	}
+	if !l.limiter.Allow() {
+		signupEmailDeferrals.WithLabelValues(l.name).Inc()
+		return errSignupEmailDeferred
+	}
+	return nil
+}
+
+type signupEmailLaneKey struct{}
+
+type signupEmailLaneValue struct {
+	lane     *signupEmailLane
+	enqueued time.Time
+}
+
+// withSignupEmailLane sets the lane that signup emails sent with the context are limited by.
+func withSignupEmailLane(ctx context.Context, lane *signupEmailLane, enqueued time.Time) context.Context {
+	return context.WithValue(ctx, signupEmailLaneKey{}, &signupEmailLaneValue{lane: lane, enqueued: enqueued})
+}
+
+func signupEmailLaneFromContext(ctx context.Context) *signupEmailLaneValue {
+	if v, ok := ctx.Value(signupEmailLaneKey{}).(*signupEmailLaneValue); ok {
+		return v
+	}
+	return &signupEmailLaneValue{lane: resyncSignupEmails}
+}
+
+// newWelcomeSequence defines the onboarding steps for new members.
+// Steps that aren't configured are left out.
+func newWelcomeSequence(env *conf.Env, kc *keycloak.Keycloak[*datamodel.User], bot *chatbot.Bot, sender *email.Sender) *welcome.Sequence {
+	seq := &welcome.Sequence{}
+
+	// Don't send email if we somehow didn't become aware of the user until >24hr after signup time.
+	seq.Steps = append(seq.Steps, &welcome.Step{
+		Name:   "signupEmail",
+		Window: time.Hour * 24,
+		Run: func(ctx context.Context, user *datamodel.User) error {
+			// Send emails at least once while avoiding duplicates when possible
+			if user.SignupEmailSentTime.After(time.Unix(0, 0)) {
+				return nil
+			}
+
+			lane := signupEmailLaneFromContext(ctx)
+			if err := lane.lane.acquire(ctx); err != nil {
+				return err
+			}
+			err := kc.SendSignupEmail(ctx, user.UUID)
+			if err != nil {
+				return err
+			}
+			if !lane.enqueued.IsZero() {
+				signupEmailQueueAge.WithLabelValues(lane.lane.name).Observe(time.Since(lane.enqueued).Seconds())
+			}
+			reporting.DefaultSink.Eventf(user.Email, "SignupEmailSent", "sent initial password reset email to new user")
+			user.SignupEmailSentTime = time.Now()
+			return nil
+		},
+	})
+
+	if env.WelcomeOrientationURL != "" && sender.Enabled() {
+		seq.Steps = append(seq.Steps, &welcome.Step{
+			Name:   "orientationEmail",
+			After:  env.WelcomeOrientationDelay,
+			Window: time.Hour * 48,
+			Run: func(ctx context.Context, user *datamodel.User) error {
+				if user.FobID != 0 {
+					return nil // fobs are handed out at orientation so they must have already attended
+				}
+				err := sender.SendNotification(ctx, user, datamodel.NotifyCommunity, "orientation", &emailtmpl.Orientation{URL: env.WelcomeOrientationURL})
+				if errors.Is(err, email.ErrOptedOut) {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				reporting.DefaultSink.Eventf(user.Email, "WelcomeOrientationEmailSent", "sent orientation reminder to new member")
+				return nil
+			},
+		})
+	}
+
+	if env.DiscordInviteURL != "" {
+		seq.Steps = append(seq.Steps, &welcome.Step{
+			Name:   "discordInvite",
+			After:  env.WelcomeDiscordDelay,
+			Window: time.Hour * 48,
+			Run: func(ctx context.Context, user *datamodel.User) error {
+				// Members who have linked their account are already in the server, so DM them.
+				// Otherwise fall back to email since there's no way to reach them on Discord yet.
+				if user.DiscordUserID != 0 && user.WantsNotification(datamodel.NotifyCommunity, datamodel.ChannelDiscord) {
+					err := bot.SendDM(ctx, user.DiscordUserID, fmt.Sprintf("Welcome to TheLab! Share the server with friends using %s", env.DiscordInviteURL))
+					if err != nil {
+						return err
+					}
+				} else if sender.Enabled() {
+					err := sender.SendNotification(ctx, user, datamodel.NotifyCommunity, "discordInvite", &emailtmpl.DiscordInvite{URL: env.DiscordInviteURL})
+					if errors.Is(err, email.ErrOptedOut) {
+						return nil
+					}
+					if err != nil {
+						return err
+					}
+				} else {
+					return nil
+				}
+				reporting.DefaultSink.Eventf(user.Email, "WelcomeDiscordInviteSent", "sent discord invite to new member")
+				return nil
+			},
+		})
+	}
+
+	return seq
+}
+
+func handleWelcomeSequence(ctx context.Context, kc *keycloak.Keycloak[*datamodel.User], seq *welcome.Sequence, userID string) error {
+	user, err := kc.GetUser(ctx, userID)
+	if errors.Is(keycloak.ErrNotFound, err) {
+		return nil // ignore any users that have been deleted since being enqueued
+	}
+	if err != nil {
+		return fmt.Errorf("getting user: %w", err)
+	}
+
+	changed, err := seq.Advance(ctx, user, time.Now())
+	if recordEmailVerification(user, time.Now()) {
+		changed = true
+	}
+	if changed {
+		// Persist progress even if a later step failed to avoid repeating earlier ones
+		if err := kc.WriteUser(ctx, user); err != nil {
+			return fmt.Errorf("writing user: %w", err)
+		}
+	}
+	return err
+}
+
+// recordEmailVerification emits the signup funnel's email verification event the first time we see a verified new member.
+// Keycloak doesn't tell us when it happens, so this is as accurate as the resync interval.
+// Older accounts are skipped to avoid flooding the funnel with members who verified long ago.
+func recordEmailVerification(user *datamodel.User, now time.Time) bool {
+	if !user.EmailVerified || !user.EmailVerifiedTime.IsZero() || now.Sub(user.SignupTime) > time.Hour*24*7 {
+		return false
+	}
+	user.EmailVerifiedTime = now
+	reporting.DefaultSink.Eventf(user.Email, "EmailVerified", "user verified their email address")
+	return true
+}
@@ -2,31 +2,36 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/stripe/stripe-go/v78"
-
+	"github.com/TheLab-ms/profile/internal/access"
+	"github.com/TheLab-ms/profile/internal/app"
 	"github.com/TheLab-ms/profile/internal/chatbot"
 	"github.com/TheLab-ms/profile/internal/conf"
-	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/email"
 	"github.com/TheLab-ms/profile/internal/events"
-	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/flags"
+	"github.com/TheLab-ms/profile/internal/jobs"
+	"github.com/TheLab-ms/profile/internal/notify"
 	"github.com/TheLab-ms/profile/internal/payment"
 	"github.com/TheLab-ms/profile/internal/paypal"
 	"github.com/TheLab-ms/profile/internal/reporting"
 	"github.com/TheLab-ms/profile/internal/server"
+	"github.com/TheLab-ms/profile/internal/telemetry"
+	"github.com/TheLab-ms/profile/internal/waitlist"
 )
 
 func main() {
-	// Load the app's configuration from env vars bound to the config struct through magic
-	env := &conf.Env{}
-	env.MustLoad()
-
-	// Stripe library (sadly) stores its creds in a global var
-	stripe.Key = env.StripeKey
+	// Load the app's configuration from env vars bound to the config struct through magic, and the clients shared
+	// with the other binaries
+	a, err := app.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	env, kc := a.Env, a.Keycloak
 
 	// We use the Age CLI to encrypt/decrypt secrets
 	// It requires the private key to be on disk, so we write it out from the config here.
@@ -38,42 +43,78 @@ func main() {
 
 	// Price cache polls Stripe to load the configured prices, and is refreshed when they change (via webhook)
 	ctx := context.TODO()
-	priceCache := payment.NewPriceCache()
-	go priceCache.Run(ctx)
+	priceCache := payment.NewPriceCache(env.StripeProducts)
 
-	kc := keycloak.New[*datamodel.User](env)
+	// Work that shouldn't be duplicated across replicas runs on the leader
+	leader := reporting.DefaultSink.NewLeadership("profile-server")
+	go leader.Run(ctx)
+	go reporting.DefaultSink.RunMemberMetricsLoop(ctx, leader)
 
-	// Reporting allows meaningful actions taken by users to be stored somewhere for reference
-	var err error
-	reporting.DefaultSink, err = reporting.NewSink(env, kc)
-	if err != nil {
-		log.Fatal(err)
-	}
-	kc.Sink = reporting.DefaultSink
-	go reporting.DefaultSink.RunMemberMetricsLoop(ctx)
+	// Only one replica refreshes the shared caches per interval
+	priceCache.Coordinator = reporting.DefaultSink
+	go priceCache.Run(ctx)
+
+	// Feature flags are stored in the reporting db and polled for changes
+	featureFlags := flags.New(reporting.DefaultSink)
+	go featureFlags.Run(ctx)
 
 	bot, err := chatbot.NewBot(env)
 	if err != nil {
 		panic(err)
 	}
+	bot.AddLinkCommand()
 	bot.Start(ctx)
 
 	// Events cache polls a the Discord scheduled events API to feed the calendar API.
 	eventsCache := events.NewCache(env)
+	eventsCache.Coordinator = reporting.DefaultSink
 	go eventsCache.Run(ctx)
 
+	// Door controllers are served from an in-memory allowlist
+	var accessCache *access.Cache
+	if env.AccessControllerToken != "" {
+		accessCache = access.NewCache(kc, env.AccessCacheInterval)
+		accessCache.RequireWaiver = env.AccessWaiverPolicy == conf.WaiverPolicyDeny
+		go accessCache.Run(ctx)
+	}
+
+	// Webhooks invalidate the user cache and access allowlist
+	if env.KeycloakRegisterWebhook {
+		err = kc.EnsureWebhook(ctx, fmt.Sprintf("%s/webhooks/keycloak", env.SelfURL), env.KeycloakWebhookSecret)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// New signups are held on a waitlist once the member cap is reached
+	waitlistGate := waitlist.NewGate(kc, reporting.DefaultSink, env.MemberCap, env.MemberCapInterval)
+	if env.MemberCap > 0 {
+		go waitlistGate.Run(ctx)
+	}
+
 	// Serve prometheus metrics on a separate port
 	go func() {
-		log.Fatal(http.ListenAndServe(":8081", promhttp.Handler()))
+		log.Fatal(telemetry.ListenAndServe(telemetry.NewMux("profile-server")))
 	}()
 
 	// Run the main http server
+	sender := email.NewSender(env)
+	paypalClient := paypal.NewClient(env)
+	notifier := notify.New(bot, sender)
 	svr := &server.Server{
 		Env:         env,
 		Keycloak:    kc,
-		Paypal:      paypal.NewClient(env),
+		Paypal:      paypalClient,
+		Stripe:      payment.NewStripeClient(),
 		PriceCache:  priceCache,
+		Balances:    payment.NewBalanceCache(env.StripeBalanceTTL),
 		EventsCache: eventsCache,
+		Email:       sender,
+		Flags:       featureFlags,
+		Access:      accessCache,
+		Waitlist:    waitlistGate,
+		Notify:      notifier,
+		Jobs:        jobs.NewRunner(&jobs.Deps{Env: env, Keycloak: kc, Paypal: paypalClient, Notifier: notifier}),
 	}
 	log.Fatal(http.ListenAndServe(":8080", svr.NewHandler()))
 }
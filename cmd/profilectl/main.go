@@ -0,0 +1,267 @@
+// profilectl is a maintenance tool for leadership, for the changes that don't have a place in the admin UI.
+// It uses the same configuration as the other services and records every change in the reporting database.
+//
+//	profilectl [-actor name] <command> [args...]
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/TheLab-ms/profile/internal/app"
+	"github.com/TheLab-ms/profile/internal/conf"
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+// mergeUndoWindow is how long a merged duplicate account can be restored before profile-async deletes it.
+const mergeUndoWindow = time.Hour * 24
+
+type command struct {
+	Usage string
+	Args  int
+	Run   func(ctx context.Context, c *cli, args []string) error
+}
+
+var commands = map[string]*command{
+	"get-user":      {Usage: "get-user <email>", Args: 1, Run: getUser},
+	"set-attribute": {Usage: "set-attribute <email> <key> <value> (an empty value removes the attribute)", Args: 3, Run: setAttribute},
+	"resync":        {Usage: "resync <email>", Args: 1, Run: resync},
+	"deactivate":    {Usage: "deactivate <email>", Args: 1, Run: deactivate},
+	"merge":         {Usage: "merge <duplicate email> <primary email>", Args: 2, Run: merge},
+	"export":        {Usage: "export (writes every user to stdout as JSON lines)", Args: 0, Run: export},
+}
+
+type cli struct {
+	Env      *conf.Env
+	Keycloak *keycloak.Keycloak[*datamodel.User]
+	Actor    string
+}
+
+func main() {
+	if err := run(); err != nil {
+		log.Printf("terminal error: %s", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	actor := flag.String("actor", os.Getenv("USER"), "who is making the change, for the audit log")
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[args[0]]
+	if !ok || len(args)-1 != cmd.Args {
+		usage()
+		os.Exit(2)
+	}
+	if *actor == "" {
+		return errors.New("-actor is required when $USER isn't set")
+	}
+
+	a, err := app.Load()
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	return cmd.Run(context.Background(), &cli{Env: a.Env, Keycloak: a.Keycloak, Actor: *actor}, args[1:])
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: profilectl [-actor name] <command> [args...]\n\ncommands:\n")
+	for _, name := range []string{"get-user", "set-attribute", "resync", "deactivate", "merge", "export"} {
+		fmt.Fprintf(os.Stderr, "  %s\n", commands[name].Usage)
+	}
+}
+
+// audit logs a change and records it in the reporting database.
+func (c *cli) audit(email, reason, templ string, args ...any) {
+	msg := fmt.Sprintf(templ, args...)
+	log.Printf("%s: %s", email, msg)
+	reporting.DefaultSink.Eventf(email, reason, "%s (by %s using profilectl)", msg, c.Actor)
+}
+
+func (c *cli) getUser(ctx context.Context, email string) (*datamodel.User, error) {
+	user, err := c.Keycloak.GetUserByEmail(ctx, email)
+	if errors.Is(err, keycloak.ErrNotFound) {
+		return nil, fmt.Errorf("no account exists for %s", email)
+	}
+	return user, err
+}
+
+func getUser(ctx context.Context, c *cli, args []string) error {
+	user, err := c.getUser(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	extended, err := c.Keycloak.ExtendUser(ctx, user, user.UUID)
+	if err != nil {
+		return fmt.Errorf("getting group membership: %w", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(extended)
+}
+
+func setAttribute(ctx context.Context, c *cli, args []string) error {
+	user, err := c.getUser(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	key, val := args[1], args[2]
+	if err := c.Keycloak.SetAttribute(ctx, user.UUID, key, val); err != nil {
+		return fmt.Errorf("setting attribute: %w", err)
+	}
+	c.audit(user.Email, "AttributeChanged", "attribute %s set to %q", key, val)
+	return nil
+}
+
+// resync sends profile-async the same webhook Keycloak would, which resyncs the user with Discord, Conway, etc.
+func resync(ctx context.Context, c *cli, args []string) error {
+	if c.Env.WebhookURL == "" || c.Env.KeycloakWebhookSecret == "" {
+		return errors.New("WEBHOOK_URL and KEYCLOAK_WEBHOOK_SECRET are required to resync users")
+	}
+	user, err := c.getUser(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(map[string]any{"resourceType": "USER", "details": map[string]string{"userId": user.UUID}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.Env.WebhookURL+"/webhooks/keycloak", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(keycloak.SignatureHeader, keycloak.SignWebhook(body, c.Env.KeycloakWebhookSecret))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected webhook status: %d", resp.StatusCode)
+	}
+	log.Printf("enqueued %s for resync", user.Email)
+	return nil
+}
+
+func deactivate(ctx context.Context, c *cli, args []string) error {
+	user, err := c.getUser(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if err := c.Keycloak.Deactivate(ctx, user); err != nil {
+		return fmt.Errorf("deactivating: %w", err)
+	}
+	c.audit(user.Email, "MembershipDeactivated", "membership deactivated")
+	return nil
+}
+
+// merge copies the attributes of a duplicate account that the primary account is missing, then deactivates the
+// duplicate and schedules it for deletion. The deletion can be undone from the admin UI like any other.
+func merge(ctx context.Context, c *cli, args []string) error {
+	if !reporting.DefaultSink.Enabled() {
+		return errors.New("merging requires the reporting database, so the duplicate's deletion can be undone")
+	}
+	dup, err := c.getUser(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	primary, err := c.getUser(ctx, args[1])
+	if err != nil {
+		return err
+	}
+	if dup.UUID == primary.UUID {
+		return errors.New("can't merge an account into itself")
+	}
+
+	copied := mergeAttributes(primary, dup)
+	if err := c.Keycloak.WriteUser(ctx, primary); err != nil {
+		return fmt.Errorf("writing primary account: %w", err)
+	}
+
+	// Fobs are looked up by ID, so they can't be left on both accounts
+	if dup.FobID != 0 && dup.FobID == primary.FobID {
+		dup.FobID = 0
+		dup.BuildingAccessApprover = ""
+		if err := c.Keycloak.WriteUser(ctx, dup); err != nil {
+			return fmt.Errorf("writing duplicate account: %w", err)
+		}
+	}
+
+	// Same for Discord accounts e.g. the bot would otherwise see two members linked to the same account
+	if dup.DiscordUserID != 0 && dup.DiscordUserID == primary.DiscordUserID {
+		if err := c.Keycloak.PatchUserAttributes(ctx, dup.UUID, map[string]string{"discordUserID": ""}); err != nil {
+			return fmt.Errorf("unlinking Discord from duplicate account: %w", err)
+		}
+	}
+	if err := c.Keycloak.Deactivate(ctx, dup); err != nil {
+		return fmt.Errorf("deactivating duplicate account: %w", err)
+	}
+
+	now := time.Now()
+	_, err = reporting.DefaultSink.RecordAdminAction(ctx, &reporting.AdminAction{
+		Time:          now,
+		Actor:         c.Actor,
+		Email:         dup.Email,
+		UserID:        dup.UUID,
+		Kind:          reporting.AdminActionDeleteUser,
+		Previous:      "{}",
+		UndoExpiresAt: now.Add(mergeUndoWindow),
+	})
+	if err != nil {
+		return fmt.Errorf("scheduling deletion of duplicate account: %w", err)
+	}
+
+	c.audit(primary.Email, "AccountsMerged", "merged %s into this account (copied %s)", dup.Email, strings.Join(copied, ", "))
+	c.audit(dup.Email, "AccountsMerged", "merged into %s - this account will be deleted in %s", primary.Email, mergeUndoWindow)
+	return nil
+}
+
+// mergeAttributes copies attributes that are set on the duplicate but not the primary, returning the names of the copied fields.
+// Identity fields (id, email, etc.) always come from the primary.
+func mergeAttributes(primary, dup *datamodel.User) []string {
+	copied := []string{}
+	pv := reflect.ValueOf(primary).Elem()
+	dv := reflect.ValueOf(dup).Elem()
+	for i := 0; i < pv.NumField(); i++ {
+		field := pv.Type().Field(i)
+		if !strings.HasPrefix(field.Tag.Get("keycloak"), "attr.") {
+			continue
+		}
+		if pv.Field(i).IsZero() && !dv.Field(i).IsZero() {
+			pv.Field(i).Set(dv.Field(i))
+			copied = append(copied, field.Name)
+		}
+	}
+	return copied
+}
+
+func export(ctx context.Context, c *cli, args []string) error {
+	enc := json.NewEncoder(os.Stdout)
+	return c.Keycloak.ListUsersStream(ctx, func(user *keycloak.ExtendedUser[*datamodel.User]) error {
+		return enc.Encode(user)
+	})
+}
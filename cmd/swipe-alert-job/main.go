@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/TheLab-ms/profile/internal/app"
+	"github.com/TheLab-ms/profile/internal/chatbot"
+	"github.com/TheLab-ms/profile/internal/conf"
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/reporting"
+	"github.com/TheLab-ms/profile/internal/swipealert"
+	"github.com/TheLab-ms/profile/internal/urls"
+)
+
+// Discord rejects messages longer than 2000 characters
+const linesPerMessage = 10
+
+func main() {
+	if err := run(); err != nil {
+		log.Printf("terminal error: %s", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	a, err := app.Load()
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	env, kc := a.Env, a.Keycloak
+	if env.SwipeAlertWebhook == "" {
+		return errors.New("SWIPE_ALERT_WEBHOOK is required")
+	}
+
+	loc, err := time.LoadLocation(env.SpaceTimezone)
+	if err != nil {
+		return fmt.Errorf("loading space timezone: %w", err)
+	}
+
+	ctx := context.Background()
+
+	if !reporting.DefaultSink.Enabled() {
+		return errors.New("the reporting database is required")
+	}
+
+	lastID, err := reporting.DefaultSink.LastSwipeAlertCheckpoint(ctx)
+	if err != nil {
+		return fmt.Errorf("getting checkpoint: %w", err)
+	}
+
+	// Don't dig up ancient history if the job hasn't run in a while
+	swipes, err := reporting.DefaultSink.ListSwipes(ctx, lastID, time.Now().Add(-time.Hour*24))
+	if err != nil {
+		return fmt.Errorf("listing swipes: %w", err)
+	}
+	if len(swipes) == 0 {
+		log.Printf("no new swipes since %d", lastID)
+		return nil
+	}
+
+	users, err := kc.ListUsers(ctx)
+	if err != nil {
+		return fmt.Errorf("listing users: %w", err)
+	}
+	fobs := map[int]*datamodel.User{}
+	for _, extended := range users {
+		if extended.User.FobID != 0 {
+			fobs[extended.User.FobID] = extended.User
+		}
+	}
+
+	detector := &swipealert.Detector{
+		Location:   loc,
+		Schedules:  env.AccessSchedules,
+		MaxPerHour: env.SwipeAlertMaxPerHour,
+	}
+	anomalies := detector.Detect(swipes, fobs)
+	log.Printf("found %d anomalies in %d swipes", len(anomalies), len(swipes))
+
+	lines := []string{}
+	for _, anomaly := range anomalies {
+		lines = append(lines, formatAnomaly(env, loc, anomaly))
+	}
+	for i := 0; i < len(lines); i += linesPerMessage {
+		end := i + linesPerMessage
+		if end > len(lines) {
+			end = len(lines)
+		}
+		msg := "**Unusual fob swipes**\n" + strings.Join(lines[i:end], "\n")
+		if err := chatbot.PostWebhook(ctx, env.SwipeAlertWebhook, msg); err != nil {
+			return fmt.Errorf("posting alert: %w", err)
+		}
+	}
+
+	if err := reporting.DefaultSink.RecordSwipeAlertCheckpoint(ctx, swipes[len(swipes)-1].ID); err != nil {
+		return fmt.Errorf("recording checkpoint: %w", err)
+	}
+
+	time.Sleep(time.Second) // event buffer flush
+	return nil
+}
+
+func formatAnomaly(env *conf.Env, loc *time.Location, anomaly *swipealert.Anomaly) string {
+	line := fmt.Sprintf("- %s: %s", anomaly.Swipe.Time.In(loc).Format("Mon Jan 2 3:04pm"), anomaly.Reason)
+	if anomaly.User == nil {
+		return line
+	}
+	return fmt.Sprintf("%s (%s %s - <%s>)", line, anomaly.User.First, anomaly.User.Last, urls.AdminMember(env, anomaly.User.Email))
+}
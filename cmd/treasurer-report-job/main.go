@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/TheLab-ms/profile/internal/app"
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/email"
+	"github.com/TheLab-ms/profile/internal/emailtmpl"
+	"github.com/TheLab-ms/profile/internal/payment"
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+func main() {
+	if err := run(); err != nil {
+		log.Printf("terminal error: %s", err)
+		os.Exit(1)
+	}
+}
+
+// run emails the treasurer a report covering the previous calendar month.
+// It's meant to be scheduled shortly after the start of each month.
+func run() error {
+	a, err := app.Load()
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	env, kc := a.Env, a.Keycloak
+
+	sender := email.NewSender(env)
+	if env.TreasurerEmail == "" || !sender.Enabled() {
+		return errors.New("TREASURER_EMAIL and SMTP must be configured")
+	}
+	loc, err := time.LoadLocation(env.SpaceTimezone)
+	if err != nil {
+		return fmt.Errorf("loading space timezone: %w", err)
+	}
+
+	ctx := context.Background()
+
+	users, err := kc.ListUsers(ctx)
+	if err != nil {
+		return fmt.Errorf("listing users: %w", err)
+	}
+	active := []*datamodel.User{}
+	for _, extended := range users {
+		if extended.ActiveMember {
+			active = append(active, extended.User)
+		}
+	}
+
+	subs, err := payment.ListSubscriptions(ctx)
+	if err != nil {
+		return fmt.Errorf("listing subscriptions: %w", err)
+	}
+
+	now := time.Now().In(loc)
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc).AddDate(0, -1, 0)
+	report := payment.BuildTreasurerReport(start, subs, active)
+
+	subject, html, err := emailtmpl.Render("treasurerReport", &emailtmpl.TreasurerReport{
+		Month:                 start.Format("January 2006"),
+		ActiveSubscriptions:   report.ActiveSubscriptions,
+		NewSubscriptions:      report.NewSubscriptions,
+		CanceledSubscriptions: report.CanceledSubscriptions,
+		PaypalStragglers:      len(report.PaypalStragglers),
+	})
+	if err != nil {
+		return err
+	}
+	err = sender.Send(ctx, env.TreasurerEmail, subject, html, &email.Attachment{
+		Name:        fmt.Sprintf("membership-%s.csv", start.Format("2006-01")),
+		ContentType: "text/csv",
+		Data:        report.CSV(),
+	})
+	if err != nil {
+		return fmt.Errorf("sending report: %w", err)
+	}
+
+	log.Printf("sent treasurer report for %s", start.Format("2006-01"))
+	reporting.DefaultSink.Eventf("", "TreasurerReportSent", "sent membership report for %s", start.Format("2006-01"))
+	time.Sleep(time.Second) // event buffer flush
+	return nil
+}
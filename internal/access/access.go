@@ -0,0 +1,432 @@
+// Package access decides which key fobs are allowed into the building.
+// Decisions are served from memory so door controllers get fast responses even when Keycloak is slow.
+package access
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"log"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/flowcontrol"
+	"github.com/TheLab-ms/profile/internal/keycloak"
+)
+
+// Cache holds the set of fobs that currently have building access.
+// The full set is rebuilt periodically, and individual users are refreshed as they change (see InvalidateUser).
+type Cache struct {
+	flowcontrol.Loop
+	kc     *keycloak.Keycloak[*datamodel.User]
+	queue  *flowcontrol.Queue[string]
+	synced chan struct{}
+
+	// RequireWaiver denies access to members who haven't signed the waiver.
+	// Otherwise their fobs are allowed, and WaiverSigned can be used to flag them.
+	RequireWaiver bool
+
+	mut       sync.RWMutex
+	fobs      map[int]string // fob ID -> user ID
+	users     map[string]int // user ID -> fob ID
+	tiers     map[int]string // fob ID -> membership tier
+	lastBuilt time.Time
+
+	// Allowlist versioning so door controllers can fetch only what changed (see AllowlistSince).
+	// The high bits of each version identify this cache (see versionEpoch), so versions handed out by another replica or
+	// before a restart always get the full list.
+	version  int64
+	minDelta int64         // oldest version that deltas can still be computed from
+	changed  map[int]int64 // fob ID -> version it was granted access or changed tier
+	removed  map[int]int64 // fob ID -> version it lost access
+
+	// Holders of every assigned fob, including the ones without access
+	holders    map[int]*Holder // fob ID -> holder
+	holderFobs map[string]int  // user ID -> fob ID
+
+	// Keypad PINs of every user that has one, including the ones without access (see HashPIN)
+	pins     map[string]string // PIN hash -> user ID
+	userPINs map[string]string // user ID -> PIN hash
+}
+
+// Holder is whoever a fob is assigned to, for annotating swipe logs without looking up each fob in Keycloak.
+type Holder struct {
+	Name         string
+	Access       bool // false for fobs that don't currently open the door e.g. lapsed members
+	WaiverSigned bool
+}
+
+func NewCache(kc *keycloak.Keycloak[*datamodel.User], interval time.Duration) *Cache {
+	c := &Cache{kc: kc, queue: flowcontrol.NewQueue[string](), synced: make(chan struct{})}
+	c.Loop.Handler = flowcontrol.RetryHandler(interval, c.rebuild)
+	return c
+}
+
+// Run rebuilds the cache periodically and processes invalidations until the context is canceled.
+func (c *Cache) Run(ctx context.Context) {
+	go c.queue.Run(ctx)
+	go flowcontrol.RunWorker(ctx, c.queue, func(userID string) error {
+		return c.refreshUser(ctx, userID)
+	})
+	c.Loop.Run(ctx)
+}
+
+// Synced returns true once the cache has been fully built at least once.
+func (c *Cache) Synced() bool {
+	select {
+	case <-c.synced:
+		return true
+	default:
+		return false
+	}
+}
+
+// Allowed returns true if the given fob should be able to open the door.
+func (c *Cache) Allowed(fobID int) bool {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	_, ok := c.fobs[fobID]
+	return ok
+}
+
+// Tier returns the membership tier of the fob's holder, or false if the fob doesn't have access at all.
+// Access is further restricted to the tier's schedule (see conf.AccessSchedules).
+func (c *Cache) Tier(fobID int) (string, bool) {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	if _, ok := c.fobs[fobID]; !ok {
+		return "", false
+	}
+	return c.tiers[fobID], true
+}
+
+// WaiverSigned returns false if the fob's holder hasn't signed the waiver.
+func (c *Cache) WaiverSigned(fobID int) bool {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	holder, ok := c.holders[fobID]
+	return ok && holder.WaiverSigned
+}
+
+// Allowlist returns every fob that currently has access, sorted, along with the time the cache was last fully rebuilt.
+func (c *Cache) Allowlist() ([]int, time.Time) {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	list := make([]int, 0, len(c.fobs))
+	for id := range c.fobs {
+		list = append(list, id)
+	}
+	sort.Ints(list)
+	return list, c.lastBuilt
+}
+
+// AllowlistEntry is a fob with building access, and the tier that determines its schedule.
+type AllowlistEntry struct {
+	FobID int    `json:"fob"`
+	Tier  string `json:"tier"`
+}
+
+// AllowlistDelta describes changes to the allowlist since a previous version.
+// Full is set when the previous version is too old (or zero), in which case Fobs is the entire allowlist.
+type AllowlistDelta struct {
+	Version int64
+	Full    bool
+	Fobs    []*AllowlistEntry // granted access or changed tier
+	Removed []int
+	Built   time.Time
+}
+
+// maxTombstones bounds how many revoked fobs are remembered for deltas.
+const maxTombstones = 10000
+
+// AllowlistSince returns the allowlist changes after the given version.
+func (c *Cache) AllowlistSince(since int64) *AllowlistDelta {
+	c.mut.Lock() // may initialize the version
+	defer c.mut.Unlock()
+	c.initVersion()
+
+	full := since>>epochShift != c.version>>epochShift || since < c.minDelta || since > c.version
+	d := &AllowlistDelta{Version: c.version, Full: full, Fobs: []*AllowlistEntry{}, Removed: []int{}, Built: c.lastBuilt}
+	for id := range c.fobs {
+		if d.Full || c.changed[id] > since {
+			d.Fobs = append(d.Fobs, &AllowlistEntry{FobID: id, Tier: c.tiers[id]})
+		}
+	}
+	if !d.Full {
+		for id, v := range c.removed {
+			if v > since {
+				d.Removed = append(d.Removed, id)
+			}
+		}
+	}
+	sort.Slice(d.Fobs, func(i, j int) bool { return d.Fobs[i].FobID < d.Fobs[j].FobID })
+	sort.Ints(d.Removed)
+	return d
+}
+
+// Holders returns whoever each of the given fobs is assigned to. Unassigned fobs are left out.
+func (c *Cache) Holders(fobIDs []int) map[int]*Holder {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	holders := map[int]*Holder{}
+	for _, id := range fobIDs {
+		if h, ok := c.holders[id]; ok {
+			holders[id] = h
+		}
+	}
+	return holders
+}
+
+// PINTier returns the membership tier of the member with the given PIN hash, or false if the PIN doesn't have access.
+// Like fobs, access is further restricted to the tier's schedule.
+func (c *Cache) PINTier(hash string) (string, bool) {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	fobID, ok := c.users[c.pins[hash]]
+	if !ok || hash == "" {
+		return "", false
+	}
+	return c.tiers[fobID], true
+}
+
+// PINTaken returns true if the PIN hash belongs to someone other than the given user.
+// PINs identify the member at the door, so they have to be unique.
+func (c *Cache) PINTaken(hash, userID string) bool {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	owner, ok := c.pins[hash]
+	return ok && owner != userID
+}
+
+// InvalidateUser schedules the user's access to be re-evaluated from Keycloak.
+// Call it whenever something that affects building access changes e.g. fob assignment, group membership.
+func (c *Cache) InvalidateUser(userID string) {
+	if c == nil {
+		return
+	}
+	c.queue.Add(userID)
+}
+
+func (c *Cache) rebuild(ctx context.Context) bool {
+	fobs := map[int]string{}
+	users := map[string]int{}
+	tiers := map[int]string{}
+	holders := map[int]*Holder{}
+	holderFobs := map[string]int{}
+	pins := map[string]string{}
+	userPINs := map[string]string{}
+	err := c.kc.ListUsersStream(ctx, func(extended *keycloak.ExtendedUser[*datamodel.User]) error {
+		access := c.userHasAccess(extended.User, extended.ActiveMember)
+		if access {
+			fobs[extended.User.FobID] = extended.User.UUID
+			users[extended.User.UUID] = extended.User.FobID
+			tiers[extended.User.FobID] = extended.User.Tier()
+		}
+		if extended.User.FobID != 0 {
+			holders[extended.User.FobID] = newHolder(extended.User, access)
+			holderFobs[extended.User.UUID] = extended.User.FobID
+		}
+		if extended.User.DoorPINHash != "" {
+			pins[extended.User.DoorPINHash] = extended.User.UUID
+			userPINs[extended.User.UUID] = extended.User.DoorPINHash
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("error while listing users to build the access cache: %s", err)
+		return false
+	}
+
+	c.mut.Lock()
+	c.initVersion()
+	for id, tier := range tiers {
+		if prev, ok := c.tiers[id]; !ok || prev != tier {
+			c.touch(id, true)
+		}
+	}
+	for id := range c.fobs {
+		if _, ok := fobs[id]; !ok {
+			c.touch(id, false)
+		}
+	}
+	c.fobs = fobs
+	c.users = users
+	c.tiers = tiers
+	c.holders = holders
+	c.holderFobs = holderFobs
+	c.pins = pins
+	c.userPINs = userPINs
+	c.lastBuilt = time.Now()
+	c.mut.Unlock()
+
+	if !c.Synced() {
+		close(c.synced)
+	}
+	log.Printf("rebuilt access cache with %d fobs", len(fobs))
+	return true
+}
+
+func (c *Cache) refreshUser(ctx context.Context, userID string) error {
+	var fobID int
+	var tier string
+	var holder *Holder
+	var pin string
+	user, err := c.kc.GetUser(ctx, userID)
+	if err != nil && !errors.Is(err, keycloak.ErrNotFound) {
+		return err
+	}
+	if user != nil {
+		extended, err := c.kc.ExtendUser(ctx, user, userID)
+		if err != nil && !errors.Is(err, keycloak.ErrNotFound) {
+			return err
+		}
+		access := extended != nil && c.userHasAccess(user, extended.ActiveMember)
+		if access {
+			fobID = user.FobID
+			tier = user.Tier()
+		}
+		if user.FobID != 0 {
+			holder = newHolder(user, access)
+		}
+		pin = user.DoorPINHash
+	}
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.set(userID, fobID, tier)
+	c.setHolder(userID, user, holder)
+	c.setPIN(userID, pin)
+	return nil
+}
+
+// set updates the cache to reflect the user's current fob and tier (or lack of access when fobID == 0).
+// Callers must hold the lock.
+func (c *Cache) set(userID string, fobID int, tier string) {
+	if c.fobs == nil {
+		c.fobs = map[int]string{}
+		c.users = map[string]int{}
+		c.tiers = map[int]string{}
+	}
+	c.initVersion()
+	prevTier, hadFob := c.tiers[fobID]
+	if prev, ok := c.users[userID]; ok {
+		delete(c.fobs, prev)
+		delete(c.tiers, prev)
+		delete(c.users, userID)
+		if prev != fobID {
+			c.touch(prev, false)
+		}
+	}
+	if fobID != 0 {
+		if !hadFob || prevTier != tier {
+			c.touch(fobID, true)
+		}
+		c.fobs[fobID] = userID
+		c.users[userID] = fobID
+		c.tiers[fobID] = tier
+	}
+}
+
+// epochShift leaves room for 2^32 changes per cache.
+const epochShift = 32
+
+// versionEpoch returns a random positive number to identify a cache's allowlist versions.
+func versionEpoch() int64 {
+	n, err := rand.Int(rand.Reader, big.NewInt(math.MaxInt32))
+	if err != nil {
+		panic(err) // crypto/rand doesn't fail on supported platforms
+	}
+	return n.Int64() + 1
+}
+
+func (c *Cache) initVersion() {
+	if c.version != 0 {
+		return
+	}
+	c.version = versionEpoch() << epochShift
+	c.minDelta = c.version
+	c.changed = map[int]int64{}
+	c.removed = map[int]int64{}
+}
+
+// touch records a change to the fob's access under a new allowlist version.
+// Callers must hold the lock.
+func (c *Cache) touch(fobID int, allowed bool) {
+	c.version++
+	if allowed {
+		c.changed[fobID] = c.version
+		delete(c.removed, fobID)
+		return
+	}
+	delete(c.changed, fobID)
+	c.removed[fobID] = c.version
+	if len(c.removed) <= maxTombstones {
+		return
+	}
+
+	// Forget the oldest revocation - controllers that haven't synced since then get the full list
+	oldest, oldestVersion := 0, c.version
+	for id, v := range c.removed {
+		if v < oldestVersion {
+			oldest, oldestVersion = id, v
+		}
+	}
+	delete(c.removed, oldest)
+	c.minDelta = oldestVersion
+}
+
+// setHolder updates the holder of the user's fob, or removes it when holder is nil.
+// Callers must hold the lock.
+func (c *Cache) setHolder(userID string, user *datamodel.User, holder *Holder) {
+	if c.holders == nil {
+		c.holders = map[int]*Holder{}
+		c.holderFobs = map[string]int{}
+	}
+	if prev, ok := c.holderFobs[userID]; ok {
+		delete(c.holders, prev)
+		delete(c.holderFobs, userID)
+	}
+	if holder != nil {
+		c.holders[user.FobID] = holder
+		c.holderFobs[userID] = user.FobID
+	}
+}
+
+// setPIN updates the user's PIN hash, or removes it when empty.
+// Callers must hold the lock.
+func (c *Cache) setPIN(userID, hash string) {
+	if c.pins == nil {
+		c.pins = map[string]string{}
+		c.userPINs = map[string]string{}
+	}
+	if prev, ok := c.userPINs[userID]; ok {
+		if c.pins[prev] == userID {
+			delete(c.pins, prev)
+		}
+		delete(c.userPINs, userID)
+	}
+	if hash != "" {
+		c.pins[hash] = userID
+		c.userPINs[userID] = hash
+	}
+}
+
+func newHolder(user *datamodel.User, access bool) *Holder {
+	return &Holder{Name: strings.TrimSpace(user.First + " " + user.Last), Access: access, WaiverSigned: waiverSigned(user)}
+}
+
+// userHasAccess applies the cache's waiver policy on top of hasAccess.
+func (c *Cache) userHasAccess(user *datamodel.User, activeMember bool) bool {
+	return hasAccess(user, activeMember) && (!c.RequireWaiver || waiverSigned(user))
+}
+
+func hasAccess(user *datamodel.User, activeMember bool) bool {
+	return activeMember && user.FobID != 0 && user.BuildingAccessApprover != "" && !user.Banned
+}
+
+func waiverSigned(user *datamodel.User) bool { return user.WaiverState == "Signed" }
@@ -0,0 +1,192 @@
+package access
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheSet(t *testing.T) {
+	c := &Cache{}
+
+	c.set("user-1", 123, datamodel.TierStandard)
+	c.set("user-2", 234, datamodel.TierPremium)
+	assert.True(t, c.Allowed(123))
+	assert.True(t, c.Allowed(234))
+	assert.False(t, c.Allowed(345))
+
+	tier, ok := c.Tier(234)
+	assert.True(t, ok)
+	assert.Equal(t, datamodel.TierPremium, tier)
+
+	// Fob reassignment removes the old fob
+	c.set("user-1", 345, datamodel.TierStandard)
+	assert.False(t, c.Allowed(123))
+	assert.True(t, c.Allowed(345))
+	_, ok = c.Tier(123)
+	assert.False(t, ok)
+
+	// Revoked
+	c.set("user-2", 0, "")
+	assert.False(t, c.Allowed(234))
+
+	list, _ := c.Allowlist()
+	assert.Equal(t, []int{345}, list)
+}
+
+func TestHasAccess(t *testing.T) {
+	assert.True(t, hasAccess(&datamodel.User{FobID: 1, BuildingAccessApprover: "foo"}, true))
+	assert.False(t, hasAccess(&datamodel.User{FobID: 1, BuildingAccessApprover: "foo"}, false))
+	assert.False(t, hasAccess(&datamodel.User{FobID: 1}, true))
+	assert.False(t, hasAccess(&datamodel.User{BuildingAccessApprover: "foo"}, true))
+	assert.False(t, hasAccess(&datamodel.User{FobID: 1, BuildingAccessApprover: "foo", Banned: true}, true))
+}
+
+func TestCacheSetHolder(t *testing.T) {
+	c := &Cache{}
+
+	c.setHolder("user-1", &datamodel.User{FobID: 123}, &Holder{Name: "Ada Lovelace", Access: true})
+	c.setHolder("user-2", &datamodel.User{FobID: 234}, &Holder{Name: "Grace Hopper"})
+	holders := c.Holders([]int{123, 234, 345})
+	assert.Equal(t, map[int]*Holder{
+		123: {Name: "Ada Lovelace", Access: true},
+		234: {Name: "Grace Hopper"},
+	}, holders)
+
+	// Fob reassignment removes the old fob
+	c.setHolder("user-1", &datamodel.User{FobID: 345}, &Holder{Name: "Ada Lovelace", Access: true})
+	assert.Equal(t, []int{345}, holderIDs(c.Holders([]int{123, 345})))
+
+	// Unassigned
+	c.setHolder("user-2", &datamodel.User{}, nil)
+	assert.Empty(t, c.Holders([]int{234}))
+}
+
+func holderIDs(holders map[int]*Holder) []int {
+	ids := []int{}
+	for id := range holders {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestCachePIN(t *testing.T) {
+	c := &Cache{}
+	c.set("user-1", 123, datamodel.TierPremium)
+	c.setPIN("user-1", "hash-1")
+	c.setPIN("user-2", "hash-2") // no access
+
+	tier, ok := c.PINTier("hash-1")
+	assert.True(t, ok)
+	assert.Equal(t, datamodel.TierPremium, tier)
+	_, ok = c.PINTier("hash-2")
+	assert.False(t, ok)
+	_, ok = c.PINTier("")
+	assert.False(t, ok)
+
+	assert.True(t, c.PINTaken("hash-2", "user-1"))
+	assert.False(t, c.PINTaken("hash-2", "user-2"))
+	assert.False(t, c.PINTaken("hash-3", "user-1"))
+
+	// Changing the PIN releases the old one
+	c.setPIN("user-1", "hash-3")
+	_, ok = c.PINTier("hash-1")
+	assert.False(t, ok)
+	assert.False(t, c.PINTaken("hash-1", "user-2"))
+	_, ok = c.PINTier("hash-3")
+	assert.True(t, ok)
+
+	// Revoking access also revokes the PIN
+	c.set("user-1", 0, "")
+	_, ok = c.PINTier("hash-3")
+	assert.False(t, ok)
+}
+
+func TestValidatePIN(t *testing.T) {
+	for _, pin := range []string{"583920", "19283746", "102938"} {
+		assert.NoError(t, ValidatePIN(pin), pin)
+	}
+	for _, pin := range []string{"", "12345", "123456789", "58392a", "000000", "123456", "987654", "890123", "121212", "123123", "12341234"} {
+		assert.Error(t, ValidatePIN(pin), pin)
+	}
+}
+
+func TestGeneratePIN(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 20; i++ {
+		pin, err := GeneratePIN()
+		require.NoError(t, err)
+		assert.NoError(t, ValidatePIN(pin))
+		seen[pin] = true
+	}
+	assert.Greater(t, len(seen), 1)
+}
+
+func TestPINRotationDue(t *testing.T) {
+	now := time.Now()
+	rotation := time.Hour * 24 * 180
+	set := now.Add(-rotation - time.Hour)
+
+	assert.False(t, PINRotationDue(&datamodel.User{}, now, rotation))
+	assert.False(t, PINRotationDue(&datamodel.User{DoorPINHash: "hash", DoorPINSetTime: now.Add(-time.Hour)}, now, rotation))
+	assert.True(t, PINRotationDue(&datamodel.User{DoorPINHash: "hash", DoorPINSetTime: set}, now, rotation))
+	assert.False(t, PINRotationDue(&datamodel.User{DoorPINHash: "hash", DoorPINSetTime: set, DoorPINReminderTime: now.Add(-time.Minute)}, now, rotation))
+	assert.False(t, PINRotationDue(&datamodel.User{DoorPINHash: "hash", DoorPINSetTime: set}, now, 0))
+}
+
+func TestAllowlistSince(t *testing.T) {
+	c := &Cache{}
+	c.set("user-1", 123, datamodel.TierStandard)
+	c.set("user-2", 234, datamodel.TierStandard)
+
+	full := c.AllowlistSince(0)
+	assert.True(t, full.Full)
+	assert.Equal(t, []*AllowlistEntry{{FobID: 123, Tier: datamodel.TierStandard}, {FobID: 234, Tier: datamodel.TierStandard}}, full.Fobs)
+
+	// Nothing changed
+	delta := c.AllowlistSince(full.Version)
+	assert.False(t, delta.Full)
+	assert.Equal(t, full.Version, delta.Version)
+	assert.Empty(t, delta.Fobs)
+	assert.Empty(t, delta.Removed)
+
+	// Upgrade, reassignment, and revocation
+	c.set("user-1", 123, datamodel.TierPremium)
+	c.set("user-2", 345, datamodel.TierStandard)
+	delta = c.AllowlistSince(full.Version)
+	assert.False(t, delta.Full)
+	assert.Equal(t, []*AllowlistEntry{{FobID: 123, Tier: datamodel.TierPremium}, {FobID: 345, Tier: datamodel.TierStandard}}, delta.Fobs)
+	assert.Equal(t, []int{234}, delta.Removed)
+
+	// Re-granted fobs aren't reported as removed
+	c.set("user-2", 234, datamodel.TierStandard)
+	delta = c.AllowlistSince(full.Version)
+	assert.Equal(t, []int{345}, delta.Removed)
+
+	// Versions from the future
+	assert.True(t, c.AllowlistSince(delta.Version+1).Full)
+
+	// Versions from another replica, even when it has made more changes
+	other := &Cache{}
+	other.set("user-1", 123, datamodel.TierStandard)
+	for i := 0; i < 10; i++ {
+		other.set("user-2", 234+i, datamodel.TierStandard)
+	}
+	assert.True(t, c.AllowlistSince(other.AllowlistSince(0).Version).Full)
+	assert.True(t, other.AllowlistSince(delta.Version).Full)
+}
+
+func TestAllowlistTombstoneLimit(t *testing.T) {
+	c := &Cache{}
+	c.set("user-1", 1, datamodel.TierStandard)
+	start := c.AllowlistSince(0).Version
+	for i := 0; i <= maxTombstones; i++ {
+		c.set("user-1", i+2, datamodel.TierStandard)
+	}
+	assert.True(t, c.AllowlistSince(start).Full)
+	assert.False(t, c.AllowlistSince(c.AllowlistSince(0).Version).Full)
+}
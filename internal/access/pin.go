@@ -0,0 +1,90 @@
+package access
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+)
+
+// Keypad PINs must be between these lengths, inclusive.
+const (
+	PINMinLength = 6
+	PINMaxLength = 8
+)
+
+// ValidatePIN enforces the strength rules for keypad PINs. Anyone at the door can try PINs, so the easy guesses are rejected.
+func ValidatePIN(pin string) error {
+	if len(pin) < PINMinLength || len(pin) > PINMaxLength {
+		return errors.New("PIN must be 6 to 8 digits")
+	}
+	for _, c := range pin {
+		if c < '0' || c > '9' {
+			return errors.New("PIN must only contain digits")
+		}
+	}
+	if strings.Count(pin, pin[:1]) == len(pin) {
+		return errors.New("PIN must not repeat a single digit")
+	}
+
+	ascending, descending := true, true
+	for i := 1; i < len(pin); i++ {
+		ascending = ascending && pin[i] == '0'+(pin[i-1]-'0'+1)%10
+		descending = descending && pin[i] == '0'+(pin[i-1]-'0'+9)%10
+	}
+	if ascending || descending {
+		return errors.New("PIN must not be a sequence of digits")
+	}
+
+	for n := 2; n <= len(pin)/2; n++ {
+		if len(pin)%n == 0 && strings.Repeat(pin[:n], len(pin)/n) == pin {
+			return errors.New("PIN must not be a repeating pattern")
+		}
+	}
+	return nil
+}
+
+// GeneratePIN returns a random PIN of the maximum length that passes ValidatePIN.
+// PINs are assigned rather than chosen by members, since checking a chosen PIN for uniqueness would reveal that it
+// opens the door for someone else.
+func GeneratePIN() (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < PINMaxLength; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+	for {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		pin := fmt.Sprintf("%0*d", PINMaxLength, n)
+		if ValidatePIN(pin) == nil {
+			return pin, nil
+		}
+	}
+}
+
+// HashPIN returns the value stored for a PIN. It's keyed so PINs can't be brute forced from Keycloak alone,
+// and deterministic so the door's keypad entry can be looked up without knowing who's at the door.
+func HashPIN(key, pin string) string {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write([]byte("door-pin:" + pin))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// PINRotationDue returns true if the member should be reminded to change their PIN.
+// Members are reminded once per PIN, so setting a new one restarts the clock.
+func PINRotationDue(user *datamodel.User, now time.Time, rotation time.Duration) bool {
+	if user.DoorPINHash == "" || rotation <= 0 {
+		return false
+	}
+	due := user.DoorPINSetTime.Add(rotation)
+	return now.After(due) && user.DoorPINReminderTime.Before(due)
+}
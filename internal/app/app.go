@@ -0,0 +1,51 @@
+// Package app wires up the dependencies shared by every binary under cmd, so they can't drift apart in how they're
+// configured e.g. one job forgetting to record Keycloak writes to the reporting db.
+package app
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/stripe/stripe-go/v78"
+
+	"github.com/TheLab-ms/profile/internal/conf"
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/reporting"
+	"github.com/TheLab-ms/profile/internal/telemetry"
+)
+
+type App struct {
+	Env      *conf.Env
+	Keycloak *keycloak.Keycloak[*datamodel.User]
+}
+
+// Load records the build info metric, reads the configuration from env vars, and constructs the Keycloak client and reporting sink.
+// reporting.DefaultSink is set as a side effect, and closed by Close.
+func Load() (*App, error) {
+	version := telemetry.RecordBuildInfo(filepath.Base(os.Args[0]))
+	log.Printf("starting %s built from revision %s", version.Binary, version.Revision)
+
+	env := &conf.Env{}
+	env.MustLoad()
+
+	// Stripe library (sadly) stores its creds in a global var
+	stripe.Key = env.StripeKey
+
+	kc := keycloak.New[*datamodel.User](env)
+
+	// Reporting allows meaningful actions taken by users to be stored somewhere for reference
+	sink, err := reporting.NewSink(env, kc)
+	if err != nil {
+		return nil, err
+	}
+	reporting.DefaultSink = sink
+	kc.Sink = sink
+	kc.History = sink
+
+	return &App{Env: env, Keycloak: kc}, nil
+}
+
+// Close flushes any buffered events to the reporting db.
+func (a *App) Close() { reporting.DefaultSink.Close() }
@@ -3,20 +3,28 @@ package chatbot
 import (
 	"context"
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"log"
 	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 
 	"github.com/TheLab-ms/profile/internal/conf"
 	"github.com/TheLab-ms/profile/internal/reporting"
-	"github.com/bwmarrin/discordgo"
+	"github.com/TheLab-ms/profile/internal/urls"
 )
 
 type Bot struct {
-	client *discordgo.Session
-	env    *conf.Env
+	client   *discordgo.Session
+	env      *conf.Env
+	commands map[string]*command
 }
 
 func NewBot(env *conf.Env) (*Bot, error) {
@@ -34,37 +42,150 @@ func NewBot(env *conf.Env) (*Bot, error) {
 	return b, nil
 }
 
-func (b *Bot) Start(ctx context.Context) error {
-	if b.client == nil {
-		log.Printf("not starting discord bot because it isn't configured")
-		return nil
+// Enabled returns false when Discord isn't configured, in which case the bot's methods are no-ops.
+func (b *Bot) Enabled() bool { return b != nil && b.client != nil }
+
+// CommandHandler responds to a slash command interaction.
+// The returned message is only visible to the caller.
+type CommandHandler func(ctx context.Context, i *discordgo.InteractionCreate) string
+
+type command struct {
+	Spec    *discordgo.ApplicationCommand
+	Handler CommandHandler
+
+	// Deferred commands are acknowledged before the handler runs, and the response is edited once it returns.
+	// Discord fails interactions that aren't acknowledged within 3 seconds, which slow dependencies can easily exceed.
+	Deferred bool
+}
+
+// AddCommand registers a slash command to be created and handled by this process once the bot is started.
+func (b *Bot) AddCommand(spec *discordgo.ApplicationCommand, fn CommandHandler) {
+	b.addCommand(&command{Spec: spec, Handler: fn})
+}
+
+func (b *Bot) addCommand(cmd *command) {
+	if b.commands == nil {
+		b.commands = map[string]*command{}
 	}
+	b.commands[cmd.Spec.Name] = cmd
+}
 
-	_, err := b.client.ApplicationCommandCreate(b.env.DiscordAppID, b.env.DiscordGuildID, &discordgo.ApplicationCommand{
+// AddLinkCommand registers the command used by members to link their Discord account to their profile.
+func (b *Bot) AddLinkCommand() {
+	b.AddCommand(&discordgo.ApplicationCommand{
 		Name:        "link",
 		Description: "Link your membership to Discord",
 		Type:        discordgo.ChatApplicationCommand,
+	}, func(ctx context.Context, i *discordgo.InteractionCreate) string {
+		id := i.Member.User.ID
+		log.Printf("got link request for discord user %q", id)
+
+		nonce := make([]byte, 16)
+		if _, err := rand.Read(nonce); err != nil {
+			log.Printf("error while generating nonce: %s", err)
+			return "Something went wrong - please try again"
+		}
+		ts := time.Now().Unix()
+		nonceStr := hex.EncodeToString(nonce)
+		signature := SignLink(id, ts, nonceStr, b.env.DiscordBotToken)
+		return fmt.Sprintf("[Go to the profile app to finish the process!](%s) This link expires in %d minutes.", urls.DiscordLink(b.env, id, ts, nonceStr, signature), int(b.env.DiscordLinkTTL.Minutes()))
 	})
-	if err != nil {
-		return err
+}
+
+// AddResyncCommand registers a leadership-only command that calls fn with the given email address.
+// The response is deferred since fn looks the member up in Keycloak.
+func (b *Bot) AddResyncCommand(fn func(ctx context.Context, email string) (string, error)) {
+	b.addCommand(&command{Deferred: true, Spec: &discordgo.ApplicationCommand{
+		Name:        "resync",
+		Description: "Force a member to be resynced immediately",
+		Type:        discordgo.ChatApplicationCommand,
+		Options: []*discordgo.ApplicationCommandOption{{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "email",
+			Description: "The member's email address",
+			Required:    true,
+		}},
+	}, Handler: func(ctx context.Context, i *discordgo.InteractionCreate) string {
+		if !b.isLeadership(i.Member) {
+			return "Only leadership can use this command"
+		}
+
+		opts := i.ApplicationCommandData().Options
+		if len(opts) == 0 {
+			return "An email address is required"
+		}
+		email := opts[0].StringValue()
+
+		log.Printf("discord user %q requested resync of member %s", i.Member.User.ID, email)
+		msg, err := fn(ctx, email)
+		if err != nil {
+			log.Printf("error while resyncing member %s: %s", email, err)
+			return fmt.Sprintf("Error: %s", err)
+		}
+		return msg
+	}})
+}
+
+func (b *Bot) isLeadership(member *discordgo.Member) bool {
+	if b.env.DiscordLeadershipRoleID == "" {
+		return false
+	}
+	for _, role := range member.Roles {
+		if role == b.env.DiscordLeadershipRoleID {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Bot) Start(ctx context.Context) error {
+	if b.client == nil {
+		log.Printf("not starting discord bot because it isn't configured")
+		return nil
+	}
+
+	for _, cmd := range b.commands {
+		_, err := b.client.ApplicationCommandCreate(b.env.DiscordAppID, b.env.DiscordGuildID, cmd.Spec)
+		if err != nil {
+			return err
+		}
 	}
 
 	b.client.AddHandler(func(s *discordgo.Session, i *discordgo.InteractionCreate) {
 		member := i.Member
-		if member == nil || member.User == nil {
+		if member == nil || member.User == nil || i.Type != discordgo.InteractionApplicationCommand {
 			return
 		}
-		id := member.User.ID
 
-		log.Printf("got link request for discord user %q", id)
-		signature := GenerateHMAC(id, b.env.DiscordBotToken)
-		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-			Type: discordgo.InteractionResponseChannelMessageWithSource,
-			Data: &discordgo.InteractionResponseData{
-				Flags:   discordgo.MessageFlagsEphemeral,
-				Content: fmt.Sprintf("[Go to the profile app to finish the process!](%s/link-discord?user=%s&sig=%s)", b.env.SelfURL, id, signature),
-			},
+		// Commands that aren't registered by this process are handled elsewhere
+		cmd, ok := b.commands[i.ApplicationCommandData().Name]
+		if !ok {
+			return
+		}
+
+		if !cmd.Deferred {
+			s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseChannelMessageWithSource,
+				Data: &discordgo.InteractionResponseData{
+					Flags:   discordgo.MessageFlagsEphemeral,
+					Content: cmd.Handler(ctx, i),
+				},
+			})
+			return
+		}
+
+		err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral},
 		})
+		if err != nil {
+			log.Printf("error while deferring response to discord command %q: %s", cmd.Spec.Name, err)
+			return
+		}
+		content := cmd.Handler(ctx, i)
+		if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &content}); err != nil {
+			log.Printf("error while responding to discord command %q: %s", cmd.Spec.Name, err)
+		}
 	})
 
 	go func() {
@@ -75,13 +196,95 @@ func (b *Bot) Start(ctx context.Context) error {
 	return b.client.Open()
 }
 
-func (b *Bot) SyncUser(ctx context.Context, user *UserStatus) error {
+// SyncResult describes the change (if any) made by SyncUser.
+type SyncResult string
+
+const (
+	SyncResultAdded       SyncResult = "added"
+	SyncResultRemoved     SyncResult = "removed"
+	SyncResultNoop        SyncResult = "noop"
+	SyncResultUserMissing SyncResult = "user_missing"
+)
+
+var syncResults = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "discord_role_sync_total",
+	Help: "Count of Discord member role syncs by result",
+}, []string{"result"})
+
+var lastRunSyncResults = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "discord_role_sync_last_run",
+	Help: "Count of Discord member role syncs by result during the last full resync",
+}, []string{"result"})
+
+func (b *Bot) SyncUser(ctx context.Context, user *UserStatus) (SyncResult, error) {
+	result, err := b.syncUser(ctx, user)
+	if err != nil {
+		return result, err
+	}
+	syncResults.WithLabelValues(string(result)).Inc()
+	return result, nil
+}
+
+// ResyncRun counts the results of the syncs enqueued by a full resync, so each run can be summarized once it drains.
+// Syncs of users that aren't part of the run e.g. from webhooks aren't counted.
+type ResyncRun struct {
+	mut     sync.Mutex
+	pending map[int64]struct{}
+	stats   map[SyncResult]int
+}
+
+// Start begins a run of the given users, abandoning the previous run if it hasn't finished.
+// It returns false if the previous run was abandoned.
+func (r *ResyncRun) Start(ids []int64) bool {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	finished := len(r.pending) == 0
+	r.pending = make(map[int64]struct{}, len(ids))
+	for _, id := range ids {
+		r.pending[id] = struct{}{}
+	}
+	r.stats = map[SyncResult]int{}
+	return finished
+}
+
+// Record counts the result of syncing the user if they're part of the run.
+// The run's totals are returned once the last of its users has been synced.
+func (r *ResyncRun) Record(id int64, result SyncResult) (map[SyncResult]int, bool) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	if _, ok := r.pending[id]; !ok {
+		return nil, false
+	}
+	delete(r.pending, id)
+	r.stats[result]++
+	if len(r.pending) > 0 {
+		return nil, false
+	}
+
+	for _, result := range []SyncResult{SyncResultAdded, SyncResultRemoved, SyncResultNoop, SyncResultUserMissing} {
+		lastRunSyncResults.WithLabelValues(string(result)).Set(float64(r.stats[result]))
+	}
+	return r.stats, true
+}
+
+func (b *Bot) syncUser(ctx context.Context, user *UserStatus) (SyncResult, error) {
 	member, err := b.client.GuildMember(b.env.DiscordGuildID, strconv.FormatInt(user.ID, 10), discordgo.WithContext(ctx))
 	if err != nil {
 		if e, ok := err.(*discordgo.RESTError); ok && e.Response.StatusCode == 404 {
-			return nil // nothing to do if the user no longer exists
+			return SyncResultUserMissing, nil // nothing to do if the user no longer exists
+		}
+		return "", fmt.Errorf("getting guild member: %w", err)
+	}
+	if user.Nickname != "" && member.Nick != user.Nickname {
+		if err := b.syncNickname(ctx, member, user); err != nil {
+			return "", err
 		}
-		return fmt.Errorf("getting guild member: %w", err)
+	}
+
+	if user.Banned {
+		return b.removeManagedRoles(ctx, member, user)
 	}
 
 	var exists bool
@@ -92,25 +295,68 @@ func (b *Bot) SyncUser(ctx context.Context, user *UserStatus) error {
 		}
 	}
 	if exists == user.ActiveMember {
-		return nil // already in sync
+		return SyncResultNoop, nil // already in sync
 	}
 
 	if user.ActiveMember {
 		err = b.client.GuildMemberRoleAdd(b.env.DiscordGuildID, strconv.FormatInt(user.ID, 10), b.env.DiscordMemberRoleID, discordgo.WithContext(ctx))
 		if err != nil {
-			return fmt.Errorf("adding role to guild member %q: %w", member.DisplayName(), err)
+			return "", fmt.Errorf("adding role to guild member %q: %w", member.DisplayName(), err)
 		}
 		log.Printf("added member role for discord user %s i.e. member %s", member.DisplayName(), user.Email)
 		reporting.DefaultSink.Eventf(user.Email, "MembershipRoleDiverged", "added member role to discord user")
-		return nil
+		return SyncResultAdded, nil
 	}
 
 	err = b.client.GuildMemberRoleRemove(b.env.DiscordGuildID, strconv.FormatInt(user.ID, 10), b.env.DiscordMemberRoleID, discordgo.WithContext(ctx))
 	if err != nil {
-		return fmt.Errorf("removing role from guild member %q: %w", member.DisplayName(), err)
+		return "", fmt.Errorf("removing role from guild member %q: %w", member.DisplayName(), err)
 	}
 	log.Printf("removed member role from discord user %s i.e. member %s", member.DisplayName(), user.Email)
 	reporting.DefaultSink.Eventf(user.Email, "MembershipRoleDiverged", "removed member role from discord user")
+	return SyncResultRemoved, nil
+}
+
+// removeManagedRoles removes the member role and every other managed role from a banned member.
+func (b *Bot) removeManagedRoles(ctx context.Context, member *discordgo.Member, user *UserStatus) (SyncResult, error) {
+	managed := map[string]bool{b.env.DiscordMemberRoleID: true}
+	for _, id := range b.env.DiscordManagedRoleIDs {
+		managed[id] = true
+	}
+
+	removed := []string{}
+	for _, role := range member.Roles {
+		if !managed[role] {
+			continue
+		}
+		err := b.client.GuildMemberRoleRemove(b.env.DiscordGuildID, strconv.FormatInt(user.ID, 10), role, discordgo.WithContext(ctx))
+		if err != nil {
+			return "", fmt.Errorf("removing role %s from banned guild member %q: %w", role, member.DisplayName(), err)
+		}
+		removed = append(removed, role)
+	}
+	if len(removed) == 0 {
+		return SyncResultNoop, nil
+	}
+
+	log.Printf("removed roles %v from banned discord user %s i.e. member %s", removed, member.DisplayName(), user.Email)
+	reporting.DefaultSink.Eventf(user.Email, "BannedRolesRemoved", "removed roles %v from banned discord user", removed)
+	return SyncResultRemoved, nil
+}
+
+// syncNickname renames the guild member. Discord doesn't allow bots to rename the server owner or anyone with a
+// higher role than the bot, so those members are skipped instead of retried forever.
+func (b *Bot) syncNickname(ctx context.Context, member *discordgo.Member, user *UserStatus) error {
+	err := b.client.GuildMemberNickname(b.env.DiscordGuildID, strconv.FormatInt(user.ID, 10), user.Nickname, discordgo.WithContext(ctx))
+	if e, ok := err.(*discordgo.RESTError); ok && e.Response.StatusCode == 403 {
+		log.Printf("not allowed to change the nickname of discord user %s i.e. member %s", member.DisplayName(), user.Email)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("setting nickname of guild member %q: %w", member.DisplayName(), err)
+	}
+	log.Printf("changed nickname of discord user %s to %q i.e. member %s", member.DisplayName(), user.Nickname, user.Email)
+	reporting.DefaultSink.Eventf(user.Email, "DiscordNicknameChanged", "changed discord nickname from %q to %q", member.Nick, user.Nickname)
 	return nil
 }
 
@@ -139,10 +385,53 @@ func (b *Bot) ListUsers(ctx context.Context, cursor func(int64)) error {
 	}
 }
 
+// SendDM sends a direct message to the given Discord user.
+func (b *Bot) SendDM(ctx context.Context, userID int64, msg string) error {
+	if b.client == nil {
+		return nil
+	}
+	channel, err := b.client.UserChannelCreate(strconv.FormatInt(userID, 10), discordgo.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("creating dm channel: %w", err)
+	}
+	_, err = b.client.ChannelMessageSend(channel.ID, msg, discordgo.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("sending dm: %w", err)
+	}
+	return nil
+}
+
+// CreateForumPost starts a new thread in the given forum channel, returning the thread's ID.
+// Only the given users are pinged by mentions in the message.
+func (b *Bot) CreateForumPost(ctx context.Context, channelID, title, msg string, mentions ...int64) (string, error) {
+	if b.client == nil {
+		return "", nil
+	}
+	users := make([]string, len(mentions))
+	for i, id := range mentions {
+		users[i] = strconv.FormatInt(id, 10)
+	}
+	thread, err := b.client.ForumThreadStartComplex(channelID, &discordgo.ThreadStart{Name: title, AutoArchiveDuration: 60 * 24 * 7}, &discordgo.MessageSend{
+		Content:         msg,
+		AllowedMentions: &discordgo.MessageAllowedMentions{Users: users},
+	}, discordgo.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("starting forum thread: %w", err)
+	}
+	return thread.ID, nil
+}
+
 type UserStatus struct {
 	ID           int64
 	Email        string
 	ActiveMember bool
+	Banned       bool   // removes every managed role, not just the member role
+	Nickname     string // the guild nickname the member should have, or empty to leave it alone
+}
+
+// SignLink returns the signature expected by the profile app's Discord link endpoint.
+func SignLink(discordUserID string, ts int64, nonce, key string) string {
+	return GenerateHMAC(fmt.Sprintf("%s:%d:%s", discordUserID, ts, nonce), key)
 }
 
 func GenerateHMAC(message, key string) string {
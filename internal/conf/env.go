@@ -1,10 +1,28 @@
 package conf
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
 	"time"
+	_ "time/tzdata" // the container images don't have tzdata
 
 	"github.com/kelseyhightower/envconfig"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+)
+
+// cssColor is what's allowed in EventCategoryColors, since they're used in style attributes.
+var cssColor = regexp.MustCompile(`^(#[0-9a-fA-F]{3,8}|[a-zA-Z]+)$`)
+
+// Values of AccessWaiverPolicy.
+const (
+	WaiverPolicyWarn = "warn"
+	WaiverPolicyDeny = "deny"
 )
 
 // TODO: Use interface + getters
@@ -15,6 +33,27 @@ type Env struct {
 	KeycloakRealm           string `default:"master" split_words:"true"`
 	KeycloakMembersGroupID  string `split_words:"true" required:"true"`
 	KeycloakRegisterWebhook bool   `split_words:"true"`
+	KeycloakWebhookSecret   string `split_words:"true"` // signs webhooks sent by Keycloak (and profilectl resync) to /webhooks/keycloak
+
+	// Groups that automated code paths may add users to or remove them from, in addition to the members group.
+	// Everything else (notably leadership) can only be changed in Keycloak itself.
+	KeycloakWritableGroupIDs []string `split_words:"true"`
+
+	// Calls fail fast for the cooldown once Keycloak has failed this many times in a row (0 disables the breaker)
+	KeycloakBreakerThreshold int           `split_words:"true" default:"5"`
+	KeycloakBreakerCooldown  time.Duration `split_words:"true" default:"30s"`
+
+	// Idempotent calls that hit a network error or 502/503/504 are attempted up to this many times in total, waiting
+	// the backoff (doubled each time) in between. 0 or 1 disables retries.
+	KeycloakRetryAttempts int           `split_words:"true" default:"3"`
+	KeycloakRetryBackoff  time.Duration `split_words:"true" default:"200ms"`
+
+	// How long the members group is cached before being listed again, instead of querying each user's groups (0 disables)
+	KeycloakMembershipCacheTTL time.Duration `split_words:"true" default:"5m"`
+
+	// How long users read for rendering (e.g. the profile page) are cached (0 disables). The cache is invalidated by
+	// Keycloak webhooks, so it's disabled without KEYCLOAK_WEBHOOK_SECRET. Webhooks only reach one replica, so keep this short.
+	KeycloakUserCacheTTL time.Duration `split_words:"true" default:"30s"`
 
 	// These should be loaded from the env if not set
 	KeycloakClientID     string `split_words:"true"`
@@ -25,24 +64,153 @@ type Env struct {
 	SelfURL               string `split_words:"true" required:"true"`
 	WebhookURL            string `split_words:"true"`
 
+	// Caller-provided return URLs must stay on SelfURL's host, or one of these e.g. "wiki.example.com"
+	RedirectAllowedHosts []string `split_words:"true"`
+
+	// Keycloak's password setup + email verification message for new accounts.
+	// Members whose link expired can request another from /signup, up to SignupEmailMaxResends times.
+	SignupEmailLifespan   time.Duration `split_words:"true" default:"12h"`
+	SignupEmailActions    []string      `split_words:"true" default:"UPDATE_PASSWORD,VERIFY_EMAIL"`
+	SignupEmailMaxResends int           `split_words:"true" default:"3"`
+
+	// Contact info fields members can't change themselves e.g. "first:waiver,last:waiver" locks the legal name once
+	// the waiver is signed. Members can ask leadership to change them, which posts to the Discord webhook.
+	LockedFields        LockedFields `split_words:"true"`
+	LockedFieldsWebhook string       `split_words:"true"`
+
+	// Per-minute limits shared by all replicas (see internal/ratelimit), 0 to disable.
+	// Signups are limited per client IP, webhooks per endpoint.
+	SignupRateLimit  int `split_words:"true" default:"10"`
+	WebhookRateLimit int `split_words:"true" default:"600"`
+
+	// Member cap - new members join a waitlist once this many are active (0 disables the cap)
+	MemberCap         int           `split_words:"true"`
+	MemberCapInterval time.Duration `split_words:"true" default:"10m"`
+	WaitlistInviteTTL time.Duration `split_words:"true" default:"72h"`
+
+	// Building hours for each membership tier, in the space's local time. Everyone has 24/7 access unless hours
+	// are configured e.g. "standard:8-22,premium:0-24".
+	SpaceTimezone   string          `split_words:"true" default:"America/Chicago"`
+	AccessSchedules AccessSchedules `split_words:"true" default:"standard:0-24"`
+
+	// Posted to (a Discord webhook URL) when one of profile-async's full resync loops falls behind
+	ResyncAlertWebhook string `split_words:"true"`
+
+	// Swipe anomaly alerts (Discord webhook URL for the leadership channel)
+	SwipeAlertWebhook    string `split_words:"true"`
+	SwipeAlertMaxPerHour int    `split_words:"true" default:"20"`
+
+	// Swipes older than this are rolled up into daily per-fob counts and deleted (0 keeps them forever)
+	SwipeRetention time.Duration `split_words:"true" default:"2160h"`
+
+	// Building access revocations for absent members are held this long at /admin/pending for leadership to review (0 applies them right away)
+	DeactivationReviewWindow time.Duration `split_words:"true" default:"168h"`
+
+	// Door controller API
+	AccessControllerToken string        `split_words:"true"`
+	EmergencyAPIToken     string        `split_words:"true"` // bearer token for looking up emergency contacts by fob
+	FobLookupAPIToken     string        `split_words:"true"` // bearer token for resolving fobs to members e.g. for the door log viewer
+	AccessCacheInterval   time.Duration `split_words:"true" default:"10m"`
+	AccessSigningKey      string        `split_words:"true"` // HS256 key for signing the offline allowlist (unsigned JSON when unset)
+
+	// Members who haven't signed the waiver are either flagged in access check responses (warn) or denied entry (deny)
+	AccessWaiverPolicy string `split_words:"true" default:"warn"`
+
+	// Newsletter subscriptions are synced to a Mailchimp audience (see internal/newsletter). Register
+	// <SELF_URL>/webhooks/newsletter?secret=<NEWSLETTER_WEBHOOK_SECRET> in Mailchimp to hear about unsubscribes.
+	NewsletterAPIKey        string `split_words:"true"`
+	NewsletterListID        string `split_words:"true"`
+	NewsletterWebhookSecret string `split_words:"true"`
+
+	// Members can see a rough count of people in the space, estimated from swipes within the window (0 disables it).
+	// Counts below the floor are shown as "fewer than" so a member alone in the space can't be singled out.
+	OccupancyWindow time.Duration `split_words:"true"`
+	OccupancyFloor  int           `split_words:"true" default:"3"`
+
+	// Door controllers and kiosks report heartbeats to /webhooks/device-heartbeat with the token. Leadership is alerted
+	// (through a Discord webhook URL) when one hasn't been heard from in DeviceSilenceThreshold.
+	DeviceHeartbeatToken   string        `split_words:"true"`
+	DeviceAlertWebhook     string        `split_words:"true"`
+	DeviceSilenceThreshold time.Duration `split_words:"true" default:"15m"`
+
+	// Keypad PINs for doors without a fob reader. PINs are stored hashed with the key, so changing it clears them all.
+	// Members are reminded to change PINs older than DoorPINRotation.
+	DoorPINKey      string        `split_words:"true"`
+	DoorPINRotation time.Duration `split_words:"true" default:"4320h"`
+
+	// Entitlements API for internal sites e.g. the wiki (responses are signed with the key)
+	EntitlementsAPIToken   string        `split_words:"true"`
+	EntitlementsSigningKey string        `split_words:"true"`
+	EntitlementsTTL        time.Duration `split_words:"true" default:"5m"`
+
+	// Fob IDs handed out to members, and ranges set aside for other badges e.g. "contractor:9000-9999".
+	// Assigning a fob outside of the member ranges (when set) or inside a reserved range requires confirmation.
+	FobMemberRanges   FobRanges `split_words:"true"`
+	FobReservedRanges FobRanges `split_words:"true"`
+
 	// Stripe
-	StripeKey        string `split_words:"true"`
-	StripeWebhookKey string `split_words:"true"`
+	StripeProducts    map[string]string `split_words:"true" default:"membership:Membership"` // key -> Stripe product name
+	StripeKey         string            `split_words:"true"`
+	StripeWebhookKey  string            `split_words:"true"`
+	StripeBalanceTTL  time.Duration     `split_words:"true" default:"5m"`
+	StripeLockerPrice string            `split_words:"true"` // recurring price ID added to the member's subscription for locker rentals
+
+	// Members are warned this long before their discount expires (see cmd/discount-check-job)
+	DiscountExpirationNotice time.Duration `split_words:"true" default:"336h"`
+
+	// Monthly membership report recipient (see cmd/treasurer-report-job)
+	TreasurerEmail string `split_words:"true"`
+
+	// Past due members keep access for this long after their first failed payment.
+	// It should be shorter than Stripe's retry schedule, since access is only revoked by later webhooks.
+	StripeGracePeriod time.Duration `split_words:"true"`
 
 	// Paypal (for migration)
-	PaypalClientID     string `split_words:"true"`
-	PaypalClientSecret string `split_words:"true"`
+	PaypalClientID      string `split_words:"true"`
+	PaypalClientSecret  string `split_words:"true"`
+	PaypalReportWebhook string `split_words:"true"` // optional Discord webhook URL for reconciliation reports
+
+	// Members still paying through Paypal after the cutoff (RFC3339) are sent escalating migration notices at this interval
+	PaypalMigrationCutoff         time.Time     `split_words:"true"`
+	PaypalMigrationNoticeInterval time.Duration `split_words:"true" default:"168h"`
 
 	// Docuseal
 	DocusealURL   string `split_words:"true"`
 	DocusealToken string `split_words:"true"`
 
+	// Guests sign in with a QR code at the door and sign the waiver, and their host confirms the visit.
+	// Each member can confirm this many guests a day (0 disables guest sign-in).
+	GuestDailyLimit int `split_words:"true" default:"2"`
+
 	// Discord
-	DiscordAppID        string        `split_words:"true"`
-	DiscordGuildID      string        `split_words:"true"`
-	DiscordBotToken     string        `split_words:"true"`
-	DiscordInterval     time.Duration `split_words:"true" default:"60s"`
-	DiscordMemberRoleID string        `split_words:"true"`
+	DiscordAppID            string        `split_words:"true"`
+	DiscordGuildID          string        `split_words:"true"`
+	DiscordBotToken         string        `split_words:"true"`
+	DiscordInterval         time.Duration `split_words:"true" default:"60s"`
+	EventsMaxHorizon        time.Duration `split_words:"true" default:"4800h"` // recurring events aren't expanded further out than this (the calendar shows 6 months)
+	DiscordMemberRoleID     string        `split_words:"true"`
+	DiscordLeadershipRoleID string        `split_words:"true"`
+	DiscordLinkTTL          time.Duration `split_words:"true" default:"15m"`
+	DiscordInviteURL        string        `split_words:"true"`
+	DiscordIntroChannelID   string        `split_words:"true"` // forum channel where new members are introduced
+
+	// Other roles given out by leadership e.g. for certifications, which are removed from banned members along with the member role
+	DiscordManagedRoleIDs []string `split_words:"true"`
+
+	// Colors for event categories, which are tagged in event names or descriptions e.g. "[woodshop]".
+	// Given as "category:color" pairs e.g. "woodshop:#f0ad4e,class:#5bc0de".
+	EventCategoryColors map[string]string `split_words:"true"`
+
+	// Signs the check-in QR codes projected at events - attendance isn't tracked when unset
+	EventCheckInKey string `split_words:"true"`
+
+	// Guild nicknames given to linked members e.g. "{first} {l}." (see NicknameFormat) - left alone when unset
+	DiscordNicknameFormat NicknameFormat `split_words:"true"`
+
+	// Welcome sequence (new member onboarding)
+	WelcomeOrientationURL   string        `split_words:"true"`
+	WelcomeOrientationDelay time.Duration `split_words:"true" default:"72h"`
+	WelcomeDiscordDelay     time.Duration `split_words:"true" default:"168h"`
 
 	// Age (secrets encrpytion)
 	AgePublicKey  string `split_words:"true"`
@@ -55,9 +223,31 @@ type Env struct {
 	EventPsqlPassword string `split_words:"true"`
 	EventBufferLength int    `split_words:"true" default:"50"`
 
+	// Reporting database pool size (0 uses pgx's default), how often it's pinged, and how long queries can take
+	EventPsqlMaxConns     int32         `split_words:"true"`
+	EventPsqlMinConns     int32         `split_words:"true"`
+	EventPsqlPingInterval time.Duration `split_words:"true" default:"30s"`
+	EventPsqlQueryTimeout time.Duration `split_words:"true" default:"15s"`
+
 	// Conway
-	ConwayURL   string `split_words:"true"`
-	ConwayToken string `split_words:"true"`
+	ConwayURL           string `split_words:"true"`
+	ConwayToken         string `split_words:"true"`
+	ConwayWebhookSecret string `split_words:"true"` // signs webhooks sent by Conway to /webhooks/conway
+
+	// Email (SMTP)
+	SMTPAddr     string `split_words:"true"`
+	SMTPUsername string `split_words:"true"`
+	SMTPPassword string `split_words:"true"`
+	SMTPFrom     string `split_words:"true"`
+
+	// Signs delivery notifications (bounces, complaints) sent by the email provider to /webhooks/email
+	EmailWebhookSecret string `split_words:"true"`
+
+	// Magic link login (fallback for clients that can't go through oauth2proxy).
+	// Links and session cookies are HS256 JWTs signed like the offline allowlist, but with their own key.
+	MagicLinkSigningKey string        `split_words:"true"`
+	MagicLinkTTL        time.Duration `split_words:"true" default:"15m"`
+	MagicLinkSessionTTL time.Duration `split_words:"true" default:"12h"`
 }
 
 func (e *Env) MustLoad() {
@@ -65,4 +255,114 @@ func (e *Env) MustLoad() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	if err := e.Validate(); err != nil {
+		log.Fatalf("invalid configuration:\n%s", err)
+	}
+	log.Printf("loaded configuration: %s", e.Redacted())
+}
+
+// Validate checks requirements that span multiple fields, since envconfig can only check them one at a time.
+// All problems are returned together so a broken deployment can be fixed in one pass.
+func (e *Env) Validate() error {
+	problems := []error{}
+	check := func(ok bool, msg string) {
+		if !ok {
+			problems = append(problems, errors.New(msg))
+		}
+	}
+	together := func(a, b, aName, bName string) {
+		check((a == "") == (b == ""), fmt.Sprintf("%s and %s must be set together", aName, bName))
+	}
+
+	if u, err := url.Parse(e.SelfURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		problems = append(problems, errors.New("SELF_URL must be an absolute http(s) URL"))
+	}
+	for _, host := range e.RedirectAllowedHosts {
+		check(host != "" && !strings.ContainsAny(host, "/?#@"), fmt.Sprintf("REDIRECT_ALLOWED_HOSTS entry %q must be a bare host name", host))
+	}
+	for category, color := range e.EventCategoryColors {
+		check(cssColor.MatchString(color), fmt.Sprintf("EVENT_CATEGORY_COLORS entry %q must be a hex or named color", category))
+	}
+	check(!e.KeycloakRegisterWebhook || e.WebhookURL != "", "WEBHOOK_URL is required when KEYCLOAK_REGISTER_WEBHOOK is set")
+	check(!e.KeycloakRegisterWebhook || e.KeycloakWebhookSecret != "", "KEYCLOAK_WEBHOOK_SECRET is required when KEYCLOAK_REGISTER_WEBHOOK is set")
+	together(e.KeycloakClientID, e.KeycloakClientSecret, "KEYCLOAK_CLIENT_ID", "KEYCLOAK_CLIENT_SECRET")
+	check(e.KeycloakRetryAttempts >= 0, "KEYCLOAK_RETRY_ATTEMPTS must not be negative")
+	together(e.PaypalClientID, e.PaypalClientSecret, "PAYPAL_CLIENT_ID", "PAYPAL_CLIENT_SECRET")
+	together(e.DocusealURL, e.DocusealToken, "DOCUSEAL_URL", "DOCUSEAL_TOKEN")
+	together(e.ConwayURL, e.ConwayToken, "CONWAY_URL", "CONWAY_TOKEN")
+	together(e.SMTPAddr, e.SMTPFrom, "SMTP_ADDR", "SMTP_FROM")
+	together(e.EntitlementsAPIToken, e.EntitlementsSigningKey, "ENTITLEMENTS_API_TOKEN", "ENTITLEMENTS_SIGNING_KEY")
+	check(e.FobLookupAPIToken == "" || e.AccessControllerToken != "", "ACCESS_CONTROLLER_TOKEN is required when FOB_LOOKUP_API_TOKEN is set")
+	check(e.DoorPINKey == "" || e.AccessControllerToken != "", "ACCESS_CONTROLLER_TOKEN is required when DOOR_PIN_KEY is set")
+	check(e.AccessSigningKey == "" || e.AccessControllerToken != "", "ACCESS_CONTROLLER_TOKEN is required when ACCESS_SIGNING_KEY is set")
+	check(e.AccessWaiverPolicy == "" || e.AccessWaiverPolicy == WaiverPolicyWarn || e.AccessWaiverPolicy == WaiverPolicyDeny, "ACCESS_WAIVER_POLICY must be warn or deny")
+	check(len(e.LockedFields) == 0 || e.LockedFieldsWebhook != "", "LOCKED_FIELDS_WEBHOOK is required when LOCKED_FIELDS is set")
+	check(e.StripeWebhookKey == "" || e.StripeKey != "", "STRIPE_KEY is required when STRIPE_WEBHOOK_KEY is set")
+	check(e.StripeLockerPrice == "" || e.StripeKey != "", "STRIPE_KEY is required when STRIPE_LOCKER_PRICE is set")
+	check(e.DiscordBotToken == "" || e.DiscordGuildID != "", "DISCORD_GUILD_ID is required when DISCORD_BOT_TOKEN is set")
+	check(e.DiscordIntroChannelID == "" || e.DiscordBotToken != "", "DISCORD_BOT_TOKEN is required when DISCORD_INTRO_CHANNEL_ID is set")
+	check(e.DiscordNicknameFormat == "" || e.DiscordBotToken != "", "DISCORD_BOT_TOKEN is required when DISCORD_NICKNAME_FORMAT is set")
+	check(e.StripeProducts["membership"] != "", "STRIPE_PRODUCTS must include the membership product")
+	check(e.MemberCap >= 0, "MEMBER_CAP must not be negative")
+	check(e.GuestDailyLimit >= 0, "GUEST_DAILY_LIMIT must not be negative")
+	check(e.SignupRateLimit >= 0, "SIGNUP_RATE_LIMIT must not be negative")
+	check(e.WebhookRateLimit >= 0, "WEBHOOK_RATE_LIMIT must not be negative")
+	check(e.EventPsqlMinConns >= 0 && (e.EventPsqlMaxConns == 0 || e.EventPsqlMinConns <= e.EventPsqlMaxConns), "EVENT_PSQL_MIN_CONNS must be between zero and EVENT_PSQL_MAX_CONNS")
+	together(e.NewsletterAPIKey, e.NewsletterListID, "NEWSLETTER_API_KEY", "NEWSLETTER_LIST_ID")
+	check(e.NewsletterAPIKey == "" || strings.Contains(e.NewsletterAPIKey, "-"), "NEWSLETTER_API_KEY must end with the Mailchimp datacenter e.g. -us1")
+	check(e.NewsletterWebhookSecret == "" || e.NewsletterAPIKey != "", "NEWSLETTER_API_KEY is required when NEWSLETTER_WEBHOOK_SECRET is set")
+	check(e.OccupancyWindow == 0 || e.EventPsqlAddr != "", "EVENT_PSQL_ADDR is required when OCCUPANCY_WINDOW is set")
+	check(e.OccupancyFloor >= 0, "OCCUPANCY_FLOOR must not be negative")
+	check(e.DeviceHeartbeatToken == "" || e.DeviceSilenceThreshold >= time.Minute, "DEVICE_SILENCE_THRESHOLD must be at least a minute")
+	check(e.SwipeRetention == 0 || e.SwipeRetention >= time.Hour*24*7, "SWIPE_RETENTION must be at least a week, or zero to keep swipes forever")
+	check(e.SignupEmailLifespan >= time.Minute, "SIGNUP_EMAIL_LIFESPAN must be at least a minute")
+	check(len(e.SignupEmailActions) > 0, "SIGNUP_EMAIL_ACTIONS must not be empty")
+	check(e.AccessSchedules[datamodel.TierStandard] != nil, "ACCESS_SCHEDULES must include the standard tier")
+	if _, err := time.LoadLocation(e.SpaceTimezone); err != nil {
+		problems = append(problems, fmt.Errorf("SPACE_TIMEZONE is invalid: %w", err))
+	}
+
+	return errors.Join(problems...)
+}
+
+// Redacted summarizes the configuration for debugging deployments.
+// Anything that might be a credential (including webhook URLs, which embed their own tokens) is only reported as set or unset.
+// Unset fields are omitted.
+func (e *Env) Redacted() string {
+	v := reflect.ValueOf(e).Elem()
+	parts := []string{}
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Name
+		field := v.Field(i)
+		if field.Kind() == reflect.String && isSensitive(name) {
+			if field.String() != "" {
+				parts = append(parts, name+"=<redacted>")
+			}
+			continue
+		}
+		if field.IsZero() {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s=%v", name, field.Interface()))
+	}
+	return strings.Join(parts, " ")
+}
+
+func isSensitive(fieldName string) bool {
+	// Compare whole words to avoid e.g. treating "Keycloak" as "Key"
+	words := []string{}
+	start := 0
+	for i := 1; i <= len(fieldName); i++ {
+		if i == len(fieldName) || (fieldName[i] >= 'A' && fieldName[i] <= 'Z') {
+			words = append(words, fieldName[start:i])
+			start = i
+		}
+	}
+	for _, word := range words {
+		switch word {
+		case "Token", "Secret", "Key", "Password", "Webhook", "Psql":
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,162 @@
+package conf
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+)
+
+func TestValidate(t *testing.T) {
+	valid := func() *Env {
+		return &Env{
+			SelfURL:         "https://profile.example.com",
+			SpaceTimezone:   "America/Chicago",
+			AccessSchedules: AccessSchedules{"standard": {OpenHour: 8, CloseHour: 22}},
+			StripeProducts:  map[string]string{"membership": "Membership"},
+
+			SignupEmailLifespan: time.Hour * 12,
+			SignupEmailActions:  []string{"UPDATE_PASSWORD", "VERIFY_EMAIL"},
+		}
+	}
+	require.NoError(t, valid().Validate())
+
+	env := valid()
+	env.SelfURL = "profile.example.com"
+	env.KeycloakRegisterWebhook = true
+	env.PaypalClientID = "foo"
+	env.AccessSchedules = AccessSchedules{"premium": {OpenHour: 0, CloseHour: 24}}
+	env.SignupEmailLifespan = time.Second
+	env.RedirectAllowedHosts = []string{"https://wiki.example.com"}
+	env.SwipeRetention = time.Hour
+	env.GuestDailyLimit = -1
+	env.SignupRateLimit = -1
+	env.WebhookRateLimit = -1
+	env.EventPsqlMinConns = 5
+	env.EventPsqlMaxConns = 2
+	env.EventCategoryColors = map[string]string{"woodshop": "red;background:url(x)"}
+	env.AccessWaiverPolicy = "block"
+	env.DeviceHeartbeatToken = "token"
+	env.DeviceSilenceThreshold = time.Second
+	env.OccupancyWindow = time.Hour
+	env.OccupancyFloor = -1
+	env.NewsletterAPIKey = "abc123"
+	env.KeycloakRetryAttempts = -1
+	err := env.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "SELF_URL")
+	assert.Contains(t, err.Error(), "WEBHOOK_URL")
+	assert.Contains(t, err.Error(), "KEYCLOAK_WEBHOOK_SECRET")
+	assert.Contains(t, err.Error(), "PAYPAL_CLIENT_ID and PAYPAL_CLIENT_SECRET")
+	assert.Contains(t, err.Error(), "ACCESS_SCHEDULES")
+	assert.Contains(t, err.Error(), "SIGNUP_EMAIL_LIFESPAN")
+	assert.Contains(t, err.Error(), "REDIRECT_ALLOWED_HOSTS")
+	assert.Contains(t, err.Error(), "SWIPE_RETENTION")
+	assert.Contains(t, err.Error(), "GUEST_DAILY_LIMIT")
+	assert.Contains(t, err.Error(), "SIGNUP_RATE_LIMIT")
+	assert.Contains(t, err.Error(), "WEBHOOK_RATE_LIMIT")
+	assert.Contains(t, err.Error(), "EVENT_PSQL_MIN_CONNS")
+	assert.Contains(t, err.Error(), "EVENT_CATEGORY_COLORS")
+	assert.Contains(t, err.Error(), "ACCESS_WAIVER_POLICY")
+	assert.Contains(t, err.Error(), "DEVICE_SILENCE_THRESHOLD")
+	assert.Contains(t, err.Error(), "OCCUPANCY_WINDOW")
+	assert.Contains(t, err.Error(), "OCCUPANCY_FLOOR")
+	assert.Contains(t, err.Error(), "NEWSLETTER_API_KEY and NEWSLETTER_LIST_ID")
+	assert.Contains(t, err.Error(), "Mailchimp datacenter")
+	assert.Contains(t, err.Error(), "KEYCLOAK_RETRY_ATTEMPTS")
+
+	env = valid()
+	env.PaypalClientID = "foo"
+	env.PaypalClientSecret = "bar"
+	assert.NoError(t, env.Validate())
+}
+
+func TestRedacted(t *testing.T) {
+	env := &Env{
+		SelfURL:             "https://profile.example.com",
+		StripeKey:           "sk_live_secret",
+		PaypalReportWebhook: "https://discord.com/api/webhooks/123/secret",
+		EventPsqlPassword:   "hunter2",
+		MemberCap:           100,
+		KeycloakRealm:       "master",
+	}
+	assert.Equal(t, "KeycloakRealm=master SelfURL=https://profile.example.com MemberCap=100 StripeKey=<redacted> PaypalReportWebhook=<redacted> EventPsqlPassword=<redacted>", env.Redacted())
+}
+
+func TestFobRanges(t *testing.T) {
+	ranges := FobRanges{}
+	require.NoError(t, ranges.Decode("100-199, contractor:9000-9999"))
+	assert.Equal(t, "100-199, contractor:9000-9999", ranges.String())
+
+	assert.Nil(t, ranges.Find(99))
+	assert.Equal(t, "", ranges.Find(100).Name)
+	assert.Equal(t, "", ranges.Find(199).Name)
+	assert.Equal(t, "contractor", ranges.Find(9500).Name)
+
+	assert.Error(t, ranges.Decode("100"))
+	assert.Error(t, ranges.Decode("200-100"))
+	assert.Error(t, ranges.Decode("a-100"))
+
+	require.NoError(t, ranges.Decode(""))
+	assert.Len(t, ranges, 0)
+}
+
+func TestAccessSchedules(t *testing.T) {
+	schedules := AccessSchedules{}
+	require.NoError(t, schedules.Decode("standard:8-22, premium:0-24"))
+	assert.Equal(t, "premium:0-24,standard:8-22", schedules.String())
+
+	assert.Equal(t, "8am-10pm", schedules.ForTier("standard").String())
+	assert.Equal(t, "24/7", schedules.ForTier("premium").String())
+	assert.Equal(t, "8am-10pm", schedules.ForTier("unknown").String(), "falls back to standard")
+	assert.Equal(t, "24/7", AccessSchedules{}.ForTier("standard").String())
+
+	assert.Error(t, schedules.Decode("standard"))
+	assert.Error(t, schedules.Decode("standard:8"))
+	assert.Error(t, schedules.Decode("standard:22-8"))
+	assert.Error(t, schedules.Decode("standard:0-25"))
+}
+
+func TestDefaultAccessSchedules(t *testing.T) {
+	field, _ := reflect.TypeOf(Env{}).FieldByName("AccessSchedules")
+	schedules := AccessSchedules{}
+	require.NoError(t, schedules.Decode(field.Tag.Get("default")))
+
+	lateNight := time.Date(2024, 1, 1, 23, 0, 0, 0, time.UTC)
+	assert.True(t, schedules.ForTier(datamodel.TierStandard).AllowedAt(lateNight), "hours are opt-in")
+}
+
+func TestLockedFields(t *testing.T) {
+	fields := LockedFields{}
+	require.NoError(t, fields.Decode("first:waiver, last:waiver,emergencyPhone:always"))
+	assert.Equal(t, "emergencyPhone:always,first:waiver,last:waiver", fields.String())
+
+	assert.Equal(t, map[string]bool{"emergencyPhone": true}, fields.For(&datamodel.User{}))
+	assert.Equal(t, map[string]bool{"emergencyPhone": true, "first": true, "last": true}, fields.For(&datamodel.User{WaiverState: "Signed"}))
+
+	assert.Error(t, fields.Decode("first"))
+	assert.Error(t, fields.Decode("email:always"))
+	assert.Error(t, fields.Decode("first:sometimes"))
+}
+
+func TestNicknameFormat(t *testing.T) {
+	var n NicknameFormat
+	require.NoError(t, n.Decode("{first} {l}."))
+	assert.Equal(t, "Ada L.", n.For(&datamodel.User{First: " Ada", Last: "lovelace"}))
+	assert.Equal(t, "Ada", n.For(&datamodel.User{First: "Ada"}))
+	assert.Equal(t, "", n.For(&datamodel.User{}))
+
+	require.NoError(t, n.Decode("{first} {last}"))
+	assert.Equal(t, "Ada", n.For(&datamodel.User{First: "Ada"}))
+	assert.Equal(t, "Wolfeschlegelsteinhausenbergerdo", n.For(&datamodel.User{First: "Wolfeschlegelsteinhausenbergerdorff", Last: "Sr"}))
+
+	require.NoError(t, n.Decode(""))
+	assert.Equal(t, "", n.For(&datamodel.User{First: "Ada"}))
+
+	assert.Error(t, n.Decode("member"))
+	assert.Error(t, n.Decode("{first} {middle}"))
+}
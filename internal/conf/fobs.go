@@ -0,0 +1,78 @@
+package conf
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// FobRange is an inclusive range of fob IDs, optionally named e.g. "contractor".
+type FobRange struct {
+	Name     string
+	Min, Max int
+}
+
+func (r *FobRange) Contains(id int) bool { return id >= r.Min && id <= r.Max }
+
+func (r *FobRange) String() string {
+	if r.Name == "" {
+		return fmt.Sprintf("%d-%d", r.Min, r.Max)
+	}
+	return fmt.Sprintf("%s:%d-%d", r.Name, r.Min, r.Max)
+}
+
+// FobRanges is loaded from a comma separated list of "min-max" or "name:min-max" ranges.
+type FobRanges []*FobRange
+
+// Decode implements envconfig.Decoder.
+func (f *FobRanges) Decode(value string) error {
+	ranges := FobRanges{}
+	for _, chunk := range strings.Split(value, ",") {
+		chunk = strings.TrimSpace(chunk)
+		if chunk == "" {
+			continue
+		}
+
+		r := &FobRange{}
+		if name, rest, ok := strings.Cut(chunk, ":"); ok {
+			r.Name = name
+			chunk = rest
+		}
+
+		min, max, ok := strings.Cut(chunk, "-")
+		if !ok {
+			return fmt.Errorf("fob range %q must be in the form min-max", chunk)
+		}
+		var err error
+		if r.Min, err = strconv.Atoi(min); err != nil {
+			return fmt.Errorf("parsing fob range %q: %w", chunk, err)
+		}
+		if r.Max, err = strconv.Atoi(max); err != nil {
+			return fmt.Errorf("parsing fob range %q: %w", chunk, err)
+		}
+		if r.Min > r.Max {
+			return fmt.Errorf("fob range %q ends before it starts", chunk)
+		}
+		ranges = append(ranges, r)
+	}
+	*f = ranges
+	return nil
+}
+
+func (f FobRanges) String() string {
+	strs := make([]string, len(f))
+	for i, r := range f {
+		strs[i] = r.String()
+	}
+	return strings.Join(strs, ", ")
+}
+
+// Find returns the first range containing the given fob ID, or nil.
+func (f FobRanges) Find(id int) *FobRange {
+	for _, r := range f {
+		if r.Contains(id) {
+			return r
+		}
+	}
+	return nil
+}
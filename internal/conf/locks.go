@@ -0,0 +1,67 @@
+package conf
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+)
+
+// Conditions under which a contact info field is locked.
+const (
+	LockAlways = "always"
+	LockWaiver = "waiver" // once the member has signed the waiver, since it records their legal name
+)
+
+// lockableFields are the contact info form fields that can be locked.
+var lockableFields = map[string]bool{"first": true, "last": true, "emergencyName": true, "emergencyPhone": true}
+
+// LockedFields maps contact info form fields to the condition that locks them.
+// It's loaded from a comma separated list of "field:condition" e.g. "first:waiver,last:waiver".
+type LockedFields map[string]string
+
+// Decode implements envconfig.Decoder.
+func (l *LockedFields) Decode(value string) error {
+	fields := LockedFields{}
+	for _, chunk := range strings.Split(value, ",") {
+		chunk = strings.TrimSpace(chunk)
+		if chunk == "" {
+			continue
+		}
+
+		field, cond, ok := strings.Cut(chunk, ":")
+		if !ok {
+			return fmt.Errorf("locked field %q must be in the form field:condition", chunk)
+		}
+		if !lockableFields[field] {
+			return fmt.Errorf("field %q can't be locked", field)
+		}
+		if cond != LockAlways && cond != LockWaiver {
+			return fmt.Errorf("locked field %q has unknown condition %q (expected %s or %s)", field, cond, LockAlways, LockWaiver)
+		}
+		fields[field] = cond
+	}
+	*l = fields
+	return nil
+}
+
+// For returns the fields that are currently locked for the given user.
+func (l LockedFields) For(user *datamodel.User) map[string]bool {
+	locked := map[string]bool{}
+	for field, cond := range l {
+		if cond == LockAlways || (cond == LockWaiver && user.WaiverState == "Signed") {
+			locked[field] = true
+		}
+	}
+	return locked
+}
+
+func (l LockedFields) String() string {
+	parts := []string{}
+	for field, cond := range l {
+		parts = append(parts, field+":"+cond)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,68 @@
+package conf
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+)
+
+// maxNicknameLength is Discord's limit for guild nicknames.
+const maxNicknameLength = 32
+
+var nicknamePlaceholder = regexp.MustCompile(`\{[^}]*\}`)
+
+// NicknameFormat is the Discord nickname given to linked members e.g. "{first} {l}." for "Ada L.".
+// Placeholders are {first} and {last} for the member's names, and {f} and {l} for their initials.
+type NicknameFormat string
+
+// Decode implements envconfig.Decoder.
+func (n *NicknameFormat) Decode(value string) error {
+	placeholders := nicknamePlaceholder.FindAllString(value, -1)
+	if value != "" && len(placeholders) == 0 {
+		return fmt.Errorf("nickname format %q doesn't include the member's name", value)
+	}
+	for _, p := range placeholders {
+		switch p {
+		case "{first}", "{last}", "{f}", "{l}":
+		default:
+			return fmt.Errorf("nickname format has unknown placeholder %s (expected {first}, {last}, {f}, or {l})", p)
+		}
+	}
+	*n = NicknameFormat(value)
+	return nil
+}
+
+// For returns the user's nickname, or an empty string if the format is unset or the user hasn't given their name.
+func (n NicknameFormat) For(user *datamodel.User) string {
+	first, last := strings.TrimSpace(user.First), strings.TrimSpace(user.Last)
+	if n == "" || (first == "" && last == "") {
+		return ""
+	}
+
+	nick := strings.NewReplacer("{first}", first, "{last}", last, "{f}", initial(first), "{l}", initial(last)).Replace(string(n))
+
+	// Drop whatever is left around missing names e.g. the period in "{first} {l}." when there's no last name
+	words := []string{}
+	for _, word := range strings.Fields(nick) {
+		if strings.IndexFunc(word, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) >= 0 {
+			words = append(words, word)
+		}
+	}
+	nick = strings.Join(words, " ")
+	for utf8.RuneCountInString(nick) > maxNicknameLength {
+		nick = string([]rune(nick)[:maxNicknameLength])
+	}
+	return nick
+}
+
+func initial(name string) string {
+	r, _ := utf8.DecodeRuneInString(name)
+	if r == utf8.RuneError {
+		return ""
+	}
+	return string(unicode.ToUpper(r))
+}
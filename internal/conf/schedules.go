@@ -0,0 +1,72 @@
+package conf
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+)
+
+// AccessSchedules maps membership tiers to the hours they can access the building.
+// It's loaded from a comma separated list of "tier:open-close" e.g. "standard:8-22,premium:0-24".
+type AccessSchedules map[string]*datamodel.Schedule
+
+// Decode implements envconfig.Decoder.
+func (a *AccessSchedules) Decode(value string) error {
+	schedules := AccessSchedules{}
+	for _, chunk := range strings.Split(value, ",") {
+		chunk = strings.TrimSpace(chunk)
+		if chunk == "" {
+			continue
+		}
+
+		tier, hours, ok := strings.Cut(chunk, ":")
+		if !ok {
+			return fmt.Errorf("access schedule %q must be in the form tier:open-close", chunk)
+		}
+		open, close, ok := strings.Cut(hours, "-")
+		if !ok {
+			return fmt.Errorf("access schedule %q must be in the form tier:open-close", chunk)
+		}
+		s := &datamodel.Schedule{}
+		var err error
+		if s.OpenHour, err = strconv.Atoi(open); err != nil {
+			return fmt.Errorf("parsing access schedule %q: %w", chunk, err)
+		}
+		if s.CloseHour, err = strconv.Atoi(close); err != nil {
+			return fmt.Errorf("parsing access schedule %q: %w", chunk, err)
+		}
+		if s.OpenHour < 0 || s.OpenHour >= s.CloseHour || s.CloseHour > 24 {
+			return fmt.Errorf("access schedule %q must open before it closes, both within 0-24", chunk)
+		}
+		schedules[tier] = s
+	}
+	*a = schedules
+	return nil
+}
+
+// ForTier returns the tier's schedule. Tiers without one get the standard schedule, or 24/7 access if
+// no schedules are configured at all.
+func (a AccessSchedules) ForTier(tier string) *datamodel.Schedule {
+	if s, ok := a[tier]; ok {
+		return s
+	}
+	if s, ok := a[datamodel.TierStandard]; ok {
+		return s
+	}
+	return &datamodel.Schedule{OpenHour: 0, CloseHour: 24}
+}
+
+func (a AccessSchedules) String() string {
+	tiers := make([]string, 0, len(a))
+	for tier := range a {
+		tiers = append(tiers, tier)
+	}
+	sort.Strings(tiers)
+	for i, tier := range tiers {
+		tiers[i] = fmt.Sprintf("%s:%d-%d", tier, a[tier].OpenHour, a[tier].CloseHour)
+	}
+	return strings.Join(tiers, ",")
+}
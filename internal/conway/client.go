@@ -0,0 +1,150 @@
+package conway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+// ErrConflict is returned when Conway's record kept changing while the patch was being retried.
+var ErrConflict = errors.New("conway record changed concurrently")
+
+// patchAttempts limits how many times a patch is retried after a conflict.
+const patchAttempts = 3
+
+// Client pushes member changes to Conway's API.
+type Client struct {
+	URL, Token string
+	HTTP       *http.Client
+}
+
+func NewClient(url, token string) *Client {
+	return &Client{URL: url, Token: token, HTTP: http.DefaultClient}
+}
+
+// PatchMember sets the given fields on the member's Conway record.
+//
+// The record is read first so only fields that differ are sent, and the patch is made conditional on the record's
+// ETag. If the record changes in the meantime (409 or 412), it's read again and any field that was edited directly
+// in Conway is dropped from the patch rather than overwritten. Those fields are reapplied by a later sync if they
+// still differ, since Keycloak is the source of truth for them - but not blindly in the middle of someone's edit.
+func (c *Client) PatchMember(ctx context.Context, email string, fields map[string]any) error {
+	base, etag, err := c.getMember(ctx, email)
+	if err != nil {
+		return fmt.Errorf("getting member: %w", err)
+	}
+
+	patch := diffFields(base, fields)
+	for attempt := 0; attempt < patchAttempts; attempt++ {
+		if len(patch) == 0 {
+			return nil // nothing to change
+		}
+
+		status, err := c.patchMember(ctx, email, etag, patch)
+		if err != nil {
+			return err
+		}
+		if status == http.StatusNoContent {
+			return nil
+		}
+		if status != http.StatusConflict && status != http.StatusPreconditionFailed {
+			return fmt.Errorf("unexpected status: %d", status)
+		}
+
+		// Someone else got there first - keep their edits
+		current, currentETag, err := c.getMember(ctx, email)
+		if err != nil {
+			return fmt.Errorf("getting member after conflict: %w", err)
+		}
+		for key := range patch {
+			if !jsonEqual(base[key], current[key]) {
+				log.Printf("not overwriting conway field %q for member %s since it was changed concurrently", key, email)
+				delete(patch, key)
+			}
+		}
+		patch = diffFields(current, patch)
+		base, etag = current, currentETag
+	}
+	return ErrConflict
+}
+
+// getMember returns the member's record and its ETag. Members that don't exist yet have an empty record.
+func (c *Client) getMember(ctx context.Context, email string) (map[string]any, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.memberURL(email), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.Token))
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return map[string]any{}, "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+
+	record := map[string]any{}
+	if err := json.NewDecoder(resp.Body).Decode(&record); err != nil {
+		return nil, "", fmt.Errorf("decoding member: %w", err)
+	}
+	return record, resp.Header.Get("ETag"), nil
+}
+
+func (c *Client) patchMember(ctx context.Context, email, etag string, patch map[string]any) (int, error) {
+	js, err := json.Marshal(patch)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "PATCH", c.memberURL(email), bytes.NewBuffer(js))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.Token))
+	if etag != "" {
+		req.Header.Set("If-Match", etag) // older Conway versions don't send ETags, in which case the patch is unconditional
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+func (c *Client) memberURL(email string) string {
+	return fmt.Sprintf("%s/api/members/%s", c.URL, url.PathEscape(email))
+}
+
+// diffFields returns the fields whose values differ from the record.
+func diffFields(record, fields map[string]any) map[string]any {
+	diff := map[string]any{}
+	for key, val := range fields {
+		if current, ok := record[key]; !ok || !jsonEqual(current, val) {
+			diff[key] = val
+		}
+	}
+	return diff
+}
+
+// jsonEqual compares values by their JSON encoding, since decoded records use different types than the ones sent
+// e.g. float64 vs int.
+func jsonEqual(a, b any) bool {
+	aJS, aErr := json.Marshal(a)
+	bJS, bErr := json.Marshal(b)
+	return aErr == nil && bErr == nil && bytes.Equal(aJS, bJS)
+}
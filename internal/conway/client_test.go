@@ -0,0 +1,95 @@
+package conway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeConway serves a single member record with versioned ETags.
+type fakeConway struct {
+	mut     sync.Mutex
+	record  map[string]any
+	version int
+	patches []map[string]any
+	onGet   func() // called after each read e.g. to simulate concurrent edits
+}
+
+func (f *fakeConway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	etag := strconv.Quote(strconv.Itoa(f.version))
+	switch r.Method {
+	case "GET":
+		w.Header().Set("ETag", etag)
+		json.NewEncoder(w).Encode(f.record)
+		if f.onGet != nil {
+			f.onGet()
+		}
+	case "PATCH":
+		if r.Header.Get("If-Match") != etag {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		patch := map[string]any{}
+		json.NewDecoder(r.Body).Decode(&patch)
+		f.patches = append(f.patches, patch)
+		for key, val := range patch {
+			f.record[key] = val
+		}
+		f.version++
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestPatchMember(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("unchanged fields aren't sent", func(t *testing.T) {
+		fake := &fakeConway{record: map[string]any{"name": "Foo Bar", "fob_id": 123}}
+		svr := httptest.NewServer(fake)
+		defer svr.Close()
+
+		client := NewClient(svr.URL, "token")
+		require.NoError(t, client.PatchMember(ctx, "foo@example.com", map[string]any{"name": "Foo Bar", "fob_id": 456}))
+		assert.Equal(t, []map[string]any{{"fob_id": float64(456)}}, fake.patches)
+
+		require.NoError(t, client.PatchMember(ctx, "foo@example.com", map[string]any{"name": "Foo Bar", "fob_id": 456}))
+		assert.Len(t, fake.patches, 1)
+	})
+
+	t.Run("concurrent edits are kept", func(t *testing.T) {
+		fake := &fakeConway{record: map[string]any{"name": "Foo Bar", "fob_id": 123}}
+		fake.onGet = func() {
+			// Someone edits the name in Conway between the sync's read and write
+			fake.record["name"] = "Foo Baz"
+			fake.version++
+			fake.onGet = nil
+		}
+		svr := httptest.NewServer(fake)
+		defer svr.Close()
+
+		client := NewClient(svr.URL, "token")
+		require.NoError(t, client.PatchMember(ctx, "foo@example.com", map[string]any{"name": "Foo Qux", "fob_id": 456}))
+		assert.Equal(t, []map[string]any{{"fob_id": float64(456)}}, fake.patches)
+		assert.Equal(t, "Foo Baz", fake.record["name"])
+	})
+
+	t.Run("gives up eventually", func(t *testing.T) {
+		fake := &fakeConway{record: map[string]any{"name": "Foo Bar"}}
+		fake.onGet = func() { fake.version++ }
+		svr := httptest.NewServer(fake)
+		defer svr.Close()
+
+		client := NewClient(svr.URL, "token")
+		assert.ErrorIs(t, client.PatchMember(ctx, "foo@example.com", map[string]any{"fob_id": 456}), ErrConflict)
+	})
+}
@@ -0,0 +1,48 @@
+// Package conway handles the integration with Conway, the space's newer member management system.
+package conway
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/TheLab-ms/profile/internal/chatbot"
+)
+
+// SignatureHeader holds the hex encoded HMAC-SHA256 of the request body.
+const SignatureHeader = "X-Conway-Signature"
+
+type webhookMsg struct {
+	Email   string   `json:"email"`
+	Changes []string `json:"changes"` // e.g. "building_access", "leadership" - informational only
+}
+
+// NewWebhookHandler accepts notifications that Conway has changed a member.
+// fn is called with the member's email and should return false if the notification needs to be retried.
+func NewWebhookHandler(secret string, fn func(email string) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<16))
+		if err != nil {
+			w.WriteHeader(400)
+			return
+		}
+		sig := r.Header.Get(SignatureHeader)
+		if secret == "" || !hmac.Equal([]byte(sig), []byte(chatbot.GenerateHMAC(string(body), secret))) {
+			w.WriteHeader(401)
+			return
+		}
+
+		msg := &webhookMsg{}
+		if err := json.Unmarshal(body, msg); err != nil || msg.Email == "" {
+			w.WriteHeader(400)
+			return
+		}
+		log.Printf("got conway webhook for member %s (changes: %v)", msg.Email, msg.Changes)
+		if !fn(msg.Email) {
+			w.WriteHeader(500)
+			return
+		}
+	})
+}
@@ -0,0 +1,41 @@
+package conway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TheLab-ms/profile/internal/chatbot"
+)
+
+func TestWebhookHandler(t *testing.T) {
+	var got []string
+	ok := true
+	h := NewWebhookHandler("secret", func(email string) bool {
+		got = append(got, email)
+		return ok
+	})
+
+	send := func(body, sig string) int {
+		r := httptest.NewRequest("POST", "/webhooks/conway", strings.NewReader(body))
+		r.Header.Set(SignatureHeader, sig)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	body := `{"email": "foo@example.com", "changes": ["leadership"]}`
+	assert.Equal(t, http.StatusOK, send(body, chatbot.GenerateHMAC(body, "secret")))
+	assert.Equal(t, []string{"foo@example.com"}, got)
+
+	assert.Equal(t, http.StatusUnauthorized, send(body, ""))
+	assert.Equal(t, http.StatusUnauthorized, send(body, chatbot.GenerateHMAC(body, "wrong")))
+	assert.Equal(t, http.StatusBadRequest, send(`{}`, chatbot.GenerateHMAC(`{}`, "secret")))
+	assert.Len(t, got, 1)
+
+	ok = false
+	assert.Equal(t, http.StatusInternalServerError, send(body, chatbot.GenerateHMAC(body, "secret")))
+}
@@ -1,9 +1,24 @@
 package datamodel
 
 type Event struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Start       int64  `json:"start"`
-	End         int64  `json:"end"`
-	MembersOnly bool   `json:"membersOnly"`
+	ID          string   `json:"-"` // the Discord event, shared by every occurrence of recurring events
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Start       int64    `json:"start"`
+	End         int64    `json:"end"`
+	MembersOnly bool     `json:"membersOnly"`
+	Categories  []string `json:"categories"`      // from [tags] in the name or description e.g. "woodshop"
+	Color       string   `json:"color,omitempty"` // of the first category that has one (see conf.Env.EventCategoryColors)
+}
+
+// InCategory returns true if the event has any of the given categories.
+func (e *Event) InCategory(categories ...string) bool {
+	for _, c := range categories {
+		for _, ec := range e.Categories {
+			if c == ec {
+				return true
+			}
+		}
+	}
+	return false
 }
@@ -0,0 +1,35 @@
+package datamodel
+
+// Notification categories members can opt out of.
+// Transactional messages the member asked for (login links, waitlist invitations, etc.) are always sent.
+const (
+	NotifyPayment   = "payment"
+	NotifyEvents    = "events"
+	NotifyCommunity = "community"
+)
+
+// Notification channels.
+const (
+	ChannelEmail   = "email"
+	ChannelDiscord = "discord"
+)
+
+// NotificationCategory describes a category on the preference page.
+type NotificationCategory struct {
+	Name, Title, Description string
+}
+
+var NotificationCategories = []*NotificationCategory{
+	{Name: NotifyPayment, Title: "Payments", Description: "Receipts, failed payments, and other billing updates"},
+	{Name: NotifyEvents, Title: "Events", Description: "Reminders and digests of upcoming events"},
+	{Name: NotifyCommunity, Title: "Community", Description: "Announcements and tips for getting involved at the space"},
+}
+
+var NotificationChannels = []string{ChannelEmail, ChannelDiscord}
+
+func NotificationKey(category, channel string) string { return category + "/" + channel }
+
+// WantsNotification returns false if the member has opted out of the category on the given channel.
+func (u *User) WantsNotification(category, channel string) bool {
+	return !u.NotificationOptOuts[NotificationKey(category, channel)]
+}
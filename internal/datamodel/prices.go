@@ -1,7 +1,13 @@
 package datamodel
 
+// ProductMembership is the product that grants membership.
+// Any other configured Stripe products are addons billed alongside it.
+const ProductMembership = "membership"
+
 type PriceDetails struct {
 	ID, ProductID    string
+	Product          string // key of the product in the STRIPE_PRODUCTS config e.g. "membership"
+	ProductName      string
 	Annual           bool
 	Price            float64
 	CouponIDs        map[string]string
@@ -11,6 +17,17 @@ type PriceDetails struct {
 type Prices struct {
 	Yearly  Price `json:"yearly"`
 	Monthly Price `json:"monthly"`
+
+	// Set when the prices were computed for a specific member. Discounted is then the price they'd actually pay
+	// rather than the best available discount.
+	Personalized bool         `json:"personalized,omitempty"`
+	DiscountType string       `json:"discount_type,omitempty"`
+	Paypal       *PaypalPrice `json:"paypal,omitempty"` // members migrating from PayPal keep their old rate
+}
+
+type PaypalPrice struct {
+	Price  float64 `json:"price"`
+	Annual bool    `json:"annual"`
 }
 
 func NewPrices(items []*PriceDetails) *Prices {
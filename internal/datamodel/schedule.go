@@ -0,0 +1,40 @@
+package datamodel
+
+import (
+	"fmt"
+	"time"
+)
+
+// Schedule is the daily window during which members of a tier can enter the building, in the space's local time.
+type Schedule struct {
+	OpenHour, CloseHour int // 0-24 is 24/7
+}
+
+// AllowedAt returns true if the given time (already converted to the space's timezone) is within the schedule.
+func (s *Schedule) AllowedAt(t time.Time) bool {
+	hour := t.Hour()
+	return hour >= s.OpenHour && hour < s.CloseHour
+}
+
+func (s *Schedule) AlwaysOpen() bool { return s.OpenHour == 0 && s.CloseHour == 24 }
+
+// String returns the schedule in a form members can read e.g. "8am-10pm".
+func (s *Schedule) String() string {
+	if s.AlwaysOpen() {
+		return "24/7"
+	}
+	return formatHour(s.OpenHour) + "-" + formatHour(s.CloseHour)
+}
+
+func formatHour(hour int) string {
+	switch {
+	case hour == 0 || hour == 24:
+		return "midnight"
+	case hour == 12:
+		return "noon"
+	case hour < 12:
+		return fmt.Sprintf("%dam", hour)
+	default:
+		return fmt.Sprintf("%dpm", hour-12)
+	}
+}
@@ -21,15 +21,82 @@ type User struct {
 	WaiverState            string    `keycloak:"attr.waiverState"`
 	NonBillable            bool      `keycloak:"attr.nonBillable"`
 	DiscountType           string    `keycloak:"attr.discountType"`
+	MembershipTier         string    `keycloak:"attr.membershipTier"`
 	BuildingAccessApprover string    `keycloak:"attr.buildingAccessApprover"`
 	SignupTime             time.Time `keycloak:"attr.signupEpochTimeUTC"`
 	LastSwipeTime          time.Time `keycloak:"attr.lastSwipeTime"`
 	DiscordUserID          int64     `keycloak:"attr.discordUserID"`
+	PreviousDiscordUserID  int64     `keycloak:"attr.previousDiscordUserID"` // replaced by a relink, so profile-async can remove its roles
+	DiscordIntroOptOut     bool      `keycloak:"attr.discordIntroOptOut"`
+	DiscordIntroThreadID   string    `keycloak:"attr.discordIntroThreadID"`
 	SignupEmailSentTime    time.Time `keycloak:"attr.signupEmailSentTime"`
+	SignupEmailResends     int       `keycloak:"attr.signupEmailResends"` // requested from /signup after the first one expired
+	EmailVerifiedTime      time.Time `keycloak:"attr.emailVerifiedTime"`  // when we first noticed the verification, not exact
+
+	// EmailBounceTime is set when an email to the member hard-bounced e.g. because of a typo in the address.
+	// It's cleared once an email is delivered.
+	EmailBounceTime   time.Time `keycloak:"attr.emailBounceTime"`
+	EmailBounceReason string    `keycloak:"attr.emailBounceReason"`
+
+	// DiscountExpiration is when DiscountType reverts e.g. at the end of a semester (zero for discounts that don't expire).
+	// DiscountNoticeTime is set when the member is warned that their discount is about to expire.
+	DiscountExpiration time.Time `keycloak:"attr.discountExpiration"`
+	DiscountNoticeTime time.Time `keycloak:"attr.discountNoticeTime"`
+
+	// StripePromotionCode is a promotion code redeemed from the profile page before subscribing.
+	// It's applied at checkout, and cleared once the subscription is active.
+	StripePromotionCode string `keycloak:"attr.stripePromotionCode"`
+
+	// WelcomeSteps maps completed welcome sequence steps to their completion time
+	WelcomeSteps map[string]time.Time `keycloak:"attr.welcomeSteps"`
+
+	// PaypalMigrationNotices counts the escalating notices sent to members still paying through Paypal after the
+	// migration cutoff. PaypalMigrationNoticeTime is when the latest one was sent (see cmd/paypal-check-job).
+	PaypalMigrationNotices    int       `keycloak:"attr.paypalMigrationNotices"`
+	PaypalMigrationNoticeTime time.Time `keycloak:"attr.paypalMigrationNoticeTime"`
 
 	StripeCustomerID      string    `keycloak:"attr.stripeID"`
 	StripeSubscriptionID  string    `keycloak:"attr.stripeSubscriptionID"`
 	StripeCancelationTime time.Time `keycloak:"attr.stripeCancelationTime"`
+
+	// StripeSubscriptionStatus is the raw Stripe status from the latest subscription webhook e.g. "active", "past_due".
+	// StripeGracePeriodEnd is set when the subscription first becomes past due, and cleared once it isn't.
+	StripeSubscriptionStatus string    `keycloak:"attr.stripeSubscriptionStatus"`
+	StripeGracePeriodEnd     time.Time `keycloak:"attr.stripeGracePeriodEnd"`
+
+	// LockerNumber is the storage locker rented by the member, if any.
+	// StripeLockerItemID is the subscription item billing for it (empty for members who don't pay through Stripe).
+	LockerNumber       string `keycloak:"attr.lockerNumber"`
+	StripeLockerItemID string `keycloak:"attr.stripeLockerItemID"`
+
+	// DoorPINHash is the member's keypad PIN for doors without a fob reader (see access.HashPIN).
+	// DoorPINReminderTime is set when the member is reminded to change a PIN older than conf.Env.DoorPINRotation.
+	DoorPINHash         string    `keycloak:"attr.doorPINHash"`
+	DoorPINSetTime      time.Time `keycloak:"attr.doorPINSetTime"`
+	DoorPINReminderTime time.Time `keycloak:"attr.doorPINReminderTime"`
+
+	// Banned members are denied building access and lose their Discord roles regardless of payment, until leadership
+	// lifts the ban. BanReason and BanTime are set by leadership along with the flag.
+	Banned    bool      `keycloak:"attr.banned"`
+	BanReason string    `keycloak:"attr.banReason"`
+	BanTime   time.Time `keycloak:"attr.banTime"`
+
+	// Certifications are the equipment the member has been trained on e.g. "laser-cutter"
+	Certifications []string `keycloak:"attr.certifications"`
+
+	// Optional contact for leadership to reach if something happens to the member at the space
+	EmergencyContactName  string `keycloak:"attr.emergencyContactName"`
+	EmergencyContactPhone string `keycloak:"attr.emergencyContactPhone"`
+
+	// NotificationOptOuts holds the notifications the member doesn't want, keyed by NotificationKey.
+	// Members receive everything by default.
+	NotificationOptOuts map[string]bool `keycloak:"attr.notificationOptOuts"`
+
+	// NewsletterOptIn is set when the member asks for the newsletter, and cleared if they unsubscribe through it.
+	// NewsletterStatus is synced to the mailing provider's status (see internal/newsletter) e.g. "pending" until they
+	// confirm the double opt-in email.
+	NewsletterOptIn  bool   `keycloak:"attr.newsletterOptIn"`
+	NewsletterStatus string `keycloak:"attr.newsletterStatus"`
 }
 
 func (u *User) PaymentStatus() string {
@@ -44,3 +111,47 @@ func (u *User) PaymentStatus() string {
 	}
 	return "InactiveOrUnknown"
 }
+
+// Membership tiers. Members without a tier are standard members.
+const (
+	TierStandard = "standard"
+	TierPremium  = "premium" // 24/7 building access
+)
+
+// Tiers lists every membership tier leadership can assign.
+var Tiers = []string{TierStandard, TierPremium}
+
+// Tier returns the member's tier, defaulting to standard.
+func (u *User) Tier() string {
+	if u.MembershipTier == "" {
+		return TierStandard
+	}
+	return u.MembershipTier
+}
+
+// Subscription states shared with Conway.
+const (
+	SubscriptionStateActive          = "active"
+	SubscriptionStatePastDue         = "past_due"
+	SubscriptionStateGrace           = "grace"
+	SubscriptionStateCanceledPending = "canceled_pending"
+	SubscriptionStateUnknown         = "unknown"
+)
+
+// SubscriptionState summarizes the member's payment state for external systems.
+// activeMember is the member's group membership i.e. whether we currently consider them to be a member.
+func (u *User) SubscriptionState(activeMember bool, now time.Time) string {
+	if u.StripeSubscriptionStatus == "past_due" {
+		if now.Before(u.StripeGracePeriodEnd) {
+			return SubscriptionStateGrace
+		}
+		return SubscriptionStatePastDue
+	}
+	if !activeMember {
+		return SubscriptionStateUnknown
+	}
+	if u.StripeSubscriptionID != "" && u.StripeCancelationTime.After(now) {
+		return SubscriptionStateCanceledPending
+	}
+	return SubscriptionStateActive
+}
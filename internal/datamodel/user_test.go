@@ -0,0 +1,35 @@
+package datamodel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscriptionState(t *testing.T) {
+	now := time.Unix(100000, 0)
+
+	assert.Equal(t, SubscriptionStateActive, (&User{StripeSubscriptionID: "foo"}).SubscriptionState(true, now))
+	assert.Equal(t, SubscriptionStateActive, (&User{NonBillable: true}).SubscriptionState(true, now))
+	assert.Equal(t, SubscriptionStateUnknown, (&User{}).SubscriptionState(false, now))
+
+	// Canceled but still within the paid period
+	assert.Equal(t, SubscriptionStateCanceledPending, (&User{StripeSubscriptionID: "foo", StripeCancelationTime: now.Add(time.Hour)}).SubscriptionState(true, now))
+	assert.Equal(t, SubscriptionStateActive, (&User{StripeSubscriptionID: "foo", StripeCancelationTime: now.Add(-time.Hour)}).SubscriptionState(true, now))
+
+	// Past due
+	assert.Equal(t, SubscriptionStateGrace, (&User{StripeSubscriptionStatus: "past_due", StripeGracePeriodEnd: now.Add(time.Hour)}).SubscriptionState(true, now))
+	assert.Equal(t, SubscriptionStatePastDue, (&User{StripeSubscriptionStatus: "past_due", StripeGracePeriodEnd: now.Add(-time.Hour)}).SubscriptionState(false, now))
+	assert.Equal(t, SubscriptionStatePastDue, (&User{StripeSubscriptionStatus: "past_due"}).SubscriptionState(false, now))
+}
+
+func TestWantsNotification(t *testing.T) {
+	u := &User{}
+	assert.True(t, u.WantsNotification(NotifyPayment, ChannelEmail))
+
+	u.NotificationOptOuts = map[string]bool{NotificationKey(NotifyPayment, ChannelEmail): true}
+	assert.False(t, u.WantsNotification(NotifyPayment, ChannelEmail))
+	assert.True(t, u.WantsNotification(NotifyPayment, ChannelDiscord))
+	assert.True(t, u.WantsNotification(NotifyEvents, ChannelEmail))
+}
@@ -0,0 +1,128 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"time"
+
+	"github.com/TheLab-ms/profile/internal/conf"
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/emailtmpl"
+)
+
+var (
+	ErrNotConfigured = errors.New("email sending is not configured")
+	ErrOptedOut      = errors.New("member has opted out of this notification")
+)
+
+// Sender delivers transactional emails over SMTP.
+// Keycloak sends its own emails (password reset, etc.) - this is only used for messages we originate.
+type Sender struct {
+	env *conf.Env
+}
+
+func NewSender(env *conf.Env) *Sender {
+	return &Sender{env: env}
+}
+
+func (s *Sender) Enabled() bool { return s != nil && s.env.SMTPAddr != "" && s.env.SMTPFrom != "" }
+
+// Attachment is a file attached to an email.
+type Attachment struct {
+	Name, ContentType string
+	Data              []byte
+}
+
+// Send delivers an HTML email to a single recipient.
+func (s *Sender) Send(ctx context.Context, to, subject, html string, attachments ...*Attachment) error {
+	if !s.Enabled() {
+		return ErrNotConfigured
+	}
+
+	host, _, err := net.SplitHostPort(s.env.SMTPAddr)
+	if err != nil {
+		return fmt.Errorf("parsing smtp address: %w", err)
+	}
+	var auth smtp.Auth
+	if s.env.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", s.env.SMTPUsername, s.env.SMTPPassword, host)
+	}
+
+	msg := &bytes.Buffer{}
+	fmt.Fprintf(msg, "From: %s\r\n", s.env.SMTPFrom)
+	fmt.Fprintf(msg, "To: %s\r\n", to)
+	fmt.Fprintf(msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(msg, "MIME-Version: 1.0\r\n")
+	if len(attachments) == 0 {
+		fmt.Fprintf(msg, "Content-Type: text/html; charset=\"UTF-8\"\r\n\r\n")
+		msg.WriteString(html)
+	} else if err := writeMultipart(msg, html, attachments); err != nil {
+		return fmt.Errorf("encoding email: %w", err)
+	}
+
+	// net/smtp doesn't support contexts so the best we can do is not start sending if it's already canceled
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := smtp.SendMail(s.env.SMTPAddr, auth, s.env.SMTPFrom, []string{to}, msg.Bytes()); err != nil {
+		return fmt.Errorf("sending email: %w", err)
+	}
+	return nil
+}
+
+func writeMultipart(msg *bytes.Buffer, html string, attachments []*Attachment) error {
+	w := multipart.NewWriter(msg)
+	fmt.Fprintf(msg, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n", w.Boundary())
+
+	part, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {`text/html; charset="UTF-8"`}})
+	if err != nil {
+		return err
+	}
+	part.Write([]byte(html))
+
+	for _, a := range attachments {
+		part, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {a.ContentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {fmt.Sprintf("attachment; filename=%q", a.Name)},
+		})
+		if err != nil {
+			return err
+		}
+
+		// RFC 2045 limits lines to 76 characters
+		encoded := base64.StdEncoding.EncodeToString(a.Data)
+		for len(encoded) > 76 {
+			fmt.Fprintf(part, "%s\r\n", encoded[:76])
+			encoded = encoded[76:]
+		}
+		fmt.Fprintf(part, "%s\r\n", encoded)
+	}
+	return w.Close()
+}
+
+// SendNotification is SendTemplate for optional notifications i.e. anything the member didn't directly ask for.
+// ErrOptedOut is returned if the member has turned off email for the category.
+func (s *Sender) SendNotification(ctx context.Context, user *datamodel.User, category, name string, data any) error {
+	if !user.WantsNotification(category, datamodel.ChannelEmail) {
+		return ErrOptedOut
+	}
+	return s.SendTemplate(ctx, user.Email, name, data)
+}
+
+// SendTemplate renders the named email (see internal/emailtmpl) and sends it.
+func (s *Sender) SendTemplate(ctx context.Context, to, name string, data any) error {
+	subject, html, err := emailtmpl.Render(name, data)
+	if err != nil {
+		return err
+	}
+	return s.Send(ctx, to, subject, html)
+}
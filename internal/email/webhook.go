@@ -0,0 +1,63 @@
+package email
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/TheLab-ms/profile/internal/chatbot"
+)
+
+// SignatureHeader holds the hex encoded HMAC-SHA256 of the delivery webhook's request body.
+const SignatureHeader = "X-Email-Signature"
+
+// Kinds of delivery events reported by the email provider.
+const (
+	EventDelivered = "delivered"
+	EventBounce    = "bounce"
+	EventComplaint = "complaint"
+)
+
+// DeliveryEvent is the provider's report about an email sent to a member, including the ones sent by Keycloak.
+type DeliveryEvent struct {
+	Event      string `json:"event"`
+	Email      string `json:"email"`
+	BounceType string `json:"bounce_type"` // "hard" or "soft", only set for bounces
+	Reason     string `json:"reason"`      // e.g. the SMTP response for bounces
+}
+
+// HardBounce returns true if the address doesn't work and retrying won't help.
+func (e *DeliveryEvent) HardBounce() bool {
+	return e.Event == EventBounce && e.BounceType == "hard"
+}
+
+// NewWebhookHandler accepts delivery events from the email provider.
+// fn should return false if the event needs to be retried.
+func NewWebhookHandler(secret string, fn func(context.Context, *DeliveryEvent) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<16))
+		if err != nil {
+			w.WriteHeader(400)
+			return
+		}
+		sig := r.Header.Get(SignatureHeader)
+		if secret == "" || !hmac.Equal([]byte(sig), []byte(chatbot.GenerateHMAC(string(body), secret))) {
+			w.WriteHeader(401)
+			return
+		}
+
+		event := &DeliveryEvent{}
+		if err := json.Unmarshal(body, event); err != nil || event.Email == "" {
+			w.WriteHeader(400)
+			return
+		}
+		event.Email = strings.ToLower(event.Email)
+		if !fn(r.Context(), event) {
+			w.WriteHeader(500)
+			return
+		}
+	})
+}
@@ -0,0 +1,43 @@
+package email
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TheLab-ms/profile/internal/chatbot"
+)
+
+func TestWebhookHandler(t *testing.T) {
+	var got []*DeliveryEvent
+	ok := true
+	h := NewWebhookHandler("secret", func(ctx context.Context, event *DeliveryEvent) bool {
+		got = append(got, event)
+		return ok
+	})
+
+	send := func(body, sig string) int {
+		r := httptest.NewRequest("POST", "/webhooks/email", strings.NewReader(body))
+		r.Header.Set(SignatureHeader, sig)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	body := `{"event": "bounce", "email": "Foo@Example.com", "bounce_type": "hard", "reason": "550 no such user"}`
+	assert.Equal(t, http.StatusOK, send(body, chatbot.GenerateHMAC(body, "secret")))
+	assert.Equal(t, []*DeliveryEvent{{Event: EventBounce, Email: "foo@example.com", BounceType: "hard", Reason: "550 no such user"}}, got)
+	assert.True(t, got[0].HardBounce())
+
+	assert.Equal(t, http.StatusUnauthorized, send(body, ""))
+	assert.Equal(t, http.StatusUnauthorized, send(body, chatbot.GenerateHMAC(body, "wrong")))
+	assert.Equal(t, http.StatusBadRequest, send(`{}`, chatbot.GenerateHMAC(`{}`, "secret")))
+	assert.Len(t, got, 1)
+
+	ok = false
+	assert.Equal(t, http.StatusInternalServerError, send(body, chatbot.GenerateHMAC(body, "secret")))
+}
@@ -0,0 +1,174 @@
+// Package emailtmpl renders the transactional emails we send (i.e. not the ones sent by Keycloak).
+//
+// Each email lives in emails/<name>.html and defines a "subject" and "content" template.
+// An optional "text" template is used when the message is sent somewhere other than email.
+// Content is wrapped in the shared layout, and can use the partials defined in layouts/partials.html.
+// Every email must also have sample data registered in Samples so it can be previewed at /admin/email-preview.
+package emailtmpl
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html"
+	"html/template"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+//go:embed layouts/*.html emails/*.html
+var files embed.FS
+
+var templates = map[string]*template.Template{}
+
+var funcs = template.FuncMap{
+	"button": func(url, text string) any { return struct{ URL, Text string }{url, text} },
+}
+
+func init() {
+	base := template.Must(template.New("").Funcs(funcs).ParseFS(files, "layouts/*.html"))
+
+	emails, err := fs.Glob(files, "emails/*.html")
+	if err != nil {
+		panic(err)
+	}
+	for _, file := range emails {
+		name := strings.TrimSuffix(path.Base(file), ".html")
+		templates[name] = template.Must(template.Must(base.Clone()).ParseFS(files, file))
+	}
+}
+
+// Render returns the subject and HTML body of the named email.
+func Render(name string, data any) (subject, body string, err error) {
+	tmpl, ok := templates[name]
+	if !ok {
+		return "", "", fmt.Errorf("unknown email template %q", name)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := tmpl.ExecuteTemplate(buf, "subject", data); err != nil {
+		return "", "", fmt.Errorf("rendering subject: %w", err)
+	}
+	subject = html.UnescapeString(strings.TrimSpace(buf.String()))
+
+	buf.Reset()
+	if err := tmpl.ExecuteTemplate(buf, "layout", data); err != nil {
+		return "", "", fmt.Errorf("rendering body: %w", err)
+	}
+	return subject, buf.String(), nil
+}
+
+// RenderText returns a plain text version of the named email for channels that don't support HTML e.g. Discord DMs.
+// Emails that don't define a "text" template fall back to their subject.
+func RenderText(name string, data any) (string, error) {
+	tmpl, ok := templates[name]
+	if !ok {
+		return "", fmt.Errorf("unknown email template %q", name)
+	}
+	section := "text"
+	if tmpl.Lookup(section) == nil {
+		section = "subject"
+	}
+
+	buf := &bytes.Buffer{}
+	if err := tmpl.ExecuteTemplate(buf, section, data); err != nil {
+		return "", fmt.Errorf("rendering text: %w", err)
+	}
+	return html.UnescapeString(strings.TrimSpace(buf.String())), nil
+}
+
+// Names returns the name of every email template, sorted.
+func Names() []string {
+	names := make([]string, 0, len(templates))
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Data types for each email.
+type (
+	MagicLink struct {
+		Link       string
+		TTLMinutes int
+	}
+	Orientation struct {
+		URL string
+	}
+	DiscordInvite struct {
+		URL string
+	}
+	WaitlistInvitation struct {
+		URL        string
+		Expiration string
+	}
+	AccessRevoked struct {
+		Reason string // completes "Your building access has been paused because ..."
+		URL    string
+	}
+	PaymentFailed struct {
+		AccessUntil string // empty when access has already been revoked
+		URL         string
+	}
+	DiscountExpiring struct {
+		DiscountType string
+		Expiration   string
+		URL          string
+	}
+	DiscountExpired struct {
+		DiscountType string
+		URL          string
+	}
+	PaypalMigration struct {
+		Notice int  // starts at 1 and escalates with each notice
+		Final  bool // the last notice that will be sent
+		URL    string
+	}
+	FobAssigned struct {
+		Hours string // completes "You can get into the building ..." e.g. "24/7"
+		URL   string
+	}
+	EventReminder struct {
+		Name  string
+		Start string // e.g. "Monday, January 2 at 3:04 PM"
+		In    string // completes "... starts " e.g. "in 1 hour"
+		URL   string
+	}
+	DoorPINRotation struct {
+		SetOn string // e.g. "January 2, 2006"
+		URL   string
+	}
+	GuestConfirmation struct {
+		Name  string
+		Email string
+		URL   string
+	}
+	TreasurerReport struct {
+		Month                 string // e.g. "January 2006"
+		ActiveSubscriptions   int
+		NewSubscriptions      int
+		CanceledSubscriptions int
+		PaypalStragglers      int
+	}
+)
+
+// Samples holds example data used to preview each email.
+var Samples = map[string]any{
+	"magicLink":          &MagicLink{Link: "https://example.com/login/verify?t=sample", TTLMinutes: 15},
+	"orientation":        &Orientation{URL: "https://example.com/orientation"},
+	"discordInvite":      &DiscordInvite{URL: "https://discord.gg/example"},
+	"waitlistInvitation": &WaitlistInvitation{URL: "https://example.com/profile", Expiration: "Monday, January 2 at 3:04 PM"},
+	"accessRevoked":      &AccessRevoked{Reason: "your membership payment is past due", URL: "https://example.com/profile"},
+	"paymentFailed":      &PaymentFailed{AccessUntil: "Monday, January 2", URL: "https://example.com/profile"},
+	"discountExpiring":   &DiscountExpiring{DiscountType: "educator", Expiration: "Monday, January 2", URL: "https://example.com/profile"},
+	"discountExpired":    &DiscountExpired{DiscountType: "educator", URL: "https://example.com/profile"},
+	"fobAssigned":        &FobAssigned{Hours: "from 8am-10pm daily", URL: "https://example.com/profile"},
+	"paypalMigration":    &PaypalMigration{Notice: 2, URL: "https://example.com/profile/stripe?price=paypal"},
+	"eventReminder":      &EventReminder{Name: "Intro to Welding", Start: "Monday, January 2 at 6:00 PM", In: "tomorrow", URL: "https://example.com/profile/notifications"},
+	"doorPINRotation":    &DoorPINRotation{SetOn: "January 2, 2006", URL: "https://example.com/profile"},
+	"guestConfirmation":  &GuestConfirmation{Name: "Ada Lovelace", Email: "ada@example.com", URL: "https://example.com/profile/guests/confirm?t=sample"},
+	"treasurerReport":    &TreasurerReport{Month: "January 2006", ActiveSubscriptions: 150, NewSubscriptions: 12, CanceledSubscriptions: 4, PaypalStragglers: 9},
+}
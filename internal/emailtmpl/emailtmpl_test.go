@@ -0,0 +1,44 @@
+package emailtmpl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderSamples(t *testing.T) {
+	for _, name := range Names() {
+		t.Run(name, func(t *testing.T) {
+			sample, ok := Samples[name]
+			require.True(t, ok, "every email needs sample data")
+
+			subject, body, err := Render(name, sample)
+			require.NoError(t, err)
+			assert.NotEmpty(t, subject)
+			assert.Contains(t, body, "TheLab.ms")
+		})
+	}
+}
+
+func TestRenderEscaping(t *testing.T) {
+	subject, body, err := Render("magicLink", &MagicLink{Link: `https://example.com/"><script>`, TTLMinutes: 5})
+	require.NoError(t, err)
+	assert.Equal(t, "Your TheLab login link", subject)
+	assert.NotContains(t, body, "<script>")
+	assert.Contains(t, body, "expires in 5 minutes")
+
+	_, _, err = Render("nope", nil)
+	assert.Error(t, err)
+}
+
+func TestRenderText(t *testing.T) {
+	text, err := RenderText("paymentFailed", &PaymentFailed{AccessUntil: "Monday", URL: "https://example.com/profile?a=b&c=d"})
+	require.NoError(t, err)
+	assert.Equal(t, "We weren't able to process your latest TheLab membership payment. Your building access will continue until Monday. Update your payment method here: https://example.com/profile?a=b&c=d", text)
+
+	// Falls back to the subject
+	text, err = RenderText("orientation", &Orientation{})
+	require.NoError(t, err)
+	assert.Equal(t, "Book your TheLab orientation", text)
+}
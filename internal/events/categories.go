@@ -0,0 +1,68 @@
+package events
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+)
+
+// categoryTag matches tags like "[woodshop]". Markdown links e.g. "[woodshop](https://...)" are captured along with
+// the paren so they can be skipped.
+var categoryTag = regexp.MustCompile(`\[([A-Za-z][A-Za-z0-9_-]*)\](\()?`)
+
+// extraSpace is left behind where tags are removed.
+var extraSpace = regexp.MustCompile(`[ \t]*\n[ \t]*|[ \t]{2,}`)
+
+// parseCategories removes category tags from the text, returning them lowercased in the order they appear.
+func parseCategories(text string, categories []string) (string, []string) {
+	stripped := categoryTag.ReplaceAllStringFunc(text, func(tag string) string {
+		if strings.HasSuffix(tag, "(") {
+			return tag // a link
+		}
+		category := strings.ToLower(tag[1 : len(tag)-1])
+		for _, c := range categories {
+			if c == category {
+				return ""
+			}
+		}
+		categories = append(categories, category)
+		return ""
+	})
+	stripped = extraSpace.ReplaceAllStringFunc(stripped, func(space string) string {
+		if strings.Contains(space, "\n") {
+			return "\n"
+		}
+		return " "
+	})
+	return strings.TrimSpace(stripped), categories
+}
+
+// categorize sets the event's categories and color from tags in its name and description.
+func categorize(event *datamodel.Event, colors map[string]string) {
+	event.Name, event.Categories = parseCategories(event.Name, []string{})
+	// Descriptions are left alone unless they have tags, since they're written by hand
+	if description, categories := parseCategories(event.Description, event.Categories); len(categories) > len(event.Categories) {
+		event.Description, event.Categories = description, categories
+	}
+	for _, c := range event.Categories {
+		if color, ok := colors[c]; ok {
+			event.Color = color
+			return
+		}
+	}
+}
+
+// FilterCategories returns the events in any of the given categories, or all of them when none are given.
+func FilterCategories(events []*datamodel.Event, categories []string) []*datamodel.Event {
+	if len(categories) == 0 {
+		return events
+	}
+	filtered := []*datamodel.Event{}
+	for _, event := range events {
+		if event.InCategory(categories...) {
+			filtered = append(filtered, event)
+		}
+	}
+	return filtered
+}
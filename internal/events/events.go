@@ -3,11 +3,14 @@ package events
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -18,24 +21,82 @@ import (
 	"github.com/teambition/rrule-go"
 )
 
+// expansionBucket is the granularity of cached recurrence expansions.
+// Events that started within the last bucket are still returned.
+const expansionBucket = time.Minute * 5
+
+type expansionKey struct{ from, until int64 }
+
 // EventCache polls Discord events, caches them in-memory, and materializes recurring events.
 type EventCache struct {
 	flowcontrol.Loop
-	mut   sync.Mutex
-	state []*event
+	mut        sync.Mutex
+	state      []*event
+	generation int // incremented whenever state changes
+
+	// Expansions are cached until the state changes, since the public site calls the API a lot
+	expandMut   sync.Mutex
+	expandedGen int
+	expanded    map[expansionKey][]*datamodel.Event
+
+	env *conf.Env
+
+	// Coordinator optionally shares events between replicas so they don't all poll Discord.
+	Coordinator flowcontrol.Coordinator
 
-	env     *conf.Env
-	baseURL string
+	// BaseURL is the Discord API to poll - it's only overridden for testing and local development.
+	BaseURL string
 }
 
 func NewCache(env *conf.Env) *EventCache {
-	ec := &EventCache{env: env, baseURL: "https://discord.com"}
+	ec := &EventCache{env: env, BaseURL: "https://discord.com"}
 	ec.Loop.Handler = flowcontrol.RetryHandler(env.DiscordInterval, ec.fillCache)
 	return ec
 }
 
+// GetEvents returns every event between now and until, with recurring events expanded. until is limited to
+// EventsMaxHorizon from now. The returned slice is shared between callers and must not be modified.
 func (e *EventCache) GetEvents(until time.Time) ([]*datamodel.Event, error) {
-	return e.getEvents(time.Now(), until)
+	return e.getCachedEvents(time.Now(), until)
+}
+
+func (e *EventCache) getCachedEvents(now, until time.Time) ([]*datamodel.Event, error) {
+	now = now.Truncate(expansionBucket)
+	if max := now.Add(e.env.EventsMaxHorizon); e.env.EventsMaxHorizon > 0 && until.After(max) {
+		until = max
+	}
+	if rounded := until.Truncate(expansionBucket); !rounded.Equal(until) {
+		until = rounded.Add(expansionBucket)
+	}
+
+	e.mut.Lock()
+	gen := e.generation
+	e.mut.Unlock()
+
+	e.expandMut.Lock()
+	defer e.expandMut.Unlock()
+	if e.expanded == nil || e.expandedGen != gen {
+		e.expanded = map[expansionKey][]*datamodel.Event{}
+		e.expandedGen = gen
+	}
+
+	key := expansionKey{from: now.Unix(), until: until.Unix()}
+	if events, ok := e.expanded[key]; ok {
+		return events, nil
+	}
+	events, err := e.getEvents(now, until)
+	if err != nil {
+		return nil, err
+	}
+
+	// Old buckets are never read again, so drop them rather than letting the map grow between refreshes
+	for k := range e.expanded {
+		if k.from != key.from {
+			delete(e.expanded, k)
+		}
+	}
+	e.expanded[key] = events
+	return events, nil
 }
 
 func (e *EventCache) getEvents(now, until time.Time) ([]*datamodel.Event, error) {
@@ -49,13 +110,20 @@ func (e *EventCache) getEvents(now, until time.Time) ([]*datamodel.Event, error)
 		// Support a magic location string to designate members only events
 		membersOnly := strings.Contains(strings.ToLower(event.Name), "(member event)")
 
+		// Tags are parsed once per event, and shared by every occurrence
+		tagged := &datamodel.Event{Name: event.Name, Description: event.Description}
+		categorize(tagged, e.env.EventCategoryColors)
+
 		if event.Recurrence == nil {
 			expanded = append(expanded, &datamodel.Event{
-				Name:        event.Name,
-				Description: event.Description,
+				ID:          event.ID,
+				Name:        tagged.Name,
+				Description: tagged.Description,
 				Start:       event.Start.UTC().Unix(),
 				End:         event.End.UTC().Unix(),
 				MembersOnly: membersOnly,
+				Categories:  tagged.Categories,
+				Color:       tagged.Color,
 			})
 			continue
 		}
@@ -104,11 +172,14 @@ func (e *EventCache) getEvents(now, until time.Time) ([]*datamodel.Event, error)
 		duration := event.End.Sub(event.Start)
 		for _, start := range times {
 			expanded = append(expanded, &datamodel.Event{
-				Name:        event.Name,
-				Description: event.Description,
+				ID:          event.ID,
+				Name:        tagged.Name,
+				Description: tagged.Description,
 				Start:       start.UTC().Unix(),
 				End:         start.Add(duration).Unix(),
 				MembersOnly: membersOnly,
+				Categories:  tagged.Categories,
+				Color:       tagged.Color,
 			})
 		}
 	}
@@ -123,7 +194,17 @@ func (e *EventCache) fillCache(ctx context.Context) bool {
 		return true
 	}
 
-	list := e.listEvents(ctx)
+	// Replicas refresh at random offsets, so sharing at half the interval bounds staleness to ~1.5 intervals
+	list, err := flowcontrol.RefreshShared(ctx, e.Coordinator, "discord-events", e.env.DiscordInterval/2, func(ctx context.Context) ([]*event, error) {
+		list := e.listEvents(ctx)
+		if list == nil {
+			return nil, errors.New("unable to list events")
+		}
+		return list, nil
+	})
+	if err != nil {
+		log.Printf("error while refreshing shared events cache: %s", err)
+	}
 
 	e.mut.Lock()
 	if list == nil && e.state == nil {
@@ -135,7 +216,10 @@ func (e *EventCache) fillCache(ctx context.Context) bool {
 		e.mut.Unlock()
 		return false
 	}
-	e.state = list
+	if !reflect.DeepEqual(e.state, list) {
+		e.state = list
+		e.generation++
+	}
 	log.Printf("updated cache of %d events", len(list))
 	e.mut.Unlock()
 	return true
@@ -145,7 +229,7 @@ func (e *EventCache) listEvents(ctx context.Context) []*event {
 	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
 	defer cancel()
 
-	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/api/v10/guilds/%s/scheduled-events", e.baseURL, e.env.DiscordGuildID), nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/api/v10/guilds/%s/scheduled-events", e.BaseURL, e.env.DiscordGuildID), nil)
 	if err != nil {
 		log.Printf("error creating request to list discord events: %s", err)
 		return nil
@@ -175,6 +259,95 @@ func (e *EventCache) listEvents(ctx context.Context) []*event {
 	return events
 }
 
+// Reminder is sent to everyone who RSVPed to an event shortly before it starts.
+type Reminder struct {
+	Kind   string // identifies the reminder for deduplication
+	Before time.Duration
+}
+
+// Reminders are sent this long before each event occurrence, longest first.
+var Reminders = []*Reminder{
+	{Kind: "24h", Before: time.Hour * 24},
+	{Kind: "1h", Before: time.Hour},
+}
+
+// DueReminder returns the reminder that should have been sent for the event by now, or nil if none are due.
+// Reminders that were missed e.g. during downtime are skipped once the next one is due.
+func DueReminder(event *datamodel.Event, now time.Time) *Reminder {
+	until := time.Unix(event.Start, 0).Sub(now)
+	if until <= 0 {
+		return nil
+	}
+	for i, r := range Reminders {
+		var next time.Duration
+		if i+1 < len(Reminders) {
+			next = Reminders[i+1].Before
+		}
+		if until <= r.Before && until > next {
+			return r
+		}
+	}
+	return nil
+}
+
+// rsvpPageSize is the most users Discord returns per page of an event's RSVPs.
+const rsvpPageSize = 100
+
+// ListRSVPs returns the Discord user IDs of everyone interested in the event.
+func (e *EventCache) ListRSVPs(ctx context.Context, eventID string) ([]int64, error) {
+	ids := []int64{}
+	after := "0"
+	for {
+		page, err := e.listRSVPPage(ctx, eventID, after)
+		if err != nil {
+			return nil, err
+		}
+		for _, rsvp := range page {
+			id, err := strconv.ParseInt(rsvp.User.ID, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid user ID %q: %w", rsvp.User.ID, err)
+			}
+			ids = append(ids, id)
+			after = rsvp.User.ID
+		}
+		if len(page) < rsvpPageSize {
+			return ids, nil
+		}
+	}
+}
+
+func (e *EventCache) listRSVPPage(ctx context.Context, eventID, after string) ([]*rsvp, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	url := fmt.Sprintf("%s/api/v10/guilds/%s/scheduled-events/%s/users?limit=%d&after=%s", e.BaseURL, e.env.DiscordGuildID, eventID, rsvpPageSize, after)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Authorization", "Bot "+e.env.DiscordBotToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("unexpected response status %d: %s", resp.StatusCode, body)
+	}
+
+	page := []*rsvp{}
+	return page, json.NewDecoder(resp.Body).Decode(&page)
+}
+
+type rsvp struct {
+	User struct {
+		ID string `json:"id"`
+	} `json:"user"`
+}
+
 // event is a partial representation of the Discord scheduled events API schema.
 type event struct {
 	ID          string      `json:"id"`
@@ -7,10 +7,12 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/TheLab-ms/profile/internal/conf"
+	"github.com/TheLab-ms/profile/internal/datamodel"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -32,7 +34,7 @@ func TestHappyPath(t *testing.T) {
 		file.Close()
 	}))
 	t.Cleanup(svr.Close)
-	c.baseURL = svr.URL
+	c.BaseURL = svr.URL
 
 	c.fillCache(context.Background())
 
@@ -50,3 +52,134 @@ func TestHappyPath(t *testing.T) {
 	require.NoError(t, err)
 	assert.JSONEq(t, string(expect), string(by))
 }
+
+func TestGetEventsCache(t *testing.T) {
+	env := &conf.Env{
+		DiscordGuildID:   "test-guild",
+		DiscordBotToken:  "test-bot-token",
+		EventsMaxHorizon: time.Hour * 24 * 30,
+	}
+	c := NewCache(env)
+
+	fixture := "fixtures/events.json"
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, err := os.Open(fixture)
+		require.NoError(t, err)
+		io.Copy(w, file)
+		file.Close()
+	}))
+	t.Cleanup(svr.Close)
+	c.BaseURL = svr.URL
+	c.fillCache(context.Background())
+
+	// Expansions are reused within a bucket
+	now := time.Unix(1709006369, 0) // when the fixture was captured
+	until := now.Add(time.Hour * 24 * 365)
+	events, err := c.getCachedEvents(now, until)
+	require.NoError(t, err)
+	require.NotEmpty(t, events)
+	again, err := c.getCachedEvents(now.Add(time.Second), until)
+	require.NoError(t, err)
+	assert.Equal(t, events, again)
+	assert.Len(t, c.expanded, 1)
+
+	// ...and limited to the horizon
+	horizon := now.Add(env.EventsMaxHorizon + expansionBucket).Unix()
+	for _, event := range events {
+		assert.LessOrEqual(t, event.Start, horizon)
+	}
+
+	// Refreshing without changes keeps the expansions
+	gen := c.generation
+	c.fillCache(context.Background())
+	assert.Equal(t, gen, c.generation)
+	again, err = c.getCachedEvents(now, until)
+	require.NoError(t, err)
+	assert.Equal(t, events, again)
+	assert.Len(t, c.expanded, 1)
+
+	// Later buckets replace earlier ones
+	later, err := c.getCachedEvents(now.Add(expansionBucket), until)
+	require.NoError(t, err)
+	assert.NotEmpty(t, later)
+	assert.Len(t, c.expanded, 1)
+
+	// Changes invalidate them
+	fixture = "fixtures/empty.json"
+	c.fillCache(context.Background())
+	again, err = c.getCachedEvents(now, until)
+	require.NoError(t, err)
+	assert.Empty(t, again)
+}
+
+func TestListRSVPs(t *testing.T) {
+	env := &conf.Env{DiscordGuildID: "test-guild", DiscordBotToken: "test-bot-token"}
+	c := NewCache(env)
+
+	// Two pages of RSVPs
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v10/guilds/test-guild/scheduled-events/test-event/users", r.URL.Path)
+		assert.Equal(t, "Bot test-bot-token", r.Header.Get("Authorization"))
+
+		count := rsvpPageSize
+		if r.URL.Query().Get("after") != "0" {
+			assert.Equal(t, strconv.Itoa(rsvpPageSize), r.URL.Query().Get("after"))
+			count = 1
+		}
+		start, _ := strconv.Atoi(r.URL.Query().Get("after"))
+		page := []map[string]any{}
+		for i := 1; i <= count; i++ {
+			page = append(page, map[string]any{"user": map[string]string{"id": strconv.Itoa(start + i)}})
+		}
+		json.NewEncoder(w).Encode(page)
+	}))
+	t.Cleanup(svr.Close)
+	c.BaseURL = svr.URL
+
+	ids, err := c.ListRSVPs(context.Background(), "test-event")
+	require.NoError(t, err)
+	require.Len(t, ids, rsvpPageSize+1)
+	assert.Equal(t, int64(1), ids[0])
+	assert.Equal(t, int64(rsvpPageSize+1), ids[rsvpPageSize])
+}
+
+func TestDueReminder(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	event := func(in time.Duration) *datamodel.Event { return &datamodel.Event{Start: now.Add(in).Unix()} }
+
+	assert.Nil(t, DueReminder(event(time.Hour*25), now))
+	assert.Equal(t, "24h", DueReminder(event(time.Hour*24), now).Kind)
+	assert.Equal(t, "24h", DueReminder(event(time.Hour*2), now).Kind)
+	assert.Equal(t, "1h", DueReminder(event(time.Hour), now).Kind)
+	assert.Equal(t, "1h", DueReminder(event(time.Minute), now).Kind)
+	assert.Nil(t, DueReminder(event(0), now))
+	assert.Nil(t, DueReminder(event(-time.Hour), now))
+}
+
+func TestCategorize(t *testing.T) {
+	colors := map[string]string{"class": "#5bc0de"}
+
+	event := &datamodel.Event{Name: "Intro to the Lathe [Woodshop] [class]", Description: "Bring safety glasses [class]\n\nSee [the wiki](https://wiki.example.com) [metalshop] first"}
+	categorize(event, colors)
+	assert.Equal(t, "Intro to the Lathe", event.Name)
+	assert.Equal(t, "Bring safety glasses\n\nSee [the wiki](https://wiki.example.com) first", event.Description)
+	assert.Equal(t, []string{"woodshop", "class", "metalshop"}, event.Categories)
+	assert.Equal(t, "#5bc0de", event.Color)
+
+	event = &datamodel.Event{Name: "Game Night", Description: "See [the rules](https://example.com)  for details"}
+	categorize(event, colors)
+	assert.Equal(t, "See [the rules](https://example.com)  for details", event.Description, "descriptions without tags are left alone")
+	assert.Equal(t, []string{}, event.Categories)
+	assert.Equal(t, "", event.Color)
+}
+
+func TestFilterCategories(t *testing.T) {
+	events := []*datamodel.Event{
+		{Name: "a", Categories: []string{"woodshop"}},
+		{Name: "b", Categories: []string{"class", "metalshop"}},
+		{Name: "c", Categories: []string{}},
+	}
+	assert.Len(t, FilterCategories(events, nil), 3)
+	assert.Equal(t, events[1:2], FilterCategories(events, []string{"metalshop", "electronics"}))
+	assert.Empty(t, FilterCategories(events, []string{"electronics"}))
+}
@@ -0,0 +1,124 @@
+// Package flags allows risky features to be enabled for a subset of members without redeploying.
+// Flags are stored in the reporting database and edited by leadership at /admin/flags.
+package flags
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TheLab-ms/profile/internal/flowcontrol"
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+// Flags holds an in-memory copy of the flag table, refreshed periodically.
+// A nil *Flags considers every flag to be disabled.
+type Flags struct {
+	flowcontrol.Loop
+	sink *reporting.ReportingSink
+
+	mut   sync.Mutex
+	state map[string]*reporting.FeatureFlag
+}
+
+func New(sink *reporting.ReportingSink) *Flags {
+	f := &Flags{sink: sink}
+	f.Loop.Handler = flowcontrol.RetryHandler(time.Minute, f.refresh)
+	return f
+}
+
+func (f *Flags) refresh(ctx context.Context) bool {
+	list, err := f.sink.ListFeatureFlags(ctx)
+	if err != nil {
+		log.Printf("error while listing feature flags: %s", err)
+		return false
+	}
+
+	state := map[string]*reporting.FeatureFlag{}
+	for _, flag := range list {
+		state[flag.Name] = flag
+	}
+
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	f.state = state
+	return true
+}
+
+// Enabled returns true if the flag is enabled for the given member.
+// Percentage rollouts are stable: a given member will always land in the same bucket for a particular flag.
+func (f *Flags) Enabled(name, email string) bool {
+	if f == nil {
+		return false
+	}
+	f.mut.Lock()
+	flag := f.state[name]
+	f.mut.Unlock()
+	if flag == nil {
+		return false
+	}
+	return isEnabled(flag, email)
+}
+
+func isEnabled(flag *reporting.FeatureFlag, email string) bool {
+	email = strings.ToLower(strings.TrimSpace(email))
+	for _, e := range flag.Emails {
+		if strings.EqualFold(e, email) {
+			return true
+		}
+	}
+	if flag.Percent <= 0 || email == "" {
+		return false
+	}
+	if flag.Percent >= 100 {
+		return true
+	}
+	return bucket(flag.Name, email) < flag.Percent
+}
+
+// bucket maps a member to a number in [0, 100) for the given flag.
+// The flag name is included so that the same members aren't always the first to get every new feature.
+func bucket(name, email string) int {
+	h := fnv.New32a()
+	h.Write([]byte(name + ":" + email))
+	return int(h.Sum32() % 100)
+}
+
+// List returns all known flags sorted by name.
+func (f *Flags) List() []*reporting.FeatureFlag {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	list := make([]*reporting.FeatureFlag, 0, len(f.state))
+	for _, flag := range f.state {
+		list = append(list, flag)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Set persists the flag and updates the local copy immediately.
+// Other processes will pick up the change the next time they refresh.
+func (f *Flags) Set(ctx context.Context, flag *reporting.FeatureFlag) error {
+	if flag.Percent < 0 || flag.Percent > 100 {
+		return fmt.Errorf("percent must be between 0 and 100")
+	}
+	if !f.sink.Enabled() {
+		return fmt.Errorf("feature flags require the reporting database")
+	}
+	if err := f.sink.PutFeatureFlag(ctx, flag); err != nil {
+		return fmt.Errorf("writing flag: %w", err)
+	}
+
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	if f.state == nil {
+		f.state = map[string]*reporting.FeatureFlag{}
+	}
+	f.state[flag.Name] = flag
+	return nil
+}
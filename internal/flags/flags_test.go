@@ -0,0 +1,40 @@
+package flags
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+func TestEnabled(t *testing.T) {
+	f := &Flags{state: map[string]*reporting.FeatureFlag{
+		"off":       {Name: "off"},
+		"on":        {Name: "on", Percent: 100},
+		"allowlist": {Name: "allowlist", Emails: []string{"Foo@example.com"}},
+		"half":      {Name: "half", Percent: 50},
+	}}
+
+	assert.False(t, f.Enabled("off", "foo@example.com"))
+	assert.False(t, f.Enabled("missing", "foo@example.com"))
+	assert.True(t, f.Enabled("on", "foo@example.com"))
+	assert.True(t, f.Enabled("allowlist", "foo@EXAMPLE.com"))
+	assert.False(t, f.Enabled("allowlist", "bar@example.com"))
+
+	// Roughly half of members should be enabled, consistently
+	var enabled int
+	for i := 0; i < 1000; i++ {
+		email := fmt.Sprintf("member-%d@example.com", i)
+		if f.Enabled("half", email) {
+			enabled++
+		}
+		assert.Equal(t, f.Enabled("half", email), f.Enabled("half", email))
+	}
+	assert.InDelta(t, 500, enabled, 75)
+
+	// Nil flags are always disabled
+	var nilFlags *Flags
+	assert.False(t, nilFlags.Enabled("on", "foo@example.com"))
+}
@@ -0,0 +1,114 @@
+package flowcontrol
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var ErrBreakerOpen = errors.New("circuit breaker is open")
+
+var breakerOpen = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "circuit_breaker_open",
+	Help: "1 when the named circuit breaker is failing fast",
+}, []string{"name"})
+
+var breakerTrips = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "circuit_breaker_trips_total",
+	Help: "Count of times the named circuit breaker has opened",
+}, []string{"name"})
+
+// Breaker fails fast after a dependency has failed Threshold times in a row.
+// Once Cooldown has passed a single probe is allowed through (half-open): success closes the breaker, failure re-opens it.
+type Breaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+
+	mut      sync.Mutex
+	failures int
+	openedAt time.Time // zero when closed
+	probing  bool
+	now      func() time.Time
+}
+
+// NewBreaker returns a breaker that is reported to Prometheus under the given name.
+func NewBreaker(name string, threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{name: name, threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// Allow returns false if the call should fail fast.
+// Callers that are allowed through must report the outcome using Success, Failure, or Abandon.
+func (b *Breaker) Allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	if b.openedAt.IsZero() {
+		return true
+	}
+	if b.probing || b.now().Sub(b.openedAt) < b.cooldown {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// Open returns true while the breaker is failing fast i.e. before the cooldown has passed.
+func (b *Breaker) Open() bool {
+	if b == nil {
+		return false
+	}
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return !b.openedAt.IsZero() && b.now().Sub(b.openedAt) < b.cooldown
+}
+
+// Success closes the breaker.
+func (b *Breaker) Success() {
+	if b == nil {
+		return
+	}
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	b.failures = 0
+	b.probing = false
+	b.openedAt = time.Time{}
+	breakerOpen.WithLabelValues(b.name).Set(0)
+}
+
+// Failure opens the breaker once the threshold is reached, or immediately if it was probing.
+func (b *Breaker) Failure() {
+	if b == nil {
+		return
+	}
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	b.failures++
+	if b.probing || b.failures >= b.threshold {
+		if b.openedAt.IsZero() || b.probing {
+			breakerTrips.WithLabelValues(b.name).Inc() // not for calls that were already in flight when it opened
+		}
+		b.probing = false
+		b.openedAt = b.now()
+		breakerOpen.WithLabelValues(b.name).Set(1)
+	}
+}
+
+// Abandon reports a call that ended without telling us anything about the dependency e.g. because the caller gave up.
+// It releases the probe if the breaker is half-open, so the next call can probe instead.
+func (b *Breaker) Abandon() {
+	if b == nil {
+		return
+	}
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	b.probing = false
+}
@@ -0,0 +1,68 @@
+package flowcontrol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Unix(1000, 0)
+	b := NewBreaker("test", 3, time.Minute)
+	b.now = func() time.Time { return now }
+
+	// Closed
+	for i := 0; i < 2; i++ {
+		assert.True(t, b.Allow())
+		b.Failure()
+	}
+	assert.False(t, b.Open())
+
+	// Successes reset the count
+	b.Success()
+	for i := 0; i < 2; i++ {
+		b.Failure()
+	}
+	assert.False(t, b.Open())
+
+	// Opens after the threshold
+	b.Failure()
+	assert.True(t, b.Open())
+	assert.False(t, b.Allow())
+
+	// Half-open allows a single probe
+	now = now.Add(time.Minute)
+	assert.False(t, b.Open())
+	assert.True(t, b.Allow())
+	assert.False(t, b.Allow())
+
+	// Failed probe re-opens
+	b.Failure()
+	assert.True(t, b.Open())
+	assert.False(t, b.Allow())
+
+	// Abandoned probe allows another
+	now = now.Add(time.Minute)
+	assert.True(t, b.Allow())
+	b.Abandon()
+	assert.True(t, b.Allow())
+	assert.False(t, b.Allow())
+	b.Failure()
+
+	// Successful probe closes
+	now = now.Add(time.Minute)
+	assert.True(t, b.Allow())
+	b.Success()
+	assert.True(t, b.Allow())
+	assert.True(t, b.Allow())
+}
+
+func TestNilBreaker(t *testing.T) {
+	var b *Breaker
+	assert.True(t, b.Allow())
+	assert.False(t, b.Open())
+	b.Failure()
+	b.Success()
+	b.Abandon()
+}
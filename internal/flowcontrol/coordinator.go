@@ -0,0 +1,35 @@
+package flowcontrol
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Coordinator shares the results of expensive refreshes between replicas, so only one of them calls the
+// upstream API per interval. See reporting.ReportingSink.RefreshShared.
+type Coordinator interface {
+	// RefreshShared returns the named state, calling fetch to replace it only when it's older than maxAge.
+	RefreshShared(ctx context.Context, name string, maxAge time.Duration, fetch func(context.Context) ([]byte, error)) ([]byte, error)
+}
+
+// RefreshShared wraps Coordinator.RefreshShared to store the state as JSON.
+// fetch is called directly when the coordinator is nil.
+func RefreshShared[T any](ctx context.Context, c Coordinator, name string, maxAge time.Duration, fetch func(context.Context) (T, error)) (T, error) {
+	if c == nil {
+		return fetch(ctx)
+	}
+
+	var val T
+	js, err := c.RefreshShared(ctx, name, maxAge, func(ctx context.Context) ([]byte, error) {
+		fetched, err := fetch(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(fetched)
+	})
+	if err != nil {
+		return val, err
+	}
+	return val, json.Unmarshal(js, &val)
+}
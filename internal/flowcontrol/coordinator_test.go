@@ -0,0 +1,61 @@
+package flowcontrol
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefreshShared(t *testing.T) {
+	ctx := context.Background()
+	type state struct{ Count int }
+	calls := 0
+	fetch := func(context.Context) (*state, error) {
+		calls++
+		return &state{Count: calls}, nil
+	}
+
+	// No coordinator
+	val, err := RefreshShared(ctx, nil, "test", time.Hour, fetch)
+	require.NoError(t, err)
+	assert.Equal(t, 1, val.Count)
+
+	// Fresh shared state is used as-is
+	c := &memoryCoordinator{}
+	val, err = RefreshShared(ctx, c, "test", time.Hour, fetch)
+	require.NoError(t, err)
+	assert.Equal(t, 2, val.Count)
+	val, err = RefreshShared(ctx, c, "test", time.Hour, fetch)
+	require.NoError(t, err)
+	assert.Equal(t, 2, val.Count)
+
+	// Stale shared state is replaced
+	val, err = RefreshShared(ctx, c, "test", 0, fetch)
+	require.NoError(t, err)
+	assert.Equal(t, 3, val.Count)
+
+	// Errors are passed through
+	_, err = RefreshShared(ctx, c, "test", 0, func(context.Context) (*state, error) { return nil, errors.New("oops") })
+	assert.EqualError(t, err, "oops")
+}
+
+type memoryCoordinator struct {
+	data    []byte
+	updated time.Time
+}
+
+func (m *memoryCoordinator) RefreshShared(ctx context.Context, name string, maxAge time.Duration, fetch func(context.Context) ([]byte, error)) ([]byte, error) {
+	if m.data != nil && time.Since(m.updated) < maxAge {
+		return m.data, nil
+	}
+	data, err := fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m.data, m.updated = data, time.Now()
+	return data, nil
+}
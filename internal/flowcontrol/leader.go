@@ -0,0 +1,23 @@
+package flowcontrol
+
+import (
+	"context"
+	"time"
+)
+
+// Elector decides which replica runs work that must not be duplicated e.g. bulk resyncs.
+// See reporting.Leadership.
+type Elector interface {
+	Leader() bool
+}
+
+// LeaderOnly skips the handler on replicas that aren't currently the leader, checking again after retry.
+// The handler always runs when the elector is nil.
+func LeaderOnly(e Elector, retry time.Duration, fn LoopTickHandler) LoopTickHandler {
+	return func(ctx context.Context) time.Duration {
+		if e != nil && !e.Leader() {
+			return retry
+		}
+		return fn(ctx)
+	}
+}
@@ -0,0 +1,34 @@
+package flowcontrol
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaderOnly(t *testing.T) {
+	calls := 0
+	fn := func(context.Context) time.Duration {
+		calls++
+		return time.Hour
+	}
+
+	e := &staticElector{}
+	handler := LeaderOnly(e, time.Second, fn)
+	assert.Equal(t, time.Second, handler(context.Background()))
+	assert.Equal(t, 0, calls)
+
+	e.leader = true
+	assert.Equal(t, time.Hour, handler(context.Background()))
+	assert.Equal(t, 1, calls)
+
+	// No elector
+	assert.Equal(t, time.Hour, LeaderOnly(nil, time.Second, fn)(context.Background()))
+	assert.Equal(t, 2, calls)
+}
+
+type staticElector struct{ leader bool }
+
+func (s *staticElector) Leader() bool { return s.leader }
@@ -51,6 +51,7 @@ func (l *Loop) Run(ctx context.Context) {
 		// Wait for the next tick
 		select {
 		case <-ticker.C:
+		case <-l.signal:
 		case <-ctx.Done():
 			return
 		}
@@ -3,6 +3,7 @@ package flowcontrol
 import (
 	"context"
 	"log"
+	"time"
 )
 
 // TODO: Support graceful shutdown
@@ -10,6 +11,9 @@ import (
 func RunWorker[T comparable](ctx context.Context, queue *Queue[T], fn func(T) error) {
 	for {
 		item := queue.Get()
+		for queue.Paused != nil && queue.Paused() {
+			time.Sleep(time.Second)
+		}
 		err := fn(item)
 		if err == nil {
 			queue.Done(item)
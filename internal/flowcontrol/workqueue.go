@@ -7,25 +7,45 @@ import (
 	"time"
 )
 
+// Priority determines which ready items are processed first.
+// Items of equal priority are processed in the order they became ready.
+type Priority int
+
+const (
+	PriorityLow    Priority = iota // bulk work e.g. periodic resyncs
+	PriorityNormal                 // the default for Add
+	PriorityHigh                   // real-time work e.g. webhooks for a member who just paid
+
+	numPriorities
+)
+
 type QueueItem[T comparable] struct {
 	key       T
 	attempts  int
 	nextRetry time.Time
+	priority  Priority
+	added     time.Time
 }
 
 type Queue[T comparable] struct {
+	// Paused optionally holds back workers e.g. while a dependency is down, so items aren't retried in vain.
+	Paused func() bool
+
 	mu    sync.Mutex
 	cond  *sync.Cond
 	items map[T]*QueueItem[T]
-	heap  *priorityQueue[T]
+	heaps []*priorityQueue[T] // indexed by priority
 }
 
 func NewQueue[T comparable]() *Queue[T] {
 	q := &Queue[T]{
 		items: make(map[T]*QueueItem[T]),
-		heap:  &priorityQueue[T]{},
+		heaps: make([]*priorityQueue[T], numPriorities),
+	}
+	for i := range q.heaps {
+		q.heaps[i] = &priorityQueue[T]{}
+		heap.Init(q.heaps[i])
 	}
-	heap.Init(q.heap)
 	q.cond = sync.NewCond(&q.mu)
 	return q
 }
@@ -38,12 +58,12 @@ func (q *Queue[T]) Run(ctx context.Context) {
 			return
 		case <-ticker.C:
 			q.mu.Lock()
-			if q.heap.Len() == 0 {
+			nextItem := q.peek()
+			if nextItem == nil {
 				q.mu.Unlock()
 				continue
 			}
 
-			nextItem := (*q.heap)[0]
 			delta := nextItem.nextRetry.Sub(time.Now())
 			if delta > 0 {
 				ticker.Reset(delta)
@@ -57,17 +77,45 @@ func (q *Queue[T]) Run(ctx context.Context) {
 	}
 }
 
-func (q *Queue[T]) Add(key T) {
+func (q *Queue[T]) Add(key T) { q.AddWithPriority(key, PriorityNormal) }
+
+// AddWithPriority enqueues the key, or raises the priority of an existing item if the given priority is higher.
+func (q *Queue[T]) AddWithPriority(key T, priority Priority) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
-	if _, exists := q.items[key]; !exists {
-		item := &QueueItem[T]{key: key, attempts: 0}
+	item, exists := q.items[key]
+	if !exists {
+		item = &QueueItem[T]{key: key, attempts: 0, priority: priority, added: time.Now()}
 		q.items[key] = item
-		heap.Push(q.heap, item)
+		heap.Push(q.heaps[priority], item)
+		q.cond.Signal()
+		return
+	}
+	if priority <= item.priority {
+		return
+	}
+
+	// Items currently being processed will land in the new heap if they're retried
+	queued := q.removeFromHeap(item)
+	item.priority = priority
+	if queued {
+		heap.Push(q.heaps[priority], item)
 		q.cond.Signal()
 	}
 }
 
+// Item returns the key's current priority and when it was first enqueued, or false if it isn't in the queue.
+// Keys being processed are still in the queue until Done is called, so workers can use this to tailor their work.
+func (q *Queue[T]) Item(key T) (Priority, time.Time, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	item, exists := q.items[key]
+	if !exists {
+		return PriorityNormal, time.Time{}, false
+	}
+	return item.priority, item.added, true
+}
+
 func (q *Queue[T]) Done(key T) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
@@ -81,16 +129,14 @@ func (q *Queue[T]) Get() T {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 	for {
-		if q.heap.Len() == 0 {
-			q.cond.Wait()
-		} else {
-			item := heap.Pop(q.heap).(*QueueItem[T])
-			if item.nextRetry.Before(time.Now()) {
-				return item.key
+		now := time.Now()
+		for i := len(q.heaps) - 1; i >= 0; i-- {
+			h := q.heaps[i]
+			if h.Len() > 0 && (*h)[0].nextRetry.Before(now) {
+				return heap.Pop(h).(*QueueItem[T]).key
 			}
-			heap.Push(q.heap, item)
-			q.cond.Wait()
 		}
+		q.cond.Wait()
 	}
 }
 
@@ -100,18 +146,31 @@ func (q *Queue[T]) Retry(key T) {
 	if item, exists := q.items[key]; exists {
 		item.attempts++
 		item.nextRetry = time.Now().Add(exponentialBackoff(item.attempts))
-		heap.Push(q.heap, item)
+		heap.Push(q.heaps[item.priority], item)
 		q.cond.Signal()
 	}
 }
 
-func (q *Queue[T]) removeFromHeap(item *QueueItem[T]) {
-	for i, heapItem := range *q.heap {
+// peek returns the item that will become ready soonest, regardless of priority.
+func (q *Queue[T]) peek() *QueueItem[T] {
+	var next *QueueItem[T]
+	for _, h := range q.heaps {
+		if h.Len() > 0 && (next == nil || (*h)[0].nextRetry.Before(next.nextRetry)) {
+			next = (*h)[0]
+		}
+	}
+	return next
+}
+
+func (q *Queue[T]) removeFromHeap(item *QueueItem[T]) bool {
+	h := q.heaps[item.priority]
+	for i, heapItem := range *h {
 		if heapItem == item {
-			heap.Remove(q.heap, i)
-			break
+			heap.Remove(h, i)
+			return true
 		}
 	}
+	return false
 }
 
 type priorityQueue[T comparable] []*QueueItem[T]
@@ -69,3 +69,53 @@ func TestDoneFunctionality(t *testing.T) {
 	q.Done("item1")
 	assert.Len(t, q.items, 0)
 }
+
+func TestPriority(t *testing.T) {
+	q := NewQueue[string]()
+	q.AddWithPriority("bulk1", PriorityLow)
+	q.AddWithPriority("bulk2", PriorityLow)
+	q.Add("normal")
+	q.AddWithPriority("webhook", PriorityHigh)
+	q.AddWithPriority("bulk2", PriorityHigh)  // escalated
+	q.AddWithPriority("webhook", PriorityLow) // never lowered
+
+	assert.Equal(t, "webhook", q.Get())
+	assert.Equal(t, "bulk2", q.Get())
+	assert.Equal(t, "normal", q.Get())
+	assert.Equal(t, "bulk1", q.Get())
+}
+
+func TestPriorityBackoff(t *testing.T) {
+	q := NewQueue[string]()
+	q.AddWithPriority("webhook", PriorityHigh)
+	assert.Equal(t, "webhook", q.Get())
+	q.Retry("webhook")
+
+	// High priority items waiting for their backoff don't block lower priority items
+	q.AddWithPriority("bulk", PriorityLow)
+	assert.Equal(t, "bulk", q.Get())
+}
+
+func TestItem(t *testing.T) {
+	q := NewQueue[string]()
+	_, _, ok := q.Item("bulk")
+	assert.False(t, ok)
+
+	q.AddWithPriority("bulk", PriorityLow)
+	priority, added, ok := q.Item("bulk")
+	assert.True(t, ok)
+	assert.Equal(t, PriorityLow, priority)
+	assert.False(t, added.IsZero())
+
+	// Escalation keeps the original enqueue time, and items are still visible while being processed
+	q.AddWithPriority("bulk", PriorityHigh)
+	assert.Equal(t, "bulk", q.Get())
+	priority, escalatedAdded, ok := q.Item("bulk")
+	assert.True(t, ok)
+	assert.Equal(t, PriorityHigh, priority)
+	assert.Equal(t, added, escalatedAdded)
+
+	q.Done("bulk")
+	_, _, ok = q.Item("bulk")
+	assert.False(t, ok)
+}
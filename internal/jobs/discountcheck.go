@@ -0,0 +1,96 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/emailtmpl"
+	"github.com/TheLab-ms/profile/internal/notify"
+	"github.com/TheLab-ms/profile/internal/payment"
+	"github.com/TheLab-ms/profile/internal/reporting"
+	"github.com/TheLab-ms/profile/internal/urls"
+)
+
+// discountCheck warns members whose discounts are about to expire, and reverts the ones that have.
+// It's meant to be scheduled daily.
+func discountCheck(ctx context.Context, ex *Execution) error {
+	loc, err := time.LoadLocation(ex.Env.SpaceTimezone)
+	if err != nil {
+		return fmt.Errorf("loading space timezone: %w", err)
+	}
+
+	users, err := ex.Keycloak.ListUsers(ctx)
+	if err != nil {
+		return fmt.Errorf("listing users: %w", err)
+	}
+
+	now := time.Now()
+	profileURL := urls.Profile(ex.Env)
+	for _, extended := range users {
+		user := extended.User
+		switch payment.GetDiscountAction(user, now, ex.Env.DiscountExpirationNotice) {
+		case payment.DiscountActionNotify:
+			ex.Change(ctx, nil, "expiration_notices", func() error {
+				user.DiscountNoticeTime = now
+				if err := ex.Keycloak.WriteUser(ctx, user); err != nil {
+					return fmt.Errorf("recording notice: %w", err) // don't risk notifying them again every day
+				}
+				notifyMember(ctx, ex, user, notify.ReasonDiscountExpiring, &emailtmpl.DiscountExpiring{
+					DiscountType: user.DiscountType,
+					Expiration:   user.DiscountExpiration.In(loc).Format("Monday, January 2"),
+					URL:          profileURL,
+				})
+				return nil
+			}, "notifying user %s that their %s discount expires soon", user.Email, user.DiscountType)
+
+		case payment.DiscountActionRevert:
+			discountType := user.DiscountType
+			ex.Change(ctx, nil, "discounts_reverted", func() error {
+				if err := revertDiscount(ctx, ex, user); err != nil {
+					return err
+				}
+				notifyMember(ctx, ex, user, notify.ReasonDiscountExpired, &emailtmpl.DiscountExpired{
+					DiscountType: discountType,
+					URL:          profileURL,
+				})
+				return nil
+			}, "reverting expired %s discount for user %s", discountType, user.Email)
+		}
+	}
+	return nil
+}
+
+// revertDiscount bills the member's subscription at the regular price and removes their discount.
+// Stripe is updated first so a failure leaves the discount in place to be retried by the next run.
+func revertDiscount(ctx context.Context, ex *Execution, user *datamodel.User) error {
+	if user.StripeSubscriptionID != "" {
+		if err := payment.RemoveSubscriptionDiscounts(ctx, user.StripeSubscriptionID); err != nil {
+			return fmt.Errorf("removing discounts from Stripe subscription: %w", err)
+		}
+	}
+
+	prev := user.DiscountType
+	user.DiscountType = ""
+	user.DiscountExpiration = time.Time{}
+	user.DiscountNoticeTime = time.Time{}
+	if err := ex.Keycloak.WriteUser(ctx, user); err != nil {
+		return fmt.Errorf("writing user: %w", err)
+	}
+
+	reporting.DefaultSink.Eventf(user.Email, "DiscountExpired", "%s discount expired and was removed", prev)
+	return nil
+}
+
+// notifyMember sends a notification, logging failures since the change it's about has already been made.
+func notifyMember(ctx context.Context, ex *Execution, user *datamodel.User, reason string, data any) {
+	channel, err := ex.Notifier.Notify(ctx, user, reason, data)
+	if err != nil {
+		ex.Errorf("error while sending %s notification to user %s: %s", reason, user.Email, err)
+		return
+	}
+	if channel != "" {
+		ex.Logger.Printf("sent %s notification to user %s over %s", reason, user.Email, channel)
+	}
+}
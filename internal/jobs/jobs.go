@@ -0,0 +1,246 @@
+// Package jobs holds the periodic checks over the member list. They run from their own binaries on a schedule (see
+// Main), or on demand by leadership through a Runner.
+//
+// Adding a job is a matter of writing a function that makes its changes through Execution.Change and adding it to
+// All, plus a three line main package if it should also be scheduled.
+package jobs
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/time/rate"
+
+	"github.com/TheLab-ms/profile/internal/app"
+	"github.com/TheLab-ms/profile/internal/chatbot"
+	"github.com/TheLab-ms/profile/internal/conf"
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/email"
+	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/notify"
+	"github.com/TheLab-ms/profile/internal/paypal"
+)
+
+var (
+	runCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "profile_job_runs_total",
+		Help: "Job runs by outcome (success or failure)",
+	}, []string{"job", "dry_run", "outcome"})
+	resultCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "profile_job_results_total",
+		Help: "Counts from job result summaries by kind e.g. accounts_deleted, not including dry runs",
+	}, []string{"job", "kind"})
+	errorCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "profile_job_errors_total",
+		Help: "Errors that jobs logged and moved past",
+	}, []string{"job"})
+	durationHist = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "profile_job_duration_seconds",
+		Help:    "Time taken by job runs, including dry runs",
+		Buckets: []float64{1, 10, 30, 60, 300, 900, 1800, 3600},
+	}, []string{"job"})
+)
+
+// Job is a check that can either make its changes or log the changes it would make.
+type Job interface {
+	Name() string
+	Run(ctx context.Context, deps *Deps, logger *log.Logger) (*Result, error)
+	DryRun(ctx context.Context, deps *Deps, logger *log.Logger) (*Result, error)
+}
+
+// All is every job, by name.
+var All = map[string]Job{}
+
+func init() {
+	for _, job := range []Job{
+		&checkJob{name: "discount-check", fn: discountCheck},
+		&checkJob{name: "paypal-check", fn: paypalCheck},
+		&checkJob{name: "visit-check", fn: visitCheck},
+	} {
+		All[job.Name()] = job
+	}
+}
+
+// Names returns the names of every job in All.
+func Names() []string {
+	names := make([]string, 0, len(All))
+	for name := range All {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Deps are the clients used by jobs. Jobs also write to reporting.DefaultSink.
+type Deps struct {
+	Env      *conf.Env
+	Keycloak *keycloak.Keycloak[*datamodel.User]
+	Paypal   *paypal.Client
+	Notifier *notify.Notifier
+}
+
+// checkJob implements Job for a function that makes every change through Execution.Change, so dry runs come for free.
+type checkJob struct {
+	name string
+	fn   func(ctx context.Context, ex *Execution) error
+}
+
+func (j *checkJob) Name() string { return j.name }
+
+func (j *checkJob) Run(ctx context.Context, deps *Deps, logger *log.Logger) (*Result, error) {
+	return j.execute(ctx, deps, logger, false)
+}
+
+func (j *checkJob) DryRun(ctx context.Context, deps *Deps, logger *log.Logger) (*Result, error) {
+	return j.execute(ctx, deps, logger, true)
+}
+
+func (j *checkJob) execute(ctx context.Context, deps *Deps, logger *log.Logger, dryRun bool) (*Result, error) {
+	ex := &Execution{
+		Deps:   deps,
+		Logger: logger,
+		DryRun: dryRun,
+		Result: &Result{Job: j.name, DryRun: dryRun, Started: time.Now(), Counts: map[string]int{}},
+	}
+	if dryRun {
+		logger.Printf("dry run - no changes will be made")
+	}
+
+	err := j.fn(ctx, ex)
+	ex.Result.Finished = time.Now()
+	durationHist.WithLabelValues(j.name).Observe(ex.Result.Finished.Sub(ex.Result.Started).Seconds())
+
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+	}
+	runCounter.WithLabelValues(j.name, fmt.Sprint(dryRun), outcome).Inc()
+	if !dryRun {
+		for kind, n := range ex.Result.Counts {
+			resultCounter.WithLabelValues(j.name, kind).Add(float64(n))
+		}
+	}
+
+	logger.Printf("%s", ex.Result)
+	return ex.Result, err
+}
+
+// Execution is passed to a running job.
+type Execution struct {
+	*Deps
+	Logger *log.Logger
+	DryRun bool
+	Result *Result
+}
+
+// Change makes a change at the limiter's pace and counts it in the result under kind, logging the description first.
+// Dry runs only log the description. Errors are logged and counted rather than returned, so one bad member doesn't stop
+// the job. Returns true if the change was made (or would have been).
+func (ex *Execution) Change(ctx context.Context, limiter *rate.Limiter, kind string, fn func() error, format string, args ...any) bool {
+	description := fmt.Sprintf(format, args...)
+	if ex.DryRun {
+		ex.Logger.Printf("dry run: %s", description)
+		ex.Result.Add(kind)
+		return true
+	}
+
+	if limiter != nil {
+		if err := limiter.Wait(ctx); err != nil {
+			ex.Errorf("error while waiting to make change: %s", err)
+			return false
+		}
+	}
+	ex.Logger.Printf("%s", description)
+	if err := fn(); err != nil {
+		ex.Errorf("error while %s: %s", description, err)
+		return false
+	}
+	ex.Result.Add(kind)
+	return true
+}
+
+// Errorf logs an error that the job is moving past, counting it in the result.
+func (ex *Execution) Errorf(format string, args ...any) {
+	ex.Logger.Printf(format, args...)
+	ex.Result.Errors++
+	errorCounter.WithLabelValues(ex.Result.Job).Inc()
+}
+
+// Result summarizes a run.
+type Result struct {
+	Job      string
+	DryRun   bool
+	Started  time.Time
+	Finished time.Time
+	Counts   map[string]int // by kind e.g. "accounts_deleted"
+	Errors   int
+}
+
+func (r *Result) Add(kind string) { r.Counts[kind]++ }
+
+func (r *Result) String() string {
+	kinds := make([]string, 0, len(r.Counts))
+	for kind := range r.Counts {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	parts := []string{}
+	for _, kind := range kinds {
+		parts = append(parts, fmt.Sprintf("%s=%d", kind, r.Counts[kind]))
+	}
+	parts = append(parts, fmt.Sprintf("errors=%d", r.Errors))
+
+	str := fmt.Sprintf("%s finished in %s: %s", r.Job, r.Finished.Sub(r.Started).Round(time.Second), strings.Join(parts, " "))
+	if r.DryRun {
+		str += " (dry run)"
+	}
+	return str
+}
+
+// Main runs the job from its own binary, loading the config from the environment.
+// Pass -dry-run to log the changes it would make instead.
+func Main(job Job) {
+	dryRun := flag.Bool("dry-run", false, "log the changes the job would make instead of making them")
+	flag.Parse()
+
+	if err := runMain(job, *dryRun); err != nil {
+		log.Printf("terminal error: %s", err)
+		os.Exit(1)
+	}
+}
+
+func runMain(job Job, dryRun bool) error {
+	a, err := app.Load()
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	ctx := context.Background()
+
+	bot, err := chatbot.NewBot(a.Env)
+	if err != nil {
+		return err
+	}
+
+	deps := &Deps{
+		Env:      a.Env,
+		Keycloak: a.Keycloak,
+		Paypal:   paypal.NewClient(a.Env),
+		Notifier: notify.New(bot, email.NewSender(a.Env)),
+	}
+	run := job.Run
+	if dryRun {
+		run = job.DryRun
+	}
+	_, err = run(ctx, deps, log.Default())
+	return err
+}
@@ -0,0 +1,109 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChange(t *testing.T) {
+	for _, dryRun := range []bool{false, true} {
+		buf := &bytes.Buffer{}
+		var changes int
+		job := &checkJob{name: "test", fn: func(ctx context.Context, ex *Execution) error {
+			ex.Change(ctx, nil, "changed", func() error { changes++; return nil }, "changing %s", "foo")
+			ex.Change(ctx, nil, "changed", func() error { return errors.New("oops") }, "changing %s", "bar")
+			return nil
+		}}
+
+		run := job.Run
+		if dryRun {
+			run = job.DryRun
+		}
+		result, err := run(context.Background(), &Deps{}, log.New(buf, "", 0))
+		require.NoError(t, err)
+		assert.Equal(t, dryRun, result.DryRun)
+
+		if dryRun {
+			assert.Equal(t, 0, changes)
+			assert.Equal(t, map[string]int{"changed": 2}, result.Counts)
+			assert.Equal(t, 0, result.Errors)
+			assert.Contains(t, buf.String(), "dry run: changing foo\n")
+			assert.Contains(t, buf.String(), "test finished in 0s: changed=2 errors=0 (dry run)\n")
+		} else {
+			assert.Equal(t, 1, changes)
+			assert.Equal(t, map[string]int{"changed": 1}, result.Counts)
+			assert.Equal(t, 1, result.Errors)
+			assert.Contains(t, buf.String(), "error while changing bar: oops\n")
+			assert.Contains(t, buf.String(), "test finished in 0s: changed=1 errors=1\n")
+		}
+	}
+}
+
+func TestRunner(t *testing.T) {
+	release := make(chan struct{})
+	All["test"] = &checkJob{name: "test", fn: func(ctx context.Context, ex *Execution) error {
+		ex.Logger.Printf("starting")
+		<-release
+		ex.Change(ctx, nil, "changed", func() error { return nil }, "changing")
+		return errors.New("test error")
+	}}
+	t.Cleanup(func() { delete(All, "test") })
+
+	r := NewRunner(&Deps{})
+	ctx := context.Background()
+
+	_, err := r.Start(ctx, "nope", false)
+	assert.True(t, errors.Is(err, ErrUnknownJob))
+	assert.Nil(t, r.Latest("test"))
+
+	run, err := r.Start(ctx, "test", true)
+	require.NoError(t, err)
+	assert.True(t, run.DryRun)
+
+	// Only one run at a time
+	current, err := r.Start(ctx, "test", false)
+	assert.True(t, errors.Is(err, ErrAlreadyRunning))
+	assert.Same(t, run, current)
+	assert.Same(t, run, r.Latest("test"))
+
+	// Followers get the output written before and after they started following
+	buf := &bytes.Buffer{}
+	var flushes int
+	done := make(chan error)
+	go func() { done <- run.Follow(ctx, buf, func() { flushes++ }) }()
+	close(release)
+	require.NoError(t, <-done)
+
+	assert.Contains(t, buf.String(), "test: ")
+	assert.Contains(t, buf.String(), "starting")
+	assert.Contains(t, buf.String(), "dry run: changing")
+	assert.Contains(t, buf.String(), "terminal error: test error")
+	assert.Greater(t, flushes, 0)
+	assert.True(t, run.Done())
+	assert.EqualError(t, run.Err(), "test error")
+	assert.Equal(t, 1, run.Result().Counts["changed"])
+
+	// Finished jobs can run again
+	next, err := r.Start(ctx, "test", false)
+	require.NoError(t, err)
+	assert.NotSame(t, run, next)
+}
+
+func TestFollowCanceled(t *testing.T) {
+	run := newRun("test", false, time.Now())
+	run.Write([]byte("hello\n"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	buf := &bytes.Buffer{}
+	err := run.Follow(ctx, buf, func() {})
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, "hello\n", buf.String())
+}
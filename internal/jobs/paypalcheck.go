@@ -0,0 +1,165 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/TheLab-ms/profile/internal/chatbot"
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/emailtmpl"
+	"github.com/TheLab-ms/profile/internal/notify"
+	"github.com/TheLab-ms/profile/internal/payment"
+	"github.com/TheLab-ms/profile/internal/reporting"
+	"github.com/TheLab-ms/profile/internal/urls"
+)
+
+// paypalCheck reconciles members still paying through PayPal with their subscriptions, deactivating canceled members
+// and nudging the rest to move to Stripe.
+func paypalCheck(ctx context.Context, ex *Execution) error {
+	kc := ex.Keycloak
+	users, err := kc.ListUsers(ctx)
+	if err != nil {
+		return fmt.Errorf("listing users: %w", err)
+	}
+
+	previous, err := reporting.DefaultSink.LastPaypalReconciliation(ctx)
+	if err != nil {
+		ex.Logger.Printf("error while getting the previous reconciliation report: %s", err)
+	}
+
+	report := &reporting.PaypalReconciliation{Start: time.Now()}
+	limiter := rate.NewLimiter(rate.Every(time.Millisecond*500), 1)
+	for _, extended := range users {
+		user := extended.User
+		if !extended.ActiveMember || user.PaypalMetadata.TransactionID == "" || user.StripeCustomerID != "" {
+			continue
+		}
+		limiter.Wait(ctx)
+		report.Checked++
+
+		current, err := ex.Paypal.GetSubscription(ctx, user.PaypalMetadata.TransactionID)
+		if err != nil {
+			ex.Errorf("error while getting paypal subscription for member %s: %s", user.Email, err)
+			report.APIErrors++
+			report.Remaining++
+			continue
+		}
+		if current == nil {
+			ex.Logger.Printf("no subscription found for id %s", user.PaypalMetadata.TransactionID)
+			report.NotFound++
+			report.Remaining++
+			continue
+		}
+		active := current.Status != "CANCELLED"
+		price, _ := strconv.ParseFloat(current.Billing.LastPayment.Amount.Value, 64)
+
+		ex.Logger.Printf("paypal subscription %s is in state active=%t for member %s who last visited on %s", user.PaypalMetadata.TransactionID, active, user.Email, user.LastSwipeTime.Format("2006-01-02"))
+		if !active {
+			deactivated := ex.Change(ctx, nil, "deactivated", func() error {
+				if err := kc.Deactivate(ctx, user); err != nil {
+					return err
+				}
+				reporting.DefaultSink.Eventf(user.Email, "PayPalSubscriptionCanceled", "We observed the member's PayPal status in an inactive state")
+				return nil
+			}, "deactivating member %s", user.Email)
+			if !deactivated {
+				report.APIErrors++
+				report.Remaining++
+				continue
+			}
+			report.Deactivated++
+
+			// Lockers are only rented to members
+			if user.LockerNumber != "" {
+				ex.Change(ctx, nil, "lockers_released", func() error {
+					reporting.DefaultSink.Eventf(user.Email, "LockerReleased", "locker %s was released because the membership ended", user.LockerNumber)
+					user.LockerNumber = ""
+					return kc.WriteUser(ctx, user)
+				}, "releasing locker %s", user.LockerNumber)
+			}
+			continue
+		}
+		report.Remaining++
+
+		if payment.PaypalMigrationNoticeDue(user, time.Now(), ex.Env.PaypalMigrationCutoff, ex.Env.PaypalMigrationNoticeInterval) {
+			notified := ex.Change(ctx, nil, "migration_notices", func() error {
+				return sendMigrationNotice(ctx, ex, user)
+			}, "sending paypal migration notice %d to member %s", user.PaypalMigrationNotices+1, user.Email)
+			if notified {
+				report.Notified++
+			}
+		}
+
+		if price == user.PaypalMetadata.Price && current.Billing.LastPayment.Time == user.PaypalMetadata.TimeRFC3339 {
+			continue
+		}
+		if price != user.PaypalMetadata.Price {
+			ex.Logger.Printf("paypal price for member %s changed from %.2f to %.2f", user.Email, user.PaypalMetadata.Price, price)
+			report.PriceMismatches++
+		}
+
+		updated := ex.Change(ctx, nil, "updated", func() error {
+			user.PaypalMetadata.TimeRFC3339 = current.Billing.LastPayment.Time
+			user.PaypalMetadata.Price = price
+			return kc.WriteUser(ctx, user)
+		}, "updating paypal metadata for member %s", user.Email)
+		if !updated {
+			report.APIErrors++
+			continue
+		}
+		report.Updated++
+	}
+	report.End = time.Now()
+
+	ex.Result.Counts["checked"] = report.Checked
+	ex.Result.Counts["remaining"] = report.Remaining
+	ex.Logger.Printf("reconciliation report: %s", report)
+	if ex.DryRun {
+		return nil
+	}
+	if err := reporting.DefaultSink.RecordPaypalReconciliation(ctx, report); err != nil {
+		ex.Errorf("error while writing reconciliation report: %s", err)
+	}
+	if ex.Env.PaypalReportWebhook != "" {
+		if err := postPaypalReport(ctx, ex.Env.PaypalReportWebhook, report, previous); err != nil {
+			ex.Errorf("error while posting reconciliation report to discord: %s", err)
+		}
+	}
+	return nil
+}
+
+// sendMigrationNotice sends the member their next notice asking them to move to Stripe.
+// The notice is recorded first so failures don't cause members to be notified every run.
+func sendMigrationNotice(ctx context.Context, ex *Execution, user *datamodel.User) error {
+	user.PaypalMigrationNotices++
+	user.PaypalMigrationNoticeTime = time.Now()
+	if err := ex.Keycloak.WriteUser(ctx, user); err != nil {
+		return fmt.Errorf("recording notice: %w", err)
+	}
+
+	channel, err := ex.Notifier.Notify(ctx, user, notify.ReasonPaypalMigration, &emailtmpl.PaypalMigration{
+		Notice: user.PaypalMigrationNotices,
+		Final:  user.PaypalMigrationNotices >= payment.PaypalMigrationFinalNotice,
+		URL:    urls.Checkout(ex.Env, "paypal"),
+	})
+	if err != nil {
+		return fmt.Errorf("sending notice: %w", err)
+	}
+	ex.Logger.Printf("sent paypal migration notice %d to member %s over %s", user.PaypalMigrationNotices, user.Email, channel)
+	reporting.DefaultSink.Eventf(user.Email, "PaypalMigrationNotice", "sent paypal migration notice %d of %d", user.PaypalMigrationNotices, payment.PaypalMigrationFinalNotice)
+	return nil
+}
+
+// postPaypalReport sends the report to a Discord webhook.
+func postPaypalReport(ctx context.Context, url string, report, previous *reporting.PaypalReconciliation) error {
+	msg := fmt.Sprintf("**Paypal reconciliation**\n%d members still on Paypal (checked %d, deactivated %d, updated %d, price changes %d, not found %d, errors %d, migration notices %d)",
+		report.Remaining, report.Checked, report.Deactivated, report.Updated, report.PriceMismatches, report.NotFound, report.APIErrors, report.Notified)
+	if previous != nil {
+		msg += fmt.Sprintf("\n%+d since %s", report.Remaining-previous.Remaining, previous.Start.Format("January 2"))
+	}
+	return chatbot.PostWebhook(ctx, url, msg)
+}
@@ -0,0 +1,158 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownJob     = errors.New("unknown job")
+	ErrAlreadyRunning = errors.New("job is already running")
+)
+
+// Runner runs jobs in the background on demand, keeping the latest run of each.
+// Only one run of each job happens at a time (per process).
+type Runner struct {
+	deps *Deps
+
+	mut  sync.Mutex
+	runs map[string]*Run
+}
+
+func NewRunner(deps *Deps) *Runner {
+	return &Runner{deps: deps, runs: map[string]*Run{}}
+}
+
+// Start runs the job (or a dry run of it) in the background until it finishes or ctx is canceled.
+// ErrAlreadyRunning is returned along with the current run if the job hasn't finished yet.
+func (r *Runner) Start(ctx context.Context, name string, dryRun bool) (*Run, error) {
+	job, ok := All[name]
+	if !ok {
+		return nil, ErrUnknownJob
+	}
+	fn := job.Run
+	if dryRun {
+		fn = job.DryRun
+	}
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	if current := r.runs[name]; current != nil && !current.Done() {
+		return current, ErrAlreadyRunning
+	}
+
+	run := newRun(name, dryRun, time.Now())
+	r.runs[name] = run
+	logger := log.New(io.MultiWriter(run, log.Writer()), name+": ", log.LstdFlags)
+	go func() {
+		result, err := fn(ctx, r.deps, logger)
+		if err != nil {
+			logger.Printf("terminal error: %s", err)
+		}
+		run.finish(result, err)
+	}()
+	return run, nil
+}
+
+// Latest returns the current or most recent run of the job, or nil if it hasn't run.
+func (r *Runner) Latest(name string) *Run {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	return r.runs[name]
+}
+
+// Run is a single run of a job. Its output is kept in memory so it can be followed while the job runs.
+type Run struct {
+	Name    string
+	DryRun  bool
+	Started time.Time
+
+	mut     sync.Mutex
+	output  []byte
+	updated chan struct{} // closed and replaced when the output changes or the run finishes
+	done    bool
+	result  *Result
+	err     error
+}
+
+func newRun(name string, dryRun bool, now time.Time) *Run {
+	return &Run{Name: name, DryRun: dryRun, Started: now, updated: make(chan struct{})}
+}
+
+func (r *Run) Write(p []byte) (int, error) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.output = append(r.output, p...)
+	r.notify()
+	return len(p), nil
+}
+
+func (r *Run) finish(result *Result, err error) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.done = true
+	r.result = result
+	r.err = err
+	r.notify()
+}
+
+// notify wakes up followers. Callers must hold the lock.
+func (r *Run) notify() {
+	close(r.updated)
+	r.updated = make(chan struct{})
+}
+
+// Done returns true once the job has returned.
+func (r *Run) Done() bool {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	return r.done
+}
+
+// Result returns the job's summary once it's done.
+func (r *Run) Result() *Result {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	return r.result
+}
+
+// Err returns the error returned by the job, if any.
+func (r *Run) Err() error {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	return r.err
+}
+
+// Follow copies the run's output to w as it's written until the run finishes or ctx is done.
+// flush is called after each write e.g. to push the output to an HTTP client.
+func (r *Run) Follow(ctx context.Context, w io.Writer, flush func()) error {
+	var offset int
+	for {
+		r.mut.Lock()
+		chunk := r.output[offset:]
+		done := r.done
+		updated := r.updated
+		r.mut.Unlock()
+
+		if len(chunk) > 0 {
+			if _, err := w.Write(chunk); err != nil {
+				return err
+			}
+			offset += len(chunk)
+			flush()
+		}
+		if done {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-updated:
+		}
+	}
+}
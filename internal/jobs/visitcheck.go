@@ -0,0 +1,330 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+// visitCheck syncs members' last visit times from the swipe log, revokes building access approval from members who
+// haven't visited in a while (after a review period), and cleans up accounts that were never confirmed.
+// Users are streamed from Keycloak so the job doesn't hold the whole realm in memory.
+func visitCheck(ctx context.Context, ex *Execution) error {
+	kc := ex.Keycloak
+	if reporting.DefaultSink.Enabled() {
+		limiter := rate.NewLimiter(rate.Every(time.Millisecond*100), 1)
+		err := kc.ListUsersStream(ctx, func(extended *keycloak.ExtendedUser[*datamodel.User]) error {
+			return updateTimestamp(ctx, ex, limiter, extended)
+		})
+		if err != nil {
+			return fmt.Errorf("updating timestamps: %w", err)
+		}
+	}
+
+	// Page through the users again so the checks below see the updated timestamps
+	absences, err := newAbsenceReview(ctx, ex)
+	if err != nil {
+		return fmt.Errorf("deactivating absent members: %w", err)
+	}
+	cleanup := newUnconfirmedCleanup()
+	err = kc.ListUsersStream(ctx, func(extended *keycloak.ExtendedUser[*datamodel.User]) error {
+		absences.check(ctx, ex, extended)
+		cleanup.check(ctx, ex, extended)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("listing users: %w", err)
+	}
+
+	// The stream pages by offset, so nothing can be deleted until it's done - otherwise users would shift between
+	// pages and be skipped. Every user has been seen by this point.
+	absences.finish(ctx, ex)
+	if err := cleanup.finish(ctx, ex); err != nil {
+		return fmt.Errorf("deleting unconfirmed accounts: %w", err)
+	}
+	return nil
+}
+
+func updateTimestamp(ctx context.Context, ex *Execution, limiter *rate.Limiter, extended *keycloak.ExtendedUser[*datamodel.User]) error {
+	if !extended.ActiveMember {
+		return nil
+	}
+	user := extended.User
+
+	name := fmt.Sprintf("%s %s", user.First, user.Last)
+	latest, ok, err := reporting.DefaultSink.GetLatestSwipe(ctx, name, user.LastSwipeTime)
+	if err != nil {
+		return fmt.Errorf("getting latest swipe for user: %w", err)
+	}
+	if !ok {
+		return nil
+	}
+
+	if math.Abs(user.LastSwipeTime.Sub(latest).Seconds()) < 5 {
+		return nil // skip timestamps that are close
+	}
+
+	prev := user.LastSwipeTime
+	ex.Change(ctx, limiter, "visits_updated", func() error {
+		user.LastSwipeTime = latest
+		return ex.Keycloak.WriteUser(ctx, user)
+	}, "updating last visit time for user %q (%s->%s)", user.Email, prev, latest)
+	return nil
+}
+
+var saneStartTime = time.Now().Add(-(time.Hour * 24 * 365 * 10))
+
+var absentThres = time.Hour * 24 * 182
+
+// absenceReview revokes building access approval from members who haven't visited in a while.
+// Revocations are held at /admin/pending for the review window first, so leadership can exempt members e.g. because
+// they're traveling. Members who visit during the review drop off of the list.
+type absenceReview struct {
+	limiter *rate.Limiter
+	window  time.Duration
+	now     time.Time
+	pending map[string]*reporting.PendingAction // by user ID
+	absent  map[string]bool
+}
+
+func newAbsenceReview(ctx context.Context, ex *Execution) (*absenceReview, error) {
+	a := &absenceReview{
+		limiter: rate.NewLimiter(rate.Every(time.Second), 1),
+		window:  ex.Env.DeactivationReviewWindow,
+		now:     time.Now(),
+		pending: map[string]*reporting.PendingAction{},
+		absent:  map[string]bool{},
+	}
+	if !reporting.DefaultSink.Enabled() {
+		a.window = 0 // there's nowhere to hold them
+	}
+
+	if a.window > 0 {
+		actions, err := reporting.DefaultSink.ListPendingActions(ctx, reporting.PendingRevokeAccess)
+		if err != nil {
+			return nil, fmt.Errorf("listing pending actions: %w", err)
+		}
+		for _, action := range actions {
+			a.pending[action.UserID] = action
+		}
+	}
+	return a, nil
+}
+
+func (a *absenceReview) check(ctx context.Context, ex *Execution, extended *keycloak.ExtendedUser[*datamodel.User]) {
+	user := extended.User
+	if !extended.ActiveMember || user.BuildingAccessApprover == "" || user.NonBillable {
+		return
+	}
+
+	// If they last badged in more than 10yr ago, something is wrong
+	if user.LastSwipeTime.Before(saneStartTime) {
+		return
+	}
+
+	// Ignore active members
+	sinceLastVisit := a.now.Sub(user.LastSwipeTime)
+	if sinceLastVisit < absentThres {
+		return
+	}
+	a.absent[user.UUID] = true
+	days := sinceLastVisit.Hours() / 24
+
+	if a.window == 0 {
+		revokeApproval(ctx, ex, a.limiter, user, days)
+		return
+	}
+
+	switch reviewDecision(a.pending[user.UUID], user.LastSwipeTime, a.now) {
+	case reviewSchedule:
+		action := &reporting.PendingAction{
+			Kind:      reporting.PendingRevokeAccess,
+			UserID:    user.UUID,
+			Email:     user.Email,
+			Reason:    fmt.Sprintf("last visit was %.0f days ago", days),
+			Created:   a.now,
+			AppliesAt: a.now.Add(a.window),
+		}
+		ex.Change(ctx, nil, "revocations_pending", func() error {
+			return reporting.DefaultSink.SchedulePendingAction(ctx, action)
+		}, "holding building access revocation for user %s for review until %s because their last visit was %.0f days ago", user.Email, action.AppliesAt.Format(time.RFC3339), days)
+
+	case reviewApply:
+		if revokeApproval(ctx, ex, a.limiter, user, days) && !ex.DryRun {
+			if err := reporting.DefaultSink.MarkPendingActionApplied(ctx, reporting.PendingRevokeAccess, user.UUID, time.Now()); err != nil {
+				ex.Errorf("error while marking pending revocation for user %s as applied: %s", user.Email, err)
+			}
+		}
+
+	case reviewWait:
+		ex.Result.Add("revocations_in_review")
+
+	case reviewExempt:
+		ex.Result.Add("revocations_exempted")
+	}
+}
+
+// finish cancels the pending revocations of members who weren't absent, once every user has been checked.
+func (a *absenceReview) finish(ctx context.Context, ex *Execution) {
+	// Members who came back (or stopped being members some other way) during the review are off the hook
+	for userID, action := range a.pending {
+		if !action.Open() || a.absent[userID] {
+			continue
+		}
+		ex.Change(ctx, nil, "revocations_canceled", func() error {
+			return reporting.DefaultSink.CancelPendingAction(ctx, reporting.PendingRevokeAccess, userID)
+		}, "canceling pending building access revocation for user %s because they're no longer absent", action.Email)
+	}
+}
+
+func revokeApproval(ctx context.Context, ex *Execution, limiter *rate.Limiter, user *datamodel.User, days float64) bool {
+	return ex.Change(ctx, limiter, "approvals_revoked", func() error {
+		user.BuildingAccessApprover = ""
+		if err := ex.Keycloak.WriteUser(ctx, user); err != nil {
+			return err
+		}
+		reporting.DefaultSink.Eventf(user.Email, "RevokedBuildingAccessApproval", "removing building access approval because member hasn't visited in %2.f days", days)
+		return nil
+	}, "revoking build access approval for user %s %s (%s) because their last visit was %2.f days ago", user.First, user.Last, user.Email, days)
+}
+
+const (
+	reviewSchedule = "schedule"
+	reviewWait     = "wait"
+	reviewApply    = "apply"
+	reviewExempt   = "exempt"
+)
+
+// reviewDecision returns what to do about an absent member given their pending action, if any.
+// Exemptions last until the member's next visit, so they'll be reviewed again if they stop showing up again.
+func reviewDecision(pending *reporting.PendingAction, lastVisit, now time.Time) string {
+	switch {
+	case pending == nil:
+		return reviewSchedule
+	case !pending.ExemptedAt.IsZero() && pending.ExemptedAt.After(lastVisit):
+		return reviewExempt
+	case !pending.Open():
+		return reviewSchedule // left over from a previous absence
+	case now.Before(pending.AppliesAt):
+		return reviewWait
+	default:
+		return reviewApply
+	}
+}
+
+// unconfirmedCleanup deletes accounts that were never confirmed, quarantining the ones that need a human to look at them.
+// Deletions are queued while checking and applied by finish.
+type unconfirmedCleanup struct {
+	limiter     *rate.Limiter
+	deletions   []*datamodel.User
+	quarantined []*reporting.QuarantinedAccount
+	incomplete  bool // some users couldn't be checked
+}
+
+func newUnconfirmedCleanup() *unconfirmedCleanup {
+	return &unconfirmedCleanup{
+		limiter:     rate.NewLimiter(rate.Every(time.Second), 1),
+		quarantined: []*reporting.QuarantinedAccount{},
+	}
+}
+
+func (c *unconfirmedCleanup) check(ctx context.Context, ex *Execution, extended *keycloak.ExtendedUser[*datamodel.User]) {
+	if userIsConfirmed(extended) {
+		return
+	}
+	user := extended.User
+
+	reason, err := deletionProtection(ctx, user)
+	if err != nil {
+		ex.Errorf("error while checking deletion protection for user %s: %s", user.UUID, err)
+		c.incomplete = true
+		return // never delete accounts we aren't sure about
+	}
+	if reason != "" {
+		ex.Logger.Printf("not deleting unconfirmed user %s because their account is %s", user.Email, reason)
+		c.quarantined = append(c.quarantined, &reporting.QuarantinedAccount{
+			UserID:     user.UUID,
+			Email:      user.Email,
+			Reason:     reason,
+			SignupTime: user.SignupTime,
+			CheckedAt:  time.Now(),
+		})
+		return
+	}
+
+	c.deletions = append(c.deletions, user)
+}
+
+// finish deletes the queued accounts and replaces the quarantine list once every user has been checked.
+// The list is left alone if any user couldn't be checked, since they may belong on it.
+func (c *unconfirmedCleanup) finish(ctx context.Context, ex *Execution) error {
+	for _, user := range c.deletions {
+		c.delete(ctx, ex, user)
+	}
+
+	ex.Result.Counts["quarantined"] = len(c.quarantined)
+	if ex.DryRun {
+		return nil
+	}
+	if c.incomplete {
+		ex.Logger.Printf("not replacing the quarantine list because some users couldn't be checked")
+		return nil
+	}
+	if err := reporting.DefaultSink.ReplaceQuarantine(ctx, c.quarantined); err != nil {
+		return fmt.Errorf("recording quarantined accounts: %w", err)
+	}
+	return nil
+}
+
+func (c *unconfirmedCleanup) delete(ctx context.Context, ex *Execution, user *datamodel.User) {
+	ex.Change(ctx, c.limiter, "accounts_deleted", func() error {
+		if err := ex.Keycloak.DeleteUser(ctx, user.UUID); err != nil {
+			return err
+		}
+		reporting.DefaultSink.Eventf(user.Email, "AccountCleanedUp", "account was deleted because its email address was not confirmed in the configured period")
+
+		if user.FobID != 0 {
+			err := reporting.DefaultSink.RecordFobAssignment(ctx, &reporting.FobAssignment{Time: time.Now(), FobID: user.FobID, Email: user.Email, Actor: "visit-check-job", Assigned: false})
+			if err != nil {
+				ex.Errorf("error while recording fob unassignment for user %s: %s", user.UUID, err)
+			}
+		}
+		return nil
+	}, "deleting user %s because they signed up %s ago and have not confirmed their email (status=%s, fobID=%d)", user.Email, time.Since(user.SignupTime).Round(time.Hour), user.PaymentStatus(), user.FobID)
+}
+
+// deletionProtection returns the reason an unconfirmed account must be kept for leadership to review,
+// or an empty string if it's safe to delete. Anything involving money is never deleted automatically, and neither are
+// accounts whose signup email bounced since the address can be corrected.
+func deletionProtection(ctx context.Context, user *datamodel.User) (string, error) {
+	switch {
+	case user.StripeCustomerID != "" || user.StripeSubscriptionID != "":
+		return "linked to Stripe", nil
+	case user.PaypalMetadata.TransactionID != "":
+		return "linked to PayPal", nil
+	case !user.EmailBounceTime.IsZero():
+		return "signup email bounced (the address might have a typo)", nil
+	}
+
+	paid, err := reporting.DefaultSink.HasPaymentEvents(ctx, user.Email)
+	if err != nil {
+		return "", fmt.Errorf("checking for payment events: %w", err)
+	}
+	if paid {
+		return "associated with payment events", nil
+	}
+	return "", nil
+}
+
+func userIsConfirmed(user *keycloak.ExtendedUser[*datamodel.User]) bool {
+	active := user.ActiveMember || user.User.EmailVerified || user.User.NonBillable
+	tooNew := time.Since(user.User.SignupTime) < 48*time.Hour
+	return active || tooNew
+}
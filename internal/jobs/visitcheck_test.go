@@ -0,0 +1,80 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TheLab-ms/profile/internal/conf"
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/keycloak/keycloaktest"
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+func TestReviewDecision(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	lastVisit := now.Add(-time.Hour * 24 * 200)
+
+	assert.Equal(t, reviewSchedule, reviewDecision(nil, lastVisit, now))
+
+	open := &reporting.PendingAction{AppliesAt: now.Add(time.Hour)}
+	assert.Equal(t, reviewWait, reviewDecision(open, lastVisit, now))
+	assert.Equal(t, reviewApply, reviewDecision(open, lastVisit, now.Add(time.Hour)))
+
+	exempted := &reporting.PendingAction{AppliesAt: now, ExemptedAt: now.Add(-time.Hour)}
+	assert.Equal(t, reviewExempt, reviewDecision(exempted, lastVisit, now))
+
+	// Exemptions expire when the member visits, and applied actions are from a previous absence
+	assert.Equal(t, reviewSchedule, reviewDecision(exempted, now.Add(-time.Minute), now))
+	applied := &reporting.PendingAction{AppliesAt: now.Add(-time.Hour), AppliedAt: now.Add(-time.Hour)}
+	assert.Equal(t, reviewSchedule, reviewDecision(applied, lastVisit, now))
+}
+
+func TestUnconfirmedCleanupDeletesEveryPage(t *testing.T) {
+	kcFake := keycloaktest.NewFake("members")
+	signup := strconv.FormatInt(time.Now().Add(-time.Hour*24*7).Unix(), 10)
+	for i := 0; i < 400; i++ {
+		kcFake.AddUser(&gocloak.User{
+			ID:         gocloak.StringP(fmt.Sprintf("user-%03d", i)),
+			Email:      gocloak.StringP(fmt.Sprintf("user-%03d@example.com", i)),
+			Attributes: &map[string][]string{"signupEpochTimeUTC": {signup}},
+		}, false)
+	}
+	kcServer := httptest.NewServer(kcFake)
+	t.Cleanup(kcServer.Close)
+
+	env := &conf.Env{
+		KeycloakURL:            kcServer.URL,
+		KeycloakRealm:          "master",
+		KeycloakMembersGroupID: "members",
+		KeycloakClientID:       "test",
+		KeycloakClientSecret:   "test",
+	}
+	kc := keycloak.New[*datamodel.User](env)
+	kc.Sink = reporting.DefaultSink
+	ex := &Execution{Deps: &Deps{Env: env, Keycloak: kc}, Logger: log.New(io.Discard, "", 0), Result: &Result{Counts: map[string]int{}}}
+
+	ctx := context.Background()
+	cleanup := newUnconfirmedCleanup()
+	cleanup.limiter = nil
+	require.NoError(t, kc.ListUsersStream(ctx, func(extended *keycloak.ExtendedUser[*datamodel.User]) error {
+		cleanup.check(ctx, ex, extended)
+		return nil
+	}))
+	require.NoError(t, cleanup.finish(ctx, ex))
+	assert.Equal(t, 400, ex.Result.Counts["accounts_deleted"])
+
+	remaining, err := kc.ListUsers(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, remaining)
+}
@@ -5,11 +5,25 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sync/atomic"
 	"time"
 
 	"github.com/Nerzal/gocloak/v13"
 )
 
+// Failing to get a token backs off exponentially between these bounds, since retrying e.g. bad credentials on every
+// request won't help and just adds load to a Keycloak that might be restarting.
+const (
+	minTokenBackoff = time.Second
+	maxTokenBackoff = time.Minute
+)
+
+// tokenBackoff tracks failed attempts to get a token.
+type tokenBackoff struct {
+	failures int          // guarded by the token lock
+	retryAt  atomic.Int64 // unix nanos, read without the token lock by Unavailable
+}
+
 // For whatever reason the Keycloak client doesn't support token rotation
 func (k *Keycloak[T]) GetToken(ctx context.Context) (*gocloak.JWT, error) {
 	k.tokenLock.Lock()
@@ -18,6 +32,9 @@ func (k *Keycloak[T]) GetToken(ctx context.Context) (*gocloak.JWT, error) {
 	if k.token != nil && time.Since(k.tokenFetchTime) < (time.Duration(k.token.ExpiresIn)*time.Second)/2 {
 		return k.token, nil
 	}
+	if k.tokenBackingOff() {
+		return nil, fmt.Errorf("%w: waiting to retry getting a token", ErrUnavailable)
+	}
 
 	clientID, err := k.getClientID()
 	if err != nil {
@@ -30,8 +47,14 @@ func (k *Keycloak[T]) GetToken(ctx context.Context) (*gocloak.JWT, error) {
 
 	token, err := k.client.LoginClient(ctx, string(clientID), string(clientSecret), k.env.KeycloakRealm)
 	if err != nil {
-		return nil, err
+		k.backoff.failures++
+		wait := min(minTokenBackoff<<min(k.backoff.failures-1, 16), maxTokenBackoff)
+		k.backoff.retryAt.Store(time.Now().Add(wait).UnixNano())
+		log.Printf("error while getting auth token from keycloak - will retry in %s: %s", wait, err)
+		return nil, fmt.Errorf("%w: %w", ErrUnavailable, err)
 	}
+	k.backoff.failures = 0
+	k.backoff.retryAt.Store(0)
 	k.token = token
 	k.tokenFetchTime = time.Now()
 
@@ -39,6 +62,10 @@ func (k *Keycloak[T]) GetToken(ctx context.Context) (*gocloak.JWT, error) {
 	return k.token, nil
 }
 
+func (k *Keycloak[T]) tokenBackingOff() bool {
+	return time.Now().UnixNano() < k.backoff.retryAt.Load()
+}
+
 func (k *Keycloak[T]) getClientID() (string, error) {
 	if len(k.env.KeycloakClientID) > 0 {
 		return k.env.KeycloakClientID, nil
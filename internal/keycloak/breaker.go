@@ -0,0 +1,32 @@
+package keycloak
+
+import (
+	"net/http"
+
+	"github.com/TheLab-ms/profile/internal/flowcontrol"
+)
+
+// breakerTransport trips the circuit breaker when Keycloak can't be reached or returns server errors.
+// Client errors (404, 409, etc.) are expected during normal operation and count as successes.
+// Requests that fail because their own context was canceled or timed out don't count either way.
+type breakerTransport struct {
+	breaker *flowcontrol.Breaker
+	next    http.RoundTripper
+}
+
+func (b *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !b.breaker.Allow() {
+		return nil, ErrUnavailable
+	}
+
+	resp, err := b.next.RoundTrip(req)
+	switch {
+	case err != nil && req.Context().Err() != nil:
+		b.breaker.Abandon()
+	case err != nil || resp.StatusCode >= 500:
+		b.breaker.Failure()
+	default:
+		b.breaker.Success()
+	}
+	return resp, err
+}
@@ -10,7 +10,90 @@ import (
 	"github.com/Nerzal/gocloak/v13"
 )
 
+// maxAttrLen is the smallest per-value attribute length limit we've seen across Keycloak realms.
+const maxAttrLen = 255
+
+// setChunkedAttr stores values that exceed maxAttrLen across indexed keys i.e. key.0, key.1, etc.
+// Shorter values are stored under the key itself. Chunks left behind by previous longer values are removed.
+func setChunkedAttr(attrs map[string][]string, key, val string) {
+	for i := 0; ; i++ {
+		ck := chunkKey(key, i)
+		if _, ok := attrs[ck]; !ok {
+			break
+		}
+		delete(attrs, ck)
+	}
+
+	if len(val) <= maxAttrLen {
+		attrs[key] = []string{val}
+		return
+	}
+
+	delete(attrs, key)
+	for i := 0; len(val) > 0; i++ {
+		n := maxAttrLen
+		if n > len(val) {
+			n = len(val)
+		}
+		attrs[chunkKey(key, i)] = []string{val[:n]}
+		val = val[n:]
+	}
+}
+
+// getChunkedAttr is the inverse of setChunkedAttr.
+func getChunkedAttr(attrs map[string][]string, key string) string {
+	if val, ok := attrs[key]; ok {
+		return firstElOrZeroVal(val)
+	}
+
+	var b strings.Builder
+	for i := 0; ; i++ {
+		chunk, ok := attrs[chunkKey(key, i)]
+		if !ok {
+			break
+		}
+		b.WriteString(firstElOrZeroVal(chunk))
+	}
+	return b.String()
+}
+
+func chunkKey(key string, i int) string { return key + "." + strconv.Itoa(i) }
+
+//go:generate go run ./mappergen -o zz_generated_mappers.go
+
+// generatedMappers are reflection-free conversions for hot user types, keyed by the pointer type.
+// The reflective mappers run for every user on every resync of the realm, so they add up.
+// Generated mappers must behave exactly like the reflective ones (see TestGeneratedMappers).
+var generatedMappers = map[reflect.Type]*generatedMapper{}
+
+type generatedMapper struct {
+	to, from func(kcuser *gocloak.User, user any)
+}
+
+func registerMapper[T any](to, from func(*gocloak.User, *T)) {
+	generatedMappers[reflect.TypeOf((*T)(nil))] = &generatedMapper{
+		to:   func(kcuser *gocloak.User, user any) { to(kcuser, user.(*T)) },
+		from: func(kcuser *gocloak.User, user any) { from(kcuser, user.(*T)) },
+	}
+}
+
 func mapToUserType(kcuser *gocloak.User, user any) {
+	if m, ok := generatedMappers[reflect.TypeOf(user)]; ok {
+		m.to(kcuser, user)
+		return
+	}
+	reflectToUserType(kcuser, user)
+}
+
+func mapFromUserType(kcuser *gocloak.User, user any) {
+	if m, ok := generatedMappers[reflect.TypeOf(user)]; ok {
+		m.from(kcuser, user)
+		return
+	}
+	reflectFromUserType(kcuser, user)
+}
+
+func reflectToUserType(kcuser *gocloak.User, user any) {
 	rt := reflect.TypeOf(user).Elem()
 	rv := reflect.ValueOf(user).Elem()
 
@@ -38,7 +121,7 @@ func mapToUserType(kcuser *gocloak.User, user any) {
 		}
 
 		key := strings.TrimPrefix(tag, "attr.")
-		val := safeGetAttr(kcuser, key)
+		val := getChunkedAttr(safeGetAttrs(kcuser), key)
 		if val == "" {
 			continue
 		}
@@ -59,12 +142,15 @@ func mapToUserType(kcuser *gocloak.User, user any) {
 		default:
 			v := reflect.New(ft.Type).Interface()
 			json.Unmarshal([]byte(val), &v)
+			if v == nil {
+				continue // nil maps etc. are stored as "null"
+			}
 			fv.Set(reflect.ValueOf(v).Elem())
 		}
 	}
 }
 
-func mapFromUserType(kcuser *gocloak.User, user any) {
+func reflectFromUserType(kcuser *gocloak.User, user any) {
 	rt := reflect.TypeOf(user).Elem()
 	rv := reflect.ValueOf(user).Elem()
 
@@ -112,7 +198,7 @@ func mapFromUserType(kcuser *gocloak.User, user any) {
 			}
 		default:
 			raw, _ := json.Marshal(&val)
-			attrs[key] = []string{string(raw)}
+			setChunkedAttr(attrs, key, string(raw))
 		}
 	}
 }
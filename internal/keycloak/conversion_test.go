@@ -1,11 +1,21 @@
 package keycloak
 
 import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/Nerzal/gocloak/v13"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TheLab-ms/profile/internal/conf"
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/keycloak/keycloaktest"
 )
 
 func TestConversion(t *testing.T) {
@@ -68,3 +78,219 @@ func TestConversion(t *testing.T) {
 	mapFromUserType(copy, user)
 	assert.Equal(t, kc, copy)
 }
+
+func TestChunkedAttrs(t *testing.T) {
+	attrs := map[string][]string{}
+
+	// Short values aren't chunked
+	setChunkedAttr(attrs, "foo", "bar")
+	assert.Equal(t, map[string][]string{"foo": {"bar"}}, attrs)
+	assert.Equal(t, "bar", getChunkedAttr(attrs, "foo"))
+
+	// Long values are split across indexed keys
+	long := strings.Repeat("a", maxAttrLen) + strings.Repeat("b", maxAttrLen) + "c"
+	setChunkedAttr(attrs, "foo", long)
+	assert.Equal(t, map[string][]string{
+		"foo.0": {strings.Repeat("a", maxAttrLen)},
+		"foo.1": {strings.Repeat("b", maxAttrLen)},
+		"foo.2": {"c"},
+	}, attrs)
+	assert.Equal(t, long, getChunkedAttr(attrs, "foo"))
+
+	// Shrinking the value cleans up the old chunks
+	setChunkedAttr(attrs, "foo", long[:maxAttrLen+1])
+	assert.Len(t, attrs, 2)
+	assert.Equal(t, long[:maxAttrLen+1], getChunkedAttr(attrs, "foo"))
+
+	setChunkedAttr(attrs, "foo", "bar")
+	assert.Equal(t, map[string][]string{"foo": {"bar"}}, attrs)
+
+	// Missing
+	assert.Equal(t, "", getChunkedAttr(attrs, "baz"))
+}
+
+func TestChunkedConversion(t *testing.T) {
+	type testStruct struct {
+		Foo string `json:"foo"`
+	}
+	type testUser struct {
+		Json testStruct `keycloak:"attr.js"`
+	}
+
+	user := &testUser{Json: testStruct{Foo: strings.Repeat("x", 600)}}
+	kc := &gocloak.User{}
+	mapFromUserType(kc, user)
+	assert.Len(t, *kc.Attributes, 3)
+
+	copy := &testUser{}
+	mapToUserType(kc, copy)
+	assert.Equal(t, user, copy)
+}
+
+func TestNullConversion(t *testing.T) {
+	type testUser struct {
+		Map map[string]bool `keycloak:"attr.map"`
+	}
+
+	kc := &gocloak.User{}
+	mapFromUserType(kc, &testUser{})
+	assert.Equal(t, []string{"null"}, (*kc.Attributes)["map"])
+
+	copy := &testUser{}
+	mapToUserType(kc, copy)
+	assert.Nil(t, copy.Map)
+}
+
+func TestGeneratedMappers(t *testing.T) {
+	user := newBenchmarkUser(1)
+
+	// Every field is set so new fields can't be missed by a stale generated mapper
+	rv := reflect.ValueOf(user).Elem()
+	for i := 0; i < rv.NumField(); i++ {
+		require.False(t, rv.Field(i).IsZero(), "test user is missing %s - set it and run go generate", rv.Type().Field(i).Name)
+	}
+
+	// From
+	generated := &gocloak.User{}
+	mapFromUserType(generated, user)
+	reflective := &gocloak.User{}
+	reflectFromUserType(reflective, user)
+	assert.Equal(t, reflective, generated)
+
+	// To
+	for _, kc := range []*gocloak.User{generated, {Attributes: &map[string][]string{"welcomeSteps": {"null"}}}} {
+		kc.CreatedTimestamp = gocloak.Int64P(1234)
+		generatedUser := &datamodel.User{}
+		mapToUserType(kc, generatedUser)
+		reflectiveUser := &datamodel.User{}
+		reflectToUserType(kc, reflectiveUser)
+		assert.Equal(t, reflectiveUser, generatedUser)
+	}
+}
+
+func BenchmarkMapToUserType(b *testing.B) {
+	kc := &gocloak.User{}
+	mapFromUserType(kc, newBenchmarkUser(1))
+	kc.CreatedTimestamp = gocloak.Int64P(1234)
+
+	b.Run("reflect", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			reflectToUserType(kc, &datamodel.User{})
+		}
+	})
+	b.Run("generated", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			mapToUserType(kc, &datamodel.User{})
+		}
+	})
+}
+
+func BenchmarkMapFromUserType(b *testing.B) {
+	user := newBenchmarkUser(1)
+
+	b.Run("reflect", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			reflectFromUserType(&gocloak.User{}, user)
+		}
+	})
+	b.Run("generated", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			mapFromUserType(&gocloak.User{}, user)
+		}
+	})
+}
+
+// BenchmarkListUsers covers a full resync of a realm roughly the size of ours, including the API round trips.
+func BenchmarkListUsers(b *testing.B) {
+	const n = 1000
+
+	kcFake := keycloaktest.NewFake("members")
+	for i := 0; i < n; i++ {
+		kc := &gocloak.User{}
+		mapFromUserType(kc, newBenchmarkUser(i))
+		kcFake.AddUser(kc, i%2 == 0)
+	}
+	kcServer := httptest.NewServer(kcFake)
+	b.Cleanup(kcServer.Close)
+
+	kc := New[*datamodel.User](&conf.Env{
+		KeycloakURL:            kcServer.URL,
+		KeycloakRealm:          "master",
+		KeycloakMembersGroupID: "members",
+		KeycloakClientID:       "test",
+		KeycloakClientSecret:   "test",
+	})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		users, err := kc.ListUsers(context.Background())
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(users) != n {
+			b.Fatalf("expected %d users, got %d", n, len(users))
+		}
+	}
+}
+
+// newBenchmarkUser returns a user with every field set, as a long-time member's would be.
+func newBenchmarkUser(i int) *datamodel.User {
+	now := time.Unix(1700000000, 0)
+	return &datamodel.User{
+		PaypalMetadata:            datamodel.PaypalMetadata{Price: 40, TimeRFC3339: now.UTC(), TransactionID: "txn-1"},
+		UUID:                      fmt.Sprintf("user-%d", i),
+		CreationTime:              1234,
+		Username:                  fmt.Sprintf("member-%d@example.com", i),
+		First:                     "Ada",
+		Last:                      "Lovelace",
+		Email:                     fmt.Sprintf("member-%d@example.com", i),
+		EmailVerified:             true,
+		FobID:                     1000 + i,
+		WaiverState:               "Signed",
+		NonBillable:               true,
+		DiscountType:              "educational",
+		MembershipTier:            datamodel.TierPremium,
+		BuildingAccessApprover:    "admin@example.com",
+		SignupTime:                now,
+		LastSwipeTime:             now,
+		DiscordUserID:             123456789,
+		PreviousDiscordUserID:     987654321,
+		DiscordIntroOptOut:        true,
+		DiscordIntroThreadID:      "987654321",
+		SignupEmailSentTime:       now,
+		SignupEmailResends:        1,
+		EmailVerifiedTime:         now,
+		EmailBounceTime:           now,
+		EmailBounceReason:         "550 mailbox not found",
+		DiscountExpiration:        now,
+		DiscountNoticeTime:        now,
+		StripePromotionCode:       "promo_123",
+		WelcomeSteps:              map[string]time.Time{"orientation": now.UTC(), "discord": now.UTC()},
+		PaypalMigrationNotices:    1,
+		PaypalMigrationNoticeTime: now,
+		StripeCustomerID:          "cus_test",
+		StripeSubscriptionID:      "sub_test",
+		StripeCancelationTime:     now,
+		StripeSubscriptionStatus:  "active",
+		StripeGracePeriodEnd:      now,
+		LockerNumber:              "A1",
+		StripeLockerItemID:        "si_locker",
+		DoorPINHash:               "0123456789abcdef",
+		DoorPINSetTime:            now,
+		DoorPINReminderTime:       now,
+		Banned:                    true,
+		BanReason:                 "repeated safety violations",
+		BanTime:                   now,
+		Certifications:            []string{"laser-cutter", "cnc-router", "woodshop", "3d-printer"},
+		EmergencyContactName:      "Charles Babbage",
+		EmergencyContactPhone:     "555-0100",
+		NotificationOptOuts:       map[string]bool{"events": true},
+		NewsletterOptIn:           true,
+		NewsletterStatus:          "pending",
+	}
+}
@@ -0,0 +1,131 @@
+package keycloak
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// AttributeChange is one attribute of a user that was changed by WriteUser.
+type AttributeChange struct {
+	Time      time.Time
+	UserID    string
+	Email     string
+	Actor     string
+	Attribute string
+	Old, New  string
+}
+
+// AttributeHistory stores the changes made by WriteUser. See internal/reporting.
+type AttributeHistory interface {
+	Enabled() bool
+	RecordAttributeChanges(ctx context.Context, changes []*AttributeChange) error
+}
+
+// redactedAttributes are recorded as changed without their values.
+var redactedAttributes = map[string]bool{"doorPINHash": true}
+
+// ignoredAttributes change too often to be worth recording.
+var ignoredAttributes = map[string]bool{"lastSwipeTime": true}
+
+type actorKey struct{}
+
+// WithActor attributes changes made using the context to the given person or process.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor set by WithActor, defaulting to the name of the running binary.
+func ActorFromContext(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return filepath.Base(os.Args[0])
+}
+
+// recordChanges stores the difference between the user's previous and new state.
+// It's best effort, since failing the write after Keycloak has accepted it would be misleading.
+func (k *Keycloak[T]) recordChanges(ctx context.Context, prev, next *gocloak.User) {
+	changes := diffUsers(prev, next)
+	if len(changes) == 0 {
+		return
+	}
+	now := time.Now()
+	actor := ActorFromContext(ctx)
+	for _, change := range changes {
+		change.Time = now
+		change.UserID = gocloak.PString(next.ID)
+		change.Email = gocloak.PString(next.Email)
+		change.Actor = actor
+	}
+	if err := k.History.RecordAttributeChanges(ctx, changes); err != nil {
+		log.Printf("error while recording attribute changes for user %s: %s", gocloak.PString(next.ID), err)
+	}
+}
+
+// diffUsers returns the attributes (and names/email) that differ between the two users, sorted by name.
+func diffUsers(prev, next *gocloak.User) []*AttributeChange {
+	before := flattenUser(prev)
+	after := flattenUser(next)
+
+	keys := map[string]bool{}
+	for key := range before {
+		keys[key] = true
+	}
+	for key := range after {
+		keys[key] = true
+	}
+
+	changes := []*AttributeChange{}
+	for key := range keys {
+		if before[key] == after[key] || ignoredAttributes[key] {
+			continue
+		}
+		change := &AttributeChange{Attribute: key, Old: before[key], New: after[key]}
+		if redactedAttributes[key] {
+			change.Old, change.New = redact(change.Old), redact(change.New)
+		}
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Attribute < changes[j].Attribute })
+	return changes
+}
+
+// flattenUser returns the user's attributes as single strings, joining chunked attributes back together.
+func flattenUser(user *gocloak.User) map[string]string {
+	flat := map[string]string{
+		"firstName": gocloak.PString(user.FirstName),
+		"lastName":  gocloak.PString(user.LastName),
+		"email":     gocloak.PString(user.Email),
+	}
+	attrs := safeGetAttrs(user)
+	for key, vals := range attrs {
+		if i := strings.LastIndex(key, "."); i > 0 {
+			if _, err := strconv.Atoi(key[i+1:]); err == nil {
+				flat[key[:i]] = getChunkedAttr(attrs, key[:i])
+				continue
+			}
+		}
+		flat[key] = strings.Join(vals, ",")
+	}
+	for key, val := range flat {
+		if val == "" {
+			delete(flat, key) // unset and empty are the same thing
+		}
+	}
+	return flat
+}
+
+func redact(val string) string {
+	if val == "" {
+		return ""
+	}
+	return "<redacted>"
+}
@@ -0,0 +1,95 @@
+package keycloak
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TheLab-ms/profile/internal/conf"
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/keycloak/keycloaktest"
+)
+
+func TestDiffUsers(t *testing.T) {
+	long := string(make([]byte, maxAttrLen+10))
+	prev := &gocloak.User{
+		FirstName: gocloak.StringP("Ada"),
+		Attributes: &map[string][]string{
+			"keyfobID":      {"123"},
+			"doorPINHash":   {"old-hash"},
+			"lastSwipeTime": {"1"},
+			"welcomeSteps":  {"a"},
+		},
+	}
+	next := &gocloak.User{
+		FirstName: gocloak.StringP("Ada"),
+		LastName:  gocloak.StringP("Lovelace"),
+		Attributes: &map[string][]string{
+			"keyfobID":       {"456"},
+			"doorPINHash":    {"new-hash"},
+			"lastSwipeTime":  {"2"},
+			"welcomeSteps.0": {long[:maxAttrLen]},
+			"welcomeSteps.1": {long[maxAttrLen:]},
+		},
+	}
+
+	assert.Equal(t, []*AttributeChange{
+		{Attribute: "doorPINHash", Old: "<redacted>", New: "<redacted>"},
+		{Attribute: "keyfobID", Old: "123", New: "456"},
+		{Attribute: "lastName", Old: "", New: "Lovelace"},
+		{Attribute: "welcomeSteps", Old: "a", New: long},
+	}, diffUsers(prev, next))
+	assert.Empty(t, diffUsers(next, next))
+}
+
+func TestWriteUserHistory(t *testing.T) {
+	fake := keycloaktest.NewFake("members")
+	fake.AddUser(&gocloak.User{
+		ID:         gocloak.StringP("user-1"),
+		Email:      gocloak.StringP("ada@example.com"),
+		Attributes: &map[string][]string{"keyfobID": {"123"}},
+	}, true)
+	svr := httptest.NewServer(fake)
+	t.Cleanup(svr.Close)
+
+	history := &fakeHistory{}
+	k := New[*datamodel.User](&conf.Env{KeycloakURL: svr.URL, KeycloakRealm: "master", KeycloakMembersGroupID: "members", KeycloakClientID: "test", KeycloakClientSecret: "test"})
+	k.Sink = &nopSink{}
+	k.History = history
+
+	ctx := WithActor(context.Background(), "admin@example.com")
+	user, err := k.GetUser(ctx, "user-1")
+	require.NoError(t, err)
+	user.FobID = 456
+	require.NoError(t, k.WriteUser(ctx, user))
+
+	require.Len(t, history.changes, 1)
+	change := history.changes[0]
+	assert.Equal(t, "user-1", change.UserID)
+	assert.Equal(t, "ada@example.com", change.Email)
+	assert.Equal(t, "admin@example.com", change.Actor)
+	assert.Equal(t, "keyfobID", change.Attribute)
+	assert.Equal(t, "123", change.Old)
+	assert.Equal(t, "456", change.New)
+	assert.False(t, change.Time.IsZero())
+}
+
+func TestActorFromContext(t *testing.T) {
+	assert.Equal(t, "someone", ActorFromContext(WithActor(context.Background(), "someone")))
+	assert.NotEmpty(t, ActorFromContext(context.Background())) // the binary name
+}
+
+type fakeHistory struct {
+	changes []*AttributeChange
+}
+
+func (*fakeHistory) Enabled() bool { return true }
+
+func (f *fakeHistory) RecordAttributeChanges(ctx context.Context, changes []*AttributeChange) error {
+	f.changes = append(f.changes, changes...)
+	return nil
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
+	"net/http"
 	"reflect"
 	"strconv"
 	"sync"
@@ -13,14 +15,22 @@ import (
 
 	"github.com/TheLab-ms/profile/internal/conf"
 	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/flowcontrol"
+	"github.com/TheLab-ms/profile/internal/urls"
 )
 
 var (
 	ErrConflict      = errors.New("conflict")
 	ErrLimitExceeded = errors.New("limit exceeded")
 	ErrNotFound      = errors.New("resource not found")
+
+	// ErrGroupNotAllowed is returned when changing membership of a group that isn't allowed by the config.
+	ErrGroupNotAllowed = errors.New("group membership can't be changed by this service")
 )
 
+// ErrUnavailable is returned (wrapped by gocloak) while the circuit breaker is open or a token can't be acquired.
+var ErrUnavailable = errors.New("keycloak is unavailable")
+
 type UserMetadata interface {
 }
 
@@ -34,18 +44,39 @@ type EventSink interface {
 }
 
 type Keycloak[T UserMetadata] struct {
-	Sink   EventSink
-	client *gocloak.GoCloak
-	env    *conf.Env
+	Sink    EventSink
+	History AttributeHistory // optional
+	client  *gocloak.GoCloak
+	env     *conf.Env
+	breaker *flowcontrol.Breaker
+	members membershipCache
+	users   userCache
 
 	// use ensureToken to access these
 	tokenLock      sync.Mutex
 	token          *gocloak.JWT
 	tokenFetchTime time.Time
+	backoff        tokenBackoff
 }
 
 func New[T UserMetadata](c *conf.Env) *Keycloak[T] {
-	return &Keycloak[T]{client: gocloak.NewClient(c.KeycloakURL), env: c}
+	k := &Keycloak[T]{client: gocloak.NewClient(c.KeycloakURL), env: c}
+	var transport http.RoundTripper = http.DefaultTransport
+	if c.KeycloakBreakerThreshold > 0 {
+		k.breaker = flowcontrol.NewBreaker("keycloak", c.KeycloakBreakerThreshold, c.KeycloakBreakerCooldown)
+		transport = &breakerTransport{breaker: k.breaker, next: transport}
+	}
+	if c.KeycloakRetryAttempts > 1 {
+		transport = &retryTransport{attempts: c.KeycloakRetryAttempts, backoff: c.KeycloakRetryBackoff, next: transport}
+	}
+	k.client.RestyClient().SetTransport(transport)
+	return k
+}
+
+// Unavailable returns true while Keycloak is considered to be down, in which case calls fail fast.
+// That includes backing off after failing to get a token e.g. because the client credentials were rotated.
+func (k *Keycloak[T]) Unavailable() bool {
+	return k != nil && (k.breaker.Open() || k.tokenBackingOff())
 }
 
 // RegisterUser creates a user and initiates the password reset + email confirmation flow.
@@ -84,12 +115,17 @@ func (k *Keycloak[T]) RegisterUser(ctx context.Context, email string) error {
 }
 
 func (k *Keycloak[T]) DeleteUser(ctx context.Context, uuid string) error {
+	defer k.InvalidateUser(uuid)
 	token, err := k.GetToken(ctx)
 	if err != nil {
 		return fmt.Errorf("getting token: %w", err)
 	}
 
-	return k.client.DeleteUser(ctx, token.AccessToken, k.env.KeycloakRealm, uuid)
+	err = k.client.DeleteUser(ctx, token.AccessToken, k.env.KeycloakRealm, uuid)
+	if e, ok := err.(*gocloak.APIError); ok && e.Code == 404 {
+		return ErrNotFound
+	}
+	return err
 }
 
 func (k *Keycloak[T]) SendSignupEmail(ctx context.Context, userID string) error {
@@ -104,8 +140,12 @@ func (k *Keycloak[T]) SendSignupEmail(ctx context.Context, userID string) error
 	}
 
 	resp, err := k.client.GetRequestWithBearerAuth(ctx, token.AccessToken).
-		SetQueryParams(map[string]string{"lifespan": "43200", "redirect_uri": k.env.SelfURL + "/profile", "client_id": string(clientID)}).
-		SetBody([]string{"UPDATE_PASSWORD", "VERIFY_EMAIL"}).
+		SetQueryParams(map[string]string{
+			"lifespan":     strconv.Itoa(int(k.env.SignupEmailLifespan.Seconds())),
+			"redirect_uri": urls.Profile(k.env),
+			"client_id":    string(clientID),
+		}).
+		SetBody(k.env.SignupEmailActions).
 		Put(fmt.Sprintf("%s/admin/realms/%s/users/%s/execute-actions-email", k.env.KeycloakURL, k.env.KeycloakRealm, userID))
 	if err != nil {
 		return fmt.Errorf("sending message: %w", err)
@@ -118,21 +158,29 @@ func (k *Keycloak[T]) SendSignupEmail(ctx context.Context, userID string) error
 
 func (k *Keycloak[T]) GetUser(ctx context.Context, userID string) (T, error) {
 	user := k.newUser()
+	kcuser, err := k.getUser(ctx, userID)
+	if err != nil {
+		return user, err
+	}
+
+	mapToUserType(kcuser, user)
+	return user, nil
+}
+
+func (k *Keycloak[T]) getUser(ctx context.Context, userID string) (*gocloak.User, error) {
 	token, err := k.GetToken(ctx)
 	if err != nil {
-		return user, fmt.Errorf("getting token: %w", err)
+		return nil, fmt.Errorf("getting token: %w", err)
 	}
 
 	kcuser, err := k.client.GetUserByID(ctx, token.AccessToken, k.env.KeycloakRealm, userID)
 	if err != nil {
 		if e, ok := err.(*gocloak.APIError); ok && e.Code == 404 {
-			return user, ErrNotFound
+			return nil, ErrNotFound
 		}
-		return user, err
+		return nil, err
 	}
-
-	mapToUserType(kcuser, user)
-	return user, nil
+	return kcuser, nil
 }
 
 func (k *Keycloak[T]) GetUserByAttribute(ctx context.Context, key, val string) (T, error) {
@@ -157,36 +205,169 @@ func (k *Keycloak[T]) GetUserByAttribute(ctx context.Context, key, val string) (
 	return user, nil
 }
 
+// ListUsersByAttribute returns every user with the given attribute value, for attributes that are expected to be
+// unique but aren't enforced by Keycloak.
+func (k *Keycloak[T]) ListUsersByAttribute(ctx context.Context, key, val string) ([]T, error) {
+	token, err := k.GetToken(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting token: %w", err)
+	}
+
+	kcusers, err := k.client.GetUsers(ctx, token.AccessToken, k.env.KeycloakRealm, gocloak.GetUsersParams{
+		Q: gocloak.StringP(fmt.Sprintf("%s:%s", key, val)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]T, len(kcusers))
+	for i, kcuser := range kcusers {
+		users[i] = k.newUser()
+		mapToUserType(kcuser, users[i])
+	}
+	return users, nil
+}
+
 func (k *Keycloak[T]) GetUserByEmail(ctx context.Context, email string) (T, error) {
 	user := k.newUser()
+	kcuser, err := k.getUserByEmail(ctx, email)
+	if err != nil {
+		return user, err
+	}
+
+	mapToUserType(kcuser, user)
+	return user, nil
+}
+
+func (k *Keycloak[T]) getUserByEmail(ctx context.Context, email string) (*gocloak.User, error) {
 	token, err := k.GetToken(ctx)
 	if err != nil {
-		return user, fmt.Errorf("getting token: %w", err)
+		return nil, fmt.Errorf("getting token: %w", err)
 	}
 
 	kcusers, err := k.client.GetUsers(ctx, token.AccessToken, k.env.KeycloakRealm, gocloak.GetUsersParams{
 		Email: &email,
 	})
 	if err != nil {
-		return user, fmt.Errorf("getting current user: %w", err)
+		return nil, fmt.Errorf("getting current user: %w", err)
 	}
 	if len(kcusers) == 0 {
-		return user, ErrNotFound
+		return nil, ErrNotFound
 	}
+	return kcusers[0], nil
+}
 
-	mapToUserType(kcusers[0], user)
-	return user, nil
+// SearchUsers returns up to max users whose name, username, or email contain the query.
+func (k *Keycloak[T]) SearchUsers(ctx context.Context, query string, max int) ([]T, error) {
+	token, err := k.GetToken(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting token: %w", err)
+	}
+
+	kcusers, err := k.client.GetUsers(ctx, token.AccessToken, k.env.KeycloakRealm, gocloak.GetUsersParams{
+		Search: &query,
+		Max:    &max,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("searching users: %w", err)
+	}
+
+	users := make([]T, len(kcusers))
+	for i, kcuser := range kcusers {
+		users[i] = k.newUser()
+		mapToUserType(kcuser, users[i])
+	}
+	return users, nil
 }
 
+// WriteUser replaces the user's attributes. Changes are recorded to the History (when set), attributed to the
+// actor from the context.
 func (k *Keycloak[T]) WriteUser(ctx context.Context, user *datamodel.User) error {
+	defer k.InvalidateUser(user.UUID)
 	token, err := k.GetToken(ctx)
 	if err != nil {
 		return fmt.Errorf("getting token: %w", err)
 	}
 
+	var prev *gocloak.User
+	if k.History != nil && k.History.Enabled() {
+		current, err := k.client.GetUserByID(ctx, token.AccessToken, k.env.KeycloakRealm, user.UUID)
+		if err != nil {
+			log.Printf("error while getting user %s to record attribute changes: %s", user.UUID, err)
+		} else {
+			// Round trip through the user type so fields that were never set don't show up as changed to their zero values
+			prevUser := &datamodel.User{}
+			mapToUserType(current, prevUser)
+			prev = &gocloak.User{}
+			mapFromUserType(prev, prevUser)
+		}
+	}
+
 	kcuser := gocloak.User{}
 	mapFromUserType(&kcuser, user)
-	return k.client.UpdateUser(ctx, token.AccessToken, k.env.KeycloakRealm, kcuser)
+	if err := k.client.UpdateUser(ctx, token.AccessToken, k.env.KeycloakRealm, kcuser); err != nil {
+		return err
+	}
+	if prev != nil {
+		k.recordChanges(ctx, prev, &kcuser)
+	}
+	return nil
+}
+
+// SetAttribute sets one raw attribute on the user, or removes it when val is empty.
+// It's meant for maintenance (see cmd/profilectl) - typed fields should be changed with WriteUser.
+func (k *Keycloak[T]) SetAttribute(ctx context.Context, userID, key, val string) error {
+	return k.PatchUserAttributes(ctx, userID, map[string]string{key: val})
+}
+
+// PatchUserAttributes sets only the given raw attributes on the user, removing those with empty values.
+// Unlike WriteUser it doesn't overwrite attributes that were changed by other processes since the user was read,
+// so it's preferred when a handler changes a few fields. Values must be encoded the way the mappers encode them
+// e.g. strconv.Itoa for ints and TimeAttr for times.
+func (k *Keycloak[T]) PatchUserAttributes(ctx context.Context, userID string, attrs map[string]string) error {
+	defer k.InvalidateUser(userID)
+	token, err := k.GetToken(ctx)
+	if err != nil {
+		return fmt.Errorf("getting token: %w", err)
+	}
+
+	kcuser, err := k.client.GetUserByID(ctx, token.AccessToken, k.env.KeycloakRealm, userID)
+	if err != nil {
+		if e, ok := err.(*gocloak.APIError); ok && e.Code == 404 {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	prev := *kcuser
+	prevAttrs := map[string][]string{}
+	for attr, vals := range safeGetAttrs(kcuser) {
+		prevAttrs[attr] = vals
+	}
+	prev.Attributes = &prevAttrs
+
+	current := safeGetAttrs(kcuser)
+	for key, val := range attrs {
+		setChunkedAttr(current, key, val)
+		if val == "" {
+			delete(current, key)
+		}
+	}
+	if err := k.client.UpdateUser(ctx, token.AccessToken, k.env.KeycloakRealm, *kcuser); err != nil {
+		return err
+	}
+	if k.History != nil && k.History.Enabled() {
+		k.recordChanges(ctx, &prev, kcuser)
+	}
+	return nil
+}
+
+// TimeAttr encodes a time attribute for PatchUserAttributes. The zero time is encoded as empty (removed).
+func TimeAttr(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return strconv.FormatInt(t.Unix(), 10)
 }
 
 func (k *Keycloak[T]) Deactivate(ctx context.Context, user *datamodel.User) error {
@@ -195,7 +376,7 @@ func (k *Keycloak[T]) Deactivate(ctx context.Context, user *datamodel.User) erro
 		return fmt.Errorf("getting token: %w", err)
 	}
 
-	return k.client.DeleteUserFromGroup(ctx, token.AccessToken, k.env.KeycloakRealm, user.UUID, k.env.KeycloakMembersGroupID)
+	return k.removeFromGroup(ctx, token, user, k.env.KeycloakMembersGroupID)
 }
 
 func (k *Keycloak[T]) UpdateGroupMembership(ctx context.Context, user *datamodel.User, active bool) error {
@@ -215,11 +396,11 @@ func (k *Keycloak[T]) UpdateGroupMembership(ctx context.Context, user *datamodel
 	inGroup := len(groups) > 0
 	if !inGroup && active {
 		k.Sink.Eventf(user.Email, "MembershipActivated", "A change in payment state caused the user's membership to be enabled")
-		err = k.client.AddUserToGroup(ctx, token.AccessToken, k.env.KeycloakRealm, user.UUID, k.env.KeycloakMembersGroupID)
+		err = k.addToGroup(ctx, token, user, k.env.KeycloakMembersGroupID)
 	}
 	if inGroup && !active {
 		k.Sink.Eventf(user.Email, "MembershipDeactivated", "A change in payment state caused the user's membership to be disabled")
-		err = k.client.DeleteUserFromGroup(ctx, token.AccessToken, k.env.KeycloakRealm, user.UUID, k.env.KeycloakMembersGroupID)
+		err = k.removeFromGroup(ctx, token, user, k.env.KeycloakMembersGroupID)
 	}
 	if err != nil {
 		return fmt.Errorf("updating user group membership: %w", err)
@@ -228,88 +409,108 @@ func (k *Keycloak[T]) UpdateGroupMembership(ctx context.Context, user *datamodel
 	return nil
 }
 
+// addToGroup and removeFromGroup are the only way group membership is changed, so a bug can't grant or revoke
+// groups like leadership (see groupAllowed).
+func (k *Keycloak[T]) addToGroup(ctx context.Context, token *gocloak.JWT, user *datamodel.User, groupID string) error {
+	if !k.groupAllowed(groupID) {
+		k.Sink.Eventf(user.Email, "GroupChangeRefused", "refused to add the user to group %s, which isn't in the allowlist", groupID)
+		return fmt.Errorf("adding user to group %s: %w", groupID, ErrGroupNotAllowed)
+	}
+	defer k.InvalidateMembership(user.UUID)
+	return k.client.AddUserToGroup(ctx, token.AccessToken, k.env.KeycloakRealm, user.UUID, groupID)
+}
+
+func (k *Keycloak[T]) removeFromGroup(ctx context.Context, token *gocloak.JWT, user *datamodel.User, groupID string) error {
+	if !k.groupAllowed(groupID) {
+		k.Sink.Eventf(user.Email, "GroupChangeRefused", "refused to remove the user from group %s, which isn't in the allowlist", groupID)
+		return fmt.Errorf("removing user from group %s: %w", groupID, ErrGroupNotAllowed)
+	}
+	defer k.InvalidateMembership(user.UUID)
+	return k.client.DeleteUserFromGroup(ctx, token.AccessToken, k.env.KeycloakRealm, user.UUID, groupID)
+}
+
+// groupAllowed returns true for the members group and any group in KeycloakWritableGroupIDs.
+func (k *Keycloak[T]) groupAllowed(groupID string) bool {
+	if groupID == "" {
+		return false
+	}
+	if groupID == k.env.KeycloakMembersGroupID {
+		return true
+	}
+	for _, id := range k.env.KeycloakWritableGroupIDs {
+		if id == groupID {
+			return true
+		}
+	}
+	return false
+}
+
 func (k *Keycloak[T]) ExtendUser(ctx context.Context, user T, uuid string) (*ExtendedUser[T], error) {
 	token, err := k.GetToken(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("getting token: %w", err)
 	}
 
-	groups, err := k.client.GetUserGroups(ctx, token.AccessToken, k.env.KeycloakRealm, uuid, gocloak.GetGroupsParams{
-		Max:    gocloak.IntP(1),
-		Search: gocloak.StringP("thelab-members"),
-	})
+	member, err := k.isActiveMember(ctx, token, uuid)
 	if err != nil {
-		if e, ok := err.(*gocloak.APIError); ok && e.Code == 404 {
-			return nil, ErrNotFound
-		}
-		return nil, fmt.Errorf("getting group membership: %w", err)
+		return nil, err
 	}
 
 	return &ExtendedUser[T]{
 		User:         user,
-		ActiveMember: len(groups) > 0,
+		ActiveMember: member,
 	}, nil
 }
 
 func (k *Keycloak[T]) ListUsers(ctx context.Context) ([]*ExtendedUser[T], error) {
-	token, err := k.GetToken(ctx)
+	users := []*ExtendedUser[T]{}
+	err := k.ListUsersStream(ctx, func(user *ExtendedUser[T]) error {
+		users = append(users, user)
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("getting token: %w", err)
+		return nil, err
 	}
+	return users, nil
+}
 
-	var (
-		max           = 150
-		first         = 0
-		activeMembers = map[string]struct{}{}
-	)
-	for {
-		params, err := gocloak.GetQueryParams(gocloak.GetUsersParams{
-			BriefRepresentation: gocloak.BoolP(true),
-			Max:                 &max,
-			First:               &first,
-		})
-		if err != nil {
-			return nil, err
-		}
-
-		// Unfortunately the keycloak client doesn't support the group membership endpoint.
-		// We reuse the client's transport here while specifying our own URL.
-		var memberships []*gocloak.User
-		_, err = k.client.GetRequestWithBearerAuth(ctx, token.AccessToken).
-			SetResult(&memberships).
-			SetQueryParams(params).
-			Get(fmt.Sprintf("%s/admin/realms/%s/groups/%s/members", k.env.KeycloakURL, k.env.KeycloakRealm, k.env.KeycloakMembersGroupID))
-		if err != nil {
-			return nil, err
-		}
-		if len(memberships) == 0 {
-			break
-		}
-		first += len(memberships)
+// ListUsersStream pages through every user in the realm, calling fn for each.
+// Paging stops and the error is returned if fn returns an error.
+func (k *Keycloak[T]) ListUsersStream(ctx context.Context, fn func(*ExtendedUser[T]) error) error {
+	token, err := k.GetToken(ctx)
+	if err != nil {
+		return fmt.Errorf("getting token: %w", err)
+	}
 
-		for _, member := range memberships {
-			activeMembers[gocloak.PString(member.ID)] = struct{}{}
-		}
+	start := time.Now()
+	activeMembers, err := k.listGroupMembers(ctx, token)
+	if err != nil {
+		return err
+	}
+	if k.env.KeycloakMembershipCacheTTL > 0 {
+		k.members.replace(activeMembers, start)
 	}
 
-	parsedUsers := []*ExtendedUser[T]{}
-	max = 150
-	first = 0
+	var (
+		max   = 150
+		first = 0
+	)
 	for {
 		users, err := k.client.GetUsers(ctx, token.AccessToken, k.env.KeycloakRealm, gocloak.GetUsersParams{Max: &max, First: &first})
 		if err != nil {
-			return nil, fmt.Errorf("getting token: %w", err)
+			return fmt.Errorf("listing users: %w", err)
 		}
 		if len(users) == 0 {
-			return parsedUsers, nil
+			return nil
 		}
 		first += len(users)
 		for _, kcuser := range users {
 			user := k.newUser()
 			mapToUserType(kcuser, user)
 			_, member := activeMembers[gocloak.PString(kcuser.ID)]
-			fullUser := &ExtendedUser[T]{User: user, ActiveMember: member}
-			parsedUsers = append(parsedUsers, fullUser)
+			if err := fn(&ExtendedUser[T]{User: user, ActiveMember: member}); err != nil {
+				return err
+			}
 		}
 	}
 }
@@ -0,0 +1,379 @@
+package keycloak
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TheLab-ms/profile/internal/conf"
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/flowcontrol"
+	"github.com/TheLab-ms/profile/internal/keycloak/keycloaktest"
+)
+
+func TestGroupAllowed(t *testing.T) {
+	k := New[*datamodel.User](&conf.Env{KeycloakMembersGroupID: "members", KeycloakWritableGroupIDs: []string{"volunteers"}})
+	k.Sink = &nopSink{}
+
+	assert.True(t, k.groupAllowed("members"))
+	assert.True(t, k.groupAllowed("volunteers"))
+	assert.False(t, k.groupAllowed("leadership"))
+	assert.False(t, k.groupAllowed(""))
+
+	// Refused before Keycloak is called
+	err := k.addToGroup(context.Background(), nil, &datamodel.User{UUID: "user"}, "leadership")
+	assert.True(t, errors.Is(err, ErrGroupNotAllowed))
+	err = k.removeFromGroup(context.Background(), nil, &datamodel.User{UUID: "user"}, "leadership")
+	assert.True(t, errors.Is(err, ErrGroupNotAllowed))
+}
+
+func TestGetTokenBackoff(t *testing.T) {
+	var calls atomic.Int32
+	var ok atomic.Bool
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if !ok.Load() {
+			http.Error(w, `{"error": "unauthorized_client"}`, http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "token", "expires_in": 300}`))
+	}))
+	t.Cleanup(svr.Close)
+
+	k := New[*datamodel.User](&conf.Env{KeycloakURL: svr.URL, KeycloakRealm: "master", KeycloakClientID: "test", KeycloakClientSecret: "test"})
+	ctx := context.Background()
+
+	_, err := k.GetToken(ctx)
+	assert.True(t, errors.Is(err, ErrUnavailable))
+	assert.True(t, k.Unavailable())
+
+	// Fails fast while backing off
+	_, err = k.GetToken(ctx)
+	assert.True(t, errors.Is(err, ErrUnavailable))
+	assert.Equal(t, int32(1), calls.Load())
+
+	// Recovers once the backoff has passed
+	ok.Store(true)
+	k.backoff.retryAt.Store(time.Now().Add(-time.Second).UnixNano())
+	token, err := k.GetToken(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "token", token.AccessToken)
+	assert.False(t, k.Unavailable())
+	assert.Equal(t, 0, k.backoff.failures)
+}
+
+func TestMembershipCache(t *testing.T) {
+	fake := keycloaktest.NewFake("members")
+	fake.AddUser(&gocloak.User{ID: gocloak.StringP("member"), Email: gocloak.StringP("member@example.com")}, true)
+	fake.AddUser(&gocloak.User{ID: gocloak.StringP("nonmember"), Email: gocloak.StringP("nonmember@example.com")}, false)
+
+	var groupQueries, memberListings atomic.Int32
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/groups/members/members") && r.URL.Query().Get("first") == "0":
+			memberListings.Add(1)
+		case strings.HasSuffix(r.URL.Path, "/groups"):
+			groupQueries.Add(1)
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	t.Cleanup(svr.Close)
+
+	k := New[*datamodel.User](&conf.Env{
+		KeycloakURL:                svr.URL,
+		KeycloakRealm:              "master",
+		KeycloakMembersGroupID:     "members",
+		KeycloakClientID:           "test",
+		KeycloakClientSecret:       "test",
+		KeycloakMembershipCacheTTL: time.Minute,
+	})
+	k.Sink = &nopSink{}
+	ctx := context.Background()
+
+	isMember := func(uuid string) bool {
+		ext, err := k.ExtendUser(ctx, &datamodel.User{UUID: uuid}, uuid)
+		require.NoError(t, err)
+		return ext.ActiveMember
+	}
+
+	// Served from a single listing of the group
+	assert.True(t, isMember("member"))
+	assert.False(t, isMember("nonmember"))
+	assert.Equal(t, int32(1), memberListings.Load())
+	assert.Equal(t, int32(0), groupQueries.Load())
+
+	// Changes made in Keycloak aren't visible until the webhook invalidates the user
+	fake.AddUser(&gocloak.User{ID: gocloak.StringP("nonmember"), Email: gocloak.StringP("nonmember@example.com")}, true)
+	assert.False(t, isMember("nonmember"))
+	k.InvalidateMembership("nonmember")
+	assert.True(t, isMember("nonmember"))
+	assert.True(t, isMember("nonmember"))
+	assert.Equal(t, int32(1), groupQueries.Load())
+
+	// Our own changes invalidate the user
+	require.NoError(t, k.Deactivate(ctx, &datamodel.User{UUID: "member", Email: "member@example.com"}))
+	assert.False(t, isMember("member"))
+	assert.Equal(t, int32(2), groupQueries.Load())
+
+	// Listing users refreshes the set
+	_, err := k.ListUsers(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), memberListings.Load())
+
+	// Expired sets are listed again
+	k.members.refreshed = time.Now().Add(-time.Hour)
+	assert.True(t, isMember("nonmember"))
+	assert.Equal(t, int32(3), memberListings.Load())
+	assert.Equal(t, int32(2), groupQueries.Load())
+}
+
+func TestListUsersStream(t *testing.T) {
+	// More than a page of users
+	fake := keycloaktest.NewFake("members")
+	for i := 0; i < 200; i++ {
+		id := fmt.Sprintf("user-%d", i)
+		fake.AddUser(&gocloak.User{ID: gocloak.StringP(id), Email: gocloak.StringP(id + "@example.com")}, i%2 == 0)
+	}
+	svr := httptest.NewServer(fake)
+	t.Cleanup(svr.Close)
+
+	k := New[*datamodel.User](&conf.Env{
+		KeycloakURL:            svr.URL,
+		KeycloakRealm:          "master",
+		KeycloakMembersGroupID: "members",
+		KeycloakClientID:       "test",
+		KeycloakClientSecret:   "test",
+	})
+	k.Sink = &nopSink{}
+	ctx := context.Background()
+
+	seen := map[string]bool{}
+	members := 0
+	err := k.ListUsersStream(ctx, func(user *ExtendedUser[*datamodel.User]) error {
+		assert.False(t, seen[user.User.UUID], "user %s was listed twice", user.User.UUID)
+		seen[user.User.UUID] = true
+		if user.ActiveMember {
+			members++
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Len(t, seen, 200)
+	assert.Equal(t, 100, members)
+
+	// Errors from the callback stop paging
+	errStop := errors.New("stop")
+	calls := 0
+	err = k.ListUsersStream(ctx, func(user *ExtendedUser[*datamodel.User]) error {
+		calls++
+		return errStop
+	})
+	assert.True(t, errors.Is(err, errStop))
+	assert.Equal(t, 1, calls)
+}
+
+type nopSink struct{}
+
+func (*nopSink) Eventf(email, reason, templ string, args ...any) {}
+
+func TestPatchUserAttributes(t *testing.T) {
+	fake := keycloaktest.NewFake("members")
+	fake.AddUser(&gocloak.User{
+		ID:    gocloak.StringP("user"),
+		Email: gocloak.StringP("user@example.com"),
+		Attributes: &map[string][]string{
+			"keyfobID":      {"123"},
+			"discountType":  {"military"},
+			"lastSwipeTime": {"1000"},
+		},
+	}, true)
+	svr := httptest.NewServer(fake)
+	t.Cleanup(svr.Close)
+
+	k := New[*datamodel.User](&conf.Env{
+		KeycloakURL:            svr.URL,
+		KeycloakRealm:          "master",
+		KeycloakMembersGroupID: "members",
+		KeycloakClientID:       "test",
+		KeycloakClientSecret:   "test",
+	})
+	k.Sink = &nopSink{}
+	ctx := context.Background()
+
+	err := k.PatchUserAttributes(ctx, "user", map[string]string{"keyfobID": "456", "discountType": "", "waiverState": "Signed"})
+	require.NoError(t, err)
+
+	user, err := k.GetUser(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, 456, user.FobID)
+	assert.Equal(t, "", user.DiscountType)
+	assert.Equal(t, "Signed", user.WaiverState)
+	assert.Equal(t, int64(1000), user.LastSwipeTime.Unix(), "unrelated attributes are preserved")
+
+	err = k.PatchUserAttributes(ctx, "missing", map[string]string{"keyfobID": "1"})
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestRetryTransientFailures(t *testing.T) {
+	fake := keycloaktest.NewFake("members")
+	fake.AddUser(&gocloak.User{ID: gocloak.StringP("user"), Email: gocloak.StringP("user@example.com")}, true)
+
+	var failures, putFailures, userCalls, createCalls atomic.Int32
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/users") && r.Method == http.MethodPost {
+			createCalls.Add(1)
+		} else if strings.HasSuffix(r.URL.Path, "/users/user") {
+			userCalls.Add(1)
+		}
+		if r.Method == http.MethodPut && putFailures.Add(-1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if strings.Contains(r.URL.Path, "/admin/") && failures.Add(-1) >= 0 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	t.Cleanup(svr.Close)
+
+	k := New[*datamodel.User](&conf.Env{
+		KeycloakURL:           svr.URL,
+		KeycloakRealm:         "master",
+		KeycloakClientID:      "test",
+		KeycloakClientSecret:  "test",
+		KeycloakRetryAttempts: 3,
+		KeycloakRetryBackoff:  time.Millisecond,
+	})
+	k.Sink = &nopSink{}
+	ctx := context.Background()
+
+	// Recovers within the attempts
+	failures.Store(2)
+	user, err := k.GetUser(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, "user@example.com", user.Email)
+	assert.Equal(t, int32(3), userCalls.Load())
+
+	// Gives up after the last attempt
+	userCalls.Store(0)
+	failures.Store(5)
+	_, err = k.GetUser(ctx, "user")
+	assert.Error(t, err)
+	assert.Equal(t, int32(3), userCalls.Load())
+
+	// Writes are only retried when they're idempotent
+	putFailures.Store(1)
+	err = k.PatchUserAttributes(ctx, "user", map[string]string{"waiverState": "Signed"})
+	require.NoError(t, err)
+	user, err = k.GetUser(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, "Signed", user.WaiverState)
+
+	failures.Store(1)
+	_, err = k.client.CreateUser(ctx, "fake-token", "master", gocloak.User{Email: gocloak.StringP("new@example.com")})
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), createCalls.Load())
+}
+
+func TestUserCache(t *testing.T) {
+	fake := keycloaktest.NewFake("members")
+	fake.AddUser(&gocloak.User{ID: gocloak.StringP("user"), Email: gocloak.StringP("user@example.com")}, true)
+
+	var reads atomic.Int32
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/users") {
+			reads.Add(1)
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	t.Cleanup(svr.Close)
+
+	k := New[*datamodel.User](&conf.Env{
+		KeycloakURL:          svr.URL,
+		KeycloakRealm:        "master",
+		KeycloakClientID:     "test",
+		KeycloakClientSecret: "test",
+		KeycloakUserCacheTTL: time.Minute,
+	})
+	k.Sink = &nopSink{}
+	ctx := context.Background()
+
+	// Disabled without webhooks to invalidate it
+	_, err := k.GetUserCached(ctx, "user")
+	require.NoError(t, err)
+	_, err = k.GetUserCached(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), reads.Load())
+
+	reads.Store(0)
+	k.env.KeycloakWebhookSecret = "test"
+	user, err := k.GetUserCached(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), reads.Load())
+
+	// Hits return copies
+	user.WaiverState = "Signed"
+	user, err = k.GetUserCached(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, "", user.WaiverState)
+	assert.Equal(t, int32(1), reads.Load())
+
+	// Our own writes invalidate
+	reads.Store(0)
+	require.NoError(t, k.PatchUserAttributes(ctx, "user", map[string]string{"waiverState": "Signed"}))
+	user, err = k.GetUserCached(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, "Signed", user.WaiverState)
+	assert.Equal(t, int32(2), reads.Load(), "one read for the patch, one for the miss")
+
+	// As do webhooks
+	reads.Store(0)
+	k.InvalidateUser("user")
+	_, err = k.GetUserCached(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), reads.Load())
+
+	// Users invalidated while being read aren't cached
+	gen := k.users.generation()
+	k.InvalidateUser("user")
+	k.users.set(&gocloak.User{ID: gocloak.StringP("user")}, gen, time.Minute, time.Now())
+	assert.Nil(t, k.users.lookup("user", time.Minute, time.Now()))
+}
+
+func TestBreakerIgnoresCanceledRequests(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	t.Cleanup(svr.Close)
+
+	breaker := flowcontrol.NewBreaker("test", 1, time.Hour)
+	client := &http.Client{Transport: &breakerTransport{breaker: breaker, next: http.DefaultTransport}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, svr.URL, nil)
+	require.NoError(t, err)
+	_, err = client.Do(req)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.False(t, breaker.Open())
+
+	// Unreachable servers still trip it
+	svr.Close()
+	req, err = http.NewRequest(http.MethodGet, svr.URL, nil)
+	require.NoError(t, err)
+	_, err = client.Do(req)
+	require.Error(t, err)
+	assert.True(t, breaker.Open())
+}
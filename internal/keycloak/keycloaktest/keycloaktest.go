@@ -0,0 +1,210 @@
+// Package keycloaktest provides an in-memory fake of the Keycloak admin API for tests and local development.
+package keycloaktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// Fake implements just enough of the Keycloak admin API to back the internal/keycloak client.
+type Fake struct {
+	mut     sync.Mutex
+	users   map[string]*gocloak.User
+	members map[string]bool
+	groupID string
+	nextID  int
+}
+
+func NewFake(groupID string) *Fake {
+	return &Fake{users: map[string]*gocloak.User{}, members: map[string]bool{}, groupID: groupID}
+}
+
+func (f *Fake) AddUser(user *gocloak.User, member bool) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	if user.CreatedTimestamp == nil {
+		user.CreatedTimestamp = gocloak.Int64P(time.Now().UnixMilli())
+	}
+	f.users[gocloak.PString(user.ID)] = user
+	f.members[gocloak.PString(user.ID)] = member
+}
+
+func (f *Fake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/protocol/openid-connect/token"):
+		writeJSON(w, &gocloak.JWT{AccessToken: "fake-token", ExpiresIn: 3600})
+	case len(parts) == 3 && parts[0] == "realms" && parts[2] == "webhooks":
+		writeJSON(w, []any{})
+	case len(parts) > 3 && parts[0] == "admin" && parts[1] == "realms":
+		f.serveAdmin(w, r, parts[3:])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *Fake) serveAdmin(w http.ResponseWriter, r *http.Request, parts []string) {
+	switch {
+	case len(parts) == 3 && parts[0] == "groups" && parts[2] == "members":
+		users := []*gocloak.User{}
+		for _, user := range f.sortedUsers() {
+			if f.members[gocloak.PString(user.ID)] {
+				users = append(users, user)
+			}
+		}
+		writeJSON(w, paginate(r, users))
+
+	case len(parts) == 1 && parts[0] == "users" && r.Method == http.MethodPost:
+		user := &gocloak.User{}
+		if err := json.NewDecoder(r.Body).Decode(user); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		for _, existing := range f.users {
+			if strings.EqualFold(gocloak.PString(existing.Email), gocloak.PString(user.Email)) {
+				w.WriteHeader(409)
+				return
+			}
+		}
+		f.nextID++
+		user.ID = gocloak.StringP(fmt.Sprintf("created-user-%d", f.nextID))
+		user.CreatedTimestamp = gocloak.Int64P(time.Now().UnixMilli())
+		f.users[*user.ID] = user
+		w.Header().Set("Location", r.URL.String()+"/"+*user.ID)
+		w.WriteHeader(201)
+
+	case len(parts) == 1 && parts[0] == "users":
+		writeJSON(w, paginate(r, f.filterUsers(r)))
+
+	case len(parts) == 2 && parts[0] == "users" && parts[1] == "count":
+		writeJSON(w, len(f.filterUsers(r)))
+
+	case len(parts) == 2 && parts[0] == "users":
+		user, ok := f.users[parts[1]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, user)
+		case http.MethodPut:
+			updated := &gocloak.User{}
+			if err := json.NewDecoder(r.Body).Decode(updated); err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			updated.CreatedTimestamp = user.CreatedTimestamp
+			f.users[parts[1]] = updated
+			w.WriteHeader(204)
+		case http.MethodDelete:
+			delete(f.users, parts[1])
+			delete(f.members, parts[1])
+			w.WriteHeader(204)
+		}
+
+	case len(parts) == 3 && parts[0] == "users" && parts[2] == "groups":
+		groups := []*gocloak.Group{}
+		if f.members[parts[1]] {
+			groups = append(groups, &gocloak.Group{ID: &f.groupID, Name: gocloak.StringP("thelab-members")})
+		}
+		writeJSON(w, groups)
+
+	case len(parts) == 4 && parts[0] == "users" && parts[2] == "groups":
+		f.members[parts[1]] = r.Method == http.MethodPut
+		w.WriteHeader(204)
+
+	case len(parts) == 3 && parts[0] == "users" && parts[2] == "execute-actions-email":
+		log.Printf("fake keycloak would have sent an actions email to user %s", parts[1])
+		w.WriteHeader(204)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// filterUsers supports the subset of user search params used by internal/keycloak.
+func (f *Fake) filterUsers(r *http.Request) []*gocloak.User {
+	email := r.URL.Query().Get("email")
+	q := r.URL.Query().Get("q")
+	search := strings.ToLower(r.URL.Query().Get("search"))
+	unverified := r.URL.Query().Get("emailVerified") == "false"
+
+	users := []*gocloak.User{}
+	for _, user := range f.sortedUsers() {
+		if email != "" && !strings.EqualFold(gocloak.PString(user.Email), email) {
+			continue
+		}
+		if unverified && gocloak.PBool(user.EmailVerified) {
+			continue
+		}
+		if search != "" && !matchesSearch(user, search) {
+			continue
+		}
+		if key, val, ok := strings.Cut(q, ":"); ok {
+			if user.Attributes == nil || firstAttr(*user.Attributes, key) != val {
+				continue
+			}
+		}
+		users = append(users, user)
+	}
+	return users
+}
+
+func matchesSearch(user *gocloak.User, search string) bool {
+	for _, field := range []*string{user.Username, user.Email, user.FirstName, user.LastName} {
+		if strings.Contains(strings.ToLower(gocloak.PString(field)), search) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Fake) sortedUsers() []*gocloak.User {
+	users := make([]*gocloak.User, 0, len(f.users))
+	for _, user := range f.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return gocloak.PString(users[i].ID) < gocloak.PString(users[j].ID) })
+	return users
+}
+
+func paginate(r *http.Request, users []*gocloak.User) []*gocloak.User {
+	first, _ := strconv.Atoi(r.URL.Query().Get("first"))
+	max, err := strconv.Atoi(r.URL.Query().Get("max"))
+	if err != nil {
+		max = 100
+	}
+	if first >= len(users) {
+		return []*gocloak.User{}
+	}
+	users = users[first:]
+	if max < len(users) {
+		users = users[:max]
+	}
+	return users
+}
+
+func firstAttr(attrs map[string][]string, key string) string {
+	if len(attrs[key]) == 0 {
+		return ""
+	}
+	return attrs[key][0]
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,158 @@
+// mappergen writes reflection-free versions of the keycloak package's user mappers for the types listed below.
+// The output must behave exactly like the reflective mappers in conversion.go, so keep the two in sync.
+//
+// Run it with `go generate ./internal/keycloak` after changing any of the types.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+)
+
+// types are the user types worth generating mappers for i.e. the ones loaded during resyncs.
+var types = []any{datamodel.User{}}
+
+func main() {
+	out := flag.String("o", "zz_generated_mappers.go", "output file")
+	flag.Parse()
+
+	buf := &bytes.Buffer{}
+	fmt.Fprint(buf, `// Code generated by mappergen. DO NOT EDIT.
+
+package keycloak
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+)
+
+func init() {
+`)
+	for _, t := range types {
+		name := funcName(reflect.TypeOf(t))
+		fmt.Fprintf(buf, "\tregisterMapper(mapTo%s, mapFrom%s)\n", name, name)
+	}
+	fmt.Fprint(buf, "}\n")
+
+	for _, t := range types {
+		rt := reflect.TypeOf(t)
+		writeTo(buf, rt)
+		writeFrom(buf, rt)
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatalf("formatting generated code: %s\n%s", err, buf.String())
+	}
+	if err := os.WriteFile(*out, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// funcName turns e.g. datamodel.User into DatamodelUser.
+func funcName(rt reflect.Type) string {
+	pkg := rt.String()[:strings.Index(rt.String(), ".")]
+	return strings.ToUpper(pkg[:1]) + pkg[1:] + rt.Name()
+}
+
+func writeTo(buf *bytes.Buffer, rt reflect.Type) {
+	fmt.Fprintf(buf, "\nfunc mapTo%s(kcuser *gocloak.User, user *%s) {\n", funcName(rt), rt.String())
+	fmt.Fprint(buf, "\tattrs := safeGetAttrs(kcuser)\n")
+	for i := 0; i < rt.NumField(); i++ {
+		ft := rt.Field(i)
+		tag := ft.Tag.Get("keycloak")
+		switch tag {
+		case "id":
+			fmt.Fprintf(buf, "\tuser.%s = gocloak.PString(kcuser.ID)\n", ft.Name)
+		case "username":
+			fmt.Fprintf(buf, "\tuser.%s = gocloak.PString(kcuser.Username)\n", ft.Name)
+		case "first":
+			fmt.Fprintf(buf, "\tuser.%s = gocloak.PString(kcuser.FirstName)\n", ft.Name)
+		case "last":
+			fmt.Fprintf(buf, "\tuser.%s = gocloak.PString(kcuser.LastName)\n", ft.Name)
+		case "email":
+			fmt.Fprintf(buf, "\tuser.%s = gocloak.PString(kcuser.Email)\n", ft.Name)
+		case "emailVerified":
+			fmt.Fprintf(buf, "\tuser.%s = gocloak.PBool(kcuser.EmailVerified)\n", ft.Name)
+		case "ctime":
+			fmt.Fprintf(buf, "\tuser.%s = *kcuser.CreatedTimestamp\n", ft.Name)
+		}
+		if !strings.HasPrefix(tag, "attr.") {
+			continue
+		}
+
+		key := strings.TrimPrefix(tag, "attr.")
+		fmt.Fprintf(buf, "\tif val := getChunkedAttr(attrs, %q); val != \"\" {\n", key)
+		switch tn := ft.Type.String(); tn {
+		case "int", "int64":
+			fmt.Fprintf(buf, "\t\ti, _ := strconv.ParseInt(val, 10, 0)\n\t\tuser.%s = %s(i)\n", ft.Name, tn)
+		case "bool":
+			fmt.Fprintf(buf, "\t\tuser.%s, _ = strconv.ParseBool(val)\n", ft.Name)
+		case "string":
+			fmt.Fprintf(buf, "\t\tuser.%s = val\n", ft.Name)
+		case "time.Time":
+			fmt.Fprintf(buf, "\t\ti, _ := strconv.ParseInt(val, 10, 0)\n\t\tuser.%s = time.Unix(i, 0)\n", ft.Name)
+		default:
+			fmt.Fprintf(buf, "\t\tif val != \"null\" { // nil maps etc. are stored as \"null\"\n")
+			fmt.Fprintf(buf, "\t\t\tvar v %s\n\t\t\tjson.Unmarshal([]byte(val), &v)\n\t\t\tuser.%s = v\n\t\t}\n", tn, ft.Name)
+		}
+		fmt.Fprint(buf, "\t}\n")
+	}
+	fmt.Fprint(buf, "}\n")
+}
+
+func writeFrom(buf *bytes.Buffer, rt reflect.Type) {
+	fmt.Fprintf(buf, "\nfunc mapFrom%s(kcuser *gocloak.User, user *%s) {\n", funcName(rt), rt.String())
+	fmt.Fprint(buf, "\tattrs := safeGetAttrs(kcuser)\n\tvar raw []byte\n")
+	for i := 0; i < rt.NumField(); i++ {
+		ft := rt.Field(i)
+		tag := ft.Tag.Get("keycloak")
+		switch tag {
+		case "id":
+			fmt.Fprintf(buf, "\tkcuser.ID = gocloak.StringP(user.%s)\n", ft.Name)
+		case "username":
+			fmt.Fprintf(buf, "\tkcuser.Username = gocloak.StringP(user.%s)\n", ft.Name)
+		case "first":
+			fmt.Fprintf(buf, "\tkcuser.FirstName = gocloak.StringP(user.%s)\n", ft.Name)
+		case "last":
+			fmt.Fprintf(buf, "\tkcuser.LastName = gocloak.StringP(user.%s)\n", ft.Name)
+		case "email":
+			fmt.Fprintf(buf, "\tkcuser.Email = gocloak.StringP(user.%s)\n", ft.Name)
+		case "emailVerified":
+			fmt.Fprintf(buf, "\tkcuser.EmailVerified = gocloak.BoolP(user.%s)\n", ft.Name)
+		}
+		if !strings.HasPrefix(tag, "attr.") {
+			continue
+		}
+
+		key := strings.TrimPrefix(tag, "attr.")
+		switch ft.Type.String() {
+		case "int":
+			fmt.Fprintf(buf, "\tif user.%s != 0 {\n\t\tattrs[%q] = []string{strconv.Itoa(user.%s)}\n\t}\n", ft.Name, key, ft.Name)
+		case "int64":
+			fmt.Fprintf(buf, "\tif user.%s != 0 {\n\t\tattrs[%q] = []string{strconv.FormatInt(user.%s, 10)}\n\t}\n", ft.Name, key, ft.Name)
+		case "bool":
+			fmt.Fprintf(buf, "\tattrs[%q] = []string{strconv.FormatBool(user.%s)}\n", key, ft.Name)
+		case "string":
+			fmt.Fprintf(buf, "\tif user.%s != \"\" {\n\t\tattrs[%q] = []string{user.%s}\n\t}\n", ft.Name, key, ft.Name)
+		case "time.Time":
+			fmt.Fprintf(buf, "\tif user.%s != (time.Time{}) {\n\t\tattrs[%q] = []string{strconv.FormatInt(user.%s.Unix(), 10)}\n\t}\n", ft.Name, key, ft.Name)
+		default:
+			fmt.Fprintf(buf, "\traw, _ = json.Marshal(user.%s)\n\tsetChunkedAttr(attrs, %q, string(raw))\n", ft.Name, key)
+		}
+	}
+	fmt.Fprint(buf, "}\n")
+}
@@ -0,0 +1,189 @@
+package keycloak
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// membershipCache holds the members group's user IDs so ExtendUser doesn't need to query Keycloak for every user.
+// Users are marked stale when we change their membership or Keycloak tells us they changed (see InvalidateMembership),
+// which makes the next lookup go to Keycloak until the set is refreshed.
+type membershipCache struct {
+	refreshLock sync.Mutex // serializes refreshes
+
+	mut       sync.Mutex
+	members   map[string]struct{}
+	stale     map[string]time.Time // invalidation time by user ID
+	refreshed time.Time            // when the current set started being enumerated
+}
+
+// lookup returns the cached membership of a user, or false if it's unknown.
+func (m *membershipCache) lookup(uuid string, ttl time.Duration, now time.Time) (member, ok bool) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	if m.members == nil || now.Sub(m.refreshed) >= ttl {
+		return false, false
+	}
+	if _, ok := m.stale[uuid]; ok {
+		return false, false
+	}
+	_, member = m.members[uuid]
+	return member, true
+}
+
+func (m *membershipCache) fresh(ttl time.Duration, now time.Time) bool {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	return m.members != nil && now.Sub(m.refreshed) < ttl
+}
+
+// replace swaps in (a copy of) a set that was enumerated starting at the given time.
+// Invalidations that happened after that point are kept since the enumeration may have missed them.
+func (m *membershipCache) replace(members map[string]struct{}, started time.Time) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	if started.Before(m.refreshed) {
+		return // a newer enumeration already finished
+	}
+	m.members = make(map[string]struct{}, len(members))
+	for uuid := range members {
+		m.members[uuid] = struct{}{}
+	}
+	m.refreshed = started
+	for uuid, t := range m.stale {
+		if t.Before(started) {
+			delete(m.stale, uuid)
+		}
+	}
+}
+
+// set records the membership of a single user, queried live starting at the given time.
+func (m *membershipCache) set(uuid string, member bool, started time.Time) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	if m.members == nil {
+		return
+	}
+	if t, ok := m.stale[uuid]; ok && !t.Before(started) {
+		return // invalidated again while the query was in flight
+	}
+	delete(m.stale, uuid)
+	if member {
+		m.members[uuid] = struct{}{}
+	} else {
+		delete(m.members, uuid)
+	}
+}
+
+func (m *membershipCache) invalidate(uuid string, now time.Time) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	if m.stale == nil {
+		m.stale = map[string]time.Time{}
+	}
+	m.stale[uuid] = now
+}
+
+// InvalidateMembership makes the next ExtendUser call for the user ask Keycloak for their group membership.
+// Call it when Keycloak notifies us that the user has changed.
+func (k *Keycloak[T]) InvalidateMembership(uuid string) {
+	k.members.invalidate(uuid, time.Now())
+}
+
+// isActiveMember returns true if the user is in the members group, consulting the cache when enabled.
+func (k *Keycloak[T]) isActiveMember(ctx context.Context, token *gocloak.JWT, uuid string) (bool, error) {
+	ttl := k.env.KeycloakMembershipCacheTTL
+	if ttl > 0 {
+		if err := k.refreshMembership(ctx, token); err != nil {
+			return false, fmt.Errorf("refreshing members group: %w", err)
+		}
+		if member, ok := k.members.lookup(uuid, ttl, time.Now()); ok {
+			return member, nil
+		}
+	}
+
+	start := time.Now()
+	groups, err := k.client.GetUserGroups(ctx, token.AccessToken, k.env.KeycloakRealm, uuid, gocloak.GetGroupsParams{
+		Max:    gocloak.IntP(1),
+		Search: gocloak.StringP("thelab-members"),
+	})
+	if err != nil {
+		if e, ok := err.(*gocloak.APIError); ok && e.Code == 404 {
+			return false, ErrNotFound
+		}
+		return false, fmt.Errorf("getting group membership: %w", err)
+	}
+	member := len(groups) > 0
+	if ttl > 0 {
+		k.members.set(uuid, member, start)
+	}
+	return member, nil
+}
+
+// refreshMembership re-enumerates the members group if the cached set has expired.
+func (k *Keycloak[T]) refreshMembership(ctx context.Context, token *gocloak.JWT) error {
+	ttl := k.env.KeycloakMembershipCacheTTL
+	if k.members.fresh(ttl, time.Now()) {
+		return nil
+	}
+
+	k.members.refreshLock.Lock()
+	defer k.members.refreshLock.Unlock()
+	if k.members.fresh(ttl, time.Now()) {
+		return nil // another caller refreshed it while we waited
+	}
+
+	start := time.Now()
+	members, err := k.listGroupMembers(ctx, token)
+	if err != nil {
+		return err
+	}
+	k.members.replace(members, start)
+	return nil
+}
+
+// listGroupMembers returns the IDs of every user in the members group.
+func (k *Keycloak[T]) listGroupMembers(ctx context.Context, token *gocloak.JWT) (map[string]struct{}, error) {
+	var (
+		max     = 150
+		first   = 0
+		members = map[string]struct{}{}
+	)
+	for {
+		params, err := gocloak.GetQueryParams(gocloak.GetUsersParams{
+			BriefRepresentation: gocloak.BoolP(true),
+			Max:                 &max,
+			First:               &first,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// Unfortunately the keycloak client doesn't support the group membership endpoint.
+		// We reuse the client's transport here while specifying our own URL.
+		var memberships []*gocloak.User
+		_, err = k.client.GetRequestWithBearerAuth(ctx, token.AccessToken).
+			SetResult(&memberships).
+			SetQueryParams(params).
+			Get(fmt.Sprintf("%s/admin/realms/%s/groups/%s/members", k.env.KeycloakURL, k.env.KeycloakRealm, k.env.KeycloakMembersGroupID))
+		if err != nil {
+			return nil, err
+		}
+		if len(memberships) == 0 {
+			return members, nil
+		}
+		first += len(memberships)
+
+		for _, member := range memberships {
+			members[gocloak.PString(member.ID)] = struct{}{}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package keycloak
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	requestFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "keycloak_request_failures_total",
+		Help: "Count of Keycloak requests that failed with a network error or server error, including ones that were retried",
+	}, []string{"reason"})
+
+	requestRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "keycloak_request_retries_total",
+		Help: "Count of Keycloak requests that were retried after a transient failure",
+	})
+)
+
+// retryTransport retries idempotent requests that fail transiently e.g. while Keycloak is restarting behind its proxy.
+// It wraps the breaker so every attempt counts towards tripping it, and stops retrying once it has tripped.
+type retryTransport struct {
+	attempts int
+	backoff  time.Duration
+	next     http.RoundTripper
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	delay := t.backoff
+	for attempt := 1; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		reason := failureReason(req, resp, err)
+		if reason == "" {
+			return resp, err
+		}
+		requestFailures.WithLabelValues(reason).Inc()
+		if attempt >= t.attempts || !retryable(req, resp, err) {
+			return resp, err
+		}
+
+		// The body has to be re-read for the next attempt
+		if req.Body != nil && req.Body != http.NoBody {
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return resp, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+		requestRetries.Inc()
+	}
+}
+
+// failureReason returns an empty string for responses that shouldn't be counted as failures.
+func failureReason(req *http.Request, resp *http.Response, err error) string {
+	switch {
+	case errors.Is(err, ErrUnavailable):
+		return "" // the breaker is open - already counted
+	case err != nil && req.Context().Err() != nil:
+		return "" // the caller gave up
+	case err != nil:
+		return "network"
+	case resp.StatusCode >= 500:
+		return "server_error"
+	default:
+		return ""
+	}
+}
+
+// retryable returns true for failures that are likely to be transient, on requests that are safe to repeat.
+func retryable(req *http.Request, resp *http.Response, err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+	default:
+		return false // e.g. creating users
+	}
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,108 @@
+package keycloak
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+const maxCachedUsers = 2048
+
+// userCache holds recently read users for GetUserCached.
+// Entries are dropped when we write the user or Keycloak tells us they changed (see InvalidateUser).
+//
+// The raw Keycloak representation is cached so every hit is mapped to a new user that callers are free to modify.
+type userCache struct {
+	mut   sync.Mutex
+	users map[string]*cachedUser // by ID
+	gen   uint64                 // incremented by every invalidation
+}
+
+type cachedUser struct {
+	kcuser  *gocloak.User
+	fetched time.Time
+}
+
+func (c *userCache) lookup(uuid string, ttl time.Duration, now time.Time) *gocloak.User {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	entry, ok := c.users[uuid]
+	if !ok || now.Sub(entry.fetched) >= ttl {
+		return nil
+	}
+	return entry.kcuser
+}
+
+// generation must be read before querying Keycloak, and passed to set once the query returns.
+func (c *userCache) generation() uint64 {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.gen
+}
+
+// set caches a user unless something was invalidated while the query was in flight, since that may have been them.
+func (c *userCache) set(kcuser *gocloak.User, gen uint64, ttl time.Duration, now time.Time) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+	if c.users == nil {
+		c.users = map[string]*cachedUser{}
+	}
+	if len(c.users) >= maxCachedUsers {
+		for uuid, entry := range c.users {
+			if now.Sub(entry.fetched) >= ttl {
+				delete(c.users, uuid)
+			}
+		}
+		if len(c.users) >= maxCachedUsers {
+			return
+		}
+	}
+
+	c.users[gocloak.PString(kcuser.ID)] = &cachedUser{kcuser: kcuser, fetched: now}
+}
+
+func (c *userCache) invalidate(uuid string) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.gen++
+	delete(c.users, uuid)
+}
+
+// InvalidateUser makes the next cached read of the user go to Keycloak.
+// Call it when Keycloak notifies us that the user has changed - our own writes invalidate automatically.
+func (k *Keycloak[T]) InvalidateUser(uuid string) {
+	k.users.invalidate(uuid)
+}
+
+// GetUserCached is like GetUser, but may return a copy of the user read up to KeycloakUserCacheTTL ago.
+// It's meant for rendering: changes should be based on GetUser so they don't overwrite newer values.
+// Nothing is cached without a webhook secret, since changes made outside of this process would go unnoticed.
+func (k *Keycloak[T]) GetUserCached(ctx context.Context, userID string) (T, error) {
+	ttl := k.env.KeycloakUserCacheTTL
+	if ttl <= 0 || k.env.KeycloakWebhookSecret == "" {
+		return k.GetUser(ctx, userID)
+	}
+	if kcuser := k.users.lookup(userID, ttl, time.Now()); kcuser != nil {
+		user := k.newUser()
+		mapToUserType(kcuser, user)
+		return user, nil
+	}
+
+	gen := k.users.generation()
+	kcuser, err := k.getUser(ctx, userID)
+	if err != nil {
+		return k.newUser(), err
+	}
+	k.users.set(kcuser, gen, ttl, time.Now())
+
+	user := k.newUser()
+	mapToUserType(kcuser, user)
+	return user, nil
+}
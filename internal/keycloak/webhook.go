@@ -2,11 +2,19 @@ package keycloak
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 )
 
+// SignatureHeader holds the hex encoded HMAC-SHA256 of the webhook body, keyed by the secret the webhook was
+// registered with.
+const SignatureHeader = "X-Keycloak-Signature"
+
 type webhookMsg struct {
 	ResourceType string `json:"resourceType"` // e.g. == "USER"
 	Details      struct {
@@ -14,14 +22,32 @@ type webhookMsg struct {
 	} `json:"details"`
 }
 
-func NewWebhookHandler(fn func(userID string) bool) http.Handler {
+// SignWebhook returns the signature Keycloak sends with the given webhook body.
+func SignWebhook(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewWebhookHandler accepts user change notifications signed with the secret. Everything is refused when the secret
+// isn't set.
+func NewWebhookHandler(secret string, fn func(userID string) bool) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		msg := &webhookMsg{}
-		err := json.NewDecoder(r.Body).Decode(msg)
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<16))
 		if err != nil {
 			w.WriteHeader(400)
 			return
 		}
+		if secret == "" || !hmac.Equal([]byte(r.Header.Get(SignatureHeader)), []byte(SignWebhook(body, secret))) {
+			w.WriteHeader(401)
+			return
+		}
+
+		msg := &webhookMsg{}
+		if err := json.Unmarshal(body, msg); err != nil {
+			w.WriteHeader(400)
+			return
+		}
 		if msg.ResourceType != "USER" || msg.Details.UserID == "" {
 			return
 		}
@@ -32,21 +58,28 @@ func NewWebhookHandler(fn func(userID string) bool) http.Handler {
 	})
 }
 
-func (k *Keycloak[T]) EnsureWebhook(ctx context.Context, callbackURL string) error {
+// EnsureWebhook registers the callback URL with Keycloak, or updates its secret if it was already registered.
+func (k *Keycloak[T]) EnsureWebhook(ctx context.Context, callbackURL, secret string) error {
 	hooks, err := k.listWebhooks(ctx)
 	if err != nil {
 		return fmt.Errorf("listing: %w", err)
 	}
 
 	for _, hook := range hooks {
-		if hook.URL == callbackURL {
+		if hook.URL != callbackURL {
+			continue
+		}
+		if hook.Secret == secret {
 			return nil // already exists
 		}
+		hook.Secret = secret
+		return k.updateWebhook(ctx, hook)
 	}
 
 	return k.createWebhook(ctx, &Webhook{
 		Enabled:    true,
 		URL:        callbackURL,
+		Secret:     secret,
 		EventTypes: []string{"admin.*"},
 	})
 }
@@ -84,9 +117,26 @@ func (k *Keycloak[T]) createWebhook(ctx context.Context, webhook *Webhook) error
 	return nil
 }
 
+func (k *Keycloak[T]) updateWebhook(ctx context.Context, webhook *Webhook) error {
+	token, err := k.GetToken(ctx)
+	if err != nil {
+		return fmt.Errorf("getting token: %w", err)
+	}
+
+	_, err = k.client.GetRequestWithBearerAuth(ctx, token.AccessToken).
+		SetBody(webhook).
+		Put(fmt.Sprintf("%s/realms/%s/webhooks/%s", k.env.KeycloakURL, k.env.KeycloakRealm, webhook.ID))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 type Webhook struct {
 	ID         string   `json:"id"`
 	Enabled    bool     `json:"enabled"`
 	URL        string   `json:"url"`
+	Secret     string   `json:"secret,omitempty"`
 	EventTypes []string `json:"eventTypes"`
 }
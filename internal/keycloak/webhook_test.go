@@ -0,0 +1,43 @@
+package keycloak
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookHandler(t *testing.T) {
+	var got []string
+	ok := true
+	h := NewWebhookHandler("secret", func(userID string) bool {
+		got = append(got, userID)
+		return ok
+	})
+
+	send := func(body, sig string) int {
+		r := httptest.NewRequest("POST", "/webhooks/keycloak", strings.NewReader(body))
+		r.Header.Set(SignatureHeader, sig)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	body := `{"resourceType": "USER", "details": {"userId": "user-1"}}`
+	assert.Equal(t, http.StatusOK, send(body, SignWebhook([]byte(body), "secret")))
+	assert.Equal(t, []string{"user-1"}, got)
+
+	assert.Equal(t, http.StatusUnauthorized, send(body, ""))
+	assert.Equal(t, http.StatusUnauthorized, send(body, SignWebhook([]byte(body), "wrong")))
+	assert.Equal(t, http.StatusOK, send(`{"resourceType": "GROUP"}`, SignWebhook([]byte(`{"resourceType": "GROUP"}`), "secret")))
+	assert.Len(t, got, 1)
+
+	ok = false
+	assert.Equal(t, http.StatusInternalServerError, send(body, SignWebhook([]byte(body), "secret")))
+
+	// Refuses everything without a secret
+	h = NewWebhookHandler("", func(string) bool { return true })
+	assert.Equal(t, http.StatusUnauthorized, send(body, SignWebhook([]byte(body), "")))
+}
@@ -0,0 +1,319 @@
+// Code generated by mappergen. DO NOT EDIT.
+
+package keycloak
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+)
+
+func init() {
+	registerMapper(mapToDatamodelUser, mapFromDatamodelUser)
+}
+
+func mapToDatamodelUser(kcuser *gocloak.User, user *datamodel.User) {
+	attrs := safeGetAttrs(kcuser)
+	if val := getChunkedAttr(attrs, "paypalMigrationMetadata"); val != "" {
+		if val != "null" { // nil maps etc. are stored as "null"
+			var v datamodel.PaypalMetadata
+			json.Unmarshal([]byte(val), &v)
+			user.PaypalMetadata = v
+		}
+	}
+	user.UUID = gocloak.PString(kcuser.ID)
+	user.CreationTime = *kcuser.CreatedTimestamp
+	user.Username = gocloak.PString(kcuser.Username)
+	user.First = gocloak.PString(kcuser.FirstName)
+	user.Last = gocloak.PString(kcuser.LastName)
+	user.Email = gocloak.PString(kcuser.Email)
+	user.EmailVerified = gocloak.PBool(kcuser.EmailVerified)
+	if val := getChunkedAttr(attrs, "keyfobID"); val != "" {
+		i, _ := strconv.ParseInt(val, 10, 0)
+		user.FobID = int(i)
+	}
+	if val := getChunkedAttr(attrs, "waiverState"); val != "" {
+		user.WaiverState = val
+	}
+	if val := getChunkedAttr(attrs, "nonBillable"); val != "" {
+		user.NonBillable, _ = strconv.ParseBool(val)
+	}
+	if val := getChunkedAttr(attrs, "discountType"); val != "" {
+		user.DiscountType = val
+	}
+	if val := getChunkedAttr(attrs, "membershipTier"); val != "" {
+		user.MembershipTier = val
+	}
+	if val := getChunkedAttr(attrs, "buildingAccessApprover"); val != "" {
+		user.BuildingAccessApprover = val
+	}
+	if val := getChunkedAttr(attrs, "signupEpochTimeUTC"); val != "" {
+		i, _ := strconv.ParseInt(val, 10, 0)
+		user.SignupTime = time.Unix(i, 0)
+	}
+	if val := getChunkedAttr(attrs, "lastSwipeTime"); val != "" {
+		i, _ := strconv.ParseInt(val, 10, 0)
+		user.LastSwipeTime = time.Unix(i, 0)
+	}
+	if val := getChunkedAttr(attrs, "discordUserID"); val != "" {
+		i, _ := strconv.ParseInt(val, 10, 0)
+		user.DiscordUserID = int64(i)
+	}
+	if val := getChunkedAttr(attrs, "previousDiscordUserID"); val != "" {
+		i, _ := strconv.ParseInt(val, 10, 0)
+		user.PreviousDiscordUserID = int64(i)
+	}
+	if val := getChunkedAttr(attrs, "discordIntroOptOut"); val != "" {
+		user.DiscordIntroOptOut, _ = strconv.ParseBool(val)
+	}
+	if val := getChunkedAttr(attrs, "discordIntroThreadID"); val != "" {
+		user.DiscordIntroThreadID = val
+	}
+	if val := getChunkedAttr(attrs, "signupEmailSentTime"); val != "" {
+		i, _ := strconv.ParseInt(val, 10, 0)
+		user.SignupEmailSentTime = time.Unix(i, 0)
+	}
+	if val := getChunkedAttr(attrs, "signupEmailResends"); val != "" {
+		i, _ := strconv.ParseInt(val, 10, 0)
+		user.SignupEmailResends = int(i)
+	}
+	if val := getChunkedAttr(attrs, "emailVerifiedTime"); val != "" {
+		i, _ := strconv.ParseInt(val, 10, 0)
+		user.EmailVerifiedTime = time.Unix(i, 0)
+	}
+	if val := getChunkedAttr(attrs, "emailBounceTime"); val != "" {
+		i, _ := strconv.ParseInt(val, 10, 0)
+		user.EmailBounceTime = time.Unix(i, 0)
+	}
+	if val := getChunkedAttr(attrs, "emailBounceReason"); val != "" {
+		user.EmailBounceReason = val
+	}
+	if val := getChunkedAttr(attrs, "discountExpiration"); val != "" {
+		i, _ := strconv.ParseInt(val, 10, 0)
+		user.DiscountExpiration = time.Unix(i, 0)
+	}
+	if val := getChunkedAttr(attrs, "discountNoticeTime"); val != "" {
+		i, _ := strconv.ParseInt(val, 10, 0)
+		user.DiscountNoticeTime = time.Unix(i, 0)
+	}
+	if val := getChunkedAttr(attrs, "stripePromotionCode"); val != "" {
+		user.StripePromotionCode = val
+	}
+	if val := getChunkedAttr(attrs, "welcomeSteps"); val != "" {
+		if val != "null" { // nil maps etc. are stored as "null"
+			var v map[string]time.Time
+			json.Unmarshal([]byte(val), &v)
+			user.WelcomeSteps = v
+		}
+	}
+	if val := getChunkedAttr(attrs, "paypalMigrationNotices"); val != "" {
+		i, _ := strconv.ParseInt(val, 10, 0)
+		user.PaypalMigrationNotices = int(i)
+	}
+	if val := getChunkedAttr(attrs, "paypalMigrationNoticeTime"); val != "" {
+		i, _ := strconv.ParseInt(val, 10, 0)
+		user.PaypalMigrationNoticeTime = time.Unix(i, 0)
+	}
+	if val := getChunkedAttr(attrs, "stripeID"); val != "" {
+		user.StripeCustomerID = val
+	}
+	if val := getChunkedAttr(attrs, "stripeSubscriptionID"); val != "" {
+		user.StripeSubscriptionID = val
+	}
+	if val := getChunkedAttr(attrs, "stripeCancelationTime"); val != "" {
+		i, _ := strconv.ParseInt(val, 10, 0)
+		user.StripeCancelationTime = time.Unix(i, 0)
+	}
+	if val := getChunkedAttr(attrs, "stripeSubscriptionStatus"); val != "" {
+		user.StripeSubscriptionStatus = val
+	}
+	if val := getChunkedAttr(attrs, "stripeGracePeriodEnd"); val != "" {
+		i, _ := strconv.ParseInt(val, 10, 0)
+		user.StripeGracePeriodEnd = time.Unix(i, 0)
+	}
+	if val := getChunkedAttr(attrs, "lockerNumber"); val != "" {
+		user.LockerNumber = val
+	}
+	if val := getChunkedAttr(attrs, "stripeLockerItemID"); val != "" {
+		user.StripeLockerItemID = val
+	}
+	if val := getChunkedAttr(attrs, "doorPINHash"); val != "" {
+		user.DoorPINHash = val
+	}
+	if val := getChunkedAttr(attrs, "doorPINSetTime"); val != "" {
+		i, _ := strconv.ParseInt(val, 10, 0)
+		user.DoorPINSetTime = time.Unix(i, 0)
+	}
+	if val := getChunkedAttr(attrs, "doorPINReminderTime"); val != "" {
+		i, _ := strconv.ParseInt(val, 10, 0)
+		user.DoorPINReminderTime = time.Unix(i, 0)
+	}
+	if val := getChunkedAttr(attrs, "banned"); val != "" {
+		user.Banned, _ = strconv.ParseBool(val)
+	}
+	if val := getChunkedAttr(attrs, "banReason"); val != "" {
+		user.BanReason = val
+	}
+	if val := getChunkedAttr(attrs, "banTime"); val != "" {
+		i, _ := strconv.ParseInt(val, 10, 0)
+		user.BanTime = time.Unix(i, 0)
+	}
+	if val := getChunkedAttr(attrs, "certifications"); val != "" {
+		if val != "null" { // nil maps etc. are stored as "null"
+			var v []string
+			json.Unmarshal([]byte(val), &v)
+			user.Certifications = v
+		}
+	}
+	if val := getChunkedAttr(attrs, "emergencyContactName"); val != "" {
+		user.EmergencyContactName = val
+	}
+	if val := getChunkedAttr(attrs, "emergencyContactPhone"); val != "" {
+		user.EmergencyContactPhone = val
+	}
+	if val := getChunkedAttr(attrs, "notificationOptOuts"); val != "" {
+		if val != "null" { // nil maps etc. are stored as "null"
+			var v map[string]bool
+			json.Unmarshal([]byte(val), &v)
+			user.NotificationOptOuts = v
+		}
+	}
+	if val := getChunkedAttr(attrs, "newsletterOptIn"); val != "" {
+		user.NewsletterOptIn, _ = strconv.ParseBool(val)
+	}
+	if val := getChunkedAttr(attrs, "newsletterStatus"); val != "" {
+		user.NewsletterStatus = val
+	}
+}
+
+func mapFromDatamodelUser(kcuser *gocloak.User, user *datamodel.User) {
+	attrs := safeGetAttrs(kcuser)
+	var raw []byte
+	raw, _ = json.Marshal(user.PaypalMetadata)
+	setChunkedAttr(attrs, "paypalMigrationMetadata", string(raw))
+	kcuser.ID = gocloak.StringP(user.UUID)
+	kcuser.Username = gocloak.StringP(user.Username)
+	kcuser.FirstName = gocloak.StringP(user.First)
+	kcuser.LastName = gocloak.StringP(user.Last)
+	kcuser.Email = gocloak.StringP(user.Email)
+	kcuser.EmailVerified = gocloak.BoolP(user.EmailVerified)
+	if user.FobID != 0 {
+		attrs["keyfobID"] = []string{strconv.Itoa(user.FobID)}
+	}
+	if user.WaiverState != "" {
+		attrs["waiverState"] = []string{user.WaiverState}
+	}
+	attrs["nonBillable"] = []string{strconv.FormatBool(user.NonBillable)}
+	if user.DiscountType != "" {
+		attrs["discountType"] = []string{user.DiscountType}
+	}
+	if user.MembershipTier != "" {
+		attrs["membershipTier"] = []string{user.MembershipTier}
+	}
+	if user.BuildingAccessApprover != "" {
+		attrs["buildingAccessApprover"] = []string{user.BuildingAccessApprover}
+	}
+	if user.SignupTime != (time.Time{}) {
+		attrs["signupEpochTimeUTC"] = []string{strconv.FormatInt(user.SignupTime.Unix(), 10)}
+	}
+	if user.LastSwipeTime != (time.Time{}) {
+		attrs["lastSwipeTime"] = []string{strconv.FormatInt(user.LastSwipeTime.Unix(), 10)}
+	}
+	if user.DiscordUserID != 0 {
+		attrs["discordUserID"] = []string{strconv.FormatInt(user.DiscordUserID, 10)}
+	}
+	if user.PreviousDiscordUserID != 0 {
+		attrs["previousDiscordUserID"] = []string{strconv.FormatInt(user.PreviousDiscordUserID, 10)}
+	}
+	attrs["discordIntroOptOut"] = []string{strconv.FormatBool(user.DiscordIntroOptOut)}
+	if user.DiscordIntroThreadID != "" {
+		attrs["discordIntroThreadID"] = []string{user.DiscordIntroThreadID}
+	}
+	if user.SignupEmailSentTime != (time.Time{}) {
+		attrs["signupEmailSentTime"] = []string{strconv.FormatInt(user.SignupEmailSentTime.Unix(), 10)}
+	}
+	if user.SignupEmailResends != 0 {
+		attrs["signupEmailResends"] = []string{strconv.Itoa(user.SignupEmailResends)}
+	}
+	if user.EmailVerifiedTime != (time.Time{}) {
+		attrs["emailVerifiedTime"] = []string{strconv.FormatInt(user.EmailVerifiedTime.Unix(), 10)}
+	}
+	if user.EmailBounceTime != (time.Time{}) {
+		attrs["emailBounceTime"] = []string{strconv.FormatInt(user.EmailBounceTime.Unix(), 10)}
+	}
+	if user.EmailBounceReason != "" {
+		attrs["emailBounceReason"] = []string{user.EmailBounceReason}
+	}
+	if user.DiscountExpiration != (time.Time{}) {
+		attrs["discountExpiration"] = []string{strconv.FormatInt(user.DiscountExpiration.Unix(), 10)}
+	}
+	if user.DiscountNoticeTime != (time.Time{}) {
+		attrs["discountNoticeTime"] = []string{strconv.FormatInt(user.DiscountNoticeTime.Unix(), 10)}
+	}
+	if user.StripePromotionCode != "" {
+		attrs["stripePromotionCode"] = []string{user.StripePromotionCode}
+	}
+	raw, _ = json.Marshal(user.WelcomeSteps)
+	setChunkedAttr(attrs, "welcomeSteps", string(raw))
+	if user.PaypalMigrationNotices != 0 {
+		attrs["paypalMigrationNotices"] = []string{strconv.Itoa(user.PaypalMigrationNotices)}
+	}
+	if user.PaypalMigrationNoticeTime != (time.Time{}) {
+		attrs["paypalMigrationNoticeTime"] = []string{strconv.FormatInt(user.PaypalMigrationNoticeTime.Unix(), 10)}
+	}
+	if user.StripeCustomerID != "" {
+		attrs["stripeID"] = []string{user.StripeCustomerID}
+	}
+	if user.StripeSubscriptionID != "" {
+		attrs["stripeSubscriptionID"] = []string{user.StripeSubscriptionID}
+	}
+	if user.StripeCancelationTime != (time.Time{}) {
+		attrs["stripeCancelationTime"] = []string{strconv.FormatInt(user.StripeCancelationTime.Unix(), 10)}
+	}
+	if user.StripeSubscriptionStatus != "" {
+		attrs["stripeSubscriptionStatus"] = []string{user.StripeSubscriptionStatus}
+	}
+	if user.StripeGracePeriodEnd != (time.Time{}) {
+		attrs["stripeGracePeriodEnd"] = []string{strconv.FormatInt(user.StripeGracePeriodEnd.Unix(), 10)}
+	}
+	if user.LockerNumber != "" {
+		attrs["lockerNumber"] = []string{user.LockerNumber}
+	}
+	if user.StripeLockerItemID != "" {
+		attrs["stripeLockerItemID"] = []string{user.StripeLockerItemID}
+	}
+	if user.DoorPINHash != "" {
+		attrs["doorPINHash"] = []string{user.DoorPINHash}
+	}
+	if user.DoorPINSetTime != (time.Time{}) {
+		attrs["doorPINSetTime"] = []string{strconv.FormatInt(user.DoorPINSetTime.Unix(), 10)}
+	}
+	if user.DoorPINReminderTime != (time.Time{}) {
+		attrs["doorPINReminderTime"] = []string{strconv.FormatInt(user.DoorPINReminderTime.Unix(), 10)}
+	}
+	attrs["banned"] = []string{strconv.FormatBool(user.Banned)}
+	if user.BanReason != "" {
+		attrs["banReason"] = []string{user.BanReason}
+	}
+	if user.BanTime != (time.Time{}) {
+		attrs["banTime"] = []string{strconv.FormatInt(user.BanTime.Unix(), 10)}
+	}
+	raw, _ = json.Marshal(user.Certifications)
+	setChunkedAttr(attrs, "certifications", string(raw))
+	if user.EmergencyContactName != "" {
+		attrs["emergencyContactName"] = []string{user.EmergencyContactName}
+	}
+	if user.EmergencyContactPhone != "" {
+		attrs["emergencyContactPhone"] = []string{user.EmergencyContactPhone}
+	}
+	raw, _ = json.Marshal(user.NotificationOptOuts)
+	setChunkedAttr(attrs, "notificationOptOuts", string(raw))
+	attrs["newsletterOptIn"] = []string{strconv.FormatBool(user.NewsletterOptIn)}
+	if user.NewsletterStatus != "" {
+		attrs["newsletterStatus"] = []string{user.NewsletterStatus}
+	}
+}
@@ -0,0 +1,139 @@
+// Package newsletter syncs members' newsletter subscriptions to a Mailchimp audience.
+//
+// Subscriptions are double opt-in: new subscribers are added as pending, and Mailchimp emails them to confirm.
+// Confirmations and unsubscribes made through Mailchimp are reported back through the webhook.
+package newsletter
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Subscriber statuses, as Mailchimp names them.
+const (
+	StatusPending      = "pending" // waiting for the subscriber to confirm the opt-in email
+	StatusSubscribed   = "subscribed"
+	StatusUnsubscribed = "unsubscribed"
+	StatusCleaned      = "cleaned" // the address bounced
+)
+
+// Client manages subscribers of a single audience (list).
+type Client struct {
+	URL, APIKey, ListID string
+	HTTP                *http.Client
+}
+
+// NewClient returns a client for the datacenter in the API key e.g. "...-us1".
+func NewClient(apiKey, listID string) *Client {
+	_, dc, _ := strings.Cut(apiKey, "-")
+	return &Client{
+		URL:    fmt.Sprintf("https://%s.api.mailchimp.com/3.0", dc),
+		APIKey: apiKey,
+		ListID: listID,
+		HTTP:   http.DefaultClient,
+	}
+}
+
+type member struct {
+	EmailAddress string `json:"email_address,omitempty"`
+	Status       string `json:"status"`
+	StatusIfNew  string `json:"status_if_new,omitempty"`
+}
+
+// Subscribe adds the address as a pending subscriber, which sends the opt-in email, and returns its status.
+// Addresses that are already subscribed (or pending) are left alone so they aren't asked to confirm again.
+func (c *Client) Subscribe(ctx context.Context, email string) (string, error) {
+	current, err := c.getMember(ctx, email)
+	if err != nil {
+		return "", fmt.Errorf("getting subscriber: %w", err)
+	}
+	if current != nil && (current.Status == StatusSubscribed || current.Status == StatusPending) {
+		return current.Status, nil
+	}
+
+	m := &member{EmailAddress: email, Status: StatusPending, StatusIfNew: StatusPending}
+	if err := c.do(ctx, "PUT", c.memberURL(email), m, nil); err != nil {
+		return "", fmt.Errorf("adding subscriber: %w", err)
+	}
+	return StatusPending, nil
+}
+
+// Unsubscribe stops sending the newsletter to the address. Addresses that were never subscribed are ignored.
+func (c *Client) Unsubscribe(ctx context.Context, email string) error {
+	current, err := c.getMember(ctx, email)
+	if err != nil {
+		return fmt.Errorf("getting subscriber: %w", err)
+	}
+	if current == nil || current.Status == StatusUnsubscribed || current.Status == StatusCleaned {
+		return nil
+	}
+	if err := c.do(ctx, "PATCH", c.memberURL(email), &member{Status: StatusUnsubscribed}, nil); err != nil {
+		return fmt.Errorf("unsubscribing: %w", err)
+	}
+	return nil
+}
+
+// getMember returns nil if the address isn't in the audience.
+func (c *Client) getMember(ctx context.Context, email string) (*member, error) {
+	m := &member{}
+	err := c.do(ctx, "GET", c.memberURL(email), nil, m)
+	if errors.Is(err, errNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// memberURL identifies members by the hash of their lowercased address.
+func (c *Client) memberURL(email string) string {
+	hash := md5.Sum([]byte(strings.ToLower(email)))
+	return fmt.Sprintf("%s/lists/%s/members/%s", c.URL, c.ListID, hex.EncodeToString(hash[:]))
+}
+
+var errNotFound = errors.New("not found")
+
+func (c *Client) do(ctx context.Context, method, url string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		js, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(js)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth("profile", c.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return errNotFound
+	}
+	if resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, msg)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,84 @@
+package newsletter
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeMailchimp holds subscriber statuses by member URL path.
+type fakeMailchimp struct {
+	mut      sync.Mutex
+	statuses map[string]string
+	writes   []string // method and status of each change
+}
+
+func (f *fakeMailchimp) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	if _, key, _ := r.BasicAuth(); key != "key-us1" {
+		w.WriteHeader(401)
+		return
+	}
+	if r.Method == "GET" {
+		status, ok := f.statuses[r.URL.Path]
+		if !ok {
+			w.WriteHeader(404)
+			return
+		}
+		json.NewEncoder(w).Encode(&member{Status: status})
+		return
+	}
+
+	m := &member{}
+	json.NewDecoder(r.Body).Decode(m)
+	f.statuses[r.URL.Path] = m.Status
+	f.writes = append(f.writes, r.Method+" "+m.Status)
+}
+
+func TestSubscribe(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeMailchimp{statuses: map[string]string{}}
+	svr := httptest.NewServer(fake)
+	t.Cleanup(svr.Close)
+
+	c := NewClient("key-us1", "list")
+	assert.Equal(t, "https://us1.api.mailchimp.com/3.0", c.URL)
+	c.URL = svr.URL
+
+	// New subscribers are pending until they confirm
+	status, err := c.Subscribe(ctx, "Member@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, StatusPending, status)
+	assert.Equal(t, []string{"PUT pending"}, fake.writes)
+
+	// Addresses are case insensitive, and existing subscribers aren't asked to confirm again
+	fake.statuses[strings.TrimPrefix(c.memberURL("member@example.com"), svr.URL)] = StatusSubscribed
+	status, err = c.Subscribe(ctx, "member@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, StatusSubscribed, status)
+	assert.Len(t, fake.writes, 1)
+
+	require.NoError(t, c.Unsubscribe(ctx, "member@example.com"))
+	require.NoError(t, c.Unsubscribe(ctx, "member@example.com"))
+	require.NoError(t, c.Unsubscribe(ctx, "someone-else@example.com"))
+	assert.Equal(t, []string{"PUT pending", "PATCH unsubscribed"}, fake.writes)
+
+	// Resubscribing starts the double opt-in again
+	status, err = c.Subscribe(ctx, "member@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, StatusPending, status)
+	assert.Equal(t, []string{"PUT pending", "PATCH unsubscribed", "PUT pending"}, fake.writes)
+
+	c.APIKey = "wrong-us1"
+	_, err = c.Subscribe(ctx, "member@example.com")
+	assert.Error(t, err)
+}
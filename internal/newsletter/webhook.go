@@ -0,0 +1,55 @@
+package newsletter
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// Kinds of webhook events sent by Mailchimp that affect a member's subscription.
+const (
+	EventSubscribe   = "subscribe" // includes confirming the opt-in email
+	EventUnsubscribe = "unsubscribe"
+	EventCleaned     = "cleaned"
+)
+
+// Event is a change to a subscriber reported by Mailchimp.
+type Event struct {
+	Type  string
+	Email string
+}
+
+// NewWebhookHandler accepts subscriber changes from Mailchimp. Mailchimp doesn't sign webhooks, so the secret is
+// expected in the webhook URL's ?secret= param. fn should return false if the event needs to be retried.
+func NewWebhookHandler(secret string, fn func(context.Context, *Event) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if secret == "" || subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("secret")), []byte(secret)) != 1 {
+			w.WriteHeader(401)
+			return
+		}
+		if r.Method == http.MethodGet {
+			return // Mailchimp checks that the URL works before saving it
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<16)
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(400)
+			return
+		}
+		event := &Event{Type: r.PostForm.Get("type"), Email: strings.ToLower(r.PostForm.Get("data[email]"))}
+		switch event.Type {
+		case EventSubscribe, EventUnsubscribe, EventCleaned:
+		default:
+			return // e.g. profile updates and campaigns
+		}
+		if event.Email == "" {
+			w.WriteHeader(400)
+			return
+		}
+		if !fn(r.Context(), event) {
+			w.WriteHeader(500)
+			return
+		}
+	})
+}
@@ -0,0 +1,43 @@
+package newsletter
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhook(t *testing.T) {
+	var events []*Event
+	ok := true
+	handler := NewWebhookHandler("secret", func(ctx context.Context, e *Event) bool {
+		events = append(events, e)
+		return ok
+	})
+	send := func(method, secret string, form url.Values) int {
+		r := httptest.NewRequest(method, "/webhooks/newsletter?secret="+secret, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, 200, send("GET", "secret", nil))
+	assert.Equal(t, 401, send("GET", "wrong", nil))
+	assert.Equal(t, 401, send("POST", "", url.Values{"type": {"unsubscribe"}, "data[email]": {"member@example.com"}}))
+	assert.Empty(t, events)
+
+	assert.Equal(t, 200, send("POST", "secret", url.Values{"type": {"unsubscribe"}, "data[email]": {"Member@example.com"}}))
+	assert.Equal(t, []*Event{{Type: EventUnsubscribe, Email: "member@example.com"}}, events)
+
+	// Irrelevant events are ignored
+	assert.Equal(t, 200, send("POST", "secret", url.Values{"type": {"campaign"}}))
+	assert.Equal(t, 400, send("POST", "secret", url.Values{"type": {"subscribe"}}))
+	assert.Len(t, events, 1)
+
+	ok = false
+	assert.Equal(t, 500, send("POST", "secret", url.Values{"type": {"cleaned"}, "data[email]": {"member@example.com"}}))
+}
@@ -0,0 +1,123 @@
+// Package notify delivers notifications to members over whichever channel can reach them.
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/emailtmpl"
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+// Reasons for notifying members. Each one has an email template of the same name (see internal/emailtmpl).
+const (
+	ReasonAccessRevoked     = "accessRevoked"
+	ReasonPaymentFailed     = "paymentFailed"
+	ReasonDiscountExpiring  = "discountExpiring"
+	ReasonDiscountExpired   = "discountExpired"
+	ReasonFobAssigned       = "fobAssigned"
+	ReasonPaypalMigration   = "paypalMigration"
+	ReasonEventReminder     = "eventReminder"
+	ReasonDoorPINRotation   = "doorPINRotation"
+	ReasonGuestConfirmation = "guestConfirmation"
+)
+
+// ErrUndeliverable is returned (possibly joined with the errors from each channel) when none of a reason's
+// channels could reach the member.
+var ErrUndeliverable = errors.New("no channel could deliver the notification")
+
+// Route determines how notifications for a reason are delivered.
+type Route struct {
+	Category string   // members can opt out of non-empty categories (see datamodel.NotificationCategories)
+	Channels []string // tried in order until one can reach the member
+}
+
+// DefaultRoutes prefers Discord, since members read it more often than email.
+var DefaultRoutes = map[string]*Route{
+	ReasonAccessRevoked:     {Channels: []string{datamodel.ChannelDiscord, datamodel.ChannelEmail}},
+	ReasonPaymentFailed:     {Category: datamodel.NotifyPayment, Channels: []string{datamodel.ChannelDiscord, datamodel.ChannelEmail}},
+	ReasonDiscountExpiring:  {Category: datamodel.NotifyPayment, Channels: []string{datamodel.ChannelDiscord, datamodel.ChannelEmail}},
+	ReasonDiscountExpired:   {Category: datamodel.NotifyPayment, Channels: []string{datamodel.ChannelDiscord, datamodel.ChannelEmail}},
+	ReasonFobAssigned:       {Channels: []string{datamodel.ChannelDiscord, datamodel.ChannelEmail}},
+	ReasonPaypalMigration:   {Channels: []string{datamodel.ChannelEmail, datamodel.ChannelDiscord}},           // email is easier to act on later
+	ReasonEventReminder:     {Category: datamodel.NotifyEvents, Channels: []string{datamodel.ChannelDiscord}}, // RSVPs happen on Discord
+	ReasonDoorPINRotation:   {Channels: []string{datamodel.ChannelEmail, datamodel.ChannelDiscord}},
+	ReasonGuestConfirmation: {Channels: []string{datamodel.ChannelDiscord, datamodel.ChannelEmail}}, // the host is probably nearby
+}
+
+// DiscordSender is implemented by chatbot.Bot.
+type DiscordSender interface {
+	Enabled() bool
+	SendDM(ctx context.Context, userID int64, msg string) error
+}
+
+// EmailSender is implemented by email.Sender.
+type EmailSender interface {
+	Enabled() bool
+	SendTemplate(ctx context.Context, to, name string, data any) error
+}
+
+type Notifier struct {
+	Discord DiscordSender
+	Email   EmailSender
+	Routes  map[string]*Route
+}
+
+func New(discord DiscordSender, email EmailSender) *Notifier {
+	return &Notifier{Discord: discord, Email: email, Routes: DefaultRoutes}
+}
+
+// Notify sends the notification over the first channel in the reason's route that can reach the member,
+// returning the channel that was used. data is passed to the reason's email template, which also renders the
+// text of Discord messages.
+func (n *Notifier) Notify(ctx context.Context, user *datamodel.User, reason string, data any) (string, error) {
+	if n == nil {
+		return "", nil
+	}
+	route, ok := n.Routes[reason]
+	if !ok {
+		return "", fmt.Errorf("no route for notification %q", reason)
+	}
+
+	errs := []error{ErrUndeliverable}
+	for _, channel := range route.Channels {
+		if route.Category != "" && !user.WantsNotification(route.Category, channel) {
+			continue
+		}
+
+		var err error
+		switch channel {
+		case datamodel.ChannelDiscord:
+			if user.DiscordUserID == 0 || n.Discord == nil || !n.Discord.Enabled() {
+				continue
+			}
+			err = n.sendDiscord(ctx, user, reason, data)
+		case datamodel.ChannelEmail:
+			if user.Email == "" || n.Email == nil || !n.Email.Enabled() {
+				continue
+			}
+			err = n.Email.SendTemplate(ctx, user.Email, reason, data)
+		default:
+			continue
+		}
+		if err != nil {
+			// Fall through to the next channel e.g. when the member doesn't accept DMs
+			errs = append(errs, fmt.Errorf("sending %s notification over %s: %w", reason, channel, err))
+			continue
+		}
+
+		reporting.DefaultSink.Eventf(user.Email, "NotificationSent", "sent %s notification over %s", reason, channel)
+		return channel, nil
+	}
+	return "", errors.Join(errs...)
+}
+
+func (n *Notifier) sendDiscord(ctx context.Context, user *datamodel.User, reason string, data any) error {
+	msg, err := emailtmpl.RenderText(reason, data)
+	if err != nil {
+		return err
+	}
+	return n.Discord.SendDM(ctx, user.DiscordUserID, msg)
+}
@@ -0,0 +1,84 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/emailtmpl"
+)
+
+func TestNotify(t *testing.T) {
+	ctx := context.Background()
+	discord := &fakeSender{enabled: true}
+	email := &fakeSender{enabled: true}
+	n := New(discord, email)
+	data := &emailtmpl.PaymentFailed{URL: "https://example.com/profile"}
+
+	// Discord is preferred
+	user := &datamodel.User{Email: "member@example.com", DiscordUserID: 123}
+	channel, err := n.Notify(ctx, user, ReasonPaymentFailed, data)
+	require.NoError(t, err)
+	assert.Equal(t, datamodel.ChannelDiscord, channel)
+	assert.Len(t, discord.sent, 1)
+
+	// Fall back to email for members without Discord
+	channel, err = n.Notify(ctx, &datamodel.User{Email: "member@example.com"}, ReasonPaymentFailed, data)
+	require.NoError(t, err)
+	assert.Equal(t, datamodel.ChannelEmail, channel)
+	assert.Equal(t, []string{"paymentFailed"}, email.sent)
+
+	// ...and when Discord fails
+	discord.err = errors.New("dms disabled")
+	channel, err = n.Notify(ctx, user, ReasonPaymentFailed, data)
+	require.NoError(t, err)
+	assert.Equal(t, datamodel.ChannelEmail, channel)
+	discord.err = nil
+
+	// Opt outs are respected
+	user.NotificationOptOuts = map[string]bool{datamodel.NotificationKey(datamodel.NotifyPayment, datamodel.ChannelDiscord): true}
+	channel, err = n.Notify(ctx, user, ReasonPaymentFailed, data)
+	require.NoError(t, err)
+	assert.Equal(t, datamodel.ChannelEmail, channel)
+
+	// Access revocations can't be opted out of
+	channel, err = n.Notify(ctx, user, ReasonAccessRevoked, &emailtmpl.AccessRevoked{Reason: "testing"})
+	require.NoError(t, err)
+	assert.Equal(t, datamodel.ChannelDiscord, channel)
+
+	// Nothing can reach the member
+	email.enabled = false
+	_, err = n.Notify(ctx, &datamodel.User{Email: "member@example.com"}, ReasonPaymentFailed, data)
+	assert.ErrorIs(t, err, ErrUndeliverable)
+
+	_, err = n.Notify(ctx, user, "nope", nil)
+	assert.Error(t, err)
+}
+
+type fakeSender struct {
+	enabled bool
+	err     error
+	sent    []string
+}
+
+func (f *fakeSender) Enabled() bool { return f.enabled }
+
+func (f *fakeSender) SendDM(ctx context.Context, userID int64, msg string) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.sent = append(f.sent, msg)
+	return nil
+}
+
+func (f *fakeSender) SendTemplate(ctx context.Context, to, name string, data any) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.sent = append(f.sent, name)
+	return nil
+}
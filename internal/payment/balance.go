@@ -0,0 +1,73 @@
+package payment
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/stripe/stripe-go/v78"
+	"github.com/stripe/stripe-go/v78/customer"
+)
+
+// BalanceCache fetches Stripe customer balances on demand and holds them for a short time.
+// Balances change rarely (proration, manual credits) so this mostly avoids repeated lookups on page reloads.
+type BalanceCache struct {
+	ttl   time.Duration
+	fetch func(ctx context.Context, customerID string) (int64, error)
+
+	mut   sync.Mutex
+	items map[string]*balanceItem
+}
+
+type balanceItem struct {
+	Balance int64
+	Expires time.Time
+}
+
+func NewBalanceCache(ttl time.Duration) *BalanceCache {
+	return &BalanceCache{ttl: ttl, fetch: getStripeBalance, items: map[string]*balanceItem{}}
+}
+
+// NewStaticBalanceCache returns a cache that reports the given balances (in cents) without calling Stripe.
+// Useful for local development without Stripe credentials.
+func NewStaticBalanceCache(balances map[string]int64) *BalanceCache {
+	return &BalanceCache{fetch: func(ctx context.Context, customerID string) (int64, error) {
+		return balances[customerID], nil
+	}, items: map[string]*balanceItem{}}
+}
+
+// Get returns the customer's balance in cents. Negative balances are credits that will be applied to the next invoice.
+func (b *BalanceCache) Get(ctx context.Context, customerID string) (int64, error) {
+	now := time.Now()
+	b.mut.Lock()
+	item, ok := b.items[customerID]
+	b.mut.Unlock()
+	if ok && now.Before(item.Expires) {
+		return item.Balance, nil
+	}
+
+	balance, err := b.fetch(ctx, customerID)
+	if err != nil {
+		return 0, err
+	}
+
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	for id, item := range b.items {
+		if now.After(item.Expires) {
+			delete(b.items, id) // keep the map from growing forever
+		}
+	}
+	b.items[customerID] = &balanceItem{Balance: balance, Expires: now.Add(b.ttl)}
+	return balance, nil
+}
+
+func getStripeBalance(ctx context.Context, customerID string) (int64, error) {
+	params := &stripe.CustomerParams{}
+	params.Context = ctx
+	cust, err := customer.Get(customerID, params)
+	if err != nil {
+		return 0, err
+	}
+	return cust.Balance, nil
+}
@@ -0,0 +1,43 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBalanceCache(t *testing.T) {
+	ctx := context.Background()
+	var calls int
+	var fetchErr error
+	b := NewBalanceCache(time.Hour)
+	b.fetch = func(ctx context.Context, customerID string) (int64, error) {
+		calls++
+		return -500, fetchErr
+	}
+
+	balance, err := b.Get(ctx, "cus_foo")
+	require.NoError(t, err)
+	assert.Equal(t, int64(-500), balance)
+
+	// Cached
+	_, err = b.Get(ctx, "cus_foo")
+	require.NoError(t, err)
+	assert.Equal(t, 1, calls)
+
+	// Expired
+	b.items["cus_foo"].Expires = time.Now().Add(-time.Second)
+	_, err = b.Get(ctx, "cus_foo")
+	require.NoError(t, err)
+	assert.Equal(t, 2, calls)
+
+	// Errors aren't cached
+	fetchErr = errors.New("stripe is down")
+	_, err = b.Get(ctx, "cus_bar")
+	assert.Error(t, err)
+	assert.NotContains(t, b.items, "cus_bar")
+}
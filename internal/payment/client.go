@@ -0,0 +1,38 @@
+package payment
+
+import (
+	"context"
+
+	"github.com/stripe/stripe-go/v78"
+	"github.com/stripe/stripe-go/v78/customer"
+	"github.com/stripe/stripe-go/v78/subscription"
+)
+
+// StripeClient is the subset of the Stripe API needed to process subscription webhooks.
+// It exists so the webhook handler can be tested without a Stripe account.
+type StripeClient interface {
+	GetSubscription(ctx context.Context, id string) (*stripe.Subscription, error)
+	GetCustomer(ctx context.Context, id string) (*stripe.Customer, error)
+	RemoveLockerItem(ctx context.Context, itemID string) error
+}
+
+// NewStripeClient returns a StripeClient backed by the global Stripe API key.
+func NewStripeClient() StripeClient { return &apiClient{} }
+
+type apiClient struct{}
+
+func (*apiClient) GetSubscription(ctx context.Context, id string) (*stripe.Subscription, error) {
+	params := &stripe.SubscriptionParams{}
+	params.Context = ctx
+	return subscription.Get(id, params)
+}
+
+func (*apiClient) GetCustomer(ctx context.Context, id string) (*stripe.Customer, error) {
+	params := &stripe.CustomerParams{}
+	params.Context = ctx
+	return customer.Get(id, params)
+}
+
+func (*apiClient) RemoveLockerItem(ctx context.Context, itemID string) error {
+	return RemoveLockerItem(ctx, itemID)
+}
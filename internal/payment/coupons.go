@@ -0,0 +1,136 @@
+package payment
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/stripe/stripe-go/v78"
+	"github.com/stripe/stripe-go/v78/coupon"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+)
+
+// Coupon is a Stripe coupon in the shape expected by the PriceCache i.e. one fixed discount for a single price,
+// applied to members with any of the given discount types.
+type Coupon struct {
+	ID            string
+	Name          string
+	PriceID       string
+	DiscountTypes []string
+	AmountOff     int64 // cents
+}
+
+// ListCoupons returns every coupon that has the metadata used to match coupons to prices.
+func ListCoupons(ctx context.Context) ([]*Coupon, error) {
+	params := &stripe.CouponListParams{}
+	params.Context = ctx
+	iter := coupon.List(params)
+
+	coupons := []*Coupon{}
+	for iter.Next() {
+		c := iter.Coupon()
+		if c.Metadata == nil || c.Metadata["priceID"] == "" {
+			continue
+		}
+		coupons = append(coupons, &Coupon{
+			ID:            c.ID,
+			Name:          c.Name,
+			PriceID:       c.Metadata["priceID"],
+			DiscountTypes: splitDiscountTypes(c.Metadata["discountTypes"]),
+			AmountOff:     c.AmountOff,
+		})
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(coupons, func(i, j int) bool { return coupons[i].Name < coupons[j].Name })
+	return coupons, nil
+}
+
+// CreateCoupon creates a new coupon in Stripe. The coupon's ID is set on success.
+func CreateCoupon(ctx context.Context, c *Coupon) error {
+	params := &stripe.CouponParams{
+		Name:      stripe.String(c.Name),
+		AmountOff: stripe.Int64(c.AmountOff),
+		Currency:  stripe.String(string(stripe.CurrencyUSD)),
+		Duration:  stripe.String(string(stripe.CouponDurationForever)),
+		Metadata:  map[string]string{"priceID": c.PriceID, "discountTypes": strings.Join(c.DiscountTypes, ",")},
+	}
+	params.Context = ctx
+	created, err := coupon.New(params)
+	if err != nil {
+		return err
+	}
+	c.ID = created.ID
+	return nil
+}
+
+// UpdateCoupon updates the coupon's name and discount types.
+// Stripe doesn't allow the amount of an existing coupon to change, so that requires a new coupon.
+func UpdateCoupon(ctx context.Context, c *Coupon) error {
+	params := &stripe.CouponParams{
+		Name:     stripe.String(c.Name),
+		Metadata: map[string]string{"discountTypes": strings.Join(c.DiscountTypes, ",")},
+	}
+	params.Context = ctx
+	_, err := coupon.Update(c.ID, params)
+	return err
+}
+
+// DeleteCoupon deletes the coupon. Existing subscriptions keep their discount.
+func DeleteCoupon(ctx context.Context, id string) error {
+	params := &stripe.CouponParams{}
+	params.Context = ctx
+	_, err := coupon.Del(id, params)
+	return err
+}
+
+// ValidateCoupon returns any reasons the coupon wouldn't work as expected with the given prices and other coupons.
+func ValidateCoupon(c *Coupon, prices []*datamodel.PriceDetails, others []*Coupon) []string {
+	problems := []string{}
+	if len(c.DiscountTypes) == 0 {
+		problems = append(problems, "no discount types are set")
+	}
+
+	var price *datamodel.PriceDetails
+	for _, p := range prices {
+		if p.ID == c.PriceID {
+			price = p
+			break
+		}
+	}
+	if price == nil {
+		problems = append(problems, fmt.Sprintf("price %q isn't an active membership price", c.PriceID))
+	} else if c.AmountOff <= 0 || float64(c.AmountOff) >= price.Price*100 {
+		problems = append(problems, fmt.Sprintf("amount off must be between $0 and the price ($%.2f)", price.Price))
+	}
+
+	// The price cache can only hold one coupon per discount type per price
+	for _, other := range others {
+		if other.ID == c.ID || other.PriceID != c.PriceID {
+			continue
+		}
+		for _, dt := range c.DiscountTypes {
+			for _, odt := range other.DiscountTypes {
+				if dt == odt {
+					problems = append(problems, fmt.Sprintf("discount type %q is also used by coupon %q for the same price", dt, other.Name))
+				}
+			}
+		}
+	}
+
+	return problems
+}
+
+func splitDiscountTypes(str string) []string {
+	types := []string{}
+	for _, dt := range strings.Split(str, ",") {
+		if dt = strings.TrimSpace(dt); dt != "" {
+			types = append(types, dt)
+		}
+	}
+	return types
+}
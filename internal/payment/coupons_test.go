@@ -0,0 +1,32 @@
+package payment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+)
+
+func TestValidateCoupon(t *testing.T) {
+	prices := []*datamodel.PriceDetails{{ID: "monthly", Price: 50}, {ID: "yearly", Price: 500}}
+	others := []*Coupon{
+		{ID: "existing", Name: "Educator", PriceID: "monthly", DiscountTypes: []string{"educator"}, AmountOff: 1000},
+		{ID: "yearly-military", Name: "Military", PriceID: "yearly", DiscountTypes: []string{"military"}, AmountOff: 1000},
+	}
+
+	// Valid
+	assert.Empty(t, ValidateCoupon(&Coupon{PriceID: "monthly", DiscountTypes: []string{"military"}, AmountOff: 1500}, prices, others))
+	assert.Empty(t, ValidateCoupon(others[0], prices, others), "coupons don't conflict with themselves")
+
+	// Invalid
+	assert.Len(t, ValidateCoupon(&Coupon{PriceID: "nope", DiscountTypes: []string{"military"}, AmountOff: 1500}, prices, others), 1)
+	assert.Len(t, ValidateCoupon(&Coupon{PriceID: "monthly", AmountOff: 1500}, prices, others), 1)
+	assert.Len(t, ValidateCoupon(&Coupon{PriceID: "monthly", DiscountTypes: []string{"military"}, AmountOff: 5000}, prices, others), 1)
+	assert.Len(t, ValidateCoupon(&Coupon{PriceID: "monthly", DiscountTypes: []string{"educator", "military"}, AmountOff: 500}, prices, others), 1)
+}
+
+func TestSplitDiscountTypes(t *testing.T) {
+	assert.Equal(t, []string{"foo", "bar"}, splitDiscountTypes(" foo,,bar "))
+	assert.Equal(t, []string{}, splitDiscountTypes(""))
+}
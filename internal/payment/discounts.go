@@ -0,0 +1,192 @@
+package payment
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/stripe/stripe-go/v78"
+	"github.com/stripe/stripe-go/v78/invoice"
+	"github.com/stripe/stripe-go/v78/subscription"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+)
+
+// Actions taken for members with expiring discounts (see cmd/discount-check-job).
+const (
+	DiscountActionNone   = ""
+	DiscountActionNotify = "notify"
+	DiscountActionRevert = "revert"
+)
+
+// GetDiscountAction returns what should be done about the member's discount given how long before expiration
+// they should be warned. Members are warned once per expiration, so extending a discount warns them again.
+func GetDiscountAction(user *datamodel.User, now time.Time, notice time.Duration) string {
+	if user.DiscountType == "" || !user.DiscountExpiration.After(time.Unix(0, 0)) {
+		return DiscountActionNone
+	}
+	if !now.Before(user.DiscountExpiration) {
+		return DiscountActionRevert
+	}
+	noticeStart := user.DiscountExpiration.Add(-notice)
+	if now.After(noticeStart) && user.DiscountNoticeTime.Before(noticeStart) {
+		return DiscountActionNotify
+	}
+	return DiscountActionNone
+}
+
+// RemoveSubscriptionDiscounts removes any coupons from the subscription, so it's billed at the regular price
+// starting with the next invoice.
+func RemoveSubscriptionDiscounts(ctx context.Context, subID string) error {
+	params := &stripe.SubscriptionParams{
+		ProrationBehavior: stripe.String("none"),
+	}
+	params.Context = ctx
+	params.AddExtra("discounts", "") // an empty value clears them
+	_, err := subscription.Update(subID, params)
+	return err
+}
+
+// SubscriptionDiscountPreview is how a discount change would affect an active subscription. Amounts are in cents.
+type SubscriptionDiscountPreview struct {
+	CouponID    string // replaces the subscription's discount, or removes it when empty
+	Matched     bool   // false when no coupon gives the discount type to the subscription's price e.g. migrated Paypal prices
+	Current     int64  // the next invoice as things are
+	New         int64  // the next invoice after the change
+	Proration   int64  // included in New, negative for credits
+	NextInvoice time.Time
+}
+
+// PreviewSubscriptionDiscount asks Stripe what the subscription's next invoice would be with the given discount type.
+func PreviewSubscriptionDiscount(ctx context.Context, subID, discountType string, prices []*datamodel.PriceDetails) (*SubscriptionDiscountPreview, error) {
+	params := &stripe.SubscriptionParams{}
+	params.Context = ctx
+	sub, err := subscription.Get(subID, params)
+	if err != nil {
+		return nil, fmt.Errorf("getting subscription: %w", err)
+	}
+
+	preview := &SubscriptionDiscountPreview{}
+	preview.CouponID, preview.Matched = subscriptionCoupon(sub, prices, discountType)
+
+	current, err := upcomingInvoice(ctx, sub, nil)
+	if err != nil {
+		return nil, fmt.Errorf("previewing current invoice: %w", err)
+	}
+	preview.Current = current.AmountDue
+
+	next := current // the subscription won't change if there's no matching coupon
+	if preview.Matched {
+		next, err = upcomingInvoice(ctx, sub, &preview.CouponID)
+		if err != nil {
+			return nil, fmt.Errorf("previewing new invoice: %w", err)
+		}
+	}
+	preview.New = next.AmountDue
+	preview.NextInvoice = time.Unix(next.PeriodEnd, 0)
+	if next.NextPaymentAttempt > 0 {
+		preview.NextInvoice = time.Unix(next.NextPaymentAttempt, 0)
+	}
+	if next.Lines != nil {
+		for _, line := range next.Lines.Data {
+			if line.Proration {
+				preview.Proration += line.Amount
+			}
+		}
+	}
+	return preview, nil
+}
+
+// ApplySubscriptionDiscount gives the subscription the discount type, or removes its discount when empty.
+// Subscriptions without a matching coupon are left alone, which is reported by returning false.
+// Stripe doesn't prorate discount changes, so the new amount starts with the next invoice.
+func ApplySubscriptionDiscount(ctx context.Context, subID, discountType string, prices []*datamodel.PriceDetails) (bool, error) {
+	if discountType == "" {
+		return true, RemoveSubscriptionDiscounts(ctx, subID)
+	}
+
+	getParams := &stripe.SubscriptionParams{}
+	getParams.Context = ctx
+	sub, err := subscription.Get(subID, getParams)
+	if err != nil {
+		return false, fmt.Errorf("getting subscription: %w", err)
+	}
+	couponID, ok := subscriptionCoupon(sub, prices, discountType)
+	if !ok {
+		return false, nil
+	}
+
+	params := &stripe.SubscriptionParams{
+		Discounts:         []*stripe.SubscriptionDiscountParams{{Coupon: stripe.String(couponID)}},
+		ProrationBehavior: stripe.String("none"),
+	}
+	params.Context = ctx
+	_, err = subscription.Update(subID, params)
+	return true, err
+}
+
+// ApplySubscriptionPromotionCode replaces the subscription's discount with the promotion code, starting with the
+// next invoice. Stripe enforces the promotion code's own restrictions e.g. first-time customers only.
+func ApplySubscriptionPromotionCode(ctx context.Context, subID, promotionCodeID string) error {
+	params := &stripe.SubscriptionParams{
+		Discounts:         []*stripe.SubscriptionDiscountParams{{PromotionCode: stripe.String(promotionCodeID)}},
+		ProrationBehavior: stripe.String("none"),
+	}
+	params.Context = ctx
+	_, err := subscription.Update(subID, params)
+	return err
+}
+
+// SubscriptionDiscounted returns true if the subscription has any coupon or promotion code applied.
+func SubscriptionDiscounted(ctx context.Context, subID string) (bool, error) {
+	params := &stripe.SubscriptionParams{}
+	params.Context = ctx
+	sub, err := subscription.Get(subID, params)
+	if err != nil {
+		return false, fmt.Errorf("getting subscription: %w", err)
+	}
+	return discounted(sub), nil
+}
+
+func discounted(sub *stripe.Subscription) bool {
+	return sub.Discount != nil || len(sub.Discounts) > 0
+}
+
+// subscriptionCoupon finds the coupon that gives the discount type to the subscription's membership price.
+// Removing the discount (an empty type) always matches.
+func subscriptionCoupon(sub *stripe.Subscription, prices []*datamodel.PriceDetails, discountType string) (string, bool) {
+	if discountType == "" {
+		return "", true
+	}
+	if sub.Items == nil {
+		return "", false
+	}
+	for _, item := range sub.Items.Data {
+		if item.Price == nil {
+			continue
+		}
+		for _, price := range prices {
+			if price.ID == item.Price.ID && price.Product == datamodel.ProductMembership && price.CouponIDs[discountType] != "" {
+				return price.CouponIDs[discountType], true
+			}
+		}
+	}
+	return "", false
+}
+
+// upcomingInvoice previews the subscription's next invoice, replacing its discount when couponID is non-nil.
+func upcomingInvoice(ctx context.Context, sub *stripe.Subscription, couponID *string) (*stripe.Invoice, error) {
+	params := &stripe.InvoiceUpcomingParams{Subscription: stripe.String(sub.ID)}
+	if sub.Customer != nil {
+		params.Customer = stripe.String(sub.Customer.ID)
+	}
+	params.Context = ctx
+	switch {
+	case couponID == nil:
+	case *couponID == "":
+		params.AddExtra("discounts", "") // an empty value clears them
+	default:
+		params.Discounts = []*stripe.InvoiceDiscountParams{{Coupon: couponID}}
+	}
+	return invoice.Upcoming(params)
+}
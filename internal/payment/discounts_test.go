@@ -0,0 +1,98 @@
+package payment
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/stripe-go/v78"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+)
+
+func TestGetDiscountAction(t *testing.T) {
+	now := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	notice := time.Hour * 24 * 14
+
+	tests := []struct {
+		Name     string
+		User     *datamodel.User
+		Expected string
+	}{
+		{
+			Name:     "no discount",
+			User:     &datamodel.User{DiscountExpiration: now.Add(-time.Hour)},
+			Expected: DiscountActionNone,
+		},
+		{
+			Name:     "no expiration",
+			User:     &datamodel.User{DiscountType: "educator"},
+			Expected: DiscountActionNone,
+		},
+		{
+			Name:     "not expiring soon",
+			User:     &datamodel.User{DiscountType: "educator", DiscountExpiration: now.Add(notice * 2)},
+			Expected: DiscountActionNone,
+		},
+		{
+			Name:     "expiring soon",
+			User:     &datamodel.User{DiscountType: "educator", DiscountExpiration: now.Add(notice / 2)},
+			Expected: DiscountActionNotify,
+		},
+		{
+			Name:     "already notified",
+			User:     &datamodel.User{DiscountType: "educator", DiscountExpiration: now.Add(notice / 2), DiscountNoticeTime: now.Add(-time.Hour)},
+			Expected: DiscountActionNone,
+		},
+		{
+			Name:     "notified about a previous expiration",
+			User:     &datamodel.User{DiscountType: "educator", DiscountExpiration: now.Add(notice / 2), DiscountNoticeTime: now.Add(-notice * 10)},
+			Expected: DiscountActionNotify,
+		},
+		{
+			Name:     "expired",
+			User:     &datamodel.User{DiscountType: "educator", DiscountExpiration: now, DiscountNoticeTime: now.Add(-time.Hour)},
+			Expected: DiscountActionRevert,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			assert.Equal(t, test.Expected, GetDiscountAction(test.User, now, notice))
+		})
+	}
+}
+
+func TestSubscriptionCoupon(t *testing.T) {
+	prices := []*datamodel.PriceDetails{
+		{ID: "price_monthly", Product: datamodel.ProductMembership, CouponIDs: map[string]string{"educator": "coupon_monthly"}},
+		{ID: "price_locker", Product: "locker", CouponIDs: map[string]string{"educator": "coupon_locker"}},
+	}
+	sub := func(priceIDs ...string) *stripe.Subscription {
+		items := &stripe.SubscriptionItemList{}
+		for _, id := range priceIDs {
+			items.Data = append(items.Data, &stripe.SubscriptionItem{Price: &stripe.Price{ID: id}})
+		}
+		return &stripe.Subscription{Items: items}
+	}
+
+	id, ok := subscriptionCoupon(sub("price_locker", "price_monthly"), prices, "educator")
+	assert.True(t, ok)
+	assert.Equal(t, "coupon_monthly", id)
+
+	_, ok = subscriptionCoupon(sub("price_monthly"), prices, "military")
+	assert.False(t, ok)
+
+	_, ok = subscriptionCoupon(sub("price_custom"), prices, "educator")
+	assert.False(t, ok)
+
+	id, ok = subscriptionCoupon(sub("price_custom"), prices, "")
+	assert.True(t, ok)
+	assert.Empty(t, id)
+}
+
+func TestDiscounted(t *testing.T) {
+	assert.False(t, discounted(&stripe.Subscription{}))
+	assert.True(t, discounted(&stripe.Subscription{Discount: &stripe.Discount{ID: "di_1"}}))
+	assert.True(t, discounted(&stripe.Subscription{Discounts: []*stripe.Discount{{ID: "di_1"}}}))
+}
@@ -0,0 +1,35 @@
+package payment
+
+import (
+	"context"
+
+	"github.com/stripe/stripe-go/v78"
+	"github.com/stripe/stripe-go/v78/subscriptionitem"
+)
+
+// AddLockerItem adds a locker rental to the member's existing subscription so it's billed alongside their membership.
+// The new item's ID is returned so it can be removed later.
+func AddLockerItem(ctx context.Context, subscriptionID, priceID string) (string, error) {
+	params := &stripe.SubscriptionItemParams{
+		Subscription:      stripe.String(subscriptionID),
+		Price:             stripe.String(priceID),
+		Quantity:          stripe.Int64(1),
+		ProrationBehavior: stripe.String("create_prorations"),
+	}
+	params.Context = ctx
+	item, err := subscriptionitem.New(params)
+	if err != nil {
+		return "", err
+	}
+	return item.ID, nil
+}
+
+// RemoveLockerItem stops billing for a locker rental, crediting the unused time.
+func RemoveLockerItem(ctx context.Context, itemID string) error {
+	params := &stripe.SubscriptionItemParams{
+		ProrationBehavior: stripe.String("create_prorations"),
+	}
+	params.Context = ctx
+	_, err := subscriptionitem.Del(itemID, params)
+	return err
+}
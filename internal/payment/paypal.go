@@ -0,0 +1,19 @@
+package payment
+
+import (
+	"time"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+)
+
+// PaypalMigrationFinalNotice is the last of the escalating notices sent to members still paying through Paypal.
+const PaypalMigrationFinalNotice = 3
+
+// PaypalMigrationNoticeDue returns true if the member, who is still paying through Paypal, should be sent their next
+// migration notice. Notices start at the cutoff and are sent every interval until the final one.
+func PaypalMigrationNoticeDue(user *datamodel.User, now, cutoff time.Time, interval time.Duration) bool {
+	if cutoff.IsZero() || now.Before(cutoff) || user.PaypalMigrationNotices >= PaypalMigrationFinalNotice {
+		return false
+	}
+	return now.Sub(user.PaypalMigrationNoticeTime) >= interval
+}
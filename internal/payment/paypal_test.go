@@ -0,0 +1,31 @@
+package payment
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+)
+
+func TestPaypalMigrationNoticeDue(t *testing.T) {
+	cutoff := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	interval := time.Hour * 24 * 7
+
+	// Nothing happens until there's a cutoff, and it has passed
+	assert.False(t, PaypalMigrationNoticeDue(&datamodel.User{}, cutoff, time.Time{}, interval))
+	assert.False(t, PaypalMigrationNoticeDue(&datamodel.User{}, cutoff.Add(-time.Hour), cutoff, interval))
+
+	// First notice
+	assert.True(t, PaypalMigrationNoticeDue(&datamodel.User{}, cutoff, cutoff, interval))
+
+	// Escalations are spaced out
+	user := &datamodel.User{PaypalMigrationNotices: 1, PaypalMigrationNoticeTime: cutoff}
+	assert.False(t, PaypalMigrationNoticeDue(user, cutoff.Add(interval/2), cutoff, interval))
+	assert.True(t, PaypalMigrationNoticeDue(user, cutoff.Add(interval), cutoff, interval))
+
+	// ...and stop after the final notice
+	user = &datamodel.User{PaypalMigrationNotices: PaypalMigrationFinalNotice, PaypalMigrationNoticeTime: cutoff}
+	assert.False(t, PaypalMigrationNoticeDue(user, cutoff.Add(interval*10), cutoff, interval))
+}
@@ -2,9 +2,13 @@ package payment
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/TheLab-ms/profile/internal/datamodel"
@@ -18,16 +22,30 @@ import (
 // PriceCache is used to store Stripe product prices in-memory to avoid fetching them when rendering pages.
 type PriceCache struct {
 	flowcontrol.Loop
-	mut   sync.Mutex
-	state *cacheState
+	mut      sync.Mutex
+	state    *cacheState
+	products map[string]string // key -> Stripe product name
+	kicked   atomic.Bool
+
+	// Coordinator optionally shares prices between replicas so they don't all poll Stripe.
+	Coordinator flowcontrol.Coordinator
 }
 
-func NewPriceCache() *PriceCache {
-	p := &PriceCache{}
+// NewPriceCache returns a cache of prices for the given products, keyed by how they're referenced in PriceDetails.Product.
+// The membership product is required - the others are optional addons.
+func NewPriceCache(products map[string]string) *PriceCache {
+	p := &PriceCache{products: products}
 	p.Loop.Handler = flowcontrol.RetryHandler(time.Hour, p.fillCache)
 	return p
 }
 
+// NewStaticPriceCache returns a cache pre-populated with the given state that never polls Stripe.
+// Useful for local development without Stripe credentials.
+func NewStaticPriceCache(prices []*datamodel.PriceDetails, discountTypes []string) *PriceCache {
+	return &PriceCache{state: &cacheState{Prices: prices, DiscountTypes: discountTypes}}
+}
+
+// GetPrices returns the prices of every product.
 func (p *PriceCache) GetPrices() []*datamodel.PriceDetails {
 	p.mut.Lock()
 	defer p.mut.Unlock()
@@ -37,6 +55,17 @@ func (p *PriceCache) GetPrices() []*datamodel.PriceDetails {
 	return p.state.Prices
 }
 
+// GetProductPrices returns the prices of a single product e.g. datamodel.ProductMembership.
+func (p *PriceCache) GetProductPrices(product string) []*datamodel.PriceDetails {
+	prices := []*datamodel.PriceDetails{}
+	for _, price := range p.GetPrices() {
+		if price.Product == product {
+			prices = append(prices, price)
+		}
+	}
+	return prices
+}
+
 func (p *PriceCache) GetDiscountTypes() []string {
 	p.mut.Lock()
 	defer p.mut.Unlock()
@@ -46,10 +75,42 @@ func (p *PriceCache) GetDiscountTypes() []string {
 	return p.state.DiscountTypes
 }
 
+// Kick refreshes the cache from Stripe now, even if another replica refreshed it recently.
+func (p *PriceCache) Kick() {
+	p.kicked.Store(true)
+	p.Loop.Kick()
+}
+
 func (p *PriceCache) fillCache(ctx context.Context) bool {
-	state := p.listPrices()
-	if state == nil {
-		log.Fatalf("failed to populate Stripe cache - will retry")
+	// Replicas refresh at random offsets, so sharing at half the interval bounds staleness to ~1.5 intervals
+	maxAge := time.Minute * 30
+	if p.kicked.Swap(false) {
+		maxAge = 0
+	}
+	var (
+		listed  bool
+		fetched *cacheState
+	)
+	list := func(ctx context.Context) (*cacheState, error) {
+		listed = true
+		fetched = p.listPrices()
+		if fetched == nil {
+			return nil, errors.New("unable to list prices")
+		}
+		return fetched, nil
+	}
+	state, err := flowcontrol.RefreshShared(ctx, p.Coordinator, "stripe-prices", maxAge, list)
+	if err != nil && fetched != nil {
+		log.Printf("error while sharing Stripe prices with other replicas: %s", err)
+		state, err = fetched, nil
+	}
+	if err != nil && !listed {
+		// The coordinator (i.e. the reporting db) is down - Stripe might not be
+		log.Printf("error while getting shared Stripe prices - listing them directly: %s", err)
+		state, err = list(ctx)
+	}
+	if err != nil {
+		log.Fatalf("failed to populate Stripe cache - will retry: %s", err)
 		return false
 	}
 
@@ -61,17 +122,25 @@ func (p *PriceCache) fillCache(ctx context.Context) bool {
 }
 
 func (p *PriceCache) listPrices() *cacheState {
-	// Discover product ID
-	products := product.Search(&stripe.ProductSearchParams{
-		SearchParams: stripe.SearchParams{
-			Query: `name:"Membership"`,
-		},
-	})
-	products.Next()
-	product := products.Product()
-	if product == nil {
-		// the stripe library logs errors - no need to do so here
-		return nil
+	// Discover product IDs
+	products := map[string]*stripe.Product{}
+	for key, name := range p.products {
+		iter := product.Search(&stripe.ProductSearchParams{
+			SearchParams: stripe.SearchParams{
+				Query: fmt.Sprintf("name:%q", name),
+			},
+		})
+		iter.Next()
+		prod := iter.Product()
+		if prod == nil && key == datamodel.ProductMembership {
+			// the stripe library logs errors - no need to do so here
+			return nil
+		}
+		if prod == nil {
+			log.Printf("stripe product %q not found - skipping it", name)
+			continue
+		}
+		products[key] = prod
 	}
 
 	// Coupons
@@ -99,11 +168,28 @@ func (p *PriceCache) listPrices() *cacheState {
 		}
 	}
 
-	// Prices
+	// Prices (in a stable order since they're rendered as-is)
+	keys := []string{}
+	for key := range products {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	returns := []*datamodel.PriceDetails{}
+	for _, key := range keys {
+		returns = append(returns, listProductPrices(key, products[key], coupsIDs, coupsAmountOff)...)
+	}
+
+	return &cacheState{
+		Prices:        returns,
+		DiscountTypes: allDiscountTypes,
+	}
+}
+
+func listProductPrices(key string, prod *stripe.Product, coupsIDs map[string]map[string]string, coupsAmountOff map[string]map[string]int64) []*datamodel.PriceDetails {
 	prices := price.List(&stripe.PriceListParams{
 		Active:  stripe.Bool(true),
 		Type:    stripe.String("recurring"),
-		Product: &product.ID,
+		Product: &prod.ID,
 	})
 	returns := []*datamodel.PriceDetails{}
 	for prices.Next() {
@@ -113,6 +199,8 @@ func (p *PriceCache) listPrices() *cacheState {
 		}
 		p := &datamodel.PriceDetails{
 			ID:               price.ID,
+			Product:          key,
+			ProductName:      prod.Name,
 			CouponIDs:        coupsIDs[price.ID],
 			CouponAmountsOff: coupsAmountOff[price.ID],
 			Price:            price.UnitAmountDecimal / 100,
@@ -129,11 +217,7 @@ func (p *PriceCache) listPrices() *cacheState {
 		}
 		returns = append(returns, p)
 	}
-
-	return &cacheState{
-		Prices:        returns,
-		DiscountTypes: allDiscountTypes,
-	}
+	return returns
 }
 
 type cacheState struct {
@@ -8,14 +8,16 @@ import (
 
 	"github.com/TheLab-ms/profile/internal/conf"
 	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/urls"
 )
 
 // NewCheckoutSessionParams sets the various Stripe checkout options for a new registering member.
-func NewCheckoutSessionParams(ctx context.Context, user *datamodel.User, env *conf.Env, pc *PriceCache, priceID string) *stripe.CheckoutSessionParams {
+// Addons are given as product keys (see PriceCache) and billed at the same interval as the membership price.
+func NewCheckoutSessionParams(ctx context.Context, user *datamodel.User, env *conf.Env, pc *PriceCache, priceID string, addons []string) *stripe.CheckoutSessionParams {
 	checkoutParams := &stripe.CheckoutSessionParams{
 		Mode:       stripe.String(string(stripe.CheckoutSessionModeSubscription)),
-		SuccessURL: stripe.String(env.SelfURL + "/profile"),
-		CancelURL:  stripe.String(env.SelfURL + "/profile"),
+		SuccessURL: stripe.String(urls.Profile(env)),
+		CancelURL:  stripe.String(urls.Profile(env)),
 	}
 	if user.StripeCustomerID == "" {
 		checkoutParams.CustomerEmail = &user.Email
@@ -26,6 +28,7 @@ func NewCheckoutSessionParams(ctx context.Context, user *datamodel.User, env *co
 
 	// Calculate specific pricing based on the member's profile
 	checkoutParams.LineItems = calculateLineItems(user, priceID, pc)
+	checkoutParams.LineItems = append(checkoutParams.LineItems, calculateAddonLineItems(user, priceID, addons, pc)...)
 	checkoutParams.Discounts = calculateDiscount(user, priceID, pc)
 	if checkoutParams.Discounts == nil {
 		// Stripe API doesn't allow Discounts and AllowPromotionCodes to be set
@@ -46,12 +49,12 @@ func calculateLineItems(user *datamodel.User, priceID string, pc *PriceCache) []
 	// Migrate existing paypal users at their current rate
 	if priceID == "paypal" {
 		interval := "month"
-		if user.PaypalMetadata.Price > 50 {
+		if paypalAnnual(user) {
 			interval = "year"
 		}
 
 		cents := user.PaypalMetadata.Price * 100
-		productID := pc.GetPrices()[0].ProductID // all prices reference the same product
+		productID := pc.GetProductPrices(datamodel.ProductMembership)[0].ProductID // all membership prices reference the same product
 		return []*stripe.CheckoutSessionLineItemParams{{
 			Quantity: stripe.Int64(1),
 			PriceData: &stripe.CheckoutSessionLineItemPriceDataParams{
@@ -71,17 +74,63 @@ func calculateLineItems(user *datamodel.User, priceID string, pc *PriceCache) []
 	}}
 }
 
+// calculateAddonLineItems finds the price of each addon product that matches the membership's billing interval.
+// Stripe requires every item of a subscription to share the same interval, so addons without one are skipped.
+func calculateAddonLineItems(user *datamodel.User, priceID string, addons []string, pc *PriceCache) []*stripe.CheckoutSessionLineItemParams {
+	var annual bool
+	if priceID == "paypal" {
+		annual = paypalAnnual(user)
+	} else {
+		found := false
+		for _, price := range pc.GetProductPrices(datamodel.ProductMembership) {
+			if price.ID == priceID {
+				annual = price.Annual
+				found = true
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+
+	items := []*stripe.CheckoutSessionLineItemParams{}
+	for _, addon := range addons {
+		if addon == datamodel.ProductMembership {
+			continue
+		}
+		for _, price := range pc.GetProductPrices(addon) {
+			if price.Annual == annual {
+				items = append(items, &stripe.CheckoutSessionLineItemParams{
+					Price:    stripe.String(price.ID),
+					Quantity: stripe.Int64(1),
+				})
+				break
+			}
+		}
+	}
+	return items
+}
+
+// calculateDiscount applies the member's discount type, falling back to a promotion code they redeemed.
+// Stripe only allows one discount per checkout session.
 func calculateDiscount(user *datamodel.User, priceID string, pc *PriceCache) []*stripe.CheckoutSessionDiscountParams {
-	if user.DiscountType == "" || priceID == "" {
+	if priceID == "" {
 		return nil
 	}
-	for _, price := range pc.GetPrices() {
-		if price.ID == priceID && price.CouponIDs != nil && price.CouponIDs[user.DiscountType] != "" {
-			return []*stripe.CheckoutSessionDiscountParams{{
-				Coupon: stripe.String(price.CouponIDs[user.DiscountType]),
-			}}
+	if user.DiscountType != "" {
+		for _, price := range pc.GetPrices() {
+			if price.ID == priceID && price.CouponIDs != nil && price.CouponIDs[user.DiscountType] != "" {
+				return []*stripe.CheckoutSessionDiscountParams{{
+					Coupon: stripe.String(price.CouponIDs[user.DiscountType]),
+				}}
+			}
 		}
 	}
+	if user.StripePromotionCode != "" {
+		return []*stripe.CheckoutSessionDiscountParams{{
+			PromotionCode: stripe.String(user.StripePromotionCode),
+		}}
+	}
 	return nil
 }
 
@@ -108,6 +157,27 @@ func calculateBillingCycleAnchor(user *datamodel.User) *int64 {
 	return &ts
 }
 
+// paypalAnnual returns true if the member's PayPal rate is for a yearly membership.
+func paypalAnnual(user *datamodel.User) bool { return user.PaypalMetadata.Price > 50 }
+
+// NewPersonalizedPrices returns the membership prices the member would pay at checkout: their discount type is
+// applied, and members migrating from PayPal get their current rate. Promotion codes aren't included since their
+// amounts are only known to Stripe.
+func NewPersonalizedPrices(user *datamodel.User, pc *PriceCache) *datamodel.Prices {
+	items := pc.GetProductPrices(datamodel.ProductMembership)
+	prices := datamodel.NewPrices(items)
+	personal := datamodel.NewPrices(CalculateDiscounts(user, items))
+	prices.Yearly.Discounted = personal.Yearly.Price
+	prices.Monthly.Discounted = personal.Monthly.Price
+	prices.Personalized = true
+	prices.DiscountType = user.DiscountType
+
+	if user.PaypalMetadata.Price > 0 {
+		prices.Paypal = &datamodel.PaypalPrice{Price: user.PaypalMetadata.Price, Annual: paypalAnnual(user)}
+	}
+	return prices
+}
+
 func CalculateDiscounts(user *datamodel.User, prices []*datamodel.PriceDetails) []*datamodel.PriceDetails {
 	if user.DiscountType == "" {
 		return prices
@@ -118,6 +188,8 @@ func CalculateDiscounts(user *datamodel.User, prices []*datamodel.PriceDetails)
 		out[i] = &datamodel.PriceDetails{
 			ID:               price.ID,
 			ProductID:        price.ProductID,
+			Product:          price.Product,
+			ProductName:      price.ProductName,
 			Annual:           price.Annual,
 			Price:            price.Price - (float64(amountOff) / 100),
 			CouponIDs:        price.CouponIDs,
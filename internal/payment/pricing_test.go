@@ -0,0 +1,74 @@
+package payment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+)
+
+func TestCalculateAddonLineItems(t *testing.T) {
+	pc := NewStaticPriceCache([]*datamodel.PriceDetails{
+		{ID: "monthly", Product: datamodel.ProductMembership},
+		{ID: "yearly", Product: datamodel.ProductMembership, Annual: true},
+		{ID: "storage-monthly", Product: "storage"},
+		{ID: "storage-yearly", Product: "storage", Annual: true},
+		{ID: "247-monthly", Product: "247"},
+	}, nil)
+
+	priceIDs := func(priceID string, user *datamodel.User, addons ...string) []string {
+		ids := []string{}
+		for _, item := range calculateAddonLineItems(user, priceID, addons, pc) {
+			ids = append(ids, *item.Price)
+		}
+		return ids
+	}
+
+	user := &datamodel.User{}
+	assert.Equal(t, []string{"storage-monthly", "247-monthly"}, priceIDs("monthly", user, "storage", "247"))
+	assert.Equal(t, []string{"storage-yearly"}, priceIDs("yearly", user, "storage", "247"), "no yearly 24/7 price")
+	assert.Equal(t, []string{}, priceIDs("monthly", user, "membership", "nope"))
+	assert.Equal(t, []string{}, priceIDs("storage-monthly", user, "storage"), "addons can't be the primary price")
+
+	user.PaypalMetadata.Price = 400
+	assert.Equal(t, []string{"storage-yearly"}, priceIDs("paypal", user, "storage"))
+}
+
+func TestCalculateDiscount(t *testing.T) {
+	pc := NewStaticPriceCache([]*datamodel.PriceDetails{
+		{ID: "monthly", Product: datamodel.ProductMembership, CouponIDs: map[string]string{"educator": "coupon_educator"}},
+	}, nil)
+
+	assert.Nil(t, calculateDiscount(&datamodel.User{}, "monthly", pc))
+
+	discounts := calculateDiscount(&datamodel.User{DiscountType: "educator", StripePromotionCode: "promo_1"}, "monthly", pc)
+	assert.Equal(t, "coupon_educator", *discounts[0].Coupon, "discount types win")
+
+	discounts = calculateDiscount(&datamodel.User{DiscountType: "military", StripePromotionCode: "promo_1"}, "monthly", pc)
+	assert.Equal(t, "promo_1", *discounts[0].PromotionCode, "no coupon for the discount type")
+}
+
+func TestNewPersonalizedPrices(t *testing.T) {
+	pc := NewStaticPriceCache([]*datamodel.PriceDetails{
+		{ID: "monthly", Product: datamodel.ProductMembership, Price: 50, CouponAmountsOff: map[string]int64{"educator": 1000, "military": 2000}},
+		{ID: "yearly", Product: datamodel.ProductMembership, Annual: true, Price: 500, CouponAmountsOff: map[string]int64{"educator": 10000}},
+		{ID: "storage-monthly", Product: "storage", Price: 10},
+	}, nil)
+
+	prices := NewPersonalizedPrices(&datamodel.User{}, pc)
+	assert.True(t, prices.Personalized)
+	assert.Equal(t, datamodel.Price{Price: 50, Discounted: 50}, prices.Monthly)
+	assert.Equal(t, datamodel.Price{Price: 500, Discounted: 500}, prices.Yearly)
+	assert.Nil(t, prices.Paypal)
+
+	prices = NewPersonalizedPrices(&datamodel.User{DiscountType: "military"}, pc)
+	assert.Equal(t, "military", prices.DiscountType)
+	assert.Equal(t, datamodel.Price{Price: 50, Discounted: 30}, prices.Monthly)
+	assert.Equal(t, datamodel.Price{Price: 500, Discounted: 500}, prices.Yearly, "no yearly military coupon")
+
+	user := &datamodel.User{}
+	user.PaypalMetadata.Price = 400
+	prices = NewPersonalizedPrices(user, pc)
+	assert.Equal(t, &datamodel.PaypalPrice{Price: 400, Annual: true}, prices.Paypal)
+}
@@ -0,0 +1,132 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/stripe/stripe-go/v78"
+	"github.com/stripe/stripe-go/v78/subscription"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+)
+
+// TreasurerReport summarizes membership finances for a month.
+// Counts of active subscriptions, discounts, etc. reflect the state when the report was built, not the end of the month.
+type TreasurerReport struct {
+	Start, End            time.Time
+	ActiveSubscriptions   int
+	NewSubscriptions      int // created during the month
+	CanceledSubscriptions int // canceled during the month
+	ByPrice               map[string]int
+	ByDiscountType        map[string]int
+	NonBillable           int
+	PaypalStragglers      []*datamodel.User // active members who haven't migrated to Stripe
+}
+
+// ListSubscriptions returns every Stripe subscription regardless of status.
+func ListSubscriptions(ctx context.Context) ([]*stripe.Subscription, error) {
+	params := &stripe.SubscriptionListParams{Status: stripe.String("all")}
+	params.Context = ctx
+
+	subs := []*stripe.Subscription{}
+	iter := subscription.List(params)
+	for iter.Next() {
+		subs = append(subs, iter.Subscription())
+	}
+	return subs, iter.Err()
+}
+
+// BuildTreasurerReport summarizes the given subscriptions and active members for the month starting at start.
+func BuildTreasurerReport(start time.Time, subs []*stripe.Subscription, activeMembers []*datamodel.User) *TreasurerReport {
+	r := &TreasurerReport{
+		Start:          start,
+		End:            start.AddDate(0, 1, 0),
+		ByPrice:        map[string]int{},
+		ByDiscountType: map[string]int{},
+	}
+	inMonth := func(ts int64) bool {
+		t := time.Unix(ts, 0)
+		return !t.Before(r.Start) && t.Before(r.End)
+	}
+
+	for _, sub := range subs {
+		if sub.Created > 0 && inMonth(sub.Created) {
+			r.NewSubscriptions++
+		}
+		if sub.CanceledAt > 0 && inMonth(sub.CanceledAt) {
+			r.CanceledSubscriptions++
+		}
+		if sub.Status != stripe.SubscriptionStatusActive && sub.Status != stripe.SubscriptionStatusPastDue {
+			continue
+		}
+		r.ActiveSubscriptions++
+		if sub.Items == nil {
+			continue
+		}
+		for _, item := range sub.Items.Data {
+			if item.Price != nil {
+				r.ByPrice[priceLabel(item.Price)]++
+			}
+		}
+	}
+
+	for _, user := range activeMembers {
+		if user.NonBillable {
+			r.NonBillable++
+		}
+		if user.DiscountType != "" {
+			r.ByDiscountType[user.DiscountType]++
+		}
+		if user.PaypalMetadata.TransactionID != "" && user.StripeCustomerID == "" {
+			r.PaypalStragglers = append(r.PaypalStragglers, user)
+		}
+	}
+
+	return r
+}
+
+func priceLabel(p *stripe.Price) string {
+	label := fmt.Sprintf("%s ($%.2f", p.ID, p.UnitAmountDecimal/100)
+	if p.Recurring != nil {
+		label += "/" + string(p.Recurring.Interval)
+	}
+	return label + ")"
+}
+
+// CSV renders the report as section,item,value rows.
+func (r *TreasurerReport) CSV() []byte {
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	w.Write([]string{"section", "item", "value"})
+	w.Write([]string{"summary", "month", r.Start.Format("2006-01")})
+	w.Write([]string{"summary", "active_subscriptions", strconv.Itoa(r.ActiveSubscriptions)})
+	w.Write([]string{"summary", "new_subscriptions", strconv.Itoa(r.NewSubscriptions)})
+	w.Write([]string{"summary", "canceled_subscriptions", strconv.Itoa(r.CanceledSubscriptions)})
+	w.Write([]string{"summary", "non_billable_members", strconv.Itoa(r.NonBillable)})
+	w.Write([]string{"summary", "paypal_stragglers", strconv.Itoa(len(r.PaypalStragglers))})
+	for _, key := range sortedKeys(r.ByPrice) {
+		w.Write([]string{"price", key, strconv.Itoa(r.ByPrice[key])})
+	}
+	for _, key := range sortedKeys(r.ByDiscountType) {
+		w.Write([]string{"discount_type", key, strconv.Itoa(r.ByDiscountType[key])})
+	}
+	for _, user := range r.PaypalStragglers {
+		w.Write([]string{"paypal_straggler", user.Email, strconv.FormatFloat(user.PaypalMetadata.Price, 'f', 2, 64)})
+	}
+	w.Flush()
+	return buf.Bytes()
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,59 @@
+package payment
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/stripe-go/v78"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+)
+
+func TestTreasurerReport(t *testing.T) {
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	monthly := &stripe.Price{ID: "monthly", UnitAmountDecimal: 5000, Recurring: &stripe.PriceRecurring{Interval: "month"}}
+	items := func(prices ...*stripe.Price) *stripe.SubscriptionItemList {
+		list := &stripe.SubscriptionItemList{}
+		for _, p := range prices {
+			list.Data = append(list.Data, &stripe.SubscriptionItem{Price: p})
+		}
+		return list
+	}
+
+	subs := []*stripe.Subscription{
+		{Status: "active", Created: start.AddDate(-1, 0, 0).Unix(), Items: items(monthly)},
+		{Status: "active", Created: start.AddDate(0, 0, 3).Unix(), Items: items(monthly)},                                               // new
+		{Status: "past_due", Created: start.AddDate(0, -2, 0).Unix(), Items: items(monthly)},                                            // still counts as active
+		{Status: "canceled", Created: start.AddDate(0, -2, 0).Unix(), CanceledAt: start.AddDate(0, 0, 10).Unix()},                       // canceled
+		{Status: "canceled", Created: start.AddDate(0, -2, 0).Unix(), CanceledAt: start.AddDate(0, 1, 1).Unix()},                        // canceled next month
+		{Status: "active", Created: start.AddDate(0, 1, 0).Unix(), Items: items(&stripe.Price{ID: "yearly", UnitAmountDecimal: 50000})}, // created next month
+	}
+	members := []*datamodel.User{
+		{Email: "a@example.com", DiscountType: "educator"},
+		{Email: "b@example.com", NonBillable: true},
+		{Email: "c@example.com", PaypalMetadata: datamodel.PaypalMetadata{TransactionID: "I-1", Price: 40}},
+		{Email: "d@example.com", StripeCustomerID: "cus_1", PaypalMetadata: datamodel.PaypalMetadata{TransactionID: "I-2"}}, // migrated
+	}
+
+	r := BuildTreasurerReport(start, subs, members)
+	assert.Equal(t, 4, r.ActiveSubscriptions)
+	assert.Equal(t, 1, r.NewSubscriptions)
+	assert.Equal(t, 1, r.CanceledSubscriptions)
+	assert.Equal(t, 1, r.NonBillable)
+	assert.Equal(t, map[string]int{"monthly ($50.00/month)": 3, "yearly ($500.00)": 1}, r.ByPrice)
+	assert.Equal(t, map[string]int{"educator": 1}, r.ByDiscountType)
+
+	assert.Equal(t, `section,item,value
+summary,month,2024-03
+summary,active_subscriptions,4
+summary,new_subscriptions,1
+summary,canceled_subscriptions,1
+summary,non_billable_members,1
+summary,paypal_stragglers,1
+price,monthly ($50.00/month),3
+price,yearly ($500.00),1
+discount_type,educator,1
+paypal_straggler,c@example.com,40.00
+`, string(r.CSV()))
+}
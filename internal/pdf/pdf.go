@@ -0,0 +1,131 @@
+// Package pdf renders simple single-page text documents, like the proof-of-membership letter.
+// It only supports what those documents need - a title and left-aligned paragraphs in Helvetica.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const (
+	pageWidth  = 612 // US letter, in points
+	pageHeight = 792
+	margin     = 72
+	titleSize  = 18
+	lineHeight = 16
+)
+
+// Document is a single page of text. Paragraphs are wrapped to fit the page, and empty ones are rendered as blank lines.
+type Document struct {
+	Title      string
+	Paragraphs []*Paragraph
+}
+
+// Paragraph is a block of text, wrapped to the page width.
+type Paragraph struct {
+	Text string
+	Mono bool // smaller fixed-width text for things that need to be typed in e.g. URLs
+}
+
+// Text is a shortcut for a regular paragraph.
+func Text(s string) *Paragraph { return &Paragraph{Text: s} }
+
+func (p *Paragraph) font() (name string, size, width int) {
+	if p.Mono {
+		return "/F3", 8, 97 // Courier is 0.6em wide
+	}
+	return "/F1", 11, 90 // rough fit for Helvetica
+}
+
+// Render encodes the document as a PDF.
+func (d *Document) Render() []byte {
+	content := &bytes.Buffer{}
+	y := pageHeight - margin
+	fmt.Fprintf(content, "BT /F2 %d Tf %d %d Td (%s) Tj ET\n", titleSize, margin, y, escape(d.Title))
+	y -= lineHeight * 2
+	for _, para := range d.Paragraphs {
+		font, size, width := para.font()
+		lines := wrap(para.Text, width)
+		if len(lines) == 0 {
+			lines = []string{""}
+		}
+		for _, line := range lines {
+			fmt.Fprintf(content, "BT %s %d Tf %d %d Td (%s) Tj ET\n", font, size, margin, y, escape(line))
+			y -= lineHeight
+		}
+	}
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 4 0 R /F2 5 0 R /F3 6 0 R >> >> /Contents 7 0 R >>", pageWidth, pageHeight),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
+
+// escape makes text safe to use in a PDF string literal. Characters outside of ASCII are replaced,
+// since the standard fonts can't be relied on to render them.
+func escape(s string) string {
+	b := strings.Builder{}
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r < 32 || r > 126:
+			b.WriteRune('?')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// wrap splits text into lines of at most width runes, breaking on spaces where possible.
+func wrap(text string, width int) []string {
+	lines := []string{}
+	current := ""
+	for _, word := range strings.Fields(text) {
+		for len([]rune(word)) > width {
+			if current != "" {
+				lines = append(lines, current)
+				current = ""
+			}
+			lines = append(lines, string([]rune(word)[:width]))
+			word = string([]rune(word)[width:])
+		}
+		switch {
+		case current == "":
+			current = word
+		case len([]rune(current))+1+len([]rune(word)) > width:
+			lines = append(lines, current)
+			current = word
+		default:
+			current += " " + word
+		}
+	}
+	if current != "" {
+		lines = append(lines, current)
+	}
+	return lines
+}
@@ -0,0 +1,40 @@
+package pdf
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRender(t *testing.T) {
+	doc := &Document{Title: "Hello (World)", Paragraphs: []*Paragraph{Text("First paragraph"), Text(""), Text("Ünïcode \\ text"), {Text: "https://example.com", Mono: true}}}
+	out := doc.Render()
+
+	assert.True(t, bytes.HasPrefix(out, []byte("%PDF-1.4\n")))
+	assert.True(t, bytes.HasSuffix(out, []byte("%%EOF\n")))
+	assert.Contains(t, string(out), `(Hello \(World\)) Tj`)
+	assert.Contains(t, string(out), `(?n?code \\ text) Tj`)
+	assert.Contains(t, string(out), `/F3 8 Tf 72 640 Td (https://example.com) Tj`)
+
+	// Every xref entry points at the start of its object
+	start := bytes.LastIndex(out, []byte("startxref\n"))
+	xref, err := strconv.Atoi(strings.Fields(string(out[start+len("startxref\n"):]))[0])
+	require.NoError(t, err)
+	entries := regexp.MustCompile(`(\d{10}) 00000 n`).FindAllStringSubmatch(string(out[xref:]), -1)
+	require.Len(t, entries, 7)
+	for i, entry := range entries {
+		offset, _ := strconv.Atoi(entry[1])
+		assert.True(t, bytes.HasPrefix(out[offset:], []byte(strconv.Itoa(i+1)+" 0 obj")), "object %d", i+1)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	assert.Equal(t, []string{"aaa bb", "c"}, wrap("aaa bb c", 6))
+	assert.Equal(t, []string{"aaaaaa", "aa b"}, wrap("aaaaaaaa b", 6))
+	assert.Equal(t, []string{}, wrap("  ", 6))
+}
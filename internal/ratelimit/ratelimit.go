@@ -0,0 +1,79 @@
+// Package ratelimit limits request rates across every replica of a service.
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/time/rate"
+
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+var limitedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "rate_limited_requests_total",
+	Help: "Count of requests rejected by rate limiters",
+}, []string{"limiter"})
+
+// Limiter allows at most Limit events per sliding Window for each key, or any number of events when Limit is 0.
+// Events are counted in the reporting database so the limit holds across replicas.
+// When the database is unavailable it falls back to an equivalent per-process limiter.
+type Limiter struct {
+	Name   string
+	Limit  int
+	Window time.Duration
+
+	sink  *reporting.ReportingSink
+	mut   sync.Mutex
+	local map[string]*rate.Limiter
+}
+
+func New(sink *reporting.ReportingSink, name string, limit int, window time.Duration) *Limiter {
+	return &Limiter{Name: name, Limit: limit, Window: window, sink: sink, local: map[string]*rate.Limiter{}}
+}
+
+// Allow returns true if another event for the given key is allowed, and counts it if so.
+func (l *Limiter) Allow(ctx context.Context, key string) bool {
+	if l.Limit <= 0 {
+		return true
+	}
+	if l.sink.Enabled() {
+		ok, err := l.sink.TakeRateLimit(ctx, l.Name, key, l.Limit, l.Window, time.Now())
+		if err == nil {
+			return ok
+		}
+		log.Printf("error while checking rate limit %q - falling back to local limiter: %s", l.Name, err)
+	}
+	return l.localLimiter(key).Allow()
+}
+
+func (l *Limiter) localLimiter(key string) *rate.Limiter {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	limiter, ok := l.local[key]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Every(l.Window/time.Duration(l.Limit)), l.Limit)
+		l.local[key] = limiter
+	}
+	return limiter
+}
+
+// Wrap rejects requests to the handler once the limit has been reached.
+// All requests share a single key, so it's best suited for endpoints called by a small number of clients e.g. webhooks.
+func (l *Limiter) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !l.Allow(r.Context(), "") {
+			limitedRequests.WithLabelValues(l.Name).Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(l.Window.Seconds())))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,40 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalFallback(t *testing.T) {
+	ctx := context.Background()
+	l := New(nil, "test", 2, time.Minute)
+	assert.True(t, l.Allow(ctx, "foo"))
+	assert.True(t, l.Allow(ctx, "foo"))
+	assert.False(t, l.Allow(ctx, "foo"))
+	assert.True(t, l.Allow(ctx, "bar"), "keys are limited independently")
+}
+
+func TestDisabled(t *testing.T) {
+	l := New(nil, "test", 0, time.Minute)
+	for i := 0; i < 10; i++ {
+		assert.True(t, l.Allow(context.Background(), "foo"))
+	}
+}
+
+func TestWrap(t *testing.T) {
+	handler := New(nil, "test", 1, time.Minute).Wrap(func(w http.ResponseWriter, r *http.Request) {})
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", "/", nil))
+	assert.Equal(t, 200, w.Code)
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", "/", nil))
+	assert.Equal(t, 429, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+}
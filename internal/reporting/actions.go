@@ -0,0 +1,116 @@
+package reporting
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// AdminAction is a destructive change made by leadership that can be undone for a short time.
+type AdminAction struct {
+	ID            int64
+	Time          time.Time
+	Actor         string
+	Email         string
+	UserID        string
+	Kind          string
+	Previous      string // JSON snapshot of whatever is needed to undo the action
+	UndoExpiresAt time.Time
+	UndoneAt      time.Time // zero unless undone
+	AppliedAt     time.Time // set once deferred actions have actually been carried out
+}
+
+// Undoable returns true if the action can still be undone.
+func (a *AdminAction) Undoable(now time.Time) bool {
+	return a != nil && a.UndoneAt.IsZero() && a.AppliedAt.IsZero() && now.Before(a.UndoExpiresAt)
+}
+
+// AdminActionDeleteUser is deferred until the undo window has passed, at which point profile-async deletes the account.
+const AdminActionDeleteUser = "delete-user"
+
+const adminActionColumns = "id, time, actor, email, user_id, kind, previous, undo_expires_at, undone_at, applied_at"
+
+func (s *ReportingSink) RecordAdminAction(ctx context.Context, action *AdminAction) (int64, error) {
+	if !s.Enabled() {
+		return 0, nil
+	}
+	var id int64
+	err := s.db.QueryRow(ctx, "INSERT INTO admin_actions (time, actor, email, user_id, kind, previous, undo_expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id",
+		action.Time, action.Actor, action.Email, action.UserID, action.Kind, action.Previous, action.UndoExpiresAt).Scan(&id)
+	return id, err
+}
+
+// GetAdminAction returns the action, or nil if it doesn't exist.
+func (s *ReportingSink) GetAdminAction(ctx context.Context, id int64) (*AdminAction, error) {
+	if !s.Enabled() {
+		return nil, nil
+	}
+	action, err := scanAdminAction(s.db.QueryRow(ctx, "SELECT "+adminActionColumns+" FROM admin_actions WHERE id = $1", id))
+	if err != nil && strings.Contains(err.Error(), "no rows in result set") {
+		return nil, nil // errors.Is didn't work with the psql library for some reason
+	}
+	return action, err
+}
+
+// UndoAdminAction marks the action as undone, returning false if it's no longer undoable.
+// Callers are responsible for actually reverting the change once this succeeds.
+func (s *ReportingSink) UndoAdminAction(ctx context.Context, id int64, now time.Time) (bool, error) {
+	if !s.Enabled() {
+		return false, nil
+	}
+	tag, err := s.db.Exec(ctx, "UPDATE admin_actions SET undone_at = $2 WHERE id = $1 AND undone_at IS NULL AND applied_at IS NULL AND undo_expires_at > $2", id, now)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// ListDueAdminActions returns deferred actions of the given kind whose undo window has passed without being undone.
+func (s *ReportingSink) ListDueAdminActions(ctx context.Context, kind string, now time.Time) ([]*AdminAction, error) {
+	if !s.Enabled() {
+		return nil, nil
+	}
+	rows, err := s.db.Query(ctx, "SELECT "+adminActionColumns+" FROM admin_actions WHERE kind = $1 AND undone_at IS NULL AND applied_at IS NULL AND undo_expires_at <= $2 ORDER BY id", kind, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	actions := []*AdminAction{}
+	for rows.Next() {
+		action, err := scanAdminAction(rows)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, action)
+	}
+	return actions, rows.Err()
+}
+
+func (s *ReportingSink) MarkAdminActionApplied(ctx context.Context, id int64, now time.Time) error {
+	if !s.Enabled() {
+		return nil
+	}
+	_, err := s.db.Exec(ctx, "UPDATE admin_actions SET applied_at = $2 WHERE id = $1", id, now)
+	return err
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAdminAction(row rowScanner) (*AdminAction, error) {
+	action := &AdminAction{}
+	var undoneAt, appliedAt *time.Time
+	err := row.Scan(&action.ID, &action.Time, &action.Actor, &action.Email, &action.UserID, &action.Kind, &action.Previous, &action.UndoExpiresAt, &undoneAt, &appliedAt)
+	if err != nil {
+		return nil, err
+	}
+	if undoneAt != nil {
+		action.UndoneAt = *undoneAt
+	}
+	if appliedAt != nil {
+		action.AppliedAt = *appliedAt
+	}
+	return action, nil
+}
@@ -0,0 +1,18 @@
+package reporting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminActionUndoable(t *testing.T) {
+	now := time.Now()
+	var nilAction *AdminAction
+	assert.False(t, nilAction.Undoable(now))
+	assert.True(t, (&AdminAction{UndoExpiresAt: now.Add(time.Minute)}).Undoable(now))
+	assert.False(t, (&AdminAction{UndoExpiresAt: now.Add(-time.Minute)}).Undoable(now))
+	assert.False(t, (&AdminAction{UndoExpiresAt: now.Add(time.Minute), UndoneAt: now}).Undoable(now))
+	assert.False(t, (&AdminAction{UndoExpiresAt: now.Add(time.Minute), AppliedAt: now}).Undoable(now))
+}
@@ -0,0 +1,51 @@
+package reporting
+
+import (
+	"context"
+	"time"
+)
+
+// Attendance is a member checking in at an occurrence of an event by scanning the QR code projected there.
+type Attendance struct {
+	EventID       string
+	Occurrence    time.Time // start of the occurrence, since recurring events share an ID
+	EventName     string
+	Email         string
+	DiscordUserID int64 // 0 if the member hasn't linked Discord
+	Time          time.Time
+}
+
+// RecordAttendance checks the member in, returning false if they already were.
+func (s *ReportingSink) RecordAttendance(ctx context.Context, a *Attendance) (bool, error) {
+	if !s.Enabled() {
+		return false, nil
+	}
+	tag, err := s.db.Exec(ctx, `INSERT INTO event_attendance (event_id, occurrence, event_name, email, discord_user_id, time) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT DO NOTHING`, a.EventID, a.Occurrence.UTC(), a.EventName, a.Email, a.DiscordUserID, a.Time)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// ListAttendance returns check-ins at occurrences that started since the given time, newest occurrence first.
+func (s *ReportingSink) ListAttendance(ctx context.Context, since time.Time) ([]*Attendance, error) {
+	if !s.Enabled() {
+		return nil, nil
+	}
+	rows, err := s.db.Query(ctx, "SELECT event_id, occurrence, event_name, email, discord_user_id, time FROM event_attendance WHERE occurrence >= $1 ORDER BY occurrence DESC, time", since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attendance := []*Attendance{}
+	for rows.Next() {
+		a := &Attendance{}
+		if err := rows.Scan(&a.EventID, &a.Occurrence, &a.EventName, &a.Email, &a.DiscordUserID, &a.Time); err != nil {
+			return nil, err
+		}
+		attendance = append(attendance, a)
+	}
+	return attendance, rows.Err()
+}
@@ -0,0 +1,40 @@
+package reporting
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// IncidentBanner is shown to members while the site is degraded, so leadership can explain what's going on.
+type IncidentBanner struct {
+	Message   string
+	UpdatedBy string
+	UpdatedAt time.Time
+}
+
+// GetIncidentBanner returns the current banner, or nil if there isn't one.
+func (s *ReportingSink) GetIncidentBanner(ctx context.Context) (*IncidentBanner, error) {
+	if !s.Enabled() {
+		return nil, nil
+	}
+	b := &IncidentBanner{}
+	err := s.db.QueryRow(ctx, "SELECT message, updated_by, updated_at FROM incident_banner WHERE message != ''").Scan(&b.Message, &b.UpdatedBy, &b.UpdatedAt)
+	if err != nil && strings.Contains(err.Error(), "no rows in result set") {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// SetIncidentBanner replaces the banner. An empty message clears it.
+func (s *ReportingSink) SetIncidentBanner(ctx context.Context, b *IncidentBanner) error {
+	if !s.Enabled() {
+		return nil
+	}
+	_, err := s.db.Exec(ctx, `INSERT INTO incident_banner (id, message, updated_by, updated_at) VALUES (1, $1, $2, $3)
+		ON CONFLICT (id) DO UPDATE SET message = $1, updated_by = $2, updated_at = $3`, b.Message, b.UpdatedBy, b.UpdatedAt)
+	return err
+}
@@ -0,0 +1,46 @@
+package reporting
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// RefreshShared implements flowcontrol.Coordinator using an advisory lock, so one replica refreshes the
+// state while the others wait for it and then read the result. fetch is always called when reporting is disabled.
+func (s *ReportingSink) RefreshShared(ctx context.Context, name string, maxAge time.Duration, fetch func(context.Context) ([]byte, error)) ([]byte, error) {
+	if !s.Enabled() {
+		return fetch(ctx)
+	}
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext('shared_cache:' || $1))", name)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	var updated time.Time
+	err = tx.QueryRow(ctx, "SELECT data, updated_at FROM shared_caches WHERE name = $1", name).Scan(&data, &updated)
+	found := err == nil
+	if err != nil && !strings.Contains(err.Error(), "no rows in result set") {
+		return nil, err // errors.Is doesn't work with the psql library for some reason
+	}
+	if found && time.Since(updated) < maxAge {
+		return data, tx.Commit(ctx)
+	}
+
+	data, err = fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(ctx, "INSERT INTO shared_caches (name, updated_at, data) VALUES ($1, $2, $3) ON CONFLICT (name) DO UPDATE SET updated_at = $2, data = $3", name, time.Now(), string(data))
+	if err != nil {
+		return nil, err
+	}
+	return data, tx.Commit(ctx)
+}
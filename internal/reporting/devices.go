@@ -0,0 +1,82 @@
+package reporting
+
+import (
+	"context"
+	"time"
+)
+
+// Device is a door controller, kiosk, etc. that reports heartbeats to /webhooks/device-heartbeat.
+type Device struct {
+	ID              string
+	Kind            string // e.g. "door-controller" or "kiosk"
+	FirmwareVersion string
+	Status          string // free-form, as reported by the device
+	FirstSeen       time.Time
+	LastSeen        time.Time
+	SilentSince     time.Time // zero unless leadership has been alerted that the device went silent
+}
+
+// Silent returns true if the device hasn't reported a heartbeat within the threshold.
+func (d *Device) Silent(now time.Time, threshold time.Duration) bool {
+	return now.Sub(d.LastSeen) > threshold
+}
+
+// RecordDeviceHeartbeat upserts the device, bumping its last seen time.
+func (s *ReportingSink) RecordDeviceHeartbeat(ctx context.Context, d *Device) error {
+	if !s.Enabled() {
+		return nil
+	}
+	_, err := s.db.Exec(ctx, `INSERT INTO devices (id, kind, firmware_version, status, first_seen, last_seen) VALUES ($1, $2, $3, $4, $5, $5)
+		ON CONFLICT (id) DO UPDATE SET kind = $2, firmware_version = $3, status = $4, last_seen = GREATEST(devices.last_seen, excluded.last_seen)`,
+		d.ID, d.Kind, d.FirmwareVersion, d.Status, d.LastSeen)
+	return err
+}
+
+// ListDevices returns every device that has ever reported a heartbeat, by ID.
+func (s *ReportingSink) ListDevices(ctx context.Context) ([]*Device, error) {
+	if !s.Enabled() {
+		return nil, nil
+	}
+	rows, err := s.db.Query(ctx, "SELECT id, kind, firmware_version, status, first_seen, last_seen, silent_since FROM devices ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	devices := []*Device{}
+	for rows.Next() {
+		d := &Device{}
+		var silentSince *time.Time
+		if err := rows.Scan(&d.ID, &d.Kind, &d.FirmwareVersion, &d.Status, &d.FirstSeen, &d.LastSeen, &silentSince); err != nil {
+			return nil, err
+		}
+		if silentSince != nil {
+			d.SilentSince = *silentSince
+		}
+		devices = append(devices, d)
+	}
+	return devices, rows.Err()
+}
+
+// MarkDeviceSilent records that leadership was alerted about the device going silent, or clears it once the device
+// has recovered (when since is zero). It's stored so replicas don't alert about the same outage twice.
+func (s *ReportingSink) MarkDeviceSilent(ctx context.Context, id string, since time.Time) error {
+	if !s.Enabled() {
+		return nil
+	}
+	var silentSince *time.Time
+	if !since.IsZero() {
+		silentSince = &since
+	}
+	_, err := s.db.Exec(ctx, "UPDATE devices SET silent_since = $2 WHERE id = $1", id, silentSince)
+	return err
+}
+
+// DeleteDevice forgets a decommissioned device so it stops being alerted about.
+func (s *ReportingSink) DeleteDevice(ctx context.Context, id string) error {
+	if !s.Enabled() {
+		return nil
+	}
+	_, err := s.db.Exec(ctx, "DELETE FROM devices WHERE id = $1", id)
+	return err
+}
@@ -0,0 +1,20 @@
+package reporting
+
+import (
+	"context"
+	"time"
+)
+
+// ClaimEventReminder records that a reminder is about to be sent to an event's RSVP, returning false if it
+// already has been. Reminders are claimed before they're sent, so a crash may drop one but never sends it twice.
+func (s *ReportingSink) ClaimEventReminder(ctx context.Context, eventID string, occurrence time.Time, discordUserID int64, kind string) (bool, error) {
+	if !s.Enabled() {
+		return false, nil
+	}
+	tag, err := s.db.Exec(ctx, `INSERT INTO event_reminders (event_id, occurrence, discord_user_id, kind, sent_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT DO NOTHING`, eventID, occurrence.UTC(), discordUserID, kind, time.Now())
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
@@ -0,0 +1,52 @@
+package reporting
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// FeatureFlag is the persisted state of a feature flag. See internal/flags.
+type FeatureFlag struct {
+	Name        string
+	Description string
+	Percent     int      // 0-100
+	Emails      []string // always enabled for these members regardless of Percent
+	UpdatedBy   string
+	UpdatedAt   time.Time
+}
+
+func (s *ReportingSink) ListFeatureFlags(ctx context.Context) ([]*FeatureFlag, error) {
+	if !s.Enabled() {
+		return nil, nil
+	}
+	rows, err := s.db.Query(ctx, "SELECT name, description, percent, emails, updated_by, updated_at FROM feature_flags ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	flags := []*FeatureFlag{}
+	for rows.Next() {
+		flag := &FeatureFlag{}
+		var emails string
+		if err := rows.Scan(&flag.Name, &flag.Description, &flag.Percent, &emails, &flag.UpdatedBy, &flag.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if emails != "" {
+			flag.Emails = strings.Split(emails, ",")
+		}
+		flags = append(flags, flag)
+	}
+	return flags, rows.Err()
+}
+
+func (s *ReportingSink) PutFeatureFlag(ctx context.Context, flag *FeatureFlag) error {
+	if !s.Enabled() {
+		return nil
+	}
+	_, err := s.db.Exec(ctx, `INSERT INTO feature_flags (name, description, percent, emails, updated_by, updated_at) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (name) DO UPDATE SET description = $2, percent = $3, emails = $4, updated_by = $5, updated_at = $6`,
+		flag.Name, flag.Description, flag.Percent, strings.Join(flag.Emails, ","), flag.UpdatedBy, flag.UpdatedAt)
+	return err
+}
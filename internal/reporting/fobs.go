@@ -0,0 +1,40 @@
+package reporting
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// FobAssignment is a historical record of a fob being assigned to or unassigned from a member.
+type FobAssignment struct {
+	Time     time.Time
+	FobID    int
+	Email    string
+	Actor    string
+	Assigned bool
+}
+
+func (s *ReportingSink) RecordFobAssignment(ctx context.Context, a *FobAssignment) error {
+	if !s.Enabled() {
+		return nil
+	}
+	_, err := s.db.Exec(ctx, "INSERT INTO fob_assignments (time, fob_id, email, actor, assigned) VALUES ($1, $2, $3, $4, $5)", a.Time, a.FobID, a.Email, a.Actor, a.Assigned)
+	return err
+}
+
+// LastFobHolder returns the most recent assignment of the given fob, if it has ever been assigned.
+func (s *ReportingSink) LastFobHolder(ctx context.Context, fobID int) (*FobAssignment, bool, error) {
+	if !s.Enabled() {
+		return nil, false, nil
+	}
+	a := &FobAssignment{}
+	err := s.db.QueryRow(ctx, "SELECT time, fob_id, email, actor, assigned FROM fob_assignments WHERE fob_id = $1 AND assigned ORDER BY time DESC LIMIT 1", fobID).Scan(&a.Time, &a.FobID, &a.Email, &a.Actor, &a.Assigned)
+	if err != nil && strings.Contains(err.Error(), "no rows in result set") {
+		return nil, false, nil // errors.Is didn't work with the psql library for some reason
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return a, true, nil
+}
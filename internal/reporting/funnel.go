@@ -0,0 +1,60 @@
+package reporting
+
+import (
+	"context"
+	"time"
+)
+
+// FunnelStep is a stage of the signup funnel, identified by the event emitted when a person reaches it.
+type FunnelStep struct {
+	Title, Reason string
+	Anonymous     bool // events don't have an email, so every event is counted instead of distinct people
+}
+
+// FunnelSteps are the signup funnel stages in order.
+var FunnelSteps = []*FunnelStep{
+	{Title: "Viewed signup form", Reason: "SignupFormViewed", Anonymous: true},
+	{Title: "Registered", Reason: "Signup"},
+	{Title: "Verified email", Reason: "EmailVerified"},
+	{Title: "Signed waiver", Reason: "SignedWaiver"},
+	{Title: "Started checkout", Reason: "StartedStripeCheckout"},
+	{Title: "Payment active", Reason: "MembershipActivated"},
+}
+
+// CountFunnel returns the number of people (or events, for anonymous steps) that reached each funnel step within the period.
+// The counts are indexed the same as FunnelSteps.
+func (s *ReportingSink) CountFunnel(ctx context.Context, start, end time.Time) ([]int, error) {
+	counts := make([]int, len(FunnelSteps))
+	if !s.Enabled() {
+		return counts, nil
+	}
+
+	reasons := make([]string, len(FunnelSteps))
+	for i, step := range FunnelSteps {
+		reasons[i] = step.Reason
+	}
+	rows, err := s.db.Query(ctx, "SELECT reason, COUNT(*), COUNT(DISTINCT email) FROM profile_events WHERE reason = ANY($1) AND time >= $2 AND time < $3 GROUP BY reason", reasons, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var reason string
+		var events, people int
+		if err := rows.Scan(&reason, &events, &people); err != nil {
+			return nil, err
+		}
+		for i, step := range FunnelSteps {
+			if step.Reason != reason {
+				continue
+			}
+			if step.Anonymous {
+				counts[i] = events
+			} else {
+				counts[i] = people
+			}
+		}
+	}
+	return counts, rows.Err()
+}
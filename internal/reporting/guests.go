@@ -0,0 +1,107 @@
+package reporting
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Guest is a visit by a non-member, signed in at the door under the member hosting them.
+// Visits don't count until the host confirms them using the link sent to them with ConfirmToken.
+type Guest struct {
+	ID             int64
+	Time           time.Time
+	Name           string
+	Email          string
+	HostEmail      string
+	WaiverSignedAt *time.Time
+	ConfirmToken   string
+	ConfirmedAt    *time.Time
+}
+
+const guestColumns = "id, time, name, email, host_email, waiver_signed_at, confirm_token, confirmed_at"
+
+func scanGuest(row rowScanner) (*Guest, error) {
+	g := &Guest{}
+	var token *string
+	if err := row.Scan(&g.ID, &g.Time, &g.Name, &g.Email, &g.HostEmail, &g.WaiverSignedAt, &token, &g.ConfirmedAt); err != nil {
+		return nil, err
+	}
+	if token != nil {
+		g.ConfirmToken = *token
+	}
+	return g, nil
+}
+
+func (s *ReportingSink) RecordGuest(ctx context.Context, g *Guest) error {
+	if !s.Enabled() {
+		return nil
+	}
+	_, err := s.db.Exec(ctx, "INSERT INTO guests (time, name, email, host_email, confirm_token) VALUES ($1, $2, $3, $4, $5)", g.Time, g.Name, g.Email, g.HostEmail, g.ConfirmToken)
+	return err
+}
+
+// GetGuestByToken returns the visit with the given confirmation token, or nil if there isn't one.
+func (s *ReportingSink) GetGuestByToken(ctx context.Context, token string) (*Guest, error) {
+	if !s.Enabled() || token == "" {
+		return nil, nil
+	}
+	g, err := scanGuest(s.db.QueryRow(ctx, "SELECT "+guestColumns+" FROM guests WHERE confirm_token = $1", token))
+	if err != nil && strings.Contains(err.Error(), "no rows in result set") {
+		return nil, nil
+	}
+	return g, err
+}
+
+// ConfirmGuest records that the host confirmed the visit.
+func (s *ReportingSink) ConfirmGuest(ctx context.Context, id int64, at time.Time) error {
+	if !s.Enabled() {
+		return nil
+	}
+	_, err := s.db.Exec(ctx, "UPDATE guests SET confirmed_at = $1 WHERE id = $2 AND confirmed_at IS NULL", at, id)
+	return err
+}
+
+// CountGuests returns the number of confirmed guests signed in by the host since the given time.
+func (s *ReportingSink) CountGuests(ctx context.Context, hostEmail string, since time.Time) (int, error) {
+	if !s.Enabled() {
+		return 0, nil
+	}
+	var n int
+	return n, s.db.QueryRow(ctx, "SELECT COUNT(*) FROM guests WHERE host_email = $1 AND time >= $2 AND confirmed_at IS NOT NULL", hostEmail, since).Scan(&n)
+}
+
+// MarkGuestWaiverSigned records the guest's waiver on their visits that don't have one yet.
+// It returns false if the email doesn't belong to a guest.
+func (s *ReportingSink) MarkGuestWaiverSigned(ctx context.Context, email string, at time.Time) (bool, error) {
+	if !s.Enabled() {
+		return false, nil
+	}
+	tag, err := s.db.Exec(ctx, "UPDATE guests SET waiver_signed_at = $1 WHERE email = $2 AND waiver_signed_at IS NULL", at, email)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// ListGuests returns guests signed in since the given time, newest first.
+func (s *ReportingSink) ListGuests(ctx context.Context, since time.Time) ([]*Guest, error) {
+	if !s.Enabled() {
+		return nil, nil
+	}
+	rows, err := s.db.Query(ctx, "SELECT "+guestColumns+" FROM guests WHERE time >= $1 ORDER BY time DESC", since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	guests := []*Guest{}
+	for rows.Next() {
+		g, err := scanGuest(rows)
+		if err != nil {
+			return nil, err
+		}
+		guests = append(guests, g)
+	}
+	return guests, rows.Err()
+}
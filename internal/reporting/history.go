@@ -0,0 +1,50 @@
+package reporting
+
+import (
+	"context"
+
+	"github.com/TheLab-ms/profile/internal/keycloak"
+)
+
+// RecordAttributeChanges implements keycloak.AttributeHistory.
+func (s *ReportingSink) RecordAttributeChanges(ctx context.Context, changes []*keycloak.AttributeChange) error {
+	if !s.Enabled() {
+		return nil
+	}
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	for _, c := range changes {
+		_, err = tx.Exec(ctx, "INSERT INTO attribute_history (time, user_id, email, actor, attribute, old_value, new_value) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+			c.Time, c.UserID, c.Email, c.Actor, c.Attribute, c.Old, c.New)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// ListAttributeChanges returns the user's most recent attribute changes, newest first.
+func (s *ReportingSink) ListAttributeChanges(ctx context.Context, userID string, limit int) ([]*keycloak.AttributeChange, error) {
+	if !s.Enabled() {
+		return nil, nil
+	}
+	rows, err := s.db.Query(ctx, "SELECT time, user_id, email, actor, attribute, old_value, new_value FROM attribute_history WHERE user_id = $1 ORDER BY time DESC, id DESC LIMIT $2", userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []*keycloak.AttributeChange{}
+	for rows.Next() {
+		c := &keycloak.AttributeChange{}
+		if err := rows.Scan(&c.Time, &c.UserID, &c.Email, &c.Actor, &c.Attribute, &c.Old, &c.New); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
@@ -0,0 +1,105 @@
+package reporting
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/TheLab-ms/profile/internal/flowcontrol"
+)
+
+// Leadership elects a single leader among replicas by holding a session-level advisory lock.
+// Postgres releases the lock when the session ends, so a crashed leader is replaced once its connection times out.
+// It implements flowcontrol.Elector.
+type Leadership struct {
+	Name     string
+	Interval time.Duration // how often the lock is acquired or the session is confirmed alive
+
+	db     *pgxpool.Pool // nil when reporting is disabled
+	mut    sync.Mutex
+	conn   *pgxpool.Conn
+	leader atomic.Bool
+}
+
+// NewLeadership returns an election for the given name. When reporting is disabled there's nothing to coordinate
+// with, so the replica is always the leader.
+func (s *ReportingSink) NewLeadership(name string) *Leadership {
+	l := &Leadership{Name: name, Interval: time.Second * 10}
+	if !s.Enabled() {
+		l.leader.Store(true)
+		return l
+	}
+	l.db = s.db.Pool
+	return l
+}
+
+func (l *Leadership) Leader() bool { return l.leader.Load() }
+
+// Run campaigns for leadership until the context is canceled, then steps down.
+func (l *Leadership) Run(ctx context.Context) {
+	if l.db == nil {
+		return
+	}
+	defer l.stepDown()
+
+	(&flowcontrol.Loop{
+		Handler: func(ctx context.Context) time.Duration {
+			if err := l.campaign(ctx); err != nil {
+				log.Printf("error while campaigning for %q leadership: %s", l.Name, err)
+				l.stepDown()
+			}
+			return l.Interval
+		},
+	}).Run(ctx)
+}
+
+func (l *Leadership) campaign(ctx context.Context) error {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if l.conn == nil {
+		conn, err := l.db.Acquire(ctx)
+		if err != nil {
+			return err
+		}
+		l.conn = conn
+	}
+
+	// The lock is held for as long as the session is, so the leader only needs to make sure it's still connected
+	if l.leader.Load() {
+		_, err := l.conn.Exec(ctx, "SELECT 1")
+		return err
+	}
+
+	var acquired bool
+	err := l.conn.QueryRow(ctx, "SELECT pg_try_advisory_lock(hashtext('leader:' || $1))", l.Name).Scan(&acquired)
+	if err != nil {
+		return err
+	}
+	if acquired {
+		log.Printf("became the %q leader", l.Name)
+		l.leader.Store(true)
+	}
+	return nil
+}
+
+// stepDown gives up leadership by closing the session, which releases the lock even if the connection is unhealthy.
+func (l *Leadership) stepDown() {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if l.leader.Swap(false) {
+		log.Printf("no longer the %q leader", l.Name)
+	}
+	if l.conn != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		l.conn.Conn().Close(ctx)
+		l.conn.Release()
+		l.conn = nil
+	}
+}
@@ -0,0 +1,26 @@
+package reporting
+
+import "context"
+
+// WithLock runs fn while holding an advisory lock on the name, so check-then-write sequences against Keycloak
+// can't interleave across replicas e.g. two admins renting out the same locker. fn is called without the lock when
+// reporting is disabled.
+func (s *ReportingSink) WithLock(ctx context.Context, name string, fn func(context.Context) error) error {
+	if !s.Enabled() {
+		return fn(ctx)
+	}
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext('lock:' || $1))", name)
+	if err != nil {
+		return err
+	}
+	if err := fn(ctx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
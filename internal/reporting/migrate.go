@@ -0,0 +1,142 @@
+package reporting
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Schema changes are applied in order from numbered files e.g. 0003_add_some_table.sql.
+// Migrations are up-only: add a new file rather than editing one that has already shipped.
+// Each applied version is recorded, so files are applied even when they're numbered below ones that already ran.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Arbitrary key used to keep replicas from running migrations concurrently
+const migrationLockID = 8675309
+
+type migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+func migrate(ctx context.Context, db *pgxpool.Pool) error {
+	migrations, err := parseMigrations(migrationFiles)
+	if err != nil {
+		return err
+	}
+
+	conn, err := db.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLockID)
+	if err != nil {
+		return fmt.Errorf("acquiring migration lock: %w", err)
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID)
+
+	_, err = conn.Exec(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations (version int primary key, name text not null, applied_at timestamp not null default now())")
+	if err != nil {
+		return fmt.Errorf("creating migrations table: %w", err)
+	}
+
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("listing applied migrations: %w", err)
+	}
+
+	for _, m := range pendingMigrations(migrations, applied) {
+		tx, err := conn.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, m.SQL); err != nil {
+			tx.Rollback(ctx)
+			return fmt.Errorf("applying migration %s: %w", m.Name, err)
+		}
+		if _, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name); err != nil {
+			tx.Rollback(ctx)
+			return fmt.Errorf("recording migration %s: %w", m.Name, err)
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return fmt.Errorf("committing migration %s: %w", m.Name, err)
+		}
+		log.Printf("applied db migration %s", m.Name)
+	}
+
+	return nil
+}
+
+func appliedMigrations(ctx context.Context, conn *pgxpool.Conn) (map[int]bool, error) {
+	rows, err := conn.Query(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]bool{}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+// pendingMigrations returns every migration that hasn't been applied, in order. Versions below the latest applied
+// one are included, since branches that were merged in a different order than they were numbered may have added them.
+func pendingMigrations(migrations []*migration, applied map[int]bool) []*migration {
+	pending := []*migration{}
+	for _, m := range migrations {
+		if !applied[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending
+}
+
+func parseMigrations(fsys fs.FS) ([]*migration, error) {
+	files, err := fs.Glob(fsys, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := []*migration{}
+	seen := map[int]string{}
+	for _, file := range files {
+		name := path.Base(file)
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %q must start with a positive version number", name)
+		}
+		if prev, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrations %q and %q have the same version", prev, name)
+		}
+		seen[version] = name
+
+		sql, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, &migration{Version: version, Name: name, SQL: string(sql)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
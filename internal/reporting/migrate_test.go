@@ -0,0 +1,50 @@
+package reporting
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/0010_third.sql":  {Data: []byte("three")},
+		"migrations/0001_first.sql":  {Data: []byte("one")},
+		"migrations/0002_second.sql": {Data: []byte("two")},
+	}
+
+	migrations, err := parseMigrations(fsys)
+	require.NoError(t, err)
+	require.Len(t, migrations, 3)
+	assert.Equal(t, &migration{Version: 1, Name: "0001_first.sql", SQL: "one"}, migrations[0])
+	assert.Equal(t, 2, migrations[1].Version)
+	assert.Equal(t, 10, migrations[2].Version)
+
+	// Duplicate versions
+	fsys["migrations/0002_another.sql"] = &fstest.MapFile{Data: []byte("dupe")}
+	_, err = parseMigrations(fsys)
+	assert.Error(t, err)
+
+	// Missing version
+	_, err = parseMigrations(fstest.MapFS{"migrations/first.sql": {}})
+	assert.Error(t, err)
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := parseMigrations(migrationFiles)
+	require.NoError(t, err)
+	assert.NotEmpty(t, migrations)
+}
+
+func TestPendingMigrations(t *testing.T) {
+	migrations := []*migration{{Version: 1}, {Version: 2}, {Version: 3}, {Version: 4}}
+
+	// e.g. 0003 was merged after 0004 had already been deployed
+	pending := pendingMigrations(migrations, map[int]bool{1: true, 2: true, 4: true})
+	assert.Equal(t, []*migration{{Version: 3}}, pending)
+
+	assert.Equal(t, migrations, pendingMigrations(migrations, map[int]bool{}))
+	assert.Empty(t, pendingMigrations(migrations, map[int]bool{1: true, 2: true, 3: true, 4: true}))
+}
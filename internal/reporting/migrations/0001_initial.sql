@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS profile_events (
+	id serial primary key,
+	time timestamp not null,
+	email text not null,
+	reason text not null,
+	message text not null
+);
+
+CREATE INDEX IF NOT EXISTS idx_profile_events_time ON profile_events (time);
+
+CREATE TABLE IF NOT EXISTS profile_metrics (
+	id serial primary key,
+	time timestamp not null,
+	active_members int not null,
+	inactive_members int not null
+);
+
+CREATE INDEX IF NOT EXISTS idx_profile_metrics_time ON profile_metrics (time);
+ALTER TABLE profile_metrics ADD COLUMN IF NOT EXISTS unverified_accounts int;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS fob_assignments (
+	id serial primary key,
+	time timestamp not null,
+	fob_id int not null,
+	email text not null,
+	actor text not null,
+	assigned boolean not null
+);
+
+CREATE INDEX IF NOT EXISTS idx_fob_assignments_fob_id ON fob_assignments (fob_id);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS feature_flags (
+	name text primary key,
+	description text not null default '',
+	percent int not null default 0,
+	emails text not null default '',
+	updated_by text not null,
+	updated_at timestamp not null
+);
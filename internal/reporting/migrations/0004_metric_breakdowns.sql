@@ -0,0 +1,5 @@
+ALTER TABLE profile_metrics ADD COLUMN IF NOT EXISTS stripe_members int;
+ALTER TABLE profile_metrics ADD COLUMN IF NOT EXISTS paypal_members int;
+ALTER TABLE profile_metrics ADD COLUMN IF NOT EXISTS non_billable_members int;
+ALTER TABLE profile_metrics ADD COLUMN IF NOT EXISTS building_access_members int;
+ALTER TABLE profile_metrics ADD COLUMN IF NOT EXISTS discount_types jsonb;
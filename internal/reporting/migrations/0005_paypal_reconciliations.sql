@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS paypal_reconciliations (
+	id serial primary key,
+	started_at timestamp not null,
+	finished_at timestamp not null,
+	checked int not null,
+	deactivated int not null,
+	updated int not null,
+	price_mismatches int not null,
+	not_found int not null,
+	api_errors int not null,
+	remaining int not null
+);
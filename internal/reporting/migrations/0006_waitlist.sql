@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS waitlist (
+	email text primary key,
+	joined_at timestamp not null,
+	invited_at timestamp,
+	invite_expires_at timestamp
+);
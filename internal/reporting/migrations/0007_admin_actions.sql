@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS admin_actions (
+	id serial primary key,
+	time timestamp not null,
+	actor text not null,
+	email text not null,
+	user_id text not null,
+	kind text not null,
+	previous text not null,
+	undo_expires_at timestamp not null,
+	undone_at timestamp,
+	applied_at timestamp
+);
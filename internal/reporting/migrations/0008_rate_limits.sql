@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS rate_limit_events (
+	name text not null,
+	key text not null,
+	time timestamp not null
+);
+CREATE INDEX IF NOT EXISTS rate_limit_events_name_key_time ON rate_limit_events (name, key, time);
@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS swipe_alert_checkpoints (
+	time timestamp not null,
+	last_swipe_id bigint not null
+);
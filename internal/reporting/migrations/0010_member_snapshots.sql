@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS member_snapshots (
+	date date not null,
+	user_id text not null,
+	email text not null,
+	active boolean not null,
+	tier text not null,
+	discount_type text not null,
+	payment_status text not null,
+	building_access boolean not null,
+	primary key (date, user_id)
+);
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS shared_caches (
+	name text primary key,
+	updated_at timestamp not null,
+	data jsonb not null
+);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS deletion_quarantine (
+	user_id text primary key,
+	email text not null,
+	reason text not null,
+	signup_time timestamp not null,
+	checked_at timestamp not null
+);
+
+CREATE INDEX IF NOT EXISTS idx_profile_events_email ON profile_events (email);
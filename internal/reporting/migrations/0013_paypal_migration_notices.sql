@@ -0,0 +1 @@
+ALTER TABLE paypal_reconciliations ADD COLUMN IF NOT EXISTS notified int not null default 0;
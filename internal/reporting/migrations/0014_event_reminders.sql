@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS event_reminders (
+	event_id text not null,
+	occurrence timestamp not null,
+	discord_user_id bigint not null,
+	kind text not null,
+	sent_at timestamp not null,
+	PRIMARY KEY (event_id, occurrence, discord_user_id, kind)
+);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS webhook_failures (
+	id serial primary key,
+	source text not null,
+	received_at timestamp not null,
+	headers text not null,
+	payload text not null,
+	status int not null,
+	error text not null,
+	replayed_at timestamp,
+	replayed_by text,
+	resolved_at timestamp
+);
@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS resync_watermarks (
+	name text primary key,
+	completed_at timestamp not null
+);
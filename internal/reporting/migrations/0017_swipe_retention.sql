@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS swipe_daily_counts (
+	date date not null,
+	fob_id bigint not null,
+	name text not null,
+	swipes int not null,
+	primary key (date, fob_id)
+);
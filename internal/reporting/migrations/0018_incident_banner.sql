@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS incident_banner (
+	id int primary key default 1 check (id = 1),
+	message text not null,
+	updated_by text not null,
+	updated_at timestamp not null
+);
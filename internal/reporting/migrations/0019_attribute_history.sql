@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS attribute_history (
+	id serial primary key,
+	time timestamp not null,
+	user_id text not null,
+	email text not null,
+	actor text not null,
+	attribute text not null,
+	old_value text not null,
+	new_value text not null
+);
+
+CREATE INDEX IF NOT EXISTS idx_attribute_history_user_id ON attribute_history (user_id, time);
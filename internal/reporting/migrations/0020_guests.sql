@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS guests (
+	id serial primary key,
+	time timestamp not null,
+	name text not null,
+	email text not null,
+	host_email text not null,
+	waiver_signed_at timestamp
+);
+
+CREATE INDEX IF NOT EXISTS idx_guests_host_email ON guests (host_email, time);
+CREATE INDEX IF NOT EXISTS idx_guests_email ON guests (email);
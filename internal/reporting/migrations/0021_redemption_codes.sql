@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS redemption_codes (
+	code text primary key,
+	discount_type text not null default '',
+	promotion_code text not null default '',
+	max_uses integer not null default 0,
+	uses integer not null default 0,
+	expires timestamp,
+	disabled boolean not null default false,
+	created_by text not null,
+	created_at timestamp not null
+);
+
+CREATE TABLE IF NOT EXISTS code_redemptions (
+	code text not null,
+	email text not null,
+	time timestamp not null,
+	primary key (code, email)
+);
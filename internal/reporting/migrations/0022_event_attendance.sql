@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS event_attendance (
+	event_id text not null,
+	occurrence timestamp not null,
+	event_name text not null,
+	email text not null,
+	discord_user_id bigint not null,
+	time timestamp not null,
+	PRIMARY KEY (event_id, occurrence, email)
+);
+
+CREATE INDEX IF NOT EXISTS idx_event_attendance_occurrence ON event_attendance (occurrence);
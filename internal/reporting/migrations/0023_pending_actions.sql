@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS pending_actions (
+	kind text not null,
+	user_id text not null,
+	email text not null,
+	reason text not null,
+	created timestamp not null,
+	applies_at timestamp not null,
+	exempted_by text not null default '',
+	exempt_reason text not null default '',
+	exempted_at timestamp,
+	applied_at timestamp,
+	primary key (kind, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_pending_actions_email ON pending_actions (email);
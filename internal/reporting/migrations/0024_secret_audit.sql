@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS secret_audit (
+	id serial primary key,
+	time timestamp not null,
+	operation text not null,
+	actor text not null,
+	description text not null,
+	recipient text not null default '',
+	encrypted_by text not null default '',
+	encrypted_at timestamp
+);
+
+CREATE INDEX IF NOT EXISTS idx_secret_audit_time ON secret_audit (time);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS devices (
+	id text primary key,
+	kind text not null default '',
+	firmware_version text not null default '',
+	status text not null default '',
+	first_seen timestamp not null,
+	last_seen timestamp not null,
+	silent_since timestamp
+);
@@ -0,0 +1,7 @@
+ALTER TABLE guests ADD COLUMN IF NOT EXISTS confirm_token text;
+ALTER TABLE guests ADD COLUMN IF NOT EXISTS confirmed_at timestamp;
+
+-- Visits from before hosts had to confirm them
+UPDATE guests SET confirmed_at = time WHERE confirmed_at IS NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_guests_confirm_token ON guests (confirm_token);
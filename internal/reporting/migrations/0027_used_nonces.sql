@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS used_nonces (
+	scope text not null,
+	nonce text not null,
+	expires_at timestamp not null,
+	primary key (scope, nonce)
+);
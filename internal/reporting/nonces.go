@@ -0,0 +1,23 @@
+package reporting
+
+import (
+	"context"
+	"time"
+)
+
+// UseNonce records a single-use value, returning false if it has already been used within the scope.
+// Nonces only need to be remembered until the signed value they're part of expires.
+func (s *ReportingSink) UseNonce(ctx context.Context, scope, nonce string, expiration, now time.Time) (bool, error) {
+	if !s.Enabled() {
+		return true, nil
+	}
+	_, err := s.db.Exec(ctx, "DELETE FROM used_nonces WHERE scope = $1 AND expires_at < $2", scope, now)
+	if err != nil {
+		return false, err
+	}
+	tag, err := s.db.Exec(ctx, "INSERT INTO used_nonces (scope, nonce, expires_at) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING", scope, nonce, expiration)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
@@ -0,0 +1,52 @@
+package reporting
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PaypalReconciliation summarizes a single run of the paypal-check-job.
+type PaypalReconciliation struct {
+	Start, End      time.Time
+	Checked         int // active members with a Paypal subscription
+	Deactivated     int // subscription was canceled on Paypal's end
+	Updated         int // payment metadata changed
+	PriceMismatches int // subset of Updated where the price differed from what we had on record
+	NotFound        int // subscription doesn't exist in Paypal
+	APIErrors       int
+	Remaining       int // members still paying through Paypal after this run
+	Notified        int // members sent a migration notice (see conf.Env.PaypalMigrationCutoff)
+}
+
+func (r *PaypalReconciliation) String() string {
+	return fmt.Sprintf("checked=%d deactivated=%d updated=%d price_mismatches=%d not_found=%d api_errors=%d remaining=%d notified=%d",
+		r.Checked, r.Deactivated, r.Updated, r.PriceMismatches, r.NotFound, r.APIErrors, r.Remaining, r.Notified)
+}
+
+func (s *ReportingSink) RecordPaypalReconciliation(ctx context.Context, r *PaypalReconciliation) error {
+	if !s.Enabled() {
+		return nil
+	}
+	_, err := s.db.Exec(ctx, "INSERT INTO paypal_reconciliations (started_at, finished_at, checked, deactivated, updated, price_mismatches, not_found, api_errors, remaining, notified) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)",
+		r.Start, r.End, r.Checked, r.Deactivated, r.Updated, r.PriceMismatches, r.NotFound, r.APIErrors, r.Remaining, r.Notified)
+	return err
+}
+
+// LastPaypalReconciliation returns the most recent run, or nil if there hasn't been one.
+func (s *ReportingSink) LastPaypalReconciliation(ctx context.Context) (*PaypalReconciliation, error) {
+	if !s.Enabled() {
+		return nil, nil
+	}
+	r := &PaypalReconciliation{}
+	err := s.db.QueryRow(ctx, "SELECT started_at, finished_at, checked, deactivated, updated, price_mismatches, not_found, api_errors, remaining, notified FROM paypal_reconciliations ORDER BY started_at DESC LIMIT 1").
+		Scan(&r.Start, &r.End, &r.Checked, &r.Deactivated, &r.Updated, &r.PriceMismatches, &r.NotFound, &r.APIErrors, &r.Remaining, &r.Notified)
+	if err != nil && strings.Contains(err.Error(), "no rows in result set") {
+		return nil, nil // errors.Is doesn't work with the psql library for some reason
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
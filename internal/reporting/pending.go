@@ -0,0 +1,103 @@
+package reporting
+
+import (
+	"context"
+	"time"
+)
+
+// PendingAction is a change that a job wants to make to a member, held for leadership to review before it takes
+// effect. There's at most one per kind and member - scheduling another replaces it.
+type PendingAction struct {
+	Kind      string
+	UserID    string
+	Email     string
+	Reason    string // why the job wants to make the change e.g. "last visit was 200 days ago"
+	Created   time.Time
+	AppliesAt time.Time
+
+	// Leadership can exempt the member from the action instead of letting it apply.
+	ExemptedBy   string
+	ExemptReason string
+	ExemptedAt   time.Time // zero unless exempted
+
+	AppliedAt time.Time // zero until the job carries the action out
+}
+
+// Open returns true if the action is still waiting to be applied or exempted.
+func (a *PendingAction) Open() bool { return a.ExemptedAt.IsZero() && a.AppliedAt.IsZero() }
+
+// PendingRevokeAccess revokes building access approval from members who haven't visited in a while (see visit-check).
+const PendingRevokeAccess = "revoke-access"
+
+const pendingActionColumns = "kind, user_id, email, reason, created, applies_at, exempted_by, exempt_reason, exempted_at, applied_at"
+
+// ListPendingActions returns every action of the given kind, including the exempted and applied ones, soonest first.
+func (s *ReportingSink) ListPendingActions(ctx context.Context, kind string) ([]*PendingAction, error) {
+	if !s.Enabled() {
+		return nil, nil
+	}
+	rows, err := s.db.Query(ctx, "SELECT "+pendingActionColumns+" FROM pending_actions WHERE kind = $1 ORDER BY applies_at, email", kind)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	actions := []*PendingAction{}
+	for rows.Next() {
+		a := &PendingAction{}
+		var exemptedAt, appliedAt *time.Time
+		err := rows.Scan(&a.Kind, &a.UserID, &a.Email, &a.Reason, &a.Created, &a.AppliesAt, &a.ExemptedBy, &a.ExemptReason, &exemptedAt, &appliedAt)
+		if err != nil {
+			return nil, err
+		}
+		if exemptedAt != nil {
+			a.ExemptedAt = *exemptedAt
+		}
+		if appliedAt != nil {
+			a.AppliedAt = *appliedAt
+		}
+		actions = append(actions, a)
+	}
+	return actions, rows.Err()
+}
+
+// SchedulePendingAction opens the action for review, replacing any previous action of the same kind for the member.
+func (s *ReportingSink) SchedulePendingAction(ctx context.Context, a *PendingAction) error {
+	if !s.Enabled() {
+		return nil
+	}
+	_, err := s.db.Exec(ctx, `INSERT INTO pending_actions (kind, user_id, email, reason, created, applies_at) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (kind, user_id) DO UPDATE SET email = $3, reason = $4, created = $5, applies_at = $6, exempted_by = '', exempt_reason = '', exempted_at = NULL, applied_at = NULL`,
+		a.Kind, a.UserID, a.Email, a.Reason, a.Created, a.AppliesAt)
+	return err
+}
+
+// ExemptPendingAction keeps the action from being applied, returning false if it's no longer open.
+func (s *ReportingSink) ExemptPendingAction(ctx context.Context, kind, userID, actor, reason string, now time.Time) (bool, error) {
+	if !s.Enabled() {
+		return false, nil
+	}
+	tag, err := s.db.Exec(ctx, "UPDATE pending_actions SET exempted_by = $3, exempt_reason = $4, exempted_at = $5 WHERE kind = $1 AND user_id = $2 AND exempted_at IS NULL AND applied_at IS NULL",
+		kind, userID, actor, reason, now)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (s *ReportingSink) MarkPendingActionApplied(ctx context.Context, kind, userID string, now time.Time) error {
+	if !s.Enabled() {
+		return nil
+	}
+	_, err := s.db.Exec(ctx, "UPDATE pending_actions SET applied_at = $3 WHERE kind = $1 AND user_id = $2", kind, userID, now)
+	return err
+}
+
+// CancelPendingAction drops an open action that no longer applies e.g. because the member visited during the review.
+func (s *ReportingSink) CancelPendingAction(ctx context.Context, kind, userID string) error {
+	if !s.Enabled() {
+		return nil
+	}
+	_, err := s.db.Exec(ctx, "DELETE FROM pending_actions WHERE kind = $1 AND user_id = $2 AND exempted_at IS NULL AND applied_at IS NULL", kind, userID)
+	return err
+}
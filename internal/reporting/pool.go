@@ -0,0 +1,179 @@
+package reporting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/TheLab-ms/profile/internal/conf"
+	"github.com/TheLab-ms/profile/internal/flowcontrol"
+)
+
+var (
+	dbUpGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "profile_reporting_db_up",
+		Help: "1 if the last ping of the reporting database succeeded",
+	})
+	dbConnsGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "profile_reporting_db_conns",
+		Help: "Connections in the reporting database pool by state (acquired, idle, max)",
+	}, []string{"state"})
+	dbAcquireWaitGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "profile_reporting_db_acquire_wait_seconds",
+		Help: "Cumulative time spent waiting for a connection from the reporting database pool",
+	})
+	dbTimeoutCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "profile_reporting_db_query_timeouts_total",
+		Help: "Reporting database queries that hit EVENT_PSQL_QUERY_TIMEOUT",
+	})
+)
+
+// pool bounds every query with a timeout so a stalled database can't hang callers (and their requests) forever.
+// Statements inside transactions are bounded by the server-side statement_timeout instead.
+type pool struct {
+	*pgxpool.Pool
+	timeout time.Duration
+
+	healthy atomic.Bool
+}
+
+func connect(ctx context.Context, env *conf.Env) (*pool, error) {
+	config, err := pgxpool.ParseConfig(fmt.Sprintf("user=%s password=%s host=%s port=5432 dbname=postgres", env.EventPsqlUsername, env.EventPsqlPassword, env.EventPsqlAddr))
+	if err != nil {
+		return nil, err
+	}
+	if env.EventPsqlMaxConns > 0 {
+		config.MaxConns = env.EventPsqlMaxConns
+	}
+	config.MinConns = env.EventPsqlMinConns
+	if env.EventPsqlQueryTimeout > 0 {
+		config.ConnConfig.ConnectTimeout = env.EventPsqlQueryTimeout
+		config.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(env.EventPsqlQueryTimeout.Milliseconds(), 10)
+	}
+
+	db, err := pgxpool.ConnectConfig(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	p := &pool{Pool: db, timeout: env.EventPsqlQueryTimeout}
+	p.healthy.Store(true)
+	return p, nil
+}
+
+func (p *pool) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, p.timeout)
+}
+
+func (p *pool) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	tag, err := p.Pool.Exec(ctx, sql, args...)
+	return tag, countTimeout(ctx, err)
+}
+
+func (p *pool) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	rows, err := p.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		cancel()
+		return nil, countTimeout(ctx, err)
+	}
+	return &timeoutRows{Rows: rows, ctx: ctx, cancel: cancel}, nil
+}
+
+func (p *pool) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	ctx, cancel := p.withTimeout(ctx)
+	return &timeoutRow{row: p.Pool.QueryRow(ctx, sql, args...), ctx: ctx, cancel: cancel}
+}
+
+func (p *pool) Begin(ctx context.Context) (pgx.Tx, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	tx, err := p.Pool.Begin(ctx)
+	return tx, countTimeout(ctx, err)
+}
+
+// timeoutRows releases the query's timeout once the rows are closed.
+type timeoutRows struct {
+	pgx.Rows
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (r *timeoutRows) Close() {
+	r.Rows.Close()
+	r.cancel()
+}
+
+func (r *timeoutRows) Err() error { return countTimeout(r.ctx, r.Rows.Err()) }
+
+// timeoutRow releases the query's timeout once the row is scanned.
+type timeoutRow struct {
+	row    pgx.Row
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (r *timeoutRow) Scan(dest ...any) error {
+	defer r.cancel()
+	return countTimeout(r.ctx, r.row.Scan(dest...))
+}
+
+func countTimeout(ctx context.Context, err error) error {
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		dbTimeoutCounter.Inc()
+	}
+	return err
+}
+
+// monitor pings the database until the context is canceled, exporting the pool's stats.
+func (p *pool) monitor(ctx context.Context, interval time.Duration) {
+	(&flowcontrol.Loop{
+		Handler: func(ctx context.Context) time.Duration {
+			p.check(ctx)
+			return interval
+		},
+	}).Run(ctx)
+}
+
+// check pings the database. Idle connections are closed when the ping fails, so queries made after the database comes
+// back dial new connections instead of each finding out that its connection is dead.
+func (p *pool) check(ctx context.Context) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	err := p.Ping(ctx)
+	wasHealthy := p.healthy.Swap(err == nil)
+	if err != nil {
+		log.Printf("error while pinging the reporting database: %s", err)
+		for _, conn := range p.AcquireAllIdle(ctx) {
+			conn.Conn().Close(ctx)
+			conn.Release()
+		}
+		dbUpGauge.Set(0)
+	} else {
+		if !wasHealthy {
+			log.Printf("reconnected to the reporting database")
+		}
+		dbUpGauge.Set(1)
+	}
+
+	stat := p.Stat()
+	dbConnsGauge.WithLabelValues("acquired").Set(float64(stat.AcquiredConns()))
+	dbConnsGauge.WithLabelValues("idle").Set(float64(stat.IdleConns()))
+	dbConnsGauge.WithLabelValues("max").Set(float64(stat.MaxConns()))
+	dbAcquireWaitGauge.Set(stat.AcquireDuration().Seconds())
+}
@@ -0,0 +1,43 @@
+package reporting
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoolTimeout(t *testing.T) {
+	p := &pool{timeout: time.Millisecond}
+	ctx, cancel := p.withTimeout(context.Background())
+	defer cancel()
+	deadline, ok := ctx.Deadline()
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now(), deadline, time.Second)
+
+	// Disabled
+	p.timeout = 0
+	ctx, cancel = p.withTimeout(context.Background())
+	defer cancel()
+	_, ok = ctx.Deadline()
+	assert.False(t, ok)
+}
+
+func TestTimeoutRow(t *testing.T) {
+	// Scanning releases the timeout
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	row := &timeoutRow{row: &fakeRow{}, ctx: ctx, cancel: cancel}
+	assert.NoError(t, row.Scan())
+	assert.Equal(t, context.Canceled, ctx.Err())
+
+	// Errors are passed through
+	ctx, cancel = context.WithTimeout(context.Background(), 0)
+	row = &timeoutRow{row: &fakeRow{err: errors.New("timeout: context deadline exceeded")}, ctx: ctx, cancel: cancel}
+	assert.EqualError(t, row.Scan(), "timeout: context deadline exceeded")
+}
+
+type fakeRow struct{ err error }
+
+func (r *fakeRow) Scan(dest ...any) error { return r.err }
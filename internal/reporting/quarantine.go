@@ -0,0 +1,84 @@
+package reporting
+
+import (
+	"context"
+	"time"
+)
+
+// PaymentEventReasons are the events that show an account has been involved in payments.
+// Accounts with any of them are never cleaned up automatically.
+var PaymentEventReasons = []string{
+	"StartedStripeCheckout",
+	"MembershipActivated",
+	"StripeSubscriptionChanged",
+	"StripeSubscriptionCanceled",
+	"StripeGracePeriodStarted",
+	"CanceledPaypal",
+	"PayPalSubscriptionCanceled",
+}
+
+// QuarantinedAccount is an account that matched the cleanup criteria but was protected from deletion.
+type QuarantinedAccount struct {
+	UserID     string
+	Email      string
+	Reason     string
+	SignupTime time.Time
+	CheckedAt  time.Time
+}
+
+// HasPaymentEvents returns true if any payment events have been recorded for the email.
+func (s *ReportingSink) HasPaymentEvents(ctx context.Context, email string) (bool, error) {
+	if !s.Enabled() {
+		return false, nil
+	}
+	var found bool
+	err := s.db.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM profile_events WHERE email = $1 AND reason = ANY($2))", email, PaymentEventReasons).Scan(&found)
+	return found, err
+}
+
+// ReplaceQuarantine replaces the quarantine list with the results of the latest cleanup, so accounts drop off of
+// it once they're confirmed or removed by leadership.
+func (s *ReportingSink) ReplaceQuarantine(ctx context.Context, accounts []*QuarantinedAccount) error {
+	if !s.Enabled() {
+		return nil
+	}
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, "DELETE FROM deletion_quarantine")
+	if err != nil {
+		return err
+	}
+	for _, a := range accounts {
+		_, err = tx.Exec(ctx, "INSERT INTO deletion_quarantine (user_id, email, reason, signup_time, checked_at) VALUES ($1, $2, $3, $4, $5)",
+			a.UserID, a.Email, a.Reason, a.SignupTime, a.CheckedAt)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+func (s *ReportingSink) ListQuarantine(ctx context.Context) ([]*QuarantinedAccount, error) {
+	if !s.Enabled() {
+		return nil, nil
+	}
+	rows, err := s.db.Query(ctx, "SELECT user_id, email, reason, signup_time, checked_at FROM deletion_quarantine ORDER BY signup_time")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []*QuarantinedAccount{}
+	for rows.Next() {
+		a := &QuarantinedAccount{}
+		if err := rows.Scan(&a.UserID, &a.Email, &a.Reason, &a.SignupTime, &a.CheckedAt); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts, rows.Err()
+}
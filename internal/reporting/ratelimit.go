@@ -0,0 +1,47 @@
+package reporting
+
+import (
+	"context"
+	"time"
+)
+
+// TakeRateLimit records an event against the named limit and returns false (without recording it) if the limit
+// has already been reached within the sliding window. See internal/ratelimit.
+func (s *ReportingSink) TakeRateLimit(ctx context.Context, name, key string, limit int, window time.Duration, now time.Time) (bool, error) {
+	if !s.Enabled() {
+		return true, nil
+	}
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Serialize concurrent requests for the same key across replicas
+	_, err = tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext($1 || ':' || $2))", name, key)
+	if err != nil {
+		return false, err
+	}
+
+	// Events that have fallen out of the window are no longer needed
+	start := now.Add(-window)
+	_, err = tx.Exec(ctx, "DELETE FROM rate_limit_events WHERE name = $1 AND key = $2 AND time <= $3", name, key, start)
+	if err != nil {
+		return false, err
+	}
+
+	var count int
+	err = tx.QueryRow(ctx, "SELECT COUNT(*) FROM rate_limit_events WHERE name = $1 AND key = $2", name, key).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	if count >= limit {
+		return false, tx.Commit(ctx)
+	}
+
+	_, err = tx.Exec(ctx, "INSERT INTO rate_limit_events (name, key, time) VALUES ($1, $2, $3)", name, key, now)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit(ctx)
+}
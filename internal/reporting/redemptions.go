@@ -0,0 +1,143 @@
+package reporting
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrCodeInvalid         = errors.New("code is invalid or expired")
+	ErrCodeAlreadyRedeemed = errors.New("code has already been redeemed")
+)
+
+// RedemptionCode is a promo or partner code that members can redeem from their profile.
+// It either sets the member's discount type or applies a Stripe promotion code.
+type RedemptionCode struct {
+	Code          string
+	DiscountType  string
+	PromotionCode string // Stripe promotion code ID e.g. "promo_..."
+	MaxUses       int    // 0 is unlimited
+	Uses          int
+	Expires       *time.Time
+	Disabled      bool
+	CreatedBy     string
+	CreatedAt     time.Time
+}
+
+// NormalizeCode makes codes case-insensitive and ignores whitespace around them, since members type them in.
+func NormalizeCode(code string) string { return strings.ToUpper(strings.TrimSpace(code)) }
+
+// Redeemable returns false when the code can't be redeemed by anyone anymore.
+func (c *RedemptionCode) Redeemable(now time.Time) bool {
+	return !c.Disabled && (c.MaxUses == 0 || c.Uses < c.MaxUses) && (c.Expires == nil || now.Before(*c.Expires))
+}
+
+func (s *ReportingSink) ListRedemptionCodes(ctx context.Context) ([]*RedemptionCode, error) {
+	if !s.Enabled() {
+		return nil, nil
+	}
+	rows, err := s.db.Query(ctx, "SELECT code, discount_type, promotion_code, max_uses, uses, expires, disabled, created_by, created_at FROM redemption_codes ORDER BY created_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	codes := []*RedemptionCode{}
+	for rows.Next() {
+		c := &RedemptionCode{}
+		if err := rows.Scan(&c.Code, &c.DiscountType, &c.PromotionCode, &c.MaxUses, &c.Uses, &c.Expires, &c.Disabled, &c.CreatedBy, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		codes = append(codes, c)
+	}
+	return codes, rows.Err()
+}
+
+// CreateRedemptionCode adds a code, returning false if it already exists.
+func (s *ReportingSink) CreateRedemptionCode(ctx context.Context, c *RedemptionCode) (bool, error) {
+	if !s.Enabled() {
+		return false, nil
+	}
+	tag, err := s.db.Exec(ctx, `INSERT INTO redemption_codes (code, discount_type, promotion_code, max_uses, expires, created_by, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT DO NOTHING`, NormalizeCode(c.Code), c.DiscountType, c.PromotionCode, c.MaxUses, c.Expires, c.CreatedBy, c.CreatedAt)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// SetRedemptionCodeDisabled stops (or resumes) redemptions of the code. Codes aren't deleted, so past redemptions can
+// still be traced back to them.
+func (s *ReportingSink) SetRedemptionCodeDisabled(ctx context.Context, code string, disabled bool) error {
+	if !s.Enabled() {
+		return nil
+	}
+	_, err := s.db.Exec(ctx, "UPDATE redemption_codes SET disabled = $1 WHERE code = $2", disabled, NormalizeCode(code))
+	return err
+}
+
+// RedeemCode reserves a use of the code for the member. Callers should release it with ReleaseRedemption if the
+// discount can't be applied, so the member can try again and the use isn't wasted.
+func (s *ReportingSink) RedeemCode(ctx context.Context, code, email string, now time.Time) (*RedemptionCode, error) {
+	if !s.Enabled() {
+		return nil, ErrCodeInvalid
+	}
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	c := &RedemptionCode{}
+	err = tx.QueryRow(ctx, "SELECT code, discount_type, promotion_code, max_uses, uses, expires, disabled, created_by, created_at FROM redemption_codes WHERE code = $1 FOR UPDATE", NormalizeCode(code)).
+		Scan(&c.Code, &c.DiscountType, &c.PromotionCode, &c.MaxUses, &c.Uses, &c.Expires, &c.Disabled, &c.CreatedBy, &c.CreatedAt)
+	if err != nil && strings.Contains(err.Error(), "no rows in result set") {
+		return nil, ErrCodeInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !c.Redeemable(now) {
+		return nil, ErrCodeInvalid
+	}
+
+	tag, err := tx.Exec(ctx, "INSERT INTO code_redemptions (code, email, time) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING", c.Code, email, now)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrCodeAlreadyRedeemed
+	}
+	_, err = tx.Exec(ctx, "UPDATE redemption_codes SET uses = uses + 1 WHERE code = $1", c.Code)
+	if err != nil {
+		return nil, err
+	}
+	c.Uses++
+	return c, tx.Commit(ctx)
+}
+
+// ReleaseRedemption undoes RedeemCode.
+func (s *ReportingSink) ReleaseRedemption(ctx context.Context, code, email string) error {
+	if !s.Enabled() {
+		return nil
+	}
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, "DELETE FROM code_redemptions WHERE code = $1 AND email = $2", NormalizeCode(code), email)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() > 0 {
+		_, err = tx.Exec(ctx, "UPDATE redemption_codes SET uses = uses - 1 WHERE code = $1", NormalizeCode(code))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,23 @@
+package reporting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedemptionCodeRedeemable(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+
+	assert.True(t, (&RedemptionCode{}).Redeemable(now))
+	assert.True(t, (&RedemptionCode{MaxUses: 2, Uses: 1, Expires: &future}).Redeemable(now))
+	assert.False(t, (&RedemptionCode{MaxUses: 2, Uses: 2}).Redeemable(now))
+	assert.False(t, (&RedemptionCode{Expires: &past}).Redeemable(now))
+	assert.False(t, (&RedemptionCode{Disabled: true}).Redeemable(now))
+}
+
+func TestNormalizeCode(t *testing.T) {
+	assert.Equal(t, "MAKER10", NormalizeCode(" maker10\n"))
+}
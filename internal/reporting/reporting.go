@@ -2,12 +2,14 @@ package reporting
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"strings"
 	"time"
 
-	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 
 	"github.com/TheLab-ms/profile/internal/conf"
 	"github.com/TheLab-ms/profile/internal/datamodel"
@@ -17,32 +19,12 @@ import (
 
 var DefaultSink *ReportingSink
 
-const migration = `
-CREATE TABLE IF NOT EXISTS profile_events (
-	id serial primary key,
-	time timestamp not null,
-	email text not null,
-	reason text not null,
-	message text not null
-);
-
-CREATE INDEX IF NOT EXISTS idx_profile_events_time ON profile_events (time);
-
-CREATE TABLE IF NOT EXISTS profile_metrics (
-	id serial primary key,
-	time timestamp not null,
-	active_members int not null,
-	inactive_members int not null
-);
-
-CREATE INDEX IF NOT EXISTS idx_profile_metrics_time ON profile_metrics (time);
-ALTER TABLE profile_metrics ADD COLUMN IF NOT EXISTS unverified_accounts int;
-`
-
 // ReportingSink buffers and periodically flushes meaningful user actions to postgres.
 type ReportingSink struct {
-	db       *pgxpool.Pool
+	db       *pool
 	buffer   chan *event
+	flushed  chan struct{}
+	stop     context.CancelFunc
 	keycloak *keycloak.Keycloak[*datamodel.User]
 }
 
@@ -52,20 +34,29 @@ func NewSink(env *conf.Env, kc *keycloak.Keycloak[*datamodel.User]) (*ReportingS
 		return s, nil
 	}
 
-	db, err := pgxpool.Connect(context.Background(), fmt.Sprintf("user=%s password=%s host=%s port=5432 dbname=postgres", env.EventPsqlUsername, env.EventPsqlPassword, env.EventPsqlAddr))
+	db, err := connect(context.Background(), env)
 	if err != nil {
 		return nil, fmt.Errorf("constructing db client: %w", err)
 	}
 	s.db = db
 
-	_, err = db.Exec(context.Background(), migration)
+	err = migrate(context.Background(), db.Pool)
 	if err != nil {
 		return nil, fmt.Errorf("db migration: %w", err)
 	}
 
+	// Watch the connection so outages are visible, and recovered from quickly
+	var ctx context.Context
+	ctx, s.stop = context.WithCancel(context.Background())
+	if env.EventPsqlPingInterval > 0 {
+		go db.monitor(ctx, env.EventPsqlPingInterval)
+	}
+
 	// Flush messages out to postgres
 	s.buffer = make(chan *event, env.EventBufferLength)
+	s.flushed = make(chan struct{})
 	go func() {
+		defer close(s.flushed)
 		defer db.Close()
 
 		for event := range s.buffer {
@@ -83,6 +74,17 @@ func NewSink(env *conf.Env, kc *keycloak.Keycloak[*datamodel.User]) (*ReportingS
 	return s, nil
 }
 
+// Close flushes any buffered events and closes the database connection.
+// Short-lived processes should call it before exiting so their events aren't lost.
+func (s *ReportingSink) Close() {
+	if s == nil || s.buffer == nil {
+		return
+	}
+	s.stop()
+	close(s.buffer)
+	<-s.flushed
+}
+
 func (s *ReportingSink) Eventf(email, reason, templ string, args ...any) {
 	if s == nil || s.buffer == nil {
 		return
@@ -108,15 +110,16 @@ func (s *ReportingSink) GetLatestSwipe(ctx context.Context, name string, last ti
 }
 
 // This really doesn't belong on the reporting sink, but it queries the reporting DB so it's convenient to put it here.
-func (s *ReportingSink) LastFobAssignment(ctx context.Context, granterFobID int) (int, bool, error) {
+// Returns the new fob's ID along with the ID of its swipe, which can be passed to FobAssignmentSwipe later.
+func (s *ReportingSink) LastFobAssignment(ctx context.Context, granterFobID int) (int, int, bool, error) {
 	var prevID int
 	err := s.db.QueryRow(ctx, "SELECT MAX(id) FROM swipes WHERE cardID = $1 AND seenAt >= NOW() - INTERVAL '1 minute' GROUP BY time ORDER BY time DESC", granterFobID).Scan(&prevID)
 	if err != nil {
 		if strings.Contains(err.Error(), "no rows in result set") {
 			log.Printf("no swipe found for granter's fob %d", granterFobID)
-			return 0, false, nil // errors.Is didn't work with the psql library for some reason
+			return 0, 0, false, nil // errors.Is didn't work with the psql library for some reason
 		}
-		return 0, false, err
+		return 0, 0, false, err
 	}
 	log.Printf("found previous swipe ID of %d", prevID)
 
@@ -126,73 +129,117 @@ func (s *ReportingSink) LastFobAssignment(ctx context.Context, granterFobID int)
 	if err != nil {
 		if strings.Contains(err.Error(), "no rows in result set") {
 			log.Printf("no swipe found for new fob")
+			return 0, 0, false, nil // errors.Is didn't work with the psql library for some reason
+		}
+		return 0, 0, false, err
+	}
+
+	return id, prevID + 1, true, nil
+}
+
+// FobAssignmentSwipe returns the fob from a swipe found by LastFobAssignment, as long as it directly followed a swipe of
+// the granter's fob and hasn't expired. Admins take a while to confirm warnings, so it's valid for longer than the swipe.
+func (s *ReportingSink) FobAssignmentSwipe(ctx context.Context, granterFobID, swipeID int) (int, bool, error) {
+	if !s.Enabled() {
+		return 0, false, nil
+	}
+	var id int
+	err := s.db.QueryRow(ctx, "SELECT s.cardID FROM swipes s JOIN swipes g ON g.id = s.id - 1 WHERE s.id = $1 AND g.cardID = $2 AND s.seenAt >= NOW() - INTERVAL '15 minutes'", swipeID, granterFobID).Scan(&id)
+	if err != nil {
+		if strings.Contains(err.Error(), "no rows in result set") {
 			return 0, false, nil // errors.Is didn't work with the psql library for some reason
 		}
 		return 0, false, err
 	}
-
 	return id, true, nil
 }
 
 func (s *ReportingSink) Enabled() bool { return s != nil && s.db != nil }
 
-func (s *ReportingSink) RunMemberMetricsLoop(ctx context.Context) {
-	if !s.Enabled() {
-		return // no db to report to
-	}
-
+// RunMemberMetricsLoop periodically counts members for the gauges and daily metrics rows.
+// Only the leader does so, since every replica would list the same users and write the same rows.
+func (s *ReportingSink) RunMemberMetricsLoop(ctx context.Context, leader *Leadership) {
 	const interval = time.Hour * 24
+	const gaugeInterval = time.Hour
 	const retryInterval = time.Second * 30
 
 	loop := &flowcontrol.Loop{
-		Handler: func(ctx context.Context) time.Duration {
+		Handler: flowcontrol.LeaderOnly(leader, leader.Interval, func(ctx context.Context) time.Duration {
+			counters, err := s.countMembers(ctx)
+			if err != nil {
+				log.Printf("error listing users to derive metrics: %s", err)
+				return retryInterval
+			}
+			counters.export()
+
+			if !s.Enabled() {
+				return gaugeInterval // no db to report to
+			}
+
 			lastTime, err := s.lastMetricTime()
 			if err != nil {
 				log.Printf("error while getting the last metrics reporting time: %s", err)
 				return retryInterval
 			}
-
-			delta := interval - time.Since(lastTime)
-			if delta > 0 {
-				log.Printf("it isn't time to report metrics yet - setting time for %d seconds in the future", int(delta.Seconds()))
-				return delta
+			if time.Since(lastTime) < interval {
+				return gaugeInterval
 			}
 
-			s.reportMetrics()
-			return retryInterval // don't wait the entire interval in case reportMetrics failed
-		},
+			err = s.writeMetrics(counters)
+			if err != nil {
+				log.Printf("unable to write metrics to the reporting store: %s", err)
+				return retryInterval
+			}
+			return gaugeInterval
+		}),
 	}
 	loop.Run(ctx)
 }
 
-func (s *ReportingSink) reportMetrics() {
-	users, err := s.keycloak.ListUsers(context.Background())
+func (s *ReportingSink) countMembers(ctx context.Context) (*counters, error) {
+	users, err := s.keycloak.ListUsers(ctx)
 	if err != nil {
-		log.Printf("error listing users to derive metrics: %s", err)
-		return
+		return nil, err
 	}
 
-	counters := counters{}
+	c := &counters{DiscountTypes: map[string]int64{}}
 	for _, extended := range users {
-		if !extended.User.EmailVerified {
-			counters.UnverifiedAccounts++
+		user := extended.User
+		if !user.EmailVerified {
+			c.UnverifiedAccounts++
 		}
-		if extended.ActiveMember {
-			counters.ActiveMembers++
-		} else {
-			counters.InactiveMembers++
+		if !extended.ActiveMember {
+			c.InactiveMembers++
+			continue
 		}
-	}
 
-	err = s.writeMetrics(&counters)
-	if err != nil {
-		log.Printf("unable to write metrics to the reporting store: %s", err)
-		return
+		// Breakdowns only consider active members
+		c.ActiveMembers++
+		switch user.PaymentStatus() {
+		case "NonBillable":
+			c.NonBillableMembers++
+		case "StripeActive":
+			c.StripeMembers++
+		case "Paypal":
+			c.PaypalMembers++
+		}
+		if user.BuildingAccessApprover != "" {
+			c.BuildingAccessMembers++
+		}
+		if user.DiscountType != "" {
+			c.DiscountTypes[user.DiscountType]++
+		}
 	}
+	return c, nil
 }
 
-func (s *ReportingSink) writeMetrics(counters *counters) error {
-	_, err := s.db.Exec(context.Background(), "INSERT INTO profile_metrics (time, active_members, inactive_members, unverified_accounts) VALUES ($1, $2, $3, $4)", time.Now(), counters.ActiveMembers, counters.InactiveMembers, counters.UnverifiedAccounts)
+func (s *ReportingSink) writeMetrics(c *counters) error {
+	discountTypes, err := json.Marshal(c.DiscountTypes)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(context.Background(), "INSERT INTO profile_metrics (time, active_members, inactive_members, unverified_accounts, stripe_members, paypal_members, non_billable_members, building_access_members, discount_types) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		time.Now(), c.ActiveMembers, c.InactiveMembers, c.UnverifiedAccounts, c.StripeMembers, c.PaypalMembers, c.NonBillableMembers, c.BuildingAccessMembers, string(discountTypes))
 	return err
 }
 
@@ -205,6 +252,51 @@ type counters struct {
 	ActiveMembers      int64
 	InactiveMembers    int64
 	UnverifiedAccounts int64
+
+	// Subsets of ActiveMembers
+	StripeMembers         int64
+	PaypalMembers         int64
+	NonBillableMembers    int64
+	BuildingAccessMembers int64
+	DiscountTypes         map[string]int64
+}
+
+var (
+	memberGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "profile_members",
+		Help: "Count of accounts by membership state",
+	}, []string{"state"})
+
+	paymentMethodGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "profile_active_members_by_payment",
+		Help: "Count of active members by payment method",
+	}, []string{"method"})
+
+	discountTypeGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "profile_active_members_by_discount_type",
+		Help: "Count of active members by discount type",
+	}, []string{"discount_type"})
+
+	buildingAccessGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "profile_active_members_building_access",
+		Help: "Count of active members who have been approved for building access",
+	})
+)
+
+func (c *counters) export() {
+	memberGauge.WithLabelValues("active").Set(float64(c.ActiveMembers))
+	memberGauge.WithLabelValues("inactive").Set(float64(c.InactiveMembers))
+	memberGauge.WithLabelValues("unverified").Set(float64(c.UnverifiedAccounts))
+	paymentMethodGauge.WithLabelValues("stripe").Set(float64(c.StripeMembers))
+	paymentMethodGauge.WithLabelValues("paypal").Set(float64(c.PaypalMembers))
+	paymentMethodGauge.WithLabelValues("nonbillable").Set(float64(c.NonBillableMembers))
+	buildingAccessGauge.Set(float64(c.BuildingAccessMembers))
+
+	// Reset so discount types that no longer have any members drop to zero rather than holding their last value
+	discountTypeGauge.Reset()
+	for dt, count := range c.DiscountTypes {
+		discountTypeGauge.WithLabelValues(dt).Set(float64(count))
+	}
 }
 
 type event struct {
@@ -0,0 +1,108 @@
+package reporting
+
+import (
+	"context"
+	"time"
+)
+
+// DeletedEmail replaces the email address of deleted accounts in anonymized records.
+const DeletedEmail = "<deleted>"
+
+// deletionReasons are the events recorded when an account is deleted.
+var deletionReasons = []string{"AccountDeleted", "AccountCleanedUp"}
+
+// AggregateSwipes rolls swipes that happened before the cutoff up into daily per-fob counts and deletes them.
+// Counts are added to any existing ones, so the cutoff doesn't need to fall on a day boundary.
+func (s *ReportingSink) AggregateSwipes(ctx context.Context, before time.Time) (int64, error) {
+	if !s.Enabled() {
+		return 0, nil
+	}
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `INSERT INTO swipe_daily_counts (date, fob_id, name, swipes)
+		SELECT time::date, cardID, MAX(name), COUNT(*) FROM swipes WHERE time < $1 GROUP BY time::date, cardID
+		ON CONFLICT (date, fob_id) DO UPDATE SET swipes = swipe_daily_counts.swipes + EXCLUDED.swipes`, before)
+	if err != nil {
+		return 0, err
+	}
+	tag, err := tx.Exec(ctx, "DELETE FROM swipes WHERE time < $1", before)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), tx.Commit(ctx)
+}
+
+// ListDeletedAccounts returns the email addresses of accounts deleted before the given time that haven't been anonymized yet.
+func (s *ReportingSink) ListDeletedAccounts(ctx context.Context, before time.Time) ([]string, error) {
+	if !s.Enabled() {
+		return nil, nil
+	}
+	rows, err := s.db.Query(ctx, "SELECT DISTINCT email FROM profile_events WHERE reason = ANY($1) AND time < $2 AND email != $3", deletionReasons, before, DeletedEmail)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	emails := []string{}
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			return nil, err
+		}
+		emails = append(emails, email)
+	}
+	return emails, rows.Err()
+}
+
+// AnonymizeMember scrubs a deleted account's email address from the reporting tables, and their name from swipes made
+// while they held a fob. Their attribute history is deleted, since it holds their contact info. Aggregate counts are kept.
+func (s *ReportingSink) AnonymizeMember(ctx context.Context, email string) error {
+	if !s.Enabled() {
+		return nil
+	}
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Swipes are matched to the member through the fobs they held, up until the fob was given to someone else
+	const held = `SELECT a.fob_id, a.time AS start, COALESCE((SELECT MIN(b.time) FROM fob_assignments b WHERE b.fob_id = a.fob_id AND b.time > a.time AND b.assigned AND b.email != a.email), 'infinity'::timestamp) AS stop
+		FROM fob_assignments a WHERE a.email = $1 AND a.assigned`
+	_, err = tx.Exec(ctx, "UPDATE swipes SET name = '' FROM ("+held+") held WHERE swipes.cardID = held.fob_id AND swipes.time >= held.start AND swipes.time < held.stop", email)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, "UPDATE swipe_daily_counts SET name = '' FROM ("+held+") held WHERE swipe_daily_counts.fob_id = held.fob_id AND swipe_daily_counts.date >= held.start::date AND swipe_daily_counts.date < held.stop::date", email)
+	if err != nil {
+		return err
+	}
+
+	for _, table := range []string{"profile_events", "fob_assignments", "member_snapshots", "admin_actions"} {
+		_, err = tx.Exec(ctx, "UPDATE "+table+" SET email = $1 WHERE email = $2", DeletedEmail, email)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(ctx, "UPDATE guests SET host_email = $1 WHERE host_email = $2", DeletedEmail, email)
+	if err != nil {
+		return err
+	}
+	for _, column := range []string{"actor", "recipient", "encrypted_by"} {
+		_, err = tx.Exec(ctx, "UPDATE secret_audit SET "+column+" = $1 WHERE "+column+" = $2", DeletedEmail, email)
+		if err != nil {
+			return err
+		}
+	}
+	for _, table := range []string{"waitlist", "attribute_history", "code_redemptions", "event_attendance", "pending_actions"} {
+		_, err = tx.Exec(ctx, "DELETE FROM "+table+" WHERE email = $1", email)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,65 @@
+package reporting
+
+import (
+	"context"
+	"time"
+)
+
+// Operations recorded in the secrets audit log.
+const (
+	SecretEncrypted = "encrypt"
+	SecretDecrypted = "decrypt"
+	SecretDenied    = "denied" // decryption attempted by someone other than the recipient
+)
+
+// SecretAccess is an entry in the secrets audit log. The secret's value is never recorded.
+type SecretAccess struct {
+	Time        time.Time
+	Operation   string
+	Actor       string
+	Description string
+	Recipient   string // empty for secrets readable by leadership
+
+	// Where the secret came from, for decryptions
+	EncryptedBy string
+	EncryptedAt time.Time
+}
+
+func (s *ReportingSink) RecordSecretAccess(ctx context.Context, a *SecretAccess) error {
+	if !s.Enabled() {
+		return nil
+	}
+	var encryptedAt *time.Time
+	if !a.EncryptedAt.IsZero() {
+		encryptedAt = &a.EncryptedAt
+	}
+	_, err := s.db.Exec(ctx, "INSERT INTO secret_audit (time, operation, actor, description, recipient, encrypted_by, encrypted_at) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		a.Time, a.Operation, a.Actor, a.Description, a.Recipient, a.EncryptedBy, encryptedAt)
+	return err
+}
+
+// ListSecretAccess returns the audit log since the given time, newest first.
+func (s *ReportingSink) ListSecretAccess(ctx context.Context, since time.Time) ([]*SecretAccess, error) {
+	if !s.Enabled() {
+		return nil, nil
+	}
+	rows, err := s.db.Query(ctx, "SELECT time, operation, actor, description, recipient, encrypted_by, encrypted_at FROM secret_audit WHERE time >= $1 ORDER BY time DESC", since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*SecretAccess{}
+	for rows.Next() {
+		a := &SecretAccess{}
+		var encryptedAt *time.Time
+		if err := rows.Scan(&a.Time, &a.Operation, &a.Actor, &a.Description, &a.Recipient, &a.EncryptedBy, &encryptedAt); err != nil {
+			return nil, err
+		}
+		if encryptedAt != nil {
+			a.EncryptedAt = *encryptedAt
+		}
+		entries = append(entries, a)
+	}
+	return entries, rows.Err()
+}
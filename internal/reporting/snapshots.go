@@ -0,0 +1,59 @@
+package reporting
+
+import (
+	"context"
+	"time"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/keycloak"
+)
+
+// MemberSnapshot is a member's status on a given day.
+// Unlike profile_metrics, snapshots can answer point-in-time questions about any combination of fields.
+type MemberSnapshot struct {
+	UserID         string
+	Email          string
+	Active         bool
+	Tier           string
+	DiscountType   string
+	PaymentStatus  string
+	BuildingAccess bool
+}
+
+func NewMemberSnapshot(user *keycloak.ExtendedUser[*datamodel.User]) *MemberSnapshot {
+	return &MemberSnapshot{
+		UserID:         user.User.UUID,
+		Email:          user.User.Email,
+		Active:         user.ActiveMember,
+		Tier:           user.User.Tier(),
+		DiscountType:   user.User.DiscountType,
+		PaymentStatus:  user.User.PaymentStatus(),
+		BuildingAccess: user.User.BuildingAccessApprover != "",
+	}
+}
+
+// RecordMemberSnapshots replaces the snapshots for the given day, so re-running a failed job is harmless.
+func (s *ReportingSink) RecordMemberSnapshots(ctx context.Context, date time.Time, snapshots []*MemberSnapshot) error {
+	if !s.Enabled() {
+		return nil
+	}
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	day := date.Format("2006-01-02")
+	_, err = tx.Exec(ctx, "DELETE FROM member_snapshots WHERE date = $1", day)
+	if err != nil {
+		return err
+	}
+	for _, snap := range snapshots {
+		_, err = tx.Exec(ctx, "INSERT INTO member_snapshots (date, user_id, email, active, tier, discount_type, payment_status, building_access) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+			day, snap.UserID, snap.Email, snap.Active, snap.Tier, snap.DiscountType, snap.PaymentStatus, snap.BuildingAccess)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,38 @@
+package reporting
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/keycloak"
+)
+
+func TestNewMemberSnapshot(t *testing.T) {
+	snap := NewMemberSnapshot(&keycloak.ExtendedUser[*datamodel.User]{
+		ActiveMember: true,
+		User: &datamodel.User{
+			UUID:                   "user-1",
+			Email:                  "member@example.com",
+			MembershipTier:         datamodel.TierPremium,
+			DiscountType:           "educator",
+			NonBillable:            true,
+			BuildingAccessApprover: "leadership",
+		},
+	})
+	assert.Equal(t, &MemberSnapshot{
+		UserID:         "user-1",
+		Email:          "member@example.com",
+		Active:         true,
+		Tier:           datamodel.TierPremium,
+		DiscountType:   "educator",
+		PaymentStatus:  "NonBillable",
+		BuildingAccess: true,
+	}, snap)
+
+	snap = NewMemberSnapshot(&keycloak.ExtendedUser[*datamodel.User]{User: &datamodel.User{UUID: "user-2"}})
+	assert.False(t, snap.Active)
+	assert.False(t, snap.BuildingAccess)
+	assert.Equal(t, datamodel.TierStandard, snap.Tier)
+}
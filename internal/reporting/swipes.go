@@ -0,0 +1,77 @@
+package reporting
+
+import (
+	"context"
+	"time"
+)
+
+// Swipe is a fob swipe recorded by the door controllers.
+type Swipe struct {
+	ID    int64
+	Time  time.Time
+	Name  string
+	FobID int
+}
+
+// ListSwipes returns swipes with an ID greater than afterID that happened after the given time, oldest first.
+func (s *ReportingSink) ListSwipes(ctx context.Context, afterID int64, since time.Time) ([]*Swipe, error) {
+	if !s.Enabled() {
+		return nil, nil
+	}
+	rows, err := s.db.Query(ctx, "SELECT id, time, name, cardID FROM swipes WHERE id > $1 AND time > $2 ORDER BY id", afterID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	swipes := []*Swipe{}
+	for rows.Next() {
+		swipe := &Swipe{}
+		if err := rows.Scan(&swipe.ID, &swipe.Time, &swipe.Name, &swipe.FobID); err != nil {
+			return nil, err
+		}
+		swipes = append(swipes, swipe)
+	}
+	return swipes, rows.Err()
+}
+
+// LastSwipeAlertCheckpoint returns the ID of the last swipe checked for anomalies, or zero if none have been.
+func (s *ReportingSink) LastSwipeAlertCheckpoint(ctx context.Context) (int64, error) {
+	if !s.Enabled() {
+		return 0, nil
+	}
+	var id int64
+	return id, s.db.QueryRow(ctx, "SELECT COALESCE(MAX(last_swipe_id), 0) FROM swipe_alert_checkpoints").Scan(&id)
+}
+
+// RecordSwipeAlertCheckpoint stores the ID of the last swipe checked for anomalies.
+func (s *ReportingSink) RecordSwipeAlertCheckpoint(ctx context.Context, lastSwipeID int64) error {
+	if !s.Enabled() {
+		return nil
+	}
+	_, err := s.db.Exec(ctx, "INSERT INTO swipe_alert_checkpoints (time, last_swipe_id) VALUES ($1, $2)", time.Now(), lastSwipeID)
+	return err
+}
+
+// ListLatestSwipeTimes returns the time of each fob's latest swipe since the given time. Names are deliberately left
+// out since it's used for the member-visible occupancy estimate.
+func (s *ReportingSink) ListLatestSwipeTimes(ctx context.Context, since time.Time) ([]time.Time, error) {
+	if !s.Enabled() {
+		return nil, nil
+	}
+	rows, err := s.db.Query(ctx, "SELECT MAX(time) FROM swipes WHERE time > $1 GROUP BY cardID", since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	times := []time.Time{}
+	for rows.Next() {
+		var t time.Time
+		if err := rows.Scan(&t); err != nil {
+			return nil, err
+		}
+		times = append(times, t)
+	}
+	return times, rows.Err()
+}
@@ -0,0 +1,119 @@
+package reporting
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// WaitlistEntry is a person waiting for a membership slot to open up. See internal/waitlist.
+type WaitlistEntry struct {
+	Email           string
+	JoinedAt        time.Time
+	InvitedAt       time.Time // zero until leadership releases a slot to them
+	InviteExpiresAt time.Time
+}
+
+// Invited returns true if the entry holds an unexpired invitation to check out.
+func (e *WaitlistEntry) Invited(now time.Time) bool {
+	return e != nil && !e.InvitedAt.IsZero() && now.Before(e.InviteExpiresAt)
+}
+
+// JoinWaitlist adds the person to the end of the waitlist.
+// People whose invitation expired go back to the end, everyone else keeps their place.
+func (s *ReportingSink) JoinWaitlist(ctx context.Context, email string) error {
+	if !s.Enabled() {
+		return nil
+	}
+	_, err := s.db.Exec(ctx, `INSERT INTO waitlist (email, joined_at) VALUES ($1, $2)
+		ON CONFLICT (email) DO UPDATE SET joined_at = $2, invited_at = NULL, invite_expires_at = NULL
+		WHERE waitlist.invite_expires_at < $2`, email, time.Now())
+	return err
+}
+
+func (s *ReportingSink) RemoveFromWaitlist(ctx context.Context, email string) error {
+	if !s.Enabled() {
+		return nil
+	}
+	_, err := s.db.Exec(ctx, "DELETE FROM waitlist WHERE email = $1", email)
+	return err
+}
+
+// GetWaitlistEntry returns the member's entry along with their position among everyone who hasn't been invited yet.
+// A nil entry is returned if they aren't on the waitlist.
+func (s *ReportingSink) GetWaitlistEntry(ctx context.Context, email string) (*WaitlistEntry, int, error) {
+	if !s.Enabled() {
+		return nil, 0, nil
+	}
+	entry := &WaitlistEntry{}
+	var invitedAt, expiresAt *time.Time
+	var position int
+	err := s.db.QueryRow(ctx, `SELECT email, joined_at, invited_at, invite_expires_at,
+		(SELECT COUNT(*) FROM waitlist o WHERE o.invited_at IS NULL AND o.joined_at <= w.joined_at)
+		FROM waitlist w WHERE email = $1`, email).Scan(&entry.Email, &entry.JoinedAt, &invitedAt, &expiresAt, &position)
+	if err != nil {
+		if strings.Contains(err.Error(), "no rows in result set") {
+			return nil, 0, nil // errors.Is didn't work with the psql library for some reason
+		}
+		return nil, 0, err
+	}
+	setInvitation(entry, invitedAt, expiresAt)
+	return entry, position, nil
+}
+
+func (s *ReportingSink) ListWaitlist(ctx context.Context) ([]*WaitlistEntry, error) {
+	if !s.Enabled() {
+		return nil, nil
+	}
+	rows, err := s.db.Query(ctx, "SELECT email, joined_at, invited_at, invite_expires_at FROM waitlist ORDER BY joined_at")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*WaitlistEntry{}
+	for rows.Next() {
+		entry := &WaitlistEntry{}
+		var invitedAt, expiresAt *time.Time
+		if err := rows.Scan(&entry.Email, &entry.JoinedAt, &invitedAt, &expiresAt); err != nil {
+			return nil, err
+		}
+		setInvitation(entry, invitedAt, expiresAt)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// InviteFromWaitlist invites the n people who have been waiting the longest, returning their entries.
+func (s *ReportingSink) InviteFromWaitlist(ctx context.Context, n int, expiration time.Time) ([]*WaitlistEntry, error) {
+	if !s.Enabled() {
+		return nil, nil
+	}
+	now := time.Now()
+	rows, err := s.db.Query(ctx, `UPDATE waitlist SET invited_at = $2, invite_expires_at = $3
+		WHERE email IN (SELECT email FROM waitlist WHERE invited_at IS NULL ORDER BY joined_at LIMIT $1)
+		RETURNING email, joined_at`, n, now, expiration)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*WaitlistEntry{}
+	for rows.Next() {
+		entry := &WaitlistEntry{InvitedAt: now, InviteExpiresAt: expiration}
+		if err := rows.Scan(&entry.Email, &entry.JoinedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func setInvitation(entry *WaitlistEntry, invitedAt, expiresAt *time.Time) {
+	if invitedAt != nil {
+		entry.InvitedAt = *invitedAt
+	}
+	if expiresAt != nil {
+		entry.InviteExpiresAt = *expiresAt
+	}
+}
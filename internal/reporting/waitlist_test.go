@@ -0,0 +1,17 @@
+package reporting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaitlistEntryInvited(t *testing.T) {
+	now := time.Now()
+	var nilEntry *WaitlistEntry
+	assert.False(t, nilEntry.Invited(now))
+	assert.False(t, (&WaitlistEntry{}).Invited(now))
+	assert.True(t, (&WaitlistEntry{InvitedAt: now.Add(-time.Hour), InviteExpiresAt: now.Add(time.Hour)}).Invited(now))
+	assert.False(t, (&WaitlistEntry{InvitedAt: now.Add(-time.Hour * 2), InviteExpiresAt: now.Add(-time.Hour)}).Invited(now))
+}
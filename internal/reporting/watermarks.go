@@ -0,0 +1,38 @@
+package reporting
+
+import (
+	"context"
+	"time"
+)
+
+// RecordResyncWatermark stores the time the named full resync loop last completed successfully.
+func (s *ReportingSink) RecordResyncWatermark(ctx context.Context, name string, completed time.Time) error {
+	if !s.Enabled() {
+		return nil
+	}
+	_, err := s.db.Exec(ctx, "INSERT INTO resync_watermarks (name, completed_at) VALUES ($1, $2) ON CONFLICT (name) DO UPDATE SET completed_at = GREATEST(resync_watermarks.completed_at, excluded.completed_at)", name, completed)
+	return err
+}
+
+// ListResyncWatermarks returns the time each full resync loop last completed successfully, by name.
+func (s *ReportingSink) ListResyncWatermarks(ctx context.Context) (map[string]time.Time, error) {
+	if !s.Enabled() {
+		return map[string]time.Time{}, nil
+	}
+	rows, err := s.db.Query(ctx, "SELECT name, completed_at FROM resync_watermarks")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	marks := map[string]time.Time{}
+	for rows.Next() {
+		var name string
+		var completed time.Time
+		if err := rows.Scan(&name, &completed); err != nil {
+			return nil, err
+		}
+		marks[name] = completed
+	}
+	return marks, rows.Err()
+}
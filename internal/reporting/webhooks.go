@@ -0,0 +1,97 @@
+package reporting
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// WebhookFailure is a webhook that couldn't be processed, archived so it can be replayed once the problem is fixed.
+type WebhookFailure struct {
+	ID         int64
+	Source     string // e.g. "stripe"
+	ReceivedAt time.Time
+	Headers    string // JSON encoded, without credentials
+	Payload    string
+	Status     int
+	Error      string    // from the latest attempt, including replays
+	ReplayedAt time.Time // zero unless replayed
+	ReplayedBy string
+	ResolvedAt time.Time // set once a replay succeeds
+}
+
+const webhookFailureColumns = "id, source, received_at, headers, payload, status, error, replayed_at, COALESCE(replayed_by, ''), resolved_at"
+
+func (s *ReportingSink) RecordWebhookFailure(ctx context.Context, f *WebhookFailure) (int64, error) {
+	if !s.Enabled() {
+		return 0, nil
+	}
+	var id int64
+	err := s.db.QueryRow(ctx, "INSERT INTO webhook_failures (source, received_at, headers, payload, status, error) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		f.Source, f.ReceivedAt, f.Headers, f.Payload, f.Status, f.Error).Scan(&id)
+	return id, err
+}
+
+// GetWebhookFailure returns the failure, or nil if it doesn't exist.
+func (s *ReportingSink) GetWebhookFailure(ctx context.Context, id int64) (*WebhookFailure, error) {
+	if !s.Enabled() {
+		return nil, nil
+	}
+	f, err := scanWebhookFailure(s.db.QueryRow(ctx, "SELECT "+webhookFailureColumns+" FROM webhook_failures WHERE id = $1", id))
+	if err != nil && strings.Contains(err.Error(), "no rows in result set") {
+		return nil, nil // errors.Is didn't work with the psql library for some reason
+	}
+	return f, err
+}
+
+// ListWebhookFailures returns the most recent failures, unresolved first.
+func (s *ReportingSink) ListWebhookFailures(ctx context.Context, limit int) ([]*WebhookFailure, error) {
+	if !s.Enabled() {
+		return nil, nil
+	}
+	rows, err := s.db.Query(ctx, "SELECT "+webhookFailureColumns+" FROM webhook_failures ORDER BY resolved_at IS NOT NULL, received_at DESC LIMIT $1", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	failures := []*WebhookFailure{}
+	for rows.Next() {
+		f, err := scanWebhookFailure(rows)
+		if err != nil {
+			return nil, err
+		}
+		failures = append(failures, f)
+	}
+	return failures, rows.Err()
+}
+
+// RecordWebhookReplay stores the outcome of replaying a failed webhook. An empty errMsg resolves the failure.
+func (s *ReportingSink) RecordWebhookReplay(ctx context.Context, id int64, actor string, status int, errMsg string, now time.Time) error {
+	if !s.Enabled() {
+		return nil
+	}
+	var resolvedAt *time.Time
+	if errMsg == "" {
+		resolvedAt = &now
+	}
+	_, err := s.db.Exec(ctx, "UPDATE webhook_failures SET replayed_at = $2, replayed_by = $3, status = $4, error = CASE WHEN $5 = '' THEN error ELSE $5 END, resolved_at = $6 WHERE id = $1",
+		id, now, actor, status, errMsg, resolvedAt)
+	return err
+}
+
+func scanWebhookFailure(row rowScanner) (*WebhookFailure, error) {
+	f := &WebhookFailure{}
+	var replayedAt, resolvedAt *time.Time
+	err := row.Scan(&f.ID, &f.Source, &f.ReceivedAt, &f.Headers, &f.Payload, &f.Status, &f.Error, &replayedAt, &f.ReplayedBy, &resolvedAt)
+	if err != nil {
+		return nil, err
+	}
+	if replayedAt != nil {
+		f.ReplayedAt = *replayedAt
+	}
+	if resolvedAt != nil {
+		f.ResolvedAt = *resolvedAt
+	}
+	return f, nil
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+// undoWindow is how long destructive admin actions can be undone.
+const undoWindow = time.Minute * 10
+
+// adminAction is a destructive change to a member that requires confirmation and can be undone for a short time.
+type adminAction struct {
+	Title       string
+	Description string
+
+	// Deferred actions aren't carried out until the undo window has passed (see profile-async).
+	// Otherwise apply modifies the user immediately, and undoing restores the fields in undoState.
+	Deferred bool
+	apply    func(user *datamodel.User)
+}
+
+var adminActions = map[string]*adminAction{
+	"unassign-fob": {
+		Title:       "Unassign Fob",
+		Description: "The member's fob will stop opening the door, and can be assigned to someone else.",
+		apply:       func(user *datamodel.User) { user.FobID = 0 },
+	},
+	"revoke-access": {
+		Title:       "Revoke Building Access",
+		Description: "The member's fob will stop opening the door until building access is approved again.",
+		apply:       func(user *datamodel.User) { user.BuildingAccessApprover = "" },
+	},
+	reporting.AdminActionDeleteUser: {
+		Title:       "Delete Account",
+		Description: "The member's account will be deleted once the undo window has passed. This can't be reversed after that point.",
+		Deferred:    true,
+	},
+}
+
+// undoState holds every field that can be changed by a non-deferred admin action.
+type undoState struct {
+	FobID                  int
+	BuildingAccessApprover string
+}
+
+func (s *Server) newAdminActionConfirmHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		kind := r.FormValue("kind")
+		action, ok := adminActions[kind]
+		if !ok {
+			http.Error(w, "unknown action", 400)
+			return
+		}
+
+		user, err := s.Keycloak.GetUserByEmail(r.Context(), r.FormValue("email"))
+		if err != nil {
+			renderSystemError(w, "error while getting user: %s", err)
+			return
+		}
+
+		// The first step only asks for confirmation
+		if r.Method != http.MethodPost {
+			render(w, r, "admin-confirm.html", map[string]any{
+				"page":       "admin",
+				"user":       user,
+				"kind":       kind,
+				"action":     action,
+				"undoWindow": int(undoWindow.Minutes()),
+				"undoable":   reporting.DefaultSink.Enabled(),
+			})
+			return
+		}
+
+		id, err := s.applyAdminAction(r.Context(), kind, action, user, getUserID(r))
+		if err != nil {
+			renderSystemError(w, "error while applying admin action: %s", err)
+			return
+		}
+		if id == 0 && action.Deferred {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`Done!`)) // the user no longer exists
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/admin/member?email=%s&action=%d", url.QueryEscape(user.Email), id), http.StatusSeeOther)
+	}
+}
+
+// applyAdminAction carries out (or schedules) the action and records it so it can be undone.
+func (s *Server) applyAdminAction(ctx context.Context, kind string, action *adminAction, user *datamodel.User, actor string) (int64, error) {
+	now := time.Now()
+	prev, err := json.Marshal(&undoState{FobID: user.FobID, BuildingAccessApprover: user.BuildingAccessApprover})
+	if err != nil {
+		return 0, err
+	}
+
+	// Undo isn't possible without the reporting db, so deletions can't be deferred either
+	if action.Deferred && !reporting.DefaultSink.Enabled() {
+		log.Printf("applying admin action %s to user %s immediately because undo is unavailable", kind, user.UUID)
+		return 0, s.Keycloak.DeleteUser(ctx, user.UUID)
+	}
+
+	id, err := reporting.DefaultSink.RecordAdminAction(ctx, &reporting.AdminAction{
+		Time:          now,
+		Actor:         actor,
+		Email:         user.Email,
+		UserID:        user.UUID,
+		Kind:          kind,
+		Previous:      string(prev),
+		UndoExpiresAt: now.Add(undoWindow),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("recording action: %w", err)
+	}
+
+	if !action.Deferred {
+		action.apply(user)
+		if err := s.Keycloak.WriteUser(ctx, user); err != nil {
+			return 0, fmt.Errorf("writing user: %w", err)
+		}
+		s.Access.InvalidateUser(user.UUID)
+	}
+
+	log.Printf("admin action %s (id=%d) applied to user %s by %s", kind, id, user.UUID, actor)
+	reporting.DefaultSink.Eventf(user.Email, "AdminAction", "%s by %s", action.Title, actor)
+	return id, nil
+}
+
+func (s *Server) newAdminActionUndoHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid action ID", 400)
+			return
+		}
+
+		record, err := reporting.DefaultSink.GetAdminAction(r.Context(), id)
+		if err != nil {
+			renderSystemError(w, "error while getting admin action: %s", err)
+			return
+		}
+		if record == nil {
+			http.Error(w, "action not found", 404)
+			return
+		}
+		action, ok := adminActions[record.Kind]
+		if !ok {
+			http.Error(w, "unknown action", 400)
+			return
+		}
+
+		ok, err = reporting.DefaultSink.UndoAdminAction(r.Context(), id, time.Now())
+		if err != nil {
+			renderSystemError(w, "error while undoing admin action: %s", err)
+			return
+		}
+		if !ok {
+			http.Error(w, "this action can no longer be undone", 409)
+			return
+		}
+
+		if !action.Deferred {
+			if err := s.restoreUndoState(r.Context(), record); err != nil {
+				renderSystemError(w, "error while restoring user: %s", err)
+				return
+			}
+		}
+
+		log.Printf("admin action %s (id=%d) undone by %s", record.Kind, id, getUserID(r))
+		reporting.DefaultSink.Eventf(record.Email, "AdminActionUndone", "%s undone by %s", action.Title, getUserID(r))
+		http.Redirect(w, r, "/admin/member?email="+url.QueryEscape(record.Email), http.StatusSeeOther)
+	}
+}
+
+func (s *Server) restoreUndoState(ctx context.Context, record *reporting.AdminAction) error {
+	state := &undoState{}
+	if err := json.Unmarshal([]byte(record.Previous), state); err != nil {
+		return fmt.Errorf("decoding previous state: %w", err)
+	}
+
+	user, err := s.Keycloak.GetUser(ctx, record.UserID)
+	if err != nil {
+		return fmt.Errorf("getting user: %w", err)
+	}
+	user.FobID = state.FobID
+	user.BuildingAccessApprover = state.BuildingAccessApprover
+	if err := s.Keycloak.WriteUser(ctx, user); err != nil {
+		return fmt.Errorf("writing user: %w", err)
+	}
+	s.Access.InvalidateUser(user.UUID)
+	return nil
+}
@@ -1,45 +1,90 @@
 package server
 
 import (
+	"compress/gzip"
+	"context"
 	"encoding/csv"
 	"errors"
+	"fmt"
+	"io"
 	"log"
+	"math"
 	"net/http"
+	"net/url"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
+	"golang.org/x/time/rate"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/emailtmpl"
 	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/notify"
+	"github.com/TheLab-ms/profile/internal/payment"
 	"github.com/TheLab-ms/profile/internal/reporting"
+	"github.com/TheLab-ms/profile/internal/urls"
 )
 
 func (s *Server) newAdminDumpHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		users, err := s.Keycloak.ListUsers(r.Context())
-		if err != nil {
-			log.Printf("error while listing users: %s", err)
-			w.WriteHeader(500)
-			return
+		var out io.Writer = w
+		var gw *gzip.Writer
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Header().Set("Content-Encoding", "gzip")
+			gw = gzip.NewWriter(w)
+			out = gw
 		}
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="export.csv"`)
 
-		w.Header().Add("Content-Disposition", `"attachment; filename="export.csv"`)
-		cw := csv.NewWriter(w)
+		cw := csv.NewWriter(out)
 		cw.Write([]string{
 			"First", "Last", "Email", "Email Verified", "Waiver Signed",
 			"Payment Status", "Building Access Enabled", "Discount Type", "Keyfob ID",
-			"Signup Timestamp", "Last Visit Timestamp",
+			"Signup Timestamp", "Last Visit Timestamp", "Discord User ID", "Stripe Customer ID",
 		})
 
-		for _, extended := range users {
+		// Rows are written as users are paged from Keycloak, throttled to avoid hammering it
+		limiter := rate.NewLimiter(rate.Every(time.Millisecond*5), 150)
+		var rows int
+		err := s.Keycloak.ListUsersStream(r.Context(), func(extended *keycloak.ExtendedUser[*datamodel.User]) error {
+			if err := limiter.Wait(r.Context()); err != nil {
+				return err
+			}
+
 			user := extended.User
+			var discordID string
+			if user.DiscordUserID != 0 {
+				discordID = strconv.FormatInt(user.DiscordUserID, 10)
+			}
 			cw.Write([]string{
 				user.First, user.Last, user.Email,
 				strconv.FormatBool(user.EmailVerified), strconv.FormatBool(user.WaiverState == "Signed"),
 				user.PaymentStatus(), strconv.FormatBool(extended.ActiveMember && user.BuildingAccessApprover != ""),
 				user.DiscountType, strconv.Itoa(user.FobID),
 				user.SignupTime.Format(time.RFC3339), user.LastSwipeTime.Format(time.RFC3339),
+				discordID, user.StripeCustomerID,
 			})
+
+			rows++
+			if rows%150 == 0 {
+				cw.Flush()
+			}
+			return cw.Error()
+		})
+		if err != nil {
+			// The status code has probably already been sent, so abort the connection instead of ending the response.
+			// Otherwise a partial export would download as if it were complete.
+			log.Printf("error while streaming users: %s", err)
+			panic(http.ErrAbortHandler)
 		}
 		cw.Flush()
+		if gw != nil {
+			gw.Close()
+		}
 	}
 }
 
@@ -57,15 +102,37 @@ func (s *Server) newAssignFobHandler() http.HandlerFunc {
 			return
 		}
 
-		fobID, ok, err := reporting.DefaultSink.LastFobAssignment(r.Context(), granter.FobID)
-		if err != nil {
-			renderSystemError(w, "error while checking for fob swipes: %s", err)
-			return
-		}
-		if !ok {
-			w.Header().Set("Content-Type", "text/html")
-			w.Write([]byte(`<meta http-equiv="refresh" content="3">Swipe your fob, then a new / unassigned fob...<br><br><i>Leave this tab open during the process!</i>`))
-			return
+		// Confirmations of previously-warned assignments are posted with the ID of the new fob's swipe since the swipes
+		// have probably expired. The fob itself is read back from the swipe so it can't be swapped for another one.
+		confirmed := r.Method == http.MethodPost
+		var fobID, swipeID int
+		var ok bool
+		if confirmed {
+			swipeID, err = strconv.Atoi(r.PostFormValue("swipe"))
+			if err != nil {
+				http.Error(w, "invalid swipe ID", 400)
+				return
+			}
+			fobID, ok, err = reporting.DefaultSink.FobAssignmentSwipe(r.Context(), granter.FobID, swipeID)
+			if err != nil {
+				renderSystemError(w, "error while checking for fob swipes: %s", err)
+				return
+			}
+			if !ok {
+				http.Error(w, "the fob swipe has expired - please start over", 400)
+				return
+			}
+		} else {
+			fobID, swipeID, ok, err = reporting.DefaultSink.LastFobAssignment(r.Context(), granter.FobID)
+			if err != nil {
+				renderSystemError(w, "error while checking for fob swipes: %s", err)
+				return
+			}
+			if !ok {
+				w.Header().Set("Content-Type", "text/html")
+				w.Write([]byte(`<meta http-equiv="refresh" content="3">Swipe your fob, then a new / unassigned fob...<br><br><i>Leave this tab open during the process!</i>`))
+				return
+			}
 		}
 
 		_, err = s.Keycloak.GetUserByAttribute(r.Context(), "keyfobID", strconv.Itoa(fobID))
@@ -79,14 +146,840 @@ func (s *Server) newAssignFobHandler() http.HandlerFunc {
 			return
 		}
 
-		user.BuildingAccessApprover = getUserID(r)
-		user.FobID = fobID
+		// Facility badges are easy to mix up with member fobs.
+		// Fobs also aren't always returned when members leave, so warn if a former member may still have this one
+		if !confirmed {
+			warning := s.checkFobRange(fobID)
+			if warning == "" {
+				warning, err = s.checkPreviousFobHolder(r.Context(), fobID, user.Email)
+				if err != nil {
+					renderSystemError(w, "error while checking fob history: %s", err)
+					return
+				}
+			}
+			if warning != "" {
+				render(w, r, "admin-assign-fob.html", map[string]any{
+					"page":    "admin",
+					"user":    user,
+					"fobID":   fobID,
+					"swipeID": swipeID,
+					"warning": warning,
+				})
+				return
+			}
+		}
+
+		s.assignFob(w, r, user, granter, fobID)
+	}
+}
+
+// assignFob writes a fob assignment that has already been checked, and lets the member know about it.
+func (s *Server) assignFob(w http.ResponseWriter, r *http.Request, user, granter *datamodel.User, fobID int) {
+	prevFobID := user.FobID
+	user.BuildingAccessApprover = getUserID(r)
+	user.FobID = fobID
+	err := s.Keycloak.PatchUserAttributes(r.Context(), user.UUID, map[string]string{
+		"buildingAccessApprover": user.BuildingAccessApprover,
+		"keyfobID":               strconv.Itoa(fobID),
+	})
+	if err != nil {
+		renderSystemError(w, "error while writing to Keycloak: %s", err)
+		return
+	}
+	s.Access.InvalidateUser(user.UUID)
+
+	now := time.Now()
+	if prevFobID != 0 && prevFobID != fobID {
+		err = reporting.DefaultSink.RecordFobAssignment(r.Context(), &reporting.FobAssignment{Time: now, FobID: prevFobID, Email: user.Email, Actor: granter.Email, Assigned: false})
+		if err != nil {
+			log.Printf("error while recording fob unassignment: %s", err)
+		}
+	}
+	err = reporting.DefaultSink.RecordFobAssignment(r.Context(), &reporting.FobAssignment{Time: now, FobID: fobID, Email: user.Email, Actor: granter.Email, Assigned: true})
+	if err != nil {
+		log.Printf("error while recording fob assignment: %s", err)
+	}
+
+	// Members otherwise only find out that their fob works by trying it
+	w.Header().Set("Content-Type", "text/html")
+	channel, err := s.Notify.Notify(r.Context(), user, notify.ReasonFobAssigned, s.newFobAssignedEmail(user))
+	switch {
+	case err != nil:
+		log.Printf("error while notifying %s of their fob assignment: %s", user.Email, err)
+		w.Write([]byte(`Done! But the member couldn't be notified - let them know their fob is active.`))
+	case channel == "":
+		w.Write([]byte(`Done!`)) // notifications aren't configured
+	default:
+		fmt.Fprintf(w, "Done! The member was notified over %s.", channel)
+	}
+}
+
+func (s *Server) newFobAssignedEmail(user *datamodel.User) *emailtmpl.FobAssigned {
+	hours := "24/7"
+	if schedule := s.Env.AccessSchedules.ForTier(user.Tier()); !schedule.AlwaysOpen() {
+		hours = fmt.Sprintf("from %s daily", schedule)
+	}
+	return &emailtmpl.FobAssigned{Hours: hours, URL: urls.Profile(s.Env)}
+}
+
+// checkFobRange returns a warning message if the fob isn't one that should be handed out to members.
+func (s *Server) checkFobRange(fobID int) string {
+	if r := s.Env.FobReservedRanges.Find(fobID); r != nil {
+		return fmt.Sprintf("Fob %d is in the reserved range %s - it's probably not meant for members!", fobID, r)
+	}
+	if len(s.Env.FobMemberRanges) > 0 && s.Env.FobMemberRanges.Find(fobID) == nil {
+		return fmt.Sprintf("Fob %d is outside of the member fob ranges (%s). It might be a facility or service badge!", fobID, s.Env.FobMemberRanges)
+	}
+	return ""
+}
+
+// checkPreviousFobHolder returns a warning message if the fob was last held by a member who has since been deactivated.
+func (s *Server) checkPreviousFobHolder(ctx context.Context, fobID int, email string) (string, error) {
+	prev, ok, err := reporting.DefaultSink.LastFobHolder(ctx, fobID)
+	if err != nil || !ok || strings.EqualFold(prev.Email, email) {
+		return "", err
+	}
+
+	holder, err := s.Keycloak.GetUserByEmail(ctx, prev.Email)
+	if errors.Is(err, keycloak.ErrNotFound) {
+		return fmt.Sprintf("This fob was assigned to %s on %s, whose account has since been deleted. They may still have it!", prev.Email, prev.Time.Format("01/02/2006")), nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	extended, err := s.Keycloak.ExtendUser(ctx, holder, holder.UUID)
+	if err != nil {
+		return "", err
+	}
+	if !extended.ActiveMember || holder.BuildingAccessApprover == "" {
+		return fmt.Sprintf("This fob was assigned to %s on %s, who is no longer an active member. They may still have it!", prev.Email, prev.Time.Format("01/02/2006")), nil
+	}
+	return "", nil
+}
+
+func (s *Server) newAdminPricesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			log.Printf("refreshing Stripe caches because leadership requested it")
+			s.PriceCache.Kick()
+			http.Redirect(w, r, "/admin/prices?refreshed=true", http.StatusSeeOther)
+			return
+		}
+
+		// Deduplicate the discount types since multiple coupons can reference the same type
+		discountTypes := []string{}
+		seen := map[string]struct{}{}
+		for _, dt := range s.PriceCache.GetDiscountTypes() {
+			if _, ok := seen[dt]; ok {
+				continue
+			}
+			seen[dt] = struct{}{}
+			discountTypes = append(discountTypes, dt)
+		}
+		sort.Strings(discountTypes)
+
+		type discountRow struct {
+			DiscountType string
+			CouponID     string
+			AmountOff    float64
+			Discounted   float64
+		}
+		type priceRow struct {
+			ID, ProductID, ProductName string
+			Annual                     bool
+			Price                      float64
+			Discounts                  []*discountRow
+		}
+		rows := []*priceRow{}
+		for _, price := range s.PriceCache.GetPrices() {
+			row := &priceRow{ID: price.ID, ProductID: price.ProductID, ProductName: price.ProductName, Annual: price.Annual, Price: price.Price}
+			for _, dt := range discountTypes {
+				off := float64(price.CouponAmountsOff[dt]) / 100
+				row.Discounts = append(row.Discounts, &discountRow{
+					DiscountType: dt,
+					CouponID:     price.CouponIDs[dt],
+					AmountOff:    off,
+					Discounted:   price.Price - off,
+				})
+			}
+			rows = append(rows, row)
+		}
+
+		render(w, r, "admin-prices.html", map[string]any{
+			"page":          "admin",
+			"prices":        rows,
+			"discountTypes": discountTypes,
+			"refreshed":     r.URL.Query().Get("refreshed") != "",
+		})
+	}
+}
+
+func (s *Server) newAdminMemberHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := s.Keycloak.GetUserByEmail(r.Context(), r.URL.Query().Get("email"))
+		if errors.Is(err, keycloak.ErrNotFound) {
+			http.Error(w, "user not found", 404)
+			return
+		}
+		if err != nil {
+			renderSystemError(w, "error while getting user: %s", err)
+			return
+		}
+
+		extended, err := s.Keycloak.ExtendUser(r.Context(), user, user.UUID)
+		if err != nil {
+			renderSystemError(w, "error while getting user groups: %s", err)
+			return
+		}
+
+		viewData := map[string]any{
+			"page":    "admin",
+			"user":    user,
+			"active":  extended.ActiveMember,
+			"waivers": s.Env.DocusealURL != "",
+		}
+		viewData["discountTypes"] = s.PriceCache.GetDiscountTypes()
+		viewData["tiers"] = datamodel.Tiers
+		if !user.EmailBounceTime.IsZero() {
+			viewData["emailBounce"] = s.localTime(user.EmailBounceTime).Format("01/02/2006 3:04 PM")
+		}
+		if user.Banned {
+			viewData["banTime"] = s.localTime(user.BanTime).Format("01/02/2006 3:04 PM")
+		}
+		if user.DiscountExpiration.After(time.Unix(0, 0)) {
+			// The expiration is midnight after the last day of the discount
+			viewData["discountExpiration"] = s.localTime(user.DiscountExpiration).AddDate(0, 0, -1).Format("2006-01-02")
+		}
+		if user.StripeCustomerID != "" {
+			balance, err := s.Balances.Get(r.Context(), user.StripeCustomerID)
+			if err != nil {
+				viewData["balanceError"] = err.Error()
+			} else {
+				viewData["balance"] = float64(balance) / 100
+			}
+		}
+
+		history, err := reporting.DefaultSink.ListAttributeChanges(r.Context(), user.UUID, 50)
+		if err != nil {
+			log.Printf("error while listing attribute changes: %s", err)
+		}
+		for _, change := range history {
+			change.Time = s.localTime(change.Time)
+		}
+		viewData["history"] = history
+
+		// Show the undo option for an action that was just taken
+		if id, err := strconv.ParseInt(r.URL.Query().Get("action"), 10, 64); err == nil {
+			record, err := reporting.DefaultSink.GetAdminAction(r.Context(), id)
+			if err != nil {
+				log.Printf("error while getting admin action: %s", err)
+			} else if record.Undoable(time.Now()) && record.UserID == user.UUID {
+				viewData["undo"] = record
+				viewData["undoAction"] = adminActions[record.Kind]
+			}
+		}
+
+		render(w, r, "admin-member.html", viewData)
+	}
+}
+
+func (s *Server) newAdminFlagsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		viewData := map[string]any{"page": "admin"}
+		if r.Method == http.MethodPost {
+			percent, err := strconv.Atoi(r.FormValue("percent"))
+			if err != nil {
+				http.Error(w, "invalid percent", 400)
+				return
+			}
+			name := strings.TrimSpace(r.FormValue("name"))
+			if name == "" {
+				http.Error(w, "flag name is required", 400)
+				return
+			}
+
+			emails := []string{}
+			for _, email := range strings.FieldsFunc(r.FormValue("emails"), func(r rune) bool { return r == ',' || r == '\n' || r == ' ' }) {
+				emails = append(emails, strings.ToLower(strings.TrimSpace(email)))
+			}
+
+			err = s.Flags.Set(r.Context(), &reporting.FeatureFlag{
+				Name:        name,
+				Description: r.FormValue("description"),
+				Percent:     percent,
+				Emails:      emails,
+				UpdatedBy:   r.Header.Get("X-Forwarded-Email"),
+				UpdatedAt:   time.Now(),
+			})
+			if err != nil {
+				viewData["error"] = err.Error()
+			} else {
+				log.Printf("feature flag %q set to %d%% (%d emails) by %s", name, percent, len(emails), getUserID(r))
+				http.Redirect(w, r, "/admin/flags", http.StatusSeeOther)
+				return
+			}
+		}
+
+		viewData["flags"] = s.Flags.List()
+		render(w, r, "admin-flags.html", viewData)
+	}
+}
+
+// newAdminIncidentHandler edits the banner shown on the waiting room page while Keycloak is unavailable.
+// It's reachable during an outage since it doesn't depend on Keycloak.
+func (s *Server) newAdminIncidentHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		viewData := map[string]any{"page": "admin"}
+		if r.Method == http.MethodPost {
+			message := strings.TrimSpace(r.FormValue("message"))
+			err := reporting.DefaultSink.SetIncidentBanner(r.Context(), &reporting.IncidentBanner{
+				Message:   message,
+				UpdatedBy: r.Header.Get("X-Forwarded-Email"),
+				UpdatedAt: time.Now(),
+			})
+			if err != nil {
+				viewData["error"] = err.Error()
+			} else {
+				log.Printf("incident banner set to %q by %s", message, getUserID(r))
+				http.Redirect(w, r, "/admin/incident", http.StatusSeeOther)
+				return
+			}
+		}
+
+		banner, err := reporting.DefaultSink.GetIncidentBanner(r.Context())
+		if err != nil {
+			renderSystemError(w, "error while getting incident banner: %s", err)
+			return
+		}
+		viewData["banner"] = banner
+		viewData["degraded"] = s.Keycloak.Unavailable()
+		render(w, r, "admin-incident.html", viewData)
+	}
+}
+
+func (s *Server) newAdminEmailPreviewHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			name = emailtmpl.Names()[0]
+		}
+
+		subject, body, err := emailtmpl.Render(name, emailtmpl.Samples[name])
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+
+		// The body is loaded into an iframe so the email's styles don't mix with the page's
+		if r.URL.Query().Get("raw") != "" {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(body))
+			return
+		}
+
+		render(w, r, "admin-email-preview.html", map[string]any{
+			"page":    "admin",
+			"names":   emailtmpl.Names(),
+			"name":    name,
+			"subject": subject,
+		})
+	}
+}
+
+func (s *Server) newAdminCouponsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		viewData := map[string]any{"page": "admin"}
+		existing, err := payment.ListCoupons(r.Context())
+		if err != nil {
+			renderSystemError(w, "error while listing coupons: %s", err)
+			return
+		}
+		prices := s.PriceCache.GetPrices()
+
+		if r.Method == http.MethodPost {
+			problems, err := s.applyCouponChange(r, prices, existing)
+			if err != nil {
+				renderSystemError(w, "error while updating coupon: %s", err)
+				return
+			}
+			if len(problems) == 0 {
+				s.PriceCache.Kick()
+				http.Redirect(w, r, "/admin/coupons?saved=true", http.StatusSeeOther)
+				return
+			}
+			viewData["problems"] = problems
+		}
+
+		type couponRow struct {
+			*payment.Coupon
+			AmountOffDollars float64
+			Problems         []string
+		}
+		rows := []*couponRow{}
+		for _, c := range existing {
+			rows = append(rows, &couponRow{
+				Coupon:           c,
+				AmountOffDollars: float64(c.AmountOff) / 100,
+				Problems:         payment.ValidateCoupon(c, prices, existing),
+			})
+		}
+
+		viewData["coupons"] = rows
+		viewData["prices"] = prices
+		viewData["saved"] = r.URL.Query().Get("saved") != ""
+		render(w, r, "admin-coupons.html", viewData)
+	}
+}
+
+// applyCouponChange creates, updates, or deletes a coupon based on the form values.
+// Validation problems are returned instead of writing a coupon that the price cache would misinterpret.
+func (s *Server) applyCouponChange(r *http.Request, prices []*datamodel.PriceDetails, existing []*payment.Coupon) ([]string, error) {
+	c := &payment.Coupon{
+		ID:      r.FormValue("id"),
+		Name:    strings.TrimSpace(r.FormValue("name")),
+		PriceID: r.FormValue("priceID"),
+	}
+	for _, dt := range strings.Split(r.FormValue("discountTypes"), ",") {
+		if dt = strings.TrimSpace(dt); dt != "" {
+			c.DiscountTypes = append(c.DiscountTypes, dt)
+		}
+	}
+
+	switch r.FormValue("action") {
+	case "create":
+		dollars, err := strconv.ParseFloat(r.FormValue("amountOff"), 64)
+		if err != nil {
+			return []string{"invalid amount off"}, nil
+		}
+		c.AmountOff = int64(math.Round(dollars * 100))
+		if problems := payment.ValidateCoupon(c, prices, existing); len(problems) > 0 {
+			return problems, nil
+		}
+		if err := payment.CreateCoupon(r.Context(), c); err != nil {
+			return nil, err
+		}
+		log.Printf("coupon %s created for price %s by %s", c.ID, c.PriceID, getUserID(r))
+
+	case "update":
+		var current *payment.Coupon
+		for _, e := range existing {
+			if e.ID == c.ID {
+				current = e
+			}
+		}
+		if current == nil {
+			return []string{"coupon not found"}, nil
+		}
+		c.PriceID = current.PriceID // immutable, along with the amount
+		c.AmountOff = current.AmountOff
+		if problems := payment.ValidateCoupon(c, prices, existing); len(problems) > 0 {
+			return problems, nil
+		}
+		if err := payment.UpdateCoupon(r.Context(), c); err != nil {
+			return nil, err
+		}
+		log.Printf("coupon %s updated by %s", c.ID, getUserID(r))
+
+	case "delete":
+		if err := payment.DeleteCoupon(r.Context(), c.ID); err != nil {
+			return nil, err
+		}
+		log.Printf("coupon %s deleted by %s", c.ID, getUserID(r))
+
+	default:
+		return []string{"unknown action"}, nil
+	}
+	return nil, nil
+}
+
+func (s *Server) newAdminLockerHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user, err := s.Keycloak.GetUserByEmail(r.Context(), r.FormValue("email"))
+		if err != nil {
+			renderSystemError(w, "error while getting user: %s", err)
+			return
+		}
+		redirect := "/admin/member?email=" + url.QueryEscape(user.Email)
+
+		locker := strings.TrimSpace(r.FormValue("locker"))
+		if locker == user.LockerNumber {
+			http.Redirect(w, r, redirect, http.StatusSeeOther)
+			return
+		}
+
+		// Keycloak can't enforce unique attributes, so assignments are serialized to keep two admins from renting the same locker
+		var holder *datamodel.User
+		prev := user.LockerNumber
+		err = reporting.DefaultSink.WithLock(r.Context(), "lockers", func(ctx context.Context) error {
+			holder, err = s.setLocker(ctx, user, locker)
+			return err
+		})
+		if err != nil {
+			renderSystemError(w, "error while assigning locker: %s", err)
+			return
+		}
+		if holder != nil {
+			http.Error(w, fmt.Sprintf("locker %s is already rented by %s", locker, holder.Email), 409)
+			return
+		}
+
+		if locker == "" {
+			reporting.DefaultSink.Eventf(user.Email, "LockerReleased", "locker %s was released by %s", prev, getUserID(r))
+		} else {
+			reporting.DefaultSink.Eventf(user.Email, "LockerAssigned", "locker %s was assigned by %s (billed=%t)", locker, getUserID(r), user.StripeLockerItemID != "")
+		}
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+	}
+}
+
+func (s *Server) newAdminCertificationsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user, err := s.Keycloak.GetUserByEmail(r.Context(), r.FormValue("email"))
+		if err != nil {
+			renderSystemError(w, "error while getting user: %s", err)
+			return
+		}
+
+		certs := []string{}
+		for _, cert := range strings.Split(r.FormValue("certifications"), ",") {
+			if cert = strings.ToLower(strings.TrimSpace(cert)); cert != "" {
+				certs = append(certs, cert)
+			}
+		}
+		sort.Strings(certs)
+
+		user.Certifications = certs
 		err = s.Keycloak.WriteUser(r.Context(), user)
 		if err != nil {
 			renderSystemError(w, "error while writing to Keycloak: %s", err)
 			return
 		}
-		w.Header().Set("Content-Type", "text/html")
-		w.Write([]byte(`Done!`))
+
+		reporting.DefaultSink.Eventf(user.Email, "CertificationsChanged", "certifications were set to %q by %s", strings.Join(certs, ","), getUserID(r))
+		http.Redirect(w, r, "/admin/member?email="+url.QueryEscape(user.Email), http.StatusSeeOther)
+	}
+}
+
+// newAdminTierHandler sets the member's tier, which decides their building access schedule.
+func (s *Server) newAdminTierHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		tier := r.FormValue("tier")
+		if !slices.Contains(datamodel.Tiers, tier) {
+			http.Error(w, "unknown tier", 400)
+			return
+		}
+
+		user, err := s.Keycloak.GetUserByEmail(r.Context(), r.FormValue("email"))
+		if err != nil {
+			renderSystemError(w, "error while getting user: %s", err)
+			return
+		}
+
+		prev := user.Tier()
+		user.MembershipTier = tier
+		if tier == datamodel.TierStandard {
+			user.MembershipTier = ""
+		}
+		err = s.Keycloak.WriteUser(r.Context(), user)
+		if err != nil {
+			renderSystemError(w, "error while writing to Keycloak: %s", err)
+			return
+		}
+		s.Access.InvalidateUser(user.UUID)
+
+		reporting.DefaultSink.Eventf(user.Email, "TierChanged", "tier was changed from %s to %s by %s", prev, tier, getUserID(r))
+		http.Redirect(w, r, "/admin/member?email="+url.QueryEscape(user.Email), http.StatusSeeOther)
+	}
+}
+
+// newAdminBanHandler bans the member (or lifts their ban). Banned members are denied building access by the access
+// cache, and profile-async strips their Discord roles and syncs the ban to Conway when Keycloak notifies it of the change.
+func (s *Server) newAdminBanHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user, err := s.Keycloak.GetUserByEmail(r.Context(), r.FormValue("email"))
+		if err != nil {
+			renderSystemError(w, "error while getting user: %s", err)
+			return
+		}
+		redirect := "/admin/member?email=" + url.QueryEscape(user.Email)
+
+		banned := r.FormValue("banned") == "true"
+		reason := strings.TrimSpace(r.FormValue("reason"))
+		if banned && reason == "" {
+			http.Error(w, "a reason is required to ban a member", 400)
+			return
+		}
+		if banned == user.Banned {
+			http.Redirect(w, r, redirect, http.StatusSeeOther)
+			return
+		}
+
+		user.Banned = banned
+		if banned {
+			user.BanReason = reason
+			user.BanTime = time.Now()
+		} else {
+			user.BanReason = ""
+			user.BanTime = time.Time{}
+		}
+		err = s.Keycloak.WriteUser(r.Context(), user)
+		if err != nil {
+			renderSystemError(w, "error while writing to Keycloak: %s", err)
+			return
+		}
+		s.Access.InvalidateUser(user.UUID)
+
+		if banned {
+			log.Printf("user %s was banned by %s", user.Email, getUserID(r))
+			reporting.DefaultSink.Eventf(user.Email, "MemberBanned", "banned by %s: %s", getUserID(r), reason)
+		} else {
+			log.Printf("ban of user %s was lifted by %s", user.Email, getUserID(r))
+			reporting.DefaultSink.Eventf(user.Email, "MemberBanLifted", "ban was lifted by %s", getUserID(r))
+		}
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+	}
+}
+
+// newAdminQuarantineHandler lists unconfirmed accounts that cmd/visit-check-job would have deleted if they
+// weren't involved in payments, so leadership can decide what to do with them.
+func (s *Server) newAdminQuarantineHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accounts, err := reporting.DefaultSink.ListQuarantine(r.Context())
+		if err != nil {
+			renderSystemError(w, "error while listing quarantined accounts: %s", err)
+			return
+		}
+
+		render(w, r, "admin-quarantine.html", map[string]any{
+			"page":     "admin",
+			"accounts": accounts,
+			"enabled":  reporting.DefaultSink.Enabled(),
+		})
+	}
+}
+
+// newAdminPendingHandler lists the building access revocations that cmd/visit-check-job is holding for review, and
+// lets leadership exempt members from them.
+func (s *Server) newAdminPendingHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			reason := strings.TrimSpace(r.FormValue("reason"))
+			if reason == "" {
+				http.Error(w, "a reason is required to exempt a member", 400)
+				return
+			}
+			email := r.FormValue("email")
+			ok, err := reporting.DefaultSink.ExemptPendingAction(r.Context(), reporting.PendingRevokeAccess, r.FormValue("user"), getUserID(r), reason, time.Now())
+			if err != nil {
+				renderSystemError(w, "error while exempting member: %s", err)
+				return
+			}
+			if !ok {
+				http.Error(w, "the revocation has already been applied or exempted", 409)
+				return
+			}
+			reporting.DefaultSink.Eventf(email, "RevocationExempted", "exempted from building access revocation by %s: %s", getUserID(r), reason)
+			http.Redirect(w, r, "/admin/pending", http.StatusSeeOther)
+			return
+		}
+
+		actions, err := reporting.DefaultSink.ListPendingActions(r.Context(), reporting.PendingRevokeAccess)
+		if err != nil {
+			renderSystemError(w, "error while listing pending actions: %s", err)
+			return
+		}
+		open := []*reporting.PendingAction{}
+		exempted := []*reporting.PendingAction{}
+		for _, a := range actions {
+			a.AppliesAt = s.localTime(a.AppliesAt)
+			a.ExemptedAt = s.localTime(a.ExemptedAt)
+			switch {
+			case a.Open():
+				open = append(open, a)
+			case a.AppliedAt.IsZero():
+				exempted = append(exempted, a)
+			}
+		}
+
+		render(w, r, "admin-pending.html", map[string]any{
+			"page":     "admin",
+			"open":     open,
+			"exempted": exempted,
+			"window":   int(s.Env.DeactivationReviewWindow.Hours() / 24),
+			"enabled":  reporting.DefaultSink.Enabled(),
+		})
+	}
+}
+
+// newAdminDiscountHandler sets the member's discount type, optionally expiring at the end of the given day
+// (see cmd/discount-check-job). The discount is applied at checkout for new subscriptions. Changes to an active
+// subscription are previewed with Stripe first, and only made once leadership confirms them.
+func (s *Server) newAdminDiscountHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user, err := s.Keycloak.GetUserByEmail(r.Context(), r.FormValue("email"))
+		if err != nil {
+			renderSystemError(w, "error while getting user: %s", err)
+			return
+		}
+
+		var expiration time.Time
+		if str := r.FormValue("expiration"); str != "" {
+			loc, _ := time.LoadLocation(s.Env.SpaceTimezone) // validated at startup
+			day, err := time.ParseInLocation("2006-01-02", str, loc)
+			if err != nil {
+				http.Error(w, "invalid expiration date", 400)
+				return
+			}
+			expiration = day.AddDate(0, 0, 1)
+		}
+
+		discountType := r.FormValue("discountType")
+		if discountType == "" {
+			expiration = time.Time{}
+		}
+		if expiration.After(time.Unix(0, 0)) && !expiration.After(time.Now()) {
+			http.Error(w, "expiration must be in the future", 400)
+			return
+		}
+
+		billed := false
+		if user.StripeSubscriptionID != "" && discountType != user.DiscountType {
+			if r.FormValue("confirm") == "" {
+				s.renderDiscountPreview(w, r, user, discountType)
+				return
+			}
+			billed, err = payment.ApplySubscriptionDiscount(r.Context(), user.StripeSubscriptionID, discountType, s.PriceCache.GetPrices())
+			if err != nil {
+				renderSystemError(w, "error while updating Stripe subscription: %s", err)
+				return
+			}
+		}
+
+		attrs := map[string]string{"discountType": discountType}
+		if !expiration.Equal(user.DiscountExpiration) {
+			attrs["discountExpiration"] = keycloak.TimeAttr(expiration)
+			attrs["discountNoticeTime"] = ""
+		}
+		err = s.Keycloak.PatchUserAttributes(r.Context(), user.UUID, attrs)
+		if err != nil {
+			renderSystemError(w, "error while writing to Keycloak: %s", err)
+			return
+		}
+
+		reporting.DefaultSink.Eventf(user.Email, "DiscountChanged", "discount was set to %q (expires %s) by %s (subscription updated=%t)", discountType, r.FormValue("expiration"), getUserID(r), billed)
+		http.Redirect(w, r, "/admin/member?email="+url.QueryEscape(user.Email), http.StatusSeeOther)
+	}
+}
+
+// renderDiscountPreview shows leadership how a discount change would affect the member's subscription.
+// Confirming it re-submits the discount form.
+func (s *Server) renderDiscountPreview(w http.ResponseWriter, r *http.Request, user *datamodel.User, discountType string) {
+	preview, err := payment.PreviewSubscriptionDiscount(r.Context(), user.StripeSubscriptionID, discountType, s.PriceCache.GetPrices())
+	if err != nil {
+		renderSystemError(w, "error while previewing subscription change: %s", err)
+		return
+	}
+
+	dollars := func(cents int64) string { return fmt.Sprintf("$%.2f", float64(cents)/100) }
+	render(w, r, "admin-discount-preview.html", map[string]any{
+		"page":         "admin",
+		"user":         user,
+		"discountType": discountType,
+		"expiration":   r.FormValue("expiration"),
+		"matched":      preview.Matched,
+		"current":      dollars(preview.Current),
+		"new":          dollars(preview.New),
+		"proration":    dollars(preview.Proration),
+		"nextInvoice":  s.localTime(preview.NextInvoice).Format("January 2, 2006"),
+	})
+}
+
+// newAdminViewAsHandler renders a member's profile exactly as they see it, to help troubleshoot their reports.
+// The page is read-only: the profile forms act on whoever submits them, so they're disabled to avoid leadership
+// accidentally changing their own profile.
+func (s *Server) newAdminViewAsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := s.Keycloak.GetUserByEmail(r.Context(), r.URL.Query().Get("email"))
+		if errors.Is(err, keycloak.ErrNotFound) {
+			http.Error(w, "user not found", 404)
+			return
+		}
+		if err != nil {
+			renderSystemError(w, "error while getting user: %s", err)
+			return
+		}
+
+		viewData, err := s.getProfileViewData(r.Context(), user)
+		if err != nil {
+			renderSystemError(w, "%s", err)
+			return
+		}
+
+		actor := r.Header.Get("X-Forwarded-Email")
+		viewData["impersonator"] = actor
+		log.Printf("%s is viewing the profile of %s", actor, user.Email)
+		reporting.DefaultSink.Eventf(user.Email, "ProfileImpersonated", "%s viewed the member's profile as the member", actor)
+		render(w, r, "profile.html", viewData)
+	}
+}
+
+// setLocker rents the locker to the member, or releases their locker when empty. The member currently renting the
+// locker is returned instead if it isn't available.
+func (s *Server) setLocker(ctx context.Context, user *datamodel.User, locker string) (*datamodel.User, error) {
+	if locker != "" {
+		holder, err := s.Keycloak.GetUserByAttribute(ctx, "lockerNumber", locker)
+		if err == nil && holder.UUID != user.UUID {
+			return holder, nil
+		}
+		if err != nil && !errors.Is(err, keycloak.ErrNotFound) {
+			return nil, fmt.Errorf("checking locker assignment: %w", err)
+		}
+	}
+
+	// Billing only changes when the member starts or stops renting - moving to another locker costs the same
+	if locker == "" && user.StripeLockerItemID != "" {
+		if err := payment.RemoveLockerItem(ctx, user.StripeLockerItemID); err != nil {
+			return nil, fmt.Errorf("removing locker from Stripe subscription: %w", err)
+		}
+		user.StripeLockerItemID = ""
+	}
+	if locker != "" && user.StripeLockerItemID == "" && user.StripeSubscriptionID != "" && s.Env.StripeLockerPrice != "" {
+		itemID, err := payment.AddLockerItem(ctx, user.StripeSubscriptionID, s.Env.StripeLockerPrice)
+		if err != nil {
+			return nil, fmt.Errorf("adding locker to Stripe subscription: %w", err)
+		}
+		user.StripeLockerItemID = itemID
+	}
+
+	user.LockerNumber = locker
+	if err := s.Keycloak.WriteUser(ctx, user); err != nil {
+		return nil, fmt.Errorf("writing to Keycloak: %w", err)
 	}
+	return nil, nil
 }
@@ -0,0 +1,279 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TheLab-ms/profile/internal/conf"
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/keycloak/keycloaktest"
+	"github.com/TheLab-ms/profile/internal/notify"
+	"github.com/TheLab-ms/profile/internal/payment"
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+func TestAssignFobNotification(t *testing.T) {
+	kcFake := keycloaktest.NewFake("members")
+	kcFake.AddUser(&gocloak.User{
+		ID:       gocloak.StringP("admin"),
+		Username: gocloak.StringP("admin@example.com"),
+		Email:    gocloak.StringP("admin@example.com"),
+	}, true)
+	kcFake.AddUser(&gocloak.User{
+		ID:       gocloak.StringP("member"),
+		Username: gocloak.StringP("member@example.com"),
+		Email:    gocloak.StringP("member@example.com"),
+	}, true)
+	kcServer := httptest.NewServer(kcFake)
+	t.Cleanup(kcServer.Close)
+
+	env := &conf.Env{
+		KeycloakURL:            kcServer.URL,
+		KeycloakRealm:          "master",
+		KeycloakMembersGroupID: "members",
+		KeycloakClientID:       "test",
+		KeycloakClientSecret:   "test",
+		SelfURL:                "https://example.com",
+		AccessSchedules:        conf.AccessSchedules{datamodel.TierStandard: {OpenHour: 8, CloseHour: 22}, datamodel.TierPremium: {OpenHour: 0, CloseHour: 24}},
+	}
+	kc := keycloak.New[*datamodel.User](env)
+	kc.Sink = reporting.DefaultSink // disabled, but nil-safe
+	emails := &fakeEmailSender{}
+	s := &Server{Env: env, Keycloak: kc, Notify: notify.New(nil, emails)}
+
+	// Confirmations must refer to a real swipe, not just any fob ID
+	req := httptest.NewRequest("POST", "/admin/assign-fob?email=member@example.com", strings.NewReader("fob=123&swipe=1"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Forwarded-Preferred-Username", "admin")
+	w := httptest.NewRecorder()
+	s.newAssignFobHandler()(w, req)
+	assert.Equal(t, 400, w.Code)
+
+	user, err := kc.GetUser(context.Background(), "member")
+	require.NoError(t, err)
+	assert.Equal(t, 0, user.FobID)
+
+	admin, err := kc.GetUser(context.Background(), "admin")
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	s.assignFob(w, req, user, admin, 123)
+	require.Equal(t, 200, w.Code)
+	assert.Equal(t, "Done! The member was notified over email.", w.Body.String())
+	assert.Equal(t, []string{notify.ReasonFobAssigned}, emails.sent)
+
+	user, err = kc.GetUser(context.Background(), "member")
+	require.NoError(t, err)
+	assert.Equal(t, 123, user.FobID)
+
+	assert.Equal(t, "from 8am-10pm daily", s.newFobAssignedEmail(user).Hours)
+
+	user.MembershipTier = datamodel.TierPremium
+	assert.Equal(t, "24/7", s.newFobAssignedEmail(user).Hours)
+}
+
+func TestAdminViewAs(t *testing.T) {
+	kcFake := keycloaktest.NewFake("members")
+	kcFake.AddUser(&gocloak.User{
+		ID:        gocloak.StringP("member"),
+		Username:  gocloak.StringP("member@example.com"),
+		Email:     gocloak.StringP("member@example.com"),
+		FirstName: gocloak.StringP("Ada"),
+	}, true)
+	kcServer := httptest.NewServer(kcFake)
+	t.Cleanup(kcServer.Close)
+
+	env := &conf.Env{
+		KeycloakURL:            kcServer.URL,
+		KeycloakRealm:          "master",
+		KeycloakMembersGroupID: "members",
+		KeycloakClientID:       "test",
+		KeycloakClientSecret:   "test",
+		AccessSchedules:        conf.AccessSchedules{datamodel.TierStandard: {OpenHour: 8, CloseHour: 22}},
+	}
+	kc := keycloak.New[*datamodel.User](env)
+	kc.Sink = reporting.DefaultSink // disabled, but nil-safe
+	s := &Server{Env: env, Keycloak: kc, PriceCache: &payment.PriceCache{}}
+
+	req := httptest.NewRequest("GET", "/admin/view-as?email=member@example.com", nil)
+	req.Header.Set("X-Forwarded-Email", "admin@example.com")
+	w := httptest.NewRecorder()
+	s.newAdminViewAsHandler()(w, req)
+	require.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), "profile as they see it")
+	assert.Contains(t, w.Body.String(), `value="Ada"`)
+	assert.Contains(t, w.Body.String(), "<fieldset disabled")
+
+	req = httptest.NewRequest("GET", "/admin/view-as?email=nobody@example.com", nil)
+	w = httptest.NewRecorder()
+	s.newAdminViewAsHandler()(w, req)
+	assert.Equal(t, 404, w.Code)
+}
+
+func TestAdminBan(t *testing.T) {
+	kcFake := keycloaktest.NewFake("members")
+	kcFake.AddUser(&gocloak.User{
+		ID:       gocloak.StringP("member"),
+		Username: gocloak.StringP("member@example.com"),
+		Email:    gocloak.StringP("member@example.com"),
+	}, true)
+	kcServer := httptest.NewServer(kcFake)
+	t.Cleanup(kcServer.Close)
+
+	env := &conf.Env{
+		KeycloakURL:            kcServer.URL,
+		KeycloakRealm:          "master",
+		KeycloakMembersGroupID: "members",
+		KeycloakClientID:       "test",
+		KeycloakClientSecret:   "test",
+	}
+	kc := keycloak.New[*datamodel.User](env)
+	kc.Sink = reporting.DefaultSink // disabled, but nil-safe
+	s := &Server{Env: env, Keycloak: kc}
+
+	ban := func(form string) int {
+		req := httptest.NewRequest("POST", "/admin/ban", strings.NewReader(form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Forwarded-Preferred-Username", "admin")
+		w := httptest.NewRecorder()
+		s.newAdminBanHandler()(w, req)
+		return w.Code
+	}
+
+	// A reason is required
+	assert.Equal(t, 400, ban("email=member@example.com&banned=true"))
+
+	assert.Equal(t, 303, ban("email=member@example.com&banned=true&reason=safety"))
+	user, err := kc.GetUser(context.Background(), "member")
+	require.NoError(t, err)
+	assert.True(t, user.Banned)
+	assert.Equal(t, "safety", user.BanReason)
+	assert.False(t, user.BanTime.IsZero())
+
+	assert.Equal(t, 303, ban("email=member@example.com&banned=false"))
+	user, err = kc.GetUser(context.Background(), "member")
+	require.NoError(t, err)
+	assert.False(t, user.Banned)
+	assert.Empty(t, user.BanReason)
+}
+
+func TestAdminTier(t *testing.T) {
+	kcFake := keycloaktest.NewFake("members")
+	kcFake.AddUser(&gocloak.User{
+		ID:       gocloak.StringP("member"),
+		Username: gocloak.StringP("member@example.com"),
+		Email:    gocloak.StringP("member@example.com"),
+	}, true)
+	kcServer := httptest.NewServer(kcFake)
+	t.Cleanup(kcServer.Close)
+
+	env := &conf.Env{
+		KeycloakURL:            kcServer.URL,
+		KeycloakRealm:          "master",
+		KeycloakMembersGroupID: "members",
+		KeycloakClientID:       "test",
+		KeycloakClientSecret:   "test",
+	}
+	kc := keycloak.New[*datamodel.User](env)
+	kc.Sink = reporting.DefaultSink // disabled, but nil-safe
+	s := &Server{Env: env, Keycloak: kc}
+
+	setTier := func(tier string) int {
+		req := httptest.NewRequest("POST", "/admin/tier", strings.NewReader("email=member@example.com&tier="+tier))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Forwarded-Preferred-Username", "admin")
+		w := httptest.NewRecorder()
+		s.newAdminTierHandler()(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, 400, setTier("platinum"))
+
+	assert.Equal(t, 303, setTier(datamodel.TierPremium))
+	user, err := kc.GetUser(context.Background(), "member")
+	require.NoError(t, err)
+	assert.Equal(t, datamodel.TierPremium, user.Tier())
+
+	assert.Equal(t, 303, setTier(datamodel.TierStandard))
+	user, err = kc.GetUser(context.Background(), "member")
+	require.NoError(t, err)
+	assert.Equal(t, datamodel.TierStandard, user.Tier())
+	assert.Empty(t, user.MembershipTier)
+}
+
+func TestAdminLocker(t *testing.T) {
+	kcFake := keycloaktest.NewFake("members")
+	kcFake.AddUser(&gocloak.User{
+		ID:         gocloak.StringP("holder"),
+		Username:   gocloak.StringP("holder@example.com"),
+		Email:      gocloak.StringP("holder@example.com"),
+		Attributes: &map[string][]string{"lockerNumber": {"A1"}},
+	}, true)
+	kcFake.AddUser(&gocloak.User{
+		ID:       gocloak.StringP("member"),
+		Username: gocloak.StringP("member@example.com"),
+		Email:    gocloak.StringP("member@example.com"),
+	}, true)
+	kcServer := httptest.NewServer(kcFake)
+	t.Cleanup(kcServer.Close)
+
+	env := &conf.Env{
+		KeycloakURL:            kcServer.URL,
+		KeycloakRealm:          "master",
+		KeycloakMembersGroupID: "members",
+		KeycloakClientID:       "test",
+		KeycloakClientSecret:   "test",
+	}
+	kc := keycloak.New[*datamodel.User](env)
+	kc.Sink = reporting.DefaultSink // disabled, but nil-safe
+	s := &Server{Env: env, Keycloak: kc}
+
+	setLocker := func(locker string) int {
+		req := httptest.NewRequest("POST", "/admin/locker", strings.NewReader("email=member@example.com&locker="+locker))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Forwarded-Preferred-Username", "admin")
+		w := httptest.NewRecorder()
+		s.newAdminLockerHandler()(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, 409, setLocker("A1"))
+
+	assert.Equal(t, 303, setLocker("A2"))
+	user, err := kc.GetUser(context.Background(), "member")
+	require.NoError(t, err)
+	assert.Equal(t, "A2", user.LockerNumber)
+
+	assert.Equal(t, 303, setLocker(""))
+	user, err = kc.GetUser(context.Background(), "member")
+	require.NoError(t, err)
+	assert.Empty(t, user.LockerNumber)
+}
+
+func TestAdminDumpAbortsOnError(t *testing.T) {
+	kcServer := httptest.NewServer(keycloaktest.NewFake("members"))
+	kcServer.Close() // every Keycloak request fails
+
+	env := &conf.Env{
+		KeycloakURL:            kcServer.URL,
+		KeycloakRealm:          "master",
+		KeycloakMembersGroupID: "members",
+		KeycloakClientID:       "test",
+		KeycloakClientSecret:   "test",
+	}
+	kc := keycloak.New[*datamodel.User](env)
+	kc.Sink = reporting.DefaultSink // disabled, but nil-safe
+	s := &Server{Env: env, Keycloak: kc}
+
+	req := httptest.NewRequest("GET", "/admin/dump", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() { s.newAdminDumpHandler()(w, req) })
+}
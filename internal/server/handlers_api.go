@@ -1,20 +1,44 @@
 package server
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/TheLab-ms/profile/internal/access"
+	"github.com/TheLab-ms/profile/internal/chatbot"
 	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/events"
+	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/payment"
+	"github.com/TheLab-ms/profile/internal/reporting"
 )
 
+var unsignedWaiverEntries = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "profile_access_unsigned_waiver_total",
+	Help: "Count of fobs allowed in by the access check without a signed waiver (see ACCESS_WAIVER_POLICY)",
+})
+
+// newListEventsHandler returns upcoming events for the website, optionally filtered to any of the ?category= params.
 func (s *Server) newListEventsHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		events, err := s.EventsCache.GetEvents(time.Now().Add(time.Hour * 24 * 60))
+		all, err := s.EventsCache.GetEvents(time.Now().Add(time.Hour * 24 * 60))
 		if err != nil {
 			renderSystemError(w, "getting cached events: %s", err)
 			return
 		}
+		events := events.FilterCategories(all, queryCategories(r))
 
 		w.Header().Set("Content-Type", "application/json")
 		w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -24,14 +48,414 @@ func (s *Server) newListEventsHandler() http.HandlerFunc {
 	}
 }
 
+// newPricingHandler returns the membership prices. With ?personalized=true, logged in members get the prices they'd
+// actually pay (see payment.NewPersonalizedPrices) so front-ends don't need to duplicate the discount logic.
 func (s *Server) newPricingHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		items := s.PriceCache.GetPrices()
+		prices := datamodel.NewPrices(s.PriceCache.GetProductPrices(datamodel.ProductMembership))
+		if r.URL.Query().Get("personalized") == "true" {
+			userID := getUserID(r)
+			if userID == "" {
+				http.Error(w, "personalized prices require logging in", 401)
+				return
+			}
+			user, err := s.Keycloak.GetUser(r.Context(), userID)
+			if err != nil {
+				renderSystemError(w, "error while getting user: %s", err)
+				return
+			}
+			prices = payment.NewPersonalizedPrices(user, s.PriceCache)
+			w.Header().Set("Cache-Control", "private, no-store")
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Headers", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET")
-		json.NewEncoder(w).Encode(datamodel.NewPrices(items))
+		json.NewEncoder(w).Encode(prices)
+	}
+}
+
+func (s *Server) newAccessCheckHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fobID, err := strconv.Atoi(r.URL.Query().Get("fob"))
+		if err != nil {
+			http.Error(w, "invalid fob ID", 400)
+			return
+		}
+		if !s.Access.Synced() {
+			http.Error(w, "access cache is warming up", http.StatusServiceUnavailable)
+			return
+		}
+
+		// Members can only enter during their tier's hours
+		tier, ok := s.Access.Tier(fobID)
+		allowed := ok && s.Env.AccessSchedules.ForTier(tier).AllowedAt(s.localTime(time.Now()))
+		resp := map[string]any{"allowed": allowed}
+
+		// Members who haven't signed the waiver are only let in when the policy is warn-only (the cache denies them
+		// otherwise), so the controller can prompt them to sign it
+		if allowed && !s.Access.WaiverSigned(fobID) {
+			resp["warning"] = "waiver_unsigned"
+			unsignedWaiverEntries.Inc()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// newPINAccessHandler checks keypad PINs for doors without a fob reader, applying the same rules as fobs.
+// The PIN is posted rather than passed in the query string to keep it out of access logs.
+// Controllers are expected to lock the keypad after repeated failures.
+func (s *Server) newPINAccessHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		pin := r.PostFormValue("pin")
+		if pin == "" {
+			http.Error(w, "missing PIN", 400)
+			return
+		}
+		if !s.Access.Synced() {
+			http.Error(w, "access cache is warming up", http.StatusServiceUnavailable)
+			return
+		}
+
+		tier, ok := s.Access.PINTier(access.HashPIN(s.Env.DoorPINKey, pin))
+		allowed := ok && s.Env.AccessSchedules.ForTier(tier).AllowedAt(s.localTime(time.Now()))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"allowed": allowed})
+	}
+}
+
+// allowlist is what door controllers cache so they keep working during network outages.
+// Deltas only include the fobs that changed since the controller's version, plus the ones that lost access.
+type allowlist struct {
+	Version   int64                      `json:"version"`
+	Full      bool                       `json:"full"`
+	Fobs      []int                      `json:"fobs"`
+	Tiers     map[string][]int           `json:"tiers"` // tier -> fob IDs
+	Removed   []int                      `json:"removed"`
+	Schedules map[string]*allowlistHours `json:"schedules"`
+	Timezone  string                     `json:"timezone"`
+	Generated int64                      `json:"generated"` // when the cache was last fully rebuilt
+	IssuedAt  int64                      `json:"iat"`
+}
+
+type allowlistHours struct {
+	Open  int `json:"open"`
+	Close int `json:"close"`
+}
+
+func (s *Server) newAllowlist(delta *access.AllowlistDelta, now time.Time) *allowlist {
+	a := &allowlist{
+		Version:   delta.Version,
+		Full:      delta.Full,
+		Fobs:      make([]int, len(delta.Fobs)),
+		Tiers:     map[string][]int{},
+		Removed:   delta.Removed,
+		Schedules: map[string]*allowlistHours{},
+		Timezone:  s.Env.SpaceTimezone,
+		Generated: delta.Built.Unix(),
+		IssuedAt:  now.Unix(),
+	}
+	for i, entry := range delta.Fobs {
+		a.Fobs[i] = entry.FobID
+		a.Tiers[entry.Tier] = append(a.Tiers[entry.Tier], entry.FobID)
+		if _, ok := a.Schedules[entry.Tier]; !ok {
+			sched := s.Env.AccessSchedules.ForTier(entry.Tier)
+			a.Schedules[entry.Tier] = &allowlistHours{Open: sched.OpenHour, Close: sched.CloseHour}
+		}
+	}
+	return a
+}
+
+// newAllowlistHandler returns every fob with building access so controllers can keep working while offline.
+// Requests to /api/v1/access/allowlist/delta?since=<version> only return what changed since then.
+func (s *Server) newAllowlistHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.Access.Synced() {
+			http.Error(w, "access cache is warming up", http.StatusServiceUnavailable)
+			return
+		}
+
+		var since int64
+		if strings.HasSuffix(r.URL.Path, "/delta") {
+			var err error
+			since, err = strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+			if err != nil {
+				http.Error(w, "invalid since version", 400)
+				return
+			}
+		}
+
+		body, err := json.Marshal(s.newAllowlist(s.Access.AllowlistSince(since), time.Now()))
+		if err != nil {
+			renderSystemError(w, "error while encoding allowlist: %s", err)
+			return
+		}
+		if s.Env.AccessSigningKey == "" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(body)
+			return
+		}
+		w.Header().Set("Content-Type", "application/jose")
+		io.WriteString(w, signJWS(s.Env.AccessSigningKey, body))
+	}
+}
+
+// jwsHeader is the only header we sign with, so it's also the only one verifyJWS accepts.
+const jwsHeader = `{"alg":"HS256","typ":"JWT"}`
+
+var errInvalidJWS = errors.New("invalid signature")
+
+// signJWS returns the payload as a compact HS256 JWS, which controllers verify before replacing their cached allowlist.
+// It also signs magic link tokens.
+func signJWS(key string, payload []byte) string {
+	enc := base64.RawURLEncoding
+	signed := enc.EncodeToString([]byte(jwsHeader)) + "." + enc.EncodeToString(payload)
+	return signed + "." + enc.EncodeToString(jwsMAC(key, signed))
+}
+
+// verifyJWS returns the payload of a token created by signJWS with the same key.
+func verifyJWS(key, token string) ([]byte, error) {
+	enc := base64.RawURLEncoding
+	header, rest, _ := strings.Cut(token, ".")
+	payload, sig, ok := strings.Cut(rest, ".")
+	if !ok || key == "" || header != enc.EncodeToString([]byte(jwsHeader)) {
+		return nil, errInvalidJWS
+	}
+	mac, err := enc.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, jwsMAC(key, header+"."+payload)) {
+		return nil, errInvalidJWS
+	}
+	return enc.DecodeString(payload)
+}
+
+func jwsMAC(key, signed string) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(signed))
+	return mac.Sum(nil)
+}
+
+// fobResolveMaxIDs limits how many fobs can be resolved in a single request.
+const fobResolveMaxIDs = 100
+
+type resolvedFob struct {
+	Fob    int    `json:"fob"`
+	Name   string `json:"name,omitempty"`
+	Status string `json:"status"` // active, inactive, or unassigned
+}
+
+// newFobResolveHandler annotates fob IDs with the members they're assigned to, for tools that display raw swipe logs.
+// Fobs are passed as repeated "fob" query params and resolved from the access cache, so Keycloak isn't queried per fob.
+func (s *Server) newFobResolveHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()["fob"]
+		if len(params) == 0 || len(params) > fobResolveMaxIDs {
+			http.Error(w, fmt.Sprintf("between 1 and %d fob IDs are required", fobResolveMaxIDs), 400)
+			return
+		}
+		fobIDs := make([]int, len(params))
+		for i, param := range params {
+			id, err := strconv.Atoi(param)
+			if err != nil || id == 0 {
+				http.Error(w, fmt.Sprintf("invalid fob ID: %q", param), 400)
+				return
+			}
+			fobIDs[i] = id
+		}
+		if !s.Access.Synced() {
+			http.Error(w, "access cache is warming up", http.StatusServiceUnavailable)
+			return
+		}
+
+		holders := s.Access.Holders(fobIDs)
+		resp := make([]*resolvedFob, len(fobIDs))
+		for i, id := range fobIDs {
+			resp[i] = &resolvedFob{Fob: id, Status: "unassigned"}
+			if holder, ok := holders[id]; ok {
+				resp[i].Name = holder.Name
+				resp[i].Status = "inactive"
+				if holder.Access {
+					resp[i].Status = "active"
+				}
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"fobs": resp})
+	}
+}
+
+// newEmergencyContactHandler looks up a member's emergency contact by fob ID for use during incidents at the space.
+// Every lookup is recorded since the data is sensitive.
+func (s *Server) newEmergencyContactHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fobID, err := strconv.Atoi(r.URL.Query().Get("fob"))
+		if err != nil || fobID == 0 {
+			http.Error(w, "invalid fob ID", 400)
+			return
+		}
+
+		user, err := s.Keycloak.GetUserByAttribute(r.Context(), "keyfobID", strconv.Itoa(fobID))
+		if errors.Is(err, keycloak.ErrNotFound) {
+			http.Error(w, "fob not found", 404)
+			return
+		}
+		if err != nil {
+			renderSystemError(w, "error while getting user by fob ID: %s", err)
+			return
+		}
+		reporting.DefaultSink.Eventf(user.Email, "EmergencyContactAccessed", "emergency contact was looked up using fob %d", fobID)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"member": fmt.Sprintf("%s %s", user.First, user.Last),
+			"emergencyContact": map[string]string{
+				"name":  user.EmergencyContactName,
+				"phone": user.EmergencyContactPhone,
+			},
+		})
+	}
+}
+
+// entitlementsSignatureHeader holds the hex encoded HMAC-SHA256 of the entitlements response body.
+const entitlementsSignatureHeader = "X-Profile-Signature"
+
+// entitlements are the claims internal sites use to decide what a member can see.
+type entitlements struct {
+	Email          string   `json:"email"`
+	Active         bool     `json:"active"`
+	Tier           string   `json:"tier"`
+	Certifications []string `json:"certifications"`
+	IssuedAt       int64    `json:"iat"`
+	ExpiresAt      int64    `json:"exp"`
+}
+
+func newEntitlements(user *keycloak.ExtendedUser[*datamodel.User], now time.Time, ttl time.Duration) *entitlements {
+	e := &entitlements{
+		Email:          user.User.Email,
+		Active:         user.ActiveMember && user.User.BuildingAccessApprover != "" && !user.User.Banned,
+		Tier:           user.User.Tier(),
+		Certifications: user.User.Certifications,
+		IssuedAt:       now.Unix(),
+		ExpiresAt:      now.Add(ttl).Unix(),
+	}
+	if e.Certifications == nil {
+		e.Certifications = []string{}
+	}
+	return e
+}
+
+// newEntitlementsHandler returns signed claims about a member for internal sites like the wiki.
+// The signature lets those sites cache or forward the claims without calling back to verify them.
+func (s *Server) newEntitlementsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := s.Keycloak.GetUserByEmail(r.Context(), r.URL.Query().Get("email"))
+		if errors.Is(err, keycloak.ErrNotFound) {
+			http.Error(w, "member not found", 404)
+			return
+		}
+		if err != nil {
+			renderSystemError(w, "error while getting user: %s", err)
+			return
+		}
+
+		extended, err := s.Keycloak.ExtendUser(r.Context(), user, user.UUID)
+		if err != nil {
+			renderSystemError(w, "error while getting user's group membership: %s", err)
+			return
+		}
+
+		body, err := json.Marshal(newEntitlements(extended, time.Now(), s.Env.EntitlementsTTL))
+		if err != nil {
+			renderSystemError(w, "error while encoding entitlements: %s", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set(entitlementsSignatureHeader, chatbot.GenerateHMAC(string(body), s.Env.EntitlementsSigningKey))
+		w.Write(body)
+	}
+}
+
+// memberProfile is the member's own data as served by /profile.json. Fields are only ever added, so personal
+// automations built against it keep working. Times are unix seconds, or null when unset.
+type memberProfile struct {
+	Email             string   `json:"email"`
+	First             string   `json:"first_name"`
+	Last              string   `json:"last_name"`
+	Active            bool     `json:"active"`
+	BuildingAccess    bool     `json:"building_access"`
+	SubscriptionState string   `json:"subscription_state"`
+	Tier              string   `json:"tier"`
+	AccessHours       string   `json:"access_hours"`
+	WaiverSigned      bool     `json:"waiver_signed"`
+	FobAssigned       bool     `json:"fob_assigned"`
+	LockerNumber      string   `json:"locker_number"`
+	Certifications    []string `json:"certifications"`
+	MembershipEndsAt  *int64   `json:"membership_ends_at"`
+	GracePeriodEndsAt *int64   `json:"grace_period_ends_at"`
+	LastVisitAt       *int64   `json:"last_visit_at"`
+}
+
+func newMemberProfile(user *keycloak.ExtendedUser[*datamodel.User], schedule *datamodel.Schedule, now time.Time) *memberProfile {
+	unix := func(t time.Time) *int64 {
+		if !t.After(time.Unix(0, 0)) {
+			return nil
+		}
+		ts := t.Unix()
+		return &ts
+	}
+
+	p := &memberProfile{
+		Email:             user.User.Email,
+		First:             user.User.First,
+		Last:              user.User.Last,
+		Active:            user.ActiveMember,
+		BuildingAccess:    user.ActiveMember && user.User.BuildingAccessApprover != "" && !user.User.Banned,
+		SubscriptionState: user.User.SubscriptionState(user.ActiveMember, now),
+		Tier:              user.User.Tier(),
+		AccessHours:       schedule.String(),
+		WaiverSigned:      user.User.WaiverState == "Signed",
+		FobAssigned:       user.User.FobID != 0,
+		LockerNumber:      user.User.LockerNumber,
+		Certifications:    user.User.Certifications,
+		MembershipEndsAt:  unix(user.User.StripeCancelationTime),
+		GracePeriodEndsAt: unix(user.User.StripeGracePeriodEnd),
+		LastVisitAt:       unix(user.User.LastSwipeTime),
+	}
+	if p.Certifications == nil {
+		p.Certifications = []string{}
+	}
+	return p
+}
+
+// newProfileJSONHandler serves the logged in member's own data for personal automations
+// e.g. a Home Assistant sensor that checks whether they're paid up.
+func (s *Server) newProfileJSONHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := getUserID(r)
+		user, err := s.Keycloak.GetUser(r.Context(), userID)
+		if err != nil {
+			renderSystemError(w, "error while getting user: %s", err)
+			return
+		}
+
+		extended, err := s.Keycloak.ExtendUser(r.Context(), user, userID)
+		if err != nil {
+			renderSystemError(w, "error while getting user's group membership: %s", err)
+			return
+		}
+
+		schedule := s.Env.AccessSchedules.ForTier(user.Tier())
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(newMemberProfile(extended, schedule, time.Now()))
 	}
 }
@@ -0,0 +1,372 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TheLab-ms/profile/internal/access"
+	"github.com/TheLab-ms/profile/internal/conf"
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/keycloak/keycloaktest"
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+func TestNewEntitlements(t *testing.T) {
+	now := time.Unix(1000, 0)
+	user := &keycloak.ExtendedUser[*datamodel.User]{
+		ActiveMember: true,
+		User: &datamodel.User{
+			Email:                  "member@example.com",
+			BuildingAccessApprover: "leadership",
+			MembershipTier:         datamodel.TierPremium,
+			Certifications:         []string{"laser-cutter"},
+		},
+	}
+
+	js, err := json.Marshal(newEntitlements(user, now, time.Minute))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"email":"member@example.com","active":true,"tier":"premium","certifications":["laser-cutter"],"iat":1000,"exp":1060}`, string(js))
+
+	// Members without building access aren't active yet, and empty lists are still lists
+	user.User.BuildingAccessApprover = ""
+	user.User.Certifications = nil
+	e := newEntitlements(user, now, time.Minute)
+	assert.False(t, e.Active)
+	assert.Equal(t, []string{}, e.Certifications)
+	assert.Equal(t, datamodel.TierPremium, e.Tier)
+}
+
+func TestNewMemberProfile(t *testing.T) {
+	now := time.Unix(1000, 0)
+	user := &keycloak.ExtendedUser[*datamodel.User]{
+		ActiveMember: true,
+		User: &datamodel.User{
+			Email:                  "member@example.com",
+			First:                  "Ada",
+			Last:                   "Lovelace",
+			BuildingAccessApprover: "leadership",
+			WaiverState:            "Signed",
+			FobID:                  123,
+			StripeSubscriptionID:   "sub_123",
+			LastSwipeTime:          time.Unix(900, 0),
+		},
+	}
+	schedule := &datamodel.Schedule{OpenHour: 8, CloseHour: 22}
+
+	js, err := json.Marshal(newMemberProfile(user, schedule, now))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"email": "member@example.com",
+		"first_name": "Ada",
+		"last_name": "Lovelace",
+		"active": true,
+		"building_access": true,
+		"subscription_state": "active",
+		"tier": "standard",
+		"access_hours": "8am-10pm",
+		"waiver_signed": true,
+		"fob_assigned": true,
+		"locker_number": "",
+		"certifications": [],
+		"membership_ends_at": null,
+		"grace_period_ends_at": null,
+		"last_visit_at": 900
+	}`, string(js))
+}
+
+func TestFobResolve(t *testing.T) {
+	kcFake := keycloaktest.NewFake("members")
+	kcFake.AddUser(&gocloak.User{
+		ID:        gocloak.StringP("user-1"),
+		Email:     gocloak.StringP("ada@example.com"),
+		FirstName: gocloak.StringP("Ada"),
+		LastName:  gocloak.StringP("Lovelace"),
+		Attributes: &map[string][]string{
+			"buildingAccessApprover": {"test"},
+			"keyfobID":               {"123"},
+		},
+	}, true)
+	kcFake.AddUser(&gocloak.User{
+		ID:         gocloak.StringP("user-2"),
+		Email:      gocloak.StringP("grace@example.com"),
+		FirstName:  gocloak.StringP("Grace"),
+		LastName:   gocloak.StringP("Hopper"),
+		Attributes: &map[string][]string{"keyfobID": {"234"}},
+	}, false)
+	kcServer := httptest.NewServer(kcFake)
+	t.Cleanup(kcServer.Close)
+
+	env := &conf.Env{
+		KeycloakURL:            kcServer.URL,
+		KeycloakRealm:          "master",
+		KeycloakMembersGroupID: "members",
+		KeycloakClientID:       "test",
+		KeycloakClientSecret:   "test",
+	}
+	kc := keycloak.New[*datamodel.User](env)
+	kc.Sink = reporting.DefaultSink // disabled, but nil-safe
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	cache := access.NewCache(kc, time.Hour)
+	go cache.Run(ctx)
+	require.Eventually(t, cache.Synced, 5*time.Second, 10*time.Millisecond)
+
+	s := &Server{Env: env, Keycloak: kc, Access: cache}
+	resolve := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.newFobResolveHandler()(w, httptest.NewRequest("GET", "/api/v1/fobs/resolve?"+query, nil))
+		return w
+	}
+
+	w := resolve("fob=123&fob=234&fob=345")
+	require.Equal(t, 200, w.Code)
+	assert.JSONEq(t, `{"fobs": [
+		{"fob": 123, "name": "Ada Lovelace", "status": "active"},
+		{"fob": 234, "name": "Grace Hopper", "status": "inactive"},
+		{"fob": 345, "status": "unassigned"}
+	]}`, w.Body.String())
+
+	assert.Equal(t, 400, resolve("").Code)
+	assert.Equal(t, 400, resolve("fob=abc").Code)
+	assert.Equal(t, 400, resolve(strings.Repeat("fob=1&", fobResolveMaxIDs+1)).Code)
+}
+
+func TestDoorPIN(t *testing.T) {
+	kcFake := keycloaktest.NewFake("members")
+	kcFake.AddUser(&gocloak.User{
+		ID:    gocloak.StringP("user-1"),
+		Email: gocloak.StringP("ada@example.com"),
+		Attributes: &map[string][]string{
+			"buildingAccessApprover": {"test"},
+			"keyfobID":               {"123"},
+		},
+	}, true)
+	kcFake.AddUser(&gocloak.User{
+		ID:         gocloak.StringP("user-2"),
+		Email:      gocloak.StringP("grace@example.com"),
+		Attributes: &map[string][]string{"keyfobID": {"234"}},
+	}, false)
+	kcServer := httptest.NewServer(kcFake)
+	t.Cleanup(kcServer.Close)
+
+	env := &conf.Env{
+		KeycloakURL:            kcServer.URL,
+		KeycloakRealm:          "master",
+		KeycloakMembersGroupID: "members",
+		KeycloakClientID:       "test",
+		KeycloakClientSecret:   "test",
+		AccessSchedules:        conf.AccessSchedules{"standard": {OpenHour: 0, CloseHour: 24}},
+		DoorPINKey:             "test-key",
+	}
+	kc := keycloak.New[*datamodel.User](env)
+	kc.Sink = reporting.DefaultSink // disabled, but nil-safe
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	cache := access.NewCache(kc, time.Hour)
+	go cache.Run(ctx)
+	require.Eventually(t, cache.Synced, 5*time.Second, 10*time.Millisecond)
+
+	s := &Server{Env: env, Keycloak: kc, Access: cache}
+	pinHandler := s.newDoorPINFormHandler()
+	requestPIN := func(userID string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/profile/door-pin", nil)
+		req.Header.Set("X-Forwarded-Preferred-Username", userID)
+		pinHandler(w, req)
+		return w
+	}
+	newPIN := func(userID string) string {
+		w := requestPIN(userID)
+		require.Equal(t, 200, w.Code)
+		match := regexp.MustCompile(`<code id="pin">(\d+)</code>`).FindStringSubmatch(w.Body.String())
+		require.Len(t, match, 2)
+		return match[1]
+	}
+	allowed := func(pin string) bool {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/api/v1/access/pin", strings.NewReader("pin="+pin))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		s.newPINAccessHandler()(w, req)
+		require.Equal(t, 200, w.Code)
+		resp := map[string]bool{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		return resp["allowed"]
+	}
+
+	pin := newPIN("user-1")
+	assert.NoError(t, access.ValidatePIN(pin))
+
+	user, err := kc.GetUser(ctx, "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, access.HashPIN("test-key", pin), user.DoorPINHash)
+	assert.False(t, user.DoorPINSetTime.IsZero())
+
+	require.Eventually(t, func() bool { return allowed(pin) }, 5*time.Second, 10*time.Millisecond)
+	assert.False(t, allowed("583921"))
+
+	// PINs are unique, and members without access can't use theirs
+	pin2 := newPIN("user-2")
+	assert.NotEqual(t, pin, pin2)
+	require.Eventually(t, func() bool { return cache.PINTaken(access.HashPIN("test-key", pin2), "user-1") }, 5*time.Second, 10*time.Millisecond)
+	assert.False(t, allowed(pin2))
+
+	// Members can't keep generating PINs
+	for i := 0; i < 4; i++ {
+		newPIN("user-2")
+	}
+	assert.Equal(t, 429, requestPIN("user-2").Code)
+	assert.Equal(t, 200, requestPIN("user-1").Code)
+}
+
+func TestAllowlist(t *testing.T) {
+	kcFake := keycloaktest.NewFake("members")
+	kcFake.AddUser(&gocloak.User{
+		ID:    gocloak.StringP("user-1"),
+		Email: gocloak.StringP("ada@example.com"),
+		Attributes: &map[string][]string{
+			"buildingAccessApprover": {"test"},
+			"keyfobID":               {"123"},
+		},
+	}, true)
+	kcServer := httptest.NewServer(kcFake)
+	t.Cleanup(kcServer.Close)
+
+	env := &conf.Env{
+		KeycloakURL:            kcServer.URL,
+		KeycloakRealm:          "master",
+		KeycloakMembersGroupID: "members",
+		KeycloakClientID:       "test",
+		KeycloakClientSecret:   "test",
+		SpaceTimezone:          "America/Chicago",
+		AccessSchedules:        conf.AccessSchedules{"standard": {OpenHour: 8, CloseHour: 22}},
+		AccessSigningKey:       "test-key",
+	}
+	kc := keycloak.New[*datamodel.User](env)
+	kc.Sink = reporting.DefaultSink
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	cache := access.NewCache(kc, time.Hour)
+	go cache.Run(ctx)
+	require.Eventually(t, cache.Synced, 5*time.Second, 10*time.Millisecond)
+
+	s := &Server{Env: env, Keycloak: kc, Access: cache}
+	get := func(url string) *allowlist {
+		w := httptest.NewRecorder()
+		s.newAllowlistHandler()(w, httptest.NewRequest("GET", url, nil))
+		require.Equal(t, 200, w.Code)
+		assert.Equal(t, "application/jose", w.Header().Get("Content-Type"))
+
+		parts := strings.Split(w.Body.String(), ".")
+		require.Len(t, parts, 3)
+		assert.Equal(t, w.Body.String(), signJWS("test-key", mustDecodeSegment(t, parts[1])))
+
+		a := &allowlist{}
+		require.NoError(t, json.Unmarshal(mustDecodeSegment(t, parts[1]), a))
+		return a
+	}
+
+	full := get("/api/v1/access/allowlist")
+	assert.True(t, full.Full)
+	assert.Equal(t, []int{123}, full.Fobs)
+	assert.Equal(t, map[string][]int{"standard": {123}}, full.Tiers)
+	assert.Equal(t, &allowlistHours{Open: 8, Close: 22}, full.Schedules["standard"])
+
+	// Revoke access
+	kcFake.AddUser(&gocloak.User{
+		ID:         gocloak.StringP("user-1"),
+		Email:      gocloak.StringP("ada@example.com"),
+		Attributes: &map[string][]string{"keyfobID": {"123"}},
+	}, true)
+	cache.InvalidateUser("user-1")
+	require.Eventually(t, func() bool { return !cache.Allowed(123) }, 5*time.Second, 10*time.Millisecond)
+
+	delta := get("/api/v1/access/allowlist/delta?since=" + strconv.FormatInt(full.Version, 10))
+	assert.False(t, delta.Full)
+	assert.Empty(t, delta.Fobs)
+	assert.Equal(t, []int{123}, delta.Removed)
+	assert.Greater(t, delta.Version, full.Version)
+
+	w := httptest.NewRecorder()
+	s.newAllowlistHandler()(w, httptest.NewRequest("GET", "/api/v1/access/allowlist/delta", nil))
+	assert.Equal(t, 400, w.Code)
+}
+
+func TestAccessCheckWaiver(t *testing.T) {
+	kcFake := keycloaktest.NewFake("members")
+	kcFake.AddUser(&gocloak.User{
+		ID:    gocloak.StringP("user-1"),
+		Email: gocloak.StringP("ada@example.com"),
+		Attributes: &map[string][]string{
+			"buildingAccessApprover": {"test"},
+			"keyfobID":               {"123"},
+			"waiverState":            {"Signed"},
+		},
+	}, true)
+	kcFake.AddUser(&gocloak.User{
+		ID:    gocloak.StringP("user-2"),
+		Email: gocloak.StringP("grace@example.com"),
+		Attributes: &map[string][]string{
+			"buildingAccessApprover": {"test"},
+			"keyfobID":               {"234"},
+		},
+	}, true)
+	kcServer := httptest.NewServer(kcFake)
+	t.Cleanup(kcServer.Close)
+
+	env := &conf.Env{
+		KeycloakURL:            kcServer.URL,
+		KeycloakRealm:          "master",
+		KeycloakMembersGroupID: "members",
+		KeycloakClientID:       "test",
+		KeycloakClientSecret:   "test",
+		AccessSchedules:        conf.AccessSchedules{"standard": {OpenHour: 0, CloseHour: 24}},
+	}
+	kc := keycloak.New[*datamodel.User](env)
+	kc.Sink = reporting.DefaultSink // disabled, but nil-safe
+
+	check := func(requireWaiver bool, fob string) map[string]any {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		cache := access.NewCache(kc, time.Hour)
+		cache.RequireWaiver = requireWaiver
+		go cache.Run(ctx)
+		require.Eventually(t, cache.Synced, 5*time.Second, 10*time.Millisecond)
+
+		s := &Server{Env: env, Keycloak: kc, Access: cache}
+		w := httptest.NewRecorder()
+		s.newAccessCheckHandler()(w, httptest.NewRequest("GET", "/api/v1/access?fob="+fob, nil))
+		require.Equal(t, 200, w.Code)
+		resp := map[string]any{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		return resp
+	}
+
+	// Warn only
+	assert.Equal(t, map[string]any{"allowed": true}, check(false, "123"))
+	assert.Equal(t, map[string]any{"allowed": true, "warning": "waiver_unsigned"}, check(false, "234"))
+
+	// Deny
+	assert.Equal(t, map[string]any{"allowed": true}, check(true, "123"))
+	assert.Equal(t, map[string]any{"allowed": false}, check(true, "234"))
+}
+
+func mustDecodeSegment(t *testing.T, seg string) []byte {
+	buf, err := base64.RawURLEncoding.DecodeString(seg)
+	require.NoError(t, err)
+	return buf
+}
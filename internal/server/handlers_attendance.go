@@ -0,0 +1,264 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+
+	"github.com/TheLab-ms/profile/internal/chatbot"
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/reporting"
+	"github.com/TheLab-ms/profile/internal/urls"
+)
+
+const (
+	// Members can check in from a little before an event starts until a while after it ends
+	checkInOpensBefore = time.Minute * 30
+	checkInClosesAfter = time.Hour
+
+	attendanceReportWindow = time.Hour * 24 * 30
+	attendanceUpcoming     = time.Hour * 24 * 7
+)
+
+// newEventCheckInHandler records the member's attendance at the event occurrence in the scanned QR code
+// (see newEventCheckInQRHandler).
+func (s *Server) newEventCheckInHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		viewData := map[string]any{"page": "profile"}
+		claims, err := verifyCheckInToken(s.Env.EventCheckInKey, r.URL.Query().Get("t"))
+		if err != nil {
+			viewData["error"] = "This check-in code isn't valid. Please scan the code shown at the event again."
+			renderStatus(w, r, 400, "event-checkin.html", viewData)
+			return
+		}
+		viewData["event"] = claims.Name
+		viewData["start"] = s.localTime(time.Unix(claims.Start, 0))
+		if !claims.open(time.Now()) {
+			viewData["error"] = "Check-in for this event is closed."
+			renderStatus(w, r, 400, "event-checkin.html", viewData)
+			return
+		}
+
+		user, err := s.Keycloak.GetUser(r.Context(), getUserID(r))
+		if err != nil {
+			renderSystemError(w, "error while getting user: %s", err)
+			return
+		}
+
+		recorded, err := reporting.DefaultSink.RecordAttendance(r.Context(), &reporting.Attendance{
+			EventID:       claims.EventID,
+			Occurrence:    time.Unix(claims.Start, 0),
+			EventName:     claims.Name,
+			Email:         user.Email,
+			DiscordUserID: user.DiscordUserID,
+			Time:          time.Now(),
+		})
+		if err != nil {
+			renderSystemError(w, "error while recording attendance: %s", err)
+			return
+		}
+		if recorded {
+			reporting.DefaultSink.Eventf(user.Email, "EventCheckIn", "checked in at %q (event %s, occurrence %d)", claims.Name, claims.EventID, claims.Start)
+		}
+		viewData["checkedIn"] = true
+		render(w, r, "event-checkin.html", viewData)
+	}
+}
+
+// newEventCheckInQRHandler renders the check-in QR code for an upcoming or ongoing event occurrence, to be projected
+// at the event.
+func (s *Server) newEventCheckInQRHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start, _ := strconv.ParseInt(r.URL.Query().Get("start"), 10, 64)
+		event, err := s.findEvent(r.URL.Query().Get("event"), start)
+		if err != nil {
+			renderSystemError(w, "error while getting events: %s", err)
+			return
+		}
+		if event == nil {
+			http.Error(w, "event not found", 404)
+			return
+		}
+
+		token := signCheckInToken(s.Env.EventCheckInKey, &checkInClaims{EventID: event.ID, Name: event.Name, Start: event.Start, End: event.End})
+		png, err := qrcode.Encode(urls.EventCheckIn(s.Env, token), qrcode.Medium, 512)
+		if err != nil {
+			renderSystemError(w, "generating QR code: %s", err)
+			return
+		}
+		w.Header().Add("Content-Type", "image/png")
+		w.Write(png)
+	}
+}
+
+// findEvent returns the occurrence of the event that starts at the given time, or nil if it isn't upcoming.
+func (s *Server) findEvent(id string, start int64) (*datamodel.Event, error) {
+	events, err := s.EventsCache.GetEvents(time.Now().Add(attendanceUpcoming))
+	if err != nil {
+		return nil, err
+	}
+	for _, event := range events {
+		if event.ID == id && event.Start == start {
+			return event, nil
+		}
+	}
+	return nil, nil
+}
+
+type upcomingEvent struct {
+	*datamodel.Event
+	Time time.Time
+}
+
+// attendanceSummary is one event occurrence in the leadership attendance report.
+type attendanceSummary struct {
+	EventID    string
+	EventName  string
+	Occurrence time.Time
+	Attendees  []string // emails
+	RSVPs      int      // -1 if they couldn't be listed
+	NoShows    int      // RSVPed on Discord but didn't check in
+}
+
+// newAdminAttendanceHandler lists upcoming events (with their check-in QR codes) and attendance at recent ones.
+func (s *Server) newAdminAttendanceHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		events, err := s.EventsCache.GetEvents(time.Now().Add(attendanceUpcoming))
+		if err != nil {
+			renderSystemError(w, "error while getting events: %s", err)
+			return
+		}
+		upcoming := []*upcomingEvent{}
+		for _, event := range events {
+			upcoming = append(upcoming, &upcomingEvent{Event: event, Time: s.localTime(time.Unix(event.Start, 0))})
+		}
+
+		attendance, err := reporting.DefaultSink.ListAttendance(r.Context(), time.Now().Add(-attendanceReportWindow))
+		if err != nil {
+			renderSystemError(w, "error while listing attendance: %s", err)
+			return
+		}
+
+		summaries := summarizeAttendance(attendance, s.listRSVPs(r.Context(), attendance))
+		for _, summary := range summaries {
+			summary.Occurrence = s.localTime(summary.Occurrence)
+		}
+
+		render(w, r, "admin-attendance.html", map[string]any{
+			"page":      "admin",
+			"upcoming":  upcoming,
+			"summaries": summaries,
+		})
+	}
+}
+
+// listRSVPs returns the Discord RSVPs of each event in the attendance records. Events whose RSVPs can't be listed
+// (e.g. because they've been deleted) map to nil.
+func (s *Server) listRSVPs(ctx context.Context, attendance []*reporting.Attendance) map[string][]int64 {
+	rsvps := map[string][]int64{}
+	for _, a := range attendance {
+		if _, ok := rsvps[a.EventID]; ok {
+			continue
+		}
+		ids, err := s.EventsCache.ListRSVPs(ctx, a.EventID)
+		if err != nil {
+			log.Printf("error while listing RSVPs for event %s: %s", a.EventID, err)
+			ids = nil
+		}
+		rsvps[a.EventID] = ids
+	}
+	return rsvps
+}
+
+// summarizeAttendance groups check-ins by event occurrence, newest first. Recurring events share RSVPs between
+// occurrences on Discord, so no-shows are counted against every occurrence.
+func summarizeAttendance(attendance []*reporting.Attendance, rsvps map[string][]int64) []*attendanceSummary {
+	type key struct {
+		id    string
+		start int64
+	}
+	byOccurrence := map[key]*attendanceSummary{}
+	attendees := map[key]map[int64]bool{}
+	summaries := []*attendanceSummary{}
+	for _, a := range attendance {
+		k := key{id: a.EventID, start: a.Occurrence.Unix()}
+		summary, ok := byOccurrence[k]
+		if !ok {
+			summary = &attendanceSummary{EventID: a.EventID, EventName: a.EventName, Occurrence: a.Occurrence}
+			byOccurrence[k] = summary
+			attendees[k] = map[int64]bool{}
+			summaries = append(summaries, summary)
+		}
+		summary.Attendees = append(summary.Attendees, a.Email)
+		if a.DiscordUserID != 0 {
+			attendees[k][a.DiscordUserID] = true
+		}
+	}
+
+	for k, summary := range byOccurrence {
+		ids := rsvps[k.id]
+		if ids == nil {
+			summary.RSVPs = -1
+			continue
+		}
+		summary.RSVPs = len(ids)
+		for _, id := range ids {
+			if !attendees[k][id] {
+				summary.NoShows++
+			}
+		}
+	}
+
+	sort.SliceStable(summaries, func(i, j int) bool { return summaries[i].Occurrence.After(summaries[j].Occurrence) })
+	return summaries
+}
+
+// checkInClaims identify the event occurrence in a check-in QR code.
+type checkInClaims struct {
+	EventID string `json:"e"`
+	Name    string `json:"n"`
+	Start   int64  `json:"s"`
+	End     int64  `json:"x"`
+}
+
+func (c *checkInClaims) open(now time.Time) bool {
+	return !now.Before(time.Unix(c.Start, 0).Add(-checkInOpensBefore)) && !now.After(time.Unix(c.End, 0).Add(checkInClosesAfter))
+}
+
+func signCheckInToken(key string, claims *checkInClaims) string {
+	js, err := json.Marshal(claims)
+	if err != nil {
+		panic(err) // unlikely
+	}
+	payload := base64.RawURLEncoding.EncodeToString(js)
+	return payload + "." + chatbot.GenerateHMAC(payload, key)
+}
+
+func verifyCheckInToken(key, token string) (*checkInClaims, error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok || key == "" {
+		return nil, errInvalidToken
+	}
+	if !hmac.Equal([]byte(sig), []byte(chatbot.GenerateHMAC(payload, key))) {
+		return nil, errInvalidToken
+	}
+
+	js, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	claims := &checkInClaims{}
+	if err := json.Unmarshal(js, claims); err != nil || claims.EventID == "" {
+		return nil, errInvalidToken
+	}
+	return claims, nil
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+func TestCheckInToken(t *testing.T) {
+	claims := &checkInClaims{EventID: "123", Name: "Welding 101", Start: 1000, End: 4600}
+	token := signCheckInToken("key", claims)
+
+	actual, err := verifyCheckInToken("key", token)
+	require.NoError(t, err)
+	assert.Equal(t, claims, actual)
+
+	_, err = verifyCheckInToken("other key", token)
+	assert.ErrorIs(t, err, errInvalidToken)
+
+	_, err = verifyCheckInToken("", token)
+	assert.ErrorIs(t, err, errInvalidToken)
+
+	tampered := signCheckInToken("key", &checkInClaims{EventID: "456", Start: 1000, End: 4600})
+	_, sig, _ := strings.Cut(token, ".")
+	payload, _, _ := strings.Cut(tampered, ".")
+	_, err = verifyCheckInToken("key", payload+"."+sig)
+	assert.ErrorIs(t, err, errInvalidToken)
+
+	_, err = verifyCheckInToken("key", "garbage")
+	assert.ErrorIs(t, err, errInvalidToken)
+}
+
+func TestCheckInWindow(t *testing.T) {
+	start := time.Date(2024, 1, 1, 18, 0, 0, 0, time.UTC)
+	claims := &checkInClaims{Start: start.Unix(), End: start.Add(time.Hour * 2).Unix()}
+
+	assert.False(t, claims.open(start.Add(-time.Hour)))
+	assert.True(t, claims.open(start.Add(-checkInOpensBefore)))
+	assert.True(t, claims.open(start.Add(time.Hour)))
+	assert.True(t, claims.open(start.Add(time.Hour*2+checkInClosesAfter)))
+	assert.False(t, claims.open(start.Add(time.Hour*2+checkInClosesAfter+time.Second)))
+}
+
+func TestSummarizeAttendance(t *testing.T) {
+	first := time.Date(2024, 1, 1, 18, 0, 0, 0, time.UTC)
+	second := first.Add(time.Hour * 24 * 7)
+	attendance := []*reporting.Attendance{
+		{EventID: "weekly", Occurrence: first, EventName: "Open Shop", Email: "a@example.com", DiscordUserID: 1},
+		{EventID: "weekly", Occurrence: first, EventName: "Open Shop", Email: "b@example.com"},
+		{EventID: "weekly", Occurrence: second, EventName: "Open Shop", Email: "a@example.com", DiscordUserID: 1},
+		{EventID: "class", Occurrence: first.Add(time.Hour), EventName: "Welding 101", Email: "c@example.com", DiscordUserID: 3},
+	}
+	rsvps := map[string][]int64{
+		"weekly": {1, 2},
+		"class":  nil, // couldn't be listed
+	}
+
+	summaries := summarizeAttendance(attendance, rsvps)
+	require.Len(t, summaries, 3)
+
+	assert.Equal(t, "weekly", summaries[0].EventID)
+	assert.Equal(t, second, summaries[0].Occurrence)
+	assert.Equal(t, []string{"a@example.com"}, summaries[0].Attendees)
+	assert.Equal(t, 2, summaries[0].RSVPs)
+	assert.Equal(t, 1, summaries[0].NoShows)
+
+	assert.Equal(t, "class", summaries[1].EventID)
+	assert.Equal(t, -1, summaries[1].RSVPs)
+	assert.Equal(t, 0, summaries[1].NoShows)
+
+	assert.Equal(t, "weekly", summaries[2].EventID)
+	assert.Equal(t, first, summaries[2].Occurrence)
+	assert.Equal(t, []string{"a@example.com", "b@example.com"}, summaries[2].Attendees)
+	assert.Equal(t, 1, summaries[2].NoShows)
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	_ "time/tzdata" // the container image doesn't have tzdata
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/events"
+)
+
+// How far ahead visitors can page the calendar. Recurring events are expanded up to this point.
+const calendarMonths = 6
+
+type calendarDay struct {
+	Date    time.Time
+	InMonth bool
+	Today   bool
+	Events  []*calendarEvent
+}
+
+type calendarEvent struct {
+	Name        string
+	Description string
+	Start, End  time.Time
+	MembersOnly bool
+	Color       string
+}
+
+// newCalendarHandler renders the events cache as a server-rendered month view that can be embedded in an iframe.
+func (s *Server) newCalendarHandler() http.HandlerFunc {
+	loc, err := time.LoadLocation("America/Chicago")
+	if err != nil {
+		panic(err) // impossible since tzdata is embedded
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now().In(loc)
+		thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+		month := thisMonth
+		if m, err := time.ParseInLocation("2006-01", r.URL.Query().Get("month"), loc); err == nil {
+			month = m
+		}
+		if month.Before(thisMonth) || month.After(thisMonth.AddDate(0, calendarMonths-1, 0)) {
+			month = thisMonth
+		}
+
+		all, err := s.EventsCache.GetEvents(thisMonth.AddDate(0, calendarMonths, 0))
+		if err != nil {
+			renderSystemError(w, "getting cached events: %s", err)
+			return
+		}
+		categories := queryCategories(r)
+
+		// Only members (i.e. anyone logged in through the proxy) can see members-only events
+		members := r.Header.Get("X-Forwarded-Preferred-Username") != ""
+
+		viewData := map[string]any{
+			"page":       "calendar",
+			"month":      month,
+			"weeks":      buildCalendar(events.FilterCategories(all, categories), month, now, members),
+			"categories": categories, // kept when paging
+		}
+		if month.After(thisMonth) {
+			viewData["prev"] = month.AddDate(0, -1, 0).Format("2006-01")
+		}
+		if month.Before(thisMonth.AddDate(0, calendarMonths-1, 0)) {
+			viewData["next"] = month.AddDate(0, 1, 0).Format("2006-01")
+		}
+		render(w, r, "calendar.html", viewData)
+	}
+}
+
+// buildCalendar returns the weeks (Sunday through Saturday) that cover the given month, with events placed on the day they start.
+func buildCalendar(events []*datamodel.Event, month, now time.Time, includeMembersOnly bool) [][]*calendarDay {
+	loc := month.Location()
+	start := month.AddDate(0, 0, -int(month.Weekday()))
+	end := month.AddDate(0, 1, 0)
+
+	byDay := map[string][]*calendarEvent{}
+	for _, event := range events {
+		if event.MembersOnly && !includeMembersOnly {
+			continue
+		}
+		e := &calendarEvent{
+			Name:        event.Name,
+			Description: event.Description,
+			Start:       time.Unix(event.Start, 0).In(loc),
+			End:         time.Unix(event.End, 0).In(loc),
+			MembersOnly: event.MembersOnly,
+			Color:       event.Color,
+		}
+		key := e.Start.Format("2006-01-02")
+		byDay[key] = append(byDay[key], e)
+	}
+
+	weeks := [][]*calendarDay{}
+	for day := start; day.Before(end); {
+		week := make([]*calendarDay, 7)
+		for i := range week {
+			week[i] = &calendarDay{
+				Date:    day,
+				InMonth: day.Month() == month.Month(),
+				Today:   day.Format("2006-01-02") == now.Format("2006-01-02"),
+				Events:  byDay[day.Format("2006-01-02")],
+			}
+			day = day.AddDate(0, 0, 1)
+		}
+		weeks = append(weeks, week)
+	}
+	return weeks
+}
+
+// newCalendarFeedHandler serves the same events as the calendar as an iCalendar feed, so they can be subscribed to
+// from calendar apps. Like the calendar, it can be filtered by category.
+func (s *Server) newCalendarFeedHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		all, err := s.EventsCache.GetEvents(time.Now().AddDate(0, calendarMonths, 0))
+		if err != nil {
+			renderSystemError(w, "getting cached events: %s", err)
+			return
+		}
+		members := r.Header.Get("X-Forwarded-Preferred-Username") != ""
+
+		host := r.Host
+		if u, err := url.Parse(s.Env.SelfURL); err == nil && u.Host != "" {
+			host = u.Host
+		}
+
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		writeICS(w, events.FilterCategories(all, queryCategories(r)), members, host, time.Now())
+	}
+}
+
+// writeICS encodes the events as an iCalendar. UIDs are scoped to the given host, and are stable for each occurrence.
+func writeICS(w io.Writer, events []*datamodel.Event, includeMembersOnly bool, host string, now time.Time) {
+	const tsFormat = "20060102T150405Z"
+	line := func(s string) {
+		// Lines are folded at 75 octets, without splitting multi-byte characters
+		for len(s) > 75 {
+			i := 75
+			for i > 0 && s[i]&0xC0 == 0x80 {
+				i--
+			}
+			io.WriteString(w, s[:i]+"\r\n")
+			s = " " + s[i:]
+		}
+		io.WriteString(w, s+"\r\n")
+	}
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//TheLab//profile//EN")
+	line("X-WR-CALNAME:TheLab")
+	for _, event := range events {
+		if event.MembersOnly && !includeMembersOnly {
+			continue
+		}
+		line("BEGIN:VEVENT")
+		line(fmt.Sprintf("UID:%s-%d@%s", event.ID, event.Start, host))
+		line("DTSTAMP:" + now.UTC().Format(tsFormat))
+		line("DTSTART:" + time.Unix(event.Start, 0).UTC().Format(tsFormat))
+		line("DTEND:" + time.Unix(event.End, 0).UTC().Format(tsFormat))
+		line("SUMMARY:" + escapeICS(event.Name))
+		if event.Description != "" {
+			line("DESCRIPTION:" + escapeICS(event.Description))
+		}
+		if len(event.Categories) > 0 {
+			escaped := make([]string, len(event.Categories))
+			for i, c := range event.Categories {
+				escaped[i] = escapeICS(c)
+			}
+			line("CATEGORIES:" + strings.Join(escaped, ","))
+		}
+		line("END:VEVENT")
+	}
+	line("END:VCALENDAR")
+}
+
+var icsEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+func escapeICS(s string) string { return icsEscaper.Replace(s) }
+
+// queryCategories returns the ?category= params used to filter events.
+func queryCategories(r *http.Request) []string {
+	categories := []string{}
+	for _, c := range r.URL.Query()["category"] {
+		if c = strings.ToLower(strings.TrimSpace(c)); c != "" {
+			categories = append(categories, c)
+		}
+	}
+	return categories
+}
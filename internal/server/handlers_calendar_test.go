@@ -0,0 +1,70 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+)
+
+func TestBuildCalendar(t *testing.T) {
+	loc, err := time.LoadLocation("America/Chicago")
+	require.NoError(t, err)
+	month := time.Date(2024, time.February, 1, 0, 0, 0, 0, loc) // a thursday
+	now := time.Date(2024, time.February, 14, 12, 0, 0, 0, loc)
+
+	events := []*datamodel.Event{
+		{Name: "public", Start: time.Date(2024, time.February, 14, 18, 0, 0, 0, loc).Unix()},
+		{Name: "members", Start: time.Date(2024, time.February, 14, 19, 0, 0, 0, loc).Unix(), MembersOnly: true},
+		{Name: "late night", Start: time.Date(2024, time.February, 15, 23, 30, 0, 0, loc).Unix()}, // already the 16th in UTC
+	}
+
+	weeks := buildCalendar(events, month, now, false)
+	require.Len(t, weeks, 5)
+	assert.Equal(t, 28, weeks[0][0].Date.Day()) // january 28th
+	assert.False(t, weeks[0][0].InMonth)
+	assert.True(t, weeks[0][4].InMonth)
+
+	day := weeks[2][3] // the 14th
+	assert.True(t, day.Today)
+	require.Len(t, day.Events, 1)
+	assert.Equal(t, "public", day.Events[0].Name)
+	require.Len(t, weeks[2][4].Events, 1)
+	assert.Equal(t, "late night", weeks[2][4].Events[0].Name)
+
+	weeks = buildCalendar(events, month, now, true)
+	assert.Len(t, weeks[2][3].Events, 2)
+}
+
+func TestWriteICS(t *testing.T) {
+	now := time.Unix(1709000000, 0)
+	events := []*datamodel.Event{
+		{ID: "123", Name: "Intro to the Lathe", Description: "Bring glasses, gloves; and\na friend", Start: 1709082000, End: 1709085600, Categories: []string{"woodshop", "class"}},
+		{ID: "234", Name: "Game Night", Start: 1709082000, End: 1709085600, MembersOnly: true},
+		{ID: "345", Name: strings.Repeat("long ", 20), Start: 1709082000, End: 1709085600},
+	}
+
+	buf := &bytes.Buffer{}
+	writeICS(buf, events, false, "profile.example.com", now)
+	out := buf.String()
+
+	assert.True(t, strings.HasPrefix(out, "BEGIN:VCALENDAR\r\n"))
+	assert.True(t, strings.HasSuffix(out, "END:VCALENDAR\r\n"))
+	assert.Contains(t, out, "UID:123-1709082000@profile.example.com\r\n")
+	assert.Contains(t, out, "DTSTART:20240228T010000Z\r\n")
+	assert.Contains(t, out, `DESCRIPTION:Bring glasses\, gloves\; and\na friend`+"\r\n")
+	assert.Contains(t, out, "CATEGORIES:woodshop,class\r\n")
+	assert.NotContains(t, out, "Game Night")
+	for _, line := range strings.Split(out, "\r\n") {
+		assert.LessOrEqual(t, len(line), 75)
+	}
+
+	buf.Reset()
+	writeICS(buf, events, true, "profile.example.com", now)
+	assert.Contains(t, buf.String(), "SUMMARY:Game Night\r\n")
+}
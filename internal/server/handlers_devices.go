@@ -0,0 +1,93 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+const deviceIDMaxLen = 128
+
+// deviceHeartbeat is reported periodically by door controllers, kiosks, etc.
+type deviceHeartbeat struct {
+	ID              string `json:"id"`
+	Kind            string `json:"kind"`
+	FirmwareVersion string `json:"firmware_version"`
+	Status          string `json:"status"`
+}
+
+// newDeviceHeartbeatHandler records that the device is alive. Leadership is alerted when a device stops reporting
+// (see cmd/profile-async).
+func (s *Server) newDeviceHeartbeatHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		hb := &deviceHeartbeat{}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<14)).Decode(hb); err != nil {
+			http.Error(w, "invalid json", 400)
+			return
+		}
+		hb.ID = strings.TrimSpace(hb.ID)
+		if hb.ID == "" || len(hb.ID) > deviceIDMaxLen {
+			http.Error(w, "a device id of up to 128 characters is required", 400)
+			return
+		}
+
+		err := reporting.DefaultSink.RecordDeviceHeartbeat(r.Context(), &reporting.Device{
+			ID:              hb.ID,
+			Kind:            hb.Kind,
+			FirmwareVersion: hb.FirmwareVersion,
+			Status:          hb.Status,
+			LastSeen:        time.Now(),
+		})
+		if err != nil {
+			renderSystemError(w, "error while recording device heartbeat: %s", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// newAdminDevicesHandler lists the devices that report heartbeats, and forgets decommissioned ones.
+func (s *Server) newAdminDevicesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			id := r.FormValue("id")
+			if err := reporting.DefaultSink.DeleteDevice(r.Context(), id); err != nil {
+				renderSystemError(w, "error while deleting device: %s", err)
+				return
+			}
+			log.Printf("device %q was deleted by %s", id, getUserID(r))
+			http.Redirect(w, r, "/admin/devices", http.StatusSeeOther)
+			return
+		}
+
+		devices, err := reporting.DefaultSink.ListDevices(r.Context())
+		if err != nil {
+			renderSystemError(w, "error while listing devices: %s", err)
+			return
+		}
+		now := time.Now()
+		silent := map[string]bool{}
+		for _, d := range devices {
+			silent[d.ID] = d.Silent(now, s.Env.DeviceSilenceThreshold)
+			d.LastSeen = s.localTime(d.LastSeen)
+			d.FirstSeen = s.localTime(d.FirstSeen)
+		}
+
+		render(w, r, "admin-devices.html", map[string]any{
+			"page":      "admin",
+			"devices":   devices,
+			"silent":    silent,
+			"threshold": s.Env.DeviceSilenceThreshold.String(),
+			"enabled":   reporting.DefaultSink.Enabled(),
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TheLab-ms/profile/internal/conf"
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+func TestDeviceHeartbeat(t *testing.T) {
+	s := &Server{Env: &conf.Env{DeviceHeartbeatToken: "device-token"}}
+	handler := requireToken(s.Env.DeviceHeartbeatToken, s.newDeviceHeartbeatHandler())
+	send := func(method, token, body string) int {
+		r := httptest.NewRequest(method, "/webhooks/device-heartbeat", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, 204, send("POST", "device-token", `{"id": "front-door", "kind": "door-controller", "firmware_version": "1.2.3", "status": "ok"}`))
+	assert.Equal(t, 401, send("POST", "wrong", `{"id": "front-door"}`))
+	assert.Equal(t, 405, send("GET", "device-token", ""))
+	assert.Equal(t, 400, send("POST", "device-token", `{"kind": "kiosk"}`))
+	assert.Equal(t, 400, send("POST", "device-token", `{"id": "`+strings.Repeat("a", deviceIDMaxLen+1)+`"}`))
+	assert.Equal(t, 400, send("POST", "device-token", `not json`))
+}
+
+func TestDeviceSilent(t *testing.T) {
+	now := time.Unix(10000, 0)
+	d := &reporting.Device{LastSeen: now.Add(-time.Minute * 10)}
+	assert.False(t, d.Silent(now, time.Minute*15))
+	assert.True(t, d.Silent(now.Add(time.Minute*6), time.Minute*15))
+}
@@ -2,10 +2,18 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
+	"github.com/TheLab-ms/profile/internal/keycloak"
 	"github.com/TheLab-ms/profile/internal/reporting"
 )
 
@@ -17,38 +25,161 @@ func (s *Server) newDocusealRedirectHandler() http.HandlerFunc {
 			return
 		}
 
-		by, _ := json.Marshal(map[string]any{"template_id": 1, "emails": user.Email})
-		req, err := http.NewRequest("POST", s.Env.DocusealURL+"/api/submissions", bytes.NewBuffer(by))
+		url, err := s.createWaiverSubmission(r.Context(), user.Email)
 		if err != nil {
-			renderSystemError(w, "error while creating docuseal submission request: %s", err)
+			renderSystemError(w, "error while creating docuseal submission: %s", err)
 			return
 		}
-		req.Header.Add("X-Auth-Token", s.Env.DocusealToken)
+		http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+	}
+}
 
-		resp, err := http.DefaultClient.Do(req)
+// createWaiverSubmission starts a waiver for the given email and returns the URL where it can be signed.
+func (s *Server) createWaiverSubmission(ctx context.Context, email string) (string, error) {
+	by, _ := json.Marshal(map[string]any{"template_id": 1, "emails": email})
+	req, err := http.NewRequestWithContext(ctx, "POST", s.Env.DocusealURL+"/api/submissions", bytes.NewBuffer(by))
+	if err != nil {
+		return "", fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Add("X-Auth-Token", s.Env.DocusealToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	subs := []struct {
+		Slug string `json:"slug"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&subs)
+	if err != nil {
+		return "", fmt.Errorf("decoding response: %w", err)
+	}
+	if len(subs) == 0 {
+		return "", errors.New("no submissions were returned from docuseal")
+	}
+
+	log.Printf("initiated docuseal submission %q for user %s", subs[0].Slug, email)
+	reporting.DefaultSink.Eventf(email, "DocusealSubmissionCreated", "created docuseal submission: %s", subs[0].Slug)
+	return s.Env.DocusealURL + "/s/" + subs[0].Slug, nil
+}
+
+// newAdminWaiverHandler streams a member's signed waiver from Docuseal, so leadership doesn't need their own
+// Docuseal accounts during incident response. Every download is recorded since waivers hold personal information.
+func (s *Server) newAdminWaiverHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		email := strings.TrimPrefix(r.URL.Path, "/admin/waiver/")
+		if email == "" {
+			http.Error(w, "email is required", 400)
+			return
+		}
+		if s.Env.DocusealURL == "" {
+			http.Error(w, "waivers are not configured", http.StatusNotImplemented)
+			return
+		}
+
+		docURL, err := s.getSignedWaiverURL(r.Context(), email)
+		if errors.Is(err, errWaiverNotFound) {
+			http.Error(w, "no signed waiver was found", 404)
+			return
+		}
 		if err != nil {
-			renderSystemError(w, "error sending docuseal submission request: %s", err)
+			renderSystemError(w, "error while finding signed waiver: %s", err)
 			return
 		}
-		defer resp.Body.Close()
 
-		subs := []struct {
-			Slug string `json:"slug"`
-		}{}
-		err = json.NewDecoder(resp.Body).Decode(&subs)
+		req, err := http.NewRequestWithContext(r.Context(), "GET", docURL, nil)
+		if err != nil {
+			renderSystemError(w, "error while creating waiver download request: %s", err)
+			return
+		}
+		resp, err := http.DefaultClient.Do(req)
 		if err != nil {
-			renderSystemError(w, "error while decoding docuseal submission: %s", err)
+			renderSystemError(w, "error while downloading waiver: %s", err)
 			return
 		}
-		if len(subs) == 0 {
-			renderSystemError(w, "no submissions were returned from docuseal: %s", err)
+		defer resp.Body.Close()
+		if resp.StatusCode != 200 {
+			renderSystemError(w, "unexpected status while downloading waiver: %d", resp.StatusCode)
 			return
 		}
 
-		log.Printf("initiated docuseal submission %q for user %s", subs[0].Slug, user.Email)
-		reporting.DefaultSink.Eventf(user.Email, "DocusealSubmissionCreated", "created docuseal submission: %s", subs[0].Slug)
-		http.Redirect(w, r, s.Env.DocusealURL+"/s/"+subs[0].Slug, http.StatusTemporaryRedirect)
+		actor := r.Header.Get("X-Forwarded-Email")
+		log.Printf("signed waiver for %s was downloaded by %s", email, actor)
+		reporting.DefaultSink.Eventf(email, "WaiverAccessed", "signed waiver was downloaded by %s", actor)
+
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", "waiver-"+email+".pdf"))
+		w.Header().Set("Cache-Control", "no-store")
+		io.Copy(w, resp.Body)
+	}
+}
+
+var errWaiverNotFound = errors.New("no signed waiver")
+
+// getSignedWaiverURL returns the download URL of the most recently completed waiver for the email.
+// Docuseal's search is fuzzy (it also matches names etc.) so results are narrowed down to the submitter's exact email.
+func (s *Server) getSignedWaiverURL(ctx context.Context, email string) (string, error) {
+	subs := struct {
+		Data []struct {
+			ID         int64 `json:"id"`
+			Submitters []struct {
+				Email string `json:"email"`
+			} `json:"submitters"`
+		} `json:"data"`
+	}{}
+	err := s.getDocuseal(ctx, "/api/submissions?"+url.Values{"q": {email}, "status": {"completed"}, "limit": {"100"}}.Encode(), &subs)
+	if err != nil {
+		return "", fmt.Errorf("listing submissions: %w", err)
+	}
+	var id int64
+	for _, sub := range subs.Data { // newest first
+		for _, submitter := range sub.Submitters {
+			if strings.EqualFold(strings.TrimSpace(submitter.Email), email) {
+				id = sub.ID
+				break
+			}
+		}
+		if id != 0 {
+			break
+		}
+	}
+	if id == 0 {
+		return "", errWaiverNotFound
+	}
+
+	docs := struct {
+		Documents []struct {
+			URL string `json:"url"`
+		} `json:"documents"`
+	}{}
+	err = s.getDocuseal(ctx, fmt.Sprintf("/api/submissions/%d/documents", id), &docs)
+	if err != nil {
+		return "", fmt.Errorf("getting submission documents: %w", err)
+	}
+	if len(docs.Documents) == 0 {
+		return "", errWaiverNotFound
+	}
+	return docs.Documents[0].URL, nil
+}
+
+func (s *Server) getDocuseal(ctx context.Context, path string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.Env.DocusealURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("X-Auth-Token", s.Env.DocusealToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
 	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
 }
 
 func (s *Server) newDocusealWebhookHandler() http.HandlerFunc {
@@ -66,17 +197,28 @@ func (s *Server) newDocusealWebhookHandler() http.HandlerFunc {
 		log.Printf("got docuseal webhook for user %s", body.Data.Email)
 
 		user, err := s.Keycloak.GetUserByEmail(r.Context(), body.Data.Email)
+		if errors.Is(err, keycloak.ErrNotFound) {
+			// Guests sign the waiver without an account
+			ok, err := reporting.DefaultSink.MarkGuestWaiverSigned(r.Context(), strings.ToLower(body.Data.Email), time.Now())
+			if err != nil {
+				webhookFailed(w, 500, "error while recording guest waiver: %s", err)
+				return
+			}
+			if !ok {
+				webhookFailed(w, 500, "no user or guest has email address %s", body.Data.Email)
+				return
+			}
+			reporting.DefaultSink.Eventf(body.Data.Email, "GuestSignedWaiver", "guest signed waiver")
+			return
+		}
 		if err != nil {
-			log.Printf("unable to get user by email address: %s", err)
-			w.WriteHeader(500)
+			webhookFailed(w, 500, "unable to get user by email address: %s", err)
 			return
 		}
 
-		user.WaiverState = "Signed"
-		err = s.Keycloak.WriteUser(r.Context(), user)
+		err = s.Keycloak.PatchUserAttributes(r.Context(), user.UUID, map[string]string{"waiverState": "Signed"})
 		if err != nil {
-			log.Printf("error while updating user's waiver state: %s", err)
-			w.WriteHeader(500)
+			webhookFailed(w, 500, "error while updating user's waiver state: %s", err)
 			return
 		}
 
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TheLab-ms/profile/internal/conf"
+)
+
+func TestAdminWaiver(t *testing.T) {
+	mux := http.NewServeMux()
+	docuseal := httptest.NewServer(mux)
+	t.Cleanup(docuseal.Close)
+
+	mux.HandleFunc("/api/submissions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Auth-Token") != "test-token" || r.URL.Query().Get("status") != "completed" {
+			w.WriteHeader(401)
+			return
+		}
+		// Search is fuzzy
+		if !strings.Contains("other.member@example.com", r.URL.Query().Get("q")) {
+			fmt.Fprint(w, `{"data":[]}`)
+			return
+		}
+		fmt.Fprint(w, `{"data":[{"id":43,"submitters":[{"email":"other.member@example.com"}]},{"id":42,"submitters":[{"email":"Member@Example.com"}]}]}`)
+	})
+	mux.HandleFunc("/api/submissions/42/documents", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"id":42,"documents":[{"name":"waiver","url":"%s/files/waiver.pdf"}]}`, docuseal.URL)
+	})
+	mux.HandleFunc("/files/waiver.pdf", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "%PDF-1.4")
+	})
+
+	s := &Server{Env: &conf.Env{DocusealURL: docuseal.URL, DocusealToken: "test-token"}}
+	handler := s.newAdminWaiverHandler()
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/admin/waiver/member@example.com", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "application/pdf", w.Header().Get("Content-Type"))
+	assert.Equal(t, "%PDF-1.4", w.Body.String())
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/admin/waiver/unknown@example.com", nil))
+	assert.Equal(t, 404, w.Code)
+
+	// Partial matches don't count
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/admin/waiver/ember@example.com", nil))
+	assert.Equal(t, 404, w.Code)
+}
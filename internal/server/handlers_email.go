@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/TheLab-ms/profile/internal/email"
+	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+// newEmailWebhookHandler records delivery problems reported by the email provider on the member's account.
+// Unconfirmed accounts whose signup email hard-bounced are kept for leadership to review instead of being cleaned up,
+// since the address is probably a typo (see cmd/visit-check-job).
+func (s *Server) newEmailWebhookHandler() http.HandlerFunc {
+	return email.NewWebhookHandler(s.Env.EmailWebhookSecret, func(ctx context.Context, event *email.DeliveryEvent) bool {
+		if err := s.handleDeliveryEvent(ctx, event); err != nil {
+			log.Printf("error while handling %s email event for %s: %s", event.Event, event.Email, err)
+			return false
+		}
+		return true
+	}).ServeHTTP
+}
+
+func (s *Server) handleDeliveryEvent(ctx context.Context, event *email.DeliveryEvent) error {
+	user, err := s.Keycloak.GetUserByEmail(ctx, event.Email)
+	if errors.Is(err, keycloak.ErrNotFound) {
+		return nil // e.g. the account was already deleted
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case event.HardBounce():
+		user.EmailBounceTime = time.Now()
+		user.EmailBounceReason = event.Reason
+		if err := s.Keycloak.WriteUser(ctx, user); err != nil {
+			return err
+		}
+		reporting.DefaultSink.Eventf(user.Email, "EmailBounced", "email to the member hard-bounced: %s", event.Reason)
+
+	case event.Event == email.EventDelivered && !user.EmailBounceTime.IsZero():
+		user.EmailBounceTime = time.Time{}
+		user.EmailBounceReason = ""
+		if err := s.Keycloak.WriteUser(ctx, user); err != nil {
+			return err
+		}
+		reporting.DefaultSink.Eventf(user.Email, "EmailDelivered", "email to the member was delivered after previously bouncing")
+
+	case event.Event == email.EventComplaint:
+		reporting.DefaultSink.Eventf(user.Email, "EmailComplaint", "the member marked an email as spam")
+	}
+	return nil
+}
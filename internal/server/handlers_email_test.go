@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TheLab-ms/profile/internal/conf"
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/email"
+	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/keycloak/keycloaktest"
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+func TestDeliveryEvents(t *testing.T) {
+	kcFake := keycloaktest.NewFake("members")
+	kcFake.AddUser(&gocloak.User{
+		ID:    gocloak.StringP("user-1"),
+		Email: gocloak.StringP("ada@example.con"),
+	}, false)
+	kcServer := httptest.NewServer(kcFake)
+	t.Cleanup(kcServer.Close)
+
+	env := &conf.Env{
+		KeycloakURL:            kcServer.URL,
+		KeycloakRealm:          "master",
+		KeycloakMembersGroupID: "members",
+		KeycloakClientID:       "test",
+		KeycloakClientSecret:   "test",
+	}
+	kc := keycloak.New[*datamodel.User](env)
+	kc.Sink = reporting.DefaultSink // disabled, but nil-safe
+	s := &Server{Env: env, Keycloak: kc}
+	ctx := context.Background()
+
+	getUser := func() *datamodel.User {
+		user, err := kc.GetUser(ctx, "user-1")
+		require.NoError(t, err)
+		return user
+	}
+
+	// Soft bounces are ignored
+	require.NoError(t, s.handleDeliveryEvent(ctx, &email.DeliveryEvent{Event: email.EventBounce, Email: "ada@example.con", BounceType: "soft"}))
+	assert.True(t, getUser().EmailBounceTime.IsZero())
+
+	require.NoError(t, s.handleDeliveryEvent(ctx, &email.DeliveryEvent{Event: email.EventBounce, Email: "ada@example.con", BounceType: "hard", Reason: "550 no such domain"}))
+	user := getUser()
+	assert.False(t, user.EmailBounceTime.IsZero())
+	assert.Equal(t, "550 no such domain", user.EmailBounceReason)
+
+	// Delivery clears the bounce
+	require.NoError(t, s.handleDeliveryEvent(ctx, &email.DeliveryEvent{Event: email.EventDelivered, Email: "ada@example.con"}))
+	user = getUser()
+	assert.True(t, user.EmailBounceTime.IsZero())
+	assert.Empty(t, user.EmailBounceReason)
+
+	// Unknown addresses are ignored
+	require.NoError(t, s.handleDeliveryEvent(ctx, &email.DeliveryEvent{Event: email.EventBounce, Email: "nobody@example.com", BounceType: "hard"}))
+}
@@ -1,26 +1,34 @@
 package server
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"net/mail"
+	"strings"
 	"sync"
+	"time"
 
-	"github.com/TheLab-ms/profile"
+	"github.com/TheLab-ms/profile/internal/access"
+	"github.com/TheLab-ms/profile/internal/chatbot"
+	"github.com/TheLab-ms/profile/internal/datamodel"
 	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/ratelimit"
 	"github.com/TheLab-ms/profile/internal/reporting"
-	"golang.org/x/time/rate"
+	"github.com/TheLab-ms/profile/internal/urls"
 )
 
 func (s *Server) newRegistrationFormHandler() http.HandlerFunc {
-	rateLimiter := rate.NewLimiter(1, 2)
+	limiter := ratelimit.New(reporting.DefaultSink, "signup", s.Env.SignupRateLimit, time.Minute) // per client IP
 	lock := sync.Mutex{}
 	return func(w http.ResponseWriter, r *http.Request) {
-		if err := rateLimiter.Wait(r.Context()); err != nil {
-			log.Printf("rate limiter error: %s", err)
+		if !limiter.Allow(r.Context(), clientIP(r)) {
+			http.Error(w, "too many signups right now - please try again in a minute", http.StatusTooManyRequests)
+			return
 		}
-		viewData := map[string]any{"page": "signup", "success": true}
+		viewData := map[string]any{"page": "signup", "success": true, "newsletter": s.Env.NewsletterAPIKey != ""}
 
 		email := r.FormValue("email")
 		if _, err := mail.ParseAddress(email); err != nil {
@@ -53,7 +61,74 @@ func (s *Server) newRegistrationFormHandler() http.HandlerFunc {
 		}
 
 		reporting.DefaultSink.Eventf(email, "Signup", "user created an account")
-		profile.Templates.ExecuteTemplate(w, "signup.html", viewData)
+		if viewData["success"] == true && s.Env.NewsletterAPIKey != "" && r.FormValue("newsletter") != "" {
+			s.optInToNewsletter(r.Context(), email)
+		}
+		render(w, r, "signup.html", viewData)
+	}
+}
+
+// optInToNewsletter records the opt-in of a member who just signed up, for cmd/profile-async to sync. The account
+// already exists at this point, so failures are logged rather than failing the signup.
+func (s *Server) optInToNewsletter(ctx context.Context, email string) {
+	user, err := s.Keycloak.GetUserByEmail(ctx, email)
+	if err == nil {
+		user.NewsletterOptIn = true
+		err = s.Keycloak.WriteUser(ctx, user)
+	}
+	if err != nil {
+		log.Printf("error while opting new user %s in to the newsletter: %s", email, err)
+		return
+	}
+	reporting.DefaultSink.Eventf(email, "NewsletterOptIn", "user asked for the newsletter when signing up")
+}
+
+// newSignupResendHandler sends another signup email to accounts that haven't been set up yet, for people whose link expired.
+// The response is the same whether or not the account exists to avoid leaking which addresses have signed up.
+func (s *Server) newSignupResendHandler() http.HandlerFunc {
+	limiter := ratelimit.New(reporting.DefaultSink, "signup-resend", s.Env.SignupRateLimit, time.Minute) // per client IP
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !limiter.Allow(r.Context(), clientIP(r)) {
+			http.Error(w, "too many requests right now - please try again in a minute", http.StatusTooManyRequests)
+			return
+		}
+
+		email := r.FormValue("email")
+		if _, err := mail.ParseAddress(email); err != nil {
+			http.Error(w, "invalid email address", 400)
+			return
+		}
+
+		user, err := s.Keycloak.GetUserByEmail(r.Context(), email)
+		if err != nil && !errors.Is(err, keycloak.ErrNotFound) {
+			renderSystemError(w, "error while getting user: %s", err)
+			return
+		}
+
+		// Verified accounts have already been set up, so they should use Keycloak's password reset instead
+		if err == nil && !user.EmailVerified {
+			if user.SignupEmailResends >= s.Env.SignupEmailMaxResends {
+				reporting.DefaultSink.Eventf(user.Email, "SignupEmailResendLimit", "refusing to resend signup email after %d resends", user.SignupEmailResends)
+			} else {
+				if err := s.Keycloak.SendSignupEmail(r.Context(), user.UUID); err != nil {
+					renderSystemError(w, "error while sending signup email: %s", err)
+					return
+				}
+				user.SignupEmailResends++
+				user.SignupEmailSentTime = time.Now()
+				if err := s.Keycloak.WriteUser(r.Context(), user); err != nil {
+					renderSystemError(w, "error while writing user: %s", err)
+					return
+				}
+				reporting.DefaultSink.Eventf(user.Email, "SignupEmailResent", "resent signup email (%d of %d)", user.SignupEmailResends, s.Env.SignupEmailMaxResends)
+			}
+		}
+
+		render(w, r, "signup.html", map[string]any{"page": "signup", "resent": true, "newsletter": s.Env.NewsletterAPIKey != ""})
 	}
 }
 
@@ -69,6 +144,12 @@ func (s *Server) newContactInfoFormHandler() http.HandlerFunc {
 			http.Error(w, "name is too long", 400)
 			return
 		}
+		emergencyName := strings.TrimSpace(r.FormValue("emergencyName"))
+		emergencyPhone := strings.TrimSpace(r.FormValue("emergencyPhone"))
+		if len(emergencyName) > 256 || len(emergencyPhone) > 64 {
+			http.Error(w, "emergency contact is too long", 400)
+			return
+		}
 
 		user, err := s.Keycloak.GetUser(r.Context(), getUserID(r))
 		if err != nil {
@@ -76,13 +157,27 @@ func (s *Server) newContactInfoFormHandler() http.HandlerFunc {
 			return
 		}
 
-		if user.First == first && user.Last == last {
+		if changes := lockedFieldChanges(s.Env.LockedFields.For(user), user, map[string]string{
+			"first":          first,
+			"last":           last,
+			"emergencyName":  emergencyName,
+			"emergencyPhone": emergencyPhone,
+		}); len(changes) > 0 {
+			render(w, r, "contact-locked.html", map[string]any{"page": "profile", "changes": changes})
+			return
+		}
+
+		optOut := r.FormValue("discordIntro") == ""
+		if user.First == first && user.Last == last && user.DiscordIntroOptOut == optOut && user.EmergencyContactName == emergencyName && user.EmergencyContactPhone == emergencyPhone {
 			http.Redirect(w, r, "/", http.StatusSeeOther)
 			return // nothing changed
 		}
 
 		user.First = first
 		user.Last = last
+		user.DiscordIntroOptOut = optOut
+		user.EmergencyContactName = emergencyName
+		user.EmergencyContactPhone = emergencyPhone
 		err = s.Keycloak.WriteUser(r.Context(), user)
 		if err != nil {
 			renderSystemError(w, "error while updating user: %s", err)
@@ -93,3 +188,149 @@ func (s *Server) newContactInfoFormHandler() http.HandlerFunc {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 	}
 }
+
+// lockedFieldLabels are the human-readable names of the contact info fields that can be locked (see conf.LockedFields).
+var lockedFieldLabels = map[string]string{
+	"first":          "first name",
+	"last":           "last name",
+	"emergencyName":  "emergency contact name",
+	"emergencyPhone": "emergency contact phone number",
+}
+
+type lockedFieldChange struct {
+	Field, Label       string
+	Current, Requested string
+}
+
+// lockedFieldChanges returns the requested changes to locked contact info fields, in a stable order.
+func lockedFieldChanges(locked map[string]bool, user *datamodel.User, requested map[string]string) []*lockedFieldChange {
+	current := map[string]string{
+		"first":          user.First,
+		"last":           user.Last,
+		"emergencyName":  user.EmergencyContactName,
+		"emergencyPhone": user.EmergencyContactPhone,
+	}
+	changes := []*lockedFieldChange{}
+	for _, field := range []string{"first", "last", "emergencyName", "emergencyPhone"} {
+		val, ok := requested[field]
+		if !ok || !locked[field] || val == current[field] {
+			continue
+		}
+		changes = append(changes, &lockedFieldChange{Field: field, Label: lockedFieldLabels[field], Current: current[field], Requested: val})
+	}
+	return changes
+}
+
+// newContactChangeRequestHandler asks leadership to change contact info fields that members can't change themselves.
+func (s *Server) newContactChangeRequestHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form", 400)
+			return
+		}
+		requested := map[string]string{}
+		for field := range lockedFieldLabels {
+			if r.PostForm.Has(field) {
+				requested[field] = strings.TrimSpace(r.PostForm.Get(field))
+				if len(requested[field]) > 256 {
+					http.Error(w, "requested value is too long", 400)
+					return
+				}
+			}
+		}
+
+		user, err := s.Keycloak.GetUser(r.Context(), getUserID(r))
+		if err != nil {
+			renderSystemError(w, "error while getting user: %s", err)
+			return
+		}
+
+		changes := lockedFieldChanges(s.Env.LockedFields.For(user), user, requested)
+		if len(changes) == 0 {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return // nothing to request (the field may have been unlocked since)
+		}
+
+		lines := []string{fmt.Sprintf("%s %s (%s) requested a profile change:", user.First, user.Last, user.Email)}
+		for _, change := range changes {
+			lines = append(lines, fmt.Sprintf("- %s: %q -> %q", change.Label, change.Current, change.Requested))
+		}
+		lines = append(lines, urls.AdminMember(s.Env, user.Email))
+		if err := chatbot.PostWebhook(r.Context(), s.Env.LockedFieldsWebhook, strings.Join(lines, "\n")); err != nil {
+			renderSystemError(w, "error while notifying leadership: %s", err)
+			return
+		}
+
+		reporting.DefaultSink.Eventf(user.Email, "ProfileChangeRequested", "user requested a change to %d locked field(s)", len(changes))
+		render(w, r, "contact-locked.html", map[string]any{"page": "profile", "requested": true})
+	}
+}
+
+// newDoorPINFormHandler assigns the member a new keypad PIN for doors without a fob reader, or removes it.
+// The PIN is only shown once - members who forget it get a new one.
+func (s *Server) newDoorPINFormHandler() http.HandlerFunc {
+	limiter := ratelimit.New(reporting.DefaultSink, "door-pin", 5, time.Hour) // per member
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !limiter.Allow(r.Context(), getUserID(r)) {
+			http.Error(w, "too many PIN changes - please try again later", http.StatusTooManyRequests)
+			return
+		}
+		remove := r.FormValue("remove") != ""
+
+		user, err := s.Keycloak.GetUser(r.Context(), getUserID(r))
+		if err != nil {
+			renderSystemError(w, "error while getting user: %s", err)
+			return
+		}
+
+		var pin string
+		if remove {
+			user.DoorPINHash = ""
+			user.DoorPINSetTime = time.Time{}
+		} else {
+			// Uniqueness is checked against the access cache, which holds every PIN
+			if !s.Access.Synced() {
+				http.Error(w, "PINs can't be changed right now - try again in a few minutes", http.StatusServiceUnavailable)
+				return
+			}
+			var hash string
+			for i := 0; i < 5 && (hash == "" || s.Access.PINTaken(hash, user.UUID)); i++ {
+				pin, err = access.GeneratePIN()
+				if err != nil {
+					renderSystemError(w, "error while generating PIN: %s", err)
+					return
+				}
+				hash = access.HashPIN(s.Env.DoorPINKey, pin)
+			}
+			if s.Access.PINTaken(hash, user.UUID) {
+				renderSystemError(w, "unable to generate an unused PIN")
+				return
+			}
+			user.DoorPINHash = hash
+			user.DoorPINSetTime = time.Now()
+		}
+		user.DoorPINReminderTime = time.Time{}
+		if err := s.Keycloak.WriteUser(r.Context(), user); err != nil {
+			renderSystemError(w, "error while updating user: %s", err)
+			return
+		}
+		s.Access.InvalidateUser(user.UUID)
+
+		if remove {
+			reporting.DefaultSink.Eventf(user.Email, "DoorPINRemoved", "user removed their door PIN")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		reporting.DefaultSink.Eventf(user.Email, "DoorPINSet", "user was assigned a new door PIN")
+		w.Header().Set("Cache-Control", "no-store")
+		render(w, r, "door-pin.html", map[string]any{"page": "profile", "pin": pin})
+	}
+}
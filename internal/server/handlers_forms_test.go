@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TheLab-ms/profile/internal/conf"
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/keycloak/keycloaktest"
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+func TestSignupResend(t *testing.T) {
+	kcFake := keycloaktest.NewFake("members")
+	kcFake.AddUser(&gocloak.User{
+		ID:       gocloak.StringP("new-user"),
+		Username: gocloak.StringP("new@example.com"),
+		Email:    gocloak.StringP("new@example.com"),
+	}, false)
+	kcFake.AddUser(&gocloak.User{
+		ID:            gocloak.StringP("verified-user"),
+		Username:      gocloak.StringP("verified@example.com"),
+		Email:         gocloak.StringP("verified@example.com"),
+		EmailVerified: gocloak.BoolP(true),
+	}, false)
+	kcServer := httptest.NewServer(kcFake)
+	t.Cleanup(kcServer.Close)
+
+	env := &conf.Env{
+		KeycloakURL:            kcServer.URL,
+		KeycloakRealm:          "master",
+		KeycloakMembersGroupID: "members",
+		KeycloakClientID:       "test",
+		KeycloakClientSecret:   "test",
+		SignupRateLimit:        100,
+		SignupEmailMaxResends:  2,
+	}
+	kc := keycloak.New[*datamodel.User](env)
+	kc.Sink = reporting.DefaultSink // disabled, but nil-safe
+	s := &Server{Env: env, Keycloak: kc}
+	handler := s.newSignupResendHandler()
+
+	resend := func(email string) {
+		req := httptest.NewRequest("POST", "/signup/resend", strings.NewReader(url.Values{"email": {email}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		require.Equal(t, 200, w.Code)
+		assert.Contains(t, w.Body.String(), "we've sent it a new link")
+	}
+	getUser := func(id string) *datamodel.User {
+		user, err := kc.GetUser(context.Background(), id)
+		require.NoError(t, err)
+		return user
+	}
+
+	// Resends stop at the limit
+	for i := 0; i < 3; i++ {
+		resend("new@example.com")
+	}
+	user := getUser("new-user")
+	assert.Equal(t, 2, user.SignupEmailResends)
+	assert.False(t, user.SignupEmailSentTime.IsZero())
+
+	// Accounts that have been set up are left alone
+	resend("verified@example.com")
+	assert.Equal(t, 0, getUser("verified-user").SignupEmailResends)
+
+	// Unknown addresses look the same as known ones
+	resend("unknown@example.com")
+}
+
+func TestSignupRateLimitPerClient(t *testing.T) {
+	s := &Server{Env: &conf.Env{SignupRateLimit: 1}}
+	handler := s.newSignupResendHandler()
+
+	send := func(forwardedFor string) int {
+		req := httptest.NewRequest("POST", "/signup/resend", strings.NewReader("email=invalid"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w.Code
+	}
+	assert.Equal(t, 400, send("10.0.0.1"))
+	assert.Equal(t, 429, send("10.0.0.1"))
+	assert.Equal(t, 429, send("1.2.3.4, 10.0.0.1"), "client-provided entries are ignored")
+	assert.Equal(t, 400, send("10.0.0.2"))
+}
+
+func TestContactInfoLockedFields(t *testing.T) {
+	kcFake := keycloaktest.NewFake("members")
+	kcFake.AddUser(&gocloak.User{
+		ID:         gocloak.StringP("user-1"),
+		Username:   gocloak.StringP("ada@example.com"),
+		Email:      gocloak.StringP("ada@example.com"),
+		FirstName:  gocloak.StringP("Ada"),
+		LastName:   gocloak.StringP("Lovelace"),
+		Attributes: &map[string][]string{"waiverState": {"Signed"}},
+	}, true)
+	kcServer := httptest.NewServer(kcFake)
+	t.Cleanup(kcServer.Close)
+
+	messages := []string{}
+	discord := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&body)
+		messages = append(messages, body["content"])
+	}))
+	t.Cleanup(discord.Close)
+
+	env := &conf.Env{
+		KeycloakURL:            kcServer.URL,
+		KeycloakRealm:          "master",
+		KeycloakMembersGroupID: "members",
+		KeycloakClientID:       "test",
+		KeycloakClientSecret:   "test",
+		SelfURL:                "https://profile.example.com",
+		LockedFields:           conf.LockedFields{"first": conf.LockWaiver, "last": conf.LockWaiver},
+		LockedFieldsWebhook:    discord.URL,
+	}
+	kc := keycloak.New[*datamodel.User](env)
+	kc.Sink = reporting.DefaultSink // disabled, but nil-safe
+	s := &Server{Env: env, Keycloak: kc}
+
+	submit := func(handler http.HandlerFunc, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/profile/contact", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Forwarded-Preferred-Username", "user-1")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+	getUser := func() *datamodel.User {
+		user, err := kc.GetUser(context.Background(), "user-1")
+		require.NoError(t, err)
+		return user
+	}
+
+	// Changing a locked field saves nothing
+	w := submit(s.newContactInfoFormHandler(), url.Values{"first": {"Augusta"}, "last": {"Lovelace"}, "emergencyName": {"Charles"}})
+	require.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), "Request Change")
+	assert.Contains(t, w.Body.String(), `<input type="hidden" name="first" value="Augusta">`)
+	assert.Equal(t, "Ada", getUser().First)
+	assert.Equal(t, "", getUser().EmergencyContactName)
+
+	// Unlocked fields can still be changed
+	w = submit(s.newContactInfoFormHandler(), url.Values{"first": {"Ada"}, "last": {"Lovelace"}, "emergencyName": {"Charles"}})
+	require.Equal(t, 303, w.Code)
+	assert.Equal(t, "Charles", getUser().EmergencyContactName)
+
+	// Requesting the change notifies leadership
+	w = submit(s.newContactChangeRequestHandler(), url.Values{"first": {"Augusta"}})
+	require.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), "Leadership has been asked to make the change")
+	require.Len(t, messages, 1)
+	assert.Equal(t, "Ada Lovelace (ada@example.com) requested a profile change:\n- first name: \"Ada\" -> \"Augusta\"\nhttps://profile.example.com/admin/member?email=ada%40example.com", messages[0])
+	assert.Equal(t, "Ada", getUser().First)
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+// frontDeskMaxResults keeps searches fast, since each result requires another Keycloak call to get its status.
+const frontDeskMaxResults = 10
+
+// frontDeskResult is a member as shown to front desk volunteers.
+type frontDeskResult struct {
+	User   *datamodel.User
+	Active bool
+}
+
+// newFrontDeskHandler renders the front desk page, where volunteers can look up members by name or fob ID.
+// It's a constrained subset of the admin pages: volunteers can see member status but can't change anything
+// beyond checking members in and starting waivers.
+func (s *Server) newFrontDeskHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := strings.TrimSpace(r.URL.Query().Get("q"))
+		viewData := map[string]any{
+			"page":      "frontdesk",
+			"query":     query,
+			"checkedIn": r.URL.Query().Get("checkedin"),
+			"waivers":   s.Env.DocusealURL != "",
+		}
+
+		if query != "" {
+			users, err := s.searchFrontDesk(r, query)
+			if err != nil {
+				renderSystemError(w, "error while searching users: %s", err)
+				return
+			}
+
+			results := make([]*frontDeskResult, len(users))
+			for i, user := range users {
+				extended, err := s.Keycloak.ExtendUser(r.Context(), user, user.UUID)
+				if err != nil {
+					renderSystemError(w, "error while getting user groups: %s", err)
+					return
+				}
+				results[i] = &frontDeskResult{User: user, Active: extended.ActiveMember}
+			}
+			viewData["results"] = results
+		}
+
+		render(w, r, "frontdesk.html", viewData)
+	}
+}
+
+// searchFrontDesk finds members by fob ID when the query is numeric, otherwise by name or email.
+func (s *Server) searchFrontDesk(r *http.Request, query string) ([]*datamodel.User, error) {
+	if _, err := strconv.Atoi(query); err != nil {
+		return s.Keycloak.SearchUsers(r.Context(), query, frontDeskMaxResults)
+	}
+
+	user, err := s.Keycloak.GetUserByAttribute(r.Context(), "keyfobID", query)
+	if errors.Is(err, keycloak.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []*datamodel.User{user}, nil
+}
+
+// newFrontDeskCheckInHandler records a visit for members who came in without swiping their fob, which
+// also keeps their building access from expiring (see cmd/visit-check-job).
+func (s *Server) newFrontDeskCheckInHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user, err := s.Keycloak.GetUserByEmail(r.Context(), r.FormValue("email"))
+		if errors.Is(err, keycloak.ErrNotFound) {
+			http.Error(w, "user not found", 404)
+			return
+		}
+		if err != nil {
+			renderSystemError(w, "error while getting user: %s", err)
+			return
+		}
+
+		user.LastSwipeTime = time.Now()
+		if err := s.Keycloak.WriteUser(r.Context(), user); err != nil {
+			renderSystemError(w, "error while writing user: %s", err)
+			return
+		}
+
+		log.Printf("user %s was checked in at the front desk by %s", user.Email, getUserID(r))
+		reporting.DefaultSink.Eventf(user.Email, "CheckedIn", "checked in at the front desk by %s", r.Header.Get("X-Forwarded-Email"))
+		http.Redirect(w, r, "/frontdesk?"+url.Values{"q": {r.FormValue("q")}, "checkedin": {user.Email}}.Encode(), http.StatusSeeOther)
+	}
+}
+
+// newFrontDeskWaiverHandler starts a waiver for the member and sends the volunteer to the signing page,
+// so it can be signed on the front desk's device.
+func (s *Server) newFrontDeskWaiverHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if s.Env.DocusealURL == "" {
+			http.Error(w, "waivers are not configured", http.StatusNotImplemented)
+			return
+		}
+
+		user, err := s.Keycloak.GetUserByEmail(r.Context(), r.FormValue("email"))
+		if errors.Is(err, keycloak.ErrNotFound) {
+			http.Error(w, "user not found", 404)
+			return
+		}
+		if err != nil {
+			renderSystemError(w, "error while getting user: %s", err)
+			return
+		}
+
+		signURL, err := s.createWaiverSubmission(r.Context(), user.Email)
+		if err != nil {
+			renderSystemError(w, "error while creating docuseal submission: %s", err)
+			return
+		}
+		http.Redirect(w, r, signURL, http.StatusSeeOther)
+	}
+}
+
+// newFrontDeskDayPassHandler renders a printable day pass for a visitor. Passes are good for the standard
+// schedule on the day they're issued.
+func (s *Server) newFrontDeskDayPassHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		name := strings.TrimSpace(r.FormValue("name"))
+		if name == "" {
+			http.Error(w, "name is required", 400)
+			return
+		}
+		email := strings.TrimSpace(r.FormValue("email"))
+
+		issuer := r.Header.Get("X-Forwarded-Email")
+		reporting.DefaultSink.Eventf(email, "DayPassIssued", "day pass issued to %q by %s", name, issuer)
+
+		render(w, r, "frontdesk-daypass.html", map[string]any{
+			"page":     "frontdesk",
+			"name":     name,
+			"date":     s.localTime(time.Now()).Format("Monday, January 2, 2006"),
+			"schedule": s.Env.AccessSchedules.ForTier(datamodel.TierStandard),
+			"issuer":   issuer,
+		})
+	}
+}
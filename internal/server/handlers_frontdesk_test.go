@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TheLab-ms/profile/internal/conf"
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/keycloak/keycloaktest"
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+func TestFrontDesk(t *testing.T) {
+	kcFake := keycloaktest.NewFake("members")
+	kcFake.AddUser(&gocloak.User{
+		ID:        gocloak.StringP("user-1"),
+		Username:  gocloak.StringP("ada@example.com"),
+		Email:     gocloak.StringP("ada@example.com"),
+		FirstName: gocloak.StringP("Ada"),
+		LastName:  gocloak.StringP("Lovelace"),
+		Attributes: &map[string][]string{
+			"buildingAccessApprover": {"test"},
+			"keyfobID":               {"123"},
+			"waiverState":            {"Signed"},
+		},
+	}, true)
+	kcFake.AddUser(&gocloak.User{
+		ID:        gocloak.StringP("user-2"),
+		Username:  gocloak.StringP("grace@example.com"),
+		Email:     gocloak.StringP("grace@example.com"),
+		FirstName: gocloak.StringP("Grace"),
+		LastName:  gocloak.StringP("Hopper"),
+	}, false)
+	kcServer := httptest.NewServer(kcFake)
+	t.Cleanup(kcServer.Close)
+
+	env := &conf.Env{
+		KeycloakURL:            kcServer.URL,
+		KeycloakRealm:          "master",
+		KeycloakMembersGroupID: "members",
+		KeycloakClientID:       "test",
+		KeycloakClientSecret:   "test",
+	}
+	kc := keycloak.New[*datamodel.User](env)
+	kc.Sink = reporting.DefaultSink // disabled, but nil-safe
+	s := &Server{Env: env, Keycloak: kc}
+
+	search := func(q string) string {
+		w := httptest.NewRecorder()
+		s.newFrontDeskHandler()(w, httptest.NewRequest("GET", "/frontdesk?"+url.Values{"q": {q}}.Encode(), nil))
+		require.Equal(t, 200, w.Code)
+		return w.Body.String()
+	}
+
+	t.Run("by name", func(t *testing.T) {
+		body := search("hopper")
+		assert.Contains(t, body, "grace@example.com")
+		assert.NotContains(t, body, "ada@example.com")
+		assert.Contains(t, body, `<span class="label label-default">Inactive</span>`)
+	})
+
+	t.Run("by fob", func(t *testing.T) {
+		body := search("123")
+		assert.Contains(t, body, "ada@example.com")
+		assert.NotContains(t, body, "grace@example.com")
+		assert.Contains(t, body, `<span class="label label-success">Active</span>`)
+	})
+
+	t.Run("no results", func(t *testing.T) {
+		assert.Contains(t, search("456"), "No members found")
+	})
+
+	t.Run("check in", func(t *testing.T) {
+		form := url.Values{"email": {"ada@example.com"}, "q": {"ada"}}
+		req := httptest.NewRequest("POST", "/frontdesk/checkin", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		s.newFrontDeskCheckInHandler()(w, req)
+		require.Equal(t, 303, w.Code)
+		assert.Equal(t, "/frontdesk?checkedin=ada%40example.com&q=ada", w.Header().Get("Location"))
+
+		user, err := kc.GetUser(context.Background(), "user-1")
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Now(), user.LastSwipeTime, time.Minute)
+	})
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+var funnelPeriods = []int{7, 30, 90, 365}
+
+// funnelRow is a step of the signup funnel along with its conversion from the previous step.
+type funnelRow struct {
+	*reporting.FunnelStep
+	Count      int
+	Conversion float64 // percent of the previous step, zero for the first step
+}
+
+func (s *Server) newAdminFunnelHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		days, _ := strconv.Atoi(r.URL.Query().Get("days"))
+		if days <= 0 || days > 365 {
+			days = 30
+		}
+		end := time.Now()
+		start := end.Add(-time.Hour * 24 * time.Duration(days))
+
+		counts, err := reporting.DefaultSink.CountFunnel(r.Context(), start, end)
+		if err != nil {
+			renderSystemError(w, "error while counting funnel events: %s", err)
+			return
+		}
+
+		rows := make([]*funnelRow, len(counts))
+		for i, count := range counts {
+			rows[i] = &funnelRow{FunnelStep: reporting.FunnelSteps[i], Count: count}
+			if i > 0 && counts[i-1] > 0 {
+				rows[i].Conversion = float64(count) / float64(counts[i-1]) * 100
+			}
+		}
+
+		render(w, r, "admin-funnel.html", map[string]any{
+			"page":    "admin",
+			"rows":    rows,
+			"days":    days,
+			"periods": funnelPeriods,
+			"enabled": reporting.DefaultSink.Enabled(),
+		})
+	}
+}
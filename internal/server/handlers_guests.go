@@ -0,0 +1,210 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+
+	"github.com/TheLab-ms/profile/internal/emailtmpl"
+	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/notify"
+	"github.com/TheLab-ms/profile/internal/reporting"
+	"github.com/TheLab-ms/profile/internal/urls"
+)
+
+// guestReportWindow is how far back the leadership guest report goes.
+const guestReportWindow = time.Hour * 24 * 30
+
+// newGuestFormHandler signs in a guest under their host member and sends them to Docuseal to sign the waiver.
+// It's reached by scanning the QR code at the door, so it doesn't require an account.
+//
+// Guests get the same response whether or not the host is a member, so the form can't be used to find out who is.
+// Instead, the host is asked to confirm the visit (see newGuestConfirmationHandler).
+func (s *Server) newGuestFormHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		viewData := map[string]any{"page": "guest"}
+		if r.Method != http.MethodPost {
+			render(w, r, "guest.html", viewData)
+			return
+		}
+
+		guest := &reporting.Guest{
+			Time:      time.Now(),
+			Name:      strings.TrimSpace(r.FormValue("name")),
+			Email:     strings.ToLower(strings.TrimSpace(r.FormValue("email"))),
+			HostEmail: strings.ToLower(strings.TrimSpace(r.FormValue("host"))),
+		}
+		viewData["guest"] = guest
+		if _, err := mail.ParseAddress(guest.Email); err != nil || guest.Name == "" || guest.HostEmail == "" {
+			viewData["error"] = "Please enter your name, your email address, and your host's email address."
+			renderStatus(w, r, 400, "guest.html", viewData)
+			return
+		}
+
+		token := make([]byte, 16)
+		if _, err := rand.Read(token); err != nil {
+			renderSystemError(w, "error while generating confirmation token: %s", err)
+			return
+		}
+		guest.ConfirmToken = hex.EncodeToString(token)
+		if err := reporting.DefaultSink.RecordGuest(r.Context(), guest); err != nil {
+			renderSystemError(w, "error while recording guest: %s", err)
+			return
+		}
+		if err := s.notifyGuestHost(r, guest); err != nil {
+			log.Printf("error while asking host %s to confirm guest %s: %s", guest.HostEmail, guest.Email, err)
+		}
+
+		url, err := s.createWaiverSubmission(r.Context(), guest.Email)
+		if err != nil {
+			renderSystemError(w, "error while creating docuseal submission for guest: %s", err)
+			return
+		}
+		http.Redirect(w, r, url, http.StatusSeeOther)
+	}
+}
+
+// notifyGuestHost asks the host to confirm the guest's visit, if the host is an active member.
+func (s *Server) notifyGuestHost(r *http.Request, guest *reporting.Guest) error {
+	host, err := s.Keycloak.GetUserByEmail(r.Context(), guest.HostEmail)
+	if errors.Is(err, keycloak.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("getting host: %w", err)
+	}
+	extended, err := s.Keycloak.ExtendUser(r.Context(), host, host.UUID)
+	if err != nil {
+		return fmt.Errorf("getting host's group membership: %w", err)
+	}
+	if !extended.ActiveMember {
+		return nil
+	}
+
+	reporting.DefaultSink.Eventf(host.Email, "GuestSignedIn", "guest %q (%s) signed in", guest.Name, guest.Email)
+	_, err = s.Notify.Notify(r.Context(), host, notify.ReasonGuestConfirmation, &emailtmpl.GuestConfirmation{
+		Name:  guest.Name,
+		Email: guest.Email,
+		URL:   urls.GuestConfirmation(s.Env, guest.ConfirmToken),
+	})
+	return err
+}
+
+// newGuestConfirmationHandler lets hosts confirm their guests' visits, which count towards their daily limit.
+func (s *Server) newGuestConfirmationHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		guest, err := reporting.DefaultSink.GetGuestByToken(r.Context(), r.FormValue("t"))
+		if err != nil {
+			renderSystemError(w, "error while getting guest: %s", err)
+			return
+		}
+		host, err := s.Keycloak.GetUserCached(r.Context(), getUserID(r))
+		if err != nil {
+			renderSystemError(w, "error while getting user: %s", err)
+			return
+		}
+		if guest == nil || !strings.EqualFold(guest.HostEmail, host.Email) {
+			http.Error(w, "guest not found", 404)
+			return
+		}
+
+		guest.Time = s.localTime(guest.Time)
+		viewData := map[string]any{"page": "profile", "guest": guest, "token": guest.ConfirmToken}
+		if r.Method != http.MethodPost || guest.ConfirmedAt != nil {
+			render(w, r, "guest-confirm.html", viewData)
+			return
+		}
+
+		now := s.localTime(time.Now())
+		midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		n, err := reporting.DefaultSink.CountGuests(r.Context(), host.Email, midnight)
+		if err != nil {
+			renderSystemError(w, "error while counting guests: %s", err)
+			return
+		}
+		if n >= s.Env.GuestDailyLimit {
+			viewData["error"] = fmt.Sprintf("You've already hosted %d guests today, which is the limit.", n)
+			renderStatus(w, r, 400, "guest-confirm.html", viewData)
+			return
+		}
+
+		if err := reporting.DefaultSink.ConfirmGuest(r.Context(), guest.ID, time.Now()); err != nil {
+			renderSystemError(w, "error while confirming guest: %s", err)
+			return
+		}
+		reporting.DefaultSink.Eventf(host.Email, "GuestConfirmed", "confirmed guest %q (%s)", guest.Name, guest.Email)
+		http.Redirect(w, r, "/profile/guests/confirm?t="+url.QueryEscape(guest.ConfirmToken), http.StatusSeeOther)
+	}
+}
+
+// newGuestQRHandler renders the QR code posted at the door.
+func (s *Server) newGuestQRHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		png, err := qrcode.Encode(urls.Guest(s.Env), qrcode.Medium, 512)
+		if err != nil {
+			renderSystemError(w, "generating QR code: %s", err)
+			return
+		}
+		w.Header().Add("Content-Type", "image/png")
+		w.Write(png)
+	}
+}
+
+// guestHostSummary is one row of the leadership guest report.
+type guestHostSummary struct {
+	HostEmail string
+	Guests    int
+	Unsigned  int // waiver was never completed
+}
+
+// newAdminGuestsHandler reports guest traffic over the last 30 days.
+func (s *Server) newAdminGuestsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		guests, err := reporting.DefaultSink.ListGuests(r.Context(), time.Now().Add(-guestReportWindow))
+		if err != nil {
+			renderSystemError(w, "error while listing guests: %s", err)
+			return
+		}
+
+		byHost := map[string]*guestHostSummary{}
+		for _, guest := range guests {
+			guest.Time = s.localTime(guest.Time)
+			summary, ok := byHost[guest.HostEmail]
+			if !ok {
+				summary = &guestHostSummary{HostEmail: guest.HostEmail}
+				byHost[guest.HostEmail] = summary
+			}
+			summary.Guests++
+			if guest.WaiverSignedAt == nil {
+				summary.Unsigned++
+			}
+		}
+		hosts := []*guestHostSummary{}
+		for _, summary := range byHost {
+			hosts = append(hosts, summary)
+		}
+		sort.Slice(hosts, func(i, j int) bool {
+			if hosts[i].Guests != hosts[j].Guests {
+				return hosts[i].Guests > hosts[j].Guests
+			}
+			return hosts[i].HostEmail < hosts[j].HostEmail
+		})
+
+		render(w, r, "admin-guests.html", map[string]any{
+			"page":   "admin",
+			"guests": guests,
+			"hosts":  hosts,
+			"limit":  s.Env.GuestDailyLimit,
+		})
+	}
+}
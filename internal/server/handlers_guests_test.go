@@ -0,0 +1,105 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TheLab-ms/profile/internal/conf"
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/keycloak/keycloaktest"
+	"github.com/TheLab-ms/profile/internal/notify"
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+func TestGuestSignIn(t *testing.T) {
+	kcFake := keycloaktest.NewFake("members")
+	kcFake.AddUser(&gocloak.User{ID: gocloak.StringP("user-1"), Email: gocloak.StringP("host@example.com")}, true)
+	kcFake.AddUser(&gocloak.User{ID: gocloak.StringP("user-2"), Email: gocloak.StringP("former@example.com")}, false)
+	kcServer := httptest.NewServer(kcFake)
+	t.Cleanup(kcServer.Close)
+
+	var submissions []string
+	docuseal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		submissions = append(submissions, r.URL.Path)
+		fmt.Fprint(w, `[{"slug":"guest-waiver"}]`)
+	}))
+	t.Cleanup(docuseal.Close)
+
+	env := &conf.Env{
+		KeycloakURL:            kcServer.URL,
+		KeycloakRealm:          "master",
+		KeycloakMembersGroupID: "members",
+		KeycloakClientID:       "test",
+		KeycloakClientSecret:   "test",
+		SpaceTimezone:          "UTC",
+		DocusealURL:            docuseal.URL,
+		GuestDailyLimit:        2,
+	}
+	kc := keycloak.New[*datamodel.User](env)
+	kc.Sink = reporting.DefaultSink // disabled, but nil-safe
+	emails := &fakeEmailSender{}
+	s := &Server{Env: env, Keycloak: kc, Notify: notify.New(nil, emails)}
+
+	submit := func(name, email, host string) *httptest.ResponseRecorder {
+		form := url.Values{"name": {name}, "email": {email}, "host": {host}}
+		req := httptest.NewRequest("POST", "/guest", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		s.newGuestFormHandler()(w, req)
+		return w
+	}
+
+	w := submit("Grace", "grace@example.com", "Host@Example.com")
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, docuseal.URL+"/s/guest-waiver", w.Header().Get("Location"))
+	assert.Equal(t, []string{"/api/submissions"}, submissions)
+	assert.Equal(t, []string{notify.ReasonGuestConfirmation}, emails.sent)
+
+	// The response doesn't reveal whether the host is a member, but only active members are asked to confirm
+	for _, host := range []string{"former@example.com", "nobody@example.com"} {
+		w = submit("Grace", "grace@example.com", host)
+		assert.Equal(t, http.StatusSeeOther, w.Code, host)
+		assert.Equal(t, docuseal.URL+"/s/guest-waiver", w.Header().Get("Location"), host)
+	}
+	assert.Len(t, submissions, 3)
+	assert.Len(t, emails.sent, 1)
+
+	for _, form := range [][]string{{"", "not an email", "host@example.com"}, {"Grace", "grace@example.com", ""}} {
+		w = submit(form[0], form[1], form[2])
+		assert.Equal(t, 400, w.Code)
+	}
+	assert.Len(t, submissions, 3)
+}
+
+func TestGuestConfirmationRequiresHost(t *testing.T) {
+	kcFake := keycloaktest.NewFake("members")
+	kcFake.AddUser(&gocloak.User{ID: gocloak.StringP("user-1"), Email: gocloak.StringP("host@example.com")}, true)
+	kcServer := httptest.NewServer(kcFake)
+	t.Cleanup(kcServer.Close)
+
+	env := &conf.Env{
+		KeycloakURL:            kcServer.URL,
+		KeycloakRealm:          "master",
+		KeycloakMembersGroupID: "members",
+		KeycloakClientID:       "test",
+		KeycloakClientSecret:   "test",
+	}
+	kc := keycloak.New[*datamodel.User](env)
+	kc.Sink = reporting.DefaultSink // disabled, but nil-safe
+	s := &Server{Env: env, Keycloak: kc}
+
+	// Unknown tokens look the same as other hosts' guests
+	req := httptest.NewRequest("POST", "/profile/guests/confirm?t=nope", nil)
+	req.Header.Set("X-Forwarded-Preferred-Username", "user-1")
+	w := httptest.NewRecorder()
+	s.newGuestConfirmationHandler()(w, req)
+	assert.Equal(t, 404, w.Code)
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/TheLab-ms/profile/internal/jobs"
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+type jobStatus struct {
+	Name   string
+	Latest *jobs.Run
+}
+
+// newAdminRunJobHandler lets leadership run check jobs on demand instead of waiting for their next scheduled run
+// e.g. after fixing data. Posting starts the job (or joins the current run) and streams its output until it finishes.
+// Jobs keep running if the request is canceled.
+func (s *Server) newAdminRunJobHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			statuses := []*jobStatus{}
+			for _, name := range jobs.Names() {
+				statuses = append(statuses, &jobStatus{Name: name, Latest: s.Jobs.Latest(name)})
+			}
+			render(w, r, "admin-run-job.html", map[string]any{"page": "admin", "jobs": statuses})
+			return
+		}
+
+		name := r.FormValue("name")
+		dryRun := r.FormValue("dryRun") != ""
+		run, err := s.Jobs.Start(context.WithoutCancel(r.Context()), name, dryRun)
+		if errors.Is(err, jobs.ErrUnknownJob) {
+			http.Error(w, "unknown job", http.StatusNotFound)
+			return
+		}
+		if err != nil && !errors.Is(err, jobs.ErrAlreadyRunning) {
+			renderSystemError(w, "error while starting job: %s", err)
+			return
+		}
+		if err == nil {
+			reporting.DefaultSink.Eventf(r.Header.Get("X-Forwarded-Email"), "JobStarted", "started job %s on demand (dry run=%t)", name, dryRun)
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		flusher, _ := w.(http.Flusher)
+		flush := func() {
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		kind := "run"
+		if run.DryRun {
+			kind = "dry run"
+		}
+		fmt.Fprintf(w, "following %s %s started at %s\n\n", run.Name, kind, s.localTime(run.Started).Format("01/02/2006 3:04:05 PM"))
+		flush()
+
+		if err := run.Follow(r.Context(), w, flush); err != nil {
+			return // the client went away
+		}
+		if err := run.Err(); err != nil {
+			fmt.Fprintf(w, "\njob failed: %s\n", err)
+			return
+		}
+		fmt.Fprintf(w, "\njob finished\n")
+	}
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/TheLab-ms/profile/internal/emailtmpl"
+	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/reporting"
+	"github.com/TheLab-ms/profile/internal/urls"
+)
+
+const magicLinkCookieName = "magiclink_session"
+
+const (
+	tokenPurposeLink    = "link"
+	tokenPurposeSession = "session"
+)
+
+var errInvalidToken = errors.New("invalid or expired token")
+
+func (s *Server) newMagicLinkFormHandler() http.HandlerFunc {
+	rateLimiter := rate.NewLimiter(1, 2)
+	return func(w http.ResponseWriter, r *http.Request) {
+		// The return URL is carried through the emailed link, and checked again when it's used
+		viewData := map[string]any{"page": "login", "return": s.safeReturnURL(r.FormValue("return"), "")}
+		if r.Method != http.MethodPost {
+			render(w, r, "login.html", viewData)
+			return
+		}
+		if err := rateLimiter.Wait(r.Context()); err != nil {
+			log.Printf("rate limiter error: %s", err)
+		}
+
+		email := r.FormValue("email")
+		if _, err := mail.ParseAddress(email); err != nil {
+			http.Error(w, "invalid email address", 400)
+			return
+		}
+
+		// Always render the same response to avoid leaking which emails have accounts
+		viewData["sent"] = true
+		user, err := s.Keycloak.GetUserByEmail(r.Context(), email)
+		if errors.Is(err, keycloak.ErrNotFound) {
+			render(w, r, "login.html", viewData)
+			return
+		}
+		if err != nil {
+			renderSystemError(w, "error while getting user: %s", err)
+			return
+		}
+
+		nonce := make([]byte, 16)
+		if _, err := rand.Read(nonce); err != nil {
+			renderSystemError(w, "error while generating nonce: %s", err)
+			return
+		}
+		token := signToken(s.Env.MagicLinkSigningKey, &tokenClaims{
+			UserID:     user.UUID,
+			Purpose:    tokenPurposeLink,
+			Expiration: time.Now().Add(s.Env.MagicLinkTTL).Unix(),
+			Nonce:      hex.EncodeToString(nonce),
+		})
+		err = s.Email.SendTemplate(r.Context(), user.Email, "magicLink", &emailtmpl.MagicLink{
+			Link:       urls.MagicLink(s.Env, token, viewData["return"].(string)),
+			TTLMinutes: int(s.Env.MagicLinkTTL.Minutes()),
+		})
+		if err != nil {
+			renderSystemError(w, "error while sending magic link: %s", err)
+			return
+		}
+
+		reporting.DefaultSink.Eventf(user.Email, "MagicLinkSent", "sent magic login link")
+		render(w, r, "login.html", viewData)
+	}
+}
+
+func (s *Server) newMagicLinkVerificationHandler() http.HandlerFunc {
+	nonces := newSharedNonceSet("magic-link")
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := verifyToken(s.Env.MagicLinkSigningKey, r.URL.Query().Get("t"), tokenPurposeLink, time.Now())
+		if err != nil || claims.Nonce == "" {
+			http.Error(w, "this login link is invalid or has expired", 400)
+			return
+		}
+
+		// Links can't be replayed e.g. from a forwarded email or browser history
+		ok, err := nonces.Use(r.Context(), claims.Nonce, time.Unix(claims.Expiration, 0))
+		if err != nil {
+			renderSystemError(w, "error while checking login link: %s", err)
+			return
+		}
+		if !ok {
+			http.Error(w, "this login link has already been used - request a new one", 400)
+			return
+		}
+
+		// Sessions carry the email too, since some handlers identify members by the header oauth2proxy sets for it
+		user, err := s.Keycloak.GetUser(r.Context(), claims.UserID)
+		if err != nil {
+			renderSystemError(w, "error while getting user: %s", err)
+			return
+		}
+
+		expiration := time.Now().Add(s.Env.MagicLinkSessionTTL)
+		http.SetCookie(w, &http.Cookie{
+			Name:     magicLinkCookieName,
+			Value:    signToken(s.Env.MagicLinkSigningKey, &tokenClaims{UserID: user.UUID, Email: user.Email, Purpose: tokenPurposeSession, Expiration: expiration.Unix()}),
+			Path:     "/",
+			Expires:  expiration,
+			HttpOnly: true,
+			Secure:   strings.HasPrefix(s.Env.SelfURL, "https://"),
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		log.Printf("established magic link session for user %s", claims.UserID)
+		http.Redirect(w, r, s.safeReturnURL(r.URL.Query().Get("return"), "/profile"), http.StatusSeeOther)
+	}
+}
+
+// withMagicLinkSession translates magic link session cookies into the same headers set by oauth2proxy.
+// Requests that already carry the header (i.e. went through the proxy) are passed through untouched.
+func (s *Server) withMagicLinkSession(next http.Handler) http.Handler {
+	if s.Env.MagicLinkSigningKey == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Forwarded-Preferred-Username") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cookie, err := r.Cookie(magicLinkCookieName)
+		if err == nil {
+			claims, err := verifyToken(s.Env.MagicLinkSigningKey, cookie.Value, tokenPurposeSession, time.Now())
+			if err == nil {
+				r.Header.Set("X-Forwarded-Preferred-Username", claims.UserID)
+				r.Header.Set("X-Forwarded-Email", claims.Email)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// tokenClaims are carried by magic links and session cookies as HS256 JWTs (see signJWS), signed with MagicLinkSigningKey.
+type tokenClaims struct {
+	UserID     string `json:"sub"`
+	Email      string `json:"email,omitempty"` // only set for sessions
+	Purpose    string `json:"purpose"`
+	Expiration int64  `json:"exp"`             // seconds since unix epoch utc
+	Nonce      string `json:"nonce,omitempty"` // only set for links, which are single-use
+}
+
+func signToken(key string, claims *tokenClaims) string {
+	js, err := json.Marshal(claims)
+	if err != nil {
+		panic(err) // unlikely
+	}
+	return signJWS(key, js)
+}
+
+func verifyToken(key, token, purpose string, now time.Time) (*tokenClaims, error) {
+	js, err := verifyJWS(key, token)
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	claims := &tokenClaims{}
+	if err := json.Unmarshal(js, claims); err != nil {
+		return nil, errInvalidToken
+	}
+	if claims.Purpose != purpose || claims.UserID == "" || now.Unix() > claims.Expiration {
+		return nil, errInvalidToken
+	}
+	return claims, nil
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TheLab-ms/profile/internal/conf"
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/keycloak/keycloaktest"
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+func TestMagicLinkTokens(t *testing.T) {
+	now := time.Unix(100000, 0)
+	token := signToken("test-key", &tokenClaims{UserID: "test-user", Purpose: tokenPurposeLink, Expiration: now.Add(time.Minute).Unix()})
+
+	claims, err := verifyToken("test-key", token, tokenPurposeLink, now)
+	require.NoError(t, err)
+	assert.Equal(t, "test-user", claims.UserID)
+
+	// Expired
+	_, err = verifyToken("test-key", token, tokenPurposeLink, now.Add(time.Hour))
+	assert.ErrorIs(t, err, errInvalidToken)
+
+	// Wrong purpose i.e. a link token can't be used as a session cookie
+	_, err = verifyToken("test-key", token, tokenPurposeSession, now)
+	assert.ErrorIs(t, err, errInvalidToken)
+
+	// Wrong key
+	_, err = verifyToken("another-key", token, tokenPurposeLink, now)
+	assert.ErrorIs(t, err, errInvalidToken)
+
+	// Tampered payload
+	parts := strings.Split(token, ".")
+	parts[1] = base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"another-user","purpose":"link","exp":200000}`))
+	_, err = verifyToken("test-key", strings.Join(parts, "."), tokenPurposeLink, now)
+	assert.ErrorIs(t, err, errInvalidToken)
+}
+
+func TestMagicLinkSingleUse(t *testing.T) {
+	kcFake := keycloaktest.NewFake("members")
+	kcFake.AddUser(&gocloak.User{ID: gocloak.StringP("test-user"), Email: gocloak.StringP("member@example.com")}, true)
+	kcServer := httptest.NewServer(kcFake)
+	t.Cleanup(kcServer.Close)
+
+	env := &conf.Env{
+		KeycloakURL:            kcServer.URL,
+		KeycloakRealm:          "master",
+		KeycloakMembersGroupID: "members",
+		KeycloakClientID:       "test",
+		KeycloakClientSecret:   "test",
+		MagicLinkSigningKey:    "test-key",
+		MagicLinkSessionTTL:    time.Hour,
+		SelfURL:                "https://example.com",
+	}
+	kc := keycloak.New[*datamodel.User](env)
+	kc.Sink = reporting.DefaultSink // disabled, but nil-safe
+	s := &Server{Env: env, Keycloak: kc}
+	handler := s.newMagicLinkVerificationHandler()
+
+	verify := func(claims *tokenClaims) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/login/verify?t="+url.QueryEscape(signToken("test-key", claims)), nil)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	claims := &tokenClaims{UserID: "test-user", Purpose: tokenPurposeLink, Expiration: time.Now().Add(time.Minute).Unix(), Nonce: "test-nonce"}
+	w := verify(claims)
+	assert.Equal(t, 303, w.Code)
+	assert.Equal(t, 400, verify(claims).Code)
+
+	// Sessions identify the member the same way oauth2proxy does
+	var headers http.Header
+	session := s.withMagicLinkSession(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { headers = r.Header }))
+	req := httptest.NewRequest("GET", "/profile", nil)
+	req.AddCookie(w.Result().Cookies()[0])
+	session.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "test-user", headers.Get("X-Forwarded-Preferred-Username"))
+	assert.Equal(t, "member@example.com", headers.Get("X-Forwarded-Email"))
+
+	// Links from before nonces were added can't be used either
+	claims.Nonce = ""
+	assert.Equal(t, 400, verify(claims).Code)
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/newsletter"
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+// newNewsletterWebhookHandler records subscription changes made through the newsletter itself e.g. confirming the
+// opt-in email or following the unsubscribe link. Unsubscribing clears the member's opt-in so the sync in
+// cmd/profile-async doesn't sign them back up.
+func (s *Server) newNewsletterWebhookHandler() http.HandlerFunc {
+	return newsletter.NewWebhookHandler(s.Env.NewsletterWebhookSecret, func(ctx context.Context, event *newsletter.Event) bool {
+		if err := s.handleNewsletterEvent(ctx, event); err != nil {
+			log.Printf("error while handling %s newsletter event for %s: %s", event.Type, event.Email, err)
+			return false
+		}
+		return true
+	}).ServeHTTP
+}
+
+func (s *Server) handleNewsletterEvent(ctx context.Context, event *newsletter.Event) error {
+	user, err := s.Keycloak.GetUserByEmail(ctx, event.Email)
+	if errors.Is(err, keycloak.ErrNotFound) {
+		return nil // subscribers don't have to be members
+	}
+	if err != nil {
+		return err
+	}
+
+	optIn, status := true, newsletter.StatusSubscribed
+	switch event.Type {
+	case newsletter.EventUnsubscribe:
+		optIn, status = false, newsletter.StatusUnsubscribed
+	case newsletter.EventCleaned:
+		optIn, status = false, newsletter.StatusCleaned
+	}
+	if user.NewsletterOptIn == optIn && user.NewsletterStatus == status {
+		return nil
+	}
+
+	user.NewsletterOptIn = optIn
+	user.NewsletterStatus = status
+	if err := s.Keycloak.WriteUser(ctx, user); err != nil {
+		return err
+	}
+	reporting.DefaultSink.Eventf(user.Email, "NewsletterStatusChanged", "newsletter subscription is now %s", status)
+	return nil
+}
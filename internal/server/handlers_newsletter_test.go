@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TheLab-ms/profile/internal/conf"
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/keycloak/keycloaktest"
+	"github.com/TheLab-ms/profile/internal/newsletter"
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+func TestNewsletterEvents(t *testing.T) {
+	kcFake := keycloaktest.NewFake("members")
+	kcFake.AddUser(&gocloak.User{
+		ID:         gocloak.StringP("user-1"),
+		Email:      gocloak.StringP("ada@example.com"),
+		Attributes: &map[string][]string{"newsletterOptIn": {"true"}, "newsletterStatus": {"pending"}},
+	}, true)
+	kcServer := httptest.NewServer(kcFake)
+	t.Cleanup(kcServer.Close)
+
+	env := &conf.Env{
+		KeycloakURL:            kcServer.URL,
+		KeycloakRealm:          "master",
+		KeycloakMembersGroupID: "members",
+		KeycloakClientID:       "test",
+		KeycloakClientSecret:   "test",
+	}
+	kc := keycloak.New[*datamodel.User](env)
+	kc.Sink = reporting.DefaultSink // disabled, but nil-safe
+	s := &Server{Env: env, Keycloak: kc}
+	ctx := context.Background()
+
+	getUser := func() *datamodel.User {
+		user, err := kc.GetUser(ctx, "user-1")
+		require.NoError(t, err)
+		return user
+	}
+
+	// Confirming the opt-in email
+	require.NoError(t, s.handleNewsletterEvent(ctx, &newsletter.Event{Type: newsletter.EventSubscribe, Email: "ada@example.com"}))
+	user := getUser()
+	assert.True(t, user.NewsletterOptIn)
+	assert.Equal(t, newsletter.StatusSubscribed, user.NewsletterStatus)
+
+	// Unsubscribing through the newsletter clears the opt-in so it isn't synced back
+	require.NoError(t, s.handleNewsletterEvent(ctx, &newsletter.Event{Type: newsletter.EventUnsubscribe, Email: "ada@example.com"}))
+	user = getUser()
+	assert.False(t, user.NewsletterOptIn)
+	assert.Equal(t, newsletter.StatusUnsubscribed, user.NewsletterStatus)
+
+	// Subscribers who aren't members are ignored
+	require.NoError(t, s.handleNewsletterEvent(ctx, &newsletter.Event{Type: newsletter.EventUnsubscribe, Email: "nobody@example.com"}))
+}
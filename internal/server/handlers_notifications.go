@@ -0,0 +1,77 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+// notificationRow is a category on the preference page along with the member's choice for each channel.
+type notificationRow struct {
+	*datamodel.NotificationCategory
+	Channels []*notificationChannel
+}
+
+type notificationChannel struct {
+	Name, Key string
+	Enabled   bool
+}
+
+func (s *Server) newNotificationPreferencesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := s.Keycloak.GetUser(r.Context(), getUserID(r))
+		if err != nil {
+			renderSystemError(w, "error while getting user: %s", err)
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			r.ParseForm()
+			optOuts := map[string]bool{}
+			for _, category := range datamodel.NotificationCategories {
+				for _, channel := range datamodel.NotificationChannels {
+					key := datamodel.NotificationKey(category.Name, channel)
+					if r.PostForm.Get(key) == "" {
+						optOuts[key] = true
+					}
+				}
+			}
+
+			user.NotificationOptOuts = optOuts
+			if s.Env.NewsletterAPIKey != "" {
+				user.NewsletterOptIn = r.PostForm.Get("newsletter") != "" // synced by cmd/profile-async
+			}
+			if err := s.Keycloak.WriteUser(r.Context(), user); err != nil {
+				renderSystemError(w, "error while updating user: %s", err)
+				return
+			}
+
+			reporting.DefaultSink.Eventf(user.Email, "UpdatedNotificationPreferences", "user updated their notification preferences")
+			http.Redirect(w, r, "/profile/notifications?saved=true", http.StatusSeeOther)
+			return
+		}
+
+		rows := []*notificationRow{}
+		for _, category := range datamodel.NotificationCategories {
+			row := &notificationRow{NotificationCategory: category}
+			for _, channel := range datamodel.NotificationChannels {
+				row.Channels = append(row.Channels, &notificationChannel{
+					Name:    channel,
+					Key:     datamodel.NotificationKey(category.Name, channel),
+					Enabled: user.WantsNotification(category.Name, channel),
+				})
+			}
+			rows = append(rows, row)
+		}
+
+		render(w, r, "notifications.html", map[string]any{
+			"page":          "profile",
+			"user":          user,
+			"categories":    rows,
+			"discordLinked": user.DiscordUserID != 0,
+			"saved":         r.URL.Query().Get("saved") != "",
+			"newsletter":    s.Env.NewsletterAPIKey != "",
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+const occupancyCacheTTL = time.Minute
+
+// occupancy is a rough count of the people in the space. It never identifies anyone: only counts are exposed, and
+// counts below the configured floor are hidden entirely.
+type occupancy struct {
+	Count     *int      `json:"count"` // nil when below the floor
+	FewerThan int       `json:"fewer_than,omitempty"`
+	AsOf      time.Time `json:"as_of"`
+}
+
+// Summary describes the occupancy for the profile page.
+func (o *occupancy) Summary() string {
+	switch {
+	case o.Count == nil:
+		return fmt.Sprintf("Fewer than %d people are at the space right now", o.FewerThan)
+	case *o.Count == 1:
+		return "About 1 person is at the space right now"
+	default:
+		return fmt.Sprintf("About %d people are at the space right now", *o.Count)
+	}
+}
+
+// occupancyCache keeps the swipe log from being queried on every profile view.
+type occupancyCache struct {
+	mut     sync.Mutex
+	current *occupancy
+}
+
+// estimateOccupancy counts each fob's latest swipe within the window, weighted by how recent it is since there's no
+// record of people leaving. Someone who just swiped in counts fully, and counts for less as the window passes.
+func estimateOccupancy(latestSwipes []time.Time, now time.Time, window time.Duration) int {
+	var total float64
+	for _, t := range latestSwipes {
+		age := now.Sub(t)
+		if age < 0 {
+			age = 0
+		}
+		if age >= window {
+			continue
+		}
+		total += 1 - float64(age)/float64(window)
+	}
+	return int(math.Round(total))
+}
+
+func (s *Server) getOccupancy(ctx context.Context) (*occupancy, error) {
+	s.occupancy.mut.Lock()
+	defer s.occupancy.mut.Unlock()
+
+	now := time.Now()
+	if s.occupancy.current != nil && now.Sub(s.occupancy.current.AsOf) < occupancyCacheTTL {
+		return s.occupancy.current, nil
+	}
+
+	swipes, err := reporting.DefaultSink.ListLatestSwipeTimes(ctx, now.Add(-s.Env.OccupancyWindow))
+	if err != nil {
+		return nil, err
+	}
+	o := &occupancy{AsOf: now}
+	if count := estimateOccupancy(swipes, now, s.Env.OccupancyWindow); count >= s.Env.OccupancyFloor {
+		o.Count = &count
+	} else {
+		o.FewerThan = s.Env.OccupancyFloor
+	}
+	s.occupancy.current = o
+	return o, nil
+}
+
+// newOccupancyHandler returns the estimated number of people in the space.
+func (s *Server) newOccupancyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		o, err := s.getOccupancy(r.Context())
+		if err != nil {
+			renderSystemError(w, "error while estimating occupancy: %s", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(occupancyCacheTTL.Seconds())))
+		json.NewEncoder(w).Encode(o)
+	}
+}
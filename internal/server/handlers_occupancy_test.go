@@ -0,0 +1,27 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimateOccupancy(t *testing.T) {
+	now := time.Unix(100000, 0)
+	window := time.Hour * 4
+
+	assert.Equal(t, 0, estimateOccupancy(nil, now, window))
+	assert.Equal(t, 1, estimateOccupancy([]time.Time{now}, now, window))
+
+	// Older swipes count for less, and ones outside of the window don't count at all
+	swipes := []time.Time{now, now.Add(-time.Hour), now.Add(-time.Hour), now.Add(-time.Hour * 3), now.Add(-window)}
+	assert.Equal(t, 3, estimateOccupancy(swipes, now, window)) // 1 + 0.75 + 0.75 + 0.25
+}
+
+func TestOccupancySummary(t *testing.T) {
+	one, several := 1, 5
+	assert.Equal(t, "Fewer than 3 people are at the space right now", (&occupancy{FewerThan: 3}).Summary())
+	assert.Equal(t, "About 1 person is at the space right now", (&occupancy{Count: &one}).Summary())
+	assert.Equal(t, "About 5 people are at the space right now", (&occupancy{Count: &several}).Summary())
+}
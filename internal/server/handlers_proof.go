@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TheLab-ms/profile/internal/chatbot"
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/pdf"
+	"github.com/TheLab-ms/profile/internal/reporting"
+	"github.com/TheLab-ms/profile/internal/urls"
+)
+
+// proofLetterValidity is how long the verification URL on a proof-of-membership letter keeps working.
+const proofLetterValidity = time.Hour * 24 * 365
+
+// newProofLetterHandler generates a dated letter confirming the member's active membership e.g. for insurance or
+// employer reimbursement. The letter links to newProofVerificationHandler so whoever receives it can check it's real.
+func (s *Server) newProofLetterHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := s.Keycloak.GetUser(r.Context(), getUserID(r))
+		if err != nil {
+			renderSystemError(w, "error while getting user: %s", err)
+			return
+		}
+		extended, err := s.Keycloak.ExtendUser(r.Context(), user, user.UUID)
+		if err != nil {
+			renderSystemError(w, "error while getting user's group membership: %s", err)
+			return
+		}
+		if !newEntitlements(extended, time.Now(), 0).Active {
+			http.Error(w, "proof of membership is only available to active members", http.StatusForbidden)
+			return
+		}
+
+		now := time.Now()
+		token := signProofToken(s.Env.EntitlementsSigningKey, user.UUID, now.Add(proofLetterValidity))
+		verifyURL := urls.ProofVerification(s.Env, token)
+
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", `attachment; filename="proof-of-membership.pdf"`)
+		w.Write(newProofLetter(user, s.localTime(now), now.Add(proofLetterValidity), verifyURL).Render())
+		reporting.DefaultSink.Eventf(user.Email, "ProofOfMembershipIssued", "user downloaded a proof of membership letter")
+	}
+}
+
+func newProofLetter(user *datamodel.User, now, verifiableUntil time.Time, verifyURL string) *pdf.Document {
+	const dateFormat = "January 2, 2006"
+	since := user.SignupTime
+	if since.IsZero() && user.CreationTime > 0 {
+		since = time.UnixMilli(user.CreationTime)
+	}
+
+	standing := fmt.Sprintf("This letter confirms that %s %s (%s) is an active member of TheLab in good standing as of %s.", user.First, user.Last, user.Email, now.Format(dateFormat))
+	if !since.IsZero() {
+		standing = fmt.Sprintf("This letter confirms that %s %s (%s) is an active member of TheLab in good standing as of %s, and has been a member since %s.", user.First, user.Last, user.Email, now.Format(dateFormat), since.Format(dateFormat))
+	}
+
+	return &pdf.Document{
+		Title: "Proof of Membership",
+		Paragraphs: []*pdf.Paragraph{
+			pdf.Text(now.Format(dateFormat)),
+			pdf.Text(""),
+			pdf.Text("To whom it may concern,"),
+			pdf.Text(""),
+			pdf.Text(standing),
+			pdf.Text(""),
+			pdf.Text(fmt.Sprintf("The authenticity of this letter can be verified until %s by visiting:", verifiableUntil.Format(dateFormat))),
+			{Text: verifyURL, Mono: true},
+			pdf.Text(""),
+			pdf.Text("TheLab Leadership"),
+		},
+	}
+}
+
+// proofVerification is returned to third parties checking a proof-of-membership letter.
+// Only what the letter already says is exposed, plus whether the membership is still active.
+type proofVerification struct {
+	Valid  bool   `json:"valid"`
+	Member string `json:"member,omitempty"`
+	Email  string `json:"email,omitempty"`
+	Active bool   `json:"active"` // right now, not when the letter was issued
+}
+
+// newProofVerificationHandler checks the token from a proof-of-membership letter against the member's current entitlements.
+// It's public, since the letters are given to third parties, and returns the same claims as the entitlements API.
+func (s *Server) newProofVerificationHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp, err := s.verifyProof(r.Context(), strings.TrimPrefix(r.URL.Path, "/verify/"))
+		if err != nil {
+			renderSystemError(w, "error while verifying proof of membership: %s", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func (s *Server) verifyProof(ctx context.Context, token string) (*proofVerification, error) {
+	userID, ok := verifyProofToken(s.Env.EntitlementsSigningKey, token, time.Now())
+	if !ok {
+		return &proofVerification{}, nil
+	}
+	user, err := s.Keycloak.GetUser(ctx, userID)
+	if errors.Is(err, keycloak.ErrNotFound) {
+		return &proofVerification{}, nil // the account has since been deleted
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting user: %w", err)
+	}
+	extended, err := s.Keycloak.ExtendUser(ctx, user, user.UUID)
+	if err != nil {
+		return nil, fmt.Errorf("getting group membership: %w", err)
+	}
+
+	return &proofVerification{
+		Valid:  true,
+		Member: fmt.Sprintf("%s %s", user.First, user.Last),
+		Email:  user.Email,
+		Active: newEntitlements(extended, time.Now(), 0).Active,
+	}, nil
+}
+
+// Proof tokens are printed on the letters, so unlike the other signed tokens they're kept short enough to type in:
+// "<user ID>.<base36 expiration>.<truncated signature>".
+func signProofToken(key, userID string, exp time.Time) string {
+	payload := userID + "." + strconv.FormatInt(exp.Unix(), 36)
+	return payload + "." + chatbot.GenerateHMAC("proof:"+payload, key)[:16]
+}
+
+func verifyProofToken(key, token string, now time.Time) (string, bool) {
+	i := strings.LastIndex(token, ".")
+	if i < 0 || key == "" {
+		return "", false
+	}
+	payload, sig := token[:i], token[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(chatbot.GenerateHMAC("proof:"+payload, key)[:16])) {
+		return "", false
+	}
+	userID, exp, ok := strings.Cut(payload, ".")
+	if !ok {
+		return "", false
+	}
+	expUnix, err := strconv.ParseInt(exp, 36, 64)
+	if err != nil || now.Unix() > expUnix {
+		return "", false
+	}
+	return userID, true
+}
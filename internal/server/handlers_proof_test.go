@@ -0,0 +1,97 @@
+package server
+
+import (
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TheLab-ms/profile/internal/conf"
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/keycloak/keycloaktest"
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+func TestProofLetter(t *testing.T) {
+	kcFake := keycloaktest.NewFake("members")
+	kcFake.AddUser(&gocloak.User{
+		ID:         gocloak.StringP("user-1"),
+		Email:      gocloak.StringP("ada@example.com"),
+		FirstName:  gocloak.StringP("Ada"),
+		LastName:   gocloak.StringP("Lovelace"),
+		Attributes: &map[string][]string{"buildingAccessApprover": {"test"}},
+	}, true)
+	kcFake.AddUser(&gocloak.User{
+		ID:    gocloak.StringP("user-2"),
+		Email: gocloak.StringP("grace@example.com"),
+	}, false)
+	kcServer := httptest.NewServer(kcFake)
+	t.Cleanup(kcServer.Close)
+
+	env := &conf.Env{
+		KeycloakURL:            kcServer.URL,
+		KeycloakRealm:          "master",
+		KeycloakMembersGroupID: "members",
+		KeycloakClientID:       "test",
+		KeycloakClientSecret:   "test",
+		SelfURL:                "https://profile.example.com",
+		SpaceTimezone:          "UTC",
+		EntitlementsSigningKey: "test-key",
+	}
+	kc := keycloak.New[*datamodel.User](env)
+	kc.Sink = reporting.DefaultSink // disabled, but nil-safe
+	s := &Server{Env: env, Keycloak: kc}
+
+	download := func(userID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/profile/proof.pdf", nil)
+		req.Header.Set("X-Forwarded-Preferred-Username", userID)
+		w := httptest.NewRecorder()
+		s.newProofLetterHandler()(w, req)
+		return w
+	}
+	verify := func(token string) string {
+		w := httptest.NewRecorder()
+		s.newProofVerificationHandler()(w, httptest.NewRequest("GET", "/verify/"+token, nil))
+		require.Equal(t, 200, w.Code)
+		return w.Body.String()
+	}
+
+	w := download("user-1")
+	require.Equal(t, 200, w.Code)
+	assert.Equal(t, "application/pdf", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "Ada Lovelace \\(ada@example.com\\) is an active member")
+
+	// The letter's verification URL checks out
+	match := regexp.MustCompile(`\(https://profile\.example\.com/verify/([^)]+)\) Tj`).FindStringSubmatch(w.Body.String())
+	require.Len(t, match, 2)
+	token := match[1]
+	assert.JSONEq(t, `{"valid":true,"member":"Ada Lovelace","email":"ada@example.com","active":true}`, verify(token))
+
+	assert.JSONEq(t, `{"valid":false,"active":false}`, verify(token+"x"))
+	assert.JSONEq(t, `{"valid":false,"active":false}`, verify(""))
+
+	// Only active members get letters
+	assert.Equal(t, 403, download("user-2").Code)
+}
+
+func TestProofToken(t *testing.T) {
+	now := time.Unix(1000, 0)
+	token := signProofToken("test-key", "2f9c1a5e-8d7b-4c1e-9a3f-6b2d8e4f1c7a", now.Add(time.Hour))
+	assert.Len(t, token, 36+1+3+1+16)
+
+	userID, ok := verifyProofToken("test-key", token, now)
+	assert.True(t, ok)
+	assert.Equal(t, "2f9c1a5e-8d7b-4c1e-9a3f-6b2d8e4f1c7a", userID)
+
+	_, ok = verifyProofToken("test-key", token, now.Add(time.Hour*2))
+	assert.False(t, ok, "expired")
+	_, ok = verifyProofToken("other-key", token, now)
+	assert.False(t, ok, "wrong key")
+	_, ok = verifyProofToken("", token, now)
+	assert.False(t, ok, "no key")
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/payment"
+	"github.com/TheLab-ms/profile/internal/ratelimit"
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+// newRedeemHandler lets members redeem promo and partner codes maintained by leadership (see newAdminRedemptionCodesHandler).
+// Codes apply to the live subscription when there is one, otherwise they're picked up at checkout.
+// Members who already have a discount can't redeem codes.
+func (s *Server) newRedeemHandler() http.HandlerFunc {
+	limiter := ratelimit.New(reporting.DefaultSink, "redeem", 10, time.Hour) // per member, to keep codes from being guessed
+	return func(w http.ResponseWriter, r *http.Request) {
+		viewData := map[string]any{"page": "profile"}
+		if r.Method != http.MethodPost {
+			render(w, r, "redeem.html", viewData)
+			return
+		}
+		if !limiter.Allow(r.Context(), getUserID(r)) {
+			viewData["error"] = "Too many attempts - please try again later."
+			renderStatus(w, r, http.StatusTooManyRequests, "redeem.html", viewData)
+			return
+		}
+
+		user, err := s.Keycloak.GetUser(r.Context(), getUserID(r))
+		if err != nil {
+			renderSystemError(w, "error while getting user: %s", err)
+			return
+		}
+
+		// Stripe subscriptions only take one discount, so codes would otherwise replace e.g. a leadership-granted one
+		discounted, err := s.hasDiscount(r.Context(), user)
+		if err != nil {
+			renderSystemError(w, "error while checking for existing discounts: %s", err)
+			return
+		}
+		if discounted {
+			viewData["error"] = "You already have a discount, and codes can't be combined with it. Reach out to leadership if you'd like to switch."
+			renderStatus(w, r, http.StatusConflict, "redeem.html", viewData)
+			return
+		}
+
+		code, err := reporting.DefaultSink.RedeemCode(r.Context(), r.FormValue("code"), user.Email, time.Now())
+		if errors.Is(err, reporting.ErrCodeInvalid) || errors.Is(err, reporting.ErrCodeAlreadyRedeemed) {
+			viewData["error"] = "That code is invalid, expired, or has already been redeemed."
+			renderStatus(w, r, 400, "redeem.html", viewData)
+			return
+		}
+		if err != nil {
+			renderSystemError(w, "error while redeeming code: %s", err)
+			return
+		}
+
+		billed, err := s.applyRedemptionCode(r.Context(), user, code)
+		if err != nil {
+			if err := reporting.DefaultSink.ReleaseRedemption(r.Context(), code.Code, user.Email); err != nil {
+				renderSystemError(w, "error while releasing code redemption: %s", err)
+				return
+			}
+			renderSystemError(w, "error while applying code: %s", err)
+			return
+		}
+
+		reporting.DefaultSink.Eventf(user.Email, "CodeRedeemed", "redeemed code %q (discount type %q, promotion code %q, subscription updated=%t)", code.Code, code.DiscountType, code.PromotionCode, billed)
+		viewData["redeemed"] = code
+		viewData["subscribed"] = user.StripeSubscriptionID != ""
+		render(w, r, "redeem.html", viewData)
+	}
+}
+
+// hasDiscount returns true if the member already has a discount, or a promotion code waiting to be used at checkout.
+func (s *Server) hasDiscount(ctx context.Context, user *datamodel.User) (bool, error) {
+	if user.DiscountType != "" || user.StripePromotionCode != "" {
+		return true, nil
+	}
+	if user.StripeSubscriptionID == "" {
+		return false, nil
+	}
+	return payment.SubscriptionDiscounted(ctx, user.StripeSubscriptionID)
+}
+
+// applyRedemptionCode gives the member the code's discount, returning true if their live subscription was updated.
+func (s *Server) applyRedemptionCode(ctx context.Context, user *datamodel.User, code *reporting.RedemptionCode) (bool, error) {
+	var billed bool
+	if code.DiscountType != "" {
+		if user.StripeSubscriptionID != "" {
+			var err error
+			billed, err = payment.ApplySubscriptionDiscount(ctx, user.StripeSubscriptionID, code.DiscountType, s.PriceCache.GetPrices())
+			if err != nil {
+				return false, fmt.Errorf("updating Stripe subscription: %w", err)
+			}
+		}
+		user.DiscountType = code.DiscountType
+		user.DiscountExpiration = time.Time{}
+		user.DiscountNoticeTime = time.Time{}
+	}
+	if code.PromotionCode != "" {
+		if user.StripeSubscriptionID != "" {
+			if err := payment.ApplySubscriptionPromotionCode(ctx, user.StripeSubscriptionID, code.PromotionCode); err != nil {
+				return false, fmt.Errorf("applying promotion code to Stripe subscription: %w", err)
+			}
+			billed = true
+		} else {
+			user.StripePromotionCode = code.PromotionCode
+		}
+	}
+
+	if err := s.Keycloak.WriteUser(ctx, user); err != nil {
+		return billed, fmt.Errorf("writing user: %w", err)
+	}
+	return billed, nil
+}
+
+// newAdminRedemptionCodesHandler lists redemption codes and lets leadership create or disable them.
+func (s *Server) newAdminRedemptionCodesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		viewData := map[string]any{"page": "admin", "discountTypes": s.PriceCache.GetDiscountTypes()}
+		if r.Method == http.MethodPost {
+			problem, err := s.applyRedemptionCodeChange(r)
+			if err != nil {
+				renderSystemError(w, "error while updating redemption code: %s", err)
+				return
+			}
+			if problem == "" {
+				http.Redirect(w, r, "/admin/redemption-codes?saved=true", http.StatusSeeOther)
+				return
+			}
+			viewData["error"] = problem
+		}
+
+		codes, err := reporting.DefaultSink.ListRedemptionCodes(r.Context())
+		if err != nil {
+			renderSystemError(w, "error while listing redemption codes: %s", err)
+			return
+		}
+		viewData["codes"] = codes
+		viewData["saved"] = r.URL.Query().Get("saved") != ""
+		render(w, r, "admin-redemption-codes.html", viewData)
+	}
+}
+
+// applyRedemptionCodeChange creates or toggles a code based on the form values.
+// Problems with the form are returned as a message rather than an error.
+func (s *Server) applyRedemptionCodeChange(r *http.Request) (string, error) {
+	actor := r.Header.Get("X-Forwarded-Email")
+	switch r.FormValue("action") {
+	case "disable", "enable":
+		disabled := r.FormValue("action") == "disable"
+		if err := reporting.DefaultSink.SetRedemptionCodeDisabled(r.Context(), r.FormValue("code"), disabled); err != nil {
+			return "", err
+		}
+		reporting.DefaultSink.Eventf(actor, "RedemptionCodeChanged", "redemption code %q was %sd", reporting.NormalizeCode(r.FormValue("code")), r.FormValue("action"))
+		return "", nil
+	}
+
+	code := &reporting.RedemptionCode{
+		Code:          reporting.NormalizeCode(r.FormValue("code")),
+		DiscountType:  r.FormValue("discountType"),
+		PromotionCode: r.FormValue("promotionCode"),
+		CreatedBy:     actor,
+		CreatedAt:     time.Now(),
+	}
+	if code.Code == "" {
+		return "A code is required.", nil
+	}
+	if (code.DiscountType == "") == (code.PromotionCode == "") {
+		return "Codes must give either a discount type or a Stripe promotion code.", nil
+	}
+	if str := r.FormValue("maxUses"); str != "" {
+		n, err := strconv.Atoi(str)
+		if err != nil || n < 0 {
+			return "Max uses must be a positive number.", nil
+		}
+		code.MaxUses = n
+	}
+	if str := r.FormValue("expires"); str != "" {
+		loc, _ := time.LoadLocation(s.Env.SpaceTimezone) // validated at startup
+		day, err := time.ParseInLocation("2006-01-02", str, loc)
+		if err != nil {
+			return "Invalid expiration date.", nil
+		}
+		expires := day.AddDate(0, 0, 1)
+		code.Expires = &expires
+	}
+
+	created, err := reporting.DefaultSink.CreateRedemptionCode(r.Context(), code)
+	if err != nil {
+		return "", err
+	}
+	if !created {
+		return fmt.Sprintf("The code %s already exists.", code.Code), nil
+	}
+	reporting.DefaultSink.Eventf(actor, "RedemptionCodeCreated", "created redemption code %q (discount type %q, promotion code %q, max uses %d)", code.Code, code.DiscountType, code.PromotionCode, code.MaxUses)
+	return "", nil
+}
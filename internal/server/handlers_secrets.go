@@ -12,15 +12,15 @@ import (
 	"strings"
 	"time"
 
-	"github.com/TheLab-ms/profile"
+	"github.com/TheLab-ms/profile/internal/reporting"
+	"github.com/TheLab-ms/profile/internal/urls"
 )
 
 func (s *Server) newSecretIndexHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ciphertext := r.URL.Query().Get("c")
 		if ciphertext == "" {
-			w.Header().Add("Content-Type", "text/html")
-			profile.Templates.ExecuteTemplate(w, "secret-index.html", nil)
+			render(w, r, "secret-index.html", nil)
 			return
 		}
 		// The caller provided ciphertext, decrypt it
@@ -45,12 +45,34 @@ func (s *Server) newSecretIndexHandler() http.HandlerFunc {
 			return
 		}
 
+		access := &reporting.SecretAccess{
+			Time:        time.Now(),
+			Operation:   reporting.SecretDecrypted,
+			Actor:       userID,
+			Description: p.Description,
+			EncryptedBy: p.EncryptedByUser,
+			EncryptedAt: time.Unix(p.EncryptedAt, 0),
+		}
+		if p.Recipient != nil {
+			access.Recipient = *p.Recipient
+		}
+
 		if (p.Recipient == nil && !isLeadership) || (p.Recipient != nil && *p.Recipient != userID) {
 			p.Value = "" // just in case the template somehow leaks the value
+			access.Operation = reporting.SecretDenied
+			if err := reporting.DefaultSink.RecordSecretAccess(r.Context(), access); err != nil {
+				log.Printf("error while recording denied secret access: %s", err)
+			}
 			http.Error(w, "unauthorized!", http.StatusForbidden)
 			return
 		}
 
+		// Secrets aren't revealed without an audit trail
+		if err := reporting.DefaultSink.RecordSecretAccess(r.Context(), access); err != nil {
+			renderSystemError(w, "error while recording secret access: %s", err)
+			return
+		}
+
 		log.Printf("decrypted value %q for user %q originally encrypted by %q", p.Description, userID, p.EncryptedByUser)
 		w.Header().Add("Content-Type", "text/plain")
 		io.WriteString(w, p.Value)
@@ -86,14 +108,49 @@ func (s *Server) newSecretEncryptionHandler() http.HandlerFunc {
 			return
 		}
 
-		w.Header().Add("Content-Type", "text/html")
-		profile.Templates.ExecuteTemplate(w, "secret-encrypted.html", map[string]any{
-			"url":  s.Env.SelfURL + "/secrets?c=" + base64.RawURLEncoding.EncodeToString(ciphertext.Bytes()),
+		err = reporting.DefaultSink.RecordSecretAccess(r.Context(), &reporting.SecretAccess{
+			Time:        time.Unix(p.EncryptedAt, 0),
+			Operation:   reporting.SecretEncrypted,
+			Actor:       userID,
+			Description: p.Description,
+			Recipient:   r.FormValue("recip"),
+		})
+		if err != nil {
+			renderSystemError(w, "error while recording secret encryption: %s", err)
+			return
+		}
+
+		render(w, r, "secret-encrypted.html", map[string]any{
+			"url":  urls.Secret(s.Env, ciphertext.Bytes()),
 			"desc": p.Description,
 		})
 	}
 }
 
+// secretAuditWindow is how far back the secrets audit log page goes.
+const secretAuditWindow = time.Hour * 24 * 90
+
+// newAdminSecretAuditHandler lists who encrypted and decrypted which secrets (but never their values).
+func (s *Server) newAdminSecretAuditHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entries, err := reporting.DefaultSink.ListSecretAccess(r.Context(), time.Now().Add(-secretAuditWindow))
+		if err != nil {
+			renderSystemError(w, "error while listing secret access: %s", err)
+			return
+		}
+		for _, entry := range entries {
+			entry.Time = s.localTime(entry.Time)
+		}
+
+		render(w, r, "admin-secrets.html", map[string]any{
+			"page":    "admin",
+			"entries": entries,
+			"days":    int(secretAuditWindow.Hours() / 24),
+			"enabled": reporting.DefaultSink.Enabled(),
+		})
+	}
+}
+
 type secretPayload struct {
 	EncryptedByUser string  `json:"eb"`
 	EncryptedAt     int64   `json:"ea"` // seconds since unix epoch utc
@@ -10,13 +10,14 @@ import (
 	"github.com/stripe/stripe-go/v78"
 	billingsession "github.com/stripe/stripe-go/v78/billingportal/session"
 	"github.com/stripe/stripe-go/v78/checkout/session"
-	"github.com/stripe/stripe-go/v78/customer"
-	"github.com/stripe/stripe-go/v78/subscription"
 	"github.com/stripe/stripe-go/v78/webhook"
 
 	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/emailtmpl"
+	"github.com/TheLab-ms/profile/internal/notify"
 	"github.com/TheLab-ms/profile/internal/payment"
 	"github.com/TheLab-ms/profile/internal/reporting"
+	"github.com/TheLab-ms/profile/internal/urls"
 )
 
 func (s *Server) newStripeCheckoutHandler() http.HandlerFunc {
@@ -31,7 +32,7 @@ func (s *Server) newStripeCheckoutHandler() http.HandlerFunc {
 		if user.StripeSubscriptionID != "" {
 			sessionParams := &stripe.BillingPortalSessionParams{
 				Customer:  stripe.String(user.StripeCustomerID),
-				ReturnURL: stripe.String(s.Env.SelfURL + "/profile"),
+				ReturnURL: stripe.String(urls.Profile(s.Env)),
 			}
 			sessionParams.Context = r.Context()
 
@@ -45,8 +46,28 @@ func (s *Server) newStripeCheckoutHandler() http.HandlerFunc {
 			return
 		}
 
+		// Only members migrating from Paypal can keep their old rate
 		priceID := r.URL.Query().Get("price")
-		s, err := session.New(payment.NewCheckoutSessionParams(r.Context(), user, s.Env, s.PriceCache, priceID))
+		migrating := user.PaypalMetadata.TransactionID != ""
+		if priceID == "paypal" && !migrating {
+			http.Error(w, "there is no Paypal subscription to migrate", 400)
+			return
+		}
+
+		// Migrating Paypal members are already members, so they aren't subject to the cap
+		if !migrating {
+			ok, err := s.Waitlist.Admitted(r.Context(), user.Email)
+			if err != nil {
+				renderSystemError(w, "error while checking member cap: %s", err)
+				return
+			}
+			if !ok {
+				http.Redirect(w, r, "/profile", http.StatusSeeOther)
+				return
+			}
+		}
+
+		s, err := session.New(payment.NewCheckoutSessionParams(r.Context(), user, s.Env, s.PriceCache, priceID, r.URL.Query()["addon"]))
 		if err != nil {
 			renderSystemError(w, "error while creating session: %s", err)
 			return
@@ -61,12 +82,15 @@ func (s *Server) newStripeWebhookHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		payload, err := io.ReadAll(r.Body)
 		if err != nil {
-			log.Printf("error while reading Stripe webhook body: %s", err)
-			w.WriteHeader(503)
+			webhookFailed(w, 503, "error while reading Stripe webhook body: %s", err)
 			return
 		}
 
-		event, err := webhook.ConstructEvent(payload, r.Header.Get("Stripe-Signature"), s.Env.StripeWebhookKey)
+		construct := webhook.ConstructEvent
+		if isWebhookReplay(r.Context()) {
+			construct = webhook.ConstructEventIgnoringTolerance // the signature is still checked, but it was made when the webhook was first sent
+		}
+		event, err := construct(payload, r.Header.Get("Stripe-Signature"), s.Env.StripeWebhookKey)
 		if err != nil {
 			log.Printf("error while constructing Stripe webhook event: %s", err)
 			w.WriteHeader(400)
@@ -89,25 +113,22 @@ func (s *Server) newStripeWebhookHandler() http.HandlerFunc {
 		}
 
 		subID := event.Data.Object["id"].(string)
-		sub, err := subscription.Get(subID, &stripe.SubscriptionParams{})
+		sub, err := s.Stripe.GetSubscription(r.Context(), subID)
 		if err != nil {
-			log.Printf("unable to get Stripe subscription object: %s", err)
-			w.WriteHeader(500)
+			webhookFailed(w, 500, "unable to get Stripe subscription object: %s", err)
 			return
 		}
 
-		customer, err := customer.Get(sub.Customer.ID, &stripe.CustomerParams{})
+		customer, err := s.Stripe.GetCustomer(r.Context(), sub.Customer.ID)
 		if err != nil {
-			log.Printf("unable to get Stripe customer object: %s", err)
-			w.WriteHeader(500)
+			webhookFailed(w, 500, "unable to get Stripe customer object: %s", err)
 			return
 		}
 		log.Printf("got Stripe subscription event for member %q, state=%s", customer.Email, sub.Status)
 
 		user, err := s.Keycloak.GetUserByEmail(r.Context(), customer.Email)
 		if err != nil {
-			log.Printf("unable to get user by email address: %s", err)
-			w.WriteHeader(500)
+			webhookFailed(w, 500, "unable to get user by email address: %s", err)
 			return
 		}
 
@@ -115,8 +136,7 @@ func (s *Server) newStripeWebhookHandler() http.HandlerFunc {
 		if s.Env.PaypalClientID != "" && s.Env.PaypalClientSecret != "" && user.PaypalMetadata.TransactionID != "" {
 			err := s.Paypal.Cancel(r.Context(), user)
 			if err != nil {
-				log.Printf("unable to get cancel Paypal subscription: %s", err)
-				w.WriteHeader(500)
+				webhookFailed(w, 500, "unable to get cancel Paypal subscription: %s", err)
 				return
 			}
 		}
@@ -124,11 +144,29 @@ func (s *Server) newStripeWebhookHandler() http.HandlerFunc {
 		// No more paypal since they're in Stripe!
 		user.PaypalMetadata = datamodel.PaypalMetadata{}
 
+		user.StripeSubscriptionStatus = string(sub.Status)
 		active := sub.Status == stripe.SubscriptionStatusActive || sub.Status == stripe.SubscriptionStatusTrialing
+
+		// Give past due members some time to sort out their payment before revoking access
+		var notification string
+		var notificationData any
+		if sub.Status == stripe.SubscriptionStatusPastDue && s.Env.StripeGracePeriod > 0 {
+			if !user.StripeGracePeriodEnd.After(time.Unix(0, 0)) {
+				user.StripeGracePeriodEnd = time.Now().Add(s.Env.StripeGracePeriod)
+				reporting.DefaultSink.Eventf(user.Email, "StripeGracePeriodStarted", "The user's subscription is past due - access will be kept until %s", user.StripeGracePeriodEnd.Format(time.RFC3339))
+				notification = notify.ReasonPaymentFailed
+				notificationData = &emailtmpl.PaymentFailed{AccessUntil: s.localTime(user.StripeGracePeriodEnd).Format("Monday, January 2"), URL: urls.Profile(s.Env)}
+			}
+			active = time.Now().Before(user.StripeGracePeriodEnd)
+		} else {
+			user.StripeGracePeriodEnd = time.Time{}
+		}
+
 		if active {
 			user.StripeCustomerID = customer.ID
 			user.StripeSubscriptionID = sub.ID
 			user.StripeCancelationTime = time.Unix(sub.CancelAt, 0)
+			user.StripePromotionCode = "" // applied at checkout
 
 			if customer.ID != user.StripeCustomerID || sub.ID != user.StripeSubscriptionID {
 				reporting.DefaultSink.Eventf(user.Email, "StripeSubscriptionChanged", "A Stripe webhook caused the user's Stripe customer and/or subscription to change")
@@ -140,26 +178,63 @@ func (s *Server) newStripeWebhookHandler() http.HandlerFunc {
 			// onboarding if they rejoin at any point. But just missing a payment shouldn't
 			// cause access to be revoked once payment is provided.
 			if sub.Status == stripe.SubscriptionStatusPastDue {
+				if user.BuildingAccessApprover != "" {
+					notification = notify.ReasonAccessRevoked
+					notificationData = &emailtmpl.AccessRevoked{Reason: "your membership payment is past due", URL: urls.Profile(s.Env)}
+				}
 				user.BuildingAccessApprover = ""
 			}
 
 			// This is reached only once the paid period has been exceeded.
 			// So saving the subscription ID isn't of any use.
 			user.StripeSubscriptionID = ""
+
+			// Lockers are only rented to members
+			if user.LockerNumber != "" {
+				if user.StripeLockerItemID != "" && sub.Status != stripe.SubscriptionStatusCanceled {
+					if err := s.Stripe.RemoveLockerItem(r.Context(), user.StripeLockerItemID); err != nil {
+						log.Printf("error while removing locker from Stripe subscription %s: %s", sub.ID, err)
+					}
+				}
+				reporting.DefaultSink.Eventf(user.Email, "LockerReleased", "locker %s was released because the membership ended", user.LockerNumber)
+				user.LockerNumber = ""
+				user.StripeLockerItemID = ""
+			}
 		}
 
 		err = s.Keycloak.WriteUser(r.Context(), user)
 		if err != nil {
-			log.Printf("error while updating Keycloak for Stripe subscription webhook event: %s", err)
-			w.WriteHeader(500)
+			webhookFailed(w, 500, "error while updating Keycloak for Stripe subscription webhook event: %s", err)
 			return
 		}
 
 		err = s.Keycloak.UpdateGroupMembership(r.Context(), user, active)
 		if err != nil {
-			log.Printf("error while updating Keycloak group membership for Stripe subscription webhook event: %s", err)
-			w.WriteHeader(500)
+			webhookFailed(w, 500, "error while updating Keycloak group membership for Stripe subscription webhook event: %s", err)
 			return
 		}
+
+		if notification != "" {
+			if _, err := s.Notify.Notify(r.Context(), user, notification, notificationData); err != nil {
+				log.Printf("error while notifying member of Stripe subscription change: %s", err)
+			}
+		}
+
+		// The member count changed, and anyone who was invited off the waitlist has claimed their spot
+		s.Waitlist.Kick()
+		if active {
+			if err := reporting.DefaultSink.RemoveFromWaitlist(r.Context(), user.Email); err != nil {
+				log.Printf("error while removing new member from the waitlist: %s", err)
+			}
+		}
+	}
+}
+
+// localTime converts the given time to the space's timezone for display.
+func (s *Server) localTime(t time.Time) time.Time {
+	loc, err := time.LoadLocation(s.Env.SpaceTimezone)
+	if err != nil {
+		return t // the timezone is validated at startup
 	}
+	return t.In(loc)
 }
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v78"
+	"github.com/stripe/stripe-go/v78/webhook"
+
+	"github.com/TheLab-ms/profile/internal/conf"
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/keycloak/keycloaktest"
+	"github.com/TheLab-ms/profile/internal/notify"
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+func TestStripeWebhookTransitions(t *testing.T) {
+	const (
+		groupID       = "members"
+		userID        = "test-user"
+		email         = "member@example.com"
+		webhookSecret = "whsec_test"
+	)
+
+	kcFake := keycloaktest.NewFake(groupID)
+	kcFake.AddUser(&gocloak.User{
+		ID:       gocloak.StringP(userID),
+		Username: gocloak.StringP(email),
+		Email:    gocloak.StringP(email),
+		Attributes: &map[string][]string{
+			"buildingAccessApprover": {"test"},
+			"keyfobID":               {"123"},
+			"lockerNumber":           {"A1"},
+			"stripeLockerItemID":     {"si_locker"},
+		},
+	}, false)
+	kcServer := httptest.NewServer(kcFake)
+	t.Cleanup(kcServer.Close)
+
+	env := &conf.Env{
+		KeycloakURL:            kcServer.URL,
+		KeycloakRealm:          "master",
+		KeycloakMembersGroupID: groupID,
+		KeycloakClientID:       "test",
+		KeycloakClientSecret:   "test",
+		StripeWebhookKey:       webhookSecret,
+	}
+	stripeFake := &fakeStripeClient{
+		customers:     map[string]*stripe.Customer{"cus_test": {ID: "cus_test", Email: email}},
+		subscriptions: map[string]*stripe.Subscription{},
+	}
+	kc := keycloak.New[*datamodel.User](env)
+	kc.Sink = reporting.DefaultSink // disabled, but nil-safe
+	emails := &fakeEmailSender{}
+	s := &Server{Env: env, Keycloak: kc, Stripe: stripeFake, Notify: notify.New(nil, emails)}
+	handler := s.newStripeWebhookHandler()
+
+	getUser := func() *keycloak.ExtendedUser[*datamodel.User] {
+		user, err := s.Keycloak.GetUser(context.Background(), userID)
+		require.NoError(t, err)
+		extended, err := s.Keycloak.ExtendUser(context.Background(), user, userID)
+		require.NoError(t, err)
+		return extended
+	}
+	setStatus := func(status stripe.SubscriptionStatus) {
+		stripeFake.subscriptions["sub_test"] = &stripe.Subscription{ID: "sub_test", Status: status, Customer: &stripe.Customer{ID: "cus_test"}}
+	}
+
+	t.Run("active", func(t *testing.T) {
+		setStatus(stripe.SubscriptionStatusActive)
+		sendSubscriptionEvent(t, handler, webhookSecret, "customer.subscription.created", "sub_test")
+
+		user := getUser()
+		assert.True(t, user.ActiveMember)
+		assert.Equal(t, "cus_test", user.User.StripeCustomerID)
+		assert.Equal(t, "sub_test", user.User.StripeSubscriptionID)
+		assert.Equal(t, "active", user.User.StripeSubscriptionStatus)
+		assert.Equal(t, "A1", user.User.LockerNumber)
+	})
+
+	t.Run("past due within grace period", func(t *testing.T) {
+		env.StripeGracePeriod = time.Hour * 24
+		defer func() { env.StripeGracePeriod = 0 }()
+
+		setStatus(stripe.SubscriptionStatusPastDue)
+		sendSubscriptionEvent(t, handler, webhookSecret, "customer.subscription.updated", "sub_test")
+
+		user := getUser()
+		assert.True(t, user.ActiveMember)
+		assert.Equal(t, "past_due", user.User.StripeSubscriptionStatus)
+		assert.True(t, user.User.StripeGracePeriodEnd.After(time.Now()))
+		assert.Equal(t, "test", user.User.BuildingAccessApprover)
+		assert.Equal(t, []string{notify.ReasonPaymentFailed}, emails.sent)
+	})
+
+	t.Run("past due", func(t *testing.T) {
+		setStatus(stripe.SubscriptionStatusPastDue)
+		sendSubscriptionEvent(t, handler, webhookSecret, "customer.subscription.updated", "sub_test")
+
+		user := getUser()
+		assert.False(t, user.ActiveMember)
+		assert.Equal(t, "past_due", user.User.StripeSubscriptionStatus)
+		assert.Equal(t, "", user.User.BuildingAccessApprover)
+		assert.Equal(t, "", user.User.StripeSubscriptionID)
+		assert.Equal(t, "", user.User.LockerNumber)
+		assert.Equal(t, []string{"si_locker"}, stripeFake.removedItems)
+		assert.Equal(t, []string{notify.ReasonPaymentFailed, notify.ReasonAccessRevoked}, emails.sent)
+	})
+
+	t.Run("reactivated", func(t *testing.T) {
+		setStatus(stripe.SubscriptionStatusActive)
+		sendSubscriptionEvent(t, handler, webhookSecret, "customer.subscription.updated", "sub_test")
+
+		user := getUser()
+		assert.True(t, user.ActiveMember)
+		assert.Equal(t, "sub_test", user.User.StripeSubscriptionID)
+		assert.False(t, user.User.StripeGracePeriodEnd.After(time.Unix(0, 0)))
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		setStatus(stripe.SubscriptionStatusCanceled)
+		sendSubscriptionEvent(t, handler, webhookSecret, "customer.subscription.deleted", "sub_test")
+
+		user := getUser()
+		assert.False(t, user.ActiveMember)
+		assert.Equal(t, "canceled", user.User.StripeSubscriptionStatus)
+		assert.Equal(t, "", user.User.StripeSubscriptionID)
+		assert.Len(t, emails.sent, 2, "canceling doesn't notify")
+	})
+}
+
+// fakeStripeClient serves canned subscriptions and customers, and records removed subscription items.
+type fakeStripeClient struct {
+	subscriptions map[string]*stripe.Subscription
+	customers     map[string]*stripe.Customer
+	removedItems  []string
+}
+
+func (f *fakeStripeClient) GetSubscription(ctx context.Context, id string) (*stripe.Subscription, error) {
+	sub, ok := f.subscriptions[id]
+	if !ok {
+		return nil, fmt.Errorf("subscription %q not found", id)
+	}
+	return sub, nil
+}
+
+func (f *fakeStripeClient) GetCustomer(ctx context.Context, id string) (*stripe.Customer, error) {
+	cust, ok := f.customers[id]
+	if !ok {
+		return nil, fmt.Errorf("customer %q not found", id)
+	}
+	return cust, nil
+}
+
+func (f *fakeStripeClient) RemoveLockerItem(ctx context.Context, itemID string) error {
+	f.removedItems = append(f.removedItems, itemID)
+	return nil
+}
+
+type fakeEmailSender struct {
+	sent []string
+}
+
+func (f *fakeEmailSender) Enabled() bool { return true }
+
+func (f *fakeEmailSender) SendTemplate(ctx context.Context, to, name string, data any) error {
+	f.sent = append(f.sent, name)
+	return nil
+}
+
+// sendSubscriptionEvent delivers a signed webhook in the same shape as Stripe's.
+// The handler only reads the subscription ID from the event and fetches the rest from the API.
+func sendSubscriptionEvent(t *testing.T, handler http.HandlerFunc, secret, eventType, subID string) {
+	payload, err := json.Marshal(map[string]any{
+		"id":          fmt.Sprintf("evt_test_%d", time.Now().UnixNano()),
+		"object":      "event",
+		"type":        eventType,
+		"api_version": stripe.APIVersion,
+		"data":        map[string]any{"object": map[string]any{"id": subID, "object": "subscription"}},
+	})
+	require.NoError(t, err)
+	signed := webhook.GenerateTestSignedPayload(&webhook.UnsignedPayload{Payload: payload, Secret: secret})
+
+	req := httptest.NewRequest("POST", "/webhooks/stripe", bytes.NewReader(payload))
+	req.Header.Set("Stripe-Signature", signed.Header)
+	w := httptest.NewRecorder()
+	handler(w, req)
+	require.Equal(t, 200, w.Code)
+}
+
+func TestStripeCheckoutPaypalPrice(t *testing.T) {
+	kcFake := keycloaktest.NewFake("members")
+	kcFake.AddUser(&gocloak.User{ID: gocloak.StringP("user-1"), Email: gocloak.StringP("new@example.com")}, false)
+	kcServer := httptest.NewServer(kcFake)
+	t.Cleanup(kcServer.Close)
+
+	env := &conf.Env{
+		KeycloakURL:            kcServer.URL,
+		KeycloakRealm:          "master",
+		KeycloakMembersGroupID: "members",
+		KeycloakClientID:       "test",
+		KeycloakClientSecret:   "test",
+	}
+	kc := keycloak.New[*datamodel.User](env)
+	kc.Sink = reporting.DefaultSink
+	s := &Server{Env: env, Keycloak: kc}
+
+	// The Paypal rate can't be used to skip the member cap (or pay nothing) without a Paypal subscription
+	req := httptest.NewRequest("GET", "/profile/stripe?price=paypal", nil)
+	req.Header.Set("X-Forwarded-Preferred-Username", "user-1")
+	w := httptest.NewRecorder()
+	s.newStripeCheckoutHandler()(w, req)
+	assert.Equal(t, 400, w.Code)
+}
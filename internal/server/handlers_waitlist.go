@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/TheLab-ms/profile/internal/emailtmpl"
+	"github.com/TheLab-ms/profile/internal/reporting"
+	"github.com/TheLab-ms/profile/internal/urls"
+)
+
+// waitlistView is the member's waitlist state as shown on their profile.
+type waitlistView struct {
+	Full             bool
+	Position         int // zero if not on the waitlist or already invited
+	Invited          bool
+	InviteExpiration string
+}
+
+func (s *Server) getWaitlistView(ctx context.Context, email string) (*waitlistView, error) {
+	entry, position, err := reporting.DefaultSink.GetWaitlistEntry(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	view := &waitlistView{Full: true}
+	if entry.Invited(time.Now()) {
+		view.Invited = true
+		view.InviteExpiration = entry.InviteExpiresAt.Format("01/02/06 3:04 PM")
+	} else if entry != nil && entry.InvitedAt.IsZero() {
+		view.Position = position
+	}
+	return view, nil
+}
+
+func (s *Server) newWaitlistJoinHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user, err := s.Keycloak.GetUser(r.Context(), getUserID(r))
+		if err != nil {
+			renderSystemError(w, "error while getting user from Keycloak: %s", err)
+			return
+		}
+
+		if err := reporting.DefaultSink.JoinWaitlist(r.Context(), user.Email); err != nil {
+			renderSystemError(w, "error while joining waitlist: %s", err)
+			return
+		}
+
+		reporting.DefaultSink.Eventf(user.Email, "JoinedWaitlist", "joined the membership waitlist")
+		http.Redirect(w, r, "/profile", http.StatusSeeOther)
+	}
+}
+
+func (s *Server) newAdminWaitlistHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			switch r.FormValue("action") {
+			case "release":
+				n, err := strconv.Atoi(r.FormValue("count"))
+				if err != nil || n < 1 {
+					http.Error(w, "invalid count", 400)
+					return
+				}
+				if err := s.releaseWaitlistSlots(r.Context(), n); err != nil {
+					renderSystemError(w, "error while releasing waitlist slots: %s", err)
+					return
+				}
+				log.Printf("%d waitlist slots released by %s", n, getUserID(r))
+
+			case "remove":
+				email := r.FormValue("email")
+				if err := reporting.DefaultSink.RemoveFromWaitlist(r.Context(), email); err != nil {
+					renderSystemError(w, "error while removing waitlist entry: %s", err)
+					return
+				}
+				log.Printf("%s removed from the waitlist by %s", email, getUserID(r))
+
+			default:
+				http.Error(w, "unknown action", 400)
+				return
+			}
+			http.Redirect(w, r, "/admin/waitlist", http.StatusSeeOther)
+			return
+		}
+
+		entries, err := reporting.DefaultSink.ListWaitlist(r.Context())
+		if err != nil {
+			renderSystemError(w, "error while listing waitlist: %s", err)
+			return
+		}
+
+		render(w, r, "admin-waitlist.html", map[string]any{
+			"page":    "admin",
+			"entries": entries,
+			"cap":     s.Env.MemberCap,
+			"full":    s.Waitlist.Full(),
+			"now":     time.Now(),
+		})
+	}
+}
+
+// releaseWaitlistSlots invites the next n people on the waitlist to check out.
+func (s *Server) releaseWaitlistSlots(ctx context.Context, n int) error {
+	expiration := time.Now().Add(s.Env.WaitlistInviteTTL)
+	entries, err := reporting.DefaultSink.InviteFromWaitlist(ctx, n, expiration)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		err := s.Email.SendTemplate(ctx, entry.Email, "waitlistInvitation", &emailtmpl.WaitlistInvitation{
+			URL:        urls.Profile(s.Env),
+			Expiration: expiration.Format("Monday, January 2 at 3:04 PM MST"),
+		})
+		if err != nil {
+			// The invitation is still visible on their profile, so keep going
+			log.Printf("error while sending waitlist invitation to %s: %s", entry.Email, err)
+			continue
+		}
+		reporting.DefaultSink.Eventf(entry.Email, "WaitlistInvited", "invited off the waitlist until %s", expiration.Format(time.RFC3339))
+	}
+	return nil
+}
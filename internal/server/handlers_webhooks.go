@@ -0,0 +1,212 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+// webhookArchiveHeaders are left out of archived webhooks since they may hold credentials.
+var webhookArchiveHeaders = []string{"Authorization", "Cookie"}
+
+type webhookReplayKey struct{}
+
+// isWebhookReplay returns true when the webhook is being replayed from the archive rather than sent by its source.
+func isWebhookReplay(ctx context.Context) bool {
+	replay, _ := ctx.Value(webhookReplayKey{}).(bool)
+	return replay
+}
+
+// webhookRecorder captures the outcome of a webhook handler.
+type webhookRecorder struct {
+	http.ResponseWriter
+	status int
+	err    string
+}
+
+func (w *webhookRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *webhookRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Failed returns true if the handler failed in a way the sender would retry i.e. not because the request was invalid.
+func (w *webhookRecorder) Failed() bool { return w.status >= 500 }
+
+func (w *webhookRecorder) Error() string {
+	if w.err != "" {
+		return w.err
+	}
+	return fmt.Sprintf("handler responded with status %d", w.status)
+}
+
+// webhookFailed logs an error while processing a webhook and responds with the given status.
+// The message is archived along with the webhook (see archiveWebhook).
+func webhookFailed(w http.ResponseWriter, status int, templ string, args ...any) {
+	msg := fmt.Sprintf(templ, args...)
+	log.Print(msg)
+	if rec, ok := w.(*webhookRecorder); ok {
+		rec.err = msg
+	}
+	w.WriteHeader(status)
+}
+
+// archiveWebhook stores webhooks that fail processing in the reporting database so leadership can replay them
+// from /admin/webhook-failures once the problem has been fixed.
+func (s *Server) archiveWebhook(source string, next http.HandlerFunc) http.HandlerFunc {
+	if s.webhooks == nil {
+		s.webhooks = map[string]http.HandlerFunc{}
+	}
+	s.webhooks[source] = next
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		payload, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "error while reading body", http.StatusServiceUnavailable)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(payload))
+
+		rec := &webhookRecorder{ResponseWriter: w}
+		next(rec, r)
+		if !rec.Failed() {
+			return
+		}
+
+		headers := map[string]string{}
+		for key := range r.Header {
+			headers[key] = r.Header.Get(key)
+		}
+		for _, key := range webhookArchiveHeaders {
+			delete(headers, key)
+		}
+		js, _ := json.Marshal(headers)
+
+		id, err := reporting.DefaultSink.RecordWebhookFailure(r.Context(), &reporting.WebhookFailure{
+			Source:     source,
+			ReceivedAt: time.Now(),
+			Headers:    string(js),
+			Payload:    string(payload),
+			Status:     rec.status,
+			Error:      rec.Error(),
+		})
+		if err != nil {
+			log.Printf("error while archiving failed %s webhook: %s", source, err)
+			return
+		}
+		if id > 0 {
+			log.Printf("archived failed %s webhook as %d", source, id)
+		}
+	}
+}
+
+// replayWebhook runs the source's handler against an archived webhook, returning the resulting error if any.
+func (s *Server) replayWebhook(ctx context.Context, f *reporting.WebhookFailure) (int, string) {
+	handler, ok := s.webhooks[f.Source]
+	if !ok {
+		return 0, fmt.Sprintf("no handler is registered for %s webhooks", f.Source)
+	}
+
+	req, err := http.NewRequestWithContext(context.WithValue(ctx, webhookReplayKey{}, true), "POST", "/webhooks/"+f.Source, strings.NewReader(f.Payload))
+	if err != nil {
+		return 0, err.Error()
+	}
+	headers := map[string]string{}
+	if err := json.Unmarshal([]byte(f.Headers), &headers); err != nil {
+		return 0, fmt.Sprintf("invalid archived headers: %s", err)
+	}
+	for key, val := range headers {
+		req.Header.Set(key, val)
+	}
+
+	rec := &webhookRecorder{ResponseWriter: &discardWriter{header: http.Header{}}}
+	handler(rec, req)
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if rec.status >= 400 {
+		return rec.status, rec.Error()
+	}
+	return rec.status, ""
+}
+
+type discardWriter struct{ header http.Header }
+
+func (d *discardWriter) Header() http.Header         { return d.header }
+func (d *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (d *discardWriter) WriteHeader(int)             {}
+
+// webhookFailuresLimit is how many archived webhooks are listed.
+const webhookFailuresLimit = 100
+
+func (s *Server) newAdminWebhookFailuresHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		failures, err := reporting.DefaultSink.ListWebhookFailures(r.Context(), webhookFailuresLimit)
+		if err != nil {
+			renderSystemError(w, "error while listing webhook failures: %s", err)
+			return
+		}
+
+		render(w, r, "admin-webhook-failures.html", map[string]any{
+			"page":     "admin",
+			"failures": failures,
+			"enabled":  reporting.DefaultSink.Enabled(),
+			"replayed": r.URL.Query().Get("replayed"),
+		})
+	}
+}
+
+func (s *Server) newAdminWebhookReplayHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid id", 400)
+			return
+		}
+
+		f, err := reporting.DefaultSink.GetWebhookFailure(r.Context(), id)
+		if err != nil {
+			renderSystemError(w, "error while getting webhook failure: %s", err)
+			return
+		}
+		if f == nil {
+			http.Error(w, "webhook failure not found", 404)
+			return
+		}
+		if !f.ResolvedAt.IsZero() {
+			http.Error(w, "webhook has already been replayed successfully", http.StatusConflict)
+			return
+		}
+
+		actor := r.Header.Get("X-Forwarded-Email")
+		status, errMsg := s.replayWebhook(r.Context(), f)
+		if err := reporting.DefaultSink.RecordWebhookReplay(r.Context(), f.ID, actor, status, errMsg, time.Now()); err != nil {
+			renderSystemError(w, "error while recording webhook replay: %s", err)
+			return
+		}
+		log.Printf("%s webhook %d was replayed by %s (status=%d)", f.Source, f.ID, actor, status)
+
+		http.Redirect(w, r, fmt.Sprintf("/admin/webhook-failures?replayed=%d", f.ID), http.StatusSeeOther)
+	}
+}
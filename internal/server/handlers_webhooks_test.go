@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+func TestWebhookReplay(t *testing.T) {
+	s := &Server{}
+	var bodies []string
+	var replays []bool
+	handler := s.archiveWebhook("test", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		replays = append(replays, isWebhookReplay(r.Context()))
+		if r.Header.Get("X-Fail") != "" {
+			webhookFailed(w, 500, "error while processing: %s", "boom")
+		}
+	})
+
+	// The archive is disabled, but the handler still sees the body and responds
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", "/webhooks/test", strings.NewReader("payload")))
+	assert.Equal(t, 200, w.Code)
+
+	status, errMsg := s.replayWebhook(context.Background(), &reporting.WebhookFailure{Source: "test", Payload: "replayed", Headers: `{"X-Fail":"1"}`})
+	assert.Equal(t, 500, status)
+	assert.Equal(t, "error while processing: boom", errMsg)
+
+	status, errMsg = s.replayWebhook(context.Background(), &reporting.WebhookFailure{Source: "test", Payload: "replayed", Headers: `{}`})
+	assert.Equal(t, 200, status)
+	assert.Empty(t, errMsg)
+
+	require.Equal(t, []string{"payload", "replayed", "replayed"}, bodies)
+	assert.Equal(t, []bool{false, true, true}, replays)
+
+	_, errMsg = s.replayWebhook(context.Background(), &reporting.WebhookFailure{Source: "unknown"})
+	assert.Equal(t, "no handler is registered for unknown webhooks", errMsg)
+}
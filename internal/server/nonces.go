@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+// sharedNonceSet remembers single-use values in the reporting db so every replica rejects them, falling back to
+// process memory when reporting is disabled.
+type sharedNonceSet struct {
+	scope string
+	local *nonceSet
+}
+
+func newSharedNonceSet(scope string) *sharedNonceSet {
+	return &sharedNonceSet{scope: scope, local: newNonceSet()}
+}
+
+// Use returns false if the nonce has already been used.
+func (n *sharedNonceSet) Use(ctx context.Context, nonce string, expiration time.Time) (bool, error) {
+	if !reporting.DefaultSink.Enabled() {
+		return n.local.Use(nonce, expiration), nil
+	}
+	return reporting.DefaultSink.UseNonce(ctx, n.scope, nonce, expiration, time.Now())
+}
+
+// nonceSet remembers single-use values until they expire.
+type nonceSet struct {
+	mut   sync.Mutex
+	items map[string]time.Time
+}
+
+func newNonceSet() *nonceSet {
+	return &nonceSet{items: map[string]time.Time{}}
+}
+
+// Use returns false if the nonce has already been used.
+// Nonces only need to be remembered until the signed value they're part of expires.
+func (n *nonceSet) Use(nonce string, expiration time.Time) bool {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+
+	now := time.Now()
+	for key, exp := range n.items {
+		if now.After(exp) {
+			delete(n.items, key)
+		}
+	}
+
+	if _, ok := n.items[nonce]; ok {
+		return false
+	}
+	n.items[nonce] = expiration
+	return true
+}
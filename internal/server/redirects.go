@@ -0,0 +1,49 @@
+package server
+
+import (
+	"log"
+	"net/url"
+	"strings"
+)
+
+// safeReturnURL validates a caller-provided URL before redirecting to it, returning fallback if it could send the user
+// somewhere unexpected i.e. an open redirect. Paths on this server are always allowed, as are absolute http(s) URLs
+// on SelfURL's host or one of RedirectAllowedHosts.
+func (s *Server) safeReturnURL(target, fallback string) string {
+	if target == "" {
+		return fallback
+	}
+	if !s.allowReturnURL(target) {
+		log.Printf("refusing to redirect to untrusted return URL %q", target)
+		return fallback
+	}
+	return target
+}
+
+func (s *Server) allowReturnURL(target string) bool {
+	// Browsers treat backslashes like slashes, so "/\evil.com" is protocol-relative
+	if strings.ContainsAny(target, "\\\r\n\t") {
+		return false
+	}
+	u, err := url.Parse(target)
+	if err != nil || u.User != nil {
+		return false
+	}
+	if u.Scheme == "" && u.Host == "" {
+		return strings.HasPrefix(u.Path, "/") && !strings.HasPrefix(target, "//")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+
+	host := strings.ToLower(u.Host)
+	if self, err := url.Parse(s.Env.SelfURL); err == nil && host == strings.ToLower(self.Host) {
+		return true
+	}
+	for _, allowed := range s.Env.RedirectAllowedHosts {
+		if host == strings.ToLower(allowed) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TheLab-ms/profile/internal/conf"
+)
+
+func TestSafeReturnURL(t *testing.T) {
+	s := &Server{Env: &conf.Env{SelfURL: "https://profile.example.com", RedirectAllowedHosts: []string{"wiki.example.com"}}}
+
+	for _, target := range []string{
+		"/profile",
+		"/profile/notifications?saved=true",
+		"https://profile.example.com/profile",
+		"https://PROFILE.example.com/admin",
+		"https://wiki.example.com/Tools",
+	} {
+		assert.Equal(t, target, s.safeReturnURL(target, "/fallback"), target)
+	}
+
+	for _, target := range []string{
+		"",
+		"profile",
+		"//evil.com",
+		"/\\evil.com",
+		"https://evil.com",
+		"https://profile.example.com.evil.com",
+		"https://wiki.example.com@evil.com",
+		"javascript:alert(1)",
+		"ftp://profile.example.com",
+	} {
+		assert.Equal(t, "/fallback", s.safeReturnURL(target, "/fallback"), target)
+	}
+}
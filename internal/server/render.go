@@ -0,0 +1,84 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/TheLab-ms/profile"
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+// degradedRetryInterval is how often the waiting room page reloads itself.
+const degradedRetryInterval = 30
+
+var renderErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "profile_template_render_errors_total",
+	Help: "Count of errors while rendering page templates",
+}, []string{"template"})
+
+type requestIDKey struct{}
+
+// withRequestID tags each request with an ID so errors can be correlated with what the user saw.
+// IDs set upstream (i.e. by the ingress) are preserved.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-Id")
+		if id == "" {
+			buf := make([]byte, 8)
+			rand.Read(buf)
+			id = hex.EncodeToString(buf)
+		}
+		w.Header().Set("X-Request-Id", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+func getRequestID(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// render executes the named template into a buffer so a failure results in an error page instead of half of a page.
+func render(w http.ResponseWriter, r *http.Request, name string, data any) {
+	renderStatus(w, r, http.StatusOK, name, data)
+}
+
+func renderStatus(w http.ResponseWriter, r *http.Request, status int, name string, data any) {
+	buf := &bytes.Buffer{}
+	if err := profile.Templates.ExecuteTemplate(buf, name, data); err != nil {
+		renderErrors.WithLabelValues(name).Inc()
+		log.Printf("error while rendering template %s (request %s): %s", name, getRequestID(r), err)
+		http.Error(w, fmt.Sprintf("system error (request ID: %s)", getRequestID(r)), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// renderDegraded shows the waiting room page while Keycloak is unavailable. The page reloads itself until the site
+// is back, and includes the incident banner set by leadership (if any). The request may be nil.
+func renderDegraded(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	banner, err := reporting.DefaultSink.GetIncidentBanner(ctx)
+	if err != nil {
+		log.Printf("error while getting incident banner: %s", err)
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(degradedRetryInterval))
+	renderStatus(w, r, http.StatusServiceUnavailable, "maintenance.html", map[string]any{
+		"page":          "maintenance",
+		"banner":        banner,
+		"retryInterval": degradedRetryInterval,
+	})
+}
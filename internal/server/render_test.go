@@ -0,0 +1,46 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TheLab-ms/profile/internal/keycloak"
+)
+
+func TestRender(t *testing.T) {
+	handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		render(w, r, r.URL.Query().Get("t"), map[string]any{"page": "signup"})
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/?t=signup.html", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), "<html")
+	assert.NotEmpty(t, w.Header().Get("X-Request-Id"))
+
+	// Failures shouldn't leave a partial page behind
+	req := httptest.NewRequest("GET", "/?t=nope.html", nil)
+	req.Header.Set("X-Request-Id", "test-request")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 500, w.Code)
+	assert.Equal(t, "system error (request ID: test-request)\n", w.Body.String())
+}
+
+func TestRenderSystemErrorDegraded(t *testing.T) {
+	w := httptest.NewRecorder()
+	renderSystemError(w, "error while getting user: %s", fmt.Errorf("getting token: %w", keycloak.ErrUnavailable))
+	assert.Equal(t, 503, w.Code)
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), "Be Right Back")
+	assert.Contains(t, w.Body.String(), `<meta http-equiv="refresh" content="30">`)
+
+	// Other errors aren't expected to resolve themselves
+	w = httptest.NewRecorder()
+	renderSystemError(w, "error while getting user: %s", fmt.Errorf("something else"))
+	assert.Equal(t, 500, w.Code)
+}
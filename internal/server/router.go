@@ -1,26 +1,50 @@
 package server
 
 import (
+	"context"
+	"crypto/subtle"
+	"errors"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/TheLab-ms/profile"
+	"github.com/TheLab-ms/profile/internal/access"
 	"github.com/TheLab-ms/profile/internal/conf"
 	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/email"
 	"github.com/TheLab-ms/profile/internal/events"
+	"github.com/TheLab-ms/profile/internal/flags"
+	"github.com/TheLab-ms/profile/internal/jobs"
 	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/notify"
 	"github.com/TheLab-ms/profile/internal/payment"
 	"github.com/TheLab-ms/profile/internal/paypal"
+	"github.com/TheLab-ms/profile/internal/ratelimit"
+	"github.com/TheLab-ms/profile/internal/reporting"
+	"github.com/TheLab-ms/profile/internal/waitlist"
 )
 
 type Server struct {
 	Env         *conf.Env
 	Keycloak    *keycloak.Keycloak[*datamodel.User]
 	Paypal      *paypal.Client
+	Stripe      payment.StripeClient
 	PriceCache  *payment.PriceCache
+	Balances    *payment.BalanceCache
 	EventsCache *events.EventCache
+	Email       *email.Sender
+	Flags       *flags.Flags
+	Access      *access.Cache
+	Waitlist    *waitlist.Gate
+	Notify      *notify.Notifier
+	Jobs        *jobs.Runner // optional
+
+	webhooks  map[string]http.HandlerFunc // by source, for replaying archived failures
+	occupancy occupancyCache
 }
 
 func (s *Server) NewHandler() http.Handler {
@@ -30,23 +54,159 @@ func (s *Server) NewHandler() http.Handler {
 	})
 	mux.HandleFunc("/signup", s.newSignupViewHandler())
 	mux.HandleFunc("/signup/register", s.newRegistrationFormHandler())
+	mux.HandleFunc("/signup/resend", s.newSignupResendHandler())
 	mux.HandleFunc("/profile", s.newProfileViewHandler())
+	mux.HandleFunc("/profile.json", s.newProfileJSONHandler())
 	mux.HandleFunc("/profile/contact", s.newContactInfoFormHandler())
+	mux.HandleFunc("/profile/contact/request-change", s.newContactChangeRequestHandler())
 	mux.HandleFunc("/profile/stripe", s.newStripeCheckoutHandler())
+	mux.HandleFunc("/profile/waitlist", s.newWaitlistJoinHandler())
+	mux.HandleFunc("/profile/notifications", s.newNotificationPreferencesHandler())
+	mux.HandleFunc("/profile/redeem", s.newRedeemHandler())
 	mux.HandleFunc("/docuseal", s.newDocusealRedirectHandler())
 	mux.HandleFunc("/fobqr", s.newFobQRHandler())
 	mux.HandleFunc("/secrets", s.newSecretIndexHandler())
 	mux.HandleFunc("/secrets/encrypt", s.newSecretEncryptionHandler())
 	mux.HandleFunc("/link-discord", s.newDiscordLinkHandler())
-	mux.HandleFunc("/webhooks/docuseal", s.newDocusealWebhookHandler())
-	mux.HandleFunc("/webhooks/stripe", s.newStripeWebhookHandler())
+	mux.HandleFunc("/webhooks/docuseal", s.limitWebhook("docuseal", s.newDocusealWebhookHandler()))
+	mux.HandleFunc("/webhooks/stripe", s.limitWebhook("stripe", s.newStripeWebhookHandler()))
 	mux.HandleFunc("/admin/dump", onlyLeadership(s.newAdminDumpHandler()))
 	mux.HandleFunc("/admin/assign-fob", onlyLeadership(s.newAssignFobHandler()))
+	mux.HandleFunc("/admin/prices", onlyLeadership(s.newAdminPricesHandler()))
+	mux.HandleFunc("/admin/coupons", onlyLeadership(s.newAdminCouponsHandler()))
+	mux.HandleFunc("/admin/redemption-codes", onlyLeadership(s.newAdminRedemptionCodesHandler()))
+	mux.HandleFunc("/admin/member", onlyLeadership(s.newAdminMemberHandler()))
+	mux.HandleFunc("/admin/locker", onlyLeadership(s.newAdminLockerHandler()))
+	mux.HandleFunc("/admin/discount", onlyLeadership(s.newAdminDiscountHandler()))
+	mux.HandleFunc("/admin/certifications", onlyLeadership(s.newAdminCertificationsHandler()))
+	mux.HandleFunc("/admin/tier", onlyLeadership(s.newAdminTierHandler()))
+	mux.HandleFunc("/admin/ban", onlyLeadership(s.newAdminBanHandler()))
+	mux.HandleFunc("/admin/actions/confirm", onlyLeadership(s.newAdminActionConfirmHandler()))
+	mux.HandleFunc("/admin/actions/undo", onlyLeadership(s.newAdminActionUndoHandler()))
+	mux.HandleFunc("/admin/waitlist", onlyLeadership(s.newAdminWaitlistHandler()))
+	mux.HandleFunc("/admin/flags", onlyLeadership(s.newAdminFlagsHandler()))
+	mux.HandleFunc("/admin/funnel", onlyLeadership(s.newAdminFunnelHandler()))
+	mux.HandleFunc("/admin/quarantine", onlyLeadership(s.newAdminQuarantineHandler()))
+	mux.HandleFunc("/admin/pending", onlyLeadership(s.newAdminPendingHandler()))
+	mux.HandleFunc("/admin/secrets", onlyLeadership(s.newAdminSecretAuditHandler()))
+	mux.HandleFunc("/admin/view-as", onlyLeadership(s.newAdminViewAsHandler()))
+	mux.HandleFunc("/admin/waiver/", onlyLeadership(s.newAdminWaiverHandler()))
+	mux.HandleFunc("/admin/email-preview", onlyLeadership(s.newAdminEmailPreviewHandler()))
+	mux.HandleFunc("/admin/webhook-failures", onlyLeadership(s.newAdminWebhookFailuresHandler()))
+	mux.HandleFunc("/admin/webhook-failures/replay", onlyLeadership(s.newAdminWebhookReplayHandler()))
+	mux.HandleFunc("/admin/incident", onlyLeadership(s.newAdminIncidentHandler()))
+	if s.Jobs != nil {
+		mux.HandleFunc("/admin/run-job", onlyLeadership(s.newAdminRunJobHandler()))
+	}
+	mux.HandleFunc("/frontdesk", onlyFrontDesk(s.newFrontDeskHandler()))
+	mux.HandleFunc("/frontdesk/checkin", onlyFrontDesk(s.newFrontDeskCheckInHandler()))
+	mux.HandleFunc("/frontdesk/waiver", onlyFrontDesk(s.newFrontDeskWaiverHandler()))
+	mux.HandleFunc("/frontdesk/daypass", onlyFrontDesk(s.newFrontDeskDayPassHandler()))
+	mux.HandleFunc("/calendar", s.newCalendarHandler())
+	mux.HandleFunc("/calendar.ics", s.newCalendarFeedHandler())
 	mux.HandleFunc("/api/events", s.newListEventsHandler())
 	mux.HandleFunc("/api/prices", s.newPricingHandler())
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {})
 	mux.Handle("/assets/", http.FileServer(http.FS(profile.Assets)))
-	return mux
+	if s.Env.AccessControllerToken != "" {
+		mux.HandleFunc("/api/v1/access", s.onlyAccessControllers(s.newAccessCheckHandler()))
+		mux.HandleFunc("/api/v1/access/allowlist", s.onlyAccessControllers(s.newAllowlistHandler()))
+		mux.HandleFunc("/api/v1/access/allowlist/delta", s.onlyAccessControllers(s.newAllowlistHandler()))
+		if s.Env.DoorPINKey != "" {
+			mux.HandleFunc("/api/v1/access/pin", s.onlyAccessControllers(s.newPINAccessHandler()))
+			mux.HandleFunc("/profile/door-pin", s.newDoorPINFormHandler())
+		}
+		if s.Env.FobLookupAPIToken != "" {
+			mux.HandleFunc("/api/v1/fobs/resolve", requireToken(s.Env.FobLookupAPIToken, s.newFobResolveHandler()))
+		}
+	}
+	if s.Env.KeycloakWebhookSecret != "" {
+		mux.HandleFunc("/webhooks/keycloak", s.limitWebhook("keycloak", keycloak.NewWebhookHandler(s.Env.KeycloakWebhookSecret, func(userID string) bool {
+			s.Keycloak.InvalidateMembership(userID)
+			s.Keycloak.InvalidateUser(userID)
+			s.Access.InvalidateUser(userID)
+			return true
+		}).ServeHTTP))
+	}
+	if s.Env.DocusealURL != "" && s.Env.GuestDailyLimit > 0 {
+		mux.HandleFunc("/guest", s.newGuestFormHandler())
+		mux.HandleFunc("/profile/guests/confirm", s.newGuestConfirmationHandler())
+		mux.HandleFunc("/admin/guests", onlyLeadership(s.newAdminGuestsHandler()))
+		mux.HandleFunc("/admin/guests/qr.png", onlyLeadership(s.newGuestQRHandler()))
+	}
+	if s.Env.EventCheckInKey != "" {
+		mux.HandleFunc("/events/checkin", s.newEventCheckInHandler())
+		mux.HandleFunc("/admin/attendance", onlyLeadership(s.newAdminAttendanceHandler()))
+		mux.HandleFunc("/admin/attendance/qr.png", onlyLeadership(s.newEventCheckInQRHandler()))
+	}
+	if s.Env.OccupancyWindow > 0 {
+		mux.HandleFunc("/api/occupancy", s.newOccupancyHandler())
+	}
+	if s.Env.DeviceHeartbeatToken != "" {
+		mux.HandleFunc("/webhooks/device-heartbeat", requireToken(s.Env.DeviceHeartbeatToken, s.newDeviceHeartbeatHandler()))
+		mux.HandleFunc("/admin/devices", onlyLeadership(s.newAdminDevicesHandler()))
+	}
+	if s.Env.EmailWebhookSecret != "" {
+		mux.HandleFunc("/webhooks/email", s.limitWebhook("email", s.newEmailWebhookHandler()))
+	}
+	if s.Env.NewsletterWebhookSecret != "" {
+		mux.HandleFunc("/webhooks/newsletter", s.limitWebhook("newsletter", s.newNewsletterWebhookHandler()))
+	}
+	if s.Env.EmergencyAPIToken != "" {
+		mux.HandleFunc("/api/v1/emergency", requireToken(s.Env.EmergencyAPIToken, s.newEmergencyContactHandler()))
+	}
+	if s.Env.EntitlementsAPIToken != "" {
+		mux.HandleFunc("/api/v1/entitlements", requireToken(s.Env.EntitlementsAPIToken, s.newEntitlementsHandler()))
+		mux.HandleFunc("/verify/", s.newProofVerificationHandler())
+		mux.HandleFunc("/profile/proof.pdf", s.newProofLetterHandler())
+	}
+	if s.Env.MagicLinkSigningKey != "" {
+		mux.HandleFunc("/login", s.newMagicLinkFormHandler())
+		mux.HandleFunc("/login/verify", s.newMagicLinkVerificationHandler())
+	}
+	return withRequestID(s.withMagicLinkSession(withActor(s.withKeycloakBreaker(mux))))
+}
+
+// withActor attributes changes made while handling the request to the signed in user, or the path for webhooks etc.
+func withActor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor := r.Header.Get("X-Forwarded-Email")
+		if actor == "" {
+			actor = r.Header.Get("X-Forwarded-Preferred-Username")
+		}
+		if actor == "" {
+			actor = "profile-server " + r.URL.Path
+		}
+		next.ServeHTTP(w, r.WithContext(keycloak.WithActor(r.Context(), actor)))
+	})
+}
+
+// keycloakPaths are the path prefixes that can't be served without Keycloak.
+// Notably the door controller APIs are served from a cache and keep working during an outage.
+var keycloakPaths = []string{"/profile", "/signup", "/admin", "/frontdesk", "/login", "/link-discord", "/docuseal", "/fobqr", "/guest", "/webhooks/"}
+
+// withKeycloakBreaker fails fast with a maintenance page while Keycloak is down instead of waiting for each call to time out.
+// The incident banner can still be edited, since it's how leadership tells members what's going on. Device heartbeats
+// don't involve Keycloak, and devices going silent during an outage is worth knowing about.
+func (s *Server) withKeycloakBreaker(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.Keycloak.Unavailable() || r.URL.Path == "/admin/incident" || r.URL.Path == "/webhooks/device-heartbeat" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for _, prefix := range keycloakPaths {
+			if !strings.HasPrefix(r.URL.Path, prefix) {
+				continue
+			}
+			if strings.HasPrefix(r.URL.Path, "/webhooks/") {
+				http.Error(w, "keycloak is unavailable", http.StatusServiceUnavailable) // senders will retry
+				return
+			}
+			renderDegraded(r.Context(), w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
 func onlyLeadership(next http.HandlerFunc) http.HandlerFunc {
@@ -59,6 +219,39 @@ func onlyLeadership(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// onlyFrontDesk allows front desk volunteers in addition to leadership.
+func onlyFrontDesk(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		groups := r.Header.Get("X-Forwarded-Groups")
+		if !strings.Contains(groups, "frontdesk") && !strings.Contains(groups, "leadership") {
+			http.Error(w, "unauthorized", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// limitWebhook limits the rate of webhook requests across all replicas, and archives the ones that fail.
+func (s *Server) limitWebhook(name string, next http.HandlerFunc) http.HandlerFunc {
+	return ratelimit.New(reporting.DefaultSink, "webhook-"+name, s.Env.WebhookRateLimit, time.Minute).Wrap(s.archiveWebhook(name, next))
+}
+
+// onlyAccessControllers authenticates door controllers using a shared bearer token.
+func (s *Server) onlyAccessControllers(next http.HandlerFunc) http.HandlerFunc {
+	return requireToken(s.Env.AccessControllerToken, next)
+}
+
+func requireToken(expected string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
 // getUserID allows the oauth2proxy header to be overridden for testing.
 func getUserID(r *http.Request) string {
 	user := r.Header.Get("X-Forwarded-Preferred-Username")
@@ -68,7 +261,29 @@ func getUserID(r *http.Request) string {
 	return user
 }
 
+// clientIP returns the address of whoever made the request. The last X-Forwarded-For entry is used since it was added
+// by our ingress - the ones before it are set by the client and can't be trusted.
+func clientIP(r *http.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		parts := strings.Split(fwd, ",")
+		return strings.TrimSpace(parts[len(parts)-1])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// renderSystemError logs the error and shows a generic error page, or the waiting room page if it was caused by Keycloak
+// being unavailable since that's likely to resolve itself.
 func renderSystemError(w http.ResponseWriter, msg string, args ...any) {
 	log.Printf(msg, args...)
+	for _, arg := range args {
+		if err, ok := arg.(error); ok && errors.Is(err, keycloak.ErrUnavailable) {
+			renderDegraded(context.Background(), w, nil)
+			return
+		}
+	}
 	http.Error(w, "system error", 500)
 }
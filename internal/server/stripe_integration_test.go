@@ -0,0 +1,183 @@
+//go:build integration
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v78"
+	"github.com/stripe/stripe-go/v78/customer"
+	"github.com/stripe/stripe-go/v78/paymentmethod"
+	"github.com/stripe/stripe-go/v78/price"
+	"github.com/stripe/stripe-go/v78/subscription"
+	"github.com/stripe/stripe-go/v78/testhelpers/testclock"
+
+	"github.com/TheLab-ms/profile/internal/conf"
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/keycloak/keycloaktest"
+	"github.com/TheLab-ms/profile/internal/payment"
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+// TestStripeBillingLifecycle uses a Stripe test clock to walk a subscription through several simulated months,
+// asserting that the webhook handler keeps Keycloak in sync at each step.
+//
+//	STRIPE_TEST_KEY=sk_test_... go test -tags integration -run TestStripeBillingLifecycle ./internal/server
+//
+// Webhooks aren't actually delivered by Stripe - the test signs and sends them to the handler itself.
+func TestStripeBillingLifecycle(t *testing.T) {
+	key := os.Getenv("STRIPE_TEST_KEY")
+	if key == "" {
+		t.Skip("STRIPE_TEST_KEY is not set")
+	}
+	require.Contains(t, key, "_test_", "refusing to run against a live Stripe account")
+	stripe.Key = key
+
+	const (
+		groupID       = "members"
+		userID        = "test-user"
+		webhookSecret = "whsec_integration"
+	)
+	email := fmt.Sprintf("integration-%d@example.com", time.Now().UnixNano())
+
+	// Fake Keycloak
+	kcFake := keycloaktest.NewFake(groupID)
+	kcFake.AddUser(&gocloak.User{
+		ID:         gocloak.StringP(userID),
+		Username:   gocloak.StringP(email),
+		Email:      gocloak.StringP(email),
+		Attributes: &map[string][]string{"buildingAccessApprover": {"integration-test"}, "keyfobID": {"123"}},
+	}, false)
+	kcServer := httptest.NewServer(kcFake)
+	t.Cleanup(kcServer.Close)
+
+	env := &conf.Env{
+		KeycloakURL:            kcServer.URL,
+		KeycloakRealm:          "master",
+		KeycloakMembersGroupID: groupID,
+		KeycloakClientID:       "test",
+		KeycloakClientSecret:   "test",
+		StripeWebhookKey:       webhookSecret,
+	}
+	kc := keycloak.New[*datamodel.User](env)
+	kc.Sink = reporting.DefaultSink // disabled, but nil-safe
+	s := &Server{Env: env, Keycloak: kc, Stripe: payment.NewStripeClient()}
+	handler := s.newStripeWebhookHandler()
+
+	// Stripe fixtures - deleting the clock also deletes the customer and subscription
+	clock, err := testclock.New(&stripe.TestHelpersTestClockParams{
+		FrozenTime: stripe.Int64(time.Now().Unix()),
+		Name:       stripe.String("profile integration test"),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { testclock.Del(clock.ID, nil) })
+	now := time.Unix(clock.FrozenTime, 0)
+
+	cust, err := customer.New(&stripe.CustomerParams{Email: &email, TestClock: &clock.ID})
+	require.NoError(t, err)
+	setDefaultPaymentMethod(t, cust.ID, "pm_card_visa")
+
+	p, err := price.New(&stripe.PriceParams{
+		Currency:    stripe.String(string(stripe.CurrencyUSD)),
+		UnitAmount:  stripe.Int64(5000),
+		Recurring:   &stripe.PriceRecurringParams{Interval: stripe.String(string(stripe.PriceRecurringIntervalMonth))},
+		ProductData: &stripe.PriceProductDataParams{Name: stripe.String("Integration Test Membership")},
+	})
+	require.NoError(t, err)
+
+	sub, err := subscription.New(&stripe.SubscriptionParams{
+		Customer: &cust.ID,
+		Items:    []*stripe.SubscriptionItemsParams{{Price: &p.ID}},
+	})
+	require.NoError(t, err)
+
+	getUser := func() *keycloak.ExtendedUser[*datamodel.User] {
+		user, err := s.Keycloak.GetUser(context.Background(), userID)
+		require.NoError(t, err)
+		extended, err := s.Keycloak.ExtendUser(context.Background(), user, userID)
+		require.NoError(t, err)
+		return extended
+	}
+
+	t.Run("created", func(t *testing.T) {
+		sendSubscriptionEvent(t, handler, webhookSecret, "customer.subscription.created", sub.ID)
+		user := getUser()
+		assert.True(t, user.ActiveMember)
+		assert.Equal(t, cust.ID, user.User.StripeCustomerID)
+		assert.Equal(t, sub.ID, user.User.StripeSubscriptionID)
+		assert.Equal(t, "active", user.User.StripeSubscriptionStatus)
+	})
+
+	t.Run("renewed", func(t *testing.T) {
+		now = now.AddDate(0, 1, 1)
+		advanceClock(t, clock.ID, now)
+		waitForStatus(t, sub.ID, stripe.SubscriptionStatusActive)
+
+		sendSubscriptionEvent(t, handler, webhookSecret, "customer.subscription.updated", sub.ID)
+		user := getUser()
+		assert.True(t, user.ActiveMember)
+		assert.Equal(t, sub.ID, user.User.StripeSubscriptionID)
+	})
+
+	t.Run("payment failed", func(t *testing.T) {
+		setDefaultPaymentMethod(t, cust.ID, "pm_card_chargeCustomerFail")
+		now = now.AddDate(0, 1, 1)
+		advanceClock(t, clock.ID, now)
+		waitForStatus(t, sub.ID, stripe.SubscriptionStatusPastDue)
+
+		sendSubscriptionEvent(t, handler, webhookSecret, "customer.subscription.updated", sub.ID)
+		user := getUser()
+		assert.False(t, user.ActiveMember)
+		assert.Equal(t, "", user.User.BuildingAccessApprover)
+		assert.Equal(t, "past_due", user.User.StripeSubscriptionStatus)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		_, err := subscription.Cancel(sub.ID, nil)
+		require.NoError(t, err)
+
+		sendSubscriptionEvent(t, handler, webhookSecret, "customer.subscription.deleted", sub.ID)
+		user := getUser()
+		assert.False(t, user.ActiveMember)
+		assert.Equal(t, "", user.User.StripeSubscriptionID)
+		assert.Equal(t, "canceled", user.User.StripeSubscriptionStatus)
+	})
+}
+
+func setDefaultPaymentMethod(t *testing.T, customerID, testPaymentMethod string) {
+	pm, err := paymentmethod.Attach(testPaymentMethod, &stripe.PaymentMethodAttachParams{Customer: &customerID})
+	require.NoError(t, err)
+	_, err = customer.Update(customerID, &stripe.CustomerParams{
+		InvoiceSettings: &stripe.CustomerInvoiceSettingsParams{DefaultPaymentMethod: &pm.ID},
+	})
+	require.NoError(t, err)
+}
+
+func advanceClock(t *testing.T, clockID string, to time.Time) {
+	_, err := testclock.Advance(clockID, &stripe.TestHelpersTestClockAdvanceParams{FrozenTime: stripe.Int64(to.Unix())})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		clock, err := testclock.Get(clockID, nil)
+		require.NoError(t, err)
+		require.NotEqual(t, stripe.TestHelpersTestClockStatusInternalFailure, clock.Status)
+		return clock.Status == stripe.TestHelpersTestClockStatusReady
+	}, time.Minute*2, time.Second)
+}
+
+func waitForStatus(t *testing.T, subID string, status stripe.SubscriptionStatus) {
+	require.Eventually(t, func() bool {
+		sub, err := subscription.Get(subID, nil)
+		require.NoError(t, err)
+		return sub.Status == status
+	}, time.Minute, time.Second)
+}
@@ -1,51 +1,132 @@
 package server
 
 import (
+	"context"
+	"crypto/hmac"
 	"fmt"
-	"io"
+	"log"
 	"net/http"
 	"strconv"
 	"time"
 
-	"github.com/TheLab-ms/profile"
 	"github.com/TheLab-ms/profile/internal/chatbot"
 	"github.com/TheLab-ms/profile/internal/datamodel"
 	"github.com/TheLab-ms/profile/internal/payment"
 	"github.com/TheLab-ms/profile/internal/reporting"
+	"github.com/TheLab-ms/profile/internal/urls"
 	qrcode "github.com/skip2/go-qrcode"
 )
 
 func (s *Server) newSignupViewHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		profile.Templates.ExecuteTemplate(w, "signup.html", map[string]any{"page": "signup"})
+		reporting.DefaultSink.Eventf("", "SignupFormViewed", "signup form was viewed")
+		render(w, r, "signup.html", map[string]any{"page": "signup", "newsletter": s.Env.NewsletterAPIKey != ""})
 	}
 }
 
 func (s *Server) newProfileViewHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		user, err := s.Keycloak.GetUser(r.Context(), getUserID(r))
+		user, err := s.Keycloak.GetUserCached(r.Context(), getUserID(r))
 		if err != nil {
 			renderSystemError(w, "error while fetching user: %s", err)
 			return
 		}
 
-		prices := payment.CalculateDiscounts(user, s.PriceCache.GetPrices())
-		renderProfile(w, user, prices)
+		viewData, err := s.getProfileViewData(r.Context(), user)
+		if err != nil {
+			renderSystemError(w, "%s", err)
+			return
+		}
+		render(w, r, "profile.html", viewData)
+	}
+}
+
+// getProfileViewData returns everything needed to render the user's profile page.
+func (s *Server) getProfileViewData(ctx context.Context, user *datamodel.User) (map[string]any, error) {
+	// The balance is nice to have - don't break the page if Stripe is having a bad day
+	var balance int64
+	var err error
+	if user.StripeCustomerID != "" {
+		balance, err = s.Balances.Get(ctx, user.StripeCustomerID)
+		if err != nil {
+			log.Printf("error while getting Stripe balance for customer %s: %s", user.StripeCustomerID, err)
+		}
+	}
+
+	var wl *waitlistView
+	if user.StripeSubscriptionID == "" && s.Waitlist.Full() {
+		wl, err = s.getWaitlistView(ctx, user.Email)
+		if err != nil {
+			return nil, fmt.Errorf("error while getting waitlist entry: %w", err)
+		}
+	}
+
+	prices := payment.CalculateDiscounts(user, s.PriceCache.GetPrices())
+	schedule := s.Env.AccessSchedules.ForTier(user.Tier())
+	viewData := newProfileViewData(user, prices, balance, wl, schedule)
+	viewData["locked"] = s.Env.LockedFields.For(user)
+	viewData["doorPIN"] = s.Env.DoorPINKey != ""
+	viewData["proofLetter"] = s.Env.EntitlementsSigningKey != "" && user.BuildingAccessApprover != "" // the handler checks for an active membership
+	if s.Env.OccupancyWindow > 0 {
+		// Also nice to have
+		if o, err := s.getOccupancy(ctx); err != nil {
+			log.Printf("error while estimating occupancy: %s", err)
+		} else {
+			viewData["occupancy"] = o
+		}
 	}
+	return viewData, nil
 }
 
-func renderProfile(w io.Writer, user *datamodel.User, prices []*datamodel.PriceDetails) error {
+// addonView is an optional product that can be added to a new subscription.
+type addonView struct {
+	Key, Name       string
+	Monthly, Yearly float64 // zero if the product has no price for the interval
+}
+
+func newProfileViewData(user *datamodel.User, prices []*datamodel.PriceDetails, balance int64, waitlist *waitlistView, schedule *datamodel.Schedule) map[string]any {
+	memberships := []*datamodel.PriceDetails{}
+	addons := []*addonView{}
+	addonsByKey := map[string]*addonView{}
+	for _, price := range prices {
+		if price.Product == datamodel.ProductMembership {
+			memberships = append(memberships, price)
+			continue
+		}
+		addon := addonsByKey[price.Product]
+		if addon == nil {
+			addon = &addonView{Key: price.Product, Name: price.ProductName}
+			addonsByKey[price.Product] = addon
+			addons = append(addons, addon)
+		}
+		if price.Annual {
+			addon.Yearly = price.Price
+		} else {
+			addon.Monthly = price.Price
+		}
+	}
+
 	viewData := map[string]any{
 		"page":            "profile",
 		"user":            user,
-		"prices":          prices,
+		"prices":          memberships,
+		"addons":          addons,
+		"waitlist":        waitlist,
+		"accessSchedule":  schedule,
 		"migratedAccount": user.PaypalMetadata.TimeRFC3339.After(time.Time{}),
 	}
 	if user.StripeCancelationTime.After(time.Unix(0, 0)) {
 		viewData["expiration"] = user.StripeCancelationTime.Format("01/02/06")
 	}
 
-	return profile.Templates.ExecuteTemplate(w, "profile.html", viewData)
+	// Stripe represents credits as a negative balance
+	if balance < 0 {
+		viewData["credit"] = float64(-balance) / 100
+	} else if balance > 0 {
+		viewData["amountDue"] = float64(balance) / 100
+	}
+
+	return viewData
 }
 
 func (s *Server) newFobQRHandler() http.HandlerFunc {
@@ -55,9 +136,7 @@ func (s *Server) newFobQRHandler() http.HandlerFunc {
 			renderSystemError(w, "error while getting user: %s", err)
 			return
 		}
-		url := fmt.Sprintf("%s/admin/assign-fob?email=%s", s.Env.SelfURL, user.Email)
-
-		png, err := qrcode.Encode(url, qrcode.Medium, 512)
+		png, err := qrcode.Encode(urls.AssignFob(s.Env, user.Email), qrcode.Medium, 512)
 		if err != nil {
 			renderSystemError(w, "generating QR code: %s", err)
 			return
@@ -69,27 +148,106 @@ func (s *Server) newFobQRHandler() http.HandlerFunc {
 }
 
 func (s *Server) newDiscordLinkHandler() http.HandlerFunc {
+	nonces := newSharedNonceSet("discord-link")
 	return func(w http.ResponseWriter, r *http.Request) {
-		discordUserID := r.URL.Query().Get("user")
-		sig := chatbot.GenerateHMAC(discordUserID, s.Env.DiscordBotToken)
-		if r.URL.Query().Get("sig") != sig {
+		// Params are in the query string for the initial visit and the form body when confirming a re-link
+		discordUserID := r.FormValue("user")
+		nonce := r.FormValue("nonce")
+		ts, _ := strconv.ParseInt(r.FormValue("ts"), 10, 0)
+		sig := chatbot.SignLink(discordUserID, ts, nonce, s.Env.DiscordBotToken)
+		if !hmac.Equal([]byte(r.FormValue("sig")), []byte(sig)) {
 			http.Error(w, "invalid signature", 400)
 			return
 		}
+		expiration := time.Unix(ts, 0).Add(s.Env.DiscordLinkTTL)
+		if nonce == "" || time.Now().After(expiration) {
+			http.Error(w, "this link has expired - run /link in Discord again", 400)
+			return
+		}
+		newID, err := strconv.ParseInt(discordUserID, 10, 0)
+		if err != nil {
+			http.Error(w, "invalid discord user", 400)
+			return
+		}
 
 		user, err := s.Keycloak.GetUser(r.Context(), getUserID(r))
 		if err != nil {
 			renderSystemError(w, "error while getting user: %s", err)
 			return
 		}
+		if user.DiscordUserID == newID {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return // already linked
+		}
 
-		user.DiscordUserID, _ = strconv.ParseInt(discordUserID, 10, 0)
-		err = s.Keycloak.WriteUser(r.Context(), user)
+		// Discord accounts can only be linked to one profile. Whoever can run /link from the Discord account is
+		// allowed to take it over, since the other profile may be an old or duplicate account.
+		holders, err := s.Keycloak.ListUsersByAttribute(r.Context(), "discordUserID", strconv.FormatInt(newID, 10))
+		if err != nil {
+			renderSystemError(w, "error while checking for other linked profiles: %s", err)
+			return
+		}
+		others := []*datamodel.User{}
+		for _, holder := range holders {
+			if holder.UUID != user.UUID {
+				others = append(others, holder)
+			}
+		}
+
+		// Links are only followed by a confirming POST, since link previews and prefetchers would otherwise use them up.
+		// It also makes sure the member actually meant to replace their linked Discord account, or take it from another profile.
+		if r.Method != http.MethodPost {
+			render(w, r, "discord-relink.html", map[string]any{
+				"page":     "profile",
+				"user":     discordUserID,
+				"ts":       ts,
+				"nonce":    nonce,
+				"sig":      sig,
+				"relink":   user.DiscordUserID != 0,
+				"takeover": len(others) > 0,
+			})
+			return
+		}
+
+		ok, err := nonces.Use(r.Context(), nonce, expiration)
+		if err != nil {
+			renderSystemError(w, "error while checking link: %s", err)
+			return
+		}
+		if !ok {
+			http.Error(w, "this link has already been used - run /link in Discord again", 400)
+			return
+		}
+
+		// Unlink the other profiles first so the Discord sync never sees this profile as a duplicate
+		for _, other := range others {
+			err = s.Keycloak.PatchUserAttributes(r.Context(), other.UUID, map[string]string{"discordUserID": ""})
+			if err != nil {
+				renderSystemError(w, "error while unlinking other profile: %s", err)
+				return
+			}
+			reporting.DefaultSink.Eventf(other.Email, "DiscordUnlinked", "discord account %s was taken over by %s", discordUserID, user.Email)
+			reporting.DefaultSink.Eventf(user.Email, "DiscordTakenOver", "member took over discord account %s from %s", discordUserID, other.Email)
+		}
+
+		// The replaced account is recorded so profile-async removes its roles when the webhook for this change arrives
+		prevID := user.DiscordUserID
+		user.DiscordUserID = newID
+		attrs := map[string]string{"discordUserID": strconv.FormatInt(newID, 10)}
+		if prevID != 0 {
+			attrs["previousDiscordUserID"] = strconv.FormatInt(prevID, 10)
+		}
+		err = s.Keycloak.PatchUserAttributes(r.Context(), user.UUID, attrs)
 		if err != nil {
 			renderSystemError(w, "error while updating user: %s", err)
 			return
 		}
-		reporting.DefaultSink.Eventf(user.Email, "DiscordLinked", "member linked discord account %s", discordUserID)
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+
+		if prevID == 0 {
+			reporting.DefaultSink.Eventf(user.Email, "DiscordLinked", "member linked discord account %s", discordUserID)
+		} else {
+			reporting.DefaultSink.Eventf(user.Email, "DiscordRelinked", "member changed their linked discord account from %d to %s", prevID, discordUserID)
+		}
+		http.Redirect(w, r, "/", http.StatusSeeOther)
 	}
 }
@@ -2,12 +2,24 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/TheLab-ms/profile"
+	"github.com/TheLab-ms/profile/internal/chatbot"
+	"github.com/TheLab-ms/profile/internal/conf"
 	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/keycloak/keycloaktest"
+	"github.com/TheLab-ms/profile/internal/reporting"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -16,9 +28,11 @@ import (
 
 func TestRenderProfile(t *testing.T) {
 	tests := []struct {
-		Name    string
-		Fixture string
-		User    *datamodel.User
+		Name     string
+		Fixture  string
+		User     *datamodel.User
+		Balance  int64
+		Waitlist *waitlistView
 	}{
 		{
 			Name:    "basic stripe member",
@@ -47,6 +61,53 @@ func TestRenderProfile(t *testing.T) {
 				Email:                  "developers@microsoft.com",
 			},
 		},
+		{
+			Name:    "waitlisted",
+			Fixture: "waitlist.html",
+			User: &datamodel.User{
+				First:                  "Steve",
+				Last:                   "Ballmer",
+				FobID:                  666,
+				BuildingAccessApprover: "Bill Gates",
+				EmailVerified:          true,
+				WaiverState:            "Signed",
+				Email:                  "developers@microsoft.com",
+			},
+			Waitlist: &waitlistView{Full: true, Position: 3},
+		},
+		{
+			Name:    "stripe member with credit",
+			Fixture: "credit.html",
+			User: &datamodel.User{
+				First:                  "Steve",
+				Last:                   "Ballmer",
+				FobID:                  666,
+				BuildingAccessApprover: "Bill Gates",
+				EmailVerified:          true,
+				WaiverState:            "Signed",
+				Email:                  "developers@microsoft.com",
+				StripeCustomerID:       "foo",
+				StripeSubscriptionID:   "bar",
+			},
+			Balance: -1250,
+		},
+		{
+			Name:    "stripe member renting a locker",
+			Fixture: "locker.html",
+			User: &datamodel.User{
+				First:                  "Steve",
+				Last:                   "Ballmer",
+				FobID:                  666,
+				BuildingAccessApprover: "Bill Gates",
+				EmailVerified:          true,
+				WaiverState:            "Signed",
+				Email:                  "developers@microsoft.com",
+				StripeCustomerID:       "foo",
+				StripeSubscriptionID:   "bar",
+				LockerNumber:           "12",
+				StripeLockerItemID:     "si_foo",
+			},
+		},
 		{
 			Name:    "canceled stripe member",
 			Fixture: "canceled.html",
@@ -115,9 +176,12 @@ func TestRenderProfile(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.Name, func(t *testing.T) {
-			prices := []*datamodel.PriceDetails{{ID: "foo", Price: 1000}}
+			prices := []*datamodel.PriceDetails{
+				{ID: "foo", Product: datamodel.ProductMembership, Price: 1000},
+				{ID: "bar", Product: "storage", ProductName: "Storage", Price: 10},
+			}
 			buf := &bytes.Buffer{}
-			err := renderProfile(buf, test.User, prices)
+			err := profile.Templates.ExecuteTemplate(buf, "profile.html", newProfileViewData(test.User, prices, test.Balance, test.Waitlist, &datamodel.Schedule{OpenHour: 8, CloseHour: 22}))
 			require.NoError(t, err)
 
 			fp := filepath.Join("fixtures", test.Fixture)
@@ -133,3 +197,144 @@ func TestRenderProfile(t *testing.T) {
 		})
 	}
 }
+
+func TestDiscordLinkTakeover(t *testing.T) {
+	kcFake := keycloaktest.NewFake("members")
+	kcFake.AddUser(&gocloak.User{ID: gocloak.StringP("old"), Email: gocloak.StringP("old@example.com"), Attributes: &map[string][]string{"discordUserID": {"1234"}}}, false)
+	kcFake.AddUser(&gocloak.User{ID: gocloak.StringP("new"), Email: gocloak.StringP("new@example.com")}, true)
+	kcServer := httptest.NewServer(kcFake)
+	t.Cleanup(kcServer.Close)
+
+	env := &conf.Env{
+		KeycloakURL:            kcServer.URL,
+		KeycloakRealm:          "master",
+		KeycloakMembersGroupID: "members",
+		KeycloakClientID:       "test",
+		KeycloakClientSecret:   "test",
+		DiscordBotToken:        "bot-token",
+		DiscordLinkTTL:         time.Minute,
+	}
+	kc := keycloak.New[*datamodel.User](env)
+	kc.Sink = reporting.DefaultSink // disabled, but nil-safe
+	s := &Server{Env: env, Keycloak: kc}
+	handler := s.newDiscordLinkHandler()
+
+	ts := time.Now().Unix()
+	form := url.Values{"user": {"1234"}, "ts": {strconv.FormatInt(ts, 10)}, "nonce": {"nonce"}, "sig": {chatbot.SignLink("1234", ts, "nonce", "bot-token")}}
+	send := func(method string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/link-discord?"+form.Encode(), strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Forwarded-Preferred-Username", "new")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+	getUser := func(id string) *datamodel.User {
+		user, err := kc.GetUser(context.Background(), id)
+		require.NoError(t, err)
+		return user
+	}
+
+	// Visiting the link asks for confirmation before taking over the account
+	w := send("GET")
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), "already linked to another profile")
+	assert.Equal(t, int64(1234), getUser("old").DiscordUserID)
+	assert.Equal(t, int64(0), getUser("new").DiscordUserID)
+
+	// Confirming moves the link
+	w = send("POST")
+	assert.Equal(t, 303, w.Code)
+	assert.Equal(t, int64(0), getUser("old").DiscordUserID)
+	assert.Equal(t, int64(1234), getUser("new").DiscordUserID)
+
+	holders, err := kc.ListUsersByAttribute(context.Background(), "discordUserID", "1234")
+	require.NoError(t, err)
+	assert.Len(t, holders, 1)
+}
+
+func TestDiscordRelink(t *testing.T) {
+	kcFake := keycloaktest.NewFake("members")
+	kcFake.AddUser(&gocloak.User{ID: gocloak.StringP("member"), Email: gocloak.StringP("member@example.com"), Attributes: &map[string][]string{"discordUserID": {"99"}}}, true)
+	kcServer := httptest.NewServer(kcFake)
+	t.Cleanup(kcServer.Close)
+
+	env := &conf.Env{
+		KeycloakURL:            kcServer.URL,
+		KeycloakRealm:          "master",
+		KeycloakMembersGroupID: "members",
+		KeycloakClientID:       "test",
+		KeycloakClientSecret:   "test",
+		DiscordBotToken:        "bot-token",
+		DiscordLinkTTL:         time.Minute,
+	}
+	kc := keycloak.New[*datamodel.User](env)
+	kc.Sink = reporting.DefaultSink // disabled, but nil-safe
+	s := &Server{Env: env, Keycloak: kc}
+
+	ts := time.Now().Unix()
+	form := url.Values{"user": {"1234"}, "ts": {strconv.FormatInt(ts, 10)}, "nonce": {"nonce"}, "sig": {chatbot.SignLink("1234", ts, "nonce", "bot-token")}}
+	req := httptest.NewRequest("POST", "/link-discord", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Forwarded-Preferred-Username", "member")
+	w := httptest.NewRecorder()
+	s.newDiscordLinkHandler()(w, req)
+	assert.Equal(t, 303, w.Code)
+
+	// The replaced account is kept so its roles can be removed
+	user, err := kc.GetUser(context.Background(), "member")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1234), user.DiscordUserID)
+	assert.Equal(t, int64(99), user.PreviousDiscordUserID)
+}
+
+func TestDiscordLinkConfirmation(t *testing.T) {
+	kcFake := keycloaktest.NewFake("members")
+	kcFake.AddUser(&gocloak.User{ID: gocloak.StringP("member"), Email: gocloak.StringP("member@example.com")}, true)
+	kcServer := httptest.NewServer(kcFake)
+	t.Cleanup(kcServer.Close)
+
+	env := &conf.Env{
+		KeycloakURL:            kcServer.URL,
+		KeycloakRealm:          "master",
+		KeycloakMembersGroupID: "members",
+		KeycloakClientID:       "test",
+		KeycloakClientSecret:   "test",
+		DiscordBotToken:        "bot-token",
+		DiscordLinkTTL:         time.Minute,
+	}
+	kc := keycloak.New[*datamodel.User](env)
+	kc.Sink = reporting.DefaultSink // disabled, but nil-safe
+	s := &Server{Env: env, Keycloak: kc}
+	handler := s.newDiscordLinkHandler()
+
+	ts := time.Now().Unix()
+	form := url.Values{"user": {"1234"}, "ts": {strconv.FormatInt(ts, 10)}, "nonce": {"nonce"}, "sig": {chatbot.SignLink("1234", ts, "nonce", "bot-token")}}
+	send := func(method string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/link-discord?"+form.Encode(), strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Forwarded-Preferred-Username", "member")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	// Visiting the link (e.g. by a link preview) changes nothing
+	for i := 0; i < 2; i++ {
+		w := send("GET")
+		assert.Equal(t, 200, w.Code)
+		assert.Contains(t, w.Body.String(), "Link Discord")
+	}
+	user, err := kc.GetUser(context.Background(), "member")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), user.DiscordUserID)
+
+	// Confirming links the account, once
+	assert.Equal(t, 303, send("POST").Code)
+	user, err = kc.GetUser(context.Background(), "member")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1234), user.DiscordUserID)
+
+	require.NoError(t, kc.PatchUserAttributes(context.Background(), "member", map[string]string{"discordUserID": ""}))
+	assert.Equal(t, 400, send("POST").Code)
+}
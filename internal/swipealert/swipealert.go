@@ -0,0 +1,62 @@
+// Package swipealert finds suspicious door swipes for leadership to review.
+package swipealert
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/TheLab-ms/profile/internal/conf"
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+// Anomaly is a swipe that leadership should probably look into.
+type Anomaly struct {
+	Swipe  *reporting.Swipe
+	User   *datamodel.User // nil if the fob isn't assigned to anyone
+	Reason string
+}
+
+// Detector holds the rules used to find anomalies.
+type Detector struct {
+	// Members are expected within their tier's schedule in Location. Tiers without a schedule aren't checked.
+	Location  *time.Location
+	Schedules conf.AccessSchedules
+
+	// More than MaxPerHour swipes of the same fob within an hour is considered excessive.
+	MaxPerHour int
+}
+
+// Detect returns anomalies in the given swipes, which must be ordered oldest first.
+// users maps fob IDs to the member they're assigned to.
+func (d *Detector) Detect(swipes []*reporting.Swipe, users map[int]*datamodel.User) []*Anomaly {
+	anomalies := []*Anomaly{}
+	recent := map[int][]time.Time{} // fob ID -> swipe times within the last hour
+	flagged := map[int]bool{}       // fobs already reported for excessive swipes
+
+	for _, swipe := range swipes {
+		user := users[swipe.FobID]
+		if user == nil {
+			anomalies = append(anomalies, &Anomaly{Swipe: swipe, Reason: fmt.Sprintf("swipe from unassigned fob %d", swipe.FobID)})
+			continue
+		}
+
+		// Only tiers with their own schedule are checked, so premium members aren't held to the standard schedule
+		if schedule, ok := d.Schedules[user.Tier()]; ok && !schedule.AllowedAt(swipe.Time.In(d.Location)) {
+			anomalies = append(anomalies, &Anomaly{Swipe: swipe, User: user, Reason: fmt.Sprintf("after-hours entry (%s access)", schedule)})
+		}
+
+		times := recent[swipe.FobID]
+		for len(times) > 0 && swipe.Time.Sub(times[0]) >= time.Hour {
+			times = times[1:]
+		}
+		times = append(times, swipe.Time)
+		recent[swipe.FobID] = times
+		if d.MaxPerHour > 0 && len(times) > d.MaxPerHour && !flagged[swipe.FobID] {
+			flagged[swipe.FobID] = true
+			anomalies = append(anomalies, &Anomaly{Swipe: swipe, User: user, Reason: fmt.Sprintf("more than %d swipes within an hour", d.MaxPerHour)})
+		}
+	}
+
+	return anomalies
+}
@@ -0,0 +1,62 @@
+package swipealert
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TheLab-ms/profile/internal/conf"
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+func TestDetect(t *testing.T) {
+	loc, err := time.LoadLocation("America/Chicago")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedules := conf.AccessSchedules{}
+	if err := schedules.Decode("standard:8-22,premium:0-24"); err != nil {
+		t.Fatal(err)
+	}
+	d := &Detector{Location: loc, Schedules: schedules, MaxPerHour: 3}
+
+	users := map[int]*datamodel.User{
+		1: {Email: "standard@example.com"},
+		2: {Email: "premium@example.com", MembershipTier: datamodel.TierPremium},
+	}
+	noon := time.Date(2024, 3, 1, 12, 0, 0, 0, loc)
+	night := time.Date(2024, 3, 1, 23, 30, 0, 0, loc)
+
+	swipes := []*reporting.Swipe{
+		{ID: 1, Time: noon, FobID: 1},
+		{ID: 2, Time: noon, FobID: 3},                                     // unassigned
+		{ID: 3, Time: noon.Add(time.Minute), FobID: 2},                    // 1
+		{ID: 4, Time: noon.Add(time.Minute * 2), FobID: 2},                // 2
+		{ID: 5, Time: noon.Add(time.Minute * 3), FobID: 2},                // 3
+		{ID: 6, Time: noon.Add(time.Minute * 4), FobID: 2},                // 4 - excessive
+		{ID: 7, Time: noon.Add(time.Minute * 5), FobID: 2},                // only reported once
+		{ID: 8, Time: noon.Add(time.Hour * 2), FobID: 1},                  // fine
+		{ID: 9, Time: night, FobID: 1},                                    // after hours
+		{ID: 10, Time: night, FobID: 2},                                   // premium members have 24/7 access
+		{ID: 11, Time: night.Add(time.Hour * 8), FobID: 1},                // 7:30am is still after hours
+		{ID: 12, Time: night.Add(time.Hour*8 + time.Minute*30), FobID: 1}, // 8am is fine
+	}
+
+	anomalies := d.Detect(swipes, users)
+	ids := []int64{}
+	for _, a := range anomalies {
+		ids = append(ids, a.Swipe.ID)
+	}
+	assert.Equal(t, []int64{2, 6, 9, 11}, ids)
+	assert.Nil(t, anomalies[0].User)
+	assert.Equal(t, "premium@example.com", anomalies[1].User.Email)
+
+	// Tiers without their own schedule aren't held to the standard one
+	d.Schedules = conf.AccessSchedules{"standard": schedules["standard"]}
+	anomalies = d.Detect([]*reporting.Swipe{{ID: 1, Time: night, FobID: 1}, {ID: 2, Time: night, FobID: 2}}, users)
+	if assert.Len(t, anomalies, 1) {
+		assert.Equal(t, int64(1), anomalies[0].Swipe.ID)
+	}
+}
@@ -0,0 +1,82 @@
+// Package telemetry exposes metrics the same way from each of the long-running binaries.
+// The jobs exit too quickly to be scraped, so they report through logs and the reporting database instead.
+package telemetry
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Addr is where the internal mux is served. It's kept off of the public listener so metrics aren't exposed through the ingress.
+const Addr = ":8081"
+
+// Set by the Dockerfiles with -ldflags "-X github.com/TheLab-ms/profile/internal/telemetry.revision=<sha> -X ...buildTime=<RFC3339>".
+// The revision falls back to the VCS information embedded by go build.
+var revision, buildTime string
+
+var buildInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "profile_build_info",
+	Help: "Always 1, labeled with the binary and the build it's running.",
+}, []string{"binary", "revision", "build_time", "go_version"})
+
+// Version describes the build of the running binary.
+type Version struct {
+	Binary    string `json:"binary"`
+	Revision  string `json:"revision"`
+	BuildTime string `json:"buildTime,omitempty"`
+	GoVersion string `json:"goVersion"`
+}
+
+func CurrentVersion(binary string) *Version {
+	return &Version{Binary: binary, Revision: Revision(), BuildTime: buildTime, GoVersion: runtime.Version()}
+}
+
+// RecordBuildInfo sets the build info metric for the binary. It's called by app.Load so every binary reports its build.
+func RecordBuildInfo(binary string) *Version {
+	version := CurrentVersion(binary)
+	buildInfo.WithLabelValues(binary, version.Revision, version.BuildTime, version.GoVersion).Set(1)
+	return version
+}
+
+// NewMux returns the internal mux for the given binary with metrics, version, and a health check already registered.
+// Binaries can add their own internal endpoints e.g. profile-async's webhooks.
+func NewMux(binary string) *http.ServeMux {
+	version := RecordBuildInfo(binary)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(204) })
+	mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(version)
+	})
+	return mux
+}
+
+// ListenAndServe serves the internal mux on Addr.
+func ListenAndServe(mux *http.ServeMux) error {
+	return http.ListenAndServe(Addr, mux)
+}
+
+// Revision returns the commit the binary was built from, or "unknown" if it wasn't built from a git checkout.
+func Revision() string {
+	if revision != "" {
+		return revision
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+	return "unknown"
+}
@@ -0,0 +1,38 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewMux(t *testing.T) {
+	mux := NewMux("test-binary")
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+	assert.Equal(t, 204, w.Code)
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `profile_build_info{binary="test-binary"`)
+	assert.Contains(t, w.Body.String(), "process_start_time_seconds")
+}
+
+func TestVersion(t *testing.T) {
+	revision, buildTime = "abc123", "2024-01-02T03:04:05Z"
+	defer func() { revision, buildTime = "", "" }()
+
+	w := httptest.NewRecorder()
+	NewMux("test-binary").ServeHTTP(w, httptest.NewRequest("GET", "/version", nil))
+	assert.Equal(t, 200, w.Code)
+
+	version := &Version{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(version))
+	assert.Equal(t, &Version{Binary: "test-binary", Revision: "abc123", BuildTime: "2024-01-02T03:04:05Z", GoVersion: runtime.Version()}, version)
+}
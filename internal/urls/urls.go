@@ -0,0 +1,84 @@
+// Package urls builds the links to the profile app that are handed to members outside of it e.g. in emails, Discord
+// messages, PDFs, and QR codes. Keep every absolute link here so the app's URL structure can change in one place.
+package urls
+
+import (
+	"encoding/base64"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/TheLab-ms/profile/internal/conf"
+)
+
+func link(env *conf.Env, path string, query url.Values) string {
+	str := strings.TrimSuffix(env.SelfURL, "/") + path
+	if len(query) > 0 {
+		str += "?" + query.Encode()
+	}
+	return str
+}
+
+func Profile(env *conf.Env) string { return link(env, "/profile", nil) }
+
+func Signup(env *conf.Env) string { return link(env, "/signup", nil) }
+
+func Calendar(env *conf.Env) string { return link(env, "/calendar", nil) }
+
+func NotificationPreferences(env *conf.Env) string { return link(env, "/profile/notifications", nil) }
+
+// Checkout starts a Stripe checkout session for the given price e.g. "paypal" to match a member's Paypal price.
+func Checkout(env *conf.Env, price string) string {
+	return link(env, "/profile/stripe", url.Values{"price": {price}})
+}
+
+// AssignFob is encoded in the QR code on each member's profile, which leadership scans to assign them a fob.
+func AssignFob(env *conf.Env, email string) string {
+	return link(env, "/admin/assign-fob", url.Values{"email": {email}})
+}
+
+func AdminMember(env *conf.Env, email string) string {
+	return link(env, "/admin/member", url.Values{"email": {email}})
+}
+
+// Secret decrypts a secret encrypted through /secrets/encrypt.
+func Secret(env *conf.Env, ciphertext []byte) string {
+	return link(env, "/secrets", url.Values{"c": {base64.RawURLEncoding.EncodeToString(ciphertext)}})
+}
+
+// DiscordLink links the Discord user to whoever opens it (see chatbot.SignLink).
+func DiscordLink(env *conf.Env, discordUserID string, ts int64, nonce, sig string) string {
+	return link(env, "/link-discord", url.Values{
+		"user":  {discordUserID},
+		"ts":    {strconv.FormatInt(ts, 10)},
+		"nonce": {nonce},
+		"sig":   {sig},
+	})
+}
+
+// MagicLink logs the member in, optionally sending them to the (already validated) return URL afterwards.
+func MagicLink(env *conf.Env, token, returnTo string) string {
+	query := url.Values{"t": {token}}
+	if returnTo != "" {
+		query.Set("return", returnTo)
+	}
+	return link(env, "/login/verify", query)
+}
+
+// ProofVerification confirms that a proof of membership letter is genuine.
+func ProofVerification(env *conf.Env, token string) string {
+	return link(env, "/verify/"+url.PathEscape(token), nil)
+}
+
+// Guest is encoded in the QR code at the door for guests to sign in.
+func Guest(env *conf.Env) string { return link(env, "/guest", nil) }
+
+// GuestConfirmation is sent to the host of a guest to confirm the visit.
+func GuestConfirmation(env *conf.Env, token string) string {
+	return link(env, "/profile/guests/confirm", url.Values{"t": {token}})
+}
+
+// EventCheckIn is encoded in the QR code projected at events.
+func EventCheckIn(env *conf.Env, token string) string {
+	return link(env, "/events/checkin", url.Values{"t": {token}})
+}
@@ -0,0 +1,23 @@
+package urls
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/TheLab-ms/profile/internal/conf"
+)
+
+func TestLinks(t *testing.T) {
+	env := &conf.Env{SelfURL: "https://profile.example.com/"}
+
+	assert.Equal(t, "https://profile.example.com/profile", Profile(env))
+	assert.Equal(t, "https://profile.example.com/profile/stripe?price=paypal", Checkout(env, "paypal"))
+	assert.Equal(t, "https://profile.example.com/admin/assign-fob?email=a%2Bb%40example.com", AssignFob(env, "a+b@example.com"))
+	assert.Equal(t, "https://profile.example.com/secrets?c=AQID", Secret(env, []byte{1, 2, 3}))
+	assert.Equal(t, "https://profile.example.com/link-discord?nonce=abc&sig=def&ts=123&user=456", DiscordLink(env, "456", 123, "abc", "def"))
+	assert.Equal(t, "https://profile.example.com/login/verify?t=tok", MagicLink(env, "tok", ""))
+	assert.Equal(t, "https://profile.example.com/login/verify?return=%2Fcalendar&t=tok", MagicLink(env, "tok", "/calendar"))
+	assert.Equal(t, "https://profile.example.com/verify/a.b", ProofVerification(env, "a.b"))
+	assert.Equal(t, "https://profile.example.com/events/checkin?t=a.b", EventCheckIn(env, "a.b"))
+}
@@ -0,0 +1,88 @@
+// Package waitlist caps the number of active members.
+// Once the cap is reached new members join a waitlist, and leadership releases slots to them at /admin/waitlist.
+package waitlist
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+	"github.com/TheLab-ms/profile/internal/flowcontrol"
+	"github.com/TheLab-ms/profile/internal/keycloak"
+	"github.com/TheLab-ms/profile/internal/reporting"
+)
+
+// Gate decides whether new members can start a subscription.
+// A nil *Gate, or one with a cap of zero, admits everyone.
+type Gate struct {
+	flowcontrol.Loop
+	Cap  int
+	kc   *keycloak.Keycloak[*datamodel.User]
+	sink *reporting.ReportingSink
+
+	mut    sync.Mutex
+	active int
+}
+
+func NewGate(kc *keycloak.Keycloak[*datamodel.User], sink *reporting.ReportingSink, cap int, interval time.Duration) *Gate {
+	g := &Gate{Cap: cap, kc: kc, sink: sink}
+	g.Loop.Handler = flowcontrol.RetryHandler(interval, g.count)
+	return g
+}
+
+// NewStaticGate returns a gate with a fixed member count that never polls Keycloak.
+// Useful for local development.
+func NewStaticGate(sink *reporting.ReportingSink, cap, active int) *Gate {
+	return &Gate{Cap: cap, sink: sink, active: active}
+}
+
+func (g *Gate) count(ctx context.Context) bool {
+	users, err := g.kc.ListUsers(ctx)
+	if err != nil {
+		log.Printf("error while listing users to count active members: %s", err)
+		return false
+	}
+
+	active := 0
+	for _, user := range users {
+		if user.ActiveMember {
+			active++
+		}
+	}
+
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	g.active = active
+	return true
+}
+
+// Full returns true when the active member count has reached the cap.
+func (g *Gate) Full() bool {
+	if g == nil || g.Cap <= 0 {
+		return false
+	}
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	return g.active >= g.Cap
+}
+
+// Admitted returns true if the given person can start a subscription i.e. the cap hasn't been reached or they've been invited off the waitlist.
+func (g *Gate) Admitted(ctx context.Context, email string) (bool, error) {
+	if !g.Full() {
+		return true, nil
+	}
+	entry, _, err := g.sink.GetWaitlistEntry(ctx, email)
+	if err != nil {
+		return false, err
+	}
+	return entry.Invited(time.Now()), nil
+}
+
+// Kick schedules the member count to be refreshed e.g. after someone's membership changes.
+func (g *Gate) Kick() {
+	if g != nil {
+		g.Loop.Kick()
+	}
+}
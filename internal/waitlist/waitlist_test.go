@@ -0,0 +1,26 @@
+package waitlist
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGate(t *testing.T) {
+	var nilGate *Gate
+	assert.False(t, nilGate.Full())
+	assert.False(t, NewStaticGate(nil, 0, 100).Full(), "zero cap disables the gate")
+	assert.False(t, NewStaticGate(nil, 10, 9).Full())
+	assert.True(t, NewStaticGate(nil, 10, 10).Full())
+
+	ok, err := NewStaticGate(nil, 10, 9).Admitted(context.Background(), "foo@example.com")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// Nobody is invited without a waitlist database
+	ok, err = NewStaticGate(nil, 10, 10).Admitted(context.Background(), "foo@example.com")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
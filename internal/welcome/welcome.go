@@ -0,0 +1,80 @@
+// Package welcome walks new members through a series of timed onboarding steps.
+package welcome
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+)
+
+var stepResults = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "welcome_sequence_steps_total",
+	Help: "Count of welcome sequence steps by step name and result. Compare completions across steps to see funnel drop-off.",
+}, []string{"step", "result"})
+
+// Step is a single stage of the welcome sequence.
+type Step struct {
+	Name string
+
+	// After is how long after signup the step should run.
+	// Window is how long the step remains eligible after that, so members we learn about late don't get stale messages.
+	After, Window time.Duration
+
+	// Run performs the step. It should be safe to call more than once for the same member.
+	Run func(ctx context.Context, user *datamodel.User) error
+}
+
+// Sequence is an ordered set of steps. Steps are completed in order: a step won't run until the previous one has completed or expired.
+type Sequence struct {
+	Steps []*Step
+}
+
+// Next returns the step that should currently be run for the user, or nil if nothing is due.
+func (s *Sequence) Next(user *datamodel.User, now time.Time) *Step {
+	if user.SignupTime.IsZero() {
+		return nil
+	}
+	for _, step := range s.Steps {
+		if _, ok := user.WelcomeSteps[step.Name]; ok {
+			continue
+		}
+		start := user.SignupTime.Add(step.After)
+		if now.Before(start) {
+			return nil // wait for this one
+		}
+		if now.After(start.Add(step.Window)) {
+			continue // missed it
+		}
+		return step
+	}
+	return nil
+}
+
+// Advance runs every step that is currently due for the user, recording their completion on the user object.
+// The caller is responsible for writing the user back when the returned bool is true.
+func (s *Sequence) Advance(ctx context.Context, user *datamodel.User, now time.Time) (bool, error) {
+	var changed bool
+	for {
+		step := s.Next(user, now)
+		if step == nil {
+			return changed, nil
+		}
+
+		if err := step.Run(ctx, user); err != nil {
+			stepResults.WithLabelValues(step.Name, "failed").Inc()
+			return changed, fmt.Errorf("running welcome step %q: %w", step.Name, err)
+		}
+		stepResults.WithLabelValues(step.Name, "completed").Inc()
+
+		if user.WelcomeSteps == nil {
+			user.WelcomeSteps = map[string]time.Time{}
+		}
+		user.WelcomeSteps[step.Name] = now
+		changed = true
+	}
+}
@@ -0,0 +1,70 @@
+package welcome
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TheLab-ms/profile/internal/datamodel"
+)
+
+func TestSequence(t *testing.T) {
+	ctx := context.Background()
+	signup := time.Unix(1000000, 0)
+	runs := []string{}
+	var failStep string
+	step := func(name string, after time.Duration) *Step {
+		return &Step{Name: name, After: after, Window: time.Hour * 24, Run: func(ctx context.Context, user *datamodel.User) error {
+			if name == failStep {
+				return errors.New("oh no")
+			}
+			runs = append(runs, name)
+			return nil
+		}}
+	}
+	seq := &Sequence{Steps: []*Step{step("first", 0), step("second", time.Hour*72), step("third", time.Hour*24*7)}}
+	user := &datamodel.User{SignupTime: signup}
+
+	// First step runs immediately
+	changed, err := seq.Advance(ctx, user, signup.Add(time.Minute))
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, []string{"first"}, runs)
+
+	// Nothing is due yet
+	changed, err = seq.Advance(ctx, user, signup.Add(time.Hour*24))
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	// Failures are retried without losing earlier progress
+	failStep = "second"
+	changed, err = seq.Advance(ctx, user, signup.Add(time.Hour*73))
+	assert.Error(t, err)
+	assert.False(t, changed)
+	failStep = ""
+
+	// Missed steps are skipped
+	changed, err = seq.Advance(ctx, user, signup.Add(time.Hour*24*7+time.Hour))
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, []string{"first", "third"}, runs)
+	assert.Len(t, user.WelcomeSteps, 2)
+
+	// Done
+	assert.Nil(t, seq.Next(user, signup.Add(time.Hour*24*30)))
+}
+
+func TestSequenceLateDiscovery(t *testing.T) {
+	seq := &Sequence{Steps: []*Step{{Name: "first", Window: time.Hour}}}
+
+	// Members that existed before the sequence or were discovered late don't get stale messages
+	user := &datamodel.User{SignupTime: time.Unix(1000000, 0)}
+	assert.Nil(t, seq.Next(user, user.SignupTime.Add(time.Hour*2)))
+
+	// Members without a signup time are ignored
+	assert.Nil(t, seq.Next(&datamodel.User{}, time.Now()))
+}